@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+
+	"LearnSingleTableDesign/models"
+)
+
+// memoryStore is an ObjectStore keeping objects in a map
+type memoryStore map[string][]byte
+
+func (m memoryStore) Put(ctx context.Context, bucket, key, contentType string, body []byte) error {
+	m[bucket+"/"+key] = body
+	return nil
+}
+
+func s(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+
+// removal is the stream record of an order being deleted
+func removal(orderID string, byTTL bool) types.Record {
+	record := types.Record{
+		EventID:   aws.String("event-" + orderID),
+		EventName: types.OperationTypeRemove,
+		Dynamodb: &types.StreamRecord{
+			SequenceNumber: aws.String(orderID),
+			OldImage: map[string]types.AttributeValue{
+				"PK":          s("USER#a@example.com"),
+				"SK":          s("ORDER#" + orderID),
+				"entity_type": s("ORDER"),
+				"data": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+					"order_id":   s(orderID),
+					"user_email": s("a@example.com"),
+					"status":     s(string(models.OrderStatusCompleted)),
+					"total":      &types.AttributeValueMemberN{Value: "10"},
+					"created_at": s("2024-03-05T10:00:00Z"),
+				}},
+			},
+		},
+	}
+	if byTTL {
+		record.UserIdentity = &types.Identity{Type: aws.String("Service"), PrincipalId: aws.String("dynamodb.amazonaws.com")}
+	}
+	return record
+}
+
+func TestArchiver(t *testing.T) {
+	store := memoryStore{}
+	hook := NewArchiver(store, "archive").Hook()
+
+	if err := hook(context.Background(), removal("ORD1", true)); err != nil {
+		t.Fatalf("Failed to archive order: %v", err)
+	}
+	body, ok := store["archive/orders/2024/03/05/ORD1.json"]
+	if !ok {
+		t.Fatalf("Order wasn't archived, store holds %v", store)
+	}
+	var order models.Order
+	if err := json.Unmarshal(body, &order); err != nil {
+		t.Fatalf("Failed to decode archived order: %v", err)
+	}
+	if order.OrderID != "ORD1" || order.Total != 10 {
+		t.Errorf("Archived order = %+v, want ORD1 with total 10", order)
+	}
+
+	// Test deletes made by callers aren't archived
+	if err := hook(context.Background(), removal("ORD2", false)); err != nil {
+		t.Fatalf("Failed to handle removal: %v", err)
+	}
+	if len(store) != 1 {
+		t.Errorf("Store holds %d objects, want only the expired order", len(store))
+	}
+}
+
+// fakeStreams serves a parent and child shard holding the given records
+type fakeStreams struct {
+	records map[string][]types.Record
+	// reads counts GetRecords calls per shard
+	reads map[string]int
+}
+
+func (f *fakeStreams) DescribeStream(ctx context.Context, in *dynamodbstreams.DescribeStreamInput, opts ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &types.StreamDescription{Shards: []types.Shard{
+		{ShardId: aws.String("child"), ParentShardId: aws.String("parent")},
+		{ShardId: aws.String("parent")},
+	}}}, nil
+}
+
+func (f *fakeStreams) GetShardIterator(ctx context.Context, in *dynamodbstreams.GetShardIteratorInput, opts ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	// Iterators are the shard and how many records to skip
+	skip := 0
+	if in.ShardIteratorType == types.ShardIteratorTypeAfterSequenceNumber {
+		for i, record := range f.records[*in.ShardId] {
+			if *record.Dynamodb.SequenceNumber == *in.SequenceNumber {
+				skip = i + 1
+			}
+		}
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("%s/%d", *in.ShardId, skip))}, nil
+}
+
+func (f *fakeStreams) GetRecords(ctx context.Context, in *dynamodbstreams.GetRecordsInput, opts ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	shard, n, _ := strings.Cut(*in.ShardIterator, "/")
+	skip, _ := strconv.Atoi(n)
+	f.reads[shard]++
+	// Both shards are closed, so there is no next iterator
+	return &dynamodbstreams.GetRecordsOutput{Records: f.records[shard][skip:]}, nil
+}
+
+func TestStream(t *testing.T) {
+	streams := &fakeStreams{
+		records: map[string][]types.Record{
+			"parent": {removal("ORD1", true), removal("ORD2", true)},
+			"child":  {removal("ORD3", true)},
+		},
+		reads: map[string]int{},
+	}
+	var handled []string
+	failOnce := "ORD2"
+	hook := func(ctx context.Context, record types.Record) error {
+		id := *record.Dynamodb.SequenceNumber
+		if id == failOnce {
+			failOnce = ""
+			return errors.New("bucket unavailable")
+		}
+		handled = append(handled, id)
+		return nil
+	}
+	stream := NewStream(streams, "arn:stream", hook)
+
+	// Test a failed record stops its shard, and the child waits for it
+	if err := stream.Poll(context.Background()); err == nil {
+		t.Fatal("Expected the hook's error, got nil")
+	}
+	if streams.reads["child"] != 0 {
+		t.Error("Child shard was read before its parent finished")
+	}
+
+	// Test the next polls resume after the last record handled
+	for range 2 {
+		if err := stream.Poll(context.Background()); err != nil {
+			t.Fatalf("Failed to poll stream: %v", err)
+		}
+	}
+	if fmt.Sprint(handled) != "[ORD1 ORD2 ORD3]" {
+		t.Errorf("Handled %v, want each record once, in order", handled)
+	}
+}
@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// ObjectStore is where an Archiver saves orders, e.g. an s3.Client
+type ObjectStore interface {
+	Put(ctx context.Context, bucket, key, contentType string, body []byte) error
+}
+
+// Archiver saves the orders time to live deletes to a bucket
+type Archiver struct {
+	store  ObjectStore
+	bucket string
+}
+
+// NewArchiver creates an Archiver writing to bucket
+func NewArchiver(store ObjectStore, bucket string) *Archiver {
+	return &Archiver{store: store, bucket: bucket}
+}
+
+// Hook saves each order deleted by time to live as JSON under
+// orders/<yyyy>/<mm>/<dd>/<order id>.json, by the day it was placed. The
+// stream must carry old images. Orders deleted any other way, such as by
+// forgetting their user, aren't archived.
+func (a *Archiver) Hook() Hook {
+	return func(ctx context.Context, record types.Record) error {
+		if record.EventName != types.OperationTypeRemove || !expiredByTTL(record) || record.Dynamodb.OldImage == nil {
+			return nil
+		}
+		av, err := attributevalue.FromDynamoDBStreamsMap(record.Dynamodb.OldImage)
+		if err != nil {
+			return fmt.Errorf("failed to convert stream image: %w", err)
+		}
+		raw := repository.RawItem(av)
+		if raw.EntityType() != repository.EntityOrder {
+			return nil
+		}
+		item, err := repository.Decode[models.Order](raw)
+		if err != nil {
+			return err
+		}
+
+		order := item.Data
+		body, err := json.MarshalIndent(order, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal order %s: %w", order.OrderID, err)
+		}
+		return a.store.Put(ctx, a.bucket, Key(order), "application/json", body)
+	}
+}
+
+// Key is where an order is archived in the bucket
+func Key(order models.Order) string {
+	return fmt.Sprintf("orders/%s/%s.json", order.CreatedAt.UTC().Format("2006/01/02"), order.OrderID)
+}
+
+// expiredByTTL reports whether DynamoDB's time to live made a removal,
+// rather than a caller
+func expiredByTTL(record types.Record) bool {
+	identity := record.UserIdentity
+	return identity != nil && aws.ToString(identity.Type) == "Service" &&
+		aws.ToString(identity.PrincipalId) == "dynamodb.amazonaws.com"
+}
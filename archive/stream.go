@@ -0,0 +1,166 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// Hook handles one stream record. A record whose hook fails is read again
+// on the next poll, along with the records after it, so hooks must be
+// idempotent.
+type Hook func(ctx context.Context, record types.Record) error
+
+// Streams is the part of the DynamoDB Streams client a Stream needs
+type Streams interface {
+	DescribeStream(ctx context.Context, in *dynamodbstreams.DescribeStreamInput, opts ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, in *dynamodbstreams.GetShardIteratorInput, opts ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, in *dynamodbstreams.GetRecordsInput, opts ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// Stream reads a table's stream and hands every record to its hooks. It
+// starts from the oldest record the stream keeps, 24 hours' worth, and
+// reads a shard only once its parent is finished, so the records of an
+// item arrive in order. Progress is kept in memory, so a restarted Stream
+// sees the last day's records again.
+type Stream struct {
+	client Streams
+	arn    string
+	hooks  []Hook
+	// PollInterval is how long to wait between reads of the shards
+	PollInterval time.Duration
+	shards       map[string]*shard
+	// order is the shards by discovery, parents before children
+	order []string
+}
+
+// shard is how far a Stream has read one shard
+type shard struct {
+	parent   string
+	iterator *string
+	// last is the sequence number of the last record handled
+	last   string
+	closed bool
+}
+
+// NewStream creates a Stream over the stream with the given ARN
+func NewStream(client Streams, streamARN string, hooks ...Hook) *Stream {
+	return &Stream{
+		client:       client,
+		arn:          streamARN,
+		hooks:        hooks,
+		PollInterval: time.Second,
+		shards:       map[string]*shard{},
+	}
+}
+
+// Run reads the stream until ctx is done
+func (s *Stream) Run(ctx context.Context) {
+	for {
+		if err := s.Poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to read table stream", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.PollInterval):
+		}
+	}
+}
+
+// Poll finds new shards, then reads one batch of records from each shard
+// that is ready
+func (s *Stream) Poll(ctx context.Context) error {
+	if err := s.discover(ctx); err != nil {
+		return err
+	}
+	for _, id := range s.order {
+		sh := s.shards[id]
+		if sh.closed {
+			continue
+		}
+		if parent, ok := s.shards[sh.parent]; ok && !parent.closed {
+			continue
+		}
+		if err := s.read(ctx, id, sh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discover adds the stream's shards that aren't known yet
+func (s *Stream) discover(ctx context.Context) error {
+	var start *string
+	for {
+		out, err := s.client.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(s.arn),
+			ExclusiveStartShardId: start,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe stream: %w", err)
+		}
+		for _, found := range out.StreamDescription.Shards {
+			id := aws.ToString(found.ShardId)
+			if _, ok := s.shards[id]; ok {
+				continue
+			}
+			s.shards[id] = &shard{parent: aws.ToString(found.ParentShardId)}
+			s.order = append(s.order, id)
+		}
+		start = out.StreamDescription.LastEvaluatedShardId
+		if start == nil {
+			return nil
+		}
+	}
+}
+
+// read hands one batch of a shard's records to the hooks
+func (s *Stream) read(ctx context.Context, id string, sh *shard) error {
+	if sh.iterator == nil {
+		in := &dynamodbstreams.GetShardIteratorInput{
+			StreamArn:         aws.String(s.arn),
+			ShardId:           aws.String(id),
+			ShardIteratorType: types.ShardIteratorTypeTrimHorizon,
+		}
+		if sh.last != "" {
+			in.ShardIteratorType = types.ShardIteratorTypeAfterSequenceNumber
+			in.SequenceNumber = aws.String(sh.last)
+		}
+		out, err := s.client.GetShardIterator(ctx, in)
+		if err != nil {
+			return fmt.Errorf("failed to get iterator for shard %s: %w", id, err)
+		}
+		sh.iterator = out.ShardIterator
+	}
+
+	out, err := s.client.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: sh.iterator})
+	var expired *types.ExpiredIteratorException
+	if errors.As(err, &expired) {
+		// Iterators last 15 minutes; get a new one next poll
+		sh.iterator = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read shard %s: %w", id, err)
+	}
+	for _, record := range out.Records {
+		for _, hook := range s.hooks {
+			if err := hook(ctx, record); err != nil {
+				// Start again after the last record handled
+				sh.iterator = nil
+				return fmt.Errorf("failed to handle stream record %s: %w", aws.ToString(record.EventID), err)
+			}
+		}
+		sh.last = aws.ToString(record.Dynamodb.SequenceNumber)
+	}
+	sh.iterator = out.NextShardIterator
+	sh.closed = sh.iterator == nil
+	return nil
+}
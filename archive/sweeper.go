@@ -0,0 +1,70 @@
+// Package archive moves old completed orders out of the table. A Sweeper
+// gives them a time to live; once DynamoDB deletes them, the table's
+// stream carries each one to an Archiver, which saves it to S3 as JSON.
+package archive
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// OrderExpirer is the part of repository.OrderRepository the sweeper needs
+type OrderExpirer interface {
+	ExpireCompletedOrders(ctx context.Context, cutoff time.Time, lookbackDays int, expireAt time.Time) (int, error)
+}
+
+// Sweeper periodically sets a time to live on completed orders older than
+// After. Every app instance can run one, since an order is only expired on
+// condition it hasn't been already.
+type Sweeper struct {
+	orders OrderExpirer
+	// After is how long after being placed a completed order expires
+	After time.Duration
+	// LookbackDays is how many days of orders before the cutoff each pass
+	// reads. Passes run daily, so this only matters for catching up after
+	// archival is turned on or the app was down.
+	LookbackDays int
+	// Interval is how often the sweeper runs
+	Interval time.Duration
+	now      func() time.Time
+}
+
+// NewSweeper creates a Sweeper expiring completed orders after days,
+// checking hourly and looking back a month
+func NewSweeper(orders OrderExpirer, days int) *Sweeper {
+	return &Sweeper{
+		orders:       orders,
+		After:        time.Duration(days) * 24 * time.Hour,
+		LookbackDays: 30,
+		Interval:     time.Hour,
+		now:          time.Now,
+	}
+}
+
+// Run sweeps until ctx is done
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RunOnce(ctx); err != nil {
+			slog.Error("failed to expire completed orders", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce expires the orders that are due, reporting how many. They expire
+// straight away; DynamoDB deletes them within a few days.
+func (s *Sweeper) RunOnce(ctx context.Context) (int, error) {
+	now := s.now()
+	expired, err := s.orders.ExpireCompletedOrders(ctx, now.Add(-s.After), s.LookbackDays, now)
+	if expired > 0 {
+		slog.Info("expired completed orders for archival", "count", expired)
+	}
+	return expired, err
+}
@@ -0,0 +1,193 @@
+// Package backup manages a table's point-in-time recovery and on-demand
+// backups, and restores either to a new table. DynamoDB Local supports
+// none of this, so it only works against AWS.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// API is the part of the DynamoDB client backups use
+type API interface {
+	DescribeContinuousBackups(ctx context.Context, params *dynamodb.DescribeContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeContinuousBackupsOutput, error)
+	UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
+	CreateBackup(ctx context.Context, params *dynamodb.CreateBackupInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateBackupOutput, error)
+	ListBackups(ctx context.Context, params *dynamodb.ListBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ListBackupsOutput, error)
+	RestoreTableFromBackup(ctx context.Context, params *dynamodb.RestoreTableFromBackupInput, optFns ...func(*dynamodb.Options)) (*dynamodb.RestoreTableFromBackupOutput, error)
+	RestoreTableToPointInTime(ctx context.Context, params *dynamodb.RestoreTableToPointInTimeInput, optFns ...func(*dynamodb.Options)) (*dynamodb.RestoreTableToPointInTimeOutput, error)
+}
+
+// Manager backs up one table
+type Manager struct {
+	client    API
+	tableName string
+	now       func() time.Time
+}
+
+// New creates a Manager for the table
+func New(client API, tableName string) *Manager {
+	return &Manager{client: client, tableName: tableName, now: time.Now}
+}
+
+// Backup is an on-demand backup of the table
+type Backup struct {
+	ARN       string
+	Name      string
+	Status    types.BackupStatus
+	Created   time.Time
+	SizeBytes int64
+}
+
+// PITR is the table's point-in-time recovery status. Earliest and Latest
+// bound the times it can be restored to while Enabled.
+type PITR struct {
+	Enabled  bool
+	Earliest time.Time
+	Latest   time.Time
+}
+
+// PITRStatus returns whether point-in-time recovery is on
+func (m *Manager) PITRStatus(ctx context.Context) (PITR, error) {
+	out, err := m.client.DescribeContinuousBackups(ctx, &dynamodb.DescribeContinuousBackupsInput{
+		TableName: aws.String(m.tableName),
+	})
+	if err != nil {
+		return PITR{}, fmt.Errorf("failed to describe continuous backups: %w", err)
+	}
+	desc := out.ContinuousBackupsDescription
+	if desc == nil || desc.PointInTimeRecoveryDescription == nil {
+		return PITR{}, nil
+	}
+	pitr := desc.PointInTimeRecoveryDescription
+	return PITR{
+		Enabled:  pitr.PointInTimeRecoveryStatus == types.PointInTimeRecoveryStatusEnabled,
+		Earliest: aws.ToTime(pitr.EarliestRestorableDateTime),
+		Latest:   aws.ToTime(pitr.LatestRestorableDateTime),
+	}, nil
+}
+
+// EnablePITR turns on point-in-time recovery, which keeps the last 35 days
+// restorable to the second
+func (m *Manager) EnablePITR(ctx context.Context) error {
+	_, err := m.client.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+		TableName: aws.String(m.tableName),
+		PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable point-in-time recovery: %w", err)
+	}
+	return nil
+}
+
+// Create starts an on-demand backup. An empty name defaults to the table
+// name and the time, e.g. AppTable-20240102-150405.
+func (m *Manager) Create(ctx context.Context, name string) (Backup, error) {
+	if name == "" {
+		name = m.tableName + "-" + m.now().UTC().Format("20060102-150405")
+	}
+	out, err := m.client.CreateBackup(ctx, &dynamodb.CreateBackupInput{
+		TableName:  aws.String(m.tableName),
+		BackupName: aws.String(name),
+	})
+	if err != nil {
+		return Backup{}, fmt.Errorf("failed to create backup: %w", err)
+	}
+	details := out.BackupDetails
+	if details == nil {
+		return Backup{Name: name}, nil
+	}
+	return Backup{
+		ARN:       aws.ToString(details.BackupArn),
+		Name:      aws.ToString(details.BackupName),
+		Status:    details.BackupStatus,
+		Created:   aws.ToTime(details.BackupCreationDateTime),
+		SizeBytes: aws.ToInt64(details.BackupSizeBytes),
+	}, nil
+}
+
+// List returns the table's on-demand backups, oldest first
+func (m *Manager) List(ctx context.Context) ([]Backup, error) {
+	input := &dynamodb.ListBackupsInput{
+		TableName:  aws.String(m.tableName),
+		BackupType: types.BackupTypeFilterUser,
+	}
+	var backups []Backup
+	for {
+		out, err := m.client.ListBackups(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backups: %w", err)
+		}
+		for _, summary := range out.BackupSummaries {
+			backups = append(backups, Backup{
+				ARN:       aws.ToString(summary.BackupArn),
+				Name:      aws.ToString(summary.BackupName),
+				Status:    summary.BackupStatus,
+				Created:   aws.ToTime(summary.BackupCreationDateTime),
+				SizeBytes: aws.ToInt64(summary.BackupSizeBytes),
+			})
+		}
+		if out.LastEvaluatedBackupArn == nil {
+			return backups, nil
+		}
+		input.ExclusiveStartBackupArn = out.LastEvaluatedBackupArn
+	}
+}
+
+// Restore creates targetTable from a backup. The restore runs in the
+// background; the new table is usable once it is ACTIVE.
+func (m *Manager) Restore(ctx context.Context, backupARN, targetTable string) error {
+	if err := m.checkTarget(targetTable); err != nil {
+		return err
+	}
+	_, err := m.client.RestoreTableFromBackup(ctx, &dynamodb.RestoreTableFromBackupInput{
+		BackupArn:       aws.String(backupARN),
+		TargetTableName: aws.String(targetTable),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreToTime creates targetTable from the table as it was at a time
+// within the point-in-time recovery window. A zero time restores the
+// latest restorable time.
+func (m *Manager) RestoreToTime(ctx context.Context, targetTable string, at time.Time) error {
+	if err := m.checkTarget(targetTable); err != nil {
+		return err
+	}
+	input := &dynamodb.RestoreTableToPointInTimeInput{
+		SourceTableName: aws.String(m.tableName),
+		TargetTableName: aws.String(targetTable),
+	}
+	if at.IsZero() {
+		input.UseLatestRestorableTime = aws.Bool(true)
+	} else {
+		input.RestoreDateTime = aws.Time(at)
+	}
+	if _, err := m.client.RestoreTableToPointInTime(ctx, input); err != nil {
+		return fmt.Errorf("failed to restore to point in time: %w", err)
+	}
+	return nil
+}
+
+// checkTarget refuses to restore over the table itself. DynamoDB would
+// refuse too, but only once it exists.
+func (m *Manager) checkTarget(targetTable string) error {
+	if targetTable == "" {
+		return errors.New("restore needs a target table name")
+	}
+	if targetTable == m.tableName {
+		return errors.New("restore needs a new table name, not the table being restored")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeAPI records backups and restores in memory, listing one backup per page
+type fakeAPI struct {
+	API
+	backups  []types.BackupSummary
+	restores []string
+}
+
+func (f *fakeAPI) CreateBackup(ctx context.Context, params *dynamodb.CreateBackupInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateBackupOutput, error) {
+	arn := "arn:backup/" + aws.ToString(params.BackupName)
+	f.backups = append(f.backups, types.BackupSummary{BackupArn: aws.String(arn), BackupName: params.BackupName})
+	return &dynamodb.CreateBackupOutput{BackupDetails: &types.BackupDetails{
+		BackupArn:    aws.String(arn),
+		BackupName:   params.BackupName,
+		BackupStatus: types.BackupStatusCreating,
+	}}, nil
+}
+
+func (f *fakeAPI) ListBackups(ctx context.Context, params *dynamodb.ListBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ListBackupsOutput, error) {
+	next := 0
+	for i, b := range f.backups {
+		if aws.ToString(b.BackupArn) == aws.ToString(params.ExclusiveStartBackupArn) {
+			next = i + 1
+		}
+	}
+	out := &dynamodb.ListBackupsOutput{}
+	if next < len(f.backups) {
+		out.BackupSummaries = f.backups[next : next+1]
+		if next+1 < len(f.backups) {
+			out.LastEvaluatedBackupArn = f.backups[next].BackupArn
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAPI) RestoreTableToPointInTime(ctx context.Context, params *dynamodb.RestoreTableToPointInTimeInput, optFns ...func(*dynamodb.Options)) (*dynamodb.RestoreTableToPointInTimeOutput, error) {
+	f.restores = append(f.restores, aws.ToString(params.TargetTableName))
+	return &dynamodb.RestoreTableToPointInTimeOutput{}, nil
+}
+
+func TestManager_CreateAndList(t *testing.T) {
+	api := &fakeAPI{}
+	manager := New(api, "AppTable")
+	manager.now = func() time.Time { return time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC) }
+	ctx := context.Background()
+
+	// Test an unnamed backup is named after the table and time
+	created, err := manager.Create(ctx, "")
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if created.Name != "AppTable-20240102-150405" {
+		t.Errorf("Name = %v, want AppTable-20240102-150405", created.Name)
+	}
+	if _, err := manager.Create(ctx, "nightly"); err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+
+	// Test List follows every page
+	backups, err := manager.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list backups: %v", err)
+	}
+	if len(backups) != 2 || backups[1].Name != "nightly" {
+		t.Errorf("List() = %+v, want both backups", backups)
+	}
+}
+
+func TestManager_RestoreToTime(t *testing.T) {
+	api := &fakeAPI{}
+	manager := New(api, "AppTable")
+	ctx := context.Background()
+
+	// Test restoring over the table itself is refused before calling AWS
+	if err := manager.RestoreToTime(ctx, "AppTable", time.Time{}); err == nil {
+		t.Error("Expected error restoring into the source table, got nil")
+	}
+	if err := manager.Restore(ctx, "arn:backup/nightly", ""); err == nil {
+		t.Error("Expected error restoring without a target, got nil")
+	}
+
+	if err := manager.RestoreToTime(ctx, "AppTableRestored", time.Time{}); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if len(api.restores) != 1 || api.restores[0] != "AppTableRestored" {
+		t.Errorf("Restores = %v, want AppTableRestored", api.restores)
+	}
+}
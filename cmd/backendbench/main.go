@@ -0,0 +1,163 @@
+// Command backendbench runs the same workload against a storage backend,
+// the single table or a table per entity (see package multitable), and
+// prints the time and DynamoDB requests each step took. It uses temporary
+// tables, so it can run against a live endpoint.
+//
+//	go run ./cmd/backendbench -local -backend single
+//	go run ./cmd/backendbench -local -backend multi
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/multitable"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+func main() {
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	backend := flag.String("backend", "", "single or multi, defaults to the configured backend")
+	users := flag.Int("users", 20, "number of users")
+	orders := flag.Int("orders", 10, "orders per user")
+	products := flag.Int("products", 50, "number of products")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	cfg.Local = cfg.Local || *local
+	if *backend != "" {
+		cfg.Backend = *backend
+	}
+	cfg.TableName = "backendbench_" + uuid.New().String()
+
+	ctx := context.Background()
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	var requests atomic.Int64
+	client = countRequests(client, &requests)
+
+	repos, err := multitable.Open(ctx, client, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dropTables(client, cfg)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s backend\ttime\trequests\n", cfg.Backend)
+	step := func(name string, run func() error) {
+		requests.Store(0)
+		start := time.Now()
+		if err := run(); err != nil {
+			dropTables(client, cfg)
+			log.Fatalf("%s: %v", name, err)
+		}
+		fmt.Fprintf(w, "%s\t%v\t%d\n", name, time.Since(start).Round(time.Millisecond), requests.Load())
+	}
+
+	productIDs := make([]string, *products)
+	step("put products", func() error {
+		for i := range productIDs {
+			product := fixtures.NewProduct().Build()
+			productIDs[i] = product.ProductID
+			if err := repos.Products.Put(ctx, product); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	emails := make([]string, *users)
+	var lastOrders []models.Order
+	step("put users and orders", func() error {
+		for i := range emails {
+			user := fixtures.NewUser().Build()
+			emails[i] = user.Email
+			if err := repos.Users.Put(ctx, user); err != nil {
+				return err
+			}
+			for j := 0; j < *orders; j++ {
+				order := fixtures.NewOrderFor(user).WithProducts(productIDs[(i+j)%len(productIDs)]).Build()
+				if err := repos.Orders.Put(ctx, order); err != nil {
+					return err
+				}
+				lastOrders = append(lastOrders, order)
+			}
+		}
+		return nil
+	})
+
+	step("get user with orders", func() error {
+		for _, email := range emails {
+			aggregate, err := repos.Users.GetUserWithOrders(ctx, email)
+			if err != nil {
+				return err
+			}
+			if len(aggregate.Orders) != *orders {
+				return fmt.Errorf("got %d orders for %s, want %d", len(aggregate.Orders), email, *orders)
+			}
+		}
+		return nil
+	})
+
+	step("get order", func() error {
+		for _, order := range lastOrders {
+			if _, err := repos.Orders.Get(ctx, order.UserEmail, order.OrderID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	step("get many products", func() error {
+		_, err := repos.Products.GetMany(ctx, productIDs)
+		return err
+	})
+	w.Flush()
+}
+
+// countRequests returns a copy of client that counts the requests it sends
+func countRequests(client *dynamodb.Client, requests *atomic.Int64) *dynamodb.Client {
+	return dynamodb.New(client.Options(), func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CountRequests",
+				func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					requests.Add(1)
+					return next.HandleInitialize(ctx, in)
+				}), middleware.After)
+		})
+	})
+}
+
+// dropTables deletes the workload's temporary tables
+func dropTables(client *dynamodb.Client, cfg config.Config) {
+	ctx := context.Background()
+	if cfg.Backend == repository.BackendMulti {
+		if err := multitable.DeleteTables(ctx, client, multitable.TablesFor(cfg.TableName)); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	if _, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(cfg.TableName)}); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,108 @@
+// Command backup manages backups of the configured table. DynamoDB Local
+// has no backups, so it runs against AWS.
+//
+//	go run ./cmd/backup pitr                        # show point-in-time recovery
+//	go run ./cmd/backup pitr -enable                # turn it on
+//	go run ./cmd/backup create [-name nightly]      # start an on-demand backup
+//	go run ./cmd/backup list
+//	go run ./cmd/backup restore -arn <backup arn> -to AppTableRestored
+//	go run ./cmd/backup restore -time 2024-01-02T15:04:05Z -to AppTableRestored
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"LearnSingleTableDesign/backup"
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("usage: backup pitr|create|list|restore [flags]")
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	enable := flags.Bool("enable", false, "pitr: turn on point-in-time recovery")
+	name := flags.String("name", "", "create: backup name, defaults to the table name and time")
+	arn := flags.String("arn", "", "restore: the backup to restore")
+	at := flags.String("time", "", "restore: an RFC 3339 time to restore to with point-in-time recovery, or \"latest\"")
+	to := flags.String("to", "", "restore: the new table to restore into")
+	flags.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+
+	ctx := context.Background()
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	manager := backup.New(client, cfg.TableName)
+
+	switch command {
+	case "pitr":
+		if *enable {
+			if err := manager.EnablePITR(ctx); err != nil {
+				log.Fatal(err)
+			}
+		}
+		status, err := manager.PITRStatus(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !status.Enabled {
+			fmt.Printf("point-in-time recovery is off for %s\n", cfg.TableName)
+			return
+		}
+		fmt.Printf("point-in-time recovery is on for %s, restorable from %s to %s\n",
+			cfg.TableName, status.Earliest.Format(time.RFC3339), status.Latest.Format(time.RFC3339))
+
+	case "create":
+		created, err := manager.Create(ctx, *name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("started backup %s (%s)\n", created.Name, created.ARN)
+
+	case "list":
+		backups, err := manager.List(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, b := range backups {
+			fmt.Printf("%s\t%s\t%s\t%d bytes\t%s\n", b.Created.Format(time.RFC3339), b.Name, b.Status, b.SizeBytes, b.ARN)
+		}
+
+	case "restore":
+		switch {
+		case *arn != "":
+			err = manager.Restore(ctx, *arn, *to)
+		case *at == "latest":
+			err = manager.RestoreToTime(ctx, *to, time.Time{})
+		case *at != "":
+			var restoreTime time.Time
+			if restoreTime, err = time.Parse(time.RFC3339, *at); err != nil {
+				log.Fatalf("invalid -time, %v", err)
+			}
+			err = manager.RestoreToTime(ctx, *to, restoreTime)
+		default:
+			log.Fatal("restore needs -arn or -time")
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("restoring into %s, which is usable once it is ACTIVE\n", *to)
+
+	default:
+		log.Fatalf("unknown command %s, want pitr, create, list or restore", command)
+	}
+}
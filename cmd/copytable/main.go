@@ -0,0 +1,51 @@
+// Command copytable copies a table, schema and items, into a new table,
+// e.g. to snapshot local data before an experiment.
+//
+//	go run ./cmd/copytable -local -to AppTable_before
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/schema"
+)
+
+func main() {
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	from := flag.String("from", "", "table to copy, defaults to the configured table")
+	to := flag.String("to", "", "new table to copy into")
+	segments := flag.Int("segments", 4, "parallel scan segments")
+	rate := flag.Int("rate", 0, "most items written per second, 0 for no limit")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	if *local {
+		cfg.Local = true
+	}
+	if *from == "" {
+		*from = cfg.TableName
+	}
+	if *to == "" {
+		log.Fatal("-to is required")
+	}
+
+	ctx := context.Background()
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	copied, err := schema.CopyTable(ctx, client, *from, *to, schema.CopyOptions{Segments: *segments, ItemsPerSecond: *rate})
+	if err != nil {
+		log.Fatalf("copied %d items before failing: %v", copied, err)
+	}
+	fmt.Printf("copied %d items from %s to %s\n", copied, *from, *to)
+}
@@ -0,0 +1,55 @@
+// Command fetchassets downloads the third-party scripts pinned in
+// assets.Vendor into web/assets/static/vendor, so they are embedded in the
+// binary instead of loaded from a CDN. Commit the files it writes.
+//
+//	go run ./cmd/fetchassets
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"LearnSingleTableDesign/web/assets"
+)
+
+func main() {
+	dir := flag.String("dir", "web/assets/static/vendor", "directory to write the files to")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatalf("failed to create %s: %v", *dir, err)
+	}
+	client := &http.Client{Timeout: time.Minute}
+	for _, v := range assets.Vendor {
+		body, err := fetch(client, v.URL)
+		if err != nil {
+			log.Fatalf("failed to fetch %s: %v", v.Name, err)
+		}
+		dst := filepath.Join(*dir, v.Name)
+		if err := os.WriteFile(dst, body, 0o644); err != nil {
+			log.Fatalf("failed to write %s: %v", dst, err)
+		}
+		sum := sha256.Sum256(body)
+		fmt.Printf("%s\t%d bytes\tsha256 %s\n", dst, len(body), hex.EncodeToString(sum[:]))
+	}
+}
+
+func fetch(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,210 @@
+// Command layoutreport compares the nested and flattened item layouts on a
+// generated dataset: item size, marshal cost, filterability and, with -query,
+// filtered query latency against the configured DynamoDB endpoint.
+//
+//	go run ./cmd/layoutreport -n 1000 -query -local
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/schema"
+)
+
+// layoutStats are the measurements for one layout
+type layoutStats struct {
+	avgSize, maxSize   int
+	marshal, unmarshal time.Duration
+	query              time.Duration
+}
+
+func main() {
+	n := flag.Int("n", 1000, "number of orders to generate")
+	query := flag.Bool("query", false, "also measure filtered query latency against DynamoDB (uses a temporary table)")
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	flag.Parse()
+
+	items := generateOrders(*n)
+	layouts := []repository.Layout{repository.LayoutNested, repository.LayoutFlattened}
+
+	stats := make(map[repository.Layout]*layoutStats)
+	for _, layout := range layouts {
+		s, err := measure(items, layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stats[layout] = s
+	}
+
+	if *query {
+		if err := measureQueries(items, layouts, stats, *local); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "layout\tavg item bytes\tmax item bytes\tmarshal/op\tunmarshal/op\tfiltered query\n")
+	for _, layout := range layouts {
+		s := stats[layout]
+		q := "-"
+		if *query {
+			q = s.query.String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", layout, s.avgSize, s.maxSize, s.marshal, s.unmarshal, q)
+	}
+	w.Flush()
+
+	fmt.Println()
+	fmt.Println("filterability:")
+	fmt.Println("  nested:    filters use document paths (data.status); attributes can't be GSI keys")
+	fmt.Println("  flattened: filters use top-level names (status); attributes can be GSI keys directly")
+}
+
+// generateOrders builds orders spread over 100 users with varying product counts
+func generateOrders(n int) []repository.GenericItem[models.Order] {
+	statuses := []models.OrderStatus{models.OrderStatusPending, models.OrderStatusProcessing, models.OrderStatusCompleted, models.OrderStatusCancelled}
+	items := make([]repository.GenericItem[models.Order], n)
+	for i := range items {
+		products := make([]models.LineItem, 1+i%5)
+		for j := range products {
+			id := (i + j) % 50
+			products[j] = models.LineItem{ProductID: fmt.Sprintf("PROD%d", id), Name: fmt.Sprintf("Product %d", id), UnitPrice: float64(id) + 0.99, Quantity: 1}
+		}
+		order := models.Order{
+			OrderID:   fmt.Sprintf("ORD%06d", i),
+			UserEmail: fmt.Sprintf("user%d@example.com", i%100),
+			Status:    statuses[i%len(statuses)],
+			Total:     float64(i%1000) + 0.99,
+			Items:     products,
+			CreatedAt: time.Now().Add(-time.Duration(i) * time.Minute),
+		}
+		items[i] = repository.GenericItem[models.Order]{
+			PK:         repository.Key.UserPK(order.UserEmail),
+			SK:         repository.Key.OrderSK(order.OrderID),
+			EntityType: repository.EntityOrder,
+			Data:       order,
+		}
+	}
+	return items
+}
+
+// measure computes item sizes and per-item marshal costs for a layout
+func measure(items []repository.GenericItem[models.Order], layout repository.Layout) (*layoutStats, error) {
+	s := &layoutStats{}
+	marshalled := make([]map[string]types.AttributeValue, len(items))
+	total := 0
+	for i, item := range items {
+		av, err := repository.MarshalLayout(item, layout)
+		if err != nil {
+			return nil, err
+		}
+		marshalled[i] = av
+		size := repository.ItemSize(av)
+		total += size
+		s.maxSize = max(s.maxSize, size)
+	}
+	s.avgSize = total / max(len(items), 1)
+
+	marshal := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			repository.MarshalLayout(items[i%len(items)], layout)
+		}
+	})
+	unmarshal := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var out repository.GenericItem[models.Order]
+			repository.UnmarshalLayout(marshalled[i%len(marshalled)], layout, &out)
+		}
+	})
+	s.marshal = time.Duration(marshal.NsPerOp())
+	s.unmarshal = time.Duration(unmarshal.NsPerOp())
+	return s, nil
+}
+
+// measureQueries writes each layout's dataset to a temporary table and times
+// a status-filtered query over every user's partition
+func measureQueries(items []repository.GenericItem[models.Order], layouts []repository.Layout, stats map[repository.Layout]*layoutStats, local bool) error {
+	ctx := context.Background()
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg.Local = cfg.Local || local
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, layout := range layouts {
+		tableName := fmt.Sprintf("layoutreport_%s_%s", layout, uuid.New().String())
+		if err := schema.CreateTable(ctx, client, tableName); err != nil {
+			return err
+		}
+		elapsed, err := timeFilteredQueries(ctx, client, tableName, items, layout)
+		client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return err
+		}
+		stats[layout].query = elapsed
+	}
+	return nil
+}
+
+func timeFilteredQueries(ctx context.Context, client *dynamodb.Client, tableName string, items []repository.GenericItem[models.Order], layout repository.Layout) (time.Duration, error) {
+	users := make(map[repository.PrimaryKey]bool)
+	for _, item := range items {
+		av, err := repository.MarshalLayout(item, layout)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tableName), Item: av}); err != nil {
+			return 0, fmt.Errorf("failed to write item: %w", err)
+		}
+		users[item.PK] = true
+	}
+
+	status := expression.Name("status")
+	if layout == repository.LayoutNested {
+		status = expression.Name("data.status")
+	}
+	filter := status.Equal(expression.Value(string(models.OrderStatusPending)))
+
+	start := time.Now()
+	for pk := range users {
+		expr, err := expression.NewBuilder().
+			WithKeyCondition(expression.Key("PK").Equal(expression.Value(string(pk)))).
+			WithFilter(filter).
+			Build()
+		if err != nil {
+			return 0, fmt.Errorf("failed to build query: %w", err)
+		}
+		_, err = client.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(tableName),
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to query: %w", err)
+		}
+	}
+	return time.Since(start) / time.Duration(len(users)), nil
+}
@@ -0,0 +1,54 @@
+// Command migrateorders gives orders stored before orders had line items
+// their Items, built from their old list of product IDs. Run it once after
+// deploying line items. Each order is rewritten on condition it hasn't been
+// migrated yet, so the command can be stopped and re-run safely.
+//
+//	go run ./cmd/migrateorders -local -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/repository"
+)
+
+func main() {
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	dryRun := flag.Bool("dry-run", false, "only count the orders that would be migrated")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	if *local {
+		cfg.Local = true
+	}
+	repository.UseIDHasher(repository.NewIDHasher(cfg.KeyHashSecret))
+
+	ctx := context.Background()
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	// Stay within WRITE_BUDGET so the app keeps its share of the table
+	orders := repository.NewOrderRepository(client, cfg.TableName,
+		repository.LimitWrites(repository.NewWriteLimiter(cfg.WriteBudget)))
+	products := repository.NewProductRepository(client, cfg.TableName)
+	scanned, migrated, err := orders.MigrateLineItems(ctx, products, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	verb := "migrated"
+	if *dryRun {
+		verb = "would migrate"
+	}
+	fmt.Printf("found %d orders without line items, %s %d\n", scanned, verb, migrated)
+}
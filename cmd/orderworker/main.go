@@ -0,0 +1,96 @@
+// Command orderworker consumes placed orders from the SQS queue at
+// ORDER_QUEUE_URL and moves them through processing to completed. Like the
+// web server, it emails customers and delivers webhooks as orders change
+// status. With INVOICE_BUCKET set, it stores an invoice for each order it
+// completes.
+//
+//	ORDER_QUEUE_URL=http://localhost:9324/000000000000/orders go run ./cmd/orderworker -local
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"os/signal"
+	"syscall"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/invoices"
+	"LearnSingleTableDesign/notifications"
+	"LearnSingleTableDesign/orderqueue"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/s3"
+	"LearnSingleTableDesign/webhooks"
+)
+
+func main() {
+	local := flag.Bool("local", false, "use DynamoDB Local and ElasticMQ with dummy credentials instead of the AWS config chain")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	if *local {
+		cfg.Local = true
+	}
+	if cfg.OrderQueueURL == "" {
+		log.Fatal("ORDER_QUEUE_URL must be set")
+	}
+	repository.UseIDHasher(repository.NewIDHasher(cfg.KeyHashSecret))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+	queue, err := orderqueue.NewSQS(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to create queue client, %v", err)
+	}
+
+	opts := []repository.StoreOption{repository.EnforceKeyConsistency()}
+
+	// Status changes made here send the same webhooks and emails as ones
+	// made through the web server
+	webhookRepo := repository.NewWebhookRepository(client, cfg.TableName, repository.EnforceKeyConsistency())
+	dispatcher := webhooks.NewDispatcher(webhookRepo, nil, 1000)
+	go dispatcher.Run(ctx)
+	mailer, err := notifications.NewMailer(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to create mailer, %v", err)
+	}
+	prefsRepo := repository.NewNotificationPrefsRepository(client, cfg.TableName, repository.EnforceKeyConsistency())
+	notifier := notifications.NewOrderNotifier(mailer, prefsRepo, 1000)
+	if cfg.LinkSecret != "" {
+		notifier.Settings = notifications.NewSettingsLinks(cfg.LinkSecret, cfg.BaseURL)
+	}
+	go notifier.Run(ctx)
+	opts = append(opts, repository.OnPut(dispatcher.Hook()), repository.OnPut(notifier.Hook()))
+
+	if cfg.InvoiceBucket != "" {
+		objects, err := s3.New(ctx, cfg)
+		if err != nil {
+			log.Fatalf("unable to create S3 client, %v", err)
+		}
+		// Emulators start empty, so make the bucket there
+		if cfg.Local {
+			if err := objects.CreateBucket(ctx, cfg.InvoiceBucket); err != nil {
+				log.Fatalf("unable to create invoice bucket, %v", err)
+			}
+		}
+		// Invoice keys are recorded with updates, which put hooks don't see
+		recorder := repository.NewOrderRepository(client, cfg.TableName, repository.EnforceKeyConsistency())
+		generator := invoices.NewGenerator(objects, cfg.InvoiceBucket, recorder, 1000)
+		go generator.Run(ctx)
+		opts = append(opts, repository.OnPut(generator.Hook()))
+	}
+
+	orders := repository.NewOrderRepository(client, cfg.TableName, opts...)
+	slog.Info("Consuming orders from", "queue", cfg.OrderQueueURL)
+	orderqueue.NewConsumer(queue, orders).Run(ctx)
+}
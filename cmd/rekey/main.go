@@ -0,0 +1,301 @@
+// Command rekey rewrites the keys of every item in user collections with the
+// configured key hashing strategy: raw emails when KEY_HASH_SECRET is unset,
+// an HMAC of the email otherwise. Run it after enabling or rotating the secret,
+// and once to normalize emails written before they were lower cased.
+// Each collection is moved with its profile last, so items that don't store
+// the user's email, such as carts, can still be matched to it if a run is
+// stopped. The collection's audit log and the user's coupon redemptions
+// move with it. Each item is moved with a transaction that writes the new
+// item and deletes the old one, so the command can be stopped and re-run
+// safely. Items that can't be moved are reported at the end and the rest
+// carry on.
+//
+//	KEY_HASH_SECRET=... go run ./cmd/rekey -local -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/repository"
+)
+
+func main() {
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	dryRun := flag.Bool("dry-run", false, "only count the items that would be rekeyed")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	if *local {
+		cfg.Local = true
+	}
+
+	ctx := context.Background()
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	// Stay within WRITE_BUDGET so the app keeps its share of the table
+	client = repository.LimitClientWrites(client, repository.NewWriteLimiter(cfg.WriteBudget))
+
+	r := &rekeyer{
+		client:    client,
+		tableName: cfg.TableName,
+		keys:      repository.NewKeyFactory(repository.NewIDHasher(cfg.KeyHashSecret)),
+		dryRun:    *dryRun,
+	}
+	if err := r.run(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	verb := "rekeyed"
+	if *dryRun {
+		verb = "would rekey"
+	}
+	fmt.Printf("scanned %d items, %s %d\n", r.scanned, verb, r.moved)
+	if len(r.failed) > 0 {
+		fmt.Printf("failed to rekey %d items:\n", len(r.failed))
+		for _, failure := range r.failed {
+			fmt.Println("  " + failure)
+		}
+		os.Exit(1)
+	}
+}
+
+// rekeyer moves user items to their new keys, counting what it did and
+// keeping the items it couldn't move
+type rekeyer struct {
+	client    *dynamodb.Client
+	tableName string
+	keys      repository.KeyFactory
+	dryRun    bool
+
+	scanned, moved int
+	failed         []string
+}
+
+// run rekeys the user collections, then the coupon redemptions. Only errors
+// reading the table stop it.
+func (r *rekeyer) run(ctx context.Context) error {
+	// done holds the collections already rekeyed, under their old keys and
+	// their new ones, so the scan passes over them when it meets them again
+	done := make(map[string]bool)
+	err := r.scan(ctx, expression.Name("PK").BeginsWith(string(repository.PrefixUser)), func(item map[string]types.AttributeValue) error {
+		pk := key(item, "PK")
+		if done[pk] {
+			return nil
+		}
+		done[pk] = true
+		to, err := r.rekeyCollection(ctx, repository.PrimaryKey(pk))
+		done[string(to)] = true
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return r.scan(ctx, expression.Name("SK").BeginsWith(string(repository.PrefixRedemption)), func(item map[string]types.AttributeValue) error {
+		r.scanned++
+		r.rekey(ctx, item, "")
+		return nil
+	})
+}
+
+// rekeyCollection moves every item of the user collection pk and its audit
+// log, returning the collection's new key
+func (r *rekeyer) rekeyCollection(ctx context.Context, pk repository.PrimaryKey) (repository.PrimaryKey, error) {
+	items, err := r.query(ctx, pk)
+	if err != nil {
+		return "", err
+	}
+	r.scanned += len(items)
+
+	var profile map[string]types.AttributeValue
+	var rest []map[string]types.AttributeValue
+	for _, item := range items {
+		if repository.PrefixProfile.Has(key(item, "SK")) {
+			profile = item
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	owner := ""
+	if profile != nil {
+		if owner, err = repository.ItemUserEmail(profile); err != nil {
+			r.fail(profile, err)
+			owner = ""
+		}
+	}
+
+	to := pk
+	if owner != "" {
+		to = r.keys.UserPK(owner)
+	}
+	for _, item := range rest {
+		if rekeyed, ok := r.rekey(ctx, item, owner); ok && owner == "" {
+			to = repository.PrimaryKey(key(rekeyed, "PK"))
+		}
+	}
+	if profile != nil {
+		r.rekey(ctx, profile, owner)
+	}
+	if to == pk {
+		return to, nil
+	}
+
+	entries, err := r.query(ctx, repository.Key.AuditPK(pk))
+	if err != nil {
+		return to, err
+	}
+	r.scanned += len(entries)
+	for _, entry := range entries {
+		r.move(ctx, entry, func() (map[string]types.AttributeValue, bool, error) {
+			return repository.RekeyAuditEntry(entry, to)
+		})
+	}
+	return to, nil
+}
+
+// rekey moves a user item to its new keys, returning the rekeyed item and
+// whether it was moved, or would be on a dry run
+func (r *rekeyer) rekey(ctx context.Context, item map[string]types.AttributeValue, owner string) (map[string]types.AttributeValue, bool) {
+	return r.move(ctx, item, func() (map[string]types.AttributeValue, bool, error) {
+		return r.keys.RekeyUserItem(item, owner)
+	})
+}
+
+// move moves item where rekey says, recording a failure if it can't
+func (r *rekeyer) move(ctx context.Context, item map[string]types.AttributeValue, rekey func() (map[string]types.AttributeValue, bool, error)) (map[string]types.AttributeValue, bool) {
+	rekeyed, changed, err := rekey()
+	if err != nil {
+		r.fail(item, err)
+		return nil, false
+	}
+	if !changed {
+		return rekeyed, false
+	}
+	if !r.dryRun {
+		if err := move(ctx, r.client, r.tableName, item, rekeyed); err != nil {
+			r.fail(item, err)
+			return nil, false
+		}
+	}
+	r.moved++
+	return rekeyed, true
+}
+
+func (r *rekeyer) fail(item map[string]types.AttributeValue, err error) {
+	r.failed = append(r.failed, fmt.Sprintf("%s/%s: %v", key(item, "PK"), key(item, "SK"), err))
+}
+
+// key returns the key attribute name of item, "" if it has none
+func key(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// scan calls each for every item whose key matches filter
+func (r *rekeyer) scan(ctx context.Context, filter expression.ConditionBuilder, each func(map[string]types.AttributeValue) error) error {
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build scan filter: %w", err)
+	}
+	paginator := dynamodb.NewScanPaginator(r.client, &dynamodb.ScanInput{
+		TableName:                 aws.String(r.tableName),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan table: %w", err)
+		}
+		for _, item := range page.Items {
+			if err := each(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// query returns every item in the partition pk
+func (r *rekeyer) query(ctx context.Context, pk repository.PrimaryKey) ([]map[string]types.AttributeValue, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key("PK").Equal(expression.Value(string(pk)))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	var items []map[string]types.AttributeValue
+	paginator := dynamodb.NewQueryPaginator(r.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(r.tableName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConsistentRead:            aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", pk, err)
+		}
+		items = append(items, page.Items...)
+	}
+	return items, nil
+}
+
+// move atomically writes the rekeyed item and deletes the original. An item
+// whose keys didn't change, only its email, is simply overwritten.
+func move(ctx context.Context, client *dynamodb.Client, tableName string, old, rekeyed map[string]types.AttributeValue) error {
+	if sameKey(old, rekeyed) {
+		if _, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tableName), Item: rekeyed}); err != nil {
+			return fmt.Errorf("failed to update item: %w", err)
+		}
+		return nil
+	}
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           aws.String(tableName),
+				Item:                rekeyed,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+			{Delete: &types.Delete{
+				TableName: aws.String(tableName),
+				Key:       map[string]types.AttributeValue{"PK": old["PK"], "SK": old["SK"]},
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move item: %w", err)
+	}
+	return nil
+}
+
+func sameKey(a, b map[string]types.AttributeValue) bool {
+	for _, name := range []string{"PK", "SK"} {
+		x, _ := a[name].(*types.AttributeValueMemberS)
+		y, _ := b[name].(*types.AttributeValueMemberS)
+		if x == nil || y == nil || x.Value != y.Value {
+			return false
+		}
+	}
+	return true
+}
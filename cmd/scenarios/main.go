@@ -0,0 +1,275 @@
+// Command scenarios runs scripted end-to-end business flows against the
+// configured table and checks invariants after each step, as a smoke test
+// after deploys and as a walkthrough of the access patterns:
+//
+//	register → browse → place order → pay → fulfill → refund
+//
+// Every run uses a fresh user, and its items are deleted afterwards unless
+// -keep is set. Carts aren't modelled yet, so the flow places the order
+// directly through the repositories. Payments go through the fake provider.
+//
+//	go run ./cmd/scenarios -local
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/payments"
+	"LearnSingleTableDesign/repository"
+)
+
+// step is one action in a scenario followed by the invariants it must leave behind
+type step struct {
+	name string
+	run  func(ctx context.Context, s *state) error
+}
+
+// state is shared by the steps of one scenario run
+type state struct {
+	users    *repository.UserRepository
+	orders   *repository.OrderRepository
+	products *repository.ProductRepository
+	payments *repository.PaymentRepository
+	checkout *payments.Service
+
+	user    models.User
+	product models.Product
+	order   models.Order
+	charge  models.Payment
+}
+
+var orderLifecycle = []step{
+	{"register", register},
+	{"browse", browse},
+	{"place order", placeOrder},
+	{"pay", pay},
+	{"fulfill", fulfill},
+	{"refund", refund},
+}
+
+func main() {
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	keep := flag.Bool("keep", false, "keep the scenario's items instead of deleting them")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	if *local {
+		cfg.Local = true
+	}
+	repository.UseIDHasher(repository.NewIDHasher(cfg.KeyHashSecret))
+
+	ctx := context.Background()
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	// Read our own writes so invariants don't flake on eventual consistency
+	runID := uuid.New().String()
+	ctx = repository.WithSession(ctx, runID)
+	opts := []repository.StoreOption{
+		repository.EnforceKeyConsistency(),
+		repository.ReadYourWrites(repository.NewRecentWrites(time.Minute)),
+	}
+	s := &state{
+		users:    repository.NewUserRepository(client, cfg.TableName, opts...),
+		orders:   repository.NewOrderRepository(client, cfg.TableName, opts...),
+		products: repository.NewProductRepository(client, cfg.TableName, opts...),
+		payments: repository.NewPaymentRepository(client, cfg.TableName, opts...),
+		user: models.User{
+			Email:     fmt.Sprintf("scenario-%s@example.com", runID),
+			Name:      "Scenario Runner",
+			CreatedAt: time.Now(),
+		},
+	}
+
+	s.checkout = payments.NewService(s.payments, payments.NewFake())
+
+	failed := runScenario(ctx, "order lifecycle", orderLifecycle, s)
+
+	if !*keep {
+		if err := cleanup(ctx, client, cfg.TableName, s); err != nil {
+			log.Printf("cleanup failed: %v", err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runScenario runs the steps in order, stopping at the first failure
+func runScenario(ctx context.Context, name string, steps []step, s *state) (failed bool) {
+	fmt.Printf("scenario: %s\n", name)
+	for _, st := range steps {
+		start := time.Now()
+		if err := st.run(ctx, s); err != nil {
+			fmt.Printf("  FAIL %-12s %v\n", st.name, err)
+			return true
+		}
+		fmt.Printf("  ok   %-12s %s\n", st.name, time.Since(start).Round(time.Millisecond))
+	}
+	return false
+}
+
+func register(ctx context.Context, s *state) error {
+	if err := s.users.Put(ctx, s.user); err != nil {
+		return err
+	}
+	got, err := s.users.Get(ctx, s.user.Email)
+	if err != nil {
+		return fmt.Errorf("registered user not readable: %w", err)
+	}
+	if got.Name != s.user.Name {
+		return fmt.Errorf("name = %q, want %q", got.Name, s.user.Name)
+	}
+	return nil
+}
+
+func browse(ctx context.Context, s *state) error {
+	page, err := s.products.All(ctx, &repository.QueryOptions{Limit: 10})
+	if err != nil {
+		return err
+	}
+	for _, product := range page.Products {
+		if product.Stock > 0 {
+			s.product = product
+			return nil
+		}
+	}
+	return errors.New("no product in stock on the first page of the catalogue")
+}
+
+func placeOrder(ctx context.Context, s *state) error {
+	s.order = models.Order{
+		OrderID:   "SCN-" + uuid.New().String(),
+		UserEmail: s.user.Email,
+		Status:    models.OrderStatusPending,
+		Total:     s.product.Price,
+		Items:     []models.LineItem{{ProductID: s.product.ProductID, Name: s.product.Name, UnitPrice: s.product.Price, Quantity: 1}},
+		CreatedAt: time.Now(),
+	}
+	if err := s.orders.CheckReferences(ctx, s.order); err != nil {
+		return err
+	}
+	if err := s.orders.Put(ctx, s.order); err != nil {
+		return err
+	}
+
+	aggregate, err := s.users.GetUserWithOrders(ctx, s.user.Email)
+	if err != nil {
+		return err
+	}
+	if len(aggregate.Orders) != 1 {
+		return fmt.Errorf("user has %d orders, want 1", len(aggregate.Orders))
+	}
+	if got := aggregate.Orders[0]; got.Status != models.OrderStatusPending || got.Total != s.product.Price {
+		return fmt.Errorf("order = %s %.2f, want %s %.2f", got.Status, got.Total, models.OrderStatusPending, s.product.Price)
+	}
+	return nil
+}
+
+func pay(ctx context.Context, s *state) error {
+	charge, err := s.checkout.Charge(ctx, s.order)
+	if err != nil {
+		return err
+	}
+	s.charge = *charge
+
+	recorded, err := s.payments.ForOrder(ctx, s.order.OrderID)
+	if err != nil {
+		return err
+	}
+	if len(recorded) != 1 || recorded[0].Status != models.PaymentStatusSucceeded || recorded[0].Amount != s.order.Total {
+		return fmt.Errorf("payments = %+v, want one successful charge of %.2f", recorded, s.order.Total)
+	}
+	return nil
+}
+
+func fulfill(ctx context.Context, s *state) error {
+	for _, status := range []models.OrderStatus{models.OrderStatusProcessing, models.OrderStatusCompleted} {
+		s.order.Status = status
+		if err := s.orders.Put(ctx, s.order); err != nil {
+			return err
+		}
+	}
+
+	page, err := s.orders.GetUserOrdersByCreatedAt(ctx, s.user.Email, &repository.QueryOptions{Descending: true, Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(page.Orders) != 1 || page.Orders[0].OrderID != s.order.OrderID {
+		return fmt.Errorf("latest order by creation time is not %s", s.order.OrderID)
+	}
+	if page.Orders[0].Status != models.OrderStatusCompleted {
+		return fmt.Errorf("status = %s, want %s", page.Orders[0].Status, models.OrderStatusCompleted)
+	}
+	return nil
+}
+
+func refund(ctx context.Context, s *state) error {
+	half := s.charge.Amount / 2
+	if _, err := s.checkout.Refund(ctx, s.charge, half); err != nil {
+		return err
+	}
+	if _, err := s.checkout.Refund(ctx, s.charge, s.charge.Amount); !errors.Is(err, payments.ErrRefundTooLarge) {
+		return fmt.Errorf("refunding more than was charged: err = %v, want ErrRefundTooLarge", err)
+	}
+
+	refundable, err := s.checkout.Refundable(ctx, s.charge)
+	if err != nil {
+		return err
+	}
+	if refundable != s.charge.Amount-half {
+		return fmt.Errorf("refundable = %.2f, want %.2f", refundable, s.charge.Amount-half)
+	}
+	return nil
+}
+
+// cleanup deletes the scenario user's profile, orders and payments
+func cleanup(ctx context.Context, client *dynamodb.Client, tableName string, s *state) error {
+	keys := []repository.ItemKey{
+		{PK: repository.Key.UserPK(s.user.Email), SK: repository.Key.UserSK(s.user.Email)},
+	}
+	if s.order.OrderID != "" {
+		keys = append(keys, repository.ItemKey{PK: repository.Key.UserPK(s.user.Email), SK: repository.Key.OrderSK(s.order.OrderID)})
+
+		recorded, err := s.payments.ForOrder(ctx, s.order.OrderID)
+		if err != nil {
+			return err
+		}
+		for _, payment := range recorded {
+			keys = append(keys, repository.ItemKey{PK: repository.Key.PaymentPK(payment.OrderID), SK: repository.Key.PaymentSK(payment.PaymentID)})
+		}
+	}
+
+	for _, key := range keys {
+		_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: string(key.PK)},
+				"SK": &types.AttributeValueMemberS{Value: string(key.SK)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete %s/%s: %w", key.PK, key.SK, err)
+		}
+	}
+	return nil
+}
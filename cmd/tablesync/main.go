@@ -0,0 +1,57 @@
+// Command tablesync brings the configured table in line with its spec:
+// billing mode, capacity, stream, indexes, time to live and tags. The app
+// does the same on start; this shows what would change first.
+//
+//	go run ./cmd/tablesync -local -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/schema"
+)
+
+func main() {
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	dryRun := flag.Bool("dry-run", false, "only print the changes that would be made")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	if *local {
+		cfg.Local = true
+	}
+
+	ctx := context.Background()
+	client, err := dynamoclient.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	spec := schema.FromConfig(cfg)
+	diff, err := schema.Plan(ctx, client, spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if diff.Empty() {
+		fmt.Printf("table %s matches its spec\n", spec.Name)
+		return
+	}
+	for _, change := range diff.Changes() {
+		fmt.Println(change)
+	}
+	if *dryRun {
+		return
+	}
+	if err := schema.Apply(ctx, client, spec, diff); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("updated table %s\n", spec.Name)
+}
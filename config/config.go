@@ -0,0 +1,310 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The local emulators Endpoint can point at. EmulatorFake is the
+// in-process fake of testutil/fakedynamo, which only the tests can use.
+const (
+	EmulatorDynamoDBLocal = "dynamodb-local"
+	EmulatorLocalStack    = "localstack"
+	EmulatorFake          = "fake"
+)
+
+// Default endpoints of the emulators
+const (
+	DynamoDBLocalEndpoint = "http://localhost:8000"
+	LocalStackEndpoint    = "http://localhost:4566"
+)
+
+// Config holds the settings shared by main, the web server and the tests
+type Config struct {
+	// Endpoint overrides the DynamoDB endpoint, e.g. DynamoDB Local
+	Endpoint string `yaml:"endpoint"`
+	// Emulator is the emulator Endpoint points at, dynamodb-local or
+	// localstack, or fake for the tests' in-process fake; when empty it is
+	// detected from Endpoint
+	Emulator string `yaml:"emulator"`
+	// Region is the AWS region to use
+	Region string `yaml:"region"`
+	// TableName is the single table every entity is stored in
+	TableName string `yaml:"table_name"`
+	// Port is the port the web server listens on
+	Port int `yaml:"port"`
+	// LogLevel is one of debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// Local runs against DynamoDB Local with dummy credentials instead of AWS
+	Local bool `yaml:"local"`
+	// Dev enables development-only checks such as duplicate write detection
+	// and checking the total of every order read
+	Dev bool `yaml:"dev"`
+	// KeyHashSecret, when set, replaces emails in keys with an HMAC of them
+	KeyHashSecret string `yaml:"key_hash_secret"`
+	// SearchEndpoint is an OpenSearch URL to mirror products to; when empty
+	// the search box falls back to name prefix matching on the table
+	SearchEndpoint string `yaml:"search_endpoint"`
+	// SearchIndex is the OpenSearch index products are mirrored to
+	SearchIndex string `yaml:"search_index"`
+	// Mailer is how order emails are sent: log, ses or none
+	Mailer string `yaml:"mailer"`
+	// MailFrom is the sender address for order emails
+	MailFrom string `yaml:"mail_from"`
+	// LowStockEmail is emailed when a product falls below its stock
+	// threshold; when empty, low stock is only logged and sent to webhooks
+	LowStockEmail string `yaml:"low_stock_email"`
+	// OrderQueueURL is an SQS queue that placed orders are sent to for
+	// processing; when empty, orders are not queued
+	OrderQueueURL string `yaml:"order_queue_url"`
+	// PrettyHTML indents HTML responses; turn it off in production
+	PrettyHTML bool `yaml:"pretty_html"`
+	// Audit records every write in the audit log, at the cost of a read
+	// and a transaction per write
+	Audit bool `yaml:"audit"`
+	// OperationTimeout bounds each DynamoDB call, retries included; 0 means
+	// no timeout
+	OperationTimeout time.Duration `yaml:"operation_timeout"`
+	// ExchangeRates is how much of each currency one US dollar buys, for
+	// showing prices in the visitor's currency; when empty a built-in table
+	// of rough rates is used
+	ExchangeRates map[string]float64 `yaml:"exchange_rates"`
+	// ReadOnly starts the app refusing writes, for a maintenance window;
+	// the admin dashboard can turn it off and on again
+	ReadOnly bool `yaml:"read_only"`
+	// BillingMode is the table's billing, PAY_PER_REQUEST or PROVISIONED
+	BillingMode string `yaml:"billing_mode"`
+	// ReadCapacity and WriteCapacity are the units a provisioned table and
+	// its indexes get; on demand they cap request units, 0 meaning no cap
+	ReadCapacity  int64 `yaml:"read_capacity"`
+	WriteCapacity int64 `yaml:"write_capacity"`
+	// WriteBudget caps the write capacity units a second that seeding,
+	// imports and migrations use, to leave the rest of a provisioned
+	// table's capacity to the app; 0 means no cap
+	WriteBudget int64 `yaml:"write_budget"`
+	// StreamView turns on the table's stream with that view, e.g.
+	// NEW_AND_OLD_IMAGES; empty means no stream
+	StreamView string `yaml:"stream_view"`
+	// TableTags are added to the table
+	TableTags map[string]string `yaml:"table_tags"`
+	// Backend is the storage to use: single for the single table, multi for
+	// a table per entity (backend comparison only) or sqlite for a local
+	// SQLite database
+	Backend string `yaml:"backend"`
+	// SQLitePath is the database file of the sqlite backend
+	SQLitePath string `yaml:"sqlite_path"`
+	// S3Endpoint is where S3 requests go, e.g. a local MinIO; empty means
+	// AWS, or the emulator's endpoint when local mode runs on LocalStack
+	S3Endpoint string `yaml:"s3_endpoint"`
+	// ArchiveAfterDays is how many days after being placed completed orders
+	// expire from the table; 0 keeps them forever
+	ArchiveAfterDays int `yaml:"archive_after_days"`
+	// ArchiveBucket is the S3 bucket expired orders are saved to as JSON;
+	// empty means they're dropped without a copy
+	ArchiveBucket string `yaml:"archive_bucket"`
+	// InvoiceBucket is the S3 bucket completed orders' invoices are saved
+	// to; empty means no invoices are generated
+	InvoiceBucket string `yaml:"invoice_bucket"`
+	// ImageBucket is the S3 bucket uploaded product images are stored in
+	ImageBucket string `yaml:"image_bucket"`
+	// ImageDir stores uploaded product images on local disk instead, when
+	// ImageBucket is empty; with neither, uploads are off
+	ImageDir string `yaml:"image_dir"`
+	// AdminUser and AdminPassword are the HTTP basic auth credentials of
+	// the admin pages; with no password the admin pages are off
+	AdminUser     string `yaml:"admin_user"`
+	AdminPassword string `yaml:"admin_password"`
+	// BaseURL is where the web server is reached, for links in emails
+	BaseURL string `yaml:"base_url"`
+	// LinkSecret signs the links in emails that let customers change their
+	// notification settings; with none, only the admin can change them
+	LinkSecret string `yaml:"link_secret"`
+}
+
+// Default returns the config used when nothing is overridden. It targets
+// real AWS; Endpoint only applies once Local is enabled (see main's -local flag).
+func Default() Config {
+	return Config{
+		Endpoint:         DynamoDBLocalEndpoint,
+		Region:           "us-east-1",
+		TableName:        "AppTable",
+		Port:             8080,
+		LogLevel:         "info",
+		Local:            false,
+		Dev:              false,
+		SearchIndex:      "products",
+		Mailer:           "log",
+		MailFrom:         "orders@example.com",
+		PrettyHTML:       true,
+		Audit:            true,
+		OperationTimeout: 5 * time.Second,
+		BillingMode:      "PAY_PER_REQUEST",
+		Backend:          "single",
+		SQLitePath:       "app.db",
+		AdminUser:        "admin",
+		BaseURL:          "http://localhost:8080",
+	}
+}
+
+// Load builds the config from the defaults, then the YAML file named by
+// CONFIG_FILE if set, then environment variables, each overriding the last
+func Load() (Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+	// Choosing LocalStack without an endpoint means its default edge port
+	if cfg.Emulator == EmulatorLocalStack && cfg.Endpoint == DynamoDBLocalEndpoint {
+		cfg.Endpoint = LocalStackEndpoint
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides config values with any environment variables that are set
+func applyEnv(cfg *Config) error {
+	strings := map[string]*string{
+		"DYNAMODB_ENDPOINT": &cfg.Endpoint,
+		"EMULATOR":          &cfg.Emulator,
+		"AWS_REGION":        &cfg.Region,
+		"TABLE_NAME":        &cfg.TableName,
+		"LOG_LEVEL":         &cfg.LogLevel,
+		"KEY_HASH_SECRET":   &cfg.KeyHashSecret,
+		"SEARCH_ENDPOINT":   &cfg.SearchEndpoint,
+		"SEARCH_INDEX":      &cfg.SearchIndex,
+		"MAILER":            &cfg.Mailer,
+		"MAIL_FROM":         &cfg.MailFrom,
+		"ORDER_QUEUE_URL":   &cfg.OrderQueueURL,
+		"LOW_STOCK_EMAIL":   &cfg.LowStockEmail,
+		"BILLING_MODE":      &cfg.BillingMode,
+		"STREAM_VIEW":       &cfg.StreamView,
+		"STORAGE_BACKEND":   &cfg.Backend,
+		"SQLITE_PATH":       &cfg.SQLitePath,
+		"S3_ENDPOINT":       &cfg.S3Endpoint,
+		"ARCHIVE_BUCKET":    &cfg.ArchiveBucket,
+		"INVOICE_BUCKET":    &cfg.InvoiceBucket,
+		"IMAGE_BUCKET":      &cfg.ImageBucket,
+		"IMAGE_DIR":         &cfg.ImageDir,
+		"ADMIN_USER":        &cfg.AdminUser,
+		"ADMIN_PASSWORD":    &cfg.AdminPassword,
+		"BASE_URL":          &cfg.BaseURL,
+		"LINK_SECRET":       &cfg.LinkSecret,
+	}
+	for name, field := range strings {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	bools := map[string]*bool{
+		"LOCAL_MODE":  &cfg.Local,
+		"DEV_MODE":    &cfg.Dev,
+		"PRETTY_HTML": &cfg.PrettyHTML,
+		"AUDIT":       &cfg.Audit,
+		"READ_ONLY":   &cfg.ReadOnly,
+	}
+	for name, field := range bools {
+		if value, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = b
+		}
+	}
+
+	if value, ok := os.LookupEnv("PORT"); ok {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid PORT: %w", err)
+		}
+		cfg.Port = port
+	}
+
+	if value, ok := os.LookupEnv("ARCHIVE_AFTER_DAYS"); ok {
+		days, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid ARCHIVE_AFTER_DAYS: %w", err)
+		}
+		cfg.ArchiveAfterDays = days
+	}
+
+	capacities := map[string]*int64{
+		"READ_CAPACITY":  &cfg.ReadCapacity,
+		"WRITE_CAPACITY": &cfg.WriteCapacity,
+		"WRITE_BUDGET":   &cfg.WriteBudget,
+	}
+	for name, field := range capacities {
+		if value, ok := os.LookupEnv(name); ok {
+			units, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = units
+		}
+	}
+
+	if value, ok := os.LookupEnv("OPERATION_TIMEOUT"); ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid OPERATION_TIMEOUT: %w", err)
+		}
+		cfg.OperationTimeout = timeout
+	}
+	return nil
+}
+
+// SlogLevel parses LogLevel for use with log/slog
+func (c Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: %w", c.LogLevel, err)
+	}
+	return level, nil
+}
+
+// LocalEmulator returns the emulator local mode talks to: Emulator when
+// set, otherwise localstack when Endpoint uses LocalStack's edge port or
+// host name, and dynamodb-local for anything else
+func (c Config) LocalEmulator() string {
+	if c.Emulator != "" {
+		return c.Emulator
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return EmulatorDynamoDBLocal
+	}
+	if u.Port() == "4566" || strings.Contains(u.Hostname(), "localstack") {
+		return EmulatorLocalStack
+	}
+	return EmulatorDynamoDBLocal
+}
+
+// PathStyle reports whether S3 clients must use path-style URLs. LocalStack
+// and MinIO need them, as bucket subdomains of their endpoints don't
+// resolve.
+func (c Config) PathStyle() bool {
+	return c.S3Endpoint != "" || c.Local && c.LocalEmulator() == EmulatorLocalStack
+}
+
+// Addr is the address the web server listens on
+func (c Config) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
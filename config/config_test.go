@@ -0,0 +1,153 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	// make test-local and test-localstack select an emulator for the whole run
+	t.Setenv("EMULATOR", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Load() = %+v, want %+v", cfg, Default())
+	}
+}
+
+func TestLoad_FileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "table_name: FromFile\nport: 9000\nlocal: true\nlog_level: debug\noperation_timeout: 2s\nexchange_rates: {EUR: 0.9}\nbilling_mode: PROVISIONED\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "9100")
+	t.Setenv("READ_CAPACITY", "5")
+	t.Setenv("WRITE_BUDGET", "20")
+	t.Setenv("ARCHIVE_AFTER_DAYS", "90")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Test file values override defaults
+	if cfg.TableName != "FromFile" {
+		t.Errorf("TableName = %v, want %v", cfg.TableName, "FromFile")
+	}
+	if !cfg.Local {
+		t.Error("Local = false, want true from file")
+	}
+	if cfg.OperationTimeout != 2*time.Second {
+		t.Errorf("OperationTimeout = %v, want 2s from file", cfg.OperationTimeout)
+	}
+	if cfg.ExchangeRates["EUR"] != 0.9 {
+		t.Errorf("ExchangeRates = %v, want EUR 0.9 from file", cfg.ExchangeRates)
+	}
+	if cfg.BillingMode != "PROVISIONED" {
+		t.Errorf("BillingMode = %v, want PROVISIONED from file", cfg.BillingMode)
+	}
+	// Test env values override the file
+	if cfg.Port != 9100 {
+		t.Errorf("Port = %v, want %v", cfg.Port, 9100)
+	}
+	if cfg.ReadCapacity != 5 {
+		t.Errorf("ReadCapacity = %v, want 5 from env", cfg.ReadCapacity)
+	}
+	if cfg.WriteBudget != 20 {
+		t.Errorf("WriteBudget = %v, want 20 from env", cfg.WriteBudget)
+	}
+	if cfg.ArchiveAfterDays != 90 {
+		t.Errorf("ArchiveAfterDays = %v, want 90 from env", cfg.ArchiveAfterDays)
+	}
+	// Test unset values keep their defaults
+	if cfg.Region != "us-east-1" {
+		t.Errorf("Region = %v, want %v", cfg.Region, "us-east-1")
+	}
+
+	level, err := cfg.SlogLevel()
+	if err != nil {
+		t.Fatalf("Failed to parse log level: %v", err)
+	}
+	if level != slog.LevelDebug {
+		t.Errorf("SlogLevel() = %v, want %v", level, slog.LevelDebug)
+	}
+}
+
+func TestLoad_InvalidEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LOCAL_MODE", "sometimes")
+
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid LOCAL_MODE, got nil")
+	}
+}
+
+func TestLoad_LocalStack(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("EMULATOR", "localstack")
+
+	// Test choosing LocalStack moves the default endpoint to its edge port
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Endpoint != LocalStackEndpoint {
+		t.Errorf("Endpoint = %v, want %v", cfg.Endpoint, LocalStackEndpoint)
+	}
+
+	// Test an explicit endpoint is kept
+	t.Setenv("DYNAMODB_ENDPOINT", "http://emulator:9000")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Endpoint != "http://emulator:9000" {
+		t.Errorf("Endpoint = %v, want http://emulator:9000", cfg.Endpoint)
+	}
+}
+
+func TestConfig_LocalEmulator(t *testing.T) {
+	tests := []struct {
+		emulator string
+		endpoint string
+		want     string
+	}{
+		{"", DynamoDBLocalEndpoint, EmulatorDynamoDBLocal},
+		{"", LocalStackEndpoint, EmulatorLocalStack},
+		{"", "http://localstack:4510", EmulatorLocalStack},
+		{"", "http://dynamodb:8000", EmulatorDynamoDBLocal},
+		{EmulatorLocalStack, "http://emulator:9000", EmulatorLocalStack},
+	}
+	for _, tt := range tests {
+		cfg := Config{Emulator: tt.emulator, Endpoint: tt.endpoint}
+		if got := cfg.LocalEmulator(); got != tt.want {
+			t.Errorf("LocalEmulator() for %q at %s = %v, want %v", tt.emulator, tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_PathStyle(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{"aws", Config{Endpoint: LocalStackEndpoint}, false},
+		{"localstack", Config{Local: true, Endpoint: LocalStackEndpoint}, true},
+		{"minio", Config{S3Endpoint: "http://localhost:9000"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.PathStyle(); got != tt.want {
+			t.Errorf("PathStyle() for %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
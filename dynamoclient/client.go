@@ -0,0 +1,89 @@
+package dynamoclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+
+	"LearnSingleTableDesign/config"
+)
+
+// New creates the DynamoDB client used by main, the tests and the tools.
+// In local mode it points at the configured endpoint, DynamoDB Local or
+// LocalStack, with dummy credentials; otherwise it uses the default AWS
+// config chain (env vars, shared config, IAM role). opts customise the
+// client further, e.g. WithMiddleware.
+func New(ctx context.Context, cfg config.Config, opts ...Option) (*dynamodb.Client, error) {
+	awsCfg, err := AWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Local {
+		// Override the endpoint on the service client rather than through
+		// the deprecated global endpoint resolver
+		opts = append([]Option{func(o *dynamodb.Options) {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}}, opts...)
+	}
+	optFns := make([]func(*dynamodb.Options), len(opts))
+	for i, opt := range opts {
+		optFns[i] = opt
+	}
+	return dynamodb.NewFromConfig(awsCfg, optFns...), nil
+}
+
+// NewStreams creates a DynamoDB Streams client for the same DynamoDB as New
+func NewStreams(ctx context.Context, cfg config.Config) (*dynamodbstreams.Client, error) {
+	awsCfg, err := AWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Local {
+		return dynamodbstreams.NewFromConfig(awsCfg), nil
+	}
+	return dynamodbstreams.NewFromConfig(awsCfg, func(o *dynamodbstreams.Options) {
+		o.BaseEndpoint = aws.String(cfg.Endpoint)
+	}), nil
+}
+
+// AWSConfig loads the AWS config the clients are built from: the default
+// chain, or in local mode the emulator's dummy credentials
+func AWSConfig(ctx context.Context, cfg config.Config) (aws.Config, error) {
+	if !cfg.Local {
+		return awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+	}
+	creds, err := localCredentials(cfg.LocalEmulator())
+	if err != nil {
+		return aws.Config{}, err
+	}
+	return awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(cfg.Region),
+		awsconfig.WithCredentialsProvider(credentials.StaticCredentialsProvider{Value: creds}),
+	)
+}
+
+// localCredentials returns the dummy credentials an emulator expects.
+// LocalStack derives its account ID from the access key, and "test" maps to
+// the 000000000000 account its docs and example ARNs use.
+func localCredentials(emulator string) (aws.Credentials, error) {
+	switch emulator {
+	case config.EmulatorDynamoDBLocal:
+		return aws.Credentials{
+			AccessKeyID: "dummy", SecretAccessKey: "dummy", SessionToken: "dummy",
+			Source: "Hard-coded credentials; DO NOT use in production",
+		}, nil
+	case config.EmulatorLocalStack:
+		return aws.Credentials{
+			AccessKeyID: "test", SecretAccessKey: "test",
+			Source: "Hard-coded credentials; DO NOT use in production",
+		}, nil
+	case config.EmulatorFake:
+		return aws.Credentials{}, fmt.Errorf("the %s emulator is only available to tests", emulator)
+	}
+	return aws.Credentials{}, fmt.Errorf("unknown emulator %q", emulator)
+}
@@ -0,0 +1,105 @@
+package dynamoclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/middleware"
+
+	"LearnSingleTableDesign/config"
+)
+
+func TestNew_Local(t *testing.T) {
+	cfg := config.Default()
+	cfg.Local = true
+	cfg.Endpoint = "http://localhost:9999"
+
+	client, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	opts := client.Options()
+	if opts.BaseEndpoint == nil || *opts.BaseEndpoint != cfg.Endpoint {
+		t.Errorf("BaseEndpoint = %v, want %v", opts.BaseEndpoint, cfg.Endpoint)
+	}
+	if opts.Region != cfg.Region {
+		t.Errorf("Region = %v, want %v", opts.Region, cfg.Region)
+	}
+}
+
+func TestNew_AWS(t *testing.T) {
+	cfg := config.Default()
+
+	client, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if opts := client.Options(); opts.BaseEndpoint != nil {
+		t.Errorf("BaseEndpoint = %v, want nil in AWS mode", *opts.BaseEndpoint)
+	}
+}
+func TestNew_LocalStack(t *testing.T) {
+	cfg := config.Default()
+	cfg.Local = true
+	cfg.Endpoint = config.LocalStackEndpoint
+
+	client, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	creds, err := client.Options().Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to retrieve credentials: %v", err)
+	}
+	if creds.AccessKeyID != "test" {
+		t.Errorf("AccessKeyID = %v, want test for LocalStack", creds.AccessKeyID)
+	}
+
+	// Test an unknown emulator is refused
+	cfg.Emulator = "cosmos"
+	if _, err := New(context.Background(), cfg); err == nil {
+		t.Error("Expected error for an unknown emulator, got nil")
+	}
+}
+
+func TestWithMiddleware(t *testing.T) {
+	cfg := config.Default()
+	cfg.Local = true
+	cfg.Endpoint = "http://localhost:9999"
+
+	// Test middleware can fail requests before they are sent, and that
+	// logged requests name their operation
+	injected := errors.New("injected fault")
+	fail := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("Fail", func(
+			ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+		) (middleware.InitializeOutput, middleware.Metadata, error) {
+			return middleware.InitializeOutput{}, middleware.Metadata{}, injected
+		}), middleware.Before)
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client, err := New(context.Background(), cfg, WithMiddleware(fail, LogRequests(logger)))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	_, err = client.GetItem(context.Background(), &dynamodb.GetItemInput{TableName: aws.String("t")})
+	if !errors.Is(err, injected) {
+		t.Errorf("Expected the injected fault, got %v", err)
+	}
+	if !strings.Contains(logs.String(), "operation=GetItem") || !strings.Contains(logs.String(), "injected fault") {
+		t.Errorf("Logged %q, want the operation and its error", logs.String())
+	}
+	if opts := client.Options(); opts.BaseEndpoint == nil || *opts.BaseEndpoint != cfg.Endpoint {
+		t.Errorf("BaseEndpoint = %v, want %v alongside the options", opts.BaseEndpoint, cfg.Endpoint)
+	}
+}
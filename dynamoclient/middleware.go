@@ -0,0 +1,58 @@
+package dynamoclient
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Option customises the client New builds
+type Option func(*dynamodb.Options)
+
+// WithMiddleware adds smithy middleware to the stack of every request the
+// client sends, for logging, fault injection in tests and the like. Each
+// function registers its middleware on the stack at the step it needs.
+func WithMiddleware(fns ...func(*middleware.Stack) error) Option {
+	return func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, fns...)
+	}
+}
+
+// WithRetryer replaces the client's retry policy, e.g. with
+// retry.AddWithMaxAttempts(retry.NewStandard(), 10) for bulk jobs that
+// would rather wait out throttling than fail
+func WithRetryer(retryer func() aws.Retryer) Option {
+	return func(o *dynamodb.Options) {
+		o.Retryer = retryer()
+	}
+}
+
+// LogRequests logs every DynamoDB call at debug level with how long it
+// took, retries included, and its error if it failed
+func LogRequests(logger *slog.Logger) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("LogRequests", func(
+			ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+		) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			attrs := []any{
+				"operation", middleware.GetOperationName(ctx),
+				"duration", time.Since(start),
+			}
+			if requestID, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+				attrs = append(attrs, "request_id", requestID)
+			}
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			}
+			logger.DebugContext(ctx, "dynamodb request", attrs...)
+			return out, metadata, err
+		}), middleware.Before)
+	}
+}
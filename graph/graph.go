@@ -0,0 +1,256 @@
+// Package graph serves a read-only GraphQL API over users, their orders and
+// the products in them. Products are read through a per-request Loader, so
+// a query over many orders fetches their products with batched gets rather
+// than one read per line item.
+package graph
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+//go:embed schema.graphql
+var schemaSDL string
+
+// loadWait is how long a product batch waits for more keys to join it
+const loadWait = 2 * time.Millisecond
+
+// maxDepth bounds how deeply a query can nest
+const maxDepth = 10
+
+// Server executes GraphQL queries sent as GET parameters or a POST JSON
+// body
+type Server struct {
+	schema   *graphql.Schema
+	products repository.Products
+}
+
+// NewServer creates a Server reading from the given repositories
+func NewServer(users repository.Users, orders repository.Orders, products repository.Products) *Server {
+	resolver := &Resolver{users: users, orders: orders}
+	return &Server{
+		schema:   graphql.MustParseSchema(schemaSDL, resolver, graphql.MaxDepth(maxDepth)),
+		products: products,
+	}
+}
+
+// request is a GraphQL request
+type request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				http.Error(w, "invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Query == "" {
+		http.Error(w, "missing query", http.StatusBadRequest)
+		return
+	}
+
+	ctx := withProductLoader(r.Context(), s.products)
+	response := s.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type productLoaderKey struct{}
+
+// withProductLoader gives the request its own product Loader
+func withProductLoader(ctx context.Context, products repository.Products) context.Context {
+	loader := NewLoader(loadWait, func(ctx context.Context, ids []string) (map[string]models.Product, error) {
+		return products.GetMany(ctx, ids)
+	})
+	return context.WithValue(ctx, productLoaderKey{}, loader)
+}
+
+func productLoader(ctx context.Context) *Loader[string, models.Product] {
+	return ctx.Value(productLoaderKey{}).(*Loader[string, models.Product])
+}
+
+// Resolver resolves the Query type
+type Resolver struct {
+	users  repository.Users
+	orders repository.Orders
+}
+
+func (r *Resolver) User(ctx context.Context, args struct{ Email string }) (*userResolver, error) {
+	aggregate, err := r.users.GetUserWithOrders(ctx, models.NormalizeEmail(args.Email))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{aggregate: *aggregate}, nil
+}
+
+func (r *Resolver) Order(ctx context.Context, args struct {
+	Email string
+	ID    graphql.ID
+}) (*orderResolver, error) {
+	order, err := r.orders.Get(ctx, models.NormalizeEmail(args.Email), string(args.ID))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &orderResolver{order: *order}, nil
+}
+
+func (r *Resolver) Product(ctx context.Context, args struct{ ID graphql.ID }) (*productResolver, error) {
+	product, ok, err := productLoader(ctx).Load(ctx, string(args.ID))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &productResolver{product: product}, nil
+}
+
+type userResolver struct {
+	aggregate repository.UserAggregate
+}
+
+func (u *userResolver) Email() string { return u.aggregate.User.Email }
+
+func (u *userResolver) Name() string { return u.aggregate.User.Name }
+
+func (u *userResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: u.aggregate.User.CreatedAt}
+}
+
+// Orders returns the user's orders newest first, queueing every product
+// they list into one batch before their line items ask for them
+func (u *userResolver) Orders(ctx context.Context, args struct{ First *int32 }) []*orderResolver {
+	orders := append([]models.Order(nil), u.aggregate.Orders...)
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt) })
+	if args.First != nil && int(*args.First) >= 0 && int(*args.First) < len(orders) {
+		orders = orders[:*args.First]
+	}
+
+	resolvers := make([]*orderResolver, len(orders))
+	var productIDs []string
+	for i, order := range orders {
+		resolvers[i] = &orderResolver{order: order}
+		for _, item := range lineItems(order) {
+			productIDs = append(productIDs, item.ProductID)
+		}
+	}
+	productLoader(ctx).Expect(ctx, productIDs...)
+	return resolvers
+}
+
+type orderResolver struct {
+	order models.Order
+}
+
+func (o *orderResolver) ID() graphql.ID { return graphql.ID(o.order.OrderID) }
+
+func (o *orderResolver) UserEmail() string { return o.order.UserEmail }
+
+func (o *orderResolver) Status() string { return string(o.order.Status) }
+
+func (o *orderResolver) Total() float64 { return o.order.Total }
+
+func (o *orderResolver) Currency() string { return o.order.PriceCurrency() }
+
+func (o *orderResolver) CreatedAt() graphql.Time { return graphql.Time{Time: o.order.CreatedAt} }
+
+// Items returns the order's line items, queueing their products into one
+// batch
+func (o *orderResolver) Items(ctx context.Context) []*lineItemResolver {
+	items := lineItems(o.order)
+	resolvers := make([]*lineItemResolver, len(items))
+	ids := make([]string, len(items))
+	for i, item := range items {
+		resolvers[i] = &lineItemResolver{item: item}
+		ids[i] = item.ProductID
+	}
+	productLoader(ctx).Expect(ctx, ids...)
+	return resolvers
+}
+
+// lineItems returns the order's items. Orders from before line items only
+// list product IDs, which become unpriced items named by their ID.
+func lineItems(order models.Order) []models.LineItem {
+	if len(order.Items) > 0 || len(order.LegacyProducts) == 0 {
+		return order.Items
+	}
+	var items []models.LineItem
+	index := make(map[string]int)
+	for _, id := range order.LegacyProducts {
+		if i, ok := index[id]; ok {
+			items[i].Quantity++
+			continue
+		}
+		index[id] = len(items)
+		items = append(items, models.LineItem{ProductID: id, Name: id, Quantity: 1})
+	}
+	return items
+}
+
+type lineItemResolver struct {
+	item models.LineItem
+}
+
+func (l *lineItemResolver) ProductID() graphql.ID { return graphql.ID(l.item.ProductID) }
+
+func (l *lineItemResolver) Name() string { return l.item.Name }
+
+func (l *lineItemResolver) Quantity() int32 { return int32(l.item.Quantity) }
+
+func (l *lineItemResolver) UnitPrice() float64 { return l.item.UnitPrice }
+
+func (l *lineItemResolver) Product(ctx context.Context) (*productResolver, error) {
+	product, ok, err := productLoader(ctx).Load(ctx, l.item.ProductID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &productResolver{product: product}, nil
+}
+
+type productResolver struct {
+	product models.Product
+}
+
+func (p *productResolver) ID() graphql.ID { return graphql.ID(p.product.ProductID) }
+
+func (p *productResolver) Name() string { return p.product.Name }
+
+func (p *productResolver) Category() string { return p.product.Category }
+
+func (p *productResolver) Price() float64 { return p.product.Price }
+
+func (p *productResolver) Currency() string { return p.product.PriceCurrency() }
+
+func (p *productResolver) Stock() int32 { return int32(p.product.Stock) }
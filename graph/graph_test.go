@@ -0,0 +1,153 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+// countingProducts records each batch of IDs read through GetMany
+type countingProducts struct {
+	repository.Products
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (c *countingProducts) GetMany(ctx context.Context, productIDs []string) (map[string]models.Product, error) {
+	c.mu.Lock()
+	c.batches = append(c.batches, append([]string(nil), productIDs...))
+	c.mu.Unlock()
+	return c.Products.GetMany(ctx, productIDs)
+}
+
+func TestServer_BatchesProducts(t *testing.T) {
+	client := testutil.CreateTestClient(t)
+	tableName := testutil.SetupTestTable(t, client)
+	defer testutil.CleanupTestTable(t, client, tableName)
+
+	users := repository.NewUserRepository(client, tableName)
+	orders := repository.NewOrderRepository(client, tableName)
+	products := &countingProducts{Products: repository.NewProductRepository(client, tableName)}
+
+	userFixture := fixtures.NewUser().WithEmail("ann@example.com")
+	user := userFixture.Build()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	fixtures.Seed(t, fixtures.Repos{Users: users, Orders: orders, Products: products},
+		userFixture,
+		fixtures.NewProduct().WithID("P1").WithName("Mug"),
+		fixtures.NewProduct().WithID("P2").WithName("Cap"),
+		fixtures.NewOrderFor(user).WithID("ORD1").WithProducts("P1", "P2").WithCreatedAt(start),
+		fixtures.NewOrderFor(user).WithID("ORD2").WithProducts("P2", "GONE").WithCreatedAt(start.Add(time.Hour)),
+	)
+
+	server := NewServer(users, orders, products)
+	query := `{ user(email: "Ann@Example.com") { name orders { id items { productId product { name } } } } }`
+	r := httptest.NewRequest(http.MethodGet, "/graphql?"+url.Values{"query": {query}}.Encode(), nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	var response struct {
+		Errors []any
+		Data   struct {
+			User struct {
+				Name   string
+				Orders []struct {
+					ID    string
+					Items []struct {
+						ProductID string
+						Product   *struct{ Name string }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.Errors) > 0 {
+		t.Fatalf("Response %s, %v", w.Body, err)
+	}
+	got := response.Data.User
+	if got.Name != user.Name || len(got.Orders) != 2 || got.Orders[0].ID != "ORD2" {
+		t.Fatalf("User = %+v, want both orders newest first", got)
+	}
+	if item := got.Orders[1].Items[0]; item.ProductID != "P1" || item.Product == nil || item.Product.Name != "Mug" {
+		t.Errorf("First item of ORD1 = %+v, want the mug", item)
+	}
+	if item := got.Orders[0].Items[1]; item.ProductID != "GONE" || item.Product != nil {
+		t.Errorf("Deleted product resolved to %+v, want null", item.Product)
+	}
+
+	// Test every product of every order was read in one batch, once each
+	if len(products.batches) != 1 {
+		t.Fatalf("GetMany called %d times (%v), want once", len(products.batches), products.batches)
+	}
+	batch := products.batches[0]
+	sort.Strings(batch)
+	if strings.Join(batch, ",") != "GONE,P1,P2" {
+		t.Errorf("Batch = %v, want each product once", batch)
+	}
+}
+
+func TestServer_BadRequests(t *testing.T) {
+	server := NewServer(nil, nil, nil)
+	for name, r := range map[string]*http.Request{
+		"no query":      httptest.NewRequest(http.MethodGet, "/graphql", nil),
+		"invalid body":  httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader("{")),
+		"bad variables": httptest.NewRequest(http.MethodGet, "/graphql?query=x&variables=nope", nil),
+	} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, w.Code)
+		}
+	}
+}
+
+func TestLoader(t *testing.T) {
+	var mu sync.Mutex
+	var calls [][]int
+	loader := NewLoader(10*time.Millisecond, func(ctx context.Context, keys []int) (map[int]string, error) {
+		mu.Lock()
+		calls = append(calls, keys)
+		mu.Unlock()
+		values := make(map[int]string)
+		for _, key := range keys {
+			if key != 0 {
+				values[key] = strings.Repeat("x", key)
+			}
+		}
+		return values, nil
+	})
+
+	// Test concurrent loads share one fetch, duplicates included
+	var wg sync.WaitGroup
+	for _, key := range []int{1, 2, 2, 3, 0} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, ok, err := loader.Load(context.Background(), key)
+			if err != nil || ok != (key != 0) || value != strings.Repeat("x", key) {
+				t.Errorf("Load(%d) = %q, %v, %v", key, value, ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(calls) != 1 || len(calls[0]) != 4 {
+		t.Fatalf("Fetched %v, want one batch of the 4 distinct keys", calls)
+	}
+
+	// Test loaded keys are cached
+	if value, _, _ := loader.Load(context.Background(), 3); value != "xxx" || len(calls) != 1 {
+		t.Errorf("Load of a cached key = %q after %d fetches, want no new fetch", value, len(calls))
+	}
+}
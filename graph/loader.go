@@ -0,0 +1,90 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Loader is a DataLoader: the keys loaded within wait of each other are
+// fetched together with one call, and each key is fetched at most once.
+// A Loader caches for its whole life, so make one per request.
+type Loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+	wait  time.Duration
+
+	mu      sync.Mutex
+	batches map[K]*batch[K, V]
+	next    *batch[K, V]
+}
+
+// batch is one call of a Loader's fetch
+type batch[K comparable, V any] struct {
+	keys   []K
+	done   chan struct{}
+	values map[K]V
+	err    error
+}
+
+// NewLoader creates a Loader that fetches with fetch, waiting wait after
+// the first key of a batch for more to join it
+func NewLoader[K comparable, V any](wait time.Duration, fetch func(ctx context.Context, keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{fetch: fetch, wait: wait, batches: make(map[K]*batch[K, V])}
+}
+
+// Expect adds keys to the next batch without waiting for them, so a
+// resolver can batch what its children will load before they ask
+func (l *Loader[K, V]) Expect(ctx context.Context, keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.add(ctx, key)
+	}
+}
+
+// Load returns the value for key, waiting for its batch to be fetched.
+// ok is false when the fetch had no value for key.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (value V, ok bool, err error) {
+	l.mu.Lock()
+	b := l.add(ctx, key)
+	l.mu.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return value, false, ctx.Err()
+	}
+	if b.err != nil {
+		return value, false, b.err
+	}
+	value, ok = b.values[key]
+	return value, ok, nil
+}
+
+// add puts key in the next batch unless it is already in one, starting the
+// batch's timer if it's new. l.mu must be held.
+func (l *Loader[K, V]) add(ctx context.Context, key K) *batch[K, V] {
+	if b, ok := l.batches[key]; ok {
+		return b
+	}
+	if l.next == nil {
+		l.next = &batch[K, V]{done: make(chan struct{})}
+		go l.dispatch(ctx, l.next)
+	}
+	l.next.keys = append(l.next.keys, key)
+	l.batches[key] = l.next
+	return l.next
+}
+
+// dispatch fetches b once its wait is up
+func (l *Loader[K, V]) dispatch(ctx context.Context, b *batch[K, V]) {
+	time.Sleep(l.wait)
+	l.mu.Lock()
+	if l.next == b {
+		l.next = nil
+	}
+	l.mu.Unlock()
+
+	b.values, b.err = l.fetch(ctx, b.keys)
+	close(b.done)
+}
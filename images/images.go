@@ -0,0 +1,158 @@
+// Package images stores uploaded product images, in an S3 bucket or a local
+// directory. Images are served by the app from URLPrefix, so buckets can
+// stay private.
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"LearnSingleTableDesign/s3"
+)
+
+// MaxSize is the largest image Upload accepts, in bytes
+const MaxSize = 5 << 20
+
+// URLPrefix is where the app serves stored images
+const URLPrefix = "/images/"
+
+var (
+	// ErrNotFound means no image is stored under the key
+	ErrNotFound = errors.New("image not found")
+	// ErrTooLarge means an upload is over MaxSize
+	ErrTooLarge = fmt.Errorf("image is larger than %d MiB", MaxSize>>20)
+	// ErrUnsupportedType means an upload isn't a JPEG, PNG, GIF or WebP
+	ErrUnsupportedType = errors.New("image must be a JPEG, PNG, GIF or WebP")
+)
+
+// extensions are the accepted image types and the extension each is
+// stored with
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Store keeps image bytes by key
+type Store interface {
+	Put(ctx context.Context, key, contentType string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Upload checks an uploaded product image and stores it, returning the URL
+// it is served from. The key includes a hash of the image, so a new image
+// gets a new URL and browsers can cache them forever.
+func Upload(ctx context.Context, store Store, productID string, r io.Reader) (string, error) {
+	body, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	if len(body) > MaxSize {
+		return "", ErrTooLarge
+	}
+	contentType := http.DetectContentType(body)
+	ext, ok := extensions[contentType]
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+
+	hash := sha256.Sum256(body)
+	key := path.Join("products", productID, hex.EncodeToString(hash[:8])+ext)
+	if err := store.Put(ctx, key, contentType, body); err != nil {
+		return "", fmt.Errorf("failed to store image: %w", err)
+	}
+	return URLPrefix + key, nil
+}
+
+// ContentType is the type of a stored image, from its key's extension
+func ContentType(key string) string {
+	ext := path.Ext(key)
+	for contentType, e := range extensions {
+		if e == ext {
+			return contentType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// ObjectStore is the part of s3.Client a Bucket needs
+type ObjectStore interface {
+	Put(ctx context.Context, bucket, key, contentType string, body []byte) error
+	Get(ctx context.Context, bucket, key string) ([]byte, error)
+}
+
+// Bucket stores images in an S3 bucket
+type Bucket struct {
+	objects ObjectStore
+	bucket  string
+}
+
+// NewBucket creates a Bucket storing images in bucket
+func NewBucket(objects ObjectStore, bucket string) *Bucket {
+	return &Bucket{objects: objects, bucket: bucket}
+}
+
+func (b *Bucket) Put(ctx context.Context, key, contentType string, body []byte) error {
+	return b.objects.Put(ctx, b.bucket, key, contentType, body)
+}
+
+func (b *Bucket) Get(ctx context.Context, key string) ([]byte, error) {
+	body, err := b.objects.Get(ctx, b.bucket, key)
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return body, err
+}
+
+// Dir stores images as files under a local directory, for development and
+// the SQLite backend
+type Dir string
+
+func (d Dir) Put(ctx context.Context, key, contentType string, body []byte) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create image directory: %w", err)
+	}
+	if err := os.WriteFile(name, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write image: %w", err)
+	}
+	return nil
+}
+
+func (d Dir) Get(ctx context.Context, key string) ([]byte, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return body, nil
+}
+
+// path is the file a key is stored in. Keys come from URLs, so ones that
+// would leave the directory are treated as missing.
+func (d Dir) path(key string) (string, error) {
+	name := filepath.FromSlash(key)
+	if !filepath.IsLocal(name) || strings.HasPrefix(key, "/") {
+		return "", ErrNotFound
+	}
+	return filepath.Join(string(d), name), nil
+}
@@ -0,0 +1,54 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// png is the start of a PNG file, enough for its type to be detected
+var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestUpload(t *testing.T) {
+	store := Dir(t.TempDir())
+	ctx := context.Background()
+
+	url, err := Upload(ctx, store, "PROD1", bytes.NewReader(png))
+	if err != nil {
+		t.Fatalf("Failed to upload image: %v", err)
+	}
+	if !strings.HasPrefix(url, "/images/products/PROD1/") || !strings.HasSuffix(url, ".png") {
+		t.Errorf("URL = %s, want a PNG under /images/products/PROD1/", url)
+	}
+	key := strings.TrimPrefix(url, URLPrefix)
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+	if !bytes.Equal(body, png) {
+		t.Error("Stored image doesn't match the upload")
+	}
+	if got := ContentType(key); got != "image/png" {
+		t.Errorf("ContentType() = %s, want image/png", got)
+	}
+
+	// Test what isn't an image, or is too large, is rejected
+	if _, err := Upload(ctx, store, "PROD1", strings.NewReader("<html></html>")); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, got %v", err)
+	}
+	large := append(bytes.Clone(png), make([]byte, MaxSize)...)
+	if _, err := Upload(ctx, store, "PROD1", bytes.NewReader(large)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestDir_Get(t *testing.T) {
+	store := Dir(t.TempDir())
+	for _, key := range []string{"missing.png", "../secret", "/etc/passwd"} {
+		if _, err := store.Get(context.Background(), key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", key, err)
+		}
+	}
+}
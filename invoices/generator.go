@@ -0,0 +1,117 @@
+package invoices
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// ObjectStore is where invoices are saved, e.g. an s3.Client
+type ObjectStore interface {
+	Put(ctx context.Context, bucket, key, contentType string, body []byte) error
+}
+
+// KeyRecorder is the part of repository.OrderRepository a Generator needs
+type KeyRecorder interface {
+	SetInvoiceKey(ctx context.Context, userEmail, orderID, key string) error
+}
+
+// Generator stores an invoice for each order that completes. Register Hook
+// on the order store and start Run in a goroutine.
+type Generator struct {
+	objects ObjectStore
+	bucket  string
+	orders  KeyRecorder
+	pending chan models.Order
+}
+
+// NewGenerator creates a Generator saving to bucket that queues up to buffer
+// invoices
+func NewGenerator(objects ObjectStore, bucket string, orders KeyRecorder, buffer int) *Generator {
+	return &Generator{
+		objects: objects,
+		bucket:  bucket,
+		orders:  orders,
+		pending: make(chan models.Order, buffer),
+	}
+}
+
+// Hook queues an invoice for every completed order put through the store
+// that doesn't have one yet; register it with repository.OnPut. It never
+// blocks a write: when the queue is full the invoice is dropped with a
+// warning.
+func (g *Generator) Hook() repository.ChangeHook {
+	return repository.Typed(repository.EntityOrder, func(ctx context.Context, change repository.Change, item repository.GenericItem[models.Order]) {
+		order := item.Data
+		if change.Phase != repository.AfterWrite || order.Status != models.OrderStatusCompleted || order.InvoiceKey != "" {
+			return
+		}
+		select {
+		case g.pending <- order:
+		default:
+			slog.Warn("invoice queue full, dropping invoice", "order_id", order.OrderID)
+		}
+	})
+}
+
+// Run generates queued invoices until ctx is done
+func (g *Generator) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case order := <-g.pending:
+			if err := g.Generate(ctx, order); err != nil {
+				slog.Error("failed to generate invoice", "order_id", order.OrderID, "error", err)
+			}
+		}
+	}
+}
+
+// Generate renders an order's invoice, stores it and records its key on the
+// order. Generating an invoice again overwrites it.
+func (g *Generator) Generate(ctx context.Context, order models.Order) error {
+	body, err := Render(order)
+	if err != nil {
+		return err
+	}
+	key := Key(order)
+	if err := g.objects.Put(ctx, g.bucket, key, ContentType, body); err != nil {
+		return err
+	}
+	if err := g.orders.SetInvoiceKey(ctx, order.UserEmail, order.OrderID, key); err != nil {
+		return fmt.Errorf("failed to record invoice for order %s: %w", order.OrderID, err)
+	}
+	return nil
+}
+
+// Presigner creates download URLs, e.g. an s3.Client
+type Presigner interface {
+	PresignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error)
+}
+
+// Links creates download URLs for stored invoices
+type Links struct {
+	objects Presigner
+	bucket  string
+	// Expiry is how long a URL works for
+	Expiry time.Duration
+}
+
+// NewLinks creates Links for invoices in bucket, valid for 15 minutes
+func NewLinks(objects Presigner, bucket string) *Links {
+	return &Links{objects: objects, bucket: bucket, Expiry: 15 * time.Minute}
+}
+
+// URL returns a signed URL downloading an order's invoice. Orders without
+// an invoice return an empty URL.
+func (l *Links) URL(ctx context.Context, order models.Order) (string, error) {
+	if order.InvoiceKey == "" {
+		return "", nil
+	}
+	return l.objects.PresignGet(ctx, l.bucket, order.InvoiceKey, l.Expiry)
+}
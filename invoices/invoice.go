@@ -0,0 +1,71 @@
+// Package invoices renders an HTML invoice for each completed order and
+// stores it in S3. A Generator does the work off the order store's put
+// hooks, and Links hands out short-lived download URLs.
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// ContentType is the media type invoices are stored with
+const ContentType = "text/html; charset=utf-8"
+
+// Key is where an order's invoice is stored in the bucket
+func Key(order models.Order) string {
+	return fmt.Sprintf("invoices/%s.html", order.OrderID)
+}
+
+// Render renders an order's invoice as a standalone HTML page
+func Render(order models.Order) ([]byte, error) {
+	currency := order.PriceCurrency()
+	var rows []Node
+	for _, item := range order.Items {
+		rows = append(rows, Tr(
+			Td(Text(item.Name)),
+			Td(Class("num"), Text(strconv.Itoa(item.Quantity))),
+			Td(Class("num"), Text(money.Format(item.UnitPrice, currency))),
+			Td(Class("num"), Text(money.Format(item.UnitPrice*float64(item.Quantity), currency))),
+		))
+	}
+	// Orders from before line items only list product IDs
+	for _, productID := range order.LegacyProducts {
+		rows = append(rows, Tr(Td(Text(productID)), Td(Class("num"), Text("1")), Td(), Td()))
+	}
+
+	page := Doctype(HTML(
+		Lang("en"),
+		Head(
+			Meta(Charset("utf-8")),
+			TitleEl(Textf("Invoice for order %s", order.OrderID)),
+			StyleEl(Raw(`body{font-family:sans-serif;max-width:40rem;margin:2rem auto}table{width:100%;border-collapse:collapse}th,td{padding:.25rem;border-bottom:1px solid #ddd;text-align:left}.num{text-align:right}`)),
+		),
+		Body(
+			H1(Text("Invoice")),
+			P(Textf("Order %s", order.OrderID)),
+			P(Textf("Billed to %s", order.UserEmail)),
+			P(Textf("Placed %s", order.CreatedAt.UTC().Format("2 January 2006"))),
+			Table(
+				THead(Tr(Th(Text("Item")), Th(Class("num"), Text("Quantity")), Th(Class("num"), Text("Unit price")), Th(Class("num"), Text("Amount")))),
+				TBody(rows...),
+				TFoot(Tr(Th(ColSpan("3"), Text("Total")), Th(Class("num"), Text(money.Format(order.Total, currency))))),
+			),
+		),
+	))
+
+	var b bytes.Buffer
+	if err := page.Render(&b); err != nil {
+		return nil, fmt.Errorf("failed to render invoice for order %s: %w", order.OrderID, err)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package invoices
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+// memoryStore is an ObjectStore keeping objects in a map
+type memoryStore map[string][]byte
+
+func (m memoryStore) Put(ctx context.Context, bucket, key, contentType string, body []byte) error {
+	m[bucket+"/"+key] = body
+	return nil
+}
+
+// recorder records invoice keys by order ID
+type recorder map[string]string
+
+func (r recorder) SetInvoiceKey(ctx context.Context, userEmail, orderID, key string) error {
+	r[orderID] = key
+	return nil
+}
+
+func TestRender(t *testing.T) {
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).WithID("ORD1").WithProducts("PROD1").Build()
+	order.Items[0].Quantity = 2
+
+	body, err := Render(order)
+	if err != nil {
+		t.Fatalf("Failed to render invoice: %v", err)
+	}
+	for _, want := range []string{"<!doctype html>", "Order ORD1", "Billed to " + order.UserEmail, "<td class=\"num\">2</td>"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Invoice doesn't contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	store := memoryStore{}
+	keys := recorder{}
+	generator := NewGenerator(store, "invoices", keys, 10)
+	hook := generator.Hook()
+
+	put := func(order models.Order, phase repository.Phase) {
+		av, err := attributevalue.MarshalMap(repository.GenericItem[models.Order]{
+			PK: repository.Key.UserPK(order.UserEmail), SK: repository.Key.OrderSK(order.OrderID),
+			EntityType: repository.EntityOrder, Data: order,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hook(context.Background(), repository.Change{
+			Operation: repository.OperationPut, Phase: phase,
+			EntityType: repository.EntityOrder, Item: av,
+		})
+	}
+	user := fixtures.NewUser().Build()
+	completed := fixtures.NewOrderFor(user).WithID("DONE").WithStatus(models.OrderStatusCompleted).Build()
+	invoiced := fixtures.NewOrderFor(user).WithID("INVOICED").WithStatus(models.OrderStatusCompleted).Build()
+	invoiced.InvoiceKey = Key(invoiced)
+
+	// Only completed orders without an invoice are queued, once written
+	put(completed, repository.BeforeWrite)
+	put(fixtures.NewOrderFor(user).WithID("PENDING").Build(), repository.AfterWrite)
+	put(invoiced, repository.AfterWrite)
+	put(completed, repository.AfterWrite)
+
+	if len(generator.pending) != 1 {
+		t.Fatalf("Queued %d invoices, want 1", len(generator.pending))
+	}
+	if err := generator.Generate(context.Background(), <-generator.pending); err != nil {
+		t.Fatalf("Failed to generate invoice: %v", err)
+	}
+	if keys["DONE"] != "invoices/DONE.html" {
+		t.Errorf("Recorded keys %v, want DONE's", keys)
+	}
+	if _, ok := store["invoices/invoices/DONE.html"]; !ok {
+		t.Errorf("Invoice wasn't stored, store holds %d objects", len(store))
+	}
+}
@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// HoldReleaser is the part of repository.ProductRepository the reconciler needs
+type HoldReleaser interface {
+	ReleaseExpiredHolds(ctx context.Context, now time.Time, limit int32) (int, error)
+}
+
+// HoldReconciler periodically returns the stock of expired inventory holds.
+// DynamoDB's TTL deletes expired items on its own schedule and can't change
+// other items, so expired holds have to be released explicitly; TTL only
+// cleans up afterwards. Every app instance can run one, since releasing is
+// conditional on the hold still being active.
+type HoldReconciler struct {
+	holds HoldReleaser
+	// Interval is how often expired holds are looked for
+	Interval time.Duration
+	// BatchSize caps the holds released per pass; a full batch starts the
+	// next pass straight away
+	BatchSize int32
+}
+
+// NewHoldReconciler creates a HoldReconciler that checks every minute
+func NewHoldReconciler(holds HoldReleaser) *HoldReconciler {
+	return &HoldReconciler{
+		holds:     holds,
+		Interval:  time.Minute,
+		BatchSize: 100,
+	}
+}
+
+// Run releases expired holds until ctx is done
+func (r *HoldReconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		for ctx.Err() == nil {
+			released, err := r.RunOnce(ctx)
+			if err != nil {
+				slog.Error("failed to release expired holds", "error", err)
+			}
+			if err != nil || released < int(r.BatchSize) {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce releases one batch of expired holds, reporting how many it released
+func (r *HoldReconciler) RunOnce(ctx context.Context) (int, error) {
+	released, err := r.holds.ReleaseExpiredHolds(ctx, time.Now(), r.BatchSize)
+	if released > 0 {
+		slog.Info("released expired inventory holds", "count", released)
+	}
+	return released, err
+}
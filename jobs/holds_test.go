@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeHolds has a number of expired holds and releases them in batches
+type fakeHolds struct {
+	expired int
+	passes  int
+}
+
+func (h *fakeHolds) ReleaseExpiredHolds(ctx context.Context, now time.Time, limit int32) (int, error) {
+	h.passes++
+	released := min(h.expired, int(limit))
+	h.expired -= released
+	return released, nil
+}
+
+func TestHoldReconciler_DrainsFullBatches(t *testing.T) {
+	holds := &fakeHolds{expired: 5}
+	reconciler := NewHoldReconciler(holds)
+	reconciler.BatchSize = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reconciler.Run(ctx)
+		close(done)
+	}()
+	// The first pass runs straight away and keeps going while batches are full
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if holds.expired != 0 || holds.passes != 3 {
+		t.Errorf("expired = %d after %d passes, want 0 after 3", holds.expired, holds.passes)
+	}
+}
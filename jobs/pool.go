@@ -0,0 +1,124 @@
+// Package jobs runs background work queued in the table by
+// repository.JobRepository, and periodic upkeep like releasing expired
+// inventory holds.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// Queue is the part of repository.JobRepository the pool needs
+type Queue interface {
+	Claim(ctx context.Context, lease time.Duration) (*models.Job, error)
+	Complete(ctx context.Context, job models.Job) error
+	Fail(ctx context.Context, job models.Job, jobErr error, retryAfter time.Duration) error
+}
+
+// Handler runs one job. Returning an error retries the job until it runs
+// out of attempts.
+type Handler func(ctx context.Context, job models.Job) error
+
+// Pool claims jobs from a Queue and runs them on a fixed number of workers
+type Pool struct {
+	queue    Queue
+	workers  int
+	handlers map[string]Handler
+	// Lease is how long a worker owns a job; handlers are cancelled when
+	// it runs out so another worker can safely pick the job up
+	Lease time.Duration
+	// PollInterval is how long an idle worker waits before claiming again
+	PollInterval time.Duration
+	// Backoff is the delay before the first retry, doubled for each attempt
+	Backoff time.Duration
+}
+
+// NewPool creates a Pool running workers jobs at a time
+func NewPool(queue Queue, workers int) *Pool {
+	return &Pool{
+		queue:        queue,
+		workers:      workers,
+		handlers:     make(map[string]Handler),
+		Lease:        time.Minute,
+		PollInterval: time.Second,
+		Backoff:      5 * time.Second,
+	}
+}
+
+// Handle registers the handler for a job type. It must be called before Run.
+func (p *Pool) Handle(jobType string, handler Handler) {
+	p.handlers[jobType] = handler
+}
+
+// Run works through the queue until ctx is done, then waits for running
+// jobs to finish
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		ran, err := p.RunOne(ctx)
+		if err != nil {
+			slog.Error("failed to claim job", "error", err)
+		}
+		if ran {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.PollInterval):
+		}
+	}
+}
+
+// RunOne claims and runs a single job, reporting whether there was one
+func (p *Pool) RunOne(ctx context.Context) (bool, error) {
+	job, err := p.queue.Claim(ctx, p.Lease)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	jobErr := p.run(ctx, *job)
+	if jobErr == nil {
+		err = p.queue.Complete(ctx, *job)
+	} else {
+		slog.Warn("job failed", "job_id", job.JobID, "type", job.Type, "attempt", job.Attempts, "error", jobErr)
+		err = p.queue.Fail(ctx, *job, jobErr, p.Backoff<<(job.Attempts-1))
+	}
+	if errors.Is(err, repository.ErrLeaseLost) {
+		slog.Warn("job lease expired before it finished", "job_id", job.JobID, "type", job.Type)
+		err = nil
+	}
+	return true, err
+}
+
+func (p *Pool) run(ctx context.Context, job models.Job) (err error) {
+	handler, ok := p.handlers[job.Type]
+	if !ok {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("job panicked: %v", v)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, p.Lease)
+	defer cancel()
+	return handler(ctx, job)
+}
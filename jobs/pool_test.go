@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"LearnSingleTableDesign/models"
+)
+
+// fakeQueue hands out its jobs in order and records how each one ended
+type fakeQueue struct {
+	jobs      []models.Job
+	completed []string
+	failed    map[string]time.Duration
+}
+
+func (q *fakeQueue) Claim(ctx context.Context, lease time.Duration) (*models.Job, error) {
+	if len(q.jobs) == 0 {
+		return nil, nil
+	}
+	job := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	job.Attempts++
+	return &job, nil
+}
+
+func (q *fakeQueue) Complete(ctx context.Context, job models.Job) error {
+	q.completed = append(q.completed, job.JobID)
+	return nil
+}
+
+func (q *fakeQueue) Fail(ctx context.Context, job models.Job, jobErr error, retryAfter time.Duration) error {
+	q.failed[job.JobID] = retryAfter
+	return nil
+}
+
+func TestPool_RunOne(t *testing.T) {
+	queue := &fakeQueue{
+		jobs: []models.Job{
+			{JobID: "ok", Type: "work"},
+			{JobID: "retry", Type: "flaky", Attempts: 2},
+			{JobID: "unknown", Type: "missing"},
+			{JobID: "panic", Type: "panics"},
+		},
+		failed: make(map[string]time.Duration),
+	}
+	pool := NewPool(queue, 1)
+	pool.Backoff = time.Second
+	pool.Handle("work", func(ctx context.Context, job models.Job) error { return nil })
+	pool.Handle("flaky", func(ctx context.Context, job models.Job) error { return errors.New("try again") })
+	pool.Handle("panics", func(ctx context.Context, job models.Job) error { panic("boom") })
+
+	for i := 0; i < 4; i++ {
+		ran, err := pool.RunOne(context.Background())
+		if !ran || err != nil {
+			t.Fatalf("RunOne() = %v, %v, want true, nil", ran, err)
+		}
+	}
+	if ran, _ := pool.RunOne(context.Background()); ran {
+		t.Error("RunOne() ran a job from an empty queue")
+	}
+
+	if len(queue.completed) != 1 || queue.completed[0] != "ok" {
+		t.Errorf("Completed = %v, want [ok]", queue.completed)
+	}
+	// Third attempt waits 1s << 2
+	if got := queue.failed["retry"]; got != 4*time.Second {
+		t.Errorf("Retry delay = %v, want 4s", got)
+	}
+	for _, id := range []string{"unknown", "panic"} {
+		if _, ok := queue.failed[id]; !ok {
+			t.Errorf("Expected job %q to fail", id)
+		}
+	}
+}
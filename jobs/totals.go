@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"LearnSingleTableDesign/repository"
+)
+
+// TotalChecker is the part of repository.OrderRepository the reconciler needs
+type TotalChecker interface {
+	CheckDayTotals(ctx context.Context, day time.Time) ([]repository.TotalMismatch, error)
+}
+
+// TotalReconciler periodically checks that recent orders' totals match
+// their line items. The checker alerts about each mismatch; repairing one
+// is left to the admin, since a wrong total may mean the items are wrong.
+type TotalReconciler struct {
+	orders TotalChecker
+	// Interval is how often the recent days are checked
+	Interval time.Duration
+	// Days is how many days back each pass checks, today included
+	Days int
+}
+
+// NewTotalReconciler creates a TotalReconciler that checks today and
+// yesterday every hour
+func NewTotalReconciler(orders TotalChecker) *TotalReconciler {
+	return &TotalReconciler{
+		orders:   orders,
+		Interval: time.Hour,
+		Days:     2,
+	}
+}
+
+// Run checks order totals until ctx is done
+func (r *TotalReconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.RunOnce(ctx, time.Now()); err != nil {
+			slog.Error("failed to reconcile order totals", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce checks the orders of the days up to now, reporting how many
+// didn't add up
+func (r *TotalReconciler) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	found := 0
+	for i := range r.Days {
+		mismatches, err := r.orders.CheckDayTotals(ctx, now.AddDate(0, 0, -i))
+		if err != nil {
+			return found, err
+		}
+		found += len(mismatches)
+	}
+	if found > 0 {
+		slog.Warn("found orders whose totals don't match their items", "count", found)
+	}
+	return found, nil
+}
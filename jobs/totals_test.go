@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"LearnSingleTableDesign/repository"
+)
+
+// fakeTotals has mismatched orders on some days
+type fakeTotals struct {
+	mismatched map[string]int
+	days       []string
+}
+
+func (f *fakeTotals) CheckDayTotals(ctx context.Context, day time.Time) ([]repository.TotalMismatch, error) {
+	date := day.Format(time.DateOnly)
+	f.days = append(f.days, date)
+	return make([]repository.TotalMismatch, f.mismatched[date]), nil
+}
+
+func TestTotalReconciler_ChecksRecentDays(t *testing.T) {
+	orders := &fakeTotals{mismatched: map[string]int{"2024-03-01": 1, "2024-02-29": 2, "2024-02-28": 4}}
+	reconciler := NewTotalReconciler(orders)
+
+	found, err := reconciler.RunOnce(context.Background(), time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if found != 3 || len(orders.days) != 2 {
+		t.Errorf("Found %d over %v, want 3 over today and yesterday", found, orders.days)
+	}
+}
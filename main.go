@@ -5,185 +5,293 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
+	"LearnSingleTableDesign/archive"
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/images"
+	"LearnSingleTableDesign/invoices"
+	"LearnSingleTableDesign/jobs"
 	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/notifications"
+	"LearnSingleTableDesign/orderqueue"
 	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/s3"
+	"LearnSingleTableDesign/schema"
+	"LearnSingleTableDesign/search"
 	"LearnSingleTableDesign/web"
+	"LearnSingleTableDesign/webhooks"
 )
 
 func main() {
-	// Create custom resolver to point to local DynamoDB
-	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		return aws.Endpoint{
-			PartitionID:   "aws",
-			URL:           "http://localhost:8000",
-			SigningRegion: "us-east-1",
-		}, nil
-	})
+	local := flag.Bool("local", false, "use DynamoDB Local with dummy credentials instead of the AWS config chain")
+	flag.Parse()
 
-	// Configure AWS SDK with local endpoint
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-east-1"),
-		config.WithEndpointResolverWithOptions(customResolver),
-		config.WithCredentialsProvider(credentials.StaticCredentialsProvider{
-			Value: aws.Credentials{
-				AccessKeyID: "dummy", SecretAccessKey: "dummy", SessionToken: "dummy",
-				Source: "Hard-coded credentials; DO NOT use in production",
-			},
-		}),
-	)
+	appCfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("unable to load config, %v", err)
+	}
+	if *local {
+		appCfg.Local = true
+	}
+	level, err := appCfg.SlogLevel()
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetLogLoggerLevel(level)
+
+	if appCfg.Backend == repository.BackendSQLite {
+		runSQLite(appCfg)
+		return
+	}
+
+	// Create DynamoDB client, logging every request when debugging
+	var clientOpts []dynamoclient.Option
+	if level <= slog.LevelDebug {
+		clientOpts = append(clientOpts, dynamoclient.WithMiddleware(dynamoclient.LogRequests(slog.Default())))
+	}
+	client, err := dynamoclient.New(context.TODO(), appCfg, clientOpts...)
 	if err != nil {
 		log.Fatalf("unable to load SDK config, %v", err)
 	}
 
-	// Create DynamoDB client
-	client := dynamodb.NewFromConfig(cfg)
+	// Keep raw emails out of keys when a hashing secret is configured
+	repository.UseIDHasher(repository.NewIDHasher(appCfg.KeyHashSecret))
 
 	// Create repositories
-	tableName := "AppTable"
-	userRepo := repository.NewUserRepository(client, tableName)
-	orderRepo := repository.NewOrderRepository(client, tableName)
-	productRepo := repository.NewProductRepository(client, tableName)
+	tableName := appCfg.TableName
+	// Refuse writes during maintenance; the admin dashboard toggles it
+	readOnly := repository.NewReadOnlySwitch(appCfg.ReadOnly)
+	storeOpts := []repository.StoreOption{
+		repository.EnforceKeyConsistency(),
+		repository.MaxPageSize(100),
+		// Give each browser session consistent reads of what it just wrote
+		repository.ReadYourWrites(repository.NewRecentWrites(10 * time.Second)),
+		// Fail fast instead of hanging handlers when DynamoDB stops answering.
+		// Scans back the dashboard counts and get longer.
+		repository.OperationTimeouts(repository.Timeouts{
+			Default:      appCfg.OperationTimeout,
+			PerOperation: map[string]time.Duration{"Scan": 30 * time.Second},
+		}),
+		repository.WithCircuitBreaker(repository.NewCircuitBreaker(5, 10*time.Second)),
+		repository.WithReadOnlySwitch(readOnly),
+	}
+	if appCfg.Dev {
+		storeOpts = append(storeOpts, repository.DetectDuplicateWrites())
+	}
+	if appCfg.Audit {
+		storeOpts = append(storeOpts, repository.AuditWrites())
+	}
 
-	// Ensure the table exists before proceeding
-	if err := ensureTableExists(context.TODO(), client, tableName); err != nil {
-		log.Fatalf("failed to ensure table exists: %v", err)
+	// Mirror products into OpenSearch when a cluster is configured
+	var openSearch *search.OpenSearch
+	var indexOpts []repository.StoreOption
+	if appCfg.SearchEndpoint != "" {
+		openSearch = search.NewOpenSearch(appCfg.SearchEndpoint, appCfg.SearchIndex, nil)
+		indexer := search.NewIndexer(openSearch, 1000)
+		go indexer.Run(context.Background())
+		indexOpts = append(indexOpts, repository.WithWriteHook(indexer.Hook()))
+	}
+	productOpts := append(slices.Clone(storeOpts), indexOpts...)
+	// Deliver order lifecycle events to subscribed webhooks
+	webhookRepo := repository.NewWebhookRepository(client, tableName, storeOpts...)
+	dispatcher := webhooks.NewDispatcher(webhookRepo, nil, 1000)
+	go dispatcher.Run(context.Background())
+	orderOpts := append(slices.Clone(storeOpts), repository.OnPut(dispatcher.Hook()))
+
+	// Email customers when orders are placed or change status
+	mailer, err := notifications.NewMailer(context.TODO(), appCfg)
+	if err != nil {
+		log.Fatalf("unable to create mailer, %v", err)
+	}
+	// Users turn order emails on and off on their notification settings
+	// page, reached from a signed link in each email
+	prefsRepo := repository.NewNotificationPrefsRepository(client, tableName, storeOpts...)
+	var settingsLinks *notifications.SettingsLinks
+	if appCfg.LinkSecret != "" {
+		settingsLinks = notifications.NewSettingsLinks(appCfg.LinkSecret, appCfg.BaseURL)
 	}
+	notifier := notifications.NewOrderNotifier(mailer, prefsRepo, 1000)
+	notifier.Settings = settingsLinks
+	go notifier.Run(context.Background())
+	orderOpts = append(orderOpts, repository.OnPut(notifier.Hook()))
 
-	// Insert some misc products
-	products := []models.Product{
-		{
-			ProductID: "PROD1",
-			Name:      "Product 1",
-			Price:     10.99,
-			Category:  "Electronics",
-			Stock:     23,
-		},
-		{
-			ProductID: "PROD2",
-			Name:      "Product 2",
-			Price:     20.99,
-			Category:  "Electronics",
-			Stock:     100,
-		},
-	}
-	for _, product := range products {
-		err := productRepo.Put(context.Background(), product)
+	// Hand placed orders to cmd/orderworker when a queue is configured
+	if appCfg.OrderQueueURL != "" {
+		queue, err := orderqueue.NewSQS(context.TODO(), appCfg)
 		if err != nil {
-			log.Fatalf("failed to put product: %v", err)
+			log.Fatalf("unable to create order queue client, %v", err)
 		}
-		fmt.Printf("Created product: %s\n", product.ProductID)
+		publisher := orderqueue.NewPublisher(queue, 1000)
+		go publisher.Run(context.Background())
+		orderOpts = append(orderOpts, repository.OnPut(publisher.Hook()))
 	}
 
-	// Example: Create a new user
-	user := models.User{
-		Email:     "john@example.com",
-		Name:      "John Doe",
-		CreatedAt: time.Now(),
+	userRepo := repository.NewUserRepository(client, tableName, storeOpts...)
+	orderRepo := repository.NewOrderRepository(client, tableName, orderOpts...)
+	productRepo := repository.NewProductRepository(client, tableName, productOpts...)
+	// The service writes orders and stock together, so it takes the order
+	// hooks and the product indexer both
+	orderService := repository.NewOrderService(client, tableName, append(slices.Clone(orderOpts), indexOpts...)...)
+	pageRepo := repository.NewPageRepository(client, tableName, storeOpts...)
+	jobRepo := repository.NewJobRepository(client, tableName, storeOpts...)
+
+	// Alert when a reservation takes a product below its stock threshold
+	productRepo.OnLowStock(func(ctx context.Context, product models.Product) {
+		slog.Warn("product low on stock", "product_id", product.ProductID, "stock", product.Stock, "threshold", product.LowStockThreshold)
+	})
+	productRepo.OnLowStock(dispatcher.LowStockAlert())
+	if appCfg.LowStockEmail != "" {
+		productRepo.OnLowStock(notifications.LowStockEmail(jobRepo, appCfg.LowStockEmail))
+	}
+	// Flag orders whose stored total doesn't match their line items
+	orderRepo.OnTotalMismatch(func(ctx context.Context, mismatch repository.TotalMismatch) {
+		slog.Warn("order total mismatch", "user_email", mismatch.UserEmail, "order_id", mismatch.OrderID, "stored", mismatch.Stored, "computed", mismatch.Computed)
+	})
+	if appCfg.Dev {
+		orderRepo.CheckTotalsOnRead()
 	}
+	reportRepo := repository.NewReportRepository(client, tableName, storeOpts...)
+	tableRepo := repository.NewTableRepository(client, tableName, storeOpts...)
+	auditRepo := repository.NewAuditRepository(client, tableName, storeOpts...)
+	contactRepo := repository.NewContactRepository(client, tableName, storeOpts...)
+	cartRepo := repository.NewCartRepository(client, tableName, storeOpts...)
+	// Viewing as a user is always audited, whatever the audit setting
+	impersonationRepo := repository.NewImpersonationRepository(client, tableName, append(slices.Clone(storeOpts), repository.AuditWrites())...)
 
-	// Put user in DynamoDB
-	if err := userRepo.Put(context.TODO(), user); err != nil {
-		log.Fatalf("failed to put user: %v", err)
+	// Ensure the table exists before proceeding
+	if err := schema.EnsureTableSpec(context.TODO(), client, schema.FromConfig(appCfg)); err != nil {
+		log.Fatalf("failed to ensure table exists: %v", err)
 	}
-	fmt.Println("Successfully created user:", user.Email)
 
-	// Create multiple orders for the user
-	for i := 1; i <= 5; i++ {
-		order := models.Order{
-			OrderID:   fmt.Sprintf("ORD%d", i),
-			UserEmail: user.Email,
-			Status:    models.OrderStatusPending,
-			Total:     float64(i) * 10.99,
-			CreatedAt: time.Now(),
-			Products:  []string{fmt.Sprintf("PROD%d", i)},
-		}
+	// Run background jobs queued in the table
+	pool := jobs.NewPool(jobRepo, 4)
+	pool.Handle(notifications.EmailJobType, notifications.EmailJob(mailer))
+	go pool.Run(context.Background())
 
-		if err := orderRepo.Put(context.TODO(), order); err != nil {
-			log.Fatalf("failed to put order: %v", err)
+	// Return the stock of inventory holds whose carts were abandoned
+	go jobs.NewHoldReconciler(productRepo).Run(context.Background())
+	// Check recent orders' totals against their line items
+	go jobs.NewTotalReconciler(orderRepo).Run(context.Background())
+
+	// Expire old completed orders, through a repository without the order
+	// hooks so customers aren't emailed about it
+	if appCfg.ArchiveAfterDays > 0 {
+		sweeperOrders := repository.NewOrderRepository(client, tableName, storeOpts...)
+		go archive.NewSweeper(sweeperOrders, appCfg.ArchiveAfterDays).Run(context.Background())
+	}
+	// Save orders to S3 as time to live deletes them
+	if appCfg.ArchiveBucket != "" {
+		stream, err := newArchiveStream(context.TODO(), appCfg, client)
+		if err != nil {
+			log.Fatalf("unable to start order archival, %v", err)
 		}
-		fmt.Printf("Created order: %s\n", order.OrderID)
+		go stream.Run(context.Background())
 	}
 
-	// Demonstrate pagination
-	fmt.Println("\nFetching orders with pagination (2 items per page):")
-	var pageToken *repository.PageToken
-	pageNum := 1
+	// Only seed demo data into DynamoDB Local, never a real table
+	if appCfg.Local && !appCfg.ReadOnly {
+		// Seed within the write budget, through repositories sharing one
+		// limiter. Orders are placed like real ones but skip the order
+		// hooks, so seeding doesn't email customers, call webhooks or queue
+		// the orders.
+		limit := repository.LimitWrites(repository.NewWriteLimiter(appCfg.WriteBudget))
+		seedDemoData(
+			repository.NewUserRepository(client, tableName, append(slices.Clone(storeOpts), limit)...),
+			repository.NewOrderRepository(client, tableName, append(slices.Clone(storeOpts), limit)...),
+			repository.NewProductRepository(client, tableName, append(slices.Clone(productOpts), limit)...),
+			repository.NewPageRepository(client, tableName, append(slices.Clone(storeOpts), limit)...),
+			repository.NewOrderService(client, tableName, append(slices.Clone(productOpts), limit)...),
+		)
+	}
 
-	for {
-		// Get a page of orders
-		page, err := orderRepo.GetUserOrders(context.TODO(), user.Email, &repository.QueryOptions{
-			Limit:     2,
-			PageToken: pageToken,
-		})
+	// Link the admin dashboard to invoices cmd/orderworker generates
+	var invoiceLinks *invoices.Links
+	if appCfg.InvoiceBucket != "" {
+		objects, err := s3.New(context.TODO(), appCfg)
 		if err != nil {
-			log.Fatalf("failed to get user orders: %v", err)
-		}
-
-		fmt.Printf("\nPage %d:\n", pageNum)
-		for _, order := range page.Orders {
-			fmt.Printf("Order: %s, Total: $%.2f\n", order.OrderID, order.Total)
+			log.Fatalf("unable to create S3 client, %v", err)
 		}
+		invoiceLinks = invoices.NewLinks(objects, appCfg.InvoiceBucket)
+	}
 
-		// If there's no next page token, we've reached the end
-		if page.NextPageToken == nil {
-			break
-		}
+	imageStore, err := newImageStore(context.TODO(), appCfg)
+	if err != nil {
+		log.Fatalf("unable to create image store, %v", err)
+	}
 
-		// Set up for next page
-		pageToken = page.NextPageToken
-		pageNum++
+	var searcher search.Service = search.PrefixSearch{Products: productRepo}
+	if openSearch != nil {
+		searcher = openSearch
 	}
 
 	web.Start(
-		userRepo, orderRepo, productRepo,
+		appCfg,
+		userRepo, orderRepo, productRepo, pageRepo, reportRepo, tableRepo, auditRepo, contactRepo, cartRepo, orderService, impersonationRepo, prefsRepo, settingsLinks,
+		searcher, newConverter(appCfg), readOnly, invoiceLinks, imageStore,
 	)
 }
 
-// ensureTableExists creates the DynamoDB table if it doesn't exist
-func ensureTableExists(ctx context.Context, client *dynamodb.Client, tableName string) error {
-	_, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
-	})
-	if err == nil {
-		// Table exists
-		return nil
-	}
-
-	// Create table
-	_, err = client.CreateTable(ctx, &dynamodb.CreateTableInput{
-		TableName: aws.String(tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("PK"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-			{
-				AttributeName: aws.String("SK"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("PK"),
-				KeyType:       types.KeyTypeHash,
-			},
-			{
-				AttributeName: aws.String("SK"),
-				KeyType:       types.KeyTypeRange,
-			},
-		},
-		BillingMode: types.BillingModePayPerRequest,
-	})
+// newConverter prices products with the configured exchange rates, or the
+// defaults when there are none
+func newConverter(cfg config.Config) money.Converter {
+	if len(cfg.ExchangeRates) > 0 {
+		return money.StaticRates(cfg.ExchangeRates)
+	}
+	return money.DefaultRates
+}
 
-	return err
+// newImageStore stores product images in cfg.ImageBucket, or else
+// cfg.ImageDir. It returns nil when neither is set.
+func newImageStore(ctx context.Context, cfg config.Config) (images.Store, error) {
+	if cfg.ImageBucket == "" {
+		if cfg.ImageDir == "" {
+			return nil, nil
+		}
+		return images.Dir(cfg.ImageDir), nil
+	}
+	objects, err := s3.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Emulators start empty, so make the bucket there
+	if cfg.Local {
+		if err := objects.CreateBucket(ctx, cfg.ImageBucket); err != nil {
+			return nil, err
+		}
+	}
+	return images.NewBucket(objects, cfg.ImageBucket), nil
+}
+
+// newArchiveStream reads the table's stream into an Archiver saving to
+// cfg.ArchiveBucket. The stream has to carry old images.
+func newArchiveStream(ctx context.Context, cfg config.Config, client *dynamodb.Client) (*archive.Stream, error) {
+	if view := types.StreamViewType(cfg.StreamView); view != types.StreamViewTypeOldImage && view != types.StreamViewTypeNewAndOldImages {
+		return nil, fmt.Errorf("archiving needs STREAM_VIEW %s or %s", types.StreamViewTypeOldImage, types.StreamViewTypeNewAndOldImages)
+	}
+	desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(cfg.TableName)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe table: %w", err)
+	}
+	streams, err := dynamoclient.NewStreams(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := s3.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	archiver := archive.NewArchiver(objects, cfg.ArchiveBucket)
+	return archive.NewStream(streams, aws.ToString(desc.Table.LatestStreamArn), archiver.Hook()), nil
 }
@@ -3,15 +3,31 @@ package models
 import (
 	"database/sql/driver"
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+
+	"LearnSingleTableDesign/money"
 )
 
 var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	validate.RegisterTagNameFunc(jsonName)
+}
+
+// DefaultCurrency is the currency of prices stored without one
+const DefaultCurrency = "USD"
+
+func currencyOrDefault(code string) string {
+	if code == "" {
+		return DefaultCurrency
+	}
+	return code
 }
 
 // OrderStatus represents the status of an order
@@ -33,6 +49,19 @@ func (s OrderStatus) IsValid() bool {
 	return false
 }
 
+// CanTransitionTo reports whether an order may move from s to next.
+// Orders move forward through processing to completed, and can be
+// cancelled until they complete.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	switch s {
+	case OrderStatusPending:
+		return next == OrderStatusProcessing || next == OrderStatusCancelled
+	case OrderStatusProcessing:
+		return next == OrderStatusCompleted || next == OrderStatusCancelled
+	}
+	return false
+}
+
 // String converts the OrderStatus to a string
 func (s OrderStatus) String() string {
 	return string(s)
@@ -65,47 +94,811 @@ func (s *OrderStatus) Scan(value interface{}) error {
 
 // User represents a user in the system
 type User struct {
-	Email     string    `json:"email" dynamodbav:"email" validate:"required,email"`
+	Email     string    `json:"email" dynamodbav:"email" validate:"required,email,normalizedEmail,keypart"`
 	Name      string    `json:"name" dynamodbav:"name" validate:"required"`
 	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
 }
 
 // Validate validates the user fields
 func (u User) Validate() error {
-	return validate.Struct(u)
+	return validateStruct(u)
+}
+
+// UserStats summarizes a user's orders. It is kept up to date as orders are
+// placed, so it can be read without scanning the orders.
+type UserStats struct {
+	Email      string `json:"email"`
+	OrderCount int    `json:"order_count"`
+	// LifetimeSpend is what the user spent in each currency they ordered in
+	LifetimeSpend map[string]float64 `json:"lifetime_spend"`
+	LastOrderAt   time.Time          `json:"last_order_at"`
+}
+
+// DailySales totals the orders completed on one UTC day
+type DailySales struct {
+	// Date is the day in YYYY-MM-DD form
+	Date       string `json:"date"`
+	OrderCount int    `json:"order_count"`
+	// Revenue is the day's takings in each currency orders were placed in
+	Revenue map[string]float64 `json:"revenue"`
+}
+
+// Address is a shipping address belonging to a user
+type Address struct {
+	AddressID  string    `json:"address_id" dynamodbav:"address_id" validate:"required,keypart"`
+	UserEmail  string    `json:"user_email" dynamodbav:"user_email" validate:"required,email,normalizedEmail,keypart"`
+	Line1      string    `json:"line1" dynamodbav:"line1" validate:"required"`
+	Line2      string    `json:"line2" dynamodbav:"line2"`
+	City       string    `json:"city" dynamodbav:"city" validate:"required"`
+	PostalCode string    `json:"postal_code" dynamodbav:"postal_code" validate:"required"`
+	Country    string    `json:"country" dynamodbav:"country" validate:"required,iso3166_1_alpha2"`
+	CreatedAt  time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Validate validates the address fields
+func (a Address) Validate() error {
+	return validateStruct(a)
 }
 
 // Order represents an order in the system
 type Order struct {
-	OrderID   string      `json:"order_id" dynamodbav:"order_id" validate:"required"`
-	UserEmail string      `json:"user_email" dynamodbav:"user_email" validate:"required,email"`
+	OrderID   string      `json:"order_id" dynamodbav:"order_id" validate:"required,keypart"`
+	UserEmail string      `json:"user_email" dynamodbav:"user_email" validate:"required,email,normalizedEmail,keypart"`
 	Status    OrderStatus `json:"status" dynamodbav:"status" validate:"required,orderStatus"`
 	Total     float64     `json:"total" dynamodbav:"total" validate:"required,gte=0"`
-	Products  []string    `json:"products" dynamodbav:"products" validate:"required,min=1,dive,required"`
-	CreatedAt time.Time   `json:"created_at" dynamodbav:"created_at"`
+	// Currency is what Total and the items are priced in; empty on orders
+	// placed before prices had a currency, which were in DefaultCurrency
+	Currency string `json:"currency" dynamodbav:"currency,omitempty" validate:"omitempty,iso4217"`
+	// Items are what was ordered, priced as they were when it was placed
+	Items []LineItem `json:"items" dynamodbav:"items,omitempty" validate:"dive"`
+	// LegacyProducts are the product IDs orders listed before they had line
+	// items, one per unit; cmd/migrateorders turns them into Items
+	LegacyProducts []string  `json:"-" dynamodbav:"products,omitempty" validate:"dive,required"`
+	CreatedAt      time.Time `json:"created_at" dynamodbav:"created_at"`
+	// InvoiceKey is the S3 key of the order's invoice, set once it has been
+	// generated after the order completed
+	InvoiceKey string `json:"invoice_key,omitempty" dynamodbav:"invoice_key,omitempty"`
+}
+
+// OrderLogEntry is one event in an order's history: placing it, a change
+// of status or a refund recorded against it
+type OrderLogEntry struct {
+	EntryID string `json:"entry_id" dynamodbav:"entry_id" validate:"required,keypart"`
+	OrderID string `json:"order_id" dynamodbav:"order_id" validate:"required,keypart"`
+	// Event is the kind of event, e.g. "order.placed"
+	Event string `json:"event" dynamodbav:"event" validate:"required"`
+	// Status is the order's status after the event
+	Status OrderStatus `json:"status" dynamodbav:"status" validate:"required,orderStatus"`
+	// Note says more, such as why the order was cancelled
+	Note string    `json:"note,omitempty" dynamodbav:"note,omitempty"`
+	At   time.Time `json:"at" dynamodbav:"at"`
+}
+
+// Validate validates the log entry fields
+func (e OrderLogEntry) Validate() error {
+	return validateStruct(e)
+}
+
+// LineItem is one product on an order. The name and price are copied from
+// the product when the order is placed, so later catalog changes don't
+// rewrite past orders.
+type LineItem struct {
+	ProductID string  `json:"product_id" dynamodbav:"product_id" validate:"required,keypart"`
+	Name      string  `json:"name" dynamodbav:"name" validate:"required"`
+	UnitPrice float64 `json:"unit_price" dynamodbav:"unit_price" validate:"gte=0"`
+	Quantity  int     `json:"quantity" dynamodbav:"quantity" validate:"gte=1"`
+}
+
+// Subtotal is the line's unit price times its quantity
+func (l LineItem) Subtotal() float64 {
+	return l.UnitPrice * float64(l.Quantity)
 }
 
 // Validate validates the order fields
 func (o Order) Validate() error {
-	return validate.Struct(o)
+	if err := validateStruct(o); err != nil {
+		return err
+	}
+	if len(o.Items) == 0 && len(o.LegacyProducts) == 0 {
+		return invalid("items", "must have at least one item")
+	}
+	return nil
+}
+
+// PriceCurrency is the currency of the order's total and items
+func (o Order) PriceCurrency() string {
+	return currencyOrDefault(o.Currency)
 }
 
+// ItemsTotal is what the order's line items add up to, rounded to the
+// currency's minor unit
+func (o Order) ItemsTotal() float64 {
+	total := 0.0
+	for _, item := range o.Items {
+		total += item.Subtotal()
+	}
+	return money.Round(total, o.PriceCurrency())
+}
+
+// ProductIDs lists the product ID of every unit ordered, repeating an ID
+// for each unit of it
+func (o Order) ProductIDs() []string {
+	if len(o.Items) == 0 {
+		return o.LegacyProducts
+	}
+	var ids []string
+	for _, item := range o.Items {
+		for range item.Quantity {
+			ids = append(ids, item.ProductID)
+		}
+	}
+	return ids
+}
+
+// Product is the catalog's only product model; ProductID is its SKU and
+// the ID its keys are built from
 type Product struct {
-	ProductID string    `json:"product_id" dynamodbav:"product_id" validate:"required"`
-	Category  string    `json:"category" dynamodbav:"category" validate:"required"`
-	Name      string    `json:"name" dynamodbav:"name" validate:"required"`
-	Price     float64   `json:"price" dynamodbav:"price" validate:"required,gt=0"`
-	Stock     int       `json:"stock" dynamodbav:"stock" validate:"gte=0"`
+	ProductID string  `json:"product_id" dynamodbav:"product_id" validate:"required,keypart"`
+	Category  string  `json:"category" dynamodbav:"category" validate:"required"`
+	Name      string  `json:"name" dynamodbav:"name" validate:"required"`
+	Price     float64 `json:"price" dynamodbav:"price" validate:"required,gt=0"`
+	// Currency is what Price is in; empty means DefaultCurrency
+	Currency string `json:"currency" dynamodbav:"currency,omitempty" validate:"omitempty,iso4217"`
+	Stock    int    `json:"stock" dynamodbav:"stock" validate:"gte=0"`
+	// LowStockThreshold flags the product as low on stock once Stock drops
+	// below it; 0 turns low stock tracking off
+	LowStockThreshold int `json:"low_stock_threshold" dynamodbav:"low_stock_threshold" validate:"gte=0"`
+	// Featured products are shown in the homepage carousel
+	Featured bool `json:"featured" dynamodbav:"featured"`
+	// ImageURL is where the product's uploaded image is served from; empty
+	// when it has none
+	ImageURL  string    `json:"image_url,omitempty" dynamodbav:"image_url,omitempty"`
 	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
 }
 
 func (p Product) Validate() error {
-	return validate.Struct(p)
+	return validateStruct(p)
+}
+
+// PriceCurrency is the currency of the product's price
+func (p Product) PriceCurrency() string {
+	return currencyOrDefault(p.Currency)
+}
+
+// IsLowStock reports whether stock has dropped below the product's threshold
+func (p Product) IsLowStock() bool {
+	return p.Stock < p.LowStockThreshold
+}
+
+// ProductContent holds the translatable text of a product for one locale
+type ProductContent struct {
+	ProductID   string `json:"product_id" dynamodbav:"product_id" validate:"required,keypart"`
+	Locale      string `json:"locale" dynamodbav:"locale" validate:"required,bcp47_language_tag"`
+	Name        string `json:"name" dynamodbav:"name" validate:"required"`
+	Description string `json:"description" dynamodbav:"description"`
+}
+
+// Validate validates the product content fields
+func (c ProductContent) Validate() error {
+	return validateStruct(c)
+}
+
+// PageStatus represents the publication state of a CMS page
+type PageStatus string
+
+const (
+	PageStatusDraft     PageStatus = "draft"
+	PageStatusPublished PageStatus = "published"
+)
+
+// IsValid validates if the status is one of the defined constants
+func (s PageStatus) IsValid() bool {
+	switch s {
+	case PageStatusDraft, PageStatusPublished:
+		return true
+	}
+	return false
+}
+
+// Page is a simple CMS page with markdown content, optionally linked from the navbar
+type Page struct {
+	Slug     string     `json:"slug" dynamodbav:"slug" validate:"required,slug"`
+	Title    string     `json:"title" dynamodbav:"title" validate:"required"`
+	Markdown string     `json:"markdown" dynamodbav:"markdown"`
+	Status   PageStatus `json:"status" dynamodbav:"status" validate:"required,pageStatus"`
+	// NavLabel adds the page to the navbar when set
+	NavLabel  string    `json:"nav_label" dynamodbav:"nav_label"`
+	NavOrder  int       `json:"nav_order" dynamodbav:"nav_order"`
+	UpdatedAt time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Validate validates the page fields
+func (p Page) Validate() error {
+	return validateStruct(p)
+}
+
+// IsPublished reports whether the page is visible to visitors
+func (p Page) IsPublished() bool {
+	return p.Status == PageStatusPublished
+}
+
+// Webhook is a subscription that receives signed POSTs for the event types it lists
+type Webhook struct {
+	WebhookID string `json:"webhook_id" dynamodbav:"webhook_id" validate:"required,keypart"`
+	URL       string `json:"url" dynamodbav:"url" validate:"required,http_url"`
+	// Secret signs each payload so the receiver can verify it came from us
+	Secret     string    `json:"-" dynamodbav:"secret" validate:"required"`
+	EventTypes []string  `json:"event_types" dynamodbav:"event_types" validate:"required,min=1,dive,required"`
+	CreatedAt  time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Validate validates the webhook fields
+func (w Webhook) Validate() error {
+	return validateStruct(w)
+}
+
+// Subscribes reports whether the webhook wants events of the given type
+func (w Webhook) Subscribes(eventType string) bool {
+	for _, t := range w.EventTypes {
+		if t == eventType || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	WebhookID string `json:"webhook_id" dynamodbav:"webhook_id" validate:"required,keypart"`
+	EventID   string `json:"event_id" dynamodbav:"event_id" validate:"required,keypart"`
+	EventType string `json:"event_type" dynamodbav:"event_type" validate:"required"`
+	Attempt   int    `json:"attempt" dynamodbav:"attempt" validate:"gte=1"`
+	// StatusCode is the receiver's response status, or 0 if the request failed
+	StatusCode  int       `json:"status_code" dynamodbav:"status_code"`
+	Error       string    `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	Succeeded   bool      `json:"succeeded" dynamodbav:"succeeded"`
+	AttemptedAt time.Time `json:"attempted_at" dynamodbav:"attempted_at"`
+}
+
+// Validate validates the delivery fields
+func (d WebhookDelivery) Validate() error {
+	return validateStruct(d)
+}
+
+// ContactMessage is a message a visitor sent through the contact form
+type ContactMessage struct {
+	MessageID string    `json:"message_id" dynamodbav:"message_id" validate:"required,keypart"`
+	Name      string    `json:"name" dynamodbav:"name" validate:"required,max=100"`
+	Email     string    `json:"email" dynamodbav:"email" validate:"required,email"`
+	Message   string    `json:"message" dynamodbav:"message" validate:"required,max=5000"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Validate validates the contact message fields
+func (m ContactMessage) Validate() error {
+	return validateStruct(m)
+}
+
+// MaxCartQuantity is the most of one product a cart holds
+const MaxCartQuantity = 99
+
+// CartItem is a product in a cart and how many of it
+type CartItem struct {
+	ProductID string `json:"product_id" dynamodbav:"product_id" validate:"required,keypart"`
+	Quantity  int    `json:"quantity" dynamodbav:"quantity" validate:"gte=1,lte=99"`
+	// HoldIDs are the inventory holds keeping the item's units out of
+	// stock, one per time it was added
+	HoldIDs []string `json:"hold_ids,omitempty" dynamodbav:"hold_ids,omitempty"`
+}
+
+// Cart is what a visitor means to buy. An anonymous visitor's cart belongs
+// to their session until they sign in and it is merged into their own.
+type Cart struct {
+	Items []CartItem `json:"items" dynamodbav:"items" validate:"max=100,dive"`
+	// Version counts the writes to the cart, for optimistic locking
+	Version   int       `json:"version" dynamodbav:"version"`
+	UpdatedAt time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Validate validates the cart's items
+func (c Cart) Validate() error {
+	return validateStruct(c)
+}
+
+// Count is the number of units in the cart
+func (c Cart) Count() int {
+	n := 0
+	for _, item := range c.Items {
+		n += item.Quantity
+	}
+	return n
+}
+
+// Add puts quantity more of a product in the cart, up to MaxCartQuantity
+func (c *Cart) Add(productID string, quantity int) {
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID {
+			c.Items[i].Quantity = min(c.Items[i].Quantity+quantity, MaxCartQuantity)
+			return
+		}
+	}
+	c.Items = append(c.Items, CartItem{ProductID: productID, Quantity: min(quantity, MaxCartQuantity)})
+}
+
+// Room is how many more of a product the cart can take
+func (c Cart) Room(productID string) int {
+	for _, item := range c.Items {
+		if item.ProductID == productID {
+			return MaxCartQuantity - item.Quantity
+		}
+	}
+	return MaxCartQuantity
+}
+
+// AddHold records that a hold keeps units of a product in the cart
+func (c *Cart) AddHold(productID, holdID string) {
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID {
+			c.Items[i].HoldIDs = append(c.Items[i].HoldIDs, holdID)
+			return
+		}
+	}
+}
+
+// Remove takes a product out of the cart, returning the holds it had
+func (c *Cart) Remove(productID string) []string {
+	var holdIDs []string
+	c.Items = slices.DeleteFunc(c.Items, func(item CartItem) bool {
+		if item.ProductID != productID {
+			return false
+		}
+		holdIDs = append(holdIDs, item.HoldIDs...)
+		return true
+	})
+	return holdIDs
+}
+
+// Merge adds the items of other to the cart, summing the quantities of
+// products in both and keeping their holds. The items of other whose sum
+// went past MaxCartQuantity are returned, and their holds not kept, for
+// the caller to release.
+func (c *Cart) Merge(other Cart) (capped []CartItem) {
+	for _, item := range other.Items {
+		if item.Quantity > c.Room(item.ProductID) {
+			capped = append(capped, item)
+			c.Add(item.ProductID, item.Quantity)
+			continue
+		}
+		c.Add(item.ProductID, item.Quantity)
+		for _, holdID := range item.HoldIDs {
+			c.AddHold(item.ProductID, holdID)
+		}
+	}
+	return capped
+}
+
+// Impersonation lets an admin's session browse the store as a user, to
+// debug what that user sees. It expires on its own at ExpiresAt.
+type Impersonation struct {
+	SessionID string `json:"session_id" dynamodbav:"session_id" validate:"required,keypart"`
+	// Email is the user being viewed as
+	Email string `json:"email" dynamodbav:"email" validate:"required,email,normalizedEmail"`
+	// Admin is who started it, as the audit log names actors
+	Admin     string    `json:"admin" dynamodbav:"admin" validate:"required"`
+	StartedAt time.Time `json:"started_at" dynamodbav:"started_at"`
+	ExpiresAt time.Time `json:"expires_at" dynamodbav:"expires_at" validate:"gtfield=StartedAt"`
+}
+
+// Validate validates the impersonation fields
+func (i Impersonation) Validate() error {
+	return validateStruct(i)
+}
+
+// Active reports whether the impersonation hasn't expired at now
+func (i Impersonation) Active(now time.Time) bool {
+	return now.Before(i.ExpiresAt)
+}
+
+// NotificationEvent is something a user can be emailed about
+type NotificationEvent string
+
+const (
+	// NotificationOrderConfirmed is the confirmation of a newly placed order
+	NotificationOrderConfirmed  NotificationEvent = "order.confirmed"
+	NotificationOrderProcessing NotificationEvent = "order.processing"
+	NotificationOrderCompleted  NotificationEvent = "order.completed"
+	NotificationOrderCancelled  NotificationEvent = "order.cancelled"
+)
+
+// NotificationEvents lists every event, in the order settings show them
+var NotificationEvents = []NotificationEvent{
+	NotificationOrderConfirmed,
+	NotificationOrderProcessing,
+	NotificationOrderCompleted,
+	NotificationOrderCancelled,
+}
+
+// IsValid validates if the event is one of the defined constants
+func (e NotificationEvent) IsValid() bool {
+	return slices.Contains(NotificationEvents, e)
+}
+
+// OrderNotificationEvent is the event of an order entering status
+func OrderNotificationEvent(status OrderStatus) NotificationEvent {
+	if status == OrderStatusPending {
+		return NotificationOrderConfirmed
+	}
+	return NotificationEvent("order." + string(status))
+}
+
+// NotificationPrefs says which events a user wants emails about. Events
+// they haven't chosen for are emailed, so a user without prefs gets
+// everything.
+type NotificationPrefs struct {
+	UserEmail string `json:"user_email" dynamodbav:"user_email" validate:"required,email,normalizedEmail,keypart"`
+	// Email turns emails about each event on or off
+	Email     map[NotificationEvent]bool `json:"email" dynamodbav:"email" validate:"dive,keys,notificationEvent,endkeys"`
+	UpdatedAt time.Time                  `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Validate validates the prefs fields
+func (p NotificationPrefs) Validate() error {
+	return validateStruct(p)
+}
+
+// Emails reports whether the user wants an email about event
+func (p NotificationPrefs) Emails(event NotificationEvent) bool {
+	on, ok := p.Email[event]
+	return on || !ok
+}
+
+// JobStatus represents where a background job is in its lifecycle
+type JobStatus string
+
+const (
+	JobStatusPending JobStatus = "pending"
+	JobStatusRunning JobStatus = "running"
+	JobStatusDone    JobStatus = "done"
+	// JobStatusFailed means the job used up its attempts
+	JobStatusFailed JobStatus = "failed"
+)
+
+// IsValid validates if the status is one of the defined constants
+func (s JobStatus) IsValid() bool {
+	switch s {
+	case JobStatusPending, JobStatusRunning, JobStatusDone, JobStatusFailed:
+		return true
+	}
+	return false
+}
+
+// Job is a unit of background work stored in the table
+type Job struct {
+	JobID string `json:"job_id" dynamodbav:"job_id" validate:"required,keypart"`
+	// Type selects the handler that runs the job
+	Type string `json:"type" dynamodbav:"type" validate:"required"`
+	// Payload is the handler's input, usually JSON
+	Payload string    `json:"payload" dynamodbav:"payload"`
+	Status  JobStatus `json:"status" dynamodbav:"status" validate:"required,jobStatus"`
+	// Attempts counts claims so far. It doubles as the item's version, so
+	// only the worker holding the latest claim can finish the job.
+	Attempts    int `json:"attempts" dynamodbav:"attempts" validate:"gte=0"`
+	MaxAttempts int `json:"max_attempts" dynamodbav:"max_attempts" validate:"gte=1"`
+	// VisibleAt is when the job can next be claimed: its run time while
+	// pending, or the end of the worker's lease while running
+	VisibleAt time.Time `json:"visible_at" dynamodbav:"visible_at"`
+	LastError string    `json:"last_error,omitempty" dynamodbav:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Validate validates the job fields
+func (j Job) Validate() error {
+	return validateStruct(j)
+}
+
+// PaymentKind says which way money moved
+type PaymentKind string
+
+const (
+	PaymentKindCharge PaymentKind = "charge"
+	PaymentKindRefund PaymentKind = "refund"
+)
+
+// IsValid validates if the kind is one of the defined constants
+func (k PaymentKind) IsValid() bool {
+	return k == PaymentKindCharge || k == PaymentKindRefund
+}
+
+// PaymentStatus represents where a payment is with its provider
+type PaymentStatus string
+
+const (
+	// PaymentStatusPending is recorded before the provider is called, so a
+	// crash mid-payment leaves a trace to reconcile
+	PaymentStatusPending   PaymentStatus = "pending"
+	PaymentStatusSucceeded PaymentStatus = "succeeded"
+	PaymentStatusFailed    PaymentStatus = "failed"
+)
+
+// IsValid validates if the status is one of the defined constants
+func (s PaymentStatus) IsValid() bool {
+	switch s {
+	case PaymentStatusPending, PaymentStatusSucceeded, PaymentStatusFailed:
+		return true
+	}
+	return false
+}
+
+// Payment is a charge for an order, or a refund of one
+type Payment struct {
+	PaymentID string      `json:"payment_id" dynamodbav:"payment_id" validate:"required,keypart"`
+	OrderID   string      `json:"order_id" dynamodbav:"order_id" validate:"required,keypart"`
+	Kind      PaymentKind `json:"kind" dynamodbav:"kind" validate:"required,paymentKind"`
+	Amount    float64     `json:"amount" dynamodbav:"amount" validate:"gt=0"`
+	// Provider names the payment provider that moved the money
+	Provider string `json:"provider" dynamodbav:"provider" validate:"required"`
+	// ProviderRef is the provider's ID for the charge or refund, once it has one
+	ProviderRef string        `json:"provider_ref,omitempty" dynamodbav:"provider_ref,omitempty"`
+	Status      PaymentStatus `json:"status" dynamodbav:"status" validate:"required,paymentStatus"`
+	// RefundOf is the PaymentID of the charge a refund pays back
+	RefundOf      string    `json:"refund_of,omitempty" dynamodbav:"refund_of,omitempty" validate:"required_if=Kind refund"`
+	FailureReason string    `json:"failure_reason,omitempty" dynamodbav:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Validate validates the payment fields
+func (p Payment) Validate() error {
+	return validateStruct(p)
+}
+
+// RefundableAmount returns how much of a charge the payments haven't
+// refunded yet. Pending refunds count, since they may still go through.
+func RefundableAmount(charge Payment, payments []Payment) float64 {
+	refundable := charge.Amount
+	for _, payment := range payments {
+		if payment.RefundOf == charge.PaymentID && payment.Status != PaymentStatusFailed {
+			refundable -= payment.Amount
+		}
+	}
+	return refundable
+}
+
+// OutboxEvent is a domain event written in the same transaction as the
+// change it describes, for a relay to publish afterwards
+type OutboxEvent struct {
+	EventID string `json:"event_id" dynamodbav:"event_id" validate:"required,keypart"`
+	// Type names the event, e.g. "order.cancelled"
+	Type string `json:"type" dynamodbav:"type" validate:"required"`
+	// AggregateID is the ID of the entity the event is about
+	AggregateID string `json:"aggregate_id" dynamodbav:"aggregate_id" validate:"required"`
+	// Payload is the event body as JSON
+	Payload     string     `json:"payload" dynamodbav:"payload"`
+	CreatedAt   time.Time  `json:"created_at" dynamodbav:"created_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty" dynamodbav:"published_at,omitempty"`
+}
+
+// Validate validates the event fields
+func (e OutboxEvent) Validate() error {
+	return validateStruct(e)
+}
+
+// Coupon is a discount code. It takes either PercentOff or AmountOff off
+// an order's total.
+type Coupon struct {
+	// Code is what customers type, stored upper case
+	Code       string  `json:"code" dynamodbav:"code" validate:"required,alphanum,max=32"`
+	PercentOff float64 `json:"percent_off,omitempty" dynamodbav:"percent_off,omitempty" validate:"gte=0,lte=100"`
+	AmountOff  float64 `json:"amount_off,omitempty" dynamodbav:"amount_off,omitempty" validate:"gte=0"`
+	// MinTotal is the smallest order total the coupon applies to
+	MinTotal float64 `json:"min_total,omitempty" dynamodbav:"min_total,omitempty" validate:"gte=0"`
+	// MaxRedemptions caps redemptions across all users; 0 means no cap
+	MaxRedemptions int `json:"max_redemptions" dynamodbav:"max_redemptions" validate:"gte=0"`
+	// Redemptions counts redemptions so far. It is only ever changed by an
+	// atomic conditional update.
+	Redemptions int        `json:"redemptions" dynamodbav:"redemptions" validate:"gte=0"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" dynamodbav:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" dynamodbav:"created_at"`
+}
+
+// Validate validates the coupon fields
+func (c Coupon) Validate() error {
+	if err := validateStruct(c); err != nil {
+		return err
+	}
+	if (c.PercentOff > 0) == (c.AmountOff > 0) {
+		return invalid("percent_off", "or amount_off must be set, but not both")
+	}
+	return nil
+}
+
+// Discount returns how much the coupon takes off total, never more than total
+func (c Coupon) Discount(total float64) float64 {
+	discount := c.AmountOff
+	if c.PercentOff > 0 {
+		discount = total * c.PercentOff / 100
+	}
+	return min(discount, total)
+}
+
+// CouponRedemption records that a user redeemed a coupon, so they can't
+// redeem it again
+type CouponRedemption struct {
+	Code       string    `json:"code" dynamodbav:"code" validate:"required"`
+	UserEmail  string    `json:"user_email" dynamodbav:"user_email" validate:"required,email,normalizedEmail,keypart"`
+	OrderID    string    `json:"order_id" dynamodbav:"order_id" validate:"required,keypart"`
+	Discount   float64   `json:"discount" dynamodbav:"discount" validate:"gte=0"`
+	RedeemedAt time.Time `json:"redeemed_at" dynamodbav:"redeemed_at"`
+}
+
+// Validate validates the redemption fields
+func (r CouponRedemption) Validate() error {
+	return validateStruct(r)
+}
+
+// AuditAction says how an audited item was written
+type AuditAction string
+
+const (
+	// AuditActionPut replaced the whole item
+	AuditActionPut AuditAction = "put"
+	// AuditActionUpdate changed the item with an update expression
+	AuditActionUpdate AuditAction = "update"
+	// AuditActionDelete removed the item
+	AuditActionDelete AuditAction = "delete"
+)
+
+// AuditChange is one attribute that a write changed. Values are JSON, and
+// empty when the attribute didn't exist on that side of the write.
+type AuditChange struct {
+	Field  string `json:"field" dynamodbav:"field"`
+	Before string `json:"before,omitempty" dynamodbav:"before,omitempty"`
+	After  string `json:"after,omitempty" dynamodbav:"after,omitempty"`
+}
+
+// AuditEntry records who wrote an item, when, and what changed
+type AuditEntry struct {
+	AuditID    string      `json:"audit_id" dynamodbav:"audit_id"`
+	PK         string      `json:"pk" dynamodbav:"pk"`
+	SK         string      `json:"sk" dynamodbav:"sk"`
+	EntityType string      `json:"entity_type" dynamodbav:"entity_type"`
+	Action     AuditAction `json:"action" dynamodbav:"action"`
+	// Actor is who made the change, e.g. "session:<id>" or "system"
+	Actor string `json:"actor" dynamodbav:"actor"`
+	// Changes are the attributes a put changed, data fields flattened to
+	// "data.<field>"
+	Changes []AuditChange `json:"changes,omitempty" dynamodbav:"changes,omitempty"`
+	// Expression is an update's update expression; its result isn't known
+	// until the transaction commits
+	Expression string    `json:"expression,omitempty" dynamodbav:"expression,omitempty"`
+	At         time.Time `json:"at" dynamodbav:"at"`
+}
+
+// HoldStatus represents what became of an inventory hold
+type HoldStatus string
+
+const (
+	HoldStatusActive HoldStatus = "active"
+	// HoldStatusReleased means the held stock went back to the product,
+	// because the cart gave it up or the hold expired
+	HoldStatusReleased HoldStatus = "released"
+	// HoldStatusConsumed means the held stock was sold with an order
+	HoldStatusConsumed HoldStatus = "consumed"
+)
+
+// IsValid validates if the status is one of the defined constants
+func (s HoldStatus) IsValid() bool {
+	switch s {
+	case HoldStatusActive, HoldStatusReleased, HoldStatusConsumed:
+		return true
+	}
+	return false
+}
+
+// InventoryHold is stock taken out of a product's available stock while it
+// sits in a cart. Active holds return their stock when they expire.
+type InventoryHold struct {
+	HoldID    string     `json:"hold_id" dynamodbav:"hold_id" validate:"required,keypart"`
+	ProductID string     `json:"product_id" dynamodbav:"product_id" validate:"required,keypart"`
+	CartID    string     `json:"cart_id" dynamodbav:"cart_id" validate:"required"`
+	Quantity  int        `json:"quantity" dynamodbav:"quantity" validate:"gte=1"`
+	Status    HoldStatus `json:"status" dynamodbav:"status" validate:"required,holdStatus"`
+	ExpiresAt time.Time  `json:"expires_at" dynamodbav:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Validate validates the hold fields
+func (h InventoryHold) Validate() error {
+	return validateStruct(h)
+}
+
+// IsExpired reports whether an active hold has run out at now
+func (h InventoryHold) IsExpired(now time.Time) bool {
+	return h.Status == HoldStatusActive && !now.Before(h.ExpiresAt)
 }
 
 func init() {
 	// Register custom validator for OrderStatus
 	validate.RegisterValidation("orderStatus", validateOrderStatus)
+	validate.RegisterValidation("pageStatus", validatePageStatus)
+	validate.RegisterValidation("slug", validateSlug)
+	validate.RegisterValidation("jobStatus", validateJobStatus)
+	validate.RegisterValidation("holdStatus", validateHoldStatus)
+	validate.RegisterValidation("paymentKind", validatePaymentKind)
+	validate.RegisterValidation("paymentStatus", validatePaymentStatus)
+	validate.RegisterValidation("notificationEvent", validateNotificationEvent)
+	validate.RegisterValidation("keypart", validateKeyPart)
+	validate.RegisterValidation("normalizedEmail", validateNormalizedEmail)
+}
+
+// NormalizeEmail returns the canonical form of an email, trimmed and lower
+// cased, so John@X.com and john@x.com are the same user. Keys are built
+// from it and models only accept emails already in it.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func validateNormalizedEmail(fl validator.FieldLevel) bool {
+	email := fl.Field().String()
+	return email == NormalizeEmail(email)
+}
+
+// keyDelimiter separates the parts of the table's keys. Values embedded in
+// keys, like IDs and emails, must not contain it, or one entity's key could
+// pose as another's.
+const keyDelimiter = "#"
+
+func validateKeyPart(fl validator.FieldLevel) bool {
+	return !strings.Contains(fl.Field().String(), keyDelimiter)
+}
+
+func validatePaymentKind(fl validator.FieldLevel) bool {
+	kind, ok := fl.Field().Interface().(PaymentKind)
+	if !ok {
+		return false
+	}
+	return kind.IsValid()
+}
+
+func validatePaymentStatus(fl validator.FieldLevel) bool {
+	status, ok := fl.Field().Interface().(PaymentStatus)
+	if !ok {
+		return false
+	}
+	return status.IsValid()
+}
+
+func validateHoldStatus(fl validator.FieldLevel) bool {
+	status, ok := fl.Field().Interface().(HoldStatus)
+	if !ok {
+		return false
+	}
+	return status.IsValid()
+}
+
+func validateJobStatus(fl validator.FieldLevel) bool {
+	status, ok := fl.Field().Interface().(JobStatus)
+	if !ok {
+		return false
+	}
+	return status.IsValid()
+}
+
+func validatePageStatus(fl validator.FieldLevel) bool {
+	status, ok := fl.Field().Interface().(PageStatus)
+	if !ok {
+		return false
+	}
+	return status.IsValid()
+}
+
+// slugPattern matches lowercase URL path segments like "about" or "shipping-faq"
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func validateSlug(fl validator.FieldLevel) bool {
+	return slugPattern.MatchString(fl.Field().String())
 }
 
 func validateOrderStatus(fl validator.FieldLevel) bool {
@@ -115,3 +908,11 @@ func validateOrderStatus(fl validator.FieldLevel) bool {
 	}
 	return status.IsValid()
 }
+
+func validateNotificationEvent(fl validator.FieldLevel) bool {
+	event, ok := fl.Field().Interface().(NotificationEvent)
+	if !ok {
+		return false
+	}
+	return event.IsValid()
+}
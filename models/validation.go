@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ErrInvalid matches every ValidationErrors, for callers that only need to
+// know a model was refused, e.g. to answer 422
+var ErrInvalid = errors.New("invalid")
+
+// FieldError is one field of a model that failed validation
+type FieldError struct {
+	// Field is the field's JSON path within the model, e.g. "email" or
+	// "items[0].quantity"
+	Field string `json:"field"`
+	// Message says what is wrong, e.g. "is required"
+	Message string `json:"message"`
+}
+
+// ValidationErrors lists the fields of a model that failed validation. Every
+// Validate method in this package returns it, so the JSON API and the web
+// forms report the same messages.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + " " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Is makes errors.Is(err, ErrInvalid) hold for validation failures
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// Get returns the message for a field, "" if it is valid
+func (e ValidationErrors) Get(field string) string {
+	for _, fe := range e {
+		if fe.Field == field {
+			return fe.Message
+		}
+	}
+	return ""
+}
+
+// invalid reports a rule that validator tags can't express, such as one
+// spanning two fields
+func invalid(field, message string) error {
+	return ValidationErrors{{Field: field, Message: message}}
+}
+
+// validateStruct checks v's validate tags, translating failures into
+// ValidationErrors
+func validateStruct(v any) error {
+	err := validate.Struct(v)
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+	errs := make(ValidationErrors, len(fieldErrs))
+	for i, fe := range fieldErrs {
+		// The namespace starts with the struct's type name
+		_, path, _ := strings.Cut(fe.Namespace(), ".")
+		errs[i] = FieldError{Field: path, Message: message(fe)}
+	}
+	return errs
+}
+
+// jsonName names fields in validation errors by their JSON name, so the
+// errors match what API clients send and what forms are named
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+// message explains a failed rule to whoever filled in the field
+func message(fe validator.FieldError) string {
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Map:
+		unit = " items"
+	}
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be an email address"
+	case "normalizedEmail":
+		return "must be lower case without surrounding spaces"
+	case "keypart":
+		return "can't contain " + keyDelimiter
+	case "slug":
+		return "must be lower case letters, digits and dashes"
+	case "http_url", "url":
+		return "must be a URL"
+	case "iso4217":
+		return "must be a currency code like USD"
+	case "iso3166_1_alpha2":
+		return "must be a two-letter country code"
+	case "max", "lte":
+		return fmt.Sprintf("must be at most %s%s", fe.Param(), unit)
+	case "min", "gte":
+		return fmt.Sprintf("must be at least %s%s", fe.Param(), unit)
+	case "gt":
+		return fmt.Sprintf("must be more than %s%s", fe.Param(), unit)
+	case "lt":
+		return fmt.Sprintf("must be less than %s%s", fe.Param(), unit)
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "orderStatus", "pageStatus", "jobStatus", "holdStatus", "paymentKind", "paymentStatus", "notificationEvent":
+		return "isn't a known value"
+	}
+	return "is invalid"
+}
@@ -0,0 +1,93 @@
+// Package money converts prices between currencies and formats them for
+// display. Currencies are ISO 4217 codes like "USD".
+package money
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"golang.org/x/text/currency"
+)
+
+// ErrUnsupportedCurrency means a converter has no rate for a currency
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// Converter converts amounts between currencies. StaticRates is a fixed
+// table; an implementation backed by a rates provider's API can stand in
+// for it.
+type Converter interface {
+	// Convert returns amount in from as an amount in to, rounded to the
+	// minor unit of to
+	Convert(ctx context.Context, amount float64, from, to string) (float64, error)
+}
+
+// StaticRates converts with a fixed table of how much of each currency one
+// US dollar buys
+type StaticRates map[string]float64
+
+// DefaultRates is the table used when the config has none. The rates are
+// rough and never updated, which is fine for showing shoppers an idea of
+// the price but not for charging them.
+var DefaultRates = StaticRates{
+	"USD": 1,
+	"EUR": 0.92,
+	"GBP": 0.79,
+	"CAD": 1.36,
+	"AUD": 1.52,
+	"JPY": 150,
+}
+
+func (s StaticRates) Convert(_ context.Context, amount float64, from, to string) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, ok := s[from]
+	if !ok || fromRate <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, from)
+	}
+	toRate, ok := s[to]
+	if !ok || toRate <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, to)
+	}
+	return Round(amount/fromRate*toRate, to), nil
+}
+
+// Round rounds amount to the minor unit of a currency: cents for USD,
+// whole yen for JPY
+func Round(amount float64, code string) float64 {
+	pow := math.Pow10(scale(code))
+	return math.Round(amount*pow) / pow
+}
+
+// symbols are the currencies written with a symbol rather than their code
+var symbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"CAD": "CA$",
+	"AUD": "A$",
+}
+
+// Format writes amount with its currency's symbol, or its code when it has
+// none, to the currency's minor unit. e.g. "$9.99", "¥1500", "CHF 9.99".
+func Format(amount float64, code string) string {
+	symbol, ok := symbols[code]
+	if !ok {
+		symbol = code + " "
+	}
+	return fmt.Sprintf("%s%.*f", symbol, scale(code), amount)
+}
+
+// scale is how many decimal places a currency's minor unit has, 2 for
+// unknown codes
+func scale(code string) int {
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return 2
+	}
+	digits, _ := currency.Standard.Rounding(unit)
+	return digits
+}
@@ -0,0 +1,58 @@
+package money
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStaticRates_Convert(t *testing.T) {
+	rates := StaticRates{"USD": 1, "EUR": 0.5, "JPY": 150}
+	ctx := context.Background()
+
+	tests := []struct {
+		amount   float64
+		from, to string
+		want     float64
+	}{
+		{10, "USD", "USD", 10},
+		{10, "USD", "EUR", 5},
+		{5, "EUR", "USD", 10},
+		{9.99, "USD", "JPY", 1499},
+		{1, "EUR", "JPY", 300},
+	}
+	for _, tt := range tests {
+		got, err := rates.Convert(ctx, tt.amount, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("Convert(%v, %s, %s): %v", tt.amount, tt.from, tt.to, err)
+		}
+		if got != tt.want {
+			t.Errorf("Convert(%v, %s, %s) = %v, want %v", tt.amount, tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if _, err := rates.Convert(ctx, 1, "USD", "CHF"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("Convert to CHF: err = %v, want ErrUnsupportedCurrency", err)
+	}
+	if _, err := rates.Convert(ctx, 1, "CHF", "USD"); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("Convert from CHF: err = %v, want ErrUnsupportedCurrency", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount float64
+		code   string
+		want   string
+	}{
+		{9.99, "USD", "$9.99"},
+		{9.5, "EUR", "€9.50"},
+		{1500, "JPY", "¥1500"},
+		{9.99, "CHF", "CHF 9.99"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.amount, tt.code); got != tt.want {
+			t.Errorf("Format(%v, %s) = %q, want %q", tt.amount, tt.code, got, tt.want)
+		}
+	}
+}
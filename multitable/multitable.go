@@ -0,0 +1,330 @@
+// Package multitable stores users, products and orders in a table each,
+// the way a relational schema would, behind the same repository.Users,
+// Products and Orders interfaces as the single table. It exists to compare
+// the two designs on identical workloads: reading a user with their orders
+// takes a request per table here instead of one query. It has none of the
+// single table's extras, like the audit log, hooks, key hashing or stats.
+package multitable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/schema"
+)
+
+// maxBatchGet is the most keys DynamoDB accepts in one BatchGetItem call
+const maxBatchGet = 100
+
+// Tables names the table of each entity
+type Tables struct {
+	Users    string
+	Products string
+	Orders   string
+}
+
+// TablesFor names the tables after prefix, e.g. AppTable_users
+func TablesFor(prefix string) Tables {
+	return Tables{
+		Users:    prefix + "_users",
+		Products: prefix + "_products",
+		Orders:   prefix + "_orders",
+	}
+}
+
+// keys are each table's key attributes, named after the model fields
+func (t Tables) keys() map[string][]string {
+	return map[string][]string{
+		t.Users:    {"email"},
+		t.Products: {"product_id"},
+		t.Orders:   {"user_email", "order_id"},
+	}
+}
+
+// EnsureTables creates whichever of the tables don't exist, on demand
+func EnsureTables(ctx context.Context, client *dynamodb.Client, tables Tables) error {
+	for name, keys := range tables.keys() {
+		_, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)})
+		var notFound *types.ResourceNotFoundException
+		if err == nil {
+			continue
+		}
+		if !errors.As(err, &notFound) {
+			return fmt.Errorf("failed to describe table: %w", err)
+		}
+
+		input := &dynamodb.CreateTableInput{
+			TableName:   aws.String(name),
+			BillingMode: types.BillingModePayPerRequest,
+		}
+		keyTypes := []types.KeyType{types.KeyTypeHash, types.KeyTypeRange}
+		for i, key := range keys {
+			input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+				AttributeName: aws.String(key), AttributeType: types.ScalarAttributeTypeS,
+			})
+			input.KeySchema = append(input.KeySchema, types.KeySchemaElement{
+				AttributeName: aws.String(key), KeyType: keyTypes[i],
+			})
+		}
+		if _, err := client.CreateTable(ctx, input); err != nil {
+			return fmt.Errorf("failed to create table %s: %w", name, err)
+		}
+		waiter := dynamodb.NewTableExistsWaiter(client)
+		if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)}, 5*time.Minute); err != nil {
+			return fmt.Errorf("failed waiting for table %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteTables deletes the tables, e.g. after a comparison run
+func DeleteTables(ctx context.Context, client *dynamodb.Client, tables Tables) error {
+	for name := range tables.keys() {
+		if _, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(name)}); err != nil {
+			return fmt.Errorf("failed to delete table %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// New returns the repositories of the multi-table backend
+func New(client *dynamodb.Client, tables Tables) repository.Backend {
+	store := &store{client: client, tables: tables}
+	return repository.Backend{
+		Users:    &Users{store},
+		Products: &Products{store},
+		Orders:   &Orders{store},
+	}
+}
+
+// Open returns the backend cfg.Backend names, with its tables created. The
+// single table is cfg.TableName; the multi-table backend's tables are named
+// after it.
+func Open(ctx context.Context, client *dynamodb.Client, cfg config.Config, opts ...repository.StoreOption) (repository.Backend, error) {
+	switch cfg.Backend {
+	case repository.BackendSingle, "":
+		if err := schema.EnsureTable(ctx, client, cfg.TableName); err != nil {
+			return repository.Backend{}, err
+		}
+		return repository.Backend{
+			Users:    repository.NewUserRepository(client, cfg.TableName, opts...),
+			Products: repository.NewProductRepository(client, cfg.TableName, opts...),
+			Orders:   repository.NewOrderRepository(client, cfg.TableName, opts...),
+		}, nil
+	case repository.BackendMulti:
+		tables := TablesFor(cfg.TableName)
+		if err := EnsureTables(ctx, client, tables); err != nil {
+			return repository.Backend{}, err
+		}
+		return New(client, tables), nil
+	default:
+		return repository.Backend{}, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+type store struct {
+	client *dynamodb.Client
+	tables Tables
+}
+
+func (s *store) put(ctx context.Context, table string, entity any) error {
+	av, err := attributevalue.MarshalMap(entity)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item: %w", err)
+	}
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: av})
+	if err != nil {
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+	return nil
+}
+
+func (s *store) get(ctx context.Context, table string, key map[string]string, out any) error {
+	av := make(map[string]types.AttributeValue, len(key))
+	for name, value := range key {
+		av[name] = &types.AttributeValueMemberS{Value: value}
+	}
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(table), Key: av})
+	if err != nil {
+		return fmt.Errorf("failed to get item: %w", err)
+	}
+	if result.Item == nil {
+		return repository.ErrNotFound
+	}
+	if err := attributevalue.UnmarshalMap(result.Item, out); err != nil {
+		return fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+	return nil
+}
+
+// Users stores users in their own table, keyed by email
+type Users struct {
+	store *store
+}
+
+func (r *Users) Put(ctx context.Context, user models.User) error {
+	user.Email = models.NormalizeEmail(user.Email)
+	if err := user.Validate(); err != nil {
+		return err
+	}
+	return r.store.put(ctx, r.store.tables.Users, user)
+}
+
+func (r *Users) Get(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	key := map[string]string{"email": models.NormalizeEmail(email)}
+	if err := r.store.get(ctx, r.store.tables.Users, key, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserWithOrders reads the user from one table and their orders from
+// another. The aggregate has no addresses or stats, which this backend
+// doesn't store.
+func (r *Users) GetUserWithOrders(ctx context.Context, email string) (*repository.UserAggregate, error) {
+	user, err := r.Get(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	aggregate := &repository.UserAggregate{User: *user}
+	orders := &Orders{r.store}
+	opts := &repository.QueryOptions{}
+	for {
+		page, err := orders.GetUserOrders(ctx, user.Email, opts)
+		if err != nil {
+			return nil, err
+		}
+		aggregate.Orders = append(aggregate.Orders, page.Orders...)
+		if page.NextPageToken == nil {
+			return aggregate, nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
+
+// Products stores products in their own table, keyed by product ID
+type Products struct {
+	store *store
+}
+
+func (r *Products) Put(ctx context.Context, product models.Product) error {
+	if err := product.Validate(); err != nil {
+		return err
+	}
+	return r.store.put(ctx, r.store.tables.Products, product)
+}
+
+func (r *Products) Get(ctx context.Context, productID string) (*models.Product, error) {
+	var product models.Product
+	if err := r.store.get(ctx, r.store.tables.Products, map[string]string{"product_id": productID}, &product); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// GetMany reads products by ID in batches, leaving out the ones that
+// don't exist
+func (r *Products) GetMany(ctx context.Context, productIDs []string) (map[string]models.Product, error) {
+	products := make(map[string]models.Product, len(productIDs))
+	table := r.store.tables.Products
+	for start := 0; start < len(productIDs); start += maxBatchGet {
+		var keys []map[string]types.AttributeValue
+		for _, id := range productIDs[start:min(start+maxBatchGet, len(productIDs))] {
+			keys = append(keys, map[string]types.AttributeValue{"product_id": &types.AttributeValueMemberS{Value: id}})
+		}
+
+		for attempt := 0; len(keys) > 0; attempt++ {
+			if attempt == 5 {
+				return nil, fmt.Errorf("%d products still unprocessed after %d attempts", len(keys), attempt)
+			}
+			out, err := r.store.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]types.KeysAndAttributes{table: {Keys: keys}},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to batch get products: %w", err)
+			}
+			for _, av := range out.Responses[table] {
+				var product models.Product
+				if err := attributevalue.UnmarshalMap(av, &product); err != nil {
+					return nil, fmt.Errorf("failed to unmarshal product: %w", err)
+				}
+				products[product.ProductID] = product
+			}
+			keys = out.UnprocessedKeys[table].Keys
+		}
+	}
+	return products, nil
+}
+
+// Orders stores orders in their own table, keyed by user email and order ID
+type Orders struct {
+	store *store
+}
+
+func (r *Orders) Put(ctx context.Context, order models.Order) error {
+	order.UserEmail = models.NormalizeEmail(order.UserEmail)
+	if err := order.Validate(); err != nil {
+		return err
+	}
+	return r.store.put(ctx, r.store.tables.Orders, order)
+}
+
+func (r *Orders) Get(ctx context.Context, userEmail, orderID string) (*models.Order, error) {
+	var order models.Order
+	key := map[string]string{"user_email": models.NormalizeEmail(userEmail), "order_id": orderID}
+	if err := r.store.get(ctx, r.store.tables.Orders, key, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetUserOrders pages through a user's orders by order ID. opts.Filter
+// isn't supported and is ignored.
+func (r *Orders) GetUserOrders(ctx context.Context, userEmail string, opts *repository.QueryOptions) (*repository.OrdersPage, error) {
+	if opts == nil {
+		opts = &repository.QueryOptions{}
+	}
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key("user_email").Equal(expression.Value(models.NormalizeEmail(userEmail)))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.store.tables.Orders),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ExclusiveStartKey:         opts.PageToken.Raw(),
+		ScanIndexForward:          aws.Bool(!opts.Descending),
+	}
+	if opts.Limit > 0 {
+		input.Limit = aws.Int32(opts.Limit)
+	}
+	out, err := r.store.client.Query(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders: %w", err)
+	}
+
+	page := &repository.OrdersPage{
+		NextPageToken: repository.NewPageToken(out.LastEvaluatedKey),
+		PageInfo:      repository.PageInfo{Count: out.Count, ScannedCount: out.ScannedCount},
+	}
+	page.HasMore = page.NextPageToken != nil
+	if err := attributevalue.UnmarshalListOfMaps(out.Items, &page.Orders); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal orders: %w", err)
+	}
+	return page, nil
+}
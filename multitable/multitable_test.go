@@ -0,0 +1,54 @@
+package multitable
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+func TestBackend(t *testing.T) {
+	client := testutil.CreateTestClient(t)
+	ctx := context.Background()
+	tables := TablesFor("test_multitable_" + uuid.New().String())
+	if err := EnsureTables(ctx, client, tables); err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+	defer DeleteTables(ctx, client, tables)
+	backend := New(client, tables)
+
+	userFixture := fixtures.NewUser()
+	productFixture := fixtures.NewProduct()
+	user, product := userFixture.Build(), productFixture.Build()
+	orderFixture := fixtures.NewOrderFor(user).WithProducts(product.ProductID)
+	order := orderFixture.Build()
+	fixtures.Seed(t, fixtures.Repos{Users: backend.Users, Orders: backend.Orders, Products: backend.Products},
+		userFixture, productFixture, orderFixture)
+
+	// Test the user comes back with their orders from the other table
+	aggregate, err := backend.Users.GetUserWithOrders(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to get user with orders: %v", err)
+	}
+	if aggregate.User.Email != user.Email || len(aggregate.Orders) != 1 || aggregate.Orders[0].OrderID != order.OrderID {
+		t.Errorf("GetUserWithOrders() = %+v, want the user and their order", aggregate)
+	}
+
+	// Test GetMany leaves out products that don't exist
+	products, err := backend.Products.GetMany(ctx, []string{product.ProductID, "missing"})
+	if err != nil {
+		t.Fatalf("Failed to get products: %v", err)
+	}
+	if len(products) != 1 || products[product.ProductID].Name != product.Name {
+		t.Errorf("GetMany() = %+v, want only %s", products, product.ProductID)
+	}
+
+	if _, err := backend.Orders.Get(ctx, user.Email, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get missing order: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,32 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"LearnSingleTableDesign/models"
+)
+
+// EmailJobType is the job type for sending an email from the job queue
+const EmailJobType = "email.send"
+
+// EmailJobPayload encodes a message as a job payload
+func EmailJobPayload(msg Message) (string, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode email: %w", err)
+	}
+	return string(b), nil
+}
+
+// EmailJob returns a job handler that sends the message in the payload
+func EmailJob(mailer Mailer) func(ctx context.Context, job models.Job) error {
+	return func(ctx context.Context, job models.Job) error {
+		var msg Message
+		if err := json.Unmarshal([]byte(job.Payload), &msg); err != nil {
+			return fmt.Errorf("failed to decode email: %w", err)
+		}
+		return mailer.Send(ctx, msg)
+	}
+}
@@ -0,0 +1,51 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"LearnSingleTableDesign/models"
+)
+
+// SettingsPath is where a user changes which emails they get
+const SettingsPath = "/notifications"
+
+// SettingsLinks signs links to a user's notification settings. There is no
+// customer sign-in, so the link in each order email is what proves the
+// visitor is the user it was sent to.
+type SettingsLinks struct {
+	secret []byte
+	// BaseURL is where the web server is reached, e.g. https://shop.example.com
+	BaseURL string
+}
+
+// NewSettingsLinks creates SettingsLinks signing with secret
+func NewSettingsLinks(secret, baseURL string) *SettingsLinks {
+	return &SettingsLinks{secret: []byte(secret), BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Path returns the signed path to the settings of the user with email
+func (l *SettingsLinks) Path(email string) string {
+	email = models.NormalizeEmail(email)
+	return SettingsPath + "?" + url.Values{"email": {email}, "sig": {l.sign(email)}}.Encode()
+}
+
+// URL returns the signed link to the settings of the user with email
+func (l *SettingsLinks) URL(email string) string {
+	return l.BaseURL + l.Path(email)
+}
+
+// Verify reports whether sig was signed for email
+func (l *SettingsLinks) Verify(email, sig string) bool {
+	want := l.sign(models.NormalizeEmail(email))
+	return hmac.Equal([]byte(sig), []byte(want))
+}
+
+func (l *SettingsLinks) sign(email string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte("notification-settings:" + email))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,51 @@
+// Package notifications emails customers about their orders through a
+// pluggable Mailer.
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"LearnSingleTableDesign/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer logs messages instead of sending them, for local development
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.Info("email", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// NoopMailer drops every message
+type NoopMailer struct{}
+
+func (NoopMailer) Send(ctx context.Context, msg Message) error {
+	return nil
+}
+
+// NewMailer creates the Mailer selected by cfg.Mailer
+func NewMailer(ctx context.Context, cfg config.Config) (Mailer, error) {
+	switch cfg.Mailer {
+	case "log":
+		return LogMailer{}, nil
+	case "none":
+		return NoopMailer{}, nil
+	case "ses":
+		return NewSESMailer(ctx, cfg.Region, cfg.MailFrom)
+	}
+	return nil, fmt.Errorf("unknown mailer %q", cfg.Mailer)
+}
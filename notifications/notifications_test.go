@@ -0,0 +1,225 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+// recordingMailer sends each message on a channel
+type recordingMailer chan Message
+
+func (r recordingMailer) Send(ctx context.Context, msg Message) error {
+	r <- msg
+	return nil
+}
+
+func TestOrderEmail(t *testing.T) {
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).WithID("ORD1").WithProducts("PROD1", "PROD2").Build()
+
+	// Test new orders get a confirmation
+	msg, err := OrderEmail(order)
+	if err != nil {
+		t.Fatalf("Failed to build email: %v", err)
+	}
+	if msg.To != order.UserEmail || msg.Subject != "Order ORD1 confirmed" {
+		t.Errorf("Unexpected confirmation %+v", msg)
+	}
+	if !strings.Contains(msg.Body, "Products: PROD1, PROD2") {
+		t.Errorf("Expected products in body, got %q", msg.Body)
+	}
+
+	// Test later statuses get a status update
+	order.Status = models.OrderStatusCompleted
+	msg, err = OrderEmail(order)
+	if err != nil {
+		t.Fatalf("Failed to build email: %v", err)
+	}
+	if msg.Subject != "Order ORD1 is completed" {
+		t.Errorf("Subject = %q, want %q", msg.Subject, "Order ORD1 is completed")
+	}
+}
+
+func TestOrderNotifier(t *testing.T) {
+	sent := make(recordingMailer, 10)
+	notifier := NewOrderNotifier(sent, nil, 10)
+	hook := notifier.Hook()
+
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).Build()
+	av, err := attributevalue.MarshalMap(repository.GenericItem[models.Order]{
+		PK: repository.Key.UserPK(order.UserEmail), SK: repository.Key.OrderSK(order.OrderID),
+		EntityType: repository.EntityOrder, Data: order,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only order puts that went through send email
+	hook(context.Background(), repository.Change{Operation: repository.OperationPut, Phase: repository.AfterWrite, EntityType: repository.EntityProduct, Item: av})
+	hook(context.Background(), repository.Change{Operation: repository.OperationPut, Phase: repository.BeforeWrite, EntityType: repository.EntityOrder, Item: av})
+	hook(context.Background(), repository.Change{Operation: repository.OperationPut, Phase: repository.AfterWrite, EntityType: repository.EntityOrder, Item: av})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Run(ctx)
+
+	select {
+	case msg := <-sent:
+		if msg.To != order.UserEmail {
+			t.Errorf("To = %q, want %q", msg.To, order.UserEmail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the email")
+	}
+	if len(notifier.pending) != 0 {
+		t.Errorf("Expected the queue to be drained, %d left", len(notifier.pending))
+	}
+}
+
+// staticPrefs gives every user the same prefs
+type staticPrefs models.NotificationPrefs
+
+func (p staticPrefs) Get(ctx context.Context, email string) (*models.NotificationPrefs, error) {
+	prefs := models.NotificationPrefs(p)
+	prefs.UserEmail = email
+	return &prefs, nil
+}
+
+func TestOrderNotifier_Preferences(t *testing.T) {
+	sent := make(recordingMailer, 10)
+	prefs := staticPrefs{Email: map[models.NotificationEvent]bool{models.NotificationOrderProcessing: false}}
+	notifier := NewOrderNotifier(sent, prefs, 10)
+
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).WithID("ORD1").Build()
+	notifier.send(context.Background(), order)
+	order.Status = models.OrderStatusProcessing
+	notifier.send(context.Background(), order)
+	order.Status = models.OrderStatusCompleted
+	notifier.send(context.Background(), order)
+
+	var subjects []string
+	for len(sent) > 0 {
+		subjects = append(subjects, (<-sent).Subject)
+	}
+	want := []string{"Order ORD1 confirmed", "Order ORD1 is completed"}
+	if strings.Join(subjects, "|") != strings.Join(want, "|") {
+		t.Errorf("Sent %q, want %q without the turned off processing email", subjects, want)
+	}
+}
+
+func TestSettingsLinks(t *testing.T) {
+	links := NewSettingsLinks("secret", "https://shop.example.com/")
+
+	link, err := url.Parse(links.URL("Ann@Example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Host != "shop.example.com" || link.Path != SettingsPath {
+		t.Errorf("URL = %s, want the settings page", link)
+	}
+	email, sig := link.Query().Get("email"), link.Query().Get("sig")
+	if email != "ann@example.com" || !links.Verify(email, sig) {
+		t.Errorf("Link for %q with %q doesn't verify", email, sig)
+	}
+
+	// Test a signature only works for the email it was made for, with the
+	// secret it was made with
+	if links.Verify("bob@example.com", sig) {
+		t.Error("Signature verified for another user")
+	}
+	if NewSettingsLinks("other", "").Verify(email, sig) {
+		t.Error("Signature verified with another secret")
+	}
+}
+
+func TestOrderNotifier_SettingsLink(t *testing.T) {
+	sent := make(recordingMailer, 1)
+	notifier := NewOrderNotifier(sent, nil, 1)
+	notifier.Settings = NewSettingsLinks("secret", "https://shop.example.com")
+
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).Build()
+	notifier.send(context.Background(), order)
+	if msg := <-sent; !strings.Contains(msg.Body, notifier.Settings.URL(order.UserEmail)) {
+		t.Errorf("Body = %q, want a link to the settings", msg.Body)
+	}
+}
+
+func TestSESMailer(t *testing.T) {
+	var got sendEmailRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/email/outbound-emails" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	mailer := &SESMailer{
+		From:   "orders@example.com",
+		Region: "us-east-1",
+		Credentials: credentials.StaticCredentialsProvider{
+			Value: aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		},
+		Endpoint: server.URL,
+		Client:   server.Client(),
+	}
+	err := mailer.Send(context.Background(), Message{To: "test@example.com", Subject: "Hi", Body: "Hello"})
+	if err != nil {
+		t.Fatalf("Failed to send email: %v", err)
+	}
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-east-1/ses/") {
+		t.Errorf("Expected a SigV4 signature for ses, got %q", auth)
+	}
+	if got.FromEmailAddress != "orders@example.com" || got.Destination.ToAddresses[0] != "test@example.com" {
+		t.Errorf("Unexpected request %+v", got)
+	}
+	if got.Content.Simple.Subject.Data != "Hi" || got.Content.Simple.Body.Text.Data != "Hello" {
+		t.Errorf("Unexpected content %+v", got.Content)
+	}
+}
+
+// recordingQueue keeps the jobs it's asked to enqueue
+type recordingQueue []models.Job
+
+func (q *recordingQueue) Enqueue(ctx context.Context, jobType, payload string, runAt time.Time, maxAttempts int) (*models.Job, error) {
+	job := models.Job{Type: jobType, Payload: payload, MaxAttempts: maxAttempts}
+	*q = append(*q, job)
+	return &job, nil
+}
+
+func TestLowStockEmail(t *testing.T) {
+	var queue recordingQueue
+	alert := LowStockEmail(&queue, "ops@example.com")
+	alert(context.Background(), models.Product{ProductID: "PROD1", Name: "Widget", Stock: 3, LowStockThreshold: 5})
+
+	if len(queue) != 1 || queue[0].Type != EmailJobType {
+		t.Fatalf("Queued jobs = %+v, want one email job", queue)
+	}
+
+	// Test the queued job sends the alert when it runs
+	mailer := make(recordingMailer, 1)
+	if err := EmailJob(mailer)(context.Background(), queue[0]); err != nil {
+		t.Fatalf("Failed to run email job: %v", err)
+	}
+	msg := <-mailer
+	if msg.To != "ops@example.com" || !strings.Contains(msg.Body, "down to 3") {
+		t.Errorf("Email = %+v", msg)
+	}
+}
@@ -0,0 +1,133 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"text/template"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/repository"
+)
+
+var confirmationTemplate = template.Must(template.New("confirmation").Funcs(template.FuncMap{"money": money.Format}).Parse(`Thanks for your order!
+
+Order {{.OrderID}}
+Total: {{money .Total .PriceCurrency}}
+Products: {{if .Items}}{{range $i, $item := .Items}}{{if $i}}, {{end}}{{$item.Name}}{{if gt $item.Quantity 1}} x{{$item.Quantity}}{{end}}{{end}}{{else}}{{range $i, $p := .LegacyProducts}}{{if $i}}, {{end}}{{$p}}{{end}}{{end}}
+
+We'll email you again when its status changes.
+`))
+
+var statusChangeTemplate = template.Must(template.New("status").Parse(`Your order {{.OrderID}} is now {{.Status}}.
+`))
+
+// OrderEmail builds the email for an order entering its current status:
+// a confirmation for new (pending) orders, a status update otherwise
+func OrderEmail(order models.Order) (Message, error) {
+	msg := Message{To: order.UserEmail}
+	tmpl := statusChangeTemplate
+	if order.Status == models.OrderStatusPending {
+		tmpl = confirmationTemplate
+		msg.Subject = fmt.Sprintf("Order %s confirmed", order.OrderID)
+	} else {
+		msg.Subject = fmt.Sprintf("Order %s is %s", order.OrderID, order.Status)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, order); err != nil {
+		return Message{}, fmt.Errorf("failed to render order email: %w", err)
+	}
+	msg.Body = body.String()
+	return msg, nil
+}
+
+// Preferences looks up which emails a user wants, e.g. a
+// repository.NotificationPrefsRepository
+type Preferences interface {
+	Get(ctx context.Context, email string) (*models.NotificationPrefs, error)
+}
+
+// OrderNotifier emails customers when their orders are placed or change
+// status. Register Hook on the order store with repository.OnPut and start
+// Run in a goroutine.
+type OrderNotifier struct {
+	mailer Mailer
+	// prefs is consulted before each email; nil emails everything
+	prefs   Preferences
+	pending chan models.Order
+	// Settings links each email to the customer's notification settings;
+	// nil leaves the link out
+	Settings *SettingsLinks
+}
+
+// NewOrderNotifier creates an OrderNotifier that queues up to buffer
+// emails, leaving out those their customers turned off in prefs
+func NewOrderNotifier(mailer Mailer, prefs Preferences, buffer int) *OrderNotifier {
+	return &OrderNotifier{
+		mailer:  mailer,
+		prefs:   prefs,
+		pending: make(chan models.Order, buffer),
+	}
+}
+
+// Hook queues an email for every order put through the store, once the
+// write has succeeded; register it with repository.OnPut. Writes that fail
+// or are cancelled send nothing. Hooks don't see the previous item, so
+// saving an order again without changing its status sends the email
+// again. It never blocks a write: when the queue is full the email is
+// dropped with a warning.
+func (n *OrderNotifier) Hook() repository.ChangeHook {
+	return repository.Typed(repository.EntityOrder, func(ctx context.Context, change repository.Change, item repository.GenericItem[models.Order]) {
+		if change.Phase != repository.AfterWrite {
+			return
+		}
+		select {
+		case n.pending <- item.Data:
+		default:
+			slog.Warn("notification queue full, dropping email", "order_id", item.Data.OrderID)
+		}
+	})
+}
+
+// Run sends queued emails until ctx is done
+func (n *OrderNotifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case order := <-n.pending:
+			n.send(ctx, order)
+		}
+	}
+}
+
+// send emails the customer about their order, unless they turned that
+// email off. When their prefs can't be read nothing is sent, rather than
+// risk emailing someone who asked not to be.
+func (n *OrderNotifier) send(ctx context.Context, order models.Order) {
+	if n.prefs != nil {
+		prefs, err := n.prefs.Get(ctx, order.UserEmail)
+		if err != nil {
+			slog.Error("failed to load notification prefs, not emailing", "order_id", order.OrderID, "error", err)
+			return
+		}
+		if event := models.OrderNotificationEvent(order.Status); !prefs.Emails(event) {
+			slog.Debug("order email turned off", "order_id", order.OrderID, "event", event)
+			return
+		}
+	}
+
+	msg, err := OrderEmail(order)
+	if err == nil {
+		if n.Settings != nil {
+			msg.Body += "\nChoose which emails you get: " + n.Settings.URL(order.UserEmail) + "\n"
+		}
+		err = n.mailer.Send(ctx, msg)
+	}
+	if err != nil {
+		slog.Error("failed to send order email", "order_id", order.OrderID, "error", err)
+	}
+}
@@ -0,0 +1,104 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// SESMailer sends email through the Amazon SES v2 SendEmail API, signing
+// requests with the default AWS credentials chain
+type SESMailer struct {
+	// From is a verified SES identity
+	From        string
+	Region      string
+	Credentials aws.CredentialsProvider
+	// Endpoint defaults to the regional SES endpoint
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewSESMailer creates an SESMailer using the default AWS config chain
+func NewSESMailer(ctx context.Context, region, from string) (*SESMailer, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	return &SESMailer{
+		From:        from,
+		Region:      region,
+		Credentials: awsCfg.Credentials,
+		Endpoint:    fmt.Sprintf("https://email.%s.amazonaws.com", region),
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// sesContent is the UTF-8 text of a subject or body
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset"`
+}
+
+// sendEmailRequest is the body of a SES v2 SendEmail call
+type sendEmailRequest struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Simple struct {
+			Subject sesContent `json:"Subject"`
+			Body    struct {
+				Text sesContent `json:"Text"`
+			} `json:"Body"`
+		} `json:"Simple"`
+	} `json:"Content"`
+}
+
+func (m *SESMailer) Send(ctx context.Context, msg Message) error {
+	var payload sendEmailRequest
+	payload.FromEmailAddress = m.From
+	payload.Destination.ToAddresses = []string{msg.To}
+	payload.Content.Simple.Subject = sesContent{Data: msg.Subject, Charset: "UTF-8"}
+	payload.Content.Simple.Body.Text = sesContent{Data: msg.Body, Charset: "UTF-8"}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal email: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.Endpoint+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	creds, err := m.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "ses", m.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign SES request: %w", err)
+	}
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to send email: SES returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// emailJobAttempts is how often a queued email is retried
+const emailJobAttempts = 5
+
+// EmailQueue is the part of repository.JobRepository needed to queue emails
+type EmailQueue interface {
+	Enqueue(ctx context.Context, jobType, payload string, runAt time.Time, maxAttempts int) (*models.Job, error)
+}
+
+// LowStockEmail returns an alert that emails to about products running low.
+// The email goes through the job queue, so the reservation that crossed the
+// threshold doesn't wait for it to send.
+func LowStockEmail(queue EmailQueue, to string) repository.LowStockAlert {
+	return func(ctx context.Context, product models.Product) {
+		payload, err := EmailJobPayload(Message{
+			To:      to,
+			Subject: fmt.Sprintf("%s is low on stock", product.Name),
+			Body: fmt.Sprintf("%s (%s) is down to %d, below its threshold of %d.\n",
+				product.Name, product.ProductID, product.Stock, product.LowStockThreshold),
+		})
+		if err == nil {
+			_, err = queue.Enqueue(ctx, EmailJobType, payload, time.Now(), emailJobAttempts)
+		}
+		if err != nil {
+			slog.Error("failed to queue low stock email", "product_id", product.ProductID, "error", err)
+		}
+	}
+}
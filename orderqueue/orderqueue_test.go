@@ -0,0 +1,135 @@
+package orderqueue
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+func TestSQS_Call(t *testing.T) {
+	var target string
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("Authorization") == "" {
+			t.Error("Request was not signed")
+		}
+		w.Write([]byte(`{"Messages":[{"MessageId":"m1","ReceiptHandle":"r1","Body":"hi"}]}`))
+	}))
+	defer srv.Close()
+
+	q := &SQS{
+		QueueURL:    srv.URL + "/000000000000/orders",
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		Endpoint:    srv.URL,
+		Client:      srv.Client(),
+	}
+	messages, err := q.Receive(context.Background(), 10, 20*time.Second, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if target != "AmazonSQS.ReceiveMessage" || body["QueueUrl"] != q.QueueURL || body["WaitTimeSeconds"] != float64(20) {
+		t.Errorf("Unexpected request %s %v", target, body)
+	}
+	if len(messages) != 1 || messages[0].ReceiptHandle != "r1" || messages[0].Body != "hi" {
+		t.Errorf("Messages = %+v", messages)
+	}
+}
+
+func TestPublisher_Hook(t *testing.T) {
+	p := NewPublisher(nil, 10)
+	hook := p.Hook()
+
+	put := func(phase repository.Phase, status models.OrderStatus) {
+		av, err := attributevalue.MarshalMap(repository.GenericItem[models.Order]{
+			PK: repository.Key.UserPK("a@example.com"), SK: repository.Key.OrderSK(string(status)),
+			EntityType: repository.EntityOrder,
+			Data:       models.Order{OrderID: string(status), UserEmail: "a@example.com", Status: status},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hook(context.Background(), repository.Change{Operation: repository.OperationPut, Phase: phase, EntityType: repository.EntityOrder, Item: av})
+	}
+
+	// Only pending orders that were stored are queued
+	put(repository.BeforeWrite, models.OrderStatusPending)
+	put(repository.AfterWrite, models.OrderStatusCompleted)
+	put(repository.AfterWrite, models.OrderStatusPending)
+
+	if len(p.pending) != 1 {
+		t.Fatalf("Queued %d orders, want 1", len(p.pending))
+	}
+	if msg := <-p.pending; msg.OrderID != string(models.OrderStatusPending) {
+		t.Errorf("Queued %+v, want the pending order", msg)
+	}
+}
+
+type fakeReceiver struct {
+	deleted []string
+}
+
+func (f *fakeReceiver) Receive(ctx context.Context, max int, wait, visibility time.Duration) ([]Message, error) {
+	return nil, nil
+}
+
+func (f *fakeReceiver) Delete(ctx context.Context, receiptHandle string) error {
+	f.deleted = append(f.deleted, receiptHandle)
+	return nil
+}
+
+// fakeOrders holds order statuses by ID and applies transitions like the repository
+type fakeOrders map[string]models.OrderStatus
+
+func (f fakeOrders) Transition(ctx context.Context, userEmail, orderID string, from, to models.OrderStatus) (*models.Order, error) {
+	status, ok := f[orderID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	if status != from {
+		return nil, repository.ErrConditionFailed
+	}
+	f[orderID] = to
+	return &models.Order{OrderID: orderID, Status: to}, nil
+}
+
+func TestConsumer_Handle(t *testing.T) {
+	orders := fakeOrders{
+		"pending":    models.OrderStatusPending,
+		"processing": models.OrderStatusProcessing,
+		"cancelled":  models.OrderStatusCancelled,
+	}
+	receiver := &fakeReceiver{}
+	consumer := NewConsumer(receiver, orders)
+
+	for _, id := range []string{"pending", "processing", "cancelled", "missing"} {
+		body, _ := json.Marshal(OrderMessage{UserEmail: "a@example.com", OrderID: id})
+		consumer.Handle(context.Background(), Message{ReceiptHandle: id, Body: string(body)})
+	}
+	consumer.Handle(context.Background(), Message{ReceiptHandle: "garbage", Body: "{"})
+
+	want := fakeOrders{
+		"pending":    models.OrderStatusCompleted,
+		"processing": models.OrderStatusCompleted,
+		"cancelled":  models.OrderStatusCancelled,
+	}
+	for id, status := range want {
+		if orders[id] != status {
+			t.Errorf("Order %s status = %s, want %s", id, orders[id], status)
+		}
+	}
+	if len(receiver.deleted) != 5 {
+		t.Errorf("Deleted %v, want every message", receiver.deleted)
+	}
+}
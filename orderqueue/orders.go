@@ -0,0 +1,167 @@
+package orderqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// OrderMessage identifies a placed order
+type OrderMessage struct {
+	UserEmail string `json:"user_email"`
+	OrderID   string `json:"order_id"`
+}
+
+// Sender is the part of SQS the Publisher needs
+type Sender interface {
+	Send(ctx context.Context, body string) error
+}
+
+// Publisher sends placed orders to the queue
+type Publisher struct {
+	sender  Sender
+	pending chan OrderMessage
+}
+
+// NewPublisher creates a Publisher that buffers up to buffer orders
+func NewPublisher(sender Sender, buffer int) *Publisher {
+	return &Publisher{
+		sender:  sender,
+		pending: make(chan OrderMessage, buffer),
+	}
+}
+
+// Hook queues every pending order put through the store once the write
+// has succeeded; register it with repository.OnPut. Writes that fail or are
+// cancelled queue nothing. Saving a pending order again queues it again;
+// the consumer's conditional transition makes the duplicate a no-op. It
+// never blocks a write: when the buffer is full the order is dropped with a
+// warning.
+func (p *Publisher) Hook() repository.ChangeHook {
+	return repository.Typed(repository.EntityOrder, func(ctx context.Context, change repository.Change, item repository.GenericItem[models.Order]) {
+		if change.Phase != repository.AfterWrite || item.Data.Status != models.OrderStatusPending {
+			return
+		}
+		select {
+		case p.pending <- OrderMessage{UserEmail: item.Data.UserEmail, OrderID: item.Data.OrderID}:
+		default:
+			slog.Warn("order queue buffer full, dropping order", "order_id", item.Data.OrderID)
+		}
+	})
+}
+
+// Run sends buffered orders until ctx is done
+func (p *Publisher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-p.pending:
+			body, err := json.Marshal(msg)
+			if err == nil {
+				err = p.sender.Send(ctx, string(body))
+			}
+			if err != nil {
+				slog.Error("failed to queue order", "order_id", msg.OrderID, "error", err)
+			}
+		}
+	}
+}
+
+// Receiver is the part of SQS the Consumer needs
+type Receiver interface {
+	Receive(ctx context.Context, max int, wait, visibility time.Duration) ([]Message, error)
+	Delete(ctx context.Context, receiptHandle string) error
+}
+
+// Transitioner is the part of repository.OrderRepository the Consumer needs
+type Transitioner interface {
+	Transition(ctx context.Context, userEmail, orderID string, from, to models.OrderStatus) (*models.Order, error)
+}
+
+// Consumer processes queued orders, moving each from pending through
+// processing to completed
+type Consumer struct {
+	receiver Receiver
+	orders   Transitioner
+	// Visibility is how long a received message is hidden while it's
+	// processed; unhandled messages are redelivered after it
+	Visibility time.Duration
+}
+
+// NewConsumer creates a Consumer
+func NewConsumer(receiver Receiver, orders Transitioner) *Consumer {
+	return &Consumer{
+		receiver:   receiver,
+		orders:     orders,
+		Visibility: 30 * time.Second,
+	}
+}
+
+// Run long-polls the queue until ctx is done
+func (c *Consumer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		messages, err := c.receiver.Receive(ctx, 10, 20*time.Second, c.Visibility)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("failed to receive orders", "error", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		for _, msg := range messages {
+			c.Handle(ctx, msg)
+		}
+	}
+}
+
+// Handle processes one message, deleting it once the order is done with.
+// Messages that fail for a transient reason are left for redelivery.
+func (c *Consumer) Handle(ctx context.Context, msg Message) {
+	var order OrderMessage
+	if err := json.Unmarshal([]byte(msg.Body), &order); err != nil {
+		slog.Error("dropping malformed order message", "message_id", msg.MessageID, "error", err)
+		c.delete(ctx, msg)
+		return
+	}
+
+	if err := c.process(ctx, order); err != nil {
+		slog.Error("failed to process order", "order_id", order.OrderID, "error", err)
+		return
+	}
+	c.delete(ctx, msg)
+}
+
+func (c *Consumer) process(ctx context.Context, order OrderMessage) error {
+	steps := []struct{ from, to models.OrderStatus }{
+		{models.OrderStatusPending, models.OrderStatusProcessing},
+		{models.OrderStatusProcessing, models.OrderStatusCompleted},
+	}
+	for _, step := range steps {
+		_, err := c.orders.Transition(ctx, order.UserEmail, order.OrderID, step.from, step.to)
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			slog.Warn("queued order no longer exists", "order_id", order.OrderID)
+			return nil
+		case errors.Is(err, repository.ErrConditionFailed):
+			// Not in this step's status: a duplicate message, another
+			// consumer or an earlier crash already moved it on, or it
+			// was cancelled
+			slog.Info("skipping order status step", "order_id", order.OrderID, "from", step.from, "to", step.to)
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) delete(ctx context.Context, msg Message) {
+	if err := c.receiver.Delete(ctx, msg.ReceiptHandle); err != nil {
+		slog.Error("failed to delete order message", "message_id", msg.MessageID, "error", err)
+	}
+}
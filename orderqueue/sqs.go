@@ -0,0 +1,142 @@
+// Package orderqueue hands placed orders to a separate consumer through
+// Amazon SQS (or ElasticMQ locally), which moves them through fulfilment.
+package orderqueue
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	"LearnSingleTableDesign/config"
+)
+
+// SQS is a minimal client for the SQS JSON API, covering just the calls the
+// order queue needs
+type SQS struct {
+	QueueURL    string
+	Region      string
+	Credentials aws.CredentialsProvider
+	// Endpoint defaults to the scheme and host of QueueURL
+	Endpoint string
+	Client   *http.Client
+}
+
+// Message is a received SQS message
+type Message struct {
+	MessageID     string `json:"MessageId"`
+	ReceiptHandle string `json:"ReceiptHandle"`
+	Body          string `json:"Body"`
+}
+
+// NewSQS creates a client for cfg.OrderQueueURL. In local mode it uses
+// dummy credentials, which ElasticMQ accepts.
+func NewSQS(ctx context.Context, cfg config.Config) (*SQS, error) {
+	u, err := url.Parse(cfg.OrderQueueURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid queue URL %q", cfg.OrderQueueURL)
+	}
+
+	var creds aws.CredentialsProvider = credentials.NewStaticCredentialsProvider("dummy", "dummy", "")
+	if !cfg.Local {
+		awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+		if err != nil {
+			return nil, err
+		}
+		creds = awsCfg.Credentials
+	}
+
+	return &SQS{
+		QueueURL:    cfg.OrderQueueURL,
+		Region:      cfg.Region,
+		Credentials: creds,
+		Endpoint:    u.Scheme + "://" + u.Host,
+		// Long enough for a 20 second long poll
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Send queues a message
+func (q *SQS) Send(ctx context.Context, body string) error {
+	return q.call(ctx, "SendMessage", map[string]any{
+		"QueueUrl":    q.QueueURL,
+		"MessageBody": body,
+	}, nil)
+}
+
+// Receive long-polls for up to max messages, waiting at most wait for one
+// to arrive. Received messages are hidden from other consumers until
+// visibility passes or they're deleted.
+func (q *SQS) Receive(ctx context.Context, max int, wait, visibility time.Duration) ([]Message, error) {
+	var out struct {
+		Messages []Message `json:"Messages"`
+	}
+	err := q.call(ctx, "ReceiveMessage", map[string]any{
+		"QueueUrl":            q.QueueURL,
+		"MaxNumberOfMessages": max,
+		"WaitTimeSeconds":     int(wait.Seconds()),
+		"VisibilityTimeout":   int(visibility.Seconds()),
+	}, &out)
+	return out.Messages, err
+}
+
+// Delete removes a handled message from the queue
+func (q *SQS) Delete(ctx context.Context, receiptHandle string) error {
+	return q.call(ctx, "DeleteMessage", map[string]any{
+		"QueueUrl":      q.QueueURL,
+		"ReceiptHandle": receiptHandle,
+	}, nil)
+}
+
+// call makes a signed SQS JSON API request, decoding the response into out
+// when it isn't nil
+func (q *SQS) call(ctx context.Context, action string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", action, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+
+	creds, err := q.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "sqs", q.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign SQS request: %w", err)
+	}
+
+	resp, err := q.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to call %s: SQS returned %s: %s", action, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", action, err)
+	}
+	return nil
+}
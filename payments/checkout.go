@@ -0,0 +1,137 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// ErrRefundTooLarge means a refund asked for more than is left of its
+// charge. It is the same error OrderService returns.
+var ErrRefundTooLarge = repository.ErrRefundTooLarge
+
+// Store is the part of repository.PaymentRepository the Service needs
+type Store interface {
+	Put(ctx context.Context, payment models.Payment) error
+	ForOrder(ctx context.Context, orderID string) ([]models.Payment, error)
+}
+
+// Service charges orders and refunds them through a Provider. Each payment
+// is stored as pending before the provider is called and updated with the
+// outcome, so an interrupted payment is never lost.
+type Service struct {
+	store    Store
+	provider Provider
+}
+
+func NewService(store Store, provider Provider) *Service {
+	return &Service{store: store, provider: provider}
+}
+
+// Charge takes the order's total. A declined charge is stored as failed
+// and returned alongside an error wrapping ErrDeclined.
+func (s *Service) Charge(ctx context.Context, order models.Order) (*models.Payment, error) {
+	payment := s.newPayment(order.OrderID, models.PaymentKindCharge, order.Total)
+	return s.settle(ctx, payment, func() (string, error) {
+		return s.provider.Charge(ctx, order.OrderID, order.Total)
+	})
+}
+
+// Refund pays back amount of a successful charge. Refunds already made
+// against the charge count towards its total, so it can't be over-refunded.
+func (s *Service) Refund(ctx context.Context, charge models.Payment, amount float64) (*models.Payment, error) {
+	if charge.Kind != models.PaymentKindCharge || charge.Status != models.PaymentStatusSucceeded {
+		return nil, fmt.Errorf("payment %s is not a successful charge", charge.PaymentID)
+	}
+	refundable, err := s.Refundable(ctx, charge)
+	if err != nil {
+		return nil, err
+	}
+	if amount > refundable {
+		return nil, fmt.Errorf("%w: %.2f of %.2f", ErrRefundTooLarge, amount, refundable)
+	}
+
+	payment := s.newPayment(charge.OrderID, models.PaymentKindRefund, amount)
+	payment.RefundOf = charge.PaymentID
+	return s.settle(ctx, payment, func() (string, error) {
+		return s.provider.Refund(ctx, charge.ProviderRef, amount)
+	})
+}
+
+// Refundable returns how much of a charge hasn't been refunded yet.
+// Pending refunds count, since they may still go through.
+func (s *Service) Refundable(ctx context.Context, charge models.Payment) (float64, error) {
+	payments, err := s.store.ForOrder(ctx, charge.OrderID)
+	if err != nil {
+		return 0, err
+	}
+	return models.RefundableAmount(charge, payments), nil
+}
+
+// SettleRefund pays out a pending refund that was recorded without calling
+// the provider, such as the one written when an order is cancelled
+func (s *Service) SettleRefund(ctx context.Context, refund models.Payment) (*models.Payment, error) {
+	if refund.Kind != models.PaymentKindRefund || refund.Status != models.PaymentStatusPending {
+		return nil, fmt.Errorf("payment %s is not a pending refund", refund.PaymentID)
+	}
+	payments, err := s.store.ForOrder(ctx, refund.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	var charge *models.Payment
+	for i := range payments {
+		if payments[i].PaymentID == refund.RefundOf {
+			charge = &payments[i]
+		}
+	}
+	if charge == nil {
+		return nil, fmt.Errorf("charge %s of refund %s not found", refund.RefundOf, refund.PaymentID)
+	}
+	return s.settle(ctx, refund, func() (string, error) {
+		return s.provider.Refund(ctx, charge.ProviderRef, refund.Amount)
+	})
+}
+
+func (s *Service) newPayment(orderID string, kind models.PaymentKind, amount float64) models.Payment {
+	now := time.Now()
+	return models.Payment{
+		PaymentID: uuid.New().String(),
+		OrderID:   orderID,
+		Kind:      kind,
+		Amount:    amount,
+		Provider:  s.provider.Name(),
+		Status:    models.PaymentStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// settle stores the payment as pending, calls the provider and stores the
+// outcome
+func (s *Service) settle(ctx context.Context, payment models.Payment, call func() (string, error)) (*models.Payment, error) {
+	if err := s.store.Put(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to record payment: %w", err)
+	}
+
+	ref, callErr := call()
+	payment.UpdatedAt = time.Now()
+	if callErr != nil {
+		payment.Status = models.PaymentStatusFailed
+		payment.FailureReason = callErr.Error()
+	} else {
+		payment.Status = models.PaymentStatusSucceeded
+		payment.ProviderRef = ref
+	}
+	if err := s.store.Put(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to record payment outcome: %w", err)
+	}
+	if callErr != nil {
+		return &payment, callErr
+	}
+	return &payment, nil
+}
@@ -0,0 +1,57 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Fake is an in-memory Provider. It accepts every charge up to DeclineOver
+// and tracks how much of each charge has been refunded.
+type Fake struct {
+	// DeclineOver declines charges of more than this amount; 0 accepts any
+	DeclineOver float64
+
+	mu      sync.Mutex
+	charges map[string]float64
+	refunds map[string]float64
+}
+
+// NewFake creates a Fake that accepts every charge
+func NewFake() *Fake {
+	return &Fake{
+		charges: make(map[string]float64),
+		refunds: make(map[string]float64),
+	}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) Charge(ctx context.Context, orderID string, amount float64) (string, error) {
+	if f.DeclineOver > 0 && amount > f.DeclineOver {
+		return "", fmt.Errorf("%w: %.2f is over the limit", ErrDeclined, amount)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ref := "ch_" + uuid.New().String()
+	f.charges[ref] = amount
+	return ref, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, chargeRef string, amount float64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	charged, ok := f.charges[chargeRef]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown charge %s", ErrDeclined, chargeRef)
+	}
+	if f.refunds[chargeRef]+amount > charged {
+		return "", fmt.Errorf("%w: refund exceeds charge %s", ErrDeclined, chargeRef)
+	}
+	f.refunds[chargeRef] += amount
+	return "re_" + uuid.New().String(), nil
+}
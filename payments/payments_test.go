@@ -0,0 +1,85 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"LearnSingleTableDesign/models"
+)
+
+// memStore keeps the latest version of each payment
+type memStore struct {
+	payments map[string]models.Payment
+	puts     int
+}
+
+func (m *memStore) Put(ctx context.Context, payment models.Payment) error {
+	if err := payment.Validate(); err != nil {
+		return err
+	}
+	m.puts++
+	m.payments[payment.PaymentID] = payment
+	return nil
+}
+
+func (m *memStore) ForOrder(ctx context.Context, orderID string) ([]models.Payment, error) {
+	var payments []models.Payment
+	for _, payment := range m.payments {
+		if payment.OrderID == orderID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func TestService_ChargeAndRefund(t *testing.T) {
+	store := &memStore{payments: make(map[string]models.Payment)}
+	service := NewService(store, NewFake())
+	ctx := context.Background()
+	order := models.Order{OrderID: "ORD1", Total: 50}
+
+	charge, err := service.Charge(ctx, order)
+	if err != nil {
+		t.Fatalf("Failed to charge: %v", err)
+	}
+	if charge.Status != models.PaymentStatusSucceeded || charge.ProviderRef == "" || charge.Provider != "fake" {
+		t.Errorf("Charge = %+v", charge)
+	}
+	// Test the payment was recorded as pending before the provider was called
+	if store.puts != 2 {
+		t.Errorf("Puts = %d, want 2", store.puts)
+	}
+
+	if _, err := service.Refund(ctx, *charge, 20); err != nil {
+		t.Fatalf("Failed to refund: %v", err)
+	}
+	if _, err := service.Refund(ctx, *charge, 40); !errors.Is(err, ErrRefundTooLarge) {
+		t.Errorf("Over-refunding error = %v, want ErrRefundTooLarge", err)
+	}
+	refundable, err := service.Refundable(ctx, *charge)
+	if err != nil {
+		t.Fatalf("Failed to get refundable amount: %v", err)
+	}
+	if refundable != 30 {
+		t.Errorf("Refundable = %.2f, want 30", refundable)
+	}
+}
+
+func TestService_ChargeDeclined(t *testing.T) {
+	store := &memStore{payments: make(map[string]models.Payment)}
+	provider := NewFake()
+	provider.DeclineOver = 100
+	service := NewService(store, provider)
+
+	payment, err := service.Charge(context.Background(), models.Order{OrderID: "ORD1", Total: 150})
+	if !errors.Is(err, ErrDeclined) {
+		t.Fatalf("Error = %v, want ErrDeclined", err)
+	}
+	if payment.Status != models.PaymentStatusFailed || payment.FailureReason == "" {
+		t.Errorf("Payment = %+v, want failed with a reason", payment)
+	}
+	if _, err := service.Refund(context.Background(), *payment, 10); err == nil {
+		t.Error("expected refunding a failed charge to fail")
+	}
+}
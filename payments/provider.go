@@ -0,0 +1,23 @@
+// Package payments takes payments for orders through a pluggable provider
+// and records every charge and refund in the table.
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDeclined means the provider refused a charge or refund
+var ErrDeclined = errors.New("payment declined")
+
+// Provider moves money for orders. Implementations wrap a payment
+// service's API; Fake stands in for one in development and tests.
+type Provider interface {
+	// Name identifies the provider in stored payments
+	Name() string
+	// Charge takes amount for an order and returns the provider's reference
+	Charge(ctx context.Context, orderID string, amount float64) (string, error)
+	// Refund pays back amount of an earlier charge and returns the
+	// provider's reference for the refund
+	Refund(ctx context.Context, chargeRef string, amount float64) (string, error)
+}
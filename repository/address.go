@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"LearnSingleTableDesign/models"
+)
+
+// AddressRepository handles Address entity operations.
+// Addresses live in their user's item collection next to the profile and orders.
+type AddressRepository struct {
+	store *Store
+}
+
+// NewAddressRepository creates a new AddressRepository
+func NewAddressRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *AddressRepository {
+	return &AddressRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Put stores an address in DynamoDB
+func (r *AddressRepository) Put(ctx context.Context, address models.Address) error {
+	address.UserEmail = models.NormalizeEmail(address.UserEmail)
+	if err := address.Validate(); err != nil {
+		return err
+	}
+	item := GenericItem[models.Address]{
+		PK:         Key.UserPK(address.UserEmail),
+		SK:         Key.AddressSK(address.AddressID),
+		EntityType: EntityAddress,
+		Data:       address,
+	}
+	return PutItem(ctx, r.store, item)
+}
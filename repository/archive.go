@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"LearnSingleTableDesign/models"
+)
+
+// ExpireCompletedOrders gives completed orders placed before cutoff a time
+// to live of expireAt, so DynamoDB deletes them and the archive stream can
+// save them. It reads the per-day order partitions in GSI1, from cutoff's
+// day back through lookbackDays earlier days; orders already expiring are
+// filtered out. Each order is rewritten on condition it is still completed
+// and unexpired, so an order refunded meanwhile is kept.
+func (r *OrderRepository) ExpireCompletedOrders(ctx context.Context, cutoff time.Time, lookbackDays int, expireAt time.Time) (int, error) {
+	unexpired := And(AttributeEquals("data.status", models.OrderStatusCompleted), AttributeNotExists("ttl"))
+
+	expired := 0
+	day := cutoff
+	for range lookbackDays + 1 {
+		opts := &QueryOptions{Filter: unexpired}
+		for {
+			result, err := QueryByGSI[models.Order](ctx, r.store, Key.OrderDatePK(day), string(PrefixCreated), opts)
+			if err != nil {
+				return expired, err
+			}
+			for _, found := range result.Items {
+				order := found.Data
+				if !order.CreatedAt.Before(cutoff) {
+					continue
+				}
+				item := orderItem(order)
+				item.TTL = expireAt.Unix()
+				err := putItemIf(ctx, r.store, item, unexpired.must())
+				if errors.Is(err, ErrConditionFailed) {
+					continue
+				}
+				if err != nil {
+					return expired, fmt.Errorf("failed to expire order %s: %w", order.OrderID, err)
+				}
+				expired++
+			}
+			if result.NextPageToken == nil {
+				break
+			}
+			opts.PageToken = result.NextPageToken
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return expired, nil
+}
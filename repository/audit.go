@@ -0,0 +1,437 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+)
+
+// AuditWrites records an AUDIT# entry for every write the Store makes, in
+// the same transaction as the write, so the log can't miss a change or
+// record one that didn't happen. Each put first reads the item it replaces
+// to work out what changed, and the write is conditional on the item still
+// being what was read, so the diff is against the state actually
+// overwritten. Batch writes can't be transactional and aren't audited.
+func AuditWrites() StoreOption {
+	return func(s *Store) {
+		s.audit = true
+	}
+}
+
+// auditClock hands out the times of audit entries. Each is later than the
+// last, even within one tick of the system clock, so entries written by
+// this process sort in the order they were written.
+var auditClock = &monotonicClock{}
+
+type monotonicClock struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// next returns now, or just after the last time handed out if now isn't
+// later than it
+func (c *monotonicClock) next(now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !now.After(c.last) {
+		now = c.last.Add(time.Nanosecond)
+	}
+	c.last = now
+	return now
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose writes are audited as made by actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns who the context's writes are made by: the actor set by
+// WithActor, else the session, else "system"
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	if sessionID, ok := SessionFrom(ctx); ok {
+		return "session:" + sessionID
+	}
+	return "system"
+}
+
+// maxAuditAttempts is how many times an audited transaction is sent when
+// the items it writes keep changing between reading their before images
+// and the write
+const maxAuditAttempts = 3
+
+// sendAudited sends items with their audit entries, each write guarded by
+// unchangedSince on the before image its entry was diffed against. When a
+// write is cancelled and its item has changed since it was read, the
+// images are read again and the transaction retried, up to
+// maxAuditAttempts times.
+func (s *Store) sendAudited(ctx context.Context, items []types.TransactWriteItem) (*dynamodb.TransactWriteItemsOutput, error) {
+	for attempt := 1; ; attempt++ {
+		audits, befores, err := s.auditItems(ctx, items)
+		if err != nil {
+			return nil, err
+		}
+		sent := overwritesAsUpdates(items)
+		for i, before := range befores {
+			sent[i] = unchangedSince(sent[i], before)
+		}
+		out, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append(sent, audits...),
+		})
+		if attempt == maxAuditAttempts || !conditionCancelled(err) {
+			return out, err
+		}
+		changed, readErr := s.beforeImagesChanged(ctx, items, befores)
+		if readErr != nil {
+			return nil, readErr
+		}
+		if !changed {
+			// The caller's own condition failed
+			return out, err
+		}
+	}
+}
+
+// unchangedSince adds to a write the condition that its item is still
+// before, going by updated_at, which the Store stamps on every write. A
+// nil before means the item must still not exist. Puts that only create
+// items already check that.
+func unchangedSince(item types.TransactWriteItem, before map[string]types.AttributeValue) types.TransactWriteItem {
+	guard := condition{expr: createOnly}
+	if before != nil {
+		names := map[string]string{auditUpdatedAtName: updatedAtAttribute}
+		if at, ok := before[updatedAtAttribute]; ok {
+			guard = condition{
+				expr:   auditUpdatedAtName + " = " + auditUpdatedAtValue,
+				names:  names,
+				values: map[string]types.AttributeValue{auditUpdatedAtValue: at},
+			}
+		} else {
+			// Items written before the Store stamped them
+			guard = condition{expr: "attribute_exists(PK) AND attribute_not_exists(" + auditUpdatedAtName + ")", names: names}
+		}
+	}
+	switch {
+	case item.Put != nil:
+		if aws.ToString(item.Put.ConditionExpression) == createOnly {
+			return item
+		}
+		put := *item.Put
+		put.ConditionExpression, put.ExpressionAttributeNames, put.ExpressionAttributeValues =
+			guard.and(put.ConditionExpression, put.ExpressionAttributeNames, put.ExpressionAttributeValues)
+		return types.TransactWriteItem{Put: &put}
+	case item.Update != nil:
+		update := *item.Update
+		update.ConditionExpression, update.ExpressionAttributeNames, update.ExpressionAttributeValues =
+			guard.and(update.ConditionExpression, update.ExpressionAttributeNames, update.ExpressionAttributeValues)
+		return types.TransactWriteItem{Update: &update}
+	case item.Delete != nil:
+		del := *item.Delete
+		del.ConditionExpression, del.ExpressionAttributeNames, del.ExpressionAttributeValues =
+			guard.and(del.ConditionExpression, del.ExpressionAttributeNames, del.ExpressionAttributeValues)
+		return types.TransactWriteItem{Delete: &del}
+	}
+	return item
+}
+
+// and returns a write's condition expression and placeholders with c
+// added, leaving the write's own maps alone
+func (c condition) and(expr *string, names map[string]string, values map[string]types.AttributeValue) (*string, map[string]string, map[string]types.AttributeValue) {
+	combined := c.expr
+	if e := aws.ToString(expr); e != "" {
+		combined = "(" + e + ") AND (" + c.expr + ")"
+	}
+	return aws.String(combined), mergeMaps(maps.Clone(names), c.names), mergeMaps(maps.Clone(values), c.values)
+}
+
+// The placeholders of unchangedSince, prefixed like the Store's timestamps
+const (
+	auditUpdatedAtName  = "#audit_updated_at"
+	auditUpdatedAtValue = ":audit_updated_at"
+)
+
+// beforeImagesChanged reports whether any item written in items is no
+// longer its before image
+func (s *Store) beforeImagesChanged(ctx context.Context, items []types.TransactWriteItem, befores map[int]map[string]types.AttributeValue) (bool, error) {
+	for i, before := range befores {
+		current, err := s.beforeImage(ctx, writeKey(items[i]))
+		if err != nil {
+			return false, err
+		}
+		if (current == nil) != (before == nil) || attributeString(current, updatedAtAttribute) != attributeString(before, updatedAtAttribute) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func attributeString(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// writeKey returns the key of the item a put, update or delete writes,
+// nil for anything else
+func writeKey(item types.TransactWriteItem) map[string]types.AttributeValue {
+	switch {
+	case item.Put != nil:
+		return map[string]types.AttributeValue{"PK": item.Put.Item["PK"], "SK": item.Put.Item["SK"]}
+	case item.Update != nil:
+		return item.Update.Key
+	case item.Delete != nil:
+		return item.Delete.Key
+	}
+	return nil
+}
+
+// auditItems returns an audit entry put for each put, update and delete in
+// items, and the before image each was diffed against, by the write's
+// index in items
+func (s *Store) auditItems(ctx context.Context, items []types.TransactWriteItem) ([]types.TransactWriteItem, map[int]map[string]types.AttributeValue, error) {
+	now := time.Now()
+	actor := ActorFrom(ctx)
+	audits := make([]types.TransactWriteItem, 0, len(items))
+	befores := make(map[int]map[string]types.AttributeValue, len(items))
+	for i, item := range items {
+		var entry models.AuditEntry
+		key := writeKey(item)
+		switch {
+		case item.Put != nil:
+			entry.Action = models.AuditActionPut
+		case item.Update != nil:
+			entry.Action = models.AuditActionUpdate
+			entry.Expression = aws.ToString(item.Update.UpdateExpression)
+		case item.Delete != nil:
+			entry.Action = models.AuditActionDelete
+		default:
+			continue
+		}
+		if err := unmarshal(key["PK"], &entry.PK); err != nil {
+			return nil, nil, fmt.Errorf("failed to read audited key: %w", err)
+		}
+		if err := unmarshal(key["SK"], &entry.SK); err != nil {
+			return nil, nil, fmt.Errorf("failed to read audited key: %w", err)
+		}
+
+		before, err := s.beforeImage(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		befores[i] = before
+		switch {
+		case item.Put != nil:
+			entry.Changes, err = diffItems(before, keepCreatedAt(item.Put.Item, before))
+			if err != nil {
+				return nil, nil, err
+			}
+			entry.EntityType = RawItem(item.Put.Item).EntityType()
+		case item.Delete != nil:
+			entry.Changes, err = diffItems(before, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			entry.EntityType = RawItem(before).EntityType()
+		default:
+			entry.EntityType = RawItem(before).EntityType()
+		}
+
+		entry.AuditID = uuid.New().String()
+		entry.Actor = actor
+		entry.At = auditClock.next(now)
+		av, err := marshalMap(auditItem(entry))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal audit entry: %w", err)
+		}
+		audits = append(audits, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String(s.tableName),
+			Item:      av,
+		}})
+	}
+	return audits, befores, nil
+}
+
+// keepCreatedAt returns the item a put leaves stored over before, which
+// keeps before's created_at
+func keepCreatedAt(item, before map[string]types.AttributeValue) map[string]types.AttributeValue {
+	createdAt, ok := before[createdAtAttribute]
+	if !ok {
+		return item
+	}
+	item = maps.Clone(item)
+	item[createdAtAttribute] = createdAt
+	return item
+}
+
+// beforeImage reads the item a write is about to change, or nil if it
+// doesn't exist yet
+func (s *Store) beforeImage(ctx context.Context, key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read item before audit: %w", err)
+	}
+	return result.Item, nil
+}
+
+// diffItems lists the attributes that differ between two item images,
+// comparing the fields of data one by one, decompressed if need be
+func diffItems(before, after map[string]types.AttributeValue) ([]models.AuditChange, error) {
+	before, err := inflate(before)
+	if err != nil {
+		return nil, err
+	}
+	if after, err = inflate(after); err != nil {
+		return nil, err
+	}
+	beforeFields, err := flattenItem(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := flattenItem(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []models.AuditChange
+	for field, value := range afterFields {
+		if beforeFields[field] != value {
+			changes = append(changes, models.AuditChange{Field: field, Before: beforeFields[field], After: value})
+		}
+	}
+	for field, value := range beforeFields {
+		if _, ok := afterFields[field]; !ok {
+			changes = append(changes, models.AuditChange{Field: field, Before: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// flattenItem renders each attribute as JSON, keyed by name, with the
+// fields of data keyed "data.<field>". The key attributes are left out
+// since they never change, and updated_at since it always does.
+func flattenItem(item map[string]types.AttributeValue) (map[string]string, error) {
+	fields := make(map[string]string)
+	add := func(name string, av types.AttributeValue) error {
+		var value any
+		if err := unmarshal(av, &value); err != nil {
+			return fmt.Errorf("failed to unmarshal %s for audit: %w", name, err)
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s for audit: %w", name, err)
+		}
+		fields[name] = string(b)
+		return nil
+	}
+
+	for name, av := range item {
+		if name == "PK" || name == "SK" || name == updatedAtAttribute {
+			continue
+		}
+		if data, ok := av.(*types.AttributeValueMemberM); ok && name == "data" {
+			for field, fieldAV := range data.Value {
+				if err := add("data."+field, fieldAV); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := add(name, av); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// auditItem wraps an entry in its table item. Entries live under the
+// audited partition for per-item history, and in a per-day GSI1 partition
+// for browsing everything that changed.
+func auditItem(entry models.AuditEntry) GenericItem[models.AuditEntry] {
+	return GenericItem[models.AuditEntry]{
+		PK:         Key.AuditPK(PrimaryKey(entry.PK)),
+		SK:         Key.AuditSK(entry.At, entry.AuditID),
+		EntityType: EntityAudit,
+		Data:       entry,
+		GSI1PK:     Key.AuditDayPK(entry.At),
+		GSI1SK:     Key.AuditSK(entry.At, entry.AuditID),
+	}
+}
+
+// AuditRepository reads the audit log
+type AuditRepository struct {
+	store *Store
+}
+
+func NewAuditRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *AuditRepository {
+	return &AuditRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// AuditPage is a page of audit entries, newest first
+type AuditPage struct {
+	Entries       []models.AuditEntry
+	NextPageToken *PageToken
+	PageInfo
+}
+
+// ForPartition returns the changes to the items in a partition, newest first
+func (r *AuditRepository) ForPartition(ctx context.Context, pk PrimaryKey, opts *QueryOptions) (*AuditPage, error) {
+	result, err := Query[models.AuditEntry](ctx, r.store, Key.AuditPK(pk), string(PrefixAt), newestFirst(opts))
+	if err != nil {
+		return nil, err
+	}
+	return auditPage(result), nil
+}
+
+// ByDay returns the changes made on a UTC day, newest first
+func (r *AuditRepository) ByDay(ctx context.Context, day time.Time, opts *QueryOptions) (*AuditPage, error) {
+	result, err := QueryByGSI[models.AuditEntry](ctx, r.store, Key.AuditDayPK(day), string(PrefixAt), newestFirst(opts))
+	if err != nil {
+		return nil, err
+	}
+	return auditPage(result), nil
+}
+
+// newestFirst copies opts with Descending set
+func newestFirst(opts *QueryOptions) *QueryOptions {
+	descending := QueryOptions{}
+	if opts != nil {
+		descending = *opts
+	}
+	descending.Descending = true
+	return &descending
+}
+
+func auditPage(result *QueryResult[models.AuditEntry]) *AuditPage {
+	entries := make([]models.AuditEntry, len(result.Items))
+	for i, item := range result.Items {
+		entries[i] = item.Data
+	}
+	return &AuditPage{
+		Entries:       entries,
+		NextPageToken: result.NextPageToken,
+		PageInfo:      result.PageInfo,
+	}
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+
+	"LearnSingleTableDesign/models"
+)
+
+// Storage backends. BackendSingle is this package's single table;
+// BackendMulti is package multitable's table per entity, kept to compare
+// the two on the same workload. BackendSQLite is package sqlstore, which
+// the web app can also run on for offline demos.
+const (
+	BackendSingle = "single"
+	BackendMulti  = "multi"
+	BackendSQLite = "sqlite"
+)
+
+// Users is what both backends implement for users
+type Users interface {
+	Put(ctx context.Context, user models.User) error
+	Get(ctx context.Context, email string) (*models.User, error)
+	GetUserWithOrders(ctx context.Context, email string) (*UserAggregate, error)
+}
+
+// Products is what both backends implement for products
+type Products interface {
+	Put(ctx context.Context, product models.Product) error
+	Get(ctx context.Context, productID string) (*models.Product, error)
+	GetMany(ctx context.Context, productIDs []string) (map[string]models.Product, error)
+}
+
+// Catalog is Products plus what the storefront reads of the catalogue
+type Catalog interface {
+	Products
+	All(ctx context.Context, opts *QueryOptions) (*ProductsPage, error)
+	SearchByNamePrefix(ctx context.Context, prefix string, opts *QueryOptions) (*ProductsPage, error)
+	Featured(ctx context.Context, opts *QueryOptions) (*ProductsPage, error)
+	LowStock(ctx context.Context, opts *QueryOptions) (*ProductsPage, error)
+	PutContent(ctx context.Context, content models.ProductContent) error
+	// SetImageURL changes only the product's image URL, so it can't undo
+	// a stock change made while the image was uploading
+	SetImageURL(ctx context.Context, productID, imageURL string) error
+	GetContent(ctx context.Context, productID string, locales []string) (*models.ProductContent, error)
+}
+
+// Pages stores the CMS pages
+type Pages interface {
+	Put(ctx context.Context, page models.Page) error
+	Get(ctx context.Context, slug string) (*models.Page, error)
+	All(ctx context.Context) ([]models.Page, error)
+}
+
+// Orders is what both backends implement for orders
+type Orders interface {
+	Put(ctx context.Context, order models.Order) error
+	Get(ctx context.Context, userEmail, orderID string) (*models.Order, error)
+	GetUserOrders(ctx context.Context, userEmail string, opts *QueryOptions) (*OrdersPage, error)
+}
+
+// Backend is one storage backend's repositories
+type Backend struct {
+	Users    Users
+	Products Products
+	Orders   Orders
+}
+
+var (
+	_ Users    = (*UserRepository)(nil)
+	_ Products = (*ProductRepository)(nil)
+	_ Orders   = (*OrderRepository)(nil)
+	_ Catalog  = (*ProductRepository)(nil)
+	_ Pages    = (*PageRepository)(nil)
+)
@@ -0,0 +1,401 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// maxBatchWriteItems is the most items DynamoDB accepts in one BatchWriteItem call
+const maxBatchWriteItems = 25
+
+// maxBatchGetItems is the most keys DynamoDB accepts in one BatchGetItem call
+const maxBatchGetItems = 100
+
+// maxBatchAttempts bounds how often unprocessed items are retried
+const maxBatchAttempts = 5
+
+// ErrPartialBatch is returned by BatchResult.Err when some items failed
+var ErrPartialBatch = errors.New("batch partially failed")
+
+// ItemKey is the primary key of a single item
+type ItemKey struct {
+	PK PrimaryKey `dynamodbav:"PK"`
+	SK SortKey    `dynamodbav:"SK"`
+}
+
+// BatchFailure describes an item a batch operation could not process
+type BatchFailure struct {
+	Key    ItemKey
+	Reason string
+	// Err is why the item failed, for errors.Is, e.g. ErrNotFound for an
+	// item a batch get didn't find
+	Err error
+	// Retryable is true when sending the item again may succeed,
+	// e.g. it was throttled rather than rejected
+	Retryable bool
+}
+
+// BatchResult reports per item which parts of a batch operation succeeded,
+// so one bad item or a throttled chunk doesn't fail the whole batch
+type BatchResult struct {
+	Succeeded []ItemKey
+	Failed    []BatchFailure
+}
+
+// OK reports whether every item succeeded
+func (r *BatchResult) OK() bool {
+	return len(r.Failed) == 0
+}
+
+// Retryable reports whether all failed items may succeed if sent again
+func (r *BatchResult) Retryable() bool {
+	for _, failure := range r.Failed {
+		if !failure.Retryable {
+			return false
+		}
+	}
+	return len(r.Failed) > 0
+}
+
+// RetryKeys returns the keys of failed items worth sending again
+func (r *BatchResult) RetryKeys() []ItemKey {
+	var keys []ItemKey
+	for _, failure := range r.Failed {
+		if failure.Retryable {
+			keys = append(keys, failure.Key)
+		}
+	}
+	return keys
+}
+
+// Err returns nil when every item succeeded, otherwise an error wrapping
+// ErrPartialBatch that summarises the failures
+func (r *BatchResult) Err() error {
+	if r.OK() {
+		return nil
+	}
+	first := r.Failed[0]
+	return fmt.Errorf("%w: %d of %d items failed, first %s/%s: %s",
+		ErrPartialBatch, len(r.Failed), len(r.Failed)+len(r.Succeeded), first.Key.PK, first.Key.SK, first.Reason)
+}
+
+// LogValue renders the result as counts plus the failed keys
+func (r *BatchResult) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Int("succeeded", len(r.Succeeded)),
+		slog.Int("failed", len(r.Failed)),
+	}
+	if !r.OK() {
+		failed := make([]string, len(r.Failed))
+		for i, failure := range r.Failed {
+			failed[i] = fmt.Sprintf("%s/%s: %s", failure.Key.PK, failure.Key.SK, failure.Reason)
+		}
+		attrs = append(attrs, slog.Bool("retryable", r.Retryable()), slog.Any("failures", failed))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func (r *BatchResult) fail(keys []ItemKey, err error, retryable bool) {
+	for _, key := range keys {
+		r.Failed = append(r.Failed, BatchFailure{Key: key, Reason: err.Error(), Err: err, Retryable: retryable})
+	}
+}
+
+// BatchPutItems writes items in batches of 25, retrying any unprocessed items.
+// Items that fail validation or are still unprocessed after the retries are
+// reported in the result; the error is only set when the whole operation
+// was abandoned, e.g. because the context was cancelled or the store is
+// read-only. Batch writes can't be conditional, so an item that already
+// exists gets a new created_at unless the caller carried its CreatedAt over.
+func BatchPutItems[T any](ctx context.Context, s *Store, items []GenericItem[T]) (*BatchResult, error) {
+	result := &BatchResult{}
+	now := time.Now()
+	for start := 0; start < len(items); start += maxBatchWriteItems {
+		end := min(start+maxBatchWriteItems, len(items))
+
+		requests := make([]types.WriteRequest, 0, end-start)
+		for _, item := range items[start:end] {
+			request, err := putRequest(ctx, s, item, now)
+			if err != nil {
+				result.fail([]ItemKey{{PK: item.PK, SK: item.SK}}, err, false)
+				continue
+			}
+			requests = append(requests, request)
+		}
+
+		if err := s.batchPuts(ctx, requests, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// putRequest checks and marshals item into a batch put request stamped
+// with now, and runs the write hooks
+func putRequest[T any](ctx context.Context, s *Store, item GenericItem[T], now time.Time) (types.WriteRequest, error) {
+	if err := s.checkKeys(ctx, item.EntityType, item.PK, item.SK); err != nil {
+		return types.WriteRequest{}, err
+	}
+	av, err := marshalItem(s, item)
+	if err != nil {
+		return types.WriteRequest{}, fmt.Errorf("failed to marshal item: %w", err)
+	}
+	stored, err := s.storedItem(item.EntityType, item.PK, item.SK, av)
+	if err != nil {
+		return types.WriteRequest{}, err
+	}
+	stampItem(av, now)
+	stampItem(stored, now)
+	s.runWriteHooks(ctx, WriteOp{PK: item.PK, SK: item.SK, EntityType: item.EntityType, Item: av})
+	return types.WriteRequest{PutRequest: &types.PutRequest{Item: stored}}, nil
+}
+
+// batchPuts sends up to 25 put requests as one batch, running the change
+// hooks around it
+func (s *Store) batchPuts(ctx context.Context, requests []types.WriteRequest, result *BatchResult) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	puts := make([]types.TransactWriteItem, len(requests))
+	for i, request := range requests {
+		puts[i] = types.TransactWriteItem{Put: &types.Put{Item: request.PutRequest.Item}}
+	}
+	changes := s.changesOf(puts, false)
+	s.runChangeHooks(ctx, BeforeWrite, changes)
+
+	succeeded := len(result.Succeeded)
+	if err := s.batchWrite(ctx, requests, result); err != nil {
+		return err
+	}
+	s.runChangeHooks(ctx, AfterWrite, succeededChanges(changes, result.Succeeded[succeeded:]))
+	return nil
+}
+
+// BatchDeleteItems deletes items by key in batches of 25, retrying any
+// unprocessed deletes. Like batch puts, batch deletes aren't audited.
+func BatchDeleteItems(ctx context.Context, s *Store, keys []ItemKey) (*BatchResult, error) {
+	result := &BatchResult{}
+	for start := 0; start < len(keys); start += maxBatchWriteItems {
+		end := min(start+maxBatchWriteItems, len(keys))
+
+		requests := make([]types.WriteRequest, 0, end-start)
+		deletes := make([]types.TransactWriteItem, 0, end-start)
+		for _, key := range keys[start:end] {
+			av, err := marshalMap(key)
+			if err != nil {
+				result.fail([]ItemKey{key}, fmt.Errorf("failed to marshal key: %w", err), false)
+				continue
+			}
+			requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: av}})
+			deletes = append(deletes, types.TransactWriteItem{Delete: &types.Delete{Key: av}})
+		}
+
+		if len(requests) == 0 {
+			continue
+		}
+		changes := s.changesOf(deletes, false)
+		s.runChangeHooks(ctx, BeforeWrite, changes)
+
+		succeeded := len(result.Succeeded)
+		if err := s.batchWrite(ctx, requests, result); err != nil {
+			return result, err
+		}
+		s.runChangeHooks(ctx, AfterWrite, succeededChanges(changes, result.Succeeded[succeeded:]))
+	}
+	return result, nil
+}
+
+// succeededChanges keeps the changes to the keys a batch wrote
+func succeededChanges(changes []Change, succeeded []ItemKey) []Change {
+	written := make(map[ItemKey]bool, len(succeeded))
+	for _, key := range succeeded {
+		written[key] = true
+	}
+	var kept []Change
+	for _, change := range changes {
+		if written[ItemKey{PK: change.PK, SK: change.SK}] {
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
+
+// batchWrite sends one batch, retrying unprocessed items with backoff, and
+// records the outcome of every request in result
+func (s *Store) batchWrite(ctx context.Context, requests []types.WriteRequest, result *BatchResult) error {
+	pending := requests
+	for attempt := 0; ; attempt++ {
+		out, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{s.tableName: pending},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// No later batch would get through either
+			if errors.Is(err, ErrReadOnly) {
+				return err
+			}
+			result.fail(writeRequestKeys(pending), fmt.Errorf("failed to batch write items: %w", err), isRetryable(err))
+			return nil
+		}
+
+		unprocessed := out.UnprocessedItems[s.tableName]
+		result.Succeeded = append(result.Succeeded, subtractKeys(writeRequestKeys(pending), writeRequestKeys(unprocessed))...)
+		if len(unprocessed) == 0 {
+			return nil
+		}
+		pending = unprocessed
+
+		if attempt+1 == maxBatchAttempts {
+			result.fail(writeRequestKeys(pending), fmt.Errorf("still unprocessed after %d attempts", maxBatchAttempts), true)
+			return nil
+		}
+		if err := backoff(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// BatchGetItems reads items by key in batches of 100, retrying any unprocessed
+// keys. Keys that don't exist are reported as non-retryable failures.
+func BatchGetItems[T any](ctx context.Context, s *Store, keys []ItemKey) ([]GenericItem[T], *BatchResult, error) {
+	result := &BatchResult{}
+	var items []GenericItem[T]
+	for start := 0; start < len(keys); start += maxBatchGetItems {
+		end := min(start+maxBatchGetItems, len(keys))
+
+		pending := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, key := range keys[start:end] {
+			av, err := marshalMap(key)
+			if err != nil {
+				result.fail([]ItemKey{key}, fmt.Errorf("failed to marshal key: %w", err), false)
+				continue
+			}
+			pending = append(pending, av)
+		}
+
+		found := make(map[ItemKey]bool, len(pending))
+		for attempt := 0; len(pending) > 0; attempt++ {
+			out, err := s.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]types.KeysAndAttributes{
+					s.tableName: {Keys: pending},
+				},
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return items, result, ctx.Err()
+				}
+				result.fail(attributeKeys(pending), fmt.Errorf("failed to batch get items: %w", err), isRetryable(err))
+				pending = nil
+				break
+			}
+
+			for _, av := range out.Responses[s.tableName] {
+				var item GenericItem[T]
+				if err := unmarshalMap(av, &item); err != nil {
+					return items, result, fmt.Errorf("failed to unmarshal item: %w", err)
+				}
+				key := ItemKey{PK: item.PK, SK: item.SK}
+				found[key] = true
+				result.Succeeded = append(result.Succeeded, key)
+				items = append(items, item)
+			}
+
+			unprocessed := out.UnprocessedKeys[s.tableName].Keys
+			for _, key := range subtractKeys(attributeKeys(pending), attributeKeys(unprocessed)) {
+				if !found[key] {
+					result.fail([]ItemKey{key}, ErrNotFound, false)
+				}
+			}
+			pending = unprocessed
+			if len(pending) == 0 {
+				break
+			}
+			if attempt+1 == maxBatchAttempts {
+				result.fail(attributeKeys(pending), fmt.Errorf("still unprocessed after %d attempts", maxBatchAttempts), true)
+				break
+			}
+			if err := backoff(ctx, attempt); err != nil {
+				return items, result, err
+			}
+		}
+	}
+	return items, result, nil
+}
+
+// backoff waits before retry attempt+1, or returns early if ctx is done
+func backoff(ctx context.Context, attempt int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(1<<attempt) * 50 * time.Millisecond):
+		return nil
+	}
+}
+
+// isRetryable reports whether err is a throttling or transient server error
+func isRetryable(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "ProvisionedThroughputExceededException", "RequestLimitExceeded",
+		"ThrottlingException", "InternalServerError", "ServiceUnavailable":
+		return true
+	}
+	return apiErr.ErrorFault() == smithy.FaultServer
+}
+
+// writeRequestKeys extracts the item keys from put requests
+func writeRequestKeys(requests []types.WriteRequest) []ItemKey {
+	keys := make([]ItemKey, 0, len(requests))
+	for _, request := range requests {
+		if request.PutRequest != nil {
+			keys = append(keys, attributeKeys([]map[string]types.AttributeValue{request.PutRequest.Item})...)
+		}
+		if request.DeleteRequest != nil {
+			keys = append(keys, attributeKeys([]map[string]types.AttributeValue{request.DeleteRequest.Key})...)
+		}
+	}
+	return keys
+}
+
+// attributeKeys extracts the item keys from attribute value maps
+func attributeKeys(avs []map[string]types.AttributeValue) []ItemKey {
+	keys := make([]ItemKey, 0, len(avs))
+	for _, av := range avs {
+		var key ItemKey
+		if pk, ok := av["PK"].(*types.AttributeValueMemberS); ok {
+			key.PK = PrimaryKey(pk.Value)
+		}
+		if sk, ok := av["SK"].(*types.AttributeValueMemberS); ok {
+			key.SK = SortKey(sk.Value)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// subtractKeys returns the keys in all that are not in remove
+func subtractKeys(all, remove []ItemKey) []ItemKey {
+	removed := make(map[ItemKey]bool, len(remove))
+	for _, key := range remove {
+		removed[key] = true
+	}
+	var keys []ItemKey
+	for _, key := range all {
+		if !removed[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+)
+
+// ErrWriterClosed is returned by puts to a closed BufferedWriter
+var ErrWriterClosed = errors.New("buffered writer closed")
+
+// BufferedWriter collects single puts and writes them with BatchWriteItem
+// once size of them are waiting or every interval, whichever comes first,
+// for ingestion like seeding or replaying a stream where a round trip per
+// item is the bottleneck. Like BatchPutItems its writes are neither
+// conditional nor transactional. Items that fail are collected in Result
+// rather than stopping the writer; an error that abandons a flush, such as
+// a cancelled context or a read-only store, makes later puts fail.
+type BufferedWriter[T any] struct {
+	ctx   context.Context
+	store *Store
+	// item validates a value and wraps it for the table
+	item     func(T) (GenericItem[T], error)
+	size     int
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []types.WriteRequest
+	result  BatchResult
+	err     error
+	closed  bool
+
+	// flushing keeps flushes, and so batches, in the order they were taken
+	flushing sync.Mutex
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// newBufferedWriter starts a writer flushing every interval until ctx is
+// done or it is closed. Sizes outside 1 to 25 mean 25 and a non-positive
+// interval means 100ms.
+func newBufferedWriter[T any](ctx context.Context, s *Store, size int, interval time.Duration, item func(T) (GenericItem[T], error)) *BufferedWriter[T] {
+	if size <= 0 || size > maxBatchWriteItems {
+		size = maxBatchWriteItems
+	}
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	w := &BufferedWriter[T]{
+		ctx:      ctx,
+		store:    s,
+		item:     item,
+		size:     size,
+		interval: interval,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// BufferedWriter creates a writer batching order puts. Like PutMany it
+// doesn't update user stats.
+func (r *OrderRepository) BufferedWriter(ctx context.Context, size int, interval time.Duration) *BufferedWriter[models.Order] {
+	return newBufferedWriter(ctx, r.store, size, interval, func(order models.Order) (GenericItem[models.Order], error) {
+		order.UserEmail = models.NormalizeEmail(order.UserEmail)
+		if err := order.Validate(); err != nil {
+			return GenericItem[models.Order]{}, err
+		}
+		return orderItem(order), nil
+	})
+}
+
+// BufferedWriter creates a writer batching product puts
+func (r *ProductRepository) BufferedWriter(ctx context.Context, size int, interval time.Duration) *BufferedWriter[models.Product] {
+	return newBufferedWriter(ctx, r.store, size, interval, func(product models.Product) (GenericItem[models.Product], error) {
+		if err := product.Validate(); err != nil {
+			return GenericItem[models.Product]{}, err
+		}
+		return productItem(product), nil
+	})
+}
+
+// Put queues v, flushing the queue when it is full. Invalid values are
+// rejected straight away.
+func (w *BufferedWriter[T]) Put(ctx context.Context, v T) error {
+	item, err := w.item(v)
+	if err != nil {
+		return err
+	}
+	request, err := putRequest(ctx, w.store, item, time.Now())
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	if err := w.unusable(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	w.pending = append(w.pending, request)
+	full := len(w.pending) >= w.size
+	w.mu.Unlock()
+
+	if full {
+		return w.Flush(ctx)
+	}
+	return nil
+}
+
+// unusable is why puts can't be queued, if they can't. w.mu must be held.
+func (w *BufferedWriter[T]) unusable() error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	return w.err
+}
+
+// Flush writes everything queued so far
+func (w *BufferedWriter[T]) Flush(ctx context.Context) error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	w.mu.Lock()
+	requests := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(requests) == 0 {
+		return nil
+	}
+
+	result := &BatchResult{}
+	var err error
+	for start := 0; start < len(requests); start += maxBatchWriteItems {
+		chunk := requests[start:min(start+maxBatchWriteItems, len(requests))]
+		if err = w.store.batchPuts(ctx, chunk, result); err != nil {
+			result.fail(writeRequestKeys(requests[start:]), err, !errors.Is(err, ErrReadOnly))
+			break
+		}
+	}
+	if !result.OK() {
+		slog.Warn("some buffered puts failed", "result", result)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.result.Succeeded = append(w.result.Succeeded, result.Succeeded...)
+	w.result.Failed = append(w.result.Failed, result.Failed...)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+func (w *BufferedWriter[T]) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Flush(w.ctx); err != nil && w.ctx.Err() == nil {
+				slog.Error("failed to flush buffered puts", "error", err)
+			}
+		}
+	}
+}
+
+// Result reports every put flushed so far
+func (w *BufferedWriter[T]) Result() *BatchResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return &BatchResult{
+		Succeeded: append([]ItemKey(nil), w.result.Succeeded...),
+		Failed:    append([]BatchFailure(nil), w.result.Failed...),
+	}
+}
+
+// Close stops the timer and flushes what's left. It returns the error that
+// abandoned a flush if there was one, and otherwise the Result's error when
+// any item failed. Puts after Close return ErrWriterClosed.
+func (w *BufferedWriter[T]) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.stopped
+	if err := w.Flush(ctx); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.result.Err()
+}
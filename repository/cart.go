@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+)
+
+// sessionCartTTL is how long an anonymous visitor's cart is kept after it
+// was last changed before DynamoDB's TTL deletes it
+const sessionCartTTL = 30 * 24 * time.Hour
+
+// cartHoldTTL is how long stock added to a cart stays held for it. Once a
+// hold expires its stock goes back on sale, and checkout takes the units
+// from stock again if there are any left.
+const cartHoldTTL = 30 * time.Minute
+
+// cartAttempts is how many times a cart write is retried when another
+// write to the same cart got there first
+const cartAttempts = 3
+
+// CartRepository stores carts: one item per session for anonymous visitors
+// and one in each signed-in user's collection. What is added to a cart is
+// held out of stock until it is removed, checked out or the hold expires.
+// ProductRepository's low stock alerts don't fire for these holds.
+type CartRepository struct {
+	store *Store
+	// products takes and releases the carts' inventory holds
+	products *ProductRepository
+}
+
+// NewCartRepository creates a new CartRepository
+func NewCartRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *CartRepository {
+	store := NewStore(client, tableName, opts...)
+	return &CartRepository{
+		store:    store,
+		products: &ProductRepository{store: store},
+	}
+}
+
+// CartOwner says whose cart to use, a session's or a user's
+type CartOwner struct {
+	sessionID string
+	email     string
+}
+
+// SessionCart is the cart of an anonymous visitor's session
+func SessionCart(sessionID string) CartOwner {
+	return CartOwner{sessionID: sessionID}
+}
+
+// UserCart is the cart of a signed-in user
+func UserCart(email string) CartOwner {
+	return CartOwner{email: email}
+}
+
+// id names the cart in its inventory holds
+func (o CartOwner) id() string {
+	return string(o.pk())
+}
+
+func (o CartOwner) pk() PrimaryKey {
+	if o.email != "" {
+		return Key.UserPK(o.email)
+	}
+	return Key.SessionPK(o.sessionID)
+}
+
+// item is how the owner's cart is stored. Session carts expire a while
+// after their last change; users' carts are kept.
+func (o CartOwner) item(cart models.Cart) GenericItem[models.Cart] {
+	item := GenericItem[models.Cart]{
+		PK:         o.pk(),
+		SK:         Key.CartSK(),
+		EntityType: EntityCart,
+		Data:       cart,
+	}
+	if o.email == "" {
+		item.EntityType = EntitySessionCart
+		item.TTL = cart.UpdatedAt.Add(sessionCartTTL).Unix()
+	}
+	return item
+}
+
+// Get returns the owner's cart, which is empty if nothing was ever added
+func (r *CartRepository) Get(ctx context.Context, owner CartOwner) (*models.Cart, error) {
+	var item GenericItem[models.Cart]
+	err := GetItem(ctx, r.store, owner.pk(), Key.CartSK(), &item)
+	if errors.Is(err, ErrNotFound) {
+		return &models.Cart{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// Add puts quantity more of a product in the owner's cart, holding the
+// units out of stock first. Units past MaxCartQuantity are left out. It
+// returns ErrInsufficientStock when there aren't enough left to hold.
+func (r *CartRepository) Add(ctx context.Context, owner CartOwner, productID string, quantity int) (*models.Cart, error) {
+	cart, err := r.Get(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	room := cart.Room(productID)
+	if room == 0 {
+		return cart, nil
+	}
+	quantity = min(quantity, room)
+	hold, err := r.products.Hold(ctx, productID, owner.id(), quantity, cartHoldTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err = r.update(ctx, owner, func(cart *models.Cart) {
+		cart.Add(productID, quantity)
+		cart.AddHold(productID, hold.HoldID)
+	})
+	if err != nil {
+		// The units never made it into the cart, so give them back
+		r.release(ctx, productID, []string{hold.HoldID})
+		return nil, err
+	}
+	return cart, nil
+}
+
+// Remove takes a product out of the owner's cart and releases its holds
+func (r *CartRepository) Remove(ctx context.Context, owner CartOwner, productID string) (*models.Cart, error) {
+	var holdIDs []string
+	cart, err := r.update(ctx, owner, func(cart *models.Cart) {
+		holdIDs = cart.Remove(productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.release(ctx, productID, holdIDs)
+	return cart, nil
+}
+
+// release gives the stock of a product's holds back. A hold that can't be
+// released now is logged and left to expire, when HoldReconciler releases
+// it instead.
+func (r *CartRepository) release(ctx context.Context, productID string, holdIDs []string) {
+	for _, holdID := range holdIDs {
+		err := r.products.ReleaseHold(ctx, productID, holdID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			slog.Warn("failed to release cart hold", "product", productID, "hold", holdID, "error", err)
+		}
+	}
+}
+
+// update applies change to the owner's cart. The write is conditional on
+// the cart's version, and is retried if another write got there first.
+func (r *CartRepository) update(ctx context.Context, owner CartOwner, change func(*models.Cart)) (*models.Cart, error) {
+	for attempt := 1; ; attempt++ {
+		cart, err := r.Get(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		version := cart.Version
+		change(cart)
+		cart.Version++
+		cart.UpdatedAt = time.Now()
+		if err := cart.Validate(); err != nil {
+			return nil, err
+		}
+
+		err = putItemIf(ctx, r.store, owner.item(*cart), cartVersionIs(version))
+		if errors.Is(err, ErrConditionFailed) && attempt < cartAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return cart, nil
+	}
+}
+
+// Merge moves the items of a session's cart into a user's, for when an
+// anonymous visitor signs in. Quantities of products in both carts are
+// summed and their holds move with them, except where the sum is capped:
+// the session's holds on those products are released. The user's cart is
+// written and the session's deleted in one transaction, so an item is
+// never in both or neither.
+func (r *CartRepository) Merge(ctx context.Context, sessionID, email string) (*models.Cart, error) {
+	from, to := SessionCart(sessionID), UserCart(email)
+	for attempt := 1; ; attempt++ {
+		session, err := r.Get(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+		if len(session.Items) == 0 {
+			return r.Get(ctx, to)
+		}
+		cart, err := r.Get(ctx, to)
+		if err != nil {
+			return nil, err
+		}
+		version := cart.Version
+		capped := cart.Merge(*session)
+		cart.Version++
+		cart.UpdatedAt = time.Now()
+		if err := cart.Validate(); err != nil {
+			return nil, err
+		}
+
+		put, err := conditionalPut(ctx, r.store, to.item(*cart), cartVersionIs(version))
+		if err != nil {
+			return nil, err
+		}
+		// The session's cart is only deleted as it was read, so an item
+		// added to it meanwhile isn't lost
+		guard := cartVersionIs(session.Version)
+		del := &types.Delete{
+			TableName: aws.String(r.store.tableName),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: string(from.pk())},
+				"SK": &types.AttributeValueMemberS{Value: string(Key.CartSK())},
+			},
+			ConditionExpression:       aws.String(guard.expr),
+			ExpressionAttributeNames:  guard.names,
+			ExpressionAttributeValues: guard.values,
+		}
+		_, err = r.store.transactWrite(ctx, []types.TransactWriteItem{{Put: put}, {Delete: del}})
+		if conditionCancelled(err) {
+			if attempt < cartAttempts {
+				continue
+			}
+			return nil, ErrConditionFailed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge carts: %w", err)
+		}
+		for _, item := range capped {
+			r.release(ctx, item.ProductID, item.HoldIDs)
+		}
+		return cart, nil
+	}
+}
+
+// cartVersionIs guards a cart write on the stored cart still being at
+// version, which a cart that doesn't exist yet is for version 0
+func cartVersionIs(version int) condition {
+	return VersionEquals("data.version", version).must()
+}
@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Operation is the kind of write a change hook is told about
+type Operation string
+
+const (
+	OperationPut    Operation = "put"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+// Phase is when a change hook runs relative to its write
+type Phase int
+
+const (
+	// BeforeWrite hooks run just before the request is sent
+	BeforeWrite Phase = iota
+	// AfterWrite hooks run once the write has succeeded; they don't run
+	// for writes that failed or were cancelled
+	AfterWrite
+)
+
+// Change describes one item the Store writes, for change hooks
+type Change struct {
+	Operation Operation
+	Phase     Phase
+	PK        PrimaryKey
+	SK        SortKey
+	// EntityType is set when the item is known: always for puts, and for
+	// deletes after the write
+	EntityType string
+	// Item is the item being put, or the item DeleteItem removed once the
+	// write succeeded. It is nil for updates and other deletes. A put that
+	// overwrites an item keeps the stored created_at, whatever Item says.
+	Item RawItem
+	// Expression is an update's update expression
+	Expression string
+	// Transactional is true when the write is part of a transaction, which
+	// may hold other writes
+	Transactional bool
+}
+
+// ChangeHook is called with the request context around each write
+type ChangeHook func(ctx context.Context, change Change)
+
+// OnPut registers a hook called before and after each item is put
+func OnPut(hook ChangeHook) StoreOption {
+	return onChange(OperationPut, hook)
+}
+
+// OnUpdate registers a hook called before and after each update expression
+// is applied
+func OnUpdate(hook ChangeHook) StoreOption {
+	return onChange(OperationUpdate, hook)
+}
+
+// OnDelete registers a hook called before and after each item is deleted
+func OnDelete(hook ChangeHook) StoreOption {
+	return onChange(OperationDelete, hook)
+}
+
+func onChange(op Operation, hook ChangeHook) StoreOption {
+	return func(s *Store) {
+		if s.changeHooks == nil {
+			s.changeHooks = make(map[Operation][]ChangeHook)
+		}
+		s.changeHooks[op] = append(s.changeHooks[op], hook)
+	}
+}
+
+// Typed adapts a hook that wants the typed item of one entity type. Changes
+// to other entity types are skipped. Changes without an item image, like
+// updates, are passed with only the item's keys set.
+func Typed[T any](entityType string, hook func(ctx context.Context, change Change, item GenericItem[T])) ChangeHook {
+	return func(ctx context.Context, change Change) {
+		if change.EntityType != entityType {
+			return
+		}
+		item := GenericItem[T]{PK: change.PK, SK: change.SK, EntityType: change.EntityType}
+		if change.Item != nil {
+			decoded, err := Decode[T](change.Item)
+			if err != nil {
+				return
+			}
+			item = decoded
+		}
+		hook(ctx, change, item)
+	}
+}
+
+// DeleteItem deletes an item. Deleting an item that doesn't exist does
+// nothing, and AfterWrite hooks only run when an item was removed. With
+// conds, the item is only deleted if they all hold, and ErrConditionFailed
+// is returned otherwise.
+func DeleteItem(ctx context.Context, s *Store, pk PrimaryKey, sk SortKey, conds ...Condition) error {
+	cond, _, err := conditionOf(conds)
+	if err != nil {
+		return err
+	}
+	del := &types.Delete{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: string(pk)},
+			"SK": &types.AttributeValueMemberS{Value: string(sk)},
+		},
+	}
+	if cond.expr != "" {
+		del.ConditionExpression = aws.String(cond.expr)
+		del.ExpressionAttributeNames = cond.names
+		del.ExpressionAttributeValues = cond.values
+	}
+	if s.audit {
+		_, err := s.transactWrite(ctx, []types.TransactWriteItem{{Delete: del}})
+		if conditionCancelled(err) {
+			return ErrConditionFailed
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete item: %w", err)
+		}
+		return nil
+	}
+
+	change := Change{Operation: OperationDelete, PK: pk, SK: sk}
+	s.runChangeHooks(ctx, BeforeWrite, []Change{change})
+	result, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 del.TableName,
+		Key:                       del.Key,
+		ConditionExpression:       del.ConditionExpression,
+		ExpressionAttributeNames:  del.ExpressionAttributeNames,
+		ExpressionAttributeValues: del.ExpressionAttributeValues,
+		ReturnValues:              types.ReturnValueAllOld,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrConditionFailed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+	if len(result.Attributes) > 0 {
+		change.Item = result.Attributes
+		change.EntityType = change.Item.EntityType()
+		s.runChangeHooks(ctx, AfterWrite, []Change{change})
+	}
+	return nil
+}
+
+// UpdateItem sets fields of an item's data, leaving the rest of the item
+// alone, e.g. {"status": "shipped"}. The item must exist, and with conds
+// they must all hold too; otherwise ErrConditionFailed is returned. Indexed
+// attributes derived from the data are not recomputed, so fields that feed
+// an index should be changed with PutItem.
+func UpdateItem(ctx context.Context, s *Store, pk PrimaryKey, sk SortKey, fields map[string]any, conds ...Condition) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("an update needs at least one field")
+	}
+	cond, err := And(append([]Condition{AttributeExists("PK")}, conds...)...).build()
+	if err != nil {
+		return err
+	}
+
+	// Sort the fields so the same update always builds the same expression
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sets := make([]string, len(names))
+	exprNames := maps.Clone(cond.names)
+	exprValues := maps.Clone(cond.values)
+	if exprValues == nil {
+		exprValues = make(map[string]types.AttributeValue)
+	}
+	exprNames["#data"] = dataAttribute
+	for i, name := range names {
+		av, err := marshal(fields[name])
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", name, err)
+		}
+		placeholder := "set_" + strconv.Itoa(i)
+		exprNames["#"+placeholder] = name
+		exprValues[":"+placeholder] = av
+		sets[i] = "#data.#" + placeholder + " = :" + placeholder
+	}
+	update := &types.Update{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: string(pk)},
+			"SK": &types.AttributeValueMemberS{Value: string(sk)},
+		},
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String(cond.expr),
+		ExpressionAttributeNames:  exprNames,
+		ExpressionAttributeValues: exprValues,
+	}
+	if s.audit {
+		_, err := s.transactWrite(ctx, []types.TransactWriteItem{{Update: update}})
+		if conditionCancelled(err) {
+			return ErrConditionFailed
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update item: %w", err)
+		}
+		return nil
+	}
+
+	stampUpdate(update, time.Now())
+	changes := s.changesOf([]types.TransactWriteItem{{Update: update}}, false)
+	s.runChangeHooks(ctx, BeforeWrite, changes)
+	_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 update.TableName,
+		Key:                       update.Key,
+		UpdateExpression:          update.UpdateExpression,
+		ConditionExpression:       update.ConditionExpression,
+		ExpressionAttributeNames:  update.ExpressionAttributeNames,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrConditionFailed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update item: %w", err)
+	}
+	s.runChangeHooks(ctx, AfterWrite, changes)
+	return nil
+}
+
+// conditionCancelled reports whether a transaction was cancelled because
+// one of its conditions didn't hold
+func conditionCancelled(err error) bool {
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) {
+		return false
+	}
+	for _, reason := range cancelled.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+// changesOf describes writes for the change hooks, marking them
+// transactional if they go out in one transaction. Condition checks write
+// nothing and are left out.
+func (s *Store) changesOf(items []types.TransactWriteItem, transactional bool) []Change {
+	if len(s.changeHooks) == 0 {
+		return nil
+	}
+	changes := make([]Change, 0, len(items))
+	for _, item := range items {
+		var change Change
+		var key map[string]types.AttributeValue
+		switch {
+		case item.Put != nil:
+			change = Change{Operation: OperationPut, Item: item.Put.Item}
+			change.EntityType = change.Item.EntityType()
+			key = item.Put.Item
+		case item.Update != nil:
+			change = Change{Operation: OperationUpdate, Expression: aws.ToString(item.Update.UpdateExpression)}
+			key = item.Update.Key
+		case item.Delete != nil:
+			change = Change{Operation: OperationDelete}
+			key = item.Delete.Key
+		default:
+			continue
+		}
+		unmarshal(key["PK"], &change.PK)
+		unmarshal(key["SK"], &change.SK)
+		change.Transactional = transactional
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// runChangeHooks calls the hooks registered for each change's operation
+func (s *Store) runChangeHooks(ctx context.Context, phase Phase, changes []Change) {
+	for _, change := range changes {
+		change.Phase = phase
+		for _, hook := range s.changeHooks[change.Operation] {
+			hook(ctx, change)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// attributevalue walks every value it marshals by reflection, and builds a
+// new Encoder or Decoder per call. Bulk writes of thousands of items paid
+// for that on every item, so marshalMap compiles an encoder once per type
+// and reuses it. Compiled encoders cover the plain structs items are made
+// of: strings, numbers, bools, times, pointers, slices and nested structs
+// tagged at most omitempty. Any other type, such as one with a custom
+// Marshaler, a map or an embedded struct, is left to attributevalue, so
+// the output is the same either way.
+
+// encoder encodes one value of the type it was compiled for
+type encoder func(v reflect.Value) (types.AttributeValue, error)
+
+var (
+	// encoders holds the encoder compiled for each type marshalMap has
+	// seen, or a nil encoder for types attributevalue handles
+	encoders sync.Map
+
+	sharedEncoder = attributevalue.NewEncoder(encodeOptions)
+	sharedDecoder = attributevalue.NewDecoder(decodeOptions)
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*attributevalue.Marshaler)(nil)).Elem()
+	// numberType is what attributevalue takes for a number held in a
+	// string, like json.Number
+	numberType = reflect.TypeOf((*interface {
+		Float64() (float64, error)
+		Int64() (int64, error)
+		String() string
+	})(nil)).Elem()
+)
+
+// encoderFor returns the compiled encoder for t, or nil if t is left to
+// attributevalue
+func encoderFor(t reflect.Type) encoder {
+	if enc, ok := encoders.Load(t); ok {
+		return enc.(encoder)
+	}
+	enc, ok := compile(t, map[reflect.Type]bool{})
+	if !ok {
+		enc = nil
+	}
+	encoders.Store(t, enc)
+	return enc
+}
+
+// compile builds an encoder for t matching attributevalue's output with
+// encodeOptions, reporting false if t needs attributevalue itself.
+// compiling holds the structs being compiled, so recursive types give up
+// rather than loop.
+func compile(t reflect.Type, compiling map[reflect.Type]bool) (encoder, bool) {
+	if t.Implements(marshalerType) || t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(marshalerType) {
+		return nil, false
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if t.Implements(numberType) {
+			return nil, false
+		}
+		return func(v reflect.Value) (types.AttributeValue, error) {
+			return &types.AttributeValueMemberS{Value: v.String()}, nil
+		}, true
+
+	case reflect.Bool:
+		return func(v reflect.Value) (types.AttributeValue, error) {
+			return &types.AttributeValueMemberBOOL{Value: v.Bool()}, nil
+		}, true
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) (types.AttributeValue, error) {
+			return &types.AttributeValueMemberN{Value: strconv.FormatInt(v.Int(), 10)}, nil
+		}, true
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(v reflect.Value) (types.AttributeValue, error) {
+			return &types.AttributeValueMemberN{Value: strconv.FormatUint(v.Uint(), 10)}, nil
+		}, true
+
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		return func(v reflect.Value) (types.AttributeValue, error) {
+			return &types.AttributeValueMemberN{Value: strconv.FormatFloat(v.Float(), 'f', -1, bits)}, nil
+		}, true
+
+	case reflect.Pointer:
+		elem, ok := compile(t.Elem(), compiling)
+		if !ok {
+			return nil, false
+		}
+		return func(v reflect.Value) (types.AttributeValue, error) {
+			if v.IsNil() {
+				return &types.AttributeValueMemberNULL{Value: true}, nil
+			}
+			return elem(v.Elem())
+		}, true
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil, false
+		}
+		elem, ok := compile(t.Elem(), compiling)
+		if !ok {
+			return nil, false
+		}
+		return func(v reflect.Value) (types.AttributeValue, error) {
+			if v.IsNil() {
+				return &types.AttributeValueMemberNULL{Value: true}, nil
+			}
+			list := make([]types.AttributeValue, v.Len())
+			for i := range list {
+				av, err := elem(v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				list[i] = av
+			}
+			return &types.AttributeValueMemberL{Value: list}, nil
+		}, true
+
+	case reflect.Struct:
+		if t == timeType {
+			return func(v reflect.Value) (types.AttributeValue, error) {
+				return timestampValue(v.Interface().(time.Time)), nil
+			}, true
+		}
+		if t.ConvertibleTo(timeType) {
+			return nil, false
+		}
+		return compileStruct(t, compiling)
+	}
+	return nil, false
+}
+
+// field is how a compiled struct encoder writes one field
+type field struct {
+	index     int
+	name      string
+	omitEmpty bool
+	encode    encoder
+}
+
+func compileStruct(t reflect.Type, compiling map[reflect.Type]bool) (encoder, bool) {
+	if compiling[t] {
+		return nil, false
+	}
+	compiling[t] = true
+	defer delete(compiling, t)
+
+	var fields []field
+	names := map[string]bool{}
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if sf.Anonymous {
+			return nil, false
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get("dynamodbav"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if names[name] || opts != "" && opts != "omitempty" {
+			return nil, false
+		}
+		names[name] = true
+		enc, ok := compile(sf.Type, compiling)
+		if !ok {
+			return nil, false
+		}
+		fields = append(fields, field{index: i, name: name, omitEmpty: opts == "omitempty", encode: enc})
+	}
+
+	return func(v reflect.Value) (types.AttributeValue, error) {
+		m := map[string]types.AttributeValue{}
+		for _, f := range fields {
+			fv := v.Field(f.index)
+			if f.omitEmpty && isEmpty(fv) {
+				continue
+			}
+			av, err := f.encode(fv)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = av
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	}, true
+}
+
+// isEmpty reports whether omitempty leaves v out. As in attributevalue,
+// structs, including times, are never empty.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Pointer, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// encodeMap marshals in with its compiled encoder if it has one
+func encodeMap(in any) (map[string]types.AttributeValue, error) {
+	v := reflect.ValueOf(in)
+	if !v.IsValid() {
+		return map[string]types.AttributeValue{}, nil
+	}
+	enc := encoderFor(v.Type())
+	if enc == nil {
+		av, err := sharedEncoder.Encode(in)
+		return asMap(av), err
+	}
+	av, err := enc(v)
+	return asMap(av), err
+}
+
+// asMap is av's attributes, or an empty map if it isn't a map, as
+// attributevalue.MarshalMap returns
+func asMap(av types.AttributeValue) map[string]types.AttributeValue {
+	if m, ok := av.(*types.AttributeValueMemberM); ok {
+		return m.Value
+	}
+	return map[string]types.AttributeValue{}
+}
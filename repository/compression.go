@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MaxItemSize is DynamoDB's limit on the size of an item, 400 KB
+const MaxItemSize = 400 * 1024
+
+// ErrItemTooLarge means an item is over the Store's size limit, so the put
+// was refused before it was sent
+var ErrItemTooLarge = errors.New("item too large")
+
+const (
+	// contentEncodingAttribute marks an item whose data is compressed,
+	// and how
+	contentEncodingAttribute = "content_encoding"
+	encodingGzip             = "gzip"
+
+	// timestampsSize is roughly what stamping created_at and updated_at
+	// adds to an item after its size is checked
+	timestampsSize = 2 * (len(createdAtAttribute) + len("2006-01-02T15:04:05.999999999Z"))
+)
+
+// ItemSizeLimit makes the Store refuse puts of items estimated to be over
+// n bytes with ErrItemTooLarge, rather than let DynamoDB reject them, and
+// log a warning for items over 80% of it. Without it the limit is
+// MaxItemSize; a lower one leaves headroom for items that grow.
+func ItemSizeLimit(n int) StoreOption {
+	return func(s *Store) {
+		s.itemSizeLimit = n
+	}
+}
+
+// CompressData gzips the data of the given entity types, such as event
+// snapshots, when it is at least minSize bytes. Compressed data is stored
+// as a binary attribute with a content_encoding attribute saying so, and
+// every read through the repository decompresses it. DynamoDB can't see
+// inside it, so these entities can't be filtered on or updated by data
+// field.
+func CompressData(minSize int, entityTypes ...string) StoreOption {
+	return func(s *Store) {
+		if s.compress == nil {
+			s.compress = make(map[string]int)
+		}
+		for _, entityType := range entityTypes {
+			s.compress[entityType] = minSize
+		}
+	}
+}
+
+// storedItem is av as it will be stored: its data compressed if its entity
+// type asks for that, and checked against the size limit. av is left
+// alone, so write hooks still see the data.
+func (s *Store) storedItem(entityType string, pk PrimaryKey, sk SortKey, av map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	stored := av
+	if minSize, ok := s.compress[entityType]; ok {
+		if data, ok := av[dataAttribute]; ok && attributeSize(data) >= minSize {
+			compressed, err := compressAttribute(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compress %s/%s: %w", pk, sk, err)
+			}
+			stored = maps.Clone(av)
+			stored[dataAttribute] = &types.AttributeValueMemberB{Value: compressed}
+			stored[contentEncodingAttribute] = &types.AttributeValueMemberS{Value: encodingGzip}
+		}
+	}
+
+	limit := s.itemSizeLimit
+	if limit <= 0 {
+		limit = MaxItemSize
+	}
+	size := ItemSize(stored) + timestampsSize
+	if size > limit {
+		return nil, fmt.Errorf("%w: %s/%s is about %d bytes, over the %d byte limit", ErrItemTooLarge, pk, sk, size, limit)
+	}
+	if size > limit*8/10 {
+		slog.Warn("item is close to the size limit", "pk", pk, "sk", sk, "entity_type", entityType, "bytes", size, "limit", limit)
+	}
+	return stored, nil
+}
+
+// inflate returns item with its data decompressed, or item itself if it
+// isn't compressed
+func inflate(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	encoding, ok := item[contentEncodingAttribute].(*types.AttributeValueMemberS)
+	if !ok {
+		return item, nil
+	}
+	if encoding.Value != encodingGzip {
+		return nil, fmt.Errorf("unknown content encoding %q", encoding.Value)
+	}
+	compressed, ok := item[dataAttribute].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, fmt.Errorf("compressed data is not binary")
+	}
+	data, err := decompressAttribute(compressed.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	inflated := maps.Clone(item)
+	inflated[dataAttribute] = data
+	delete(inflated, contentEncodingAttribute)
+	return inflated, nil
+}
+
+func compressAttribute(av types.AttributeValue) ([]byte, error) {
+	encoded, err := json.Marshal(toWire(av))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(encoded); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressAttribute(compressed []byte) (types.AttributeValue, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var wire wireValue
+	if err := json.Unmarshal(encoded, &wire); err != nil {
+		return nil, err
+	}
+	return fromWire(wire)
+}
+
+// wireValue is an attribute value in DynamoDB's JSON format, which keeps
+// the type of every value, unlike decoding into plain Go values
+type wireValue struct {
+	S    *string               `json:"S,omitempty"`
+	N    *string               `json:"N,omitempty"`
+	B    *[]byte               `json:"B,omitempty"`
+	BOOL *bool                 `json:"BOOL,omitempty"`
+	NULL bool                  `json:"NULL,omitempty"`
+	M    *map[string]wireValue `json:"M,omitempty"`
+	L    *[]wireValue          `json:"L,omitempty"`
+	SS   []string              `json:"SS,omitempty"`
+	NS   []string              `json:"NS,omitempty"`
+	BS   [][]byte              `json:"BS,omitempty"`
+}
+
+func toWire(av types.AttributeValue) wireValue {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return wireValue{S: &v.Value}
+	case *types.AttributeValueMemberN:
+		return wireValue{N: &v.Value}
+	case *types.AttributeValueMemberB:
+		return wireValue{B: &v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return wireValue{BOOL: &v.Value}
+	case *types.AttributeValueMemberM:
+		m := make(map[string]wireValue, len(v.Value))
+		for name, elem := range v.Value {
+			m[name] = toWire(elem)
+		}
+		return wireValue{M: &m}
+	case *types.AttributeValueMemberL:
+		l := make([]wireValue, len(v.Value))
+		for i, elem := range v.Value {
+			l[i] = toWire(elem)
+		}
+		return wireValue{L: &l}
+	case *types.AttributeValueMemberSS:
+		return wireValue{SS: v.Value}
+	case *types.AttributeValueMemberNS:
+		return wireValue{NS: v.Value}
+	case *types.AttributeValueMemberBS:
+		return wireValue{BS: v.Value}
+	}
+	return wireValue{NULL: true}
+}
+
+func fromWire(w wireValue) (types.AttributeValue, error) {
+	switch {
+	case w.S != nil:
+		return &types.AttributeValueMemberS{Value: *w.S}, nil
+	case w.N != nil:
+		return &types.AttributeValueMemberN{Value: *w.N}, nil
+	case w.B != nil:
+		return &types.AttributeValueMemberB{Value: *w.B}, nil
+	case w.BOOL != nil:
+		return &types.AttributeValueMemberBOOL{Value: *w.BOOL}, nil
+	case w.NULL:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case w.M != nil:
+		m := make(map[string]types.AttributeValue, len(*w.M))
+		for name, wireElem := range *w.M {
+			elem, err := fromWire(wireElem)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = elem
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	case w.L != nil:
+		l := make([]types.AttributeValue, len(*w.L))
+		for i, elem := range *w.L {
+			av, err := fromWire(elem)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = av
+		}
+		return &types.AttributeValueMemberL{Value: l}, nil
+	case w.SS != nil:
+		return &types.AttributeValueMemberSS{Value: w.SS}, nil
+	case w.NS != nil:
+		return &types.AttributeValueMemberNS{Value: w.NS}, nil
+	case w.BS != nil:
+		return &types.AttributeValueMemberBS{Value: w.BS}, nil
+	}
+	return nil, fmt.Errorf("attribute value has no type")
+}
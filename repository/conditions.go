@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// Condition guards PutItem, UpdateItem and DeleteItem on the item already
+// stored, so repositories can keep invariants without building condition
+// expressions themselves. Attributes are named by path, like "PK" or
+// "data.status". A write whose conditions don't hold returns
+// ErrConditionFailed.
+type Condition struct {
+	builder expression.ConditionBuilder
+	err     error
+}
+
+// pathSegment is what a path may hold between its dots
+var pathSegment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Where wraps a condition built with the aws expression package, for
+// checks the helpers below don't cover, like
+// Where(expression.Name("data.total").GreaterThan(expression.Value(10)))
+func Where(builder expression.ConditionBuilder) Condition {
+	return Condition{builder: builder}
+}
+
+// AttributeNotExists holds when the item has no attribute at path, which
+// for "PK" means the item doesn't exist
+func AttributeNotExists(path string) Condition {
+	name, err := attributePath(path)
+	if err != nil {
+		return Condition{err: err}
+	}
+	return Where(name.AttributeNotExists())
+}
+
+// AttributeExists holds when the item has an attribute at path
+func AttributeExists(path string) Condition {
+	name, err := attributePath(path)
+	if err != nil {
+		return Condition{err: err}
+	}
+	return Where(name.AttributeExists())
+}
+
+// AttributeEquals holds when the attribute at path equals value
+func AttributeEquals(path string, value any) Condition {
+	name, err := attributePath(path)
+	if err != nil {
+		return Condition{err: err}
+	}
+	// Marshalled here so the value is encoded the way the store writes it
+	av, err := marshal(value)
+	if err != nil {
+		return Condition{err: fmt.Errorf("failed to marshal condition value: %w", err)}
+	}
+	return Where(name.Equal(expression.Value(av)))
+}
+
+// VersionEquals holds when the number at path is version, for optimistic
+// locking. Version 0 also holds when there is no number yet, so the first
+// write of an item can use it too.
+func VersionEquals(path string, version int) Condition {
+	c := AttributeEquals(path, version)
+	if c.err != nil || version != 0 {
+		return c
+	}
+	name, _ := attributePath(path)
+	return Where(expression.Or(name.AttributeNotExists(), c.builder))
+}
+
+// And holds when all of conds hold
+func And(conds ...Condition) Condition {
+	var builders []expression.ConditionBuilder
+	for _, c := range conds {
+		if c.err != nil {
+			return c
+		}
+		if c.builder.IsSet() {
+			builders = append(builders, c.builder)
+		}
+	}
+	switch len(builders) {
+	case 0:
+		return Condition{}
+	case 1:
+		return Where(builders[0])
+	}
+	return Where(expression.And(builders[0], builders[1], builders[2:]...))
+}
+
+// build renders the condition's expression with its placeholders
+func (c Condition) build() (condition, error) {
+	if c.err != nil {
+		return condition{}, c.err
+	}
+	if !c.builder.IsSet() {
+		return condition{}, nil
+	}
+	expr, err := expression.NewBuilder().WithCondition(c.builder).Build()
+	if err != nil {
+		return condition{}, fmt.Errorf("failed to build condition: %w", err)
+	}
+	return condition{expr: aws.ToString(expr.Condition()), names: expr.Names(), values: expr.Values()}, nil
+}
+
+// must returns the condition for internal guards, whose paths are
+// constants and can't fail to build
+func (c Condition) must() condition {
+	cond, err := c.build()
+	if err != nil {
+		panic(err)
+	}
+	return cond
+}
+
+// conditionOf combines the conditions given to a write, reporting whether
+// there were any
+func conditionOf(conds []Condition) (condition, bool, error) {
+	if len(conds) == 0 {
+		return condition{}, false, nil
+	}
+	cond, err := And(conds...).build()
+	return cond, cond.expr != "", err
+}
+
+// attributePath checks a dotted path and returns its name for building
+// expressions. The expression package would take "#" or "[" in a segment
+// as part of the name, so they are refused here instead.
+func attributePath(path string) (expression.NameBuilder, error) {
+	for _, segment := range strings.Split(path, ".") {
+		if !pathSegment.MatchString(segment) {
+			return expression.NameBuilder{}, fmt.Errorf("invalid attribute path %s", strconv.Quote(path))
+		}
+	}
+	return expression.Name(path), nil
+}
+
+func mergeMaps[M ~map[K]V, K comparable, V any](dst, src M) M {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(M, len(src))
+	}
+	maps.Copy(dst, src)
+	return dst
+}
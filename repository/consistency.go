@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RecentWrites remembers which partitions each session wrote recently, so
+// the session's follow-up reads of them can be strongly consistent instead
+// of possibly missing the write on an eventually consistent read
+type RecentWrites struct {
+	mu        sync.Mutex
+	window    time.Duration
+	writes    map[sessionPartition]time.Time
+	lastSweep time.Time
+}
+
+type sessionPartition struct {
+	session string
+	pk      PrimaryKey
+}
+
+// NewRecentWrites creates a tracker that forces consistent reads for window after each write
+func NewRecentWrites(window time.Duration) *RecentWrites {
+	return &RecentWrites{
+		window: window,
+		writes: make(map[sessionPartition]time.Time),
+	}
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context whose reads and writes belong to the session
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessionID)
+}
+
+// SessionFrom returns the session ID set by WithSession, if any
+func SessionFrom(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionKey{}).(string)
+	return sessionID, ok && sessionID != ""
+}
+
+// ReadYourWrites makes reads of a partition strongly consistent for a short
+// window after the same session wrote to it
+func ReadYourWrites(tracker *RecentWrites) StoreOption {
+	return func(s *Store) {
+		s.recentWrites = tracker
+		s.writeHooks = append(s.writeHooks, tracker.record)
+	}
+}
+
+// record is the write hook remembering the written partition for the session
+func (w *RecentWrites) record(ctx context.Context, op WriteOp) {
+	session, ok := SessionFrom(ctx)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes[sessionPartition{session, op.PK}] = now.Add(w.window)
+
+	// Drop expired entries now and then so the map doesn't grow forever
+	if now.Sub(w.lastSweep) > w.window {
+		for key, expires := range w.writes {
+			if now.After(expires) {
+				delete(w.writes, key)
+			}
+		}
+		w.lastSweep = now
+	}
+}
+
+// shouldReadConsistently reports whether the session wrote to the partition recently
+func (w *RecentWrites) shouldReadConsistently(ctx context.Context, pk PrimaryKey) bool {
+	session, ok := SessionFrom(ctx)
+	if !ok {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	expires, ok := w.writes[sessionPartition{session, pk}]
+	return ok && time.Now().Before(expires)
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+)
+
+// ContactRepository stores the messages sent through the contact form, in
+// one partition ordered by when they were sent
+type ContactRepository struct {
+	store *Store
+}
+
+// NewContactRepository creates a new ContactRepository
+func NewContactRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *ContactRepository {
+	return &ContactRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Submit stores a message from the contact form, giving it an ID and the
+// time it was sent
+func (r *ContactRepository) Submit(ctx context.Context, name, email, message string) (*models.ContactMessage, error) {
+	msg := models.ContactMessage{
+		MessageID: uuid.New().String(),
+		Name:      name,
+		Email:     models.NormalizeEmail(email),
+		Message:   message,
+		CreatedAt: time.Now(),
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	item := GenericItem[models.ContactMessage]{
+		PK:         Key.ContactPK(),
+		SK:         Key.ContactSK(msg.CreatedAt, msg.MessageID),
+		EntityType: EntityContactMessage,
+		Data:       msg,
+	}
+	if err := PutItem(ctx, r.store, item); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// ContactMessagesPage represents a page of contact messages
+type ContactMessagesPage struct {
+	Messages      []models.ContactMessage
+	NextPageToken *PageToken
+	PageInfo
+}
+
+// Recent returns contact messages, newest first
+func (r *ContactRepository) Recent(ctx context.Context, opts *QueryOptions) (*ContactMessagesPage, error) {
+	result, err := Query[models.ContactMessage](ctx, r.store, Key.ContactPK(), string(PrefixAt), newestFirst(opts))
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]models.ContactMessage, len(result.Items))
+	for i, item := range result.Items {
+		messages[i] = item.Data
+	}
+	return &ContactMessagesPage{
+		Messages:      messages,
+		NextPageToken: result.NextPageToken,
+		PageInfo:      result.PageInfo,
+	}, nil
+}
@@ -0,0 +1,227 @@
+//go:build contract
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/schema"
+	"LearnSingleTableDesign/testutil"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+// The contract tests pin down the DynamoDB behaviour the repositories rely
+// on, so they can be run against real DynamoDB as well as the emulators to
+// catch where the two differ:
+//
+//	go test -tags contract -run TestContract ./repository
+//	CONTRACT_TARGET=aws go test -tags contract -run TestContract ./repository
+//
+// Every subtest shares one table, since a real one takes a while to
+// create, and uses keys of its own.
+
+// gsiPropagation bounds how long a write takes to reach a GSI
+const gsiPropagation = 30 * time.Second
+
+func TestContract(t *testing.T) {
+	client, tableName := testutil.SetupContractTable(t)
+	ctx := context.Background()
+	store := NewStore(client, tableName)
+	userRepo := NewUserRepository(client, tableName)
+	productRepo := NewProductRepository(client, tableName)
+
+	t.Run("PlaceOrder", func(t *testing.T) {
+		user := fixtures.NewUser().Build()
+		if err := userRepo.Put(ctx, user); err != nil {
+			t.Fatalf("Failed to put user: %v", err)
+		}
+		product := fixtures.NewProduct().WithID("CONTRACT_ORDER").WithStock(1).Build()
+		if err := productRepo.Put(ctx, product); err != nil {
+			t.Fatalf("Failed to put product: %v", err)
+		}
+
+		service := NewOrderService(client, tableName, EnforceKeyConsistency())
+		if _, err := service.Place(ctx, user.Email, []string{product.ProductID}); err != nil {
+			t.Fatalf("Failed to place order: %v", err)
+		}
+		if _, err := service.Place(ctx, user.Email, []string{product.ProductID}); !errors.Is(err, ErrInsufficientStock) {
+			t.Errorf("Placing an order out of stock = %v, want ErrInsufficientStock", err)
+		}
+		stats, err := userRepo.GetStats(ctx, user.Email)
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if stats.OrderCount != 1 {
+			t.Errorf("OrderCount = %d, want 1", stats.OrderCount)
+		}
+	})
+
+	t.Run("ConditionalPut", func(t *testing.T) {
+		item := GenericItem[models.Product]{
+			PK: Key.ProductPK(), SK: Key.ProductSK("CONTRACT_CONDITION"), EntityType: EntityProduct,
+			Data: fixtures.NewProduct().WithID("CONTRACT_CONDITION").Build(),
+		}
+		if err := PutItem(ctx, store, item, AttributeNotExists("PK")); err != nil {
+			t.Fatalf("Failed to create item: %v", err)
+		}
+		if err := PutItem(ctx, store, item, AttributeNotExists("PK")); !errors.Is(err, ErrConditionFailed) {
+			t.Errorf("Creating it again = %v, want ErrConditionFailed", err)
+		}
+	})
+
+	t.Run("TransactionLimits", func(t *testing.T) {
+		// DynamoDB takes up to 100 actions per transaction; older emulators
+		// stop at 25
+		puts := make([]types.TransactWriteItem, 101)
+		for i := range puts {
+			puts[i] = types.TransactWriteItem{Put: &types.Put{
+				TableName: aws.String(tableName),
+				Item:      rawItem("CONTRACT#TX", fmt.Sprintf("ITEM#%03d", i), 10),
+			}}
+		}
+		if _, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: puts[:100]}); err != nil {
+			t.Errorf("A transaction of 100 puts failed: %v", err)
+		}
+		_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: puts})
+		assertValidationError(t, "a transaction of 101 puts", err)
+
+		// One transaction can't touch an item twice
+		_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{puts[0], puts[0]}})
+		assertValidationError(t, "a transaction writing an item twice", err)
+	})
+
+	t.Run("BatchLimits", func(t *testing.T) {
+		requests := make([]types.WriteRequest, 26)
+		for i := range requests {
+			requests[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: rawItem("CONTRACT#BATCH", fmt.Sprintf("ITEM#%03d", i), 10)}}
+		}
+		_, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{tableName: requests},
+		})
+		assertValidationError(t, "a batch of 26 puts", err)
+
+		products := make([]models.Product, 60)
+		for i := range products {
+			products[i] = fixtures.NewProduct().WithID(fmt.Sprintf("CONTRACT_BATCH%02d", i)).Build()
+		}
+		result, err := productRepo.PutMany(ctx, products)
+		if err != nil || !result.OK() || len(result.Succeeded) != len(products) {
+			t.Errorf("PutMany of %d products = %+v, %v", len(products), result, err)
+		}
+	})
+
+	t.Run("ItemSizeLimit", func(t *testing.T) {
+		_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(tableName),
+			Item:      rawItem("CONTRACT#SIZE", "BIG", MaxItemSize+1),
+		})
+		assertValidationError(t, "an item over 400 KB", err)
+
+		// The Store refuses it before DynamoDB sees it
+		product := fixtures.NewProduct().WithID("CONTRACT_SIZE").WithCategory(strings.Repeat("x", MaxItemSize)).Build()
+		if err := productRepo.Put(ctx, product); !errors.Is(err, ErrItemTooLarge) {
+			t.Errorf("Putting a product over 400 KB = %v, want ErrItemTooLarge", err)
+		}
+	})
+
+	t.Run("IndexConsistency", func(t *testing.T) {
+		product := fixtures.NewProduct().WithID("CONTRACT_GSI").WithName("Contractual Widget").Build()
+		if err := productRepo.Put(ctx, product); err != nil {
+			t.Fatalf("Failed to put product: %v", err)
+		}
+
+		// GSIs are eventually consistent, so the product may take a moment
+		// to show up in a search
+		deadline := time.Now().Add(gsiPropagation)
+		for {
+			page, err := productRepo.SearchByNamePrefix(ctx, "contractual", nil)
+			if err != nil {
+				t.Fatalf("Failed to search: %v", err)
+			}
+			if len(page.Products) == 1 && page.Products[0].ProductID == product.ProductID {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Product not in the index after %v, got %+v", gsiPropagation, page.Products)
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		// Consistent reads are refused on a GSI but allowed on the LSI
+		_, err := client.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(tableName),
+			IndexName:                 aws.String(schema.GSI1),
+			KeyConditionExpression:    aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":pk": &types.AttributeValueMemberS{Value: string(Key.ProductNamePK())}},
+			ConsistentRead:            aws.Bool(true),
+		})
+		assertValidationError(t, "a consistent read of a GSI", err)
+		_, err = client.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(tableName),
+			IndexName:                 aws.String(schema.LSI1),
+			KeyConditionExpression:    aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":pk": &types.AttributeValueMemberS{Value: string(Key.ProductPK())}},
+			ConsistentRead:            aws.Bool(true),
+		})
+		if err != nil {
+			t.Errorf("A consistent read of the LSI failed: %v", err)
+		}
+	})
+
+	t.Run("Paging", func(t *testing.T) {
+		seen := map[string]bool{}
+		opts := &QueryOptions{Limit: 7}
+		for pages := 0; ; pages++ {
+			if pages > 100 {
+				t.Fatal("Paging didn't end")
+			}
+			page, err := productRepo.All(ctx, opts)
+			if err != nil {
+				t.Fatalf("Failed to list products: %v", err)
+			}
+			for _, product := range page.Products {
+				if seen[product.ProductID] {
+					t.Errorf("Product %s on two pages", product.ProductID)
+				}
+				seen[product.ProductID] = true
+			}
+			if page.NextPageToken == nil {
+				break
+			}
+			opts.PageToken = page.NextPageToken
+		}
+		for i := range 60 {
+			if id := fmt.Sprintf("CONTRACT_BATCH%02d", i); !seen[id] {
+				t.Errorf("Product %s missing from the pages", id)
+			}
+		}
+	})
+}
+
+// rawItem is an item with a string attribute making it about size bytes
+func rawItem(pk, sk string, size int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK":      &types.AttributeValueMemberS{Value: pk},
+		"SK":      &types.AttributeValueMemberS{Value: sk},
+		"payload": &types.AttributeValueMemberS{Value: strings.Repeat("x", size)},
+	}
+}
+
+func assertValidationError(t *testing.T, what string, err error) {
+	t.Helper()
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "ValidationException" {
+		t.Errorf("Expected %s to fail validation, got %v", what, err)
+	}
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+)
+
+// Reasons a coupon can't be used
+var (
+	ErrCouponExpired         = errors.New("coupon has expired")
+	ErrCouponExhausted       = errors.New("coupon has no redemptions left")
+	ErrCouponAlreadyRedeemed = errors.New("coupon already redeemed by this user")
+	ErrCouponBelowMinimum    = errors.New("order total is below the coupon minimum")
+)
+
+// CouponRepository stores coupons and who redeemed them. Each coupon's
+// collection holds the coupon item and one redemption item per user.
+type CouponRepository struct {
+	store *Store
+}
+
+func NewCouponRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *CouponRepository {
+	return &CouponRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Create stores a new coupon with no redemptions. It returns
+// ErrConditionFailed if the code is taken, so an existing coupon's counter
+// is never reset.
+func (r *CouponRepository) Create(ctx context.Context, coupon models.Coupon) error {
+	coupon.Code = strings.ToUpper(coupon.Code)
+	coupon.Redemptions = 0
+	if err := coupon.Validate(); err != nil {
+		return err
+	}
+	return putItemIf(ctx, r.store, GenericItem[models.Coupon]{
+		PK:         Key.CouponPK(coupon.Code),
+		SK:         Key.CouponSK(coupon.Code),
+		EntityType: EntityCoupon,
+		Data:       coupon,
+	}, condition{expr: createOnly})
+}
+
+func (r *CouponRepository) Get(ctx context.Context, code string) (*models.Coupon, error) {
+	var item GenericItem[models.Coupon]
+	err := GetItem(ctx, r.store, Key.CouponPK(code), Key.CouponSK(code), &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// Check validates a coupon for a user's order at checkout and returns the
+// discount it would give. It doesn't redeem the coupon, so the answer can
+// change before Redeem is called.
+func (r *CouponRepository) Check(ctx context.Context, code, userEmail string, total float64) (*models.Coupon, float64, error) {
+	coupon, err := r.Get(ctx, code)
+	if err != nil {
+		return nil, 0, err
+	}
+	if coupon.ExpiresAt != nil && !time.Now().Before(*coupon.ExpiresAt) {
+		return nil, 0, ErrCouponExpired
+	}
+	if coupon.MaxRedemptions > 0 && coupon.Redemptions >= coupon.MaxRedemptions {
+		return nil, 0, ErrCouponExhausted
+	}
+	if total < coupon.MinTotal {
+		return nil, 0, fmt.Errorf("%w of %.2f", ErrCouponBelowMinimum, coupon.MinTotal)
+	}
+
+	_, err = getRawItem(ctx, r.store, Key.CouponPK(code), Key.CouponRedemptionSK(userEmail))
+	if err == nil {
+		return nil, 0, ErrCouponAlreadyRedeemed
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, 0, err
+	}
+	return coupon, coupon.Discount(total), nil
+}
+
+// Redeem uses a coupon on a user's order. The user's redemption item and the
+// coupon's counter are written in one transaction, each with a condition:
+// the redemption must not exist yet and the counter must be under the cap.
+// Concurrent redemptions therefore can't exceed the cap or let a user
+// redeem twice, whatever Check said earlier.
+func (r *CouponRepository) Redeem(ctx context.Context, code, userEmail, orderID string, total float64) (*models.CouponRedemption, error) {
+	coupon, discount, err := r.Check(ctx, code, userEmail, total)
+	if err != nil {
+		return nil, err
+	}
+
+	redemption := models.CouponRedemption{
+		Code:       coupon.Code,
+		UserEmail:  models.NormalizeEmail(userEmail),
+		OrderID:    orderID,
+		Discount:   discount,
+		RedeemedAt: time.Now(),
+	}
+	if err := redemption.Validate(); err != nil {
+		return nil, err
+	}
+	put, err := conditionalPut(ctx, r.store, GenericItem[models.CouponRedemption]{
+		PK:         Key.CouponPK(code),
+		SK:         Key.CouponRedemptionSK(userEmail),
+		EntityType: EntityCouponRedemption,
+		Data:       redemption,
+	}, condition{expr: createOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.store.transactPut(ctx, put, []*types.Update{{
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: string(Key.CouponPK(code))},
+			"SK": &types.AttributeValueMemberS{Value: string(Key.CouponSK(code))},
+		},
+		UpdateExpression:    aws.String("SET #data.#redemptions = #data.#redemptions + :one"),
+		ConditionExpression: aws.String("#data.#max = :zero OR #data.#redemptions < #data.#max"),
+		ExpressionAttributeNames: map[string]string{
+			"#data":        "data",
+			"#redemptions": "redemptions",
+			"#max":         "max_redemptions",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":  &types.AttributeValueMemberN{Value: "1"},
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+	}})
+
+	var cancelled *types.TransactionCanceledException
+	if errors.As(err, &cancelled) && len(cancelled.CancellationReasons) >= 2 {
+		if aws.ToString(cancelled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			return nil, ErrCouponAlreadyRedeemed
+		}
+		if aws.ToString(cancelled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+			return nil, ErrCouponExhausted
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem coupon: %w", err)
+	}
+	return &redemption, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// repositoryPkg is the function name prefix of frames inside this package
+var repositoryPkg = reflect.TypeOf(Store{}).PkgPath() + "."
+
+// writeLog remembers where each key was first written during a request
+type writeLog struct {
+	mu    sync.Mutex
+	sites map[string]string
+}
+
+type writeLogKey struct{}
+
+// TrackWrites returns a context that records the keys written through it,
+// so DetectDuplicateWrites can spot the same item being Put twice.
+// The web layer calls it once per request.
+func TrackWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeLogKey{}, &writeLog{sites: make(map[string]string)})
+}
+
+// DetectDuplicateWrites warns when the same PK/SK is written twice without a
+// condition within a context prepared by TrackWrites. Intended for development,
+// where it points at accidental overwrites by logging both call sites.
+func DetectDuplicateWrites() StoreOption {
+	return WithWriteHook(duplicateWriteHook)
+}
+
+func duplicateWriteHook(ctx context.Context, op WriteOp) {
+	log, ok := ctx.Value(writeLogKey{}).(*writeLog)
+	if !ok || op.Conditional {
+		return
+	}
+
+	key := fmt.Sprintf("%s|%s", op.PK, op.SK)
+	site := callSite()
+
+	log.mu.Lock()
+	first, seen := log.sites[key]
+	if !seen {
+		log.sites[key] = site
+	}
+	log.mu.Unlock()
+
+	if seen {
+		slog.Warn("duplicate unconditional write",
+			"pk", op.PK,
+			"sk", op.SK,
+			"entity_type", op.EntityType,
+			"first", first,
+			"second", site,
+		)
+	}
+}
+
+// callSite returns the first caller outside the repository package
+func callSite() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		inRepository := strings.HasPrefix(frame.Function, repositoryPkg) && !strings.HasSuffix(frame.File, "_test.go")
+		if !inRepository || !more {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TimeFormat is how times in entity data are stored. Every format reads
+// back with the Store's decoder, so changing it doesn't strand old items.
+type TimeFormat int
+
+const (
+	// TimeRFC3339Nano stores times as RFC 3339 strings in UTC with
+	// nanoseconds, trailing zeros trimmed. It is the default.
+	TimeRFC3339Nano TimeFormat = iota
+	// TimeRFC3339Millis stores fixed width RFC 3339 strings in UTC with
+	// milliseconds, which sort as strings
+	TimeRFC3339Millis
+	// TimeRFC3339 stores RFC 3339 strings in UTC to the second
+	TimeRFC3339
+	// TimeUnix stores times as numbers of seconds since the epoch, as
+	// attributevalue's unixtime tag does
+	TimeUnix
+)
+
+// EncodingOptions change how the Store marshals entity data, for models
+// with optional fields. They apply to the data attribute only; keys and
+// the Store's own attributes, such as created_at, are always written the
+// same way. Items are decoded the same whatever the options, with
+// attributes left out read as zero values.
+type EncodingOptions struct {
+	// OmitEmptyStrings leaves out empty string attributes rather than
+	// storing "", as if every string field were tagged omitempty
+	OmitEmptyStrings bool
+	// OmitNulls leaves out nil pointers, slices and maps rather than
+	// storing them as NULL
+	OmitNulls bool
+	// OmitZeroTimes leaves out zero times rather than storing
+	// 0001-01-01T00:00:00Z
+	OmitZeroTimes bool
+	// TimeFormat is how times are stored
+	TimeFormat TimeFormat
+}
+
+// WithEncoding sets how the Store marshals entity data. Data marshalled
+// with non-default options skips the compiled encoders.
+func WithEncoding(opts EncodingOptions) StoreOption {
+	return func(s *Store) {
+		s.encoding = opts
+		s.dataEncoder = attributevalue.NewEncoder(encodeOptions, func(o *attributevalue.EncoderOptions) {
+			o.EncodeTime = func(t time.Time) (types.AttributeValue, error) {
+				if opts.OmitZeroTimes && t.IsZero() {
+					return zeroTime, nil
+				}
+				return opts.TimeFormat.value(t), nil
+			}
+		})
+	}
+}
+
+// zeroTime stands in for zero times being left out until prune removes it.
+// It is compared by identity, so NULLs from anywhere else are untouched.
+var zeroTime types.AttributeValue = &types.AttributeValueMemberNULL{Value: true}
+
+func (f TimeFormat) value(t time.Time) types.AttributeValue {
+	t = t.UTC()
+	switch f {
+	case TimeRFC3339Millis:
+		return &types.AttributeValueMemberS{Value: t.Format("2006-01-02T15:04:05.000Z07:00")}
+	case TimeRFC3339:
+		return &types.AttributeValueMemberS{Value: t.Format(time.RFC3339)}
+	case TimeUnix:
+		return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+	}
+	return timestampValue(t)
+}
+
+// marshalItem marshals item for s, encoding its data with the Store's
+// encoding options if it has any
+func marshalItem[T any](s *Store, item GenericItem[T]) (map[string]types.AttributeValue, error) {
+	if s.dataEncoder == nil {
+		return marshalMap(item)
+	}
+	data, err := s.dataEncoder.Encode(item.Data)
+	if err != nil {
+		return nil, err
+	}
+	var zero T
+	item.Data = zero
+	av, err := marshalMap(item)
+	if err != nil {
+		return nil, err
+	}
+	if data = s.encoding.prune(data); data == nil {
+		return nil, fmt.Errorf("data attribute can't be left out")
+	}
+	av[dataAttribute] = data
+	return av, nil
+}
+
+// prune removes the attributes the options leave out from the maps within
+// av, returning nil if av itself is left out. List elements are kept, so
+// their positions don't change.
+func (o EncodingOptions) prune(av types.AttributeValue) types.AttributeValue {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		if o.OmitEmptyStrings && v.Value == "" {
+			return nil
+		}
+	case *types.AttributeValueMemberNULL:
+		if av == zeroTime || o.OmitNulls {
+			return nil
+		}
+	case *types.AttributeValueMemberM:
+		for name, elem := range v.Value {
+			if o.prune(elem) == nil {
+				delete(v.Value, name)
+			}
+		}
+	case *types.AttributeValueMemberL:
+		for i, elem := range v.Value {
+			if o.prune(elem) == nil {
+				v.Value[i] = &types.AttributeValueMemberNULL{Value: true}
+			}
+		}
+	}
+	return av
+}
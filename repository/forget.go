@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+)
+
+// forgetTransactionItems is how many writes Forget sends per transaction,
+// leaving room under DynamoDB's limit of 100 for their audit entries
+const forgetTransactionItems = 50
+
+// ForgetReport lists what Forget did with a user's items
+type ForgetReport struct {
+	Email string
+	// AnonymousEmail stands in for the user on the orders that were kept
+	AnonymousEmail string
+	// Deleted are the keys of the items removed
+	Deleted []ItemKey
+	// Anonymized are the new keys of the orders kept without the user's
+	// details
+	Anonymized []ItemKey
+	// AuditEntriesPurged counts the audit entries of the user's partition
+	// that were deleted, since they hold the user's old values
+	AuditEntriesPurged int
+}
+
+// Forget erases a user for a GDPR deletion request. Their profile,
+// addresses, stats and any other items in their collection are deleted.
+// Orders are kept for the books but moved to an anonymous partition, with
+// the email replaced by a random placeholder. Each order's move is atomic,
+// and the writes go out in transactions of up to 50, profile last, so a
+// Forget that fails part way can simply be run again. Finally the audit
+// log of the user's partition is purged.
+//
+// Items about the user outside their collection, such as coupon
+// redemptions and outbox events, are not covered.
+func (r *UserRepository) Forget(ctx context.Context, email string) (*ForgetReport, error) {
+	var items []RawItem
+	opts := &QueryOptions{}
+	for {
+		page, err := QueryCollection(ctx, r.store, Key.UserPK(email), opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.NextPageToken == nil {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+
+	report := &ForgetReport{
+		Email:          email,
+		AnonymousEmail: uuid.New().String() + "@forgotten.invalid",
+	}
+	// Each entry is a group of writes that must stay in one transaction
+	var moves, deletes, profile [][]types.TransactWriteItem
+	for _, raw := range items {
+		key := itemKey(raw)
+		switch raw.EntityType() {
+		case EntityOrder:
+			move, newKey, err := r.anonymizeOrder(ctx, raw, report.AnonymousEmail)
+			if err != nil {
+				return nil, err
+			}
+			moves = append(moves, move)
+			report.Anonymized = append(report.Anonymized, newKey)
+		case EntityUser:
+			profile = append(profile, []types.TransactWriteItem{r.deleteWrite(key)})
+			report.Deleted = append(report.Deleted, key)
+		default:
+			deletes = append(deletes, []types.TransactWriteItem{r.deleteWrite(key)})
+			report.Deleted = append(report.Deleted, key)
+		}
+	}
+
+	groups := append(append(moves, deletes...), profile...)
+	for len(groups) > 0 {
+		var batch []types.TransactWriteItem
+		for len(groups) > 0 && len(batch)+len(groups[0]) <= forgetTransactionItems {
+			batch = append(batch, groups[0]...)
+			groups = groups[1:]
+		}
+		if _, err := r.store.transactWrite(ctx, batch); err != nil {
+			return nil, fmt.Errorf("failed to forget user: %w", err)
+		}
+	}
+
+	// Purge even when nothing else was left, to finish an earlier Forget
+	purged, err := r.purgeAudit(ctx, Key.UserPK(email))
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 && purged == 0 {
+		return nil, ErrNotFound
+	}
+	report.AuditEntriesPurged = purged
+	return report, nil
+}
+
+// anonymizeOrder returns the writes moving an order to the anonymous
+// partition: the new copy is put and the old one deleted together
+func (r *UserRepository) anonymizeOrder(ctx context.Context, raw RawItem, anonymousEmail string) ([]types.TransactWriteItem, ItemKey, error) {
+	item, err := Decode[models.Order](raw)
+	if err != nil {
+		return nil, ItemKey{}, err
+	}
+	order := item.Data
+	order.UserEmail = anonymousEmail
+	moved := orderItem(order)
+
+	put, err := conditionalPut(ctx, r.store, moved, condition{expr: createOnly})
+	if err != nil {
+		return nil, ItemKey{}, err
+	}
+	return []types.TransactWriteItem{{Put: put}, r.deleteWrite(itemKey(raw))},
+		ItemKey{PK: moved.PK, SK: moved.SK}, nil
+}
+
+// purgeAudit deletes the audit entries of a partition, returning how many
+// there were
+func (r *UserRepository) purgeAudit(ctx context.Context, pk PrimaryKey) (int, error) {
+	var keys []ItemKey
+	opts := &QueryOptions{}
+	for {
+		// Read consistently to catch the entries Forget's own writes added
+		page, err := r.store.queryPage(ctx, query{
+			input: &dynamodb.QueryInput{
+				TableName:      aws.String(r.store.tableName),
+				ConsistentRead: aws.Bool(true),
+			},
+			key: partitionKey("PK", Key.AuditPK(pk)),
+		}, opts)
+		if err != nil {
+			return 0, err
+		}
+		for _, raw := range page.Items {
+			keys = append(keys, itemKey(raw))
+		}
+		if page.NextPageToken == nil {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+
+	result, err := BatchDeleteItems(ctx, r.store, keys)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit log: %w", err)
+	}
+	if !result.OK() {
+		return len(result.Succeeded), fmt.Errorf("failed to purge %d audit entries", len(result.Failed))
+	}
+	return len(keys), nil
+}
+
+func (r *UserRepository) deleteWrite(key ItemKey) types.TransactWriteItem {
+	return types.TransactWriteItem{Delete: &types.Delete{
+		TableName: aws.String(r.store.tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: string(key.PK)},
+			"SK": &types.AttributeValueMemberS{Value: string(key.SK)},
+		},
+	}}
+}
+
+// itemKey returns a raw item's keys
+func itemKey(raw RawItem) ItemKey {
+	return attributeKeys([]map[string]types.AttributeValue{raw})[0]
+}
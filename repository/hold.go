@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/schema"
+)
+
+// ErrHoldNotActive means a hold was already released, consumed or expired
+var ErrHoldNotActive = errors.New("inventory hold is not active")
+
+// holdRetention is how long finished holds are kept after they expire
+// before DynamoDB's TTL deletes them. Active holds are returned by
+// ReleaseExpiredHolds long before then, so TTL never eats held stock.
+const holdRetention = 7 * 24 * time.Hour
+
+// Hold takes quantity units of a product out of stock for a cart until ttl
+// passes. The stock comes back when the hold is released, or when
+// ReleaseExpiredHolds finds it expired; ConsumeHold keeps it sold instead.
+func (r *ProductRepository) Hold(ctx context.Context, productID, cartID string, quantity int, ttl time.Duration) (*models.InventoryHold, error) {
+	now := time.Now()
+	hold := models.InventoryHold{
+		HoldID:    uuid.New().String(),
+		ProductID: productID,
+		CartID:    cartID,
+		Quantity:  quantity,
+		Status:    models.HoldStatusActive,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := hold.Validate(); err != nil {
+		return nil, err
+	}
+
+	_, err := r.adjustStock(ctx, productID, -quantity, func() (*types.Put, error) {
+		return conditionalPut(ctx, r.store, holdItem(hold), condition{expr: createOnly})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// GetHold returns an inventory hold, whatever its status
+func (r *ProductRepository) GetHold(ctx context.Context, productID, holdID string) (*models.InventoryHold, error) {
+	var item GenericItem[models.InventoryHold]
+	err := GetItem(ctx, r.store, Key.HoldPK(productID), Key.HoldSK(holdID), &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// ReleaseHold returns a hold's stock to its product. Releasing a hold that
+// is no longer active does nothing, so a cart and the reconciliation job
+// can race to release the same hold.
+func (r *ProductRepository) ReleaseHold(ctx context.Context, productID, holdID string) error {
+	hold, err := r.GetHold(ctx, productID, holdID)
+	if err != nil {
+		return err
+	}
+	return r.release(ctx, *hold)
+}
+
+// ConsumeHold marks an active, unexpired hold as sold, so its stock stays
+// out of the product for good
+func (r *ProductRepository) ConsumeHold(ctx context.Context, productID, holdID string) (*models.InventoryHold, error) {
+	hold, err := r.GetHold(ctx, productID, holdID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if hold.Status != models.HoldStatusActive || hold.IsExpired(now) {
+		return nil, ErrHoldNotActive
+	}
+
+	hold.Status = models.HoldStatusConsumed
+	hold.UpdatedAt = now
+	err = putItemIf(ctx, r.store, holdItem(*hold), holdIsActive())
+	if errors.Is(err, ErrConditionFailed) {
+		return nil, ErrHoldNotActive
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ExpiredHolds returns up to limit active holds that expired by now, oldest
+// first
+func (r *ProductRepository) ExpiredHolds(ctx context.Context, now time.Time, limit int32) ([]models.InventoryHold, error) {
+	// This takes every hold expiring up to now
+	cutoff := timeCutoff(PrefixExpires, now)
+	input, err := query{
+		input: &dynamodb.QueryInput{
+			TableName: aws.String(r.store.tableName),
+			IndexName: aws.String(schema.GSI1),
+			Limit:     aws.Int32(limit),
+		},
+		key: partitionKey("GSI1PK", Key.HoldExpiryPK()).And(expression.Key("GSI1SK").LessThan(expression.Value(cutoff))),
+	}.build(Condition{})
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.store.client.Query(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired holds: %w", err)
+	}
+
+	holds := make([]models.InventoryHold, 0, len(result.Items))
+	for _, av := range result.Items {
+		var item GenericItem[models.InventoryHold]
+		if err := unmarshalMap(av, &item); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal hold: %w", err)
+		}
+		holds = append(holds, item.Data)
+	}
+	return holds, nil
+}
+
+// ReleaseExpiredHolds returns the stock of up to limit expired holds to
+// their products, reporting how many it released. It is safe to run from
+// several processes at once.
+func (r *ProductRepository) ReleaseExpiredHolds(ctx context.Context, now time.Time, limit int32) (int, error) {
+	holds, err := r.ExpiredHolds(ctx, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, hold := range holds {
+		// The index may lag behind a release, so check the hold itself
+		current, err := r.GetHold(ctx, hold.ProductID, hold.HoldID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		if current.Status != models.HoldStatusActive {
+			continue
+		}
+		if err := r.release(ctx, *current); err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// release marks an active hold released and gives its stock back in one
+// transaction
+func (r *ProductRepository) release(ctx context.Context, hold models.InventoryHold) error {
+	if hold.Status != models.HoldStatusActive {
+		return nil
+	}
+	hold.Status = models.HoldStatusReleased
+	hold.UpdatedAt = time.Now()
+	_, err := r.adjustStock(ctx, hold.ProductID, hold.Quantity, func() (*types.Put, error) {
+		return conditionalPut(ctx, r.store, holdItem(hold), holdIsActive())
+	})
+	if !errors.Is(err, ErrConditionFailed) {
+		return err
+	}
+
+	// Either the product kept changing under us or someone else finished
+	// the hold first; only the former is an error
+	current, getErr := r.GetHold(ctx, hold.ProductID, hold.HoldID)
+	if getErr != nil {
+		return getErr
+	}
+	if current.Status != models.HoldStatusActive {
+		return nil
+	}
+	return err
+}
+
+// holdIsActive guards a hold write on the stored hold still being active
+func holdIsActive() condition {
+	return AttributeEquals("data.status", models.HoldStatusActive).must()
+}
+
+// holdItem wraps a hold in its table item. Active holds are indexed in GSI1
+// by expiry for the reconciliation job; all holds carry a TTL so the table
+// forgets them once they are long finished.
+func holdItem(hold models.InventoryHold) GenericItem[models.InventoryHold] {
+	item := GenericItem[models.InventoryHold]{
+		PK:         Key.HoldPK(hold.ProductID),
+		SK:         Key.HoldSK(hold.HoldID),
+		EntityType: EntityInventoryHold,
+		Data:       hold,
+		TTL:        hold.ExpiresAt.Add(holdRetention).Unix(),
+	}
+	if hold.Status == models.HoldStatusActive {
+		item.GSI1PK = Key.HoldExpiryPK()
+		item.GSI1SK = Key.HoldExpirySK(hold.ExpiresAt, hold.HoldID)
+	}
+	return item
+}
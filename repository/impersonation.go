@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"LearnSingleTableDesign/models"
+)
+
+// ImpersonationRepository stores which sessions are viewing the store as
+// a user. Starting and stopping are writes like any other, so a store
+// with AuditWrites records who viewed as whom, and when.
+type ImpersonationRepository struct {
+	store *Store
+}
+
+// NewImpersonationRepository creates a new ImpersonationRepository
+func NewImpersonationRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *ImpersonationRepository {
+	return &ImpersonationRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Start lets a session view the store as the user with email for ttl,
+// replacing any impersonation it already had. The admin is the context's
+// actor.
+func (r *ImpersonationRepository) Start(ctx context.Context, sessionID, email string, ttl time.Duration) (*models.Impersonation, error) {
+	now := time.Now()
+	imp := models.Impersonation{
+		SessionID: sessionID,
+		Email:     models.NormalizeEmail(email),
+		Admin:     ActorFrom(ctx),
+		StartedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := imp.Validate(); err != nil {
+		return nil, err
+	}
+	item := GenericItem[models.Impersonation]{
+		PK:         Key.SessionPK(sessionID),
+		SK:         Key.ImpersonationSK(),
+		EntityType: EntityImpersonation,
+		Data:       imp,
+		TTL:        imp.ExpiresAt.Unix(),
+	}
+	if err := PutItem(ctx, r.store, item); err != nil {
+		return nil, err
+	}
+	return &imp, nil
+}
+
+// Get returns the session's impersonation, or ErrNotFound if it has none.
+// DynamoDB's TTL can take a while to delete an item, so an expired one is
+// treated as gone.
+func (r *ImpersonationRepository) Get(ctx context.Context, sessionID string) (*models.Impersonation, error) {
+	var item GenericItem[models.Impersonation]
+	if err := GetItem(ctx, r.store, Key.SessionPK(sessionID), Key.ImpersonationSK(), &item); err != nil {
+		return nil, err
+	}
+	if !item.Data.Active(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return &item.Data, nil
+}
+
+// Stop ends the session's impersonation. Stopping a session that isn't
+// impersonating anyone does nothing.
+func (r *ImpersonationRepository) Stop(ctx context.Context, sessionID string) error {
+	return DeleteItem(ctx, r.store, Key.SessionPK(sessionID), Key.ImpersonationSK())
+}
@@ -0,0 +1,165 @@
+package repository
+
+import "context"
+
+// PageIterator reads a query's pages in order, like bufio.Scanner:
+//
+//	it := IterateCollection(ctx, store, pk, nil, Prefetch())
+//	defer it.Close()
+//	for it.Next() {
+//		for _, item := range it.Items() { ... }
+//	}
+//	if err := it.Err(); err != nil { ... }
+type PageIterator[T any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	fetch  pageFetcher[T]
+	// prefetch reads the next page while the caller handles this one
+	prefetch bool
+	token    *PageToken
+	started  bool
+	items    []T
+	err      error
+	// pending is the page being prefetched
+	pending chan fetchedPage[T]
+}
+
+// pageFetcher reads the page starting at token, nil for the first
+type pageFetcher[T any] func(ctx context.Context, token *PageToken) ([]T, *PageToken, error)
+
+type fetchedPage[T any] struct {
+	items []T
+	next  *PageToken
+	err   error
+}
+
+// IteratorOption configures a PageIterator
+type IteratorOption func(*iteratorOptions)
+
+type iteratorOptions struct {
+	prefetch bool
+}
+
+// Prefetch makes the iterator read each next page in the background while
+// the caller handles the current one, hiding DynamoDB's latency for long
+// sequential reads like exports. At most one page is read ahead.
+func Prefetch() IteratorOption {
+	return func(o *iteratorOptions) {
+		o.prefetch = true
+	}
+}
+
+func newPageIterator[T any](ctx context.Context, start *PageToken, fetch pageFetcher[T], opts []IteratorOption) *PageIterator[T] {
+	var o iteratorOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &PageIterator[T]{ctx: ctx, cancel: cancel, fetch: fetch, prefetch: o.prefetch, token: start}
+}
+
+// IterateCollection iterates over the pages of a partition's whole item
+// collection, as QueryCollection reads them. opts.PageToken, if set, is
+// where the first page starts.
+func IterateCollection(ctx context.Context, s *Store, pk PrimaryKey, opts *QueryOptions, iterOpts ...IteratorOption) *PageIterator[RawItem] {
+	return newPageIterator(ctx, startToken(opts), func(ctx context.Context, token *PageToken) ([]RawItem, *PageToken, error) {
+		page, err := QueryCollection(ctx, s, pk, withPageToken(opts, token))
+		if err != nil {
+			return nil, nil, err
+		}
+		return page.Items, page.NextPageToken, nil
+	}, iterOpts)
+}
+
+// IterateData iterates over the pages QueryData reads
+func IterateData[T any](ctx context.Context, s *Store, pk PrimaryKey, skPrefix string, opts *QueryOptions, iterOpts ...IteratorOption) *PageIterator[T] {
+	return newPageIterator(ctx, startToken(opts), func(ctx context.Context, token *PageToken) ([]T, *PageToken, error) {
+		page, err := QueryData[T](ctx, s, pk, skPrefix, withPageToken(opts, token))
+		if err != nil {
+			return nil, nil, err
+		}
+		return page.Items, page.NextPageToken, nil
+	}, iterOpts)
+}
+
+func startToken(opts *QueryOptions) *PageToken {
+	if opts == nil {
+		return nil
+	}
+	return opts.PageToken
+}
+
+// withPageToken is a copy of opts starting at token, leaving the caller's
+// options alone
+func withPageToken(opts *QueryOptions, token *PageToken) *QueryOptions {
+	var o QueryOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.PageToken = token
+	return &o
+}
+
+// Next reads the next page, reporting false once there are no more or a
+// read failed
+func (it *PageIterator[T]) Next() bool {
+	if it.err != nil || it.started && it.token == nil {
+		it.items = nil
+		return false
+	}
+	it.started = true
+
+	var page fetchedPage[T]
+	if it.pending != nil {
+		page = <-it.pending
+		it.pending = nil
+	} else {
+		page = it.read(it.token)
+	}
+	if page.err != nil {
+		it.err = page.err
+		it.items = nil
+		it.cancel()
+		return false
+	}
+	it.items, it.token = page.items, page.next
+
+	if it.token == nil {
+		it.cancel()
+	} else if it.prefetch {
+		pending := make(chan fetchedPage[T], 1)
+		it.pending = pending
+		go func(token *PageToken) {
+			pending <- it.read(token)
+		}(it.token)
+	}
+	return true
+}
+
+func (it *PageIterator[T]) read(token *PageToken) fetchedPage[T] {
+	items, next, err := it.fetch(it.ctx, token)
+	return fetchedPage[T]{items: items, next: next, err: err}
+}
+
+// Items is the page Next read. Pages may be empty when a filter dropped
+// every item read.
+func (it *PageIterator[T]) Items() []T {
+	return it.items
+}
+
+// NextPageToken is where the page after the current one starts, or nil if
+// it was the last. It lets a caller that stops early resume later.
+func (it *PageIterator[T]) NextPageToken() *PageToken {
+	return it.token
+}
+
+// Err is the error that stopped the iterator, if any
+func (it *PageIterator[T]) Err() error {
+	return it.err
+}
+
+// Close stops any page being read ahead. Iterators that ran to the end or
+// failed are closed already.
+func (it *PageIterator[T]) Close() {
+	it.cancel()
+}
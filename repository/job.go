@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/schema"
+)
+
+// ErrLeaseLost means another worker claimed the job since it was read, so
+// the caller no longer owns it
+var ErrLeaseLost = errors.New("job lease lost")
+
+// claimCandidates is how many visible jobs Claim reads per attempt; losing
+// the race for one job moves on to the next instead of querying again
+const claimCandidates = 10
+
+// JobRepository is a queue of background jobs. Pending and running jobs are
+// indexed in GSI1 by when they next become visible; workers claim them with
+// conditional writes, and a worker that dies simply lets its lease expire.
+type JobRepository struct {
+	store *Store
+}
+
+func NewJobRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *JobRepository {
+	return &JobRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Enqueue adds a job that becomes claimable at runAt
+func (r *JobRepository) Enqueue(ctx context.Context, jobType, payload string, runAt time.Time, maxAttempts int) (*models.Job, error) {
+	now := time.Now()
+	job := models.Job{
+		JobID:       uuid.New().String(),
+		Type:        jobType,
+		Payload:     payload,
+		Status:      models.JobStatusPending,
+		MaxAttempts: maxAttempts,
+		VisibleAt:   runAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := r.save(ctx, job, -1); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *JobRepository) Get(ctx context.Context, jobID string) (*models.Job, error) {
+	var item GenericItem[models.Job]
+	err := GetItem(ctx, r.store, Key.JobPK(jobID), Key.JobSK(jobID), &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// Claim takes the oldest visible job and leases it to the caller for lease.
+// It returns nil when no job is ready. Running jobs whose lease has expired
+// are claimed again, or marked failed if that was their last attempt.
+func (r *JobRepository) Claim(ctx context.Context, lease time.Duration) (*models.Job, error) {
+	now := time.Now()
+	// This takes every job visible up to now
+	cutoff := timeCutoff(PrefixVisible, now)
+	input, err := query{
+		input: &dynamodb.QueryInput{
+			TableName: aws.String(r.store.tableName),
+			IndexName: aws.String(schema.GSI1),
+			Limit:     aws.Int32(claimCandidates),
+		},
+		key: partitionKey("GSI1PK", Key.JobQueuePK()).And(expression.Key("GSI1SK").LessThan(expression.Value(cutoff))),
+	}.build(Condition{})
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.store.client.Query(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job queue: %w", err)
+	}
+
+	for _, av := range result.Items {
+		var item GenericItem[models.Job]
+		if err := unmarshalMap(av, &item); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+		}
+		job := item.Data
+		claimed := job.Attempts
+
+		if job.Attempts >= job.MaxAttempts {
+			// The last worker died holding the final attempt
+			job.Status = models.JobStatusFailed
+			job.LastError = "lease expired on the final attempt"
+			job.UpdatedAt = now
+			if err := r.save(ctx, job, claimed); err != nil && !errors.Is(err, ErrLeaseLost) {
+				return nil, err
+			}
+			continue
+		}
+
+		job.Status = models.JobStatusRunning
+		job.Attempts++
+		job.VisibleAt = now.Add(lease)
+		job.UpdatedAt = now
+		err := r.save(ctx, job, claimed)
+		if errors.Is(err, ErrLeaseLost) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &job, nil
+	}
+	return nil, nil
+}
+
+// Complete marks a claimed job done and takes it out of the queue
+func (r *JobRepository) Complete(ctx context.Context, job models.Job) error {
+	job.Status = models.JobStatusDone
+	job.LastError = ""
+	job.UpdatedAt = time.Now()
+	return r.save(ctx, job, job.Attempts)
+}
+
+// Fail records a failed run of a claimed job. The job is retried after
+// retryAfter, or marked failed if it has no attempts left.
+func (r *JobRepository) Fail(ctx context.Context, job models.Job, jobErr error, retryAfter time.Duration) error {
+	now := time.Now()
+	job.LastError = jobErr.Error()
+	job.UpdatedAt = now
+	if job.Attempts >= job.MaxAttempts {
+		job.Status = models.JobStatusFailed
+	} else {
+		job.Status = models.JobStatusPending
+		job.VisibleAt = now.Add(retryAfter)
+	}
+	return r.save(ctx, job, job.Attempts)
+}
+
+// save writes the job if its stored attempt count is still expectedAttempts,
+// or if it doesn't exist yet when expectedAttempts is negative
+func (r *JobRepository) save(ctx context.Context, job models.Job, expectedAttempts int) error {
+	if err := job.Validate(); err != nil {
+		return err
+	}
+	item := GenericItem[models.Job]{
+		PK:         Key.JobPK(job.JobID),
+		SK:         Key.JobSK(job.JobID),
+		EntityType: EntityJob,
+		Data:       job,
+	}
+	if job.Status == models.JobStatusPending || job.Status == models.JobStatusRunning {
+		item.GSI1PK = Key.JobQueuePK()
+		item.GSI1SK = Key.JobQueueSK(job.VisibleAt, job.JobID)
+	}
+	cond := condition{expr: createOnly}
+	if expectedAttempts >= 0 {
+		cond = AttributeEquals("data.attempts", expectedAttempts).must()
+	}
+	err := putItemIf(ctx, r.store, item, cond)
+	if errors.Is(err, ErrConditionFailed) {
+		return ErrLeaseLost
+	}
+	return err
+}
@@ -1,27 +1,382 @@
 package repository
 
-import "fmt"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
 
-type KeyFactory struct{}
+	"LearnSingleTableDesign/models"
+)
+
+// IDHasher turns an identifier such as an email into the value embedded in keys
+type IDHasher interface {
+	HashID(id string) string
+}
+
+// PlainIDs embeds identifiers in keys unchanged
+type PlainIDs struct{}
+
+func (PlainIDs) HashID(id string) string {
+	return id
+}
+
+// HMACIDs embeds an HMAC-SHA256 of identifiers in keys, so the table never
+// holds raw emails in PK/SK. The secret must stay stable; changing it means
+// re-keying the table (see cmd/rekey).
+type HMACIDs struct {
+	Secret []byte
+}
+
+func (h HMACIDs) HashID(id string) string {
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewIDHasher returns HMACIDs keyed by secret, or PlainIDs when secret is empty
+func NewIDHasher(secret string) IDHasher {
+	if secret == "" {
+		return PlainIDs{}
+	}
+	return HMACIDs{Secret: []byte(secret)}
+}
+
+type KeyFactory struct {
+	// ids hashes user identifiers; nil means PlainIDs
+	ids IDHasher
+}
 
 var Key = KeyFactory{}
 
-func (KeyFactory) UserPK(email string) PrimaryKey {
-	return PrimaryKey(fmt.Sprintf("USER#%s", email))
+// NewKeyFactory creates a KeyFactory that hashes user identifiers with ids
+func NewKeyFactory(ids IDHasher) KeyFactory {
+	return KeyFactory{ids: ids}
+}
+
+// UseIDHasher changes how the global Key embeds user identifiers.
+// It should be called at startup before any Store is used.
+func UseIDHasher(ids IDHasher) {
+	Key = NewKeyFactory(ids)
 }
 
-func (KeyFactory) UserSK(email string) SortKey {
-	return SortKey(fmt.Sprintf("PROFILE#%s", email))
+// userID is the value a user's keys embed: their normalized email, hashed
+// by ids when set
+func (k KeyFactory) userID(email string) string {
+	email = models.NormalizeEmail(email)
+	if k.ids == nil {
+		return email
+	}
+	return k.ids.HashID(email)
+}
+
+func (k KeyFactory) UserPK(email string) PrimaryKey {
+	return PrimaryKey(PrefixUser.Of(k.userID(email)))
+}
+
+func (k KeyFactory) UserSK(email string) SortKey {
+	return SortKey(PrefixProfile.Of(k.userID(email)))
+}
+
+// UserStatsSK is the single stats item in a user's collection
+func (KeyFactory) UserStatsSK() SortKey {
+	return StatsSK
 }
 
 func (KeyFactory) OrderSK(orderID string) SortKey {
-	return SortKey(fmt.Sprintf("ORDER#%s", orderID))
+	return SortKey(PrefixOrder.Of(orderID))
+}
+
+// OrderDatePK is the GSI1 partition indexing every order created on a UTC
+// day, for store-wide views like recent orders
+func (KeyFactory) OrderDatePK(createdAt time.Time) PrimaryKey {
+	return PrimaryKey(PrefixOrderDate.Of(createdAt.UTC().Format(time.DateOnly)))
+}
+
+// OrderDateSK sorts a day's orders in GSI1 by creation time
+func (KeyFactory) OrderDateSK(createdAt time.Time, orderID string) SortKey {
+	return SortKey(timeKey(PrefixCreated, createdAt, orderID))
+}
+
+// OrderStatusPK is the GSI2 partition indexing a user's orders in one
+// status, sorted by OrderDateSK, for filtering their order history
+func (k KeyFactory) OrderStatusPK(email string, status models.OrderStatus) PrimaryKey {
+	return PrimaryKey(PrefixOrderStatus.Of(k.userID(email), string(status)))
+}
+
+// PaymentPK is the item collection holding an order's payments and log.
+// Orders themselves live under their user, so these can be listed by order
+// ID alone.
+func (KeyFactory) PaymentPK(orderID string) PrimaryKey {
+	return PrimaryKey(PrefixOrder.Of(orderID))
+}
+
+func (KeyFactory) PaymentSK(paymentID string) SortKey {
+	return SortKey(PrefixPayment.Of(paymentID))
+}
+
+// OrderLogSK orders an order's log entries by when they happened, in the
+// partition holding its payments
+func (KeyFactory) OrderLogSK(at time.Time, entryID string) SortKey {
+	return SortKey(timeKey(PrefixLog, at, entryID))
+}
+
+func (KeyFactory) AddressSK(addressID string) SortKey {
+	return SortKey(PrefixAddress.Of(addressID))
 }
 
 func (KeyFactory) ProductPK() PrimaryKey {
-	return "PRODUCT#ALL"
+	return PrimaryKey(PrefixProduct.Of(PartitionAll))
 }
 
 func (KeyFactory) ProductSK(productID string) SortKey {
-	return SortKey(fmt.Sprintf("PRODUCT#%s", productID))
+	return SortKey(PrefixProduct.Of(productID))
+}
+
+// ProductNamePK is the GSI1 partition indexing products by name
+func (KeyFactory) ProductNamePK() PrimaryKey {
+	return PrimaryKey(PrefixProductName.Of(PartitionAll))
+}
+
+// ProductNameSK sorts products by lowercase name in GSI1; the ID keeps
+// products with the same name apart
+func (KeyFactory) ProductNameSK(name, productID string) SortKey {
+	return SortKey(PrefixName.Of(strings.ToLower(name), productID))
+}
+
+// LowStockPK is the sparse GSI2 partition holding only the products that
+// are below their stock threshold
+func (KeyFactory) LowStockPK() PrimaryKey {
+	return PrimaryKey(PrefixLowStock.Of(PartitionAll))
+}
+
+// LowStockSK sorts low stock products in GSI2 by stock, lowest first
+func (KeyFactory) LowStockSK(stock int, productID string) SortKey {
+	return SortKey(PrefixStock.Of(fmt.Sprintf("%06d", stock), productID))
+}
+
+// FeaturedPK is the sparse GSI3 partition holding only featured products,
+// sorted like ProductNameSK
+func (KeyFactory) FeaturedPK() PrimaryKey {
+	return PrimaryKey(PrefixFeatured.Of(PartitionAll))
+}
+
+// HoldPK is the product's item collection, which also holds its inventory holds
+func (KeyFactory) HoldPK(productID string) PrimaryKey {
+	return PrimaryKey(PrefixProduct.Of(productID))
+}
+
+// HoldSK is an inventory hold in its product's item collection
+func (KeyFactory) HoldSK(holdID string) SortKey {
+	return SortKey(PrefixHold.Of(holdID))
+}
+
+// HoldExpiryPK is the GSI1 partition holding active inventory holds.
+// Released and consumed holds drop their GSI1 keys, so the index only holds
+// stock that may still need returning.
+func (KeyFactory) HoldExpiryPK() PrimaryKey {
+	return PrimaryKey(PrefixHoldExpiry.Of(PartitionAll))
+}
+
+// HoldExpirySK orders active holds in GSI1 by when they expire
+func (KeyFactory) HoldExpirySK(expiresAt time.Time, holdID string) SortKey {
+	return SortKey(timeKey(PrefixExpires, expiresAt, holdID))
+}
+
+// ProductContentPK is the item collection holding a product's localized content
+func (KeyFactory) ProductContentPK(productID string) PrimaryKey {
+	return PrimaryKey(PrefixProduct.Of(productID))
+}
+
+func (KeyFactory) ProductContentSK(locale string) SortKey {
+	return SortKey(PrefixContent.Of(locale))
+}
+
+func (KeyFactory) PagePK() PrimaryKey {
+	return PrimaryKey(PrefixPage.Of(PartitionAll))
+}
+
+func (KeyFactory) PageSK(slug string) SortKey {
+	return SortKey(PrefixPage.Of(slug))
+}
+
+func (KeyFactory) WebhookPK() PrimaryKey {
+	return PrimaryKey(PrefixWebhook.Of(PartitionAll))
+}
+
+func (KeyFactory) WebhookSK(webhookID string) SortKey {
+	return SortKey(PrefixWebhook.Of(webhookID))
+}
+
+// WebhookDeliveryPK is the item collection holding a webhook's delivery attempts
+func (KeyFactory) WebhookDeliveryPK(webhookID string) PrimaryKey {
+	return PrimaryKey(PrefixWebhook.Of(webhookID))
+}
+
+func (KeyFactory) WebhookDeliverySK(eventID string, attempt int) SortKey {
+	return SortKey(PrefixDelivery.Of(eventID, fmt.Sprintf("%03d", attempt)))
+}
+
+func (KeyFactory) JobPK(jobID string) PrimaryKey {
+	return PrimaryKey(PrefixJob.Of(jobID))
+}
+
+func (KeyFactory) JobSK(jobID string) SortKey {
+	return SortKey(PrefixJob.Of(jobID))
+}
+
+// JobQueuePK is the GSI1 partition holding claimable jobs. Finished jobs
+// drop their GSI1 keys, so the index only ever holds live work.
+func (KeyFactory) JobQueuePK() PrimaryKey {
+	return PrimaryKey(PrefixJobQueue.Of(PartitionAll))
+}
+
+// JobQueueSK orders jobs in GSI1 by when they can next be claimed
+func (KeyFactory) JobQueueSK(visibleAt time.Time, jobID string) SortKey {
+	return SortKey(timeKey(PrefixVisible, visibleAt, jobID))
+}
+
+func (KeyFactory) OutboxPK(eventID string) PrimaryKey {
+	return PrimaryKey(PrefixOutbox.Of(eventID))
+}
+
+func (KeyFactory) OutboxSK(eventID string) SortKey {
+	return SortKey(PrefixOutbox.Of(eventID))
+}
+
+// OutboxPendingPK is the GSI1 partition holding unpublished outbox events.
+// Published events drop their GSI1 keys.
+func (KeyFactory) OutboxPendingPK() PrimaryKey {
+	return PrimaryKey(PrefixOutbox.Of(PartitionPending))
+}
+
+// OutboxPendingSK orders unpublished events in GSI1 by creation time
+func (KeyFactory) OutboxPendingSK(createdAt time.Time, eventID string) SortKey {
+	return SortKey(timeKey(PrefixCreated, createdAt, eventID))
+}
+
+// CouponPK is the item collection holding a coupon and its redemptions.
+// Codes are case-insensitive, so they are keyed upper case.
+func (KeyFactory) CouponPK(code string) PrimaryKey {
+	return PrimaryKey(PrefixCoupon.Of(strings.ToUpper(code)))
+}
+
+func (KeyFactory) CouponSK(code string) SortKey {
+	return SortKey(PrefixCoupon.Of(strings.ToUpper(code)))
+}
+
+// CouponRedemptionSK is one user's redemption in the coupon's collection
+func (k KeyFactory) CouponRedemptionSK(email string) SortKey {
+	return SortKey(PrefixRedemption.Of(k.userID(email)))
+}
+
+// AuditPK is the partition holding the audit log of the items under pk
+func (KeyFactory) AuditPK(pk PrimaryKey) PrimaryKey {
+	// pk is already a key, so it is embedded as is
+	return PrimaryKey(string(PrefixAudit) + string(pk))
+}
+
+// AuditSK orders audit entries by when the write happened, to the
+// nanosecond, so entries written in the same millisecond still sort in
+// the order auditClock handed out their times
+func (KeyFactory) AuditSK(at time.Time, auditID string) SortKey {
+	return SortKey(PrefixAt.Of(sortableNanos(at), auditID))
+}
+
+// AuditDayPK is the GSI1 partition holding every audit entry of a UTC day
+func (KeyFactory) AuditDayPK(at time.Time) PrimaryKey {
+	return PrimaryKey(PrefixAuditDay.Of(at.UTC().Format(time.DateOnly)))
+}
+
+// SalesPK is the partition holding a month of daily sales rollups.
+// Partitioning by month keeps each partition small and lets a date range
+// be read with one query per month.
+func (KeyFactory) SalesPK(day time.Time) PrimaryKey {
+	return PrimaryKey(PrefixSales.Of(day.UTC().Format("2006-01")))
+}
+
+func (KeyFactory) SalesSK(day time.Time) SortKey {
+	return SortKey(PrefixSales.Of(day.UTC().Format(time.DateOnly)))
+}
+
+// ContactPK is the single partition holding every contact message
+func (KeyFactory) ContactPK() PrimaryKey {
+	return PrimaryKey(PrefixContact.Of(PartitionAll))
+}
+
+// ContactSK orders contact messages by when they were sent
+func (KeyFactory) ContactSK(sentAt time.Time, messageID string) SortKey {
+	return SortKey(timeKey(PrefixAt, sentAt, messageID))
+}
+
+// SessionPK is the partition of an anonymous visitor's session
+func (KeyFactory) SessionPK(sessionID string) PrimaryKey {
+	return PrimaryKey(PrefixSession.Of(sessionID))
+}
+
+// CartSK is the cart item in a user's or session's partition
+func (KeyFactory) CartSK() SortKey {
+	return CartSK
+}
+
+// ImpersonationSK is the impersonation item in a session's partition
+func (KeyFactory) ImpersonationSK() SortKey {
+	return ImpersonationSK
+}
+
+// NotificationPrefsSK is the notification prefs item in a user's collection
+func (KeyFactory) NotificationPrefsSK() SortKey {
+	return NotificationPrefsSK
+}
+
+// KeyPattern describes the key prefixes an entity type may be stored under
+type KeyPattern struct {
+	PKPrefix Prefix
+	SKPrefix Prefix
+}
+
+// Matches reports whether the keys start with the pattern's prefixes
+func (p KeyPattern) Matches(pk PrimaryKey, sk SortKey) bool {
+	return p.PKPrefix.Has(string(pk)) && p.SKPrefix.Has(string(sk))
+}
+
+// entityRegistry maps each entity type to its declared key pattern
+var entityRegistry = map[string]KeyPattern{
+	EntityUser:              {PKPrefix: PrefixUser, SKPrefix: PrefixProfile},
+	EntityOrder:             {PKPrefix: PrefixUser, SKPrefix: PrefixOrder},
+	EntityProduct:           {PKPrefix: PrefixProduct, SKPrefix: PrefixProduct},
+	EntityProductContent:    {PKPrefix: PrefixProduct, SKPrefix: PrefixContent},
+	EntityPage:              {PKPrefix: PrefixPage, SKPrefix: PrefixPage},
+	EntityAddress:           {PKPrefix: PrefixUser, SKPrefix: PrefixAddress},
+	EntityWebhook:           {PKPrefix: PrefixWebhook, SKPrefix: PrefixWebhook},
+	EntityWebhookDelivery:   {PKPrefix: PrefixWebhook, SKPrefix: PrefixDelivery},
+	EntityJob:               {PKPrefix: PrefixJob, SKPrefix: PrefixJob},
+	EntityUserStats:         {PKPrefix: PrefixUser, SKPrefix: Prefix(StatsSK)},
+	EntityDailySales:        {PKPrefix: PrefixSales, SKPrefix: PrefixSales},
+	EntityInventoryHold:     {PKPrefix: PrefixProduct, SKPrefix: PrefixHold},
+	EntityPayment:           {PKPrefix: PrefixOrder, SKPrefix: PrefixPayment},
+	EntityOutboxEvent:       {PKPrefix: PrefixOutbox, SKPrefix: PrefixOutbox},
+	EntityCoupon:            {PKPrefix: PrefixCoupon, SKPrefix: PrefixCoupon},
+	EntityAudit:             {PKPrefix: PrefixAudit, SKPrefix: PrefixAt},
+	EntityCouponRedemption:  {PKPrefix: PrefixCoupon, SKPrefix: PrefixRedemption},
+	EntityContactMessage:    {PKPrefix: PrefixContact, SKPrefix: PrefixAt},
+	EntityCart:              {PKPrefix: PrefixUser, SKPrefix: Prefix(CartSK)},
+	EntityOrderLog:          {PKPrefix: PrefixOrder, SKPrefix: PrefixLog},
+	EntitySessionCart:       {PKPrefix: PrefixSession, SKPrefix: Prefix(CartSK)},
+	EntityImpersonation:     {PKPrefix: PrefixSession, SKPrefix: Prefix(ImpersonationSK)},
+	EntityNotificationPrefs: {PKPrefix: PrefixUser, SKPrefix: Prefix(NotificationPrefsSK)},
+}
+
+// RegisterEntity declares the key pattern for an entity type.
+// It should be called from an init function before any Store is used.
+func RegisterEntity(entityType string, pattern KeyPattern) {
+	entityRegistry[entityType] = pattern
+}
+
+// EntityKeyPattern returns the key pattern registered for an entity type
+func EntityKeyPattern(entityType string) (KeyPattern, bool) {
+	pattern, ok := entityRegistry[entityType]
+	return pattern, ok
 }
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyDelimiter separates the parts of a key
+const KeyDelimiter = "#"
+
+// Prefix starts every key of one kind and ends with KeyDelimiter. The
+// prefixes below are the whole keyspace of the table; KeyFactory builds
+// keys from them and the Parse functions take keys apart again.
+type Prefix string
+
+// Partition key prefixes
+const (
+	PrefixUser        Prefix = "USER#"
+	PrefixProduct     Prefix = "PRODUCT#"
+	PrefixPage        Prefix = "PAGE#"
+	PrefixWebhook     Prefix = "WEBHOOK#"
+	PrefixJob         Prefix = "JOB#"
+	PrefixOutbox      Prefix = "OUTBOX#"
+	PrefixCoupon      Prefix = "COUPON#"
+	PrefixSales       Prefix = "SALES#"
+	PrefixAudit       Prefix = "AUDIT#"
+	PrefixContact     Prefix = "CONTACT#"
+	PrefixSession     Prefix = "SESSION#"
+	PrefixOrderDate   Prefix = "ORDER_DATE#"
+	PrefixProductName Prefix = "PRODUCT_NAME#"
+	PrefixLowStock    Prefix = "LOW_STOCK#"
+	PrefixFeatured    Prefix = "FEATURED#"
+	PrefixHoldExpiry  Prefix = "HOLD_EXPIRY#"
+	PrefixJobQueue    Prefix = "JOB_QUEUE#"
+	PrefixAuditDay    Prefix = "AUDIT_DAY#"
+	PrefixOrderStatus Prefix = "ORDER_STATUS#"
+)
+
+// Sort key prefixes. ORDER# is also the partition of an order's payments
+// and event log.
+const (
+	PrefixProfile    Prefix = "PROFILE#"
+	PrefixOrder      Prefix = "ORDER#"
+	PrefixPayment    Prefix = "PAYMENT#"
+	PrefixAddress    Prefix = "ADDRESS#"
+	PrefixContent    Prefix = "CONTENT#"
+	PrefixHold       Prefix = "HOLD#"
+	PrefixDelivery   Prefix = "DELIVERY#"
+	PrefixRedemption Prefix = "REDEMPTION#"
+	PrefixName       Prefix = "NAME#"
+	PrefixStock      Prefix = "STOCK#"
+	PrefixCreated    Prefix = "CREATED#"
+	PrefixExpires    Prefix = "EXPIRES#"
+	PrefixVisible    Prefix = "VISIBLE#"
+	PrefixAt         Prefix = "AT#"
+	PrefixLog        Prefix = "LOG#"
+)
+
+// Fixed key values
+const (
+	// PartitionAll names the single partition of entities listed together
+	PartitionAll = "ALL"
+	// PartitionPending names the GSI1 partition of unpublished outbox events
+	PartitionPending = "PENDING"
+	// StatsSK is the single stats item in a user's collection
+	StatsSK SortKey = "STATS"
+	// CartSK is the single cart item in a user's or session's collection
+	CartSK SortKey = "CART"
+	// ImpersonationSK is the single impersonation item in a session's
+	// partition
+	ImpersonationSK SortKey = "IMPERSONATION"
+	// NotificationPrefsSK is the single notification prefs item in a
+	// user's collection
+	NotificationPrefsSK SortKey = "NOTIFICATION_PREFS"
+)
+
+// ErrMalformedKey means a key doesn't have the shape its parser expects
+var ErrMalformedKey = errors.New("malformed key")
+
+// keyEscaper encodes the delimiter in key parts, and the escape character
+// itself so encoded parts decode unambiguously
+var (
+	keyEscaper   = strings.NewReplacer("%", "%25", KeyDelimiter, "%23")
+	keyUnescaper = strings.NewReplacer("%23", KeyDelimiter, "%25", "%")
+)
+
+// Of returns a key of this kind, with the parts joined by KeyDelimiter.
+// Delimiters inside a part are escaped, so a value like "a#ORDER#1" can't
+// forge extra key segments; models reject them up front as well.
+func (p Prefix) Of(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = keyEscaper.Replace(part)
+	}
+	return string(p) + strings.Join(escaped, KeyDelimiter)
+}
+
+// Has reports whether key is of this kind
+func (p Prefix) Has(key string) bool {
+	return strings.HasPrefix(key, string(p))
+}
+
+// Trim returns key without the prefix, and whether it had it
+func (p Prefix) Trim(key string) (string, bool) {
+	return strings.CutPrefix(key, string(p))
+}
+
+// ParseKey splits a key into its prefix and the rest, for tools that dump
+// keys without knowing their kind. Keys without a delimiter, like StatsSK,
+// have no prefix.
+func ParseKey(key string) (Prefix, string) {
+	head, rest, found := strings.Cut(key, KeyDelimiter)
+	if !found {
+		return "", key
+	}
+	return Prefix(head + KeyDelimiter), rest
+}
+
+// ParseUserPK returns the user ID in a user partition key: the email, or
+// its hash when keys are hashed
+func ParseUserPK(pk PrimaryKey) (string, error) {
+	return parseID(PrefixUser, string(pk))
+}
+
+// ParseOrderSK returns the order ID in an order's sort key
+func ParseOrderSK(sk SortKey) (string, error) {
+	return parseID(PrefixOrder, string(sk))
+}
+
+// ParsePaymentPK returns the order ID of a payments partition key
+func ParsePaymentPK(pk PrimaryKey) (string, error) {
+	return parseID(PrefixOrder, string(pk))
+}
+
+// ParseProductSK returns the product ID in a product's sort key
+func ParseProductSK(sk SortKey) (string, error) {
+	return parseID(PrefixProduct, string(sk))
+}
+
+// ParseTimeKey takes apart the time-ordered keys built by timeKey, like
+// OrderDateSK and AuditSK, returning the time and the ID that follows it
+func ParseTimeKey(prefix Prefix, key string) (time.Time, string, error) {
+	rest, ok := prefix.Trim(key)
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("%w: %q is not a %s key", ErrMalformedKey, key, prefix)
+	}
+	digits, id, found := strings.Cut(rest, KeyDelimiter)
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if !found || err != nil || id == "" {
+		return time.Time{}, "", fmt.Errorf("%w: %q has no time and ID", ErrMalformedKey, key)
+	}
+	if len(digits) == nanosDigits {
+		return time.Unix(0, n).UTC(), keyUnescaper.Replace(id), nil
+	}
+	return time.UnixMilli(n).UTC(), keyUnescaper.Replace(id), nil
+}
+
+// parseID returns the single value after prefix in key
+func parseID(prefix Prefix, key string) (string, error) {
+	id, ok := prefix.Trim(key)
+	if !ok || id == "" || strings.Contains(id, KeyDelimiter) {
+		return "", fmt.Errorf("%w: %q is not a %s key", ErrMalformedKey, key, prefix)
+	}
+	return keyUnescaper.Replace(id), nil
+}
+
+// timeKey orders keys by t to the millisecond, zero padded so they sort as
+// strings, with id keeping keys at the same instant apart. Milliseconds
+// since the epoch don't depend on t's zone, so neither does the key.
+func timeKey(prefix Prefix, t time.Time, id string) string {
+	return prefix.Of(sortableMillis(t), id)
+}
+
+// timeCutoff sorts just after every timeKey of prefix up to t, since '$'
+// sorts just after '#'
+func timeCutoff(prefix Prefix, t time.Time) string {
+	return string(prefix) + sortableMillis(t) + "$"
+}
+
+// sortableMillis writes t as 13 zero padded digits of milliseconds since
+// the epoch, which sort as strings until the year 2286. Times before the
+// epoch would print a sign and sort wrongly, so they are clamped to it.
+func sortableMillis(t time.Time) string {
+	return fmt.Sprintf("%013d", max(t.UnixMilli(), 0))
+}
+
+// lsiMillis is t as an SK2, milliseconds since the epoch, or 0 for the
+// zero time so omitempty leaves the item out of LSI1 instead of sorting it
+// before every dated item
+func lsiMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// nanosDigits is the width of sortableNanos
+const nanosDigits = 19
+
+// sortableNanos writes t as 19 zero padded digits of nanoseconds since the
+// epoch, which sort as strings until the year 2262. Its first 13 digits
+// are sortableMillis, so keys written before AuditSK went to nanoseconds
+// still sort among the new ones.
+func sortableNanos(t time.Time) string {
+	return fmt.Sprintf("%019d", max(t.UnixNano(), 0))
+}
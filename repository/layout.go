@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Layout is a way of arranging an entity's attributes within an item
+type Layout string
+
+const (
+	// LayoutNested stores the entity under a single "data" map attribute.
+	// This is what the Store writes today.
+	LayoutNested Layout = "nested"
+	// LayoutFlattened stores the entity's attributes at the top level next to the keys
+	LayoutFlattened Layout = "flattened"
+)
+
+// dataAttribute is the attribute GenericItem stores the entity under
+const dataAttribute = "data"
+
+// MarshalLayout marshals the item into the given layout
+func MarshalLayout[T any](item GenericItem[T], layout Layout) (map[string]types.AttributeValue, error) {
+	av, err := marshalMap(item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal item: %w", err)
+	}
+	if layout == LayoutNested {
+		return av, nil
+	}
+	// Flattened items leave out the Store's timestamps, which would collide
+	// with entities' own created_at
+	delete(av, createdAtAttribute)
+	delete(av, updatedAtAttribute)
+
+	data, ok := av[dataAttribute].(*types.AttributeValueMemberM)
+	if !ok {
+		return nil, fmt.Errorf("item data is not a map")
+	}
+	delete(av, dataAttribute)
+	for name, value := range data.Value {
+		if _, exists := av[name]; exists {
+			return nil, fmt.Errorf("data attribute %q collides with an item attribute", name)
+		}
+		av[name] = value
+	}
+	return av, nil
+}
+
+// UnmarshalLayout unmarshals an item stored in the given layout
+func UnmarshalLayout[T any](av map[string]types.AttributeValue, layout Layout, out *GenericItem[T]) error {
+	if layout == LayoutFlattened {
+		// The entity's attributes sit next to the envelope, so decode the whole
+		// item as the data map too. Envelope attributes T doesn't have are ignored.
+		nested := maps.Clone(av)
+		nested[dataAttribute] = &types.AttributeValueMemberM{Value: av}
+		av = nested
+	}
+	if err := unmarshalMap(av, out); err != nil {
+		return fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+	if layout == LayoutFlattened {
+		out.CreatedAt, out.UpdatedAt = time.Time{}, time.Time{}
+	}
+	return nil
+}
+
+// ItemSize estimates the stored size of an item in bytes using DynamoDB's
+// sizing rules: attribute names plus values, with container overheads
+func ItemSize(av map[string]types.AttributeValue) int {
+	size := 0
+	for name, value := range av {
+		size += len(name) + attributeSize(value)
+	}
+	return size
+}
+
+func attributeSize(value types.AttributeValue) int {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return len(v.Value)
+	case *types.AttributeValueMemberN:
+		return numberSize(v.Value)
+	case *types.AttributeValueMemberB:
+		return len(v.Value)
+	case *types.AttributeValueMemberBOOL, *types.AttributeValueMemberNULL:
+		return 1
+	case *types.AttributeValueMemberSS:
+		size := 0
+		for _, s := range v.Value {
+			size += len(s)
+		}
+		return size
+	case *types.AttributeValueMemberNS:
+		size := 0
+		for _, n := range v.Value {
+			size += numberSize(n)
+		}
+		return size
+	case *types.AttributeValueMemberBS:
+		size := 0
+		for _, b := range v.Value {
+			size += len(b)
+		}
+		return size
+	case *types.AttributeValueMemberL:
+		size := 3
+		for _, elem := range v.Value {
+			size += 1 + attributeSize(elem)
+		}
+		return size
+	case *types.AttributeValueMemberM:
+		size := 3
+		for name, elem := range v.Value {
+			size += 1 + len(name) + attributeSize(elem)
+		}
+		return size
+	}
+	return 0
+}
+
+// numberSize approximates a number's size: one byte per two significant digits plus one
+func numberSize(n string) int {
+	digits := 0
+	for _, c := range n {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return (digits+1)/2 + 1
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+)
+
+var layouts = []Layout{LayoutNested, LayoutFlattened}
+
+func TestLayout_RoundTrip(t *testing.T) {
+	for _, layout := range layouts {
+		item := benchOrderItems(1, "ORD")[0]
+		av, err := MarshalLayout(item, layout)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s item: %v", layout, err)
+		}
+
+		var got GenericItem[models.Order]
+		if err := UnmarshalLayout(av, layout, &got); err != nil {
+			t.Fatalf("Failed to unmarshal %s item: %v", layout, err)
+		}
+		if !got.Data.CreatedAt.Equal(item.Data.CreatedAt) {
+			t.Errorf("%s CreatedAt = %v, want %v", layout, got.Data.CreatedAt, item.Data.CreatedAt)
+		}
+		got.Data.CreatedAt = item.Data.CreatedAt
+		if !reflect.DeepEqual(got, item) {
+			t.Errorf("%s round trip = %+v, want %+v", layout, got, item)
+		}
+	}
+}
+
+func TestItemSize(t *testing.T) {
+	av := map[string]types.AttributeValue{
+		"PK":    &types.AttributeValueMemberS{Value: "USER#a"}, // 2 + 6
+		"total": &types.AttributeValueMemberN{Value: "12.5"},   // 5 + 3
+		"data": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{ // 4 + 3 + (1 + 1 + 1)
+			"x": &types.AttributeValueMemberBOOL{Value: true},
+		}},
+	}
+	if got, want := ItemSize(av), 8+8+10; got != want {
+		t.Errorf("ItemSize() = %d, want %d", got, want)
+	}
+}
+
+func BenchmarkLayout_Marshal(b *testing.B) {
+	item := benchOrderItems(1, "ORD")[0]
+	for _, layout := range layouts {
+		b.Run(string(layout), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := MarshalLayout(item, layout); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLayout_Unmarshal(b *testing.B) {
+	item := benchOrderItems(1, "ORD")[0]
+	for _, layout := range layouts {
+		av, err := MarshalLayout(item, layout)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(string(layout), func(b *testing.B) {
+			b.ReportMetric(float64(ItemSize(av)), "item-bytes")
+			for i := 0; i < b.N; i++ {
+				var out GenericItem[models.Order]
+				if err := UnmarshalLayout(av, layout, &out); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLayout_FilteredQuery measures a status-filtered page read of a
+// user's orders for each layout against DynamoDB Local
+func BenchmarkLayout_FilteredQuery(b *testing.B) {
+	store, cleanup := benchStore(b)
+	defer cleanup()
+
+	statusPath := map[Layout]string{
+		LayoutNested:    "#data.#status",
+		LayoutFlattened: "#status",
+	}
+	for _, layout := range layouts {
+		pk := Key.UserPK(fmt.Sprintf("%s@example.com", layout))
+		for _, item := range benchOrderItems(*benchItems, "ORD") {
+			item.PK = pk
+			av, err := MarshalLayout(item, layout)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := store.client.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String(store.tableName), Item: av}); err != nil {
+				b.Fatalf("Failed to seed item: %v", err)
+			}
+		}
+
+		names := map[string]string{"#status": "status"}
+		if layout == LayoutNested {
+			names["#data"] = dataAttribute
+		}
+		b.Run(string(layout), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := store.client.Query(context.Background(), &dynamodb.QueryInput{
+					TableName:                aws.String(store.tableName),
+					KeyConditionExpression:   aws.String("PK = :pk"),
+					FilterExpression:         aws.String(statusPath[layout] + " = :status"),
+					ExpressionAttributeNames: names,
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":pk":     &types.AttributeValueMemberS{Value: string(pk)},
+						":status": &types.AttributeValueMemberS{Value: string(models.OrderStatusPending)},
+					},
+				})
+				if err != nil {
+					b.Fatalf("Failed to query: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"LearnSingleTableDesign/models"
+)
+
+// MigrateLineItems gives every order stored before orders had line items
+// its Items, built from its list of product IDs, and drops the list. Names
+// come from catalog. An order of a single product is priced from its total,
+// which is exactly what was paid; orders of several products can only take
+// the products' current prices. Products since deleted are named by their
+// ID and priced at zero. Each order is rewritten on condition it still has
+// the old list, so the migration can be stopped and run again.
+func (r *OrderRepository) MigrateLineItems(ctx context.Context, catalog *ProductRepository, dryRun bool) (scanned, migrated int, err error) {
+	filter := And(AttributeEquals("entity_type", EntityOrder), AttributeExists("data.products")).must()
+	paginator := dynamodb.NewScanPaginator(r.store.client, &dynamodb.ScanInput{
+		TableName:                 aws.String(r.store.tableName),
+		FilterExpression:          aws.String(filter.expr),
+		ExpressionAttributeNames:  filter.names,
+		ExpressionAttributeValues: filter.values,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return scanned, migrated, fmt.Errorf("failed to scan orders: %w", err)
+		}
+
+		orders := make([]models.Order, 0, len(page.Items))
+		var productIDs []string
+		for _, raw := range page.Items {
+			item, err := Decode[models.Order](raw)
+			if err != nil {
+				return scanned, migrated, err
+			}
+			orders = append(orders, item.Data)
+			productIDs = append(productIDs, item.Data.LegacyProducts...)
+		}
+		scanned += len(orders)
+		products, err := catalog.GetMany(ctx, productIDs)
+		if err != nil {
+			return scanned, migrated, err
+		}
+
+		for _, order := range orders {
+			order.Items = legacyLineItems(order, products)
+			order.LegacyProducts = nil
+			if dryRun {
+				migrated++
+				continue
+			}
+			err := PutItem(ctx, r.store, orderItem(order), AttributeExists("data.products"))
+			if errors.Is(err, ErrConditionFailed) {
+				continue
+			}
+			if err != nil {
+				return scanned, migrated, fmt.Errorf("failed to migrate order %s: %w", order.OrderID, err)
+			}
+			migrated++
+		}
+	}
+	return scanned, migrated, nil
+}
+
+// legacyLineItems turns an order's product IDs into line items, one per
+// distinct product in the order they were listed
+func legacyLineItems(order models.Order, catalog map[string]models.Product) []models.LineItem {
+	var items []models.LineItem
+	index := make(map[string]int)
+	for _, id := range order.LegacyProducts {
+		if i, ok := index[id]; ok {
+			items[i].Quantity++
+			continue
+		}
+		index[id] = len(items)
+		item := models.LineItem{ProductID: id, Name: id, Quantity: 1}
+		if product, ok := catalog[id]; ok {
+			item.Name = product.Name
+			item.UnitPrice = product.Price
+		}
+		items = append(items, item)
+	}
+	if len(items) == 1 {
+		items[0].UnitPrice = order.Total / float64(items[0].Quantity)
+	}
+	return items
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"LearnSingleTableDesign/models"
+)
+
+// NotificationPrefsRepository stores which emails each user wants, as one
+// item in their collection
+type NotificationPrefsRepository struct {
+	store *Store
+}
+
+// NewNotificationPrefsRepository creates a new NotificationPrefsRepository
+func NewNotificationPrefsRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *NotificationPrefsRepository {
+	return &NotificationPrefsRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Get returns the user's prefs. A user who never saved any gets empty
+// prefs, which email about everything.
+func (r *NotificationPrefsRepository) Get(ctx context.Context, email string) (*models.NotificationPrefs, error) {
+	email = models.NormalizeEmail(email)
+	var item GenericItem[models.NotificationPrefs]
+	err := GetItem(ctx, r.store, Key.UserPK(email), Key.NotificationPrefsSK(), &item)
+	if errors.Is(err, ErrNotFound) {
+		return &models.NotificationPrefs{UserEmail: email}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// Put stores the user's prefs, replacing what they had
+func (r *NotificationPrefsRepository) Put(ctx context.Context, prefs models.NotificationPrefs) error {
+	prefs.UserEmail = models.NormalizeEmail(prefs.UserEmail)
+	prefs.UpdatedAt = time.Now()
+	if err := prefs.Validate(); err != nil {
+		return err
+	}
+	return PutItem(ctx, r.store, GenericItem[models.NotificationPrefs]{
+		PK:         Key.UserPK(prefs.UserEmail),
+		SK:         Key.NotificationPrefsSK(),
+		EntityType: EntityNotificationPrefs,
+		Data:       prefs,
+	})
+}
@@ -2,21 +2,31 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
 	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/schema"
 )
 
 // OrderRepository handles Order entity operations
 type OrderRepository struct {
 	store *Store
+	// totalAlerts are told about orders whose total doesn't match their
+	// line items
+	totalAlerts []TotalMismatchAlert
+	// checkTotalsOnRead checks the totals of every order read, in dev mode
+	checkTotalsOnRead bool
 }
 
 // NewOrderRepository creates a new OrderRepository
-func NewOrderRepository(client *dynamodb.Client, tableName string) *OrderRepository {
+func NewOrderRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *OrderRepository {
 	return &OrderRepository{
-		store: NewStore(client, tableName),
+		store: NewStore(client, tableName, opts...),
 	}
 }
 
@@ -27,36 +37,243 @@ type OrdersPage struct {
 	// NextPageToken is the token for getting the next page
 	// If nil, there are no more pages
 	NextPageToken *PageToken
+	PageInfo
 }
 
-// Put stores an order in DynamoDB
+// Put stores an order in DynamoDB. Its total is worked out from its line
+// items, whatever the caller set; only orders from before line items keep
+// theirs. A new order is counted in the user's stats in the same
+// transaction; saving an existing order again leaves the stats alone. New
+// orders should go through OrderService.Place, which also prices the items
+// and takes the stock.
 func (r *OrderRepository) Put(ctx context.Context, order models.Order) error {
+	order = withItemsTotal(order)
 	if err := order.Validate(); err != nil {
 		return err
 	}
-	item := GenericItem[models.Order]{
+	stats, err := userStatsUpdate(order)
+	if err != nil {
+		return err
+	}
+	return putNewItemWithUpdate(ctx, r.store, orderItem(order), stats)
+}
+
+// PutMany stores orders in batches. Invalid orders and items DynamoDB
+// didn't process are reported in the result instead of failing the rest.
+// Batch writes can't be transactional, so it doesn't update user stats;
+// it's meant for bulk loads.
+func (r *OrderRepository) PutMany(ctx context.Context, orders []models.Order) (*BatchResult, error) {
+	items := make([]GenericItem[models.Order], 0, len(orders))
+	var invalid []BatchFailure
+	for _, order := range orders {
+		order = withItemsTotal(order)
+		item := orderItem(order)
+		if err := order.Validate(); err != nil {
+			invalid = append(invalid, BatchFailure{Key: ItemKey{PK: item.PK, SK: item.SK}, Reason: err.Error(), Err: err})
+			continue
+		}
+		items = append(items, item)
+	}
+
+	result, err := BatchPutItems(ctx, r.store, items)
+	if result != nil {
+		result.Failed = append(invalid, result.Failed...)
+	}
+	return result, err
+}
+
+// withItemsTotal normalizes an order's email and sets its total from its
+// line items
+func withItemsTotal(order models.Order) models.Order {
+	order.UserEmail = models.NormalizeEmail(order.UserEmail)
+	if len(order.Items) > 0 {
+		order.Total = order.ItemsTotal()
+	}
+	return order
+}
+
+// ErrUnknownReference means an order names a user or product that doesn't
+// exist
+var ErrUnknownReference = errors.New("unknown reference")
+
+// CheckReferences checks that an order's user and every product it lists
+// exist, reading only their keys, for callers writing new orders with Put.
+// OrderService.Place makes the same checks itself.
+func (r *OrderRepository) CheckReferences(ctx context.Context, order models.Order) error {
+	ok, err := r.store.Exists(ctx, Key.UserPK(order.UserEmail), Key.UserSK(order.UserEmail))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: user %s", ErrUnknownReference, order.UserEmail)
+	}
+	checked := make(map[string]bool)
+	for _, productID := range order.ProductIDs() {
+		if checked[productID] {
+			continue
+		}
+		checked[productID] = true
+		ok, err := r.store.Exists(ctx, Key.ProductPK(), Key.ProductSK(productID))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: product %s", ErrUnknownReference, productID)
+		}
+	}
+	return nil
+}
+
+// orderItem wraps an order in its table item
+func orderItem(order models.Order) GenericItem[models.Order] {
+	return GenericItem[models.Order]{
 		PK:         Key.UserPK(order.UserEmail),
 		SK:         Key.OrderSK(order.OrderID),
 		EntityType: EntityOrder,
 		Data:       order,
+		SK2:        lsiMillis(order.CreatedAt),
+		GSI1PK:     Key.OrderDatePK(order.CreatedAt),
+		GSI1SK:     Key.OrderDateSK(order.CreatedAt, order.OrderID),
+		GSI2PK:     Key.OrderStatusPK(order.UserEmail, order.Status),
+		GSI2SK:     Key.OrderDateSK(order.CreatedAt, order.OrderID),
+	}
+}
+
+// recentOrderDays is how many days of orders Recent looks back through
+const recentOrderDays = 7
+
+// Recent returns up to limit of the newest orders across all users, from
+// the last week. It reads the per-day order partitions in GSI1, newest day
+// first, so it never touches more days than it needs.
+func (r *OrderRepository) Recent(ctx context.Context, limit int) ([]models.Order, error) {
+	var orders []models.Order
+	day := time.Now()
+	for i := 0; i < recentOrderDays && len(orders) < limit; i++ {
+		result, err := QueryByGSI[models.Order](ctx, r.store, Key.OrderDatePK(day), string(PrefixCreated), &QueryOptions{
+			Limit:      int32(limit - len(orders)),
+			Descending: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			orders = append(orders, item.Data)
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return orders, nil
+}
+
+// ErrInvalidTransition means an order can't move to the requested status
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// Get retrieves a single order
+func (r *OrderRepository) Get(ctx context.Context, userEmail, orderID string) (*models.Order, error) {
+	var item GenericItem[models.Order]
+	err := GetItem(ctx, r.store, Key.UserPK(userEmail), Key.OrderSK(orderID), &item)
+	if err != nil {
+		return nil, err
+	}
+	r.checkRead(ctx, item.Data)
+	return &item.Data, nil
+}
+
+// Transition moves an order from one status to another and logs the change
+// in the same transaction. The write is conditional on the order still
+// being in from, so concurrent consumers can't both apply the same
+// transition; the loser gets ErrConditionFailed. Moves the status machine
+// doesn't allow return ErrInvalidTransition.
+func (r *OrderRepository) Transition(ctx context.Context, userEmail, orderID string, from, to models.OrderStatus) (*models.Order, error) {
+	if !from.CanTransitionTo(to) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+	order, err := r.Get(ctx, userEmail, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != from {
+		return nil, ErrConditionFailed
 	}
-	return PutItem(ctx, r.store, item)
+
+	order.Status = to
+	// Completed orders count towards the day's sales in the same
+	// transaction, so a sale is counted exactly once
+	var updates []*types.Update
+	if to == models.OrderStatusCompleted {
+		updates = append(updates, dailySalesUpdate(*order, time.Now()))
+	}
+	orderPut, err := conditionalPut(ctx, r.store, orderItem(*order), statusIs(from))
+	if err != nil {
+		return nil, err
+	}
+	logPut, err := orderLogPut(ctx, r.store, orderID, EventOrderStatusChanged, to, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := r.store.transactPutsWith(ctx, []*types.Put{orderPut, logPut}, updates); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// SetInvoiceKey records where an order's invoice is stored. It updates the
+// order in place, so put hooks don't see it as the order being saved again.
+func (r *OrderRepository) SetInvoiceKey(ctx context.Context, userEmail, orderID, key string) error {
+	return UpdateItem(ctx, r.store, Key.UserPK(userEmail), Key.OrderSK(orderID), map[string]any{"invoice_key": key})
+}
+
+// statusIs guards an order write on the stored order still being in status
+func statusIs(status models.OrderStatus) condition {
+	return AttributeEquals("data.status", status).must()
 }
 
 // GetUserOrders retrieves orders for a user from DynamoDB with pagination support
 func (r *OrderRepository) GetUserOrders(ctx context.Context, userEmail string, opts *QueryOptions) (*OrdersPage, error) {
-	result, err := Query[models.Order](ctx, r.store, Key.UserPK(userEmail), "ORDER#", opts)
+	page, err := QueryData[models.Order](ctx, r.store, Key.UserPK(userEmail), string(PrefixOrder), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r.checkRead(ctx, page.Items...)
+	return &OrdersPage{
+		Orders:        page.Items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
+	}, nil
+}
+
+// GetUserOrdersByCreatedAt retrieves a user's orders oldest first (or newest
+// first with opts.Descending) through LSI1, whose sort key is the creation time.
+// GetUserOrders sorts by order ID instead because it reads the base table.
+func (r *OrderRepository) GetUserOrdersByCreatedAt(ctx context.Context, userEmail string, opts *QueryOptions) (*OrdersPage, error) {
+	page, err := QueryDataByLSI[models.Order](ctx, r.store, Key.UserPK(userEmail), opts)
 	if err != nil {
 		return nil, err
 	}
 
-	orders := make([]models.Order, len(result.Items))
-	for i, item := range result.Items {
-		orders[i] = item.Data
+	r.checkRead(ctx, page.Items...)
+	return &OrdersPage{
+		Orders:        page.Items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
+	}, nil
+}
+
+// GetUserOrdersByStatus retrieves a user's orders in one status through
+// GSI2, oldest first (or newest first with opts.Descending). Every write of
+// an order moves it to its status's partition. GSI2 is eventually
+// consistent, so an order whose status just changed may briefly be listed
+// under its old one.
+func (r *OrderRepository) GetUserOrdersByStatus(ctx context.Context, userEmail string, status models.OrderStatus, opts *QueryOptions) (*OrdersPage, error) {
+	page, err := QueryDataByIndex[models.Order](ctx, r.store, schema.GSI2, Key.OrderStatusPK(userEmail, status), string(PrefixCreated), opts)
+	if err != nil {
+		return nil, err
 	}
 
+	r.checkRead(ctx, page.Items...)
 	return &OrdersPage{
-		Orders:        orders,
-		NextPageToken: result.NextPageToken,
+		Orders:        page.Items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
 	}, nil
 }
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+)
+
+// EventOrderStatusChanged is logged when Transition moves an order. It
+// only goes in the order's log, not the outbox.
+const EventOrderStatusChanged = "order.status_changed"
+
+// OrderActivity is what happened to an order after it was placed
+type OrderActivity struct {
+	// Payments are the order's charges and refunds
+	Payments []models.Payment
+	// Log is the order's history, oldest first. Orders written before the
+	// log existed have none.
+	Log []models.OrderLogEntry
+}
+
+// Activity returns an order's payments and log. Both live in the order's
+// own partition, so one query reads them.
+func (r *OrderRepository) Activity(ctx context.Context, orderID string) (*OrderActivity, error) {
+	var activity OrderActivity
+	opts := &QueryOptions{}
+	for {
+		page, err := QueryCollection(ctx, r.store, Key.PaymentPK(orderID), opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range page.Items {
+			switch raw.EntityType() {
+			case EntityPayment:
+				item, err := Decode[models.Payment](raw)
+				if err != nil {
+					return nil, err
+				}
+				activity.Payments = append(activity.Payments, item.Data)
+			case EntityOrderLog:
+				item, err := Decode[models.OrderLogEntry](raw)
+				if err != nil {
+					return nil, err
+				}
+				activity.Log = append(activity.Log, item.Data)
+			}
+		}
+		if page.NextPageToken == nil {
+			return &activity, nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
+
+// orderLogPut returns the put logging event against an order, for writing
+// in the same transaction as the change it records
+func orderLogPut(ctx context.Context, s *Store, orderID, event string, status models.OrderStatus, note string) (*types.Put, error) {
+	entry := models.OrderLogEntry{
+		EntryID: uuid.New().String(),
+		OrderID: orderID,
+		Event:   event,
+		Status:  status,
+		Note:    note,
+		At:      time.Now(),
+	}
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+	return conditionalPut(ctx, s, GenericItem[models.OrderLogEntry]{
+		PK:         Key.PaymentPK(orderID),
+		SK:         Key.OrderLogSK(entry.At, entry.EntryID),
+		EntityType: EntityOrderLog,
+		Data:       entry,
+	}, condition{expr: createOnly})
+}
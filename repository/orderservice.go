@@ -0,0 +1,517 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+)
+
+// Outbox event types written by OrderService
+const (
+	EventOrderPlaced    = "order.placed"
+	EventOrderCancelled = "order.cancelled"
+	EventOrderRefunded  = "order.refunded"
+)
+
+// ErrMixedCurrencies means an order listed products priced in different
+// currencies
+var ErrMixedCurrencies = errors.New("products are priced in different currencies")
+
+// ErrEmptyCart means a checkout found nothing in the cart
+var ErrEmptyCart = errors.New("the cart is empty")
+
+// ErrRefundTooLarge means a refund asked for more than is left of the
+// order's charges
+var ErrRefundTooLarge = errors.New("refund exceeds the refundable amount")
+
+// OrderEvent is the payload of order outbox events
+type OrderEvent struct {
+	OrderID   string `json:"order_id"`
+	UserEmail string `json:"user_email"`
+	Reason    string `json:"reason,omitempty"`
+	// RefundIDs are the pending refund payments written with the event
+	RefundIDs []string `json:"refund_ids,omitempty"`
+}
+
+// OrderService runs order operations that touch several entities. Each one
+// is a single transaction: either every item changes or none do, and an
+// outbox event describing the change is written with it.
+type OrderService struct {
+	store *Store
+}
+
+func NewOrderService(client *dynamodb.Client, tableName string, opts ...StoreOption) *OrderService {
+	return &OrderService{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Place places a pending order for one unit of each product ID listed,
+// repeating an ID to order more. The user and products must exist, and the
+// total is worked out from the products' current prices rather than taken
+// from the caller, in the currency the products are priced in; products in
+// different currencies can't be ordered together. In one transaction it
+// writes the order, takes the units out of stock, counts the order in the
+// user's stats and writes an order.placed event. The transaction is retried
+// if a product's stock changed under it. Orders with more different
+// products than a transaction can hold fail with ErrTransactionTooLarge.
+// ProductRepository's low stock alerts don't fire.
+func (s *OrderService) Place(ctx context.Context, userEmail string, productIDs []string) (*models.Order, error) {
+	if len(productIDs) == 0 {
+		return nil, fmt.Errorf("an order needs at least one product")
+	}
+	order, err := s.newOrder(ctx, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.place(ctx, order, productIDs, nil)
+		if errors.Is(err, ErrConditionFailed) && attempt < reserveAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return order, nil
+	}
+}
+
+// Checkout places a pending order for everything in a user's cart and
+// empties the cart, like Place otherwise. Units kept by the cart's active
+// holds are already out of stock, so those holds are consumed instead of
+// taking the stock again; units whose holds were released or expired are
+// taken from stock. The order, the holds, the stock and the emptied cart
+// are written in one transaction, which is retried if any of them changed
+// under it.
+func (s *OrderService) Checkout(ctx context.Context, userEmail string) (*models.Order, error) {
+	order, err := s.newOrder(ctx, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	owner := UserCart(order.UserEmail)
+	for attempt := 1; ; attempt++ {
+		var item GenericItem[models.Cart]
+		err := GetItem(ctx, s.store, owner.pk(), Key.CartSK(), &item)
+		if errors.Is(err, ErrNotFound) || err == nil && len(item.Data.Items) == 0 {
+			return nil, ErrEmptyCart
+		}
+		if err != nil {
+			return nil, err
+		}
+		cart := item.Data
+
+		var productIDs []string
+		var holds []models.InventoryHold
+		for _, cartItem := range cart.Items {
+			for range cartItem.Quantity {
+				productIDs = append(productIDs, cartItem.ProductID)
+			}
+			active, err := s.activeHolds(ctx, cartItem)
+			if err != nil {
+				return nil, err
+			}
+			holds = append(holds, active...)
+		}
+
+		version := cart.Version
+		cart.Items = nil
+		cart.Version++
+		cart.UpdatedAt = time.Now()
+		cartPut, err := conditionalPut(ctx, s.store, owner.item(cart), cartVersionIs(version))
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.place(ctx, order, productIDs, holds, cartPut)
+		if errors.Is(err, ErrConditionFailed) && attempt < reserveAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return order, nil
+	}
+}
+
+// newOrder starts a pending order for a user, who must exist
+func (s *OrderService) newOrder(ctx context.Context, userEmail string) (*models.Order, error) {
+	ok, err := s.store.Exists(ctx, Key.UserPK(userEmail), Key.UserSK(userEmail))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: user %s", ErrUnknownReference, userEmail)
+	}
+	return &models.Order{
+		OrderID:   uuid.New().String(),
+		UserEmail: models.NormalizeEmail(userEmail),
+		Status:    models.OrderStatusPending,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// activeHolds returns the holds of a cart item that still keep its units
+// out of stock
+func (s *OrderService) activeHolds(ctx context.Context, item models.CartItem) ([]models.InventoryHold, error) {
+	now := time.Now()
+	var holds []models.InventoryHold
+	for _, holdID := range item.HoldIDs {
+		var hold GenericItem[models.InventoryHold]
+		err := GetItem(ctx, s.store, Key.HoldPK(item.ProductID), Key.HoldSK(holdID), &hold)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hold.Data.Status == models.HoldStatusActive && !hold.Data.IsExpired(now) {
+			holds = append(holds, hold.Data)
+		}
+	}
+	return holds, nil
+}
+
+// place prices the order's line items from the current products and writes
+// it, with the stock it takes. The units of held are already out of stock,
+// so their holds are consumed instead. extra are written in the same
+// transaction.
+func (s *OrderService) place(ctx context.Context, order *models.Order, productIDs []string, held []models.InventoryHold, extra ...*types.Put) error {
+	var puts []*types.Put
+	heldUnits := make(map[string]int)
+	for _, hold := range held {
+		hold.Status = models.HoldStatusConsumed
+		hold.UpdatedAt = time.Now()
+		put, err := conditionalPut(ctx, s.store, holdItem(hold), holdIsActive())
+		if err != nil {
+			return err
+		}
+		puts = append(puts, put)
+		heldUnits[hold.ProductID] += hold.Quantity
+	}
+
+	quantities := make(map[string]int)
+	var ids []string
+	for _, id := range productIDs {
+		if quantities[id] == 0 {
+			ids = append(ids, id)
+		}
+		quantities[id]++
+	}
+	// Besides the hold puts, each product that isn't held takes a stock
+	// put, and the order, its event, its log entry and the user's stats one
+	// item each. Held products may need a stock put too, which the Store
+	// checks before sending.
+	writes := len(puts) + len(extra) + 4
+	for _, id := range ids {
+		if heldUnits[id] == 0 {
+			writes++
+		}
+	}
+	if size := s.store.transactionSize(writes, 0); size > maxTransactItems {
+		return fmt.Errorf("%w: the order has %d different products, which need %d transaction items, over DynamoDB's limit of %d",
+			ErrTransactionTooLarge, len(ids), size, maxTransactItems)
+	}
+
+	order.Items = order.Items[:0]
+	order.Currency = ""
+	for _, id := range ids {
+		var item GenericItem[models.Product]
+		err := GetItem(ctx, s.store, Key.ProductPK(), Key.ProductSK(id), &item)
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("%w: product %s", ErrUnknownReference, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		product := item.Data
+		take := max(quantities[id]-heldUnits[id], 0)
+		if product.Stock < take {
+			return fmt.Errorf("%w: %d left of %s", ErrInsufficientStock, product.Stock, id)
+		}
+		if order.Currency == "" {
+			order.Currency = product.PriceCurrency()
+		} else if order.Currency != product.PriceCurrency() {
+			return fmt.Errorf("%w: %s is in %s, not %s", ErrMixedCurrencies, id, product.PriceCurrency(), order.Currency)
+		}
+		line := models.LineItem{ProductID: id, Name: product.Name, UnitPrice: product.Price, Quantity: quantities[id]}
+		order.Items = append(order.Items, line)
+		if take == 0 {
+			continue
+		}
+		stock := product.Stock
+		product.Stock -= take
+		put, err := conditionalPut(ctx, s.store, productItem(product), stockIs(stock))
+		if err != nil {
+			return err
+		}
+		puts = append(puts, put)
+	}
+	order.Total = order.ItemsTotal()
+	if err := order.Validate(); err != nil {
+		return err
+	}
+
+	orderPut, err := conditionalPut(ctx, s.store, orderItem(*order), condition{expr: createOnly})
+	if err != nil {
+		return err
+	}
+	stats, err := userStatsUpdate(*order)
+	if err != nil {
+		return err
+	}
+	eventPut, err := s.eventPut(ctx, EventOrderPlaced, order.OrderID, OrderEvent{OrderID: order.OrderID, UserEmail: order.UserEmail})
+	if err != nil {
+		return err
+	}
+	logPut, err := orderLogPut(ctx, s.store, order.OrderID, EventOrderPlaced, order.Status, "")
+	if err != nil {
+		return err
+	}
+	puts = append([]*types.Put{orderPut}, append(append(puts, extra...), eventPut, logPut)...)
+	return s.store.transactPutsWith(ctx, puts, []*types.Update{stats})
+}
+
+// Cancel cancels a pending or processing order. In one transaction it puts
+// the order's products back in stock, marks it cancelled, records a pending
+// refund of whatever was charged and writes an order.cancelled event. The
+// transaction is retried if a product or payment changed under it.
+func (s *OrderService) Cancel(ctx context.Context, userEmail, orderID, reason string) (*models.Order, error) {
+	for attempt := 1; ; attempt++ {
+		order, err := s.cancel(ctx, userEmail, orderID, reason)
+		if errors.Is(err, ErrConditionFailed) && attempt < reserveAttempts {
+			continue
+		}
+		return order, err
+	}
+}
+
+func (s *OrderService) cancel(ctx context.Context, userEmail, orderID, reason string) (*models.Order, error) {
+	order, err := s.getOrder(ctx, userEmail, orderID)
+	if err != nil {
+		return nil, err
+	}
+	from := order.Status
+	if !from.CanTransitionTo(models.OrderStatusCancelled) {
+		return nil, fmt.Errorf("%w: %s orders can't be cancelled", ErrInvalidTransition, from)
+	}
+	order.Status = models.OrderStatusCancelled
+
+	orderPut, err := conditionalPut(ctx, s.store, orderItem(*order), statusIs(from))
+	if err != nil {
+		return nil, err
+	}
+	puts := []*types.Put{orderPut}
+
+	stockPuts, err := s.restock(ctx, order.ProductIDs())
+	if err != nil {
+		return nil, err
+	}
+	puts = append(puts, stockPuts...)
+
+	charges, payments, err := s.charges(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	event := OrderEvent{OrderID: orderID, UserEmail: userEmail, Reason: reason}
+	for _, charge := range charges {
+		amount := models.RefundableAmount(charge, payments)
+		if amount <= 0 {
+			continue
+		}
+		refundPuts, refund, err := s.refund(ctx, charge, amount)
+		if err != nil {
+			return nil, err
+		}
+		puts = append(puts, refundPuts...)
+		event.RefundIDs = append(event.RefundIDs, refund.PaymentID)
+	}
+
+	eventPut, err := s.eventPut(ctx, EventOrderCancelled, orderID, event)
+	if err != nil {
+		return nil, err
+	}
+	logPut, err := orderLogPut(ctx, s.store, orderID, EventOrderCancelled, order.Status, reason)
+	if err != nil {
+		return nil, err
+	}
+	puts = append(puts, eventPut, logPut)
+
+	if err := s.store.transactPuts(ctx, puts...); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// Refund records a pending refund of amount against the order's charges
+// and writes an order.refunded event, without changing the order itself.
+// It is meant for returns after an order has shipped; cancelling refunds
+// the whole order on its own.
+func (s *OrderService) Refund(ctx context.Context, userEmail, orderID string, amount float64, reason string) (*models.Payment, error) {
+	for attempt := 1; ; attempt++ {
+		refund, err := s.refundOrder(ctx, userEmail, orderID, amount, reason)
+		if errors.Is(err, ErrConditionFailed) && attempt < reserveAttempts {
+			continue
+		}
+		return refund, err
+	}
+}
+
+func (s *OrderService) refundOrder(ctx context.Context, userEmail, orderID string, amount float64, reason string) (*models.Payment, error) {
+	order, err := s.getOrder(ctx, userEmail, orderID)
+	if err != nil {
+		return nil, err
+	}
+	charges, payments, err := s.charges(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refund from the first charge with enough left on it
+	for _, charge := range charges {
+		if models.RefundableAmount(charge, payments) < amount {
+			continue
+		}
+		puts, refund, err := s.refund(ctx, charge, amount)
+		if err != nil {
+			return nil, err
+		}
+		eventPut, err := s.eventPut(ctx, EventOrderRefunded, orderID, OrderEvent{
+			OrderID:   orderID,
+			UserEmail: userEmail,
+			Reason:    reason,
+			RefundIDs: []string{refund.PaymentID},
+		})
+		if err != nil {
+			return nil, err
+		}
+		note := "Refund of " + money.Format(amount, order.PriceCurrency())
+		if reason != "" {
+			note += ": " + reason
+		}
+		logPut, err := orderLogPut(ctx, s.store, orderID, EventOrderRefunded, order.Status, note)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.store.transactPuts(ctx, append(puts, eventPut, logPut)...); err != nil {
+			return nil, err
+		}
+		return refund, nil
+	}
+	return nil, fmt.Errorf("%w: %.2f on order %s", ErrRefundTooLarge, amount, orderID)
+}
+
+func (s *OrderService) getOrder(ctx context.Context, userEmail, orderID string) (*models.Order, error) {
+	var item GenericItem[models.Order]
+	if err := GetItem(ctx, s.store, Key.UserPK(userEmail), Key.OrderSK(orderID), &item); err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// restock returns the puts that give each ordered unit back to its product.
+// Products that have since been deleted are skipped.
+func (s *OrderService) restock(ctx context.Context, productIDs []string) ([]*types.Put, error) {
+	quantities := make(map[string]int)
+	var ids []string
+	for _, id := range productIDs {
+		if quantities[id] == 0 {
+			ids = append(ids, id)
+		}
+		quantities[id]++
+	}
+
+	var puts []*types.Put
+	for _, id := range ids {
+		var item GenericItem[models.Product]
+		err := GetItem(ctx, s.store, Key.ProductPK(), Key.ProductSK(id), &item)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		product := item.Data
+		stock := product.Stock
+		product.Stock += quantities[id]
+		put, err := conditionalPut(ctx, s.store, productItem(product), stockIs(stock))
+		if err != nil {
+			return nil, err
+		}
+		puts = append(puts, put)
+	}
+	return puts, nil
+}
+
+// charges returns the order's successful charges and all of its payments
+func (s *OrderService) charges(ctx context.Context, orderID string) ([]models.Payment, []models.Payment, error) {
+	result, err := Query[models.Payment](ctx, s.store, Key.PaymentPK(orderID), string(PrefixPayment), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var charges []models.Payment
+	payments := make([]models.Payment, len(result.Items))
+	for i, item := range result.Items {
+		payments[i] = item.Data
+		if item.Data.Kind == models.PaymentKindCharge && item.Data.Status == models.PaymentStatusSucceeded {
+			charges = append(charges, item.Data)
+		}
+	}
+	return charges, payments, nil
+}
+
+// refund returns the puts recording a pending refund of a charge. The charge
+// is rewritten with a new UpdatedAt, conditional on the old one, so two
+// refunds racing against the same charge can't both pass the refundable
+// check.
+func (s *OrderService) refund(ctx context.Context, charge models.Payment, amount float64) ([]*types.Put, *models.Payment, error) {
+	now := time.Now()
+	refund := models.Payment{
+		PaymentID: uuid.New().String(),
+		OrderID:   charge.OrderID,
+		Kind:      models.PaymentKindRefund,
+		Amount:    amount,
+		Provider:  charge.Provider,
+		Status:    models.PaymentStatusPending,
+		RefundOf:  charge.PaymentID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := refund.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	unchanged, err := AttributeEquals("data.updated_at", charge.UpdatedAt).build()
+	if err != nil {
+		return nil, nil, err
+	}
+	bumped := charge
+	bumped.UpdatedAt = now
+	chargePut, err := conditionalPut(ctx, s.store, paymentItem(bumped), unchanged)
+	if err != nil {
+		return nil, nil, err
+	}
+	refundPut, err := conditionalPut(ctx, s.store, paymentItem(refund), condition{expr: createOnly})
+	if err != nil {
+		return nil, nil, err
+	}
+	return []*types.Put{chargePut, refundPut}, &refund, nil
+}
+
+// eventPut returns the put writing an outbox event
+func (s *OrderService) eventPut(ctx context.Context, eventType, aggregateID string, payload any) (*types.Put, error) {
+	event, err := newOutboxEvent(eventType, aggregateID, payload)
+	if err != nil {
+		return nil, err
+	}
+	return conditionalPut(ctx, s.store, outboxItem(event), condition{expr: createOnly})
+}
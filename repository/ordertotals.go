@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"LearnSingleTableDesign/models"
+)
+
+// TotalMismatch is an order whose stored total isn't what its line items
+// add up to
+type TotalMismatch struct {
+	UserEmail string
+	OrderID   string
+	Currency  string
+	// Stored is the order's total as saved
+	Stored float64
+	// Computed is what its line items add up to
+	Computed float64
+}
+
+// TotalMismatchAlert is told about an order whose total doesn't match its
+// line items. It runs on the caller's goroutine.
+type TotalMismatchAlert func(ctx context.Context, mismatch TotalMismatch)
+
+// CheckTotal recomputes an order's total from its line items, returning
+// the mismatch if the stored total differs. Orders have no discounts or
+// tax, so the total is just the items' subtotals. Orders from before line
+// items have nothing to check against and always pass.
+func CheckTotal(order models.Order) (TotalMismatch, bool) {
+	if len(order.Items) == 0 {
+		return TotalMismatch{}, false
+	}
+	computed := order.ItemsTotal()
+	if computed == order.Total {
+		return TotalMismatch{}, false
+	}
+	return TotalMismatch{
+		UserEmail: order.UserEmail,
+		OrderID:   order.OrderID,
+		Currency:  order.PriceCurrency(),
+		Stored:    order.Total,
+		Computed:  computed,
+	}, true
+}
+
+// OnTotalMismatch registers an alert for orders the total checks find
+// don't add up
+func (r *OrderRepository) OnTotalMismatch(alert TotalMismatchAlert) {
+	r.totalAlerts = append(r.totalAlerts, alert)
+}
+
+// CheckTotalsOnRead makes every order read also have its total checked,
+// for dev mode, where the cost of checking doesn't matter
+func (r *OrderRepository) CheckTotalsOnRead() {
+	r.checkTotalsOnRead = true
+}
+
+// checkRead checks the totals of orders just read when CheckTotalsOnRead
+// is on
+func (r *OrderRepository) checkRead(ctx context.Context, orders ...models.Order) {
+	if r.checkTotalsOnRead {
+		r.checkTotals(ctx, orders)
+	}
+}
+
+// checkTotals checks each order's total, alerting about and returning the
+// ones that don't match
+func (r *OrderRepository) checkTotals(ctx context.Context, orders []models.Order) []TotalMismatch {
+	var mismatches []TotalMismatch
+	for _, order := range orders {
+		mismatch, ok := CheckTotal(order)
+		if !ok {
+			continue
+		}
+		for _, alert := range r.totalAlerts {
+			alert(ctx, mismatch)
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches
+}
+
+// CheckUserTotals checks the totals of all of a user's orders, on demand
+func (r *OrderRepository) CheckUserTotals(ctx context.Context, userEmail string) ([]TotalMismatch, error) {
+	var mismatches []TotalMismatch
+	opts := &QueryOptions{}
+	for {
+		page, err := QueryData[models.Order](ctx, r.store, Key.UserPK(userEmail), string(PrefixOrder), opts)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, r.checkTotals(ctx, page.Items)...)
+		if page.NextPageToken == nil {
+			return mismatches, nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
+
+// CheckDayTotals checks the totals of every order placed on day, reading
+// the day's partition in GSI1. Reconciliation runs it on recent days.
+func (r *OrderRepository) CheckDayTotals(ctx context.Context, day time.Time) ([]TotalMismatch, error) {
+	var mismatches []TotalMismatch
+	opts := &QueryOptions{}
+	for {
+		result, err := QueryByGSI[models.Order](ctx, r.store, Key.OrderDatePK(day), string(PrefixCreated), opts)
+		if err != nil {
+			return nil, err
+		}
+		orders := make([]models.Order, len(result.Items))
+		for i, item := range result.Items {
+			orders[i] = item.Data
+		}
+		mismatches = append(mismatches, r.checkTotals(ctx, orders)...)
+		if result.NextPageToken == nil {
+			return mismatches, nil
+		}
+		opts.PageToken = result.NextPageToken
+	}
+}
+
+// RepairTotal sets an order's total to what its line items add up to,
+// returning the repaired order. The write is conditional on the total not
+// having changed since it was read; an order that already adds up is left
+// alone.
+func (r *OrderRepository) RepairTotal(ctx context.Context, userEmail, orderID string) (*models.Order, error) {
+	var item GenericItem[models.Order]
+	if err := GetItem(ctx, r.store, Key.UserPK(userEmail), Key.OrderSK(orderID), &item); err != nil {
+		return nil, err
+	}
+	order := item.Data
+	mismatch, ok := CheckTotal(order)
+	if !ok {
+		return &order, nil
+	}
+	err := UpdateItem(ctx, r.store, Key.UserPK(userEmail), Key.OrderSK(orderID),
+		map[string]any{"total": mismatch.Computed},
+		AttributeEquals("data.total", mismatch.Stored),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to repair order total: %w", err)
+	}
+	order.Total = mismatch.Computed
+	return &order, nil
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+)
+
+// OutboxRepository reads the events services write alongside their changes.
+// Writing an event in the same transaction as the change means it is
+// recorded exactly when the change happens; a relay then publishes pending
+// events and marks them published.
+type OutboxRepository struct {
+	store *Store
+}
+
+func NewOutboxRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *OutboxRepository {
+	return &OutboxRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Pending returns unpublished events, oldest first
+func (r *OutboxRepository) Pending(ctx context.Context, opts *QueryOptions) ([]models.OutboxEvent, *PageToken, error) {
+	result, err := QueryByGSI[models.OutboxEvent](ctx, r.store, Key.OutboxPendingPK(), string(PrefixCreated), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make([]models.OutboxEvent, len(result.Items))
+	for i, item := range result.Items {
+		events[i] = item.Data
+	}
+	return events, result.NextPageToken, nil
+}
+
+// MarkPublished takes an event out of the pending index
+func (r *OutboxRepository) MarkPublished(ctx context.Context, event models.OutboxEvent) error {
+	now := time.Now()
+	event.PublishedAt = &now
+	return PutItem(ctx, r.store, outboxItem(event))
+}
+
+// newOutboxEvent builds an event with a JSON payload
+func newOutboxEvent(eventType, aggregateID string, payload any) (models.OutboxEvent, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return models.OutboxEvent{}, fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+	}
+	return models.OutboxEvent{
+		EventID:     uuid.New().String(),
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     string(body),
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// outboxItem wraps an event in its table item. Unpublished events are
+// indexed in GSI1 for the relay.
+func outboxItem(event models.OutboxEvent) GenericItem[models.OutboxEvent] {
+	item := GenericItem[models.OutboxEvent]{
+		PK:         Key.OutboxPK(event.EventID),
+		SK:         Key.OutboxSK(event.EventID),
+		EntityType: EntityOutboxEvent,
+		Data:       event,
+	}
+	if event.PublishedAt == nil {
+		item.GSI1PK = Key.OutboxPendingPK()
+		item.GSI1SK = Key.OutboxPendingSK(event.CreatedAt, event.EventID)
+	}
+	return item
+}
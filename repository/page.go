@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"LearnSingleTableDesign/models"
+)
+
+// PageRepository handles CMS Page entity operations
+type PageRepository struct {
+	store *Store
+}
+
+// NewPageRepository creates a new PageRepository
+func NewPageRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *PageRepository {
+	return &PageRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Put stores a page in DynamoDB
+func (r *PageRepository) Put(ctx context.Context, page models.Page) error {
+	if err := page.Validate(); err != nil {
+		return err
+	}
+	item := GenericItem[models.Page]{
+		PK:         Key.PagePK(),
+		SK:         Key.PageSK(page.Slug),
+		EntityType: EntityPage,
+		Data:       page,
+	}
+	return PutItem(ctx, r.store, item)
+}
+
+// Get retrieves a page by its slug
+func (r *PageRepository) Get(ctx context.Context, slug string) (*models.Page, error) {
+	var item GenericItem[models.Page]
+	err := GetItem(ctx, r.store, Key.PagePK(), Key.PageSK(slug), &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// All retrieves every page, drafts included.
+// There are only ever a handful of pages, so all result pages are read.
+func (r *PageRepository) All(ctx context.Context) ([]models.Page, error) {
+	var pages []models.Page
+	opts := &QueryOptions{}
+	for {
+		result, err := Query[models.Page](ctx, r.store, Key.PagePK(), string(PrefixPage), opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			pages = append(pages, item.Data)
+		}
+		if result.NextPageToken == nil {
+			return pages, nil
+		}
+		opts.PageToken = result.NextPageToken
+	}
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalidPageToken is returned when a page token can't be decoded
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// PageToken represents an opaque token for pagination.
+// It carries the query's whole LastEvaluatedKey, which includes the index
+// key attributes when querying an LSI or GSI, so any query resumes correctly.
+type PageToken struct {
+	key map[string]types.AttributeValue
+}
+
+// NewPageToken wraps a raw LastEvaluatedKey
+func NewPageToken(lastEvaluatedKey map[string]types.AttributeValue) *PageToken {
+	if len(lastEvaluatedKey) == 0 {
+		return nil
+	}
+	return &PageToken{key: lastEvaluatedKey}
+}
+
+// Raw returns the LastEvaluatedKey to pass as ExclusiveStartKey
+func (t *PageToken) Raw() map[string]types.AttributeValue {
+	if t == nil {
+		return nil
+	}
+	return t.key
+}
+
+// tokenAttribute is the JSON form of a key attribute.
+// Key attributes can only be strings, numbers or binary.
+type tokenAttribute struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+	B []byte  `json:"B,omitempty"`
+}
+
+// String encodes the token for use in URLs and forms
+func (t *PageToken) String() string {
+	if t == nil {
+		return ""
+	}
+	attrs := make(map[string]tokenAttribute, len(t.key))
+	for name, av := range t.key {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			attrs[name] = tokenAttribute{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			attrs[name] = tokenAttribute{N: &v.Value}
+		case *types.AttributeValueMemberB:
+			attrs[name] = tokenAttribute{B: v.Value}
+		}
+	}
+	// Marshalling a map of plain structs can't fail
+	data, _ := json.Marshal(attrs)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParsePageToken decodes a token produced by PageToken.String.
+// An empty string means the first page and returns nil.
+func ParsePageToken(s string) (*PageToken, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+	var attrs map[string]tokenAttribute
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	key := make(map[string]types.AttributeValue, len(attrs))
+	for name, attr := range attrs {
+		switch {
+		case attr.S != nil:
+			key[name] = &types.AttributeValueMemberS{Value: *attr.S}
+		case attr.N != nil:
+			key[name] = &types.AttributeValueMemberN{Value: *attr.N}
+		case attr.B != nil:
+			key[name] = &types.AttributeValueMemberB{Value: attr.B}
+		default:
+			return nil, fmt.Errorf("%w: attribute %q has no value", ErrInvalidPageToken, name)
+		}
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: no key attributes", ErrInvalidPageToken)
+	}
+	return &PageToken{key: key}, nil
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"LearnSingleTableDesign/models"
+)
+
+// PaymentRepository stores the charges and refunds of orders
+type PaymentRepository struct {
+	store *Store
+}
+
+func NewPaymentRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *PaymentRepository {
+	return &PaymentRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+func (r *PaymentRepository) Put(ctx context.Context, payment models.Payment) error {
+	if err := payment.Validate(); err != nil {
+		return err
+	}
+	return PutItem(ctx, r.store, paymentItem(payment))
+}
+
+func (r *PaymentRepository) Get(ctx context.Context, orderID, paymentID string) (*models.Payment, error) {
+	var item GenericItem[models.Payment]
+	err := GetItem(ctx, r.store, Key.PaymentPK(orderID), Key.PaymentSK(paymentID), &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item.Data, nil
+}
+
+// ForOrder returns every charge and refund of an order
+func (r *PaymentRepository) ForOrder(ctx context.Context, orderID string) ([]models.Payment, error) {
+	result, err := Query[models.Payment](ctx, r.store, Key.PaymentPK(orderID), string(PrefixPayment), nil)
+	if err != nil {
+		return nil, err
+	}
+	payments := make([]models.Payment, len(result.Items))
+	for i, item := range result.Items {
+		payments[i] = item.Data
+	}
+	return payments, nil
+}
+
+// paymentItem wraps a payment in its table item
+func paymentItem(payment models.Payment) GenericItem[models.Payment] {
+	return GenericItem[models.Payment]{
+		PK:         Key.PaymentPK(payment.OrderID),
+		SK:         Key.PaymentSK(payment.PaymentID),
+		EntityType: EntityPayment,
+		Data:       payment,
+	}
+}
@@ -3,21 +3,42 @@ package repository
 import (
 	"LearnSingleTableDesign/models"
 	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"strings"
+
+	"LearnSingleTableDesign/schema"
 )
 
 type ProductRepository struct {
 	store *Store
+	// lowStockAlerts are called when a reservation takes a product below
+	// its stock threshold
+	lowStockAlerts []LowStockAlert
 }
 
+// LowStockAlert is told about a product whose stock just fell below its
+// threshold. It runs after the write, on the caller's goroutine.
+type LowStockAlert func(ctx context.Context, product models.Product)
+
+// ErrInsufficientStock means a reservation asked for more than is in stock
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// reserveAttempts bounds how often ReserveStock retries after losing a race
+// with another write to the same product
+const reserveAttempts = 3
+
 type ProductsPage struct {
 	Products      []models.Product
 	NextPageToken *PageToken
+	PageInfo
 }
 
-func NewProductRepository(client *dynamodb.Client, tableName string) *ProductRepository {
+func NewProductRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *ProductRepository {
 	return &ProductRepository{
-		store: NewStore(client, tableName),
+		store: NewStore(client, tableName, opts...),
 	}
 }
 
@@ -25,13 +46,138 @@ func (r *ProductRepository) Put(ctx context.Context, product models.Product) err
 	if err := product.Validate(); err != nil {
 		return err
 	}
+	return PutItem(ctx, r.store, productItem(product))
+}
+
+// PutMany stores products in batches, e.g. for catalog imports. Invalid
+// products and items DynamoDB didn't process are reported in the result
+// instead of failing the rest. Unlike Put, it can't be conditional, so it
+// shouldn't race stock reservations.
+func (r *ProductRepository) PutMany(ctx context.Context, products []models.Product) (*BatchResult, error) {
+	items := make([]GenericItem[models.Product], 0, len(products))
+	var invalid []BatchFailure
+	for _, product := range products {
+		item := productItem(product)
+		if err := product.Validate(); err != nil {
+			invalid = append(invalid, BatchFailure{Key: ItemKey{PK: item.PK, SK: item.SK}, Reason: err.Error(), Err: err})
+			continue
+		}
+		items = append(items, item)
+	}
+
+	result, err := BatchPutItems(ctx, r.store, items)
+	if result != nil {
+		result.Failed = append(invalid, result.Failed...)
+	}
+	return result, err
+}
+
+// Exists reports whether a product is in the catalog, without reading it
+func (r *ProductRepository) Exists(ctx context.Context, productID string) (bool, error) {
+	return r.store.Exists(ctx, Key.ProductPK(), Key.ProductSK(productID))
+}
+
+// productItem wraps a product in its table item. Products below their
+// stock threshold and featured products are also written to sparse
+// indexes, so listing them only reads the products that qualify.
+func productItem(product models.Product) GenericItem[models.Product] {
 	item := GenericItem[models.Product]{
 		PK:         Key.ProductPK(),
 		SK:         Key.ProductSK(product.ProductID),
 		EntityType: EntityProduct,
 		Data:       product,
+		GSI1PK:     Key.ProductNamePK(),
+		GSI1SK:     Key.ProductNameSK(product.Name, product.ProductID),
 	}
-	return PutItem(ctx, r.store, item)
+	if product.IsLowStock() {
+		item.GSI2PK = Key.LowStockPK()
+		item.GSI2SK = Key.LowStockSK(product.Stock, product.ProductID)
+	}
+	if product.Featured {
+		item.GSI3PK = Key.FeaturedPK()
+		item.GSI3SK = Key.ProductNameSK(product.Name, product.ProductID)
+	}
+	return item
+}
+
+// OnLowStock registers an alert for products that ReserveStock takes below
+// their threshold
+func (r *ProductRepository) OnLowStock(alert LowStockAlert) {
+	r.lowStockAlerts = append(r.lowStockAlerts, alert)
+}
+
+// ReserveStock takes quantity units of a product out of stock, returning
+// the updated product. The write is conditional on the stock not having
+// changed since it was read, and is retried if another write got there
+// first. Crossing the product's low stock threshold fires the alerts.
+func (r *ProductRepository) ReserveStock(ctx context.Context, productID string, quantity int) (*models.Product, error) {
+	return r.adjustStock(ctx, productID, -quantity, nil)
+}
+
+// adjustStock adds delta to a product's stock with the same optimistic
+// write as ReserveStock. When with is set, the put it returns is written in
+// the same transaction as the product, so both happen or neither does.
+func (r *ProductRepository) adjustStock(ctx context.Context, productID string, delta int, with func() (*types.Put, error)) (*models.Product, error) {
+	for attempt := 1; ; attempt++ {
+		product, err := r.Get(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		if product.Stock+delta < 0 {
+			return nil, fmt.Errorf("%w: %d left of %s", ErrInsufficientStock, product.Stock, productID)
+		}
+
+		wasLow := product.IsLowStock()
+		stock := product.Stock
+		product.Stock += delta
+		cond := stockIs(stock)
+		if with == nil {
+			err = putItemIf(ctx, r.store, productItem(*product), cond)
+		} else {
+			err = r.putWith(ctx, *product, cond, with)
+		}
+		if errors.Is(err, ErrConditionFailed) && attempt < reserveAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !wasLow && product.IsLowStock() {
+			for _, alert := range r.lowStockAlerts {
+				alert(ctx, *product)
+			}
+		}
+		return product, nil
+	}
+}
+
+// stockIs guards a product write on the stored stock still being stock
+func stockIs(stock int) condition {
+	return AttributeEquals("data.stock", stock).must()
+}
+
+// putWith writes the product and the put from with in one transaction
+func (r *ProductRepository) putWith(ctx context.Context, product models.Product, cond condition, with func() (*types.Put, error)) error {
+	put, err := conditionalPut(ctx, r.store, productItem(product), cond)
+	if err != nil {
+		return err
+	}
+	other, err := with()
+	if err != nil {
+		return err
+	}
+	return r.store.transactPuts(ctx, put, other)
+}
+
+// SetImageURL sets a product's image URL with an update of that field
+// alone, returning ErrNotFound if the product doesn't exist
+func (r *ProductRepository) SetImageURL(ctx context.Context, productID, imageURL string) error {
+	err := UpdateItem(ctx, r.store, Key.ProductPK(), Key.ProductSK(productID), map[string]any{"image_url": imageURL})
+	if errors.Is(err, ErrConditionFailed) {
+		return ErrNotFound
+	}
+	return err
 }
 
 func (r *ProductRepository) Get(ctx context.Context, productID string) (*models.Product, error) {
@@ -43,19 +189,126 @@ func (r *ProductRepository) Get(ctx context.Context, productID string) (*models.
 	return &item.Data, nil
 }
 
+// GetMany retrieves products by ID with batched reads, so resolving the
+// products of many orders costs one request per 100 IDs instead of one per
+// product. Duplicate IDs are read once; missing products are left out.
+func (r *ProductRepository) GetMany(ctx context.Context, productIDs []string) (map[string]models.Product, error) {
+	keys := make([]ItemKey, 0, len(productIDs))
+	seen := make(map[string]bool, len(productIDs))
+	for _, id := range productIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		keys = append(keys, ItemKey{PK: Key.ProductPK(), SK: Key.ProductSK(id)})
+	}
+
+	items, result, err := BatchGetItems[models.Product](ctx, r.store, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, failure := range result.Failed {
+		if !errors.Is(failure.Err, ErrNotFound) {
+			return nil, result.Err()
+		}
+	}
+
+	products := make(map[string]models.Product, len(items))
+	for _, item := range items {
+		products[item.Data.ProductID] = item.Data
+	}
+	return products, nil
+}
+
 func (r *ProductRepository) All(ctx context.Context, opts *QueryOptions) (*ProductsPage, error) {
-	result, err := Query[models.Product](ctx, r.store, Key.ProductPK(), "PRODUCT#", opts)
+	page, err := QueryData[models.Product](ctx, r.store, Key.ProductPK(), string(PrefixProduct), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductsPage{
+		Products:      page.Items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
+	}, nil
+}
+
+// SearchByNamePrefix returns products whose name starts with prefix,
+// ignoring case, in name order
+func (r *ProductRepository) SearchByNamePrefix(ctx context.Context, prefix string, opts *QueryOptions) (*ProductsPage, error) {
+	page, err := QueryDataByIndex[models.Product](ctx, r.store, schema.GSI1, Key.ProductNamePK(), PrefixName.Of(strings.ToLower(prefix)), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductsPage{
+		Products:      page.Items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
+	}, nil
+}
+
+// LowStock returns the products below their stock threshold, lowest stock
+// first. Only those products are in the sparse GSI2 partition it reads, so
+// the cost doesn't grow with the size of the catalogue.
+func (r *ProductRepository) LowStock(ctx context.Context, opts *QueryOptions) (*ProductsPage, error) {
+	page, err := QueryDataByIndex[models.Product](ctx, r.store, schema.GSI2, Key.LowStockPK(), string(PrefixStock), opts)
 	if err != nil {
 		return nil, err
 	}
 
-	products := make([]models.Product, len(result.Items))
-	for i, item := range result.Items {
-		products[i] = item.Data
+	return &ProductsPage{
+		Products:      page.Items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
+	}, nil
+}
+
+// Featured returns the featured products in name order. Only featured
+// products are in the sparse GSI3 partition it reads.
+func (r *ProductRepository) Featured(ctx context.Context, opts *QueryOptions) (*ProductsPage, error) {
+	page, err := QueryDataByIndex[models.Product](ctx, r.store, schema.GSI3, Key.FeaturedPK(), string(PrefixName), opts)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ProductsPage{
-		Products:      products,
-		NextPageToken: result.NextPageToken,
+		Products:      page.Items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
 	}, nil
 }
+
+// PutContent stores a product's localized content
+func (r *ProductRepository) PutContent(ctx context.Context, content models.ProductContent) error {
+	if err := content.Validate(); err != nil {
+		return err
+	}
+	item := GenericItem[models.ProductContent]{
+		PK:         Key.ProductContentPK(content.ProductID),
+		SK:         Key.ProductContentSK(content.Locale),
+		EntityType: EntityProductContent,
+		Data:       content,
+	}
+	return PutItem(ctx, r.store, item)
+}
+
+// GetContent returns the product content for the first locale in the fallback
+// chain that has a translation, or ErrNotFound if none of them do
+func (r *ProductRepository) GetContent(ctx context.Context, productID string, locales []string) (*models.ProductContent, error) {
+	result, err := Query[models.ProductContent](ctx, r.store, Key.ProductContentPK(productID), string(PrefixContent), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byLocale := make(map[string]models.ProductContent, len(result.Items))
+	for _, item := range result.Items {
+		byLocale[strings.ToLower(item.Data.Locale)] = item.Data
+	}
+	for _, locale := range locales {
+		if content, ok := byLocale[strings.ToLower(locale)]; ok {
+			return &content, nil
+		}
+	}
+	return nil, ErrNotFound
+}
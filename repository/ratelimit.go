@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// writeUnitSize is how many bytes of an item one write capacity unit covers
+const writeUnitSize = 1024
+
+// WriteLimiter is a token bucket of write capacity units, refilled at a
+// fixed rate and holding at most one second's worth. Seeding, imports and
+// migrations share one so together they stay within a provisioned table's
+// budget and leave room for the app. It is safe for concurrent use.
+type WriteLimiter struct {
+	rate float64
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewWriteLimiter creates a limiter allowing unitsPerSecond write capacity
+// units a second. It returns nil, which never waits, when unitsPerSecond
+// isn't positive.
+func NewWriteLimiter(unitsPerSecond int64) *WriteLimiter {
+	if unitsPerSecond <= 0 {
+		return nil
+	}
+	return &WriteLimiter{
+		rate:   float64(unitsPerSecond),
+		now:    time.Now,
+		tokens: float64(unitsPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until units are available, or returns early if ctx is done
+func (l *WriteLimiter) Wait(ctx context.Context, units int) error {
+	if l == nil || units <= 0 {
+		return nil
+	}
+	wait := l.reserve(units)
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.refund(units)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes units from the bucket and returns how long until they are
+// paid for. A write larger than the bucket runs it into debt instead of
+// waiting forever.
+func (l *WriteLimiter) reserve(units int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.tokens -= float64(units)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// refund returns units a cancelled wait never used
+func (l *WriteLimiter) refund(units int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.tokens = min(l.rate, l.tokens+float64(units))
+}
+
+func (l *WriteLimiter) refill() {
+	now := l.now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// LimitWrites makes every write the Store sends wait for its write capacity
+// units from limiter. Share one limiter between stores to give them one
+// budget.
+func LimitWrites(limiter *WriteLimiter) StoreOption {
+	return func(s *Store) {
+		s.writeLimiter = limiter
+	}
+}
+
+// LimitClientWrites returns a copy of client whose writes wait for limiter,
+// for code that writes to the table without a Store
+func LimitClientWrites(client *dynamodb.Client, limiter *WriteLimiter) *dynamodb.Client {
+	if limiter == nil {
+		return client
+	}
+	return dynamodb.New(client.Options(), func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("WriteLimiter",
+				func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					if err := limiter.Wait(ctx, writeUnits(in.Parameters)); err != nil {
+						return middleware.InitializeOutput{}, middleware.Metadata{}, err
+					}
+					return next.HandleInitialize(ctx, in)
+				}), middleware.Before)
+		})
+	})
+}
+
+// writeUnits estimates the write capacity units a call consumes on the
+// table itself: one per started KB of each item put or update's values,
+// one per delete, and double inside a transaction. Index writes aren't counted. Reads cost nothing.
+func writeUnits(input any) int {
+	switch in := input.(type) {
+	case *dynamodb.PutItemInput:
+		return itemWriteUnits(in.Item)
+	case *dynamodb.UpdateItemInput:
+		return itemWriteUnits(in.ExpressionAttributeValues)
+	case *dynamodb.DeleteItemInput:
+		return 1
+	case *dynamodb.BatchWriteItemInput:
+		units := 0
+		for _, requests := range in.RequestItems {
+			for _, request := range requests {
+				if request.PutRequest != nil {
+					units += itemWriteUnits(request.PutRequest.Item)
+				} else {
+					units++
+				}
+			}
+		}
+		return units
+	case *dynamodb.TransactWriteItemsInput:
+		units := 0
+		for _, item := range in.TransactItems {
+			switch {
+			case item.Put != nil:
+				units += 2 * itemWriteUnits(item.Put.Item)
+			case item.Update != nil:
+				units += 2 * itemWriteUnits(item.Update.ExpressionAttributeValues)
+			default:
+				units += 2
+			}
+		}
+		return units
+	}
+	return 0
+}
+
+func itemWriteUnits(item map[string]types.AttributeValue) int {
+	return max(1, (ItemSize(item)+writeUnitSize-1)/writeUnitSize)
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrReadOnly means a write was refused because the store is read-only,
+// e.g. during a maintenance window
+var ErrReadOnly = errors.New("store is read-only for maintenance")
+
+// writeOperations are the DynamoDB operations a read-only store refuses
+var writeOperations = map[string]bool{
+	"PutItem":            true,
+	"UpdateItem":         true,
+	"DeleteItem":         true,
+	"BatchWriteItem":     true,
+	"TransactWriteItems": true,
+}
+
+// ReadOnlySwitch turns writes off and back on at runtime. Share one switch
+// between stores so a single toggle covers the whole app.
+type ReadOnlySwitch struct {
+	on atomic.Bool
+}
+
+// NewReadOnlySwitch creates a switch, read-only from the start if on
+func NewReadOnlySwitch(on bool) *ReadOnlySwitch {
+	s := &ReadOnlySwitch{}
+	s.on.Store(on)
+	return s
+}
+
+// Set turns read-only mode on or off
+func (s *ReadOnlySwitch) Set(on bool) {
+	s.on.Store(on)
+}
+
+// On reports whether writes are being refused
+func (s *ReadOnlySwitch) On() bool {
+	return s != nil && s.on.Load()
+}
+
+// WithReadOnlySwitch makes the Store refuse every write with ErrReadOnly
+// while sw is on. Reads carry on as normal. Writes are refused as they are
+// sent, so BeforeWrite change hooks and write hooks still see them.
+func WithReadOnlySwitch(sw *ReadOnlySwitch) StoreOption {
+	return func(s *Store) {
+		s.readOnly = sw
+	}
+}
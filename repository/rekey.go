@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+)
+
+// RekeyUserItem recomputes the keys of an item in a user's collection, or of
+// one of the user's coupon redemptions, with k. The user's email is read from
+// the item's data rather than its keys, so this works whichever IDHasher the
+// item was written with; items that don't store it, such as carts and stats,
+// use owner, the email in the collection's profile. An email stored before
+// emails were normalized is normalized in the data too, which moves
+// mixed-case collections to their lower-case keys. Compressed data is read
+// through the codec and stays compressed. It returns the item with its new
+// keys and whether it differs from the old one.
+func (k KeyFactory) RekeyUserItem(item map[string]types.AttributeValue, owner string) (map[string]types.AttributeValue, bool, error) {
+	pk, _ := item["PK"].(*types.AttributeValueMemberS)
+	sk, _ := item["SK"].(*types.AttributeValueMemberS)
+	if pk == nil || sk == nil {
+		return nil, false, fmt.Errorf("item has no keys")
+	}
+	redemption := PrefixCoupon.Has(pk.Value) && PrefixRedemption.Has(sk.Value)
+	if !PrefixUser.Has(pk.Value) && !redemption {
+		return nil, false, fmt.Errorf("item is not in a user collection")
+	}
+
+	data, err := itemData(item)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to rekey %s/%s: %w", pk.Value, sk.Value, err)
+	}
+	email, field := dataUserEmail(data)
+	if email == "" {
+		email = owner
+	}
+	if email == "" {
+		return nil, false, fmt.Errorf("failed to rekey %s/%s: item data has no email and its collection has no profile", pk.Value, sk.Value)
+	}
+	normalized := models.NormalizeEmail(email)
+
+	newPK, newSK := string(k.UserPK(email)), sk.Value
+	switch {
+	case redemption:
+		newPK, newSK = pk.Value, string(k.CouponRedemptionSK(email))
+	case PrefixProfile.Has(sk.Value):
+		newSK = string(k.UserSK(email))
+	}
+	// Orders also embed the user in their GSI2 status partition
+	gsi2PK, _ := item["GSI2PK"].(*types.AttributeValueMemberS)
+	newGSI2PK := ""
+	if gsi2PK != nil && PrefixOrderStatus.Has(gsi2PK.Value) {
+		status, _ := data["status"].(*types.AttributeValueMemberS)
+		if status == nil {
+			return nil, false, fmt.Errorf("failed to rekey %s/%s: order has no status", pk.Value, sk.Value)
+		}
+		newGSI2PK = string(k.OrderStatusPK(email, models.OrderStatus(status.Value)))
+	}
+	renormalize := field != "" && normalized != email
+	if newPK == pk.Value && newSK == sk.Value && !renormalize && (newGSI2PK == "" || newGSI2PK == gsi2PK.Value) {
+		return item, false, nil
+	}
+
+	rekeyed := maps.Clone(item)
+	rekeyed["PK"] = &types.AttributeValueMemberS{Value: newPK}
+	rekeyed["SK"] = &types.AttributeValueMemberS{Value: newSK}
+	if newGSI2PK != "" {
+		rekeyed["GSI2PK"] = &types.AttributeValueMemberS{Value: newGSI2PK}
+	}
+	if renormalize {
+		data = maps.Clone(data)
+		data[field] = &types.AttributeValueMemberS{Value: normalized}
+		if rekeyed, err = withItemData(rekeyed, data); err != nil {
+			return nil, false, fmt.Errorf("failed to rekey %s/%s: %w", pk.Value, sk.Value, err)
+		}
+	}
+	return rekeyed, true, nil
+}
+
+// RekeyAuditEntry moves an entry of the audit log of a user's collection to
+// the log of the collection's new key, to. The audited item's keys in the
+// entry are rewritten to match, the profile's included.
+func RekeyAuditEntry(item map[string]types.AttributeValue, to PrimaryKey) (map[string]types.AttributeValue, bool, error) {
+	pk, _ := item["PK"].(*types.AttributeValueMemberS)
+	sk, _ := item["SK"].(*types.AttributeValueMemberS)
+	if pk == nil || sk == nil || !PrefixAudit.Has(pk.Value) {
+		return nil, false, fmt.Errorf("item is not an audit entry")
+	}
+	data, err := itemData(item)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to rekey %s/%s: %w", pk.Value, sk.Value, err)
+	}
+	newPK := string(Key.AuditPK(to))
+	if newPK == pk.Value {
+		return item, false, nil
+	}
+
+	from := PrimaryKey(strings.TrimPrefix(pk.Value, string(PrefixAudit)))
+	if data == nil {
+		return nil, false, fmt.Errorf("failed to rekey %s/%s: entry has no data", pk.Value, sk.Value)
+	}
+	data = maps.Clone(data)
+	data["pk"] = &types.AttributeValueMemberS{Value: string(to)}
+	if auditedSK, ok := data["sk"].(*types.AttributeValueMemberS); ok && auditedSK.Value == profileSKOf(from) {
+		data["sk"] = &types.AttributeValueMemberS{Value: profileSKOf(to)}
+	}
+	rekeyed := maps.Clone(item)
+	rekeyed["PK"] = &types.AttributeValueMemberS{Value: newPK}
+	if rekeyed, err = withItemData(rekeyed, data); err != nil {
+		return nil, false, fmt.Errorf("failed to rekey %s/%s: %w", pk.Value, sk.Value, err)
+	}
+	return rekeyed, true, nil
+}
+
+// ItemUserEmail returns the user's email stored in an item's data, "" if it
+// has none
+func ItemUserEmail(item map[string]types.AttributeValue) (string, error) {
+	data, err := itemData(item)
+	if err != nil {
+		return "", err
+	}
+	email, _ := dataUserEmail(data)
+	return email, nil
+}
+
+// profileSKOf is the profile's sort key in the user collection pk, which
+// embeds the same user ID
+func profileSKOf(pk PrimaryKey) string {
+	id, ok := strings.CutPrefix(string(pk), string(PrefixUser))
+	if !ok {
+		return ""
+	}
+	return PrefixProfile.Of(id)
+}
+
+// dataUserEmail finds the owning user's email in an item's data, and the
+// field holding it: profiles store it as email, everything else that has
+// it as user_email
+func dataUserEmail(data map[string]types.AttributeValue) (string, string) {
+	for _, name := range []string{"email", "user_email"} {
+		if v, ok := data[name].(*types.AttributeValueMemberS); ok && v.Value != "" {
+			return v.Value, name
+		}
+	}
+	return "", ""
+}
+
+// itemData returns an item's data map, decompressed if it was stored
+// compressed, or nil if the item has no data
+func itemData(item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	inflated, err := inflate(item)
+	if err != nil {
+		return nil, err
+	}
+	av, ok := inflated[dataAttribute]
+	if !ok {
+		return nil, nil
+	}
+	data, ok := av.(*types.AttributeValueMemberM)
+	if !ok {
+		return nil, fmt.Errorf("item data is not a map")
+	}
+	return data.Value, nil
+}
+
+// withItemData returns item with its data replaced, compressed again if the
+// old data was compressed
+func withItemData(item, data map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	item = maps.Clone(item)
+	var av types.AttributeValue = &types.AttributeValueMemberM{Value: data}
+	if _, compressed := item[contentEncodingAttribute]; compressed {
+		b, err := compressAttribute(av)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress data: %w", err)
+		}
+		av = &types.AttributeValueMemberB{Value: b}
+	}
+	item[dataAttribute] = av
+	return item, nil
+}
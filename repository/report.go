@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+)
+
+// ReportRepository reads the pre-aggregated sales rollups
+type ReportRepository struct {
+	store *Store
+}
+
+func NewReportRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *ReportRepository {
+	return &ReportRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Counters of totals in money. Amounts in different currencies can't be
+// added up, so each currency gets its own counter, named by
+// currencyAttribute.
+const (
+	revenueAttribute       = "revenue"
+	lifetimeSpendAttribute = "lifetime_spend"
+)
+
+// currencyAttribute names the counter of a total in one currency, such as
+// revenue_USD
+func currencyAttribute(name, currency string) string {
+	return name + "_" + currency
+}
+
+// currencyTotals reads the name_<currency> counters of a stored item. A
+// plain name counter, written before totals were kept per currency, was
+// only ever summed from DefaultCurrency orders and counts as that.
+func currencyTotals(raw map[string]types.AttributeValue, name string) (map[string]float64, error) {
+	totals := make(map[string]float64)
+	for attribute, av := range raw {
+		currency, ok := strings.CutPrefix(attribute, name+"_")
+		if attribute == name {
+			currency, ok = models.DefaultCurrency, true
+		}
+		if !ok {
+			continue
+		}
+		var total float64
+		if err := unmarshal(av, &total); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", attribute, err)
+		}
+		totals[currency] += total
+	}
+	return totals, nil
+}
+
+// dailySalesItem is how DailySales is stored. Like user stats, the counters
+// sit beside data so ADD can create the item on the day's first sale, and
+// revenue is kept in a revenue_<currency> counter per currency.
+type dailySalesItem struct {
+	PK         PrimaryKey `dynamodbav:"PK"`
+	SK         SortKey    `dynamodbav:"SK"`
+	EntityType string     `dynamodbav:"entity_type"`
+	Data       struct {
+		Date string `dynamodbav:"date"`
+	} `dynamodbav:"data"`
+	OrderCount int `dynamodbav:"order_count"`
+}
+
+// dailySalesUpdate adds a completed order to the rollup for the day it completed
+func dailySalesUpdate(order models.Order, completedAt time.Time) *types.Update {
+	date := completedAt.UTC().Format(time.DateOnly)
+	return &types.Update{
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: string(Key.SalesPK(completedAt))},
+			"SK": &types.AttributeValueMemberS{Value: string(Key.SalesSK(completedAt))},
+		},
+		UpdateExpression: aws.String("ADD order_count :one, #revenue :total " +
+			"SET entity_type = :type, #data = if_not_exists(#data, :data)"),
+		ExpressionAttributeNames: map[string]string{
+			"#data":    "data",
+			"#revenue": currencyAttribute(revenueAttribute, order.PriceCurrency()),
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":   &types.AttributeValueMemberN{Value: "1"},
+			":total": &types.AttributeValueMemberN{Value: fmt.Sprint(order.Total)},
+			":type":  &types.AttributeValueMemberS{Value: EntityDailySales},
+			":data": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"date": &types.AttributeValueMemberS{Value: date},
+			}},
+		},
+	}
+}
+
+// GetSalesRange returns one entry per UTC day from from to to inclusive,
+// with zeros for days without sales. It runs one query per month spanned.
+func (r *ReportRepository) GetSalesRange(ctx context.Context, from, to time.Time) ([]models.DailySales, error) {
+	from = truncateDay(from)
+	to = truncateDay(to)
+	if to.Before(from) {
+		return nil, fmt.Errorf("invalid sales range: %s is before %s", to.Format(time.DateOnly), from.Format(time.DateOnly))
+	}
+
+	byDate := make(map[string]models.DailySales)
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(to); month = month.AddDate(0, 1, 0) {
+		if err := r.queryMonth(ctx, month, from, to, byDate); err != nil {
+			return nil, err
+		}
+	}
+
+	var sales []models.DailySales
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		if s, ok := byDate[date]; ok {
+			sales = append(sales, s)
+		} else {
+			sales = append(sales, models.DailySales{Date: date})
+		}
+	}
+	return sales, nil
+}
+
+// queryMonth reads the rollups in one month's partition that fall within the range
+func (r *ReportRepository) queryMonth(ctx context.Context, month, from, to time.Time, out map[string]models.DailySales) error {
+	input, err := query{
+		input: &dynamodb.QueryInput{TableName: aws.String(r.store.tableName)},
+		key: partitionKey("PK", Key.SalesPK(month)).And(expression.Key("SK").Between(
+			expression.Value(string(Key.SalesSK(from))),
+			expression.Value(string(Key.SalesSK(to))),
+		)),
+	}.build(Condition{})
+	if err != nil {
+		return err
+	}
+	paginator := dynamodb.NewQueryPaginator(r.store.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query sales: %w", err)
+		}
+		for _, av := range page.Items {
+			var item dailySalesItem
+			if err := unmarshalMap(av, &item); err != nil {
+				return fmt.Errorf("failed to unmarshal sales: %w", err)
+			}
+			revenue, err := currencyTotals(av, revenueAttribute)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal sales: %w", err)
+			}
+			out[item.Data.Date] = models.DailySales{
+				Date:       item.Data.Date,
+				OrderCount: item.OrderCount,
+				Revenue:    revenue,
+			}
+		}
+	}
+	return nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
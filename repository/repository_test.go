@@ -1,14 +1,35 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
 
+	"LearnSingleTableDesign/dynamoclient"
 	"LearnSingleTableDesign/models"
 	"LearnSingleTableDesign/testutil"
+	"LearnSingleTableDesign/testutil/chaos"
+	"LearnSingleTableDesign/testutil/fixtures"
 )
 
 // testSetup creates test resources and returns cleanup function
@@ -28,73 +49,12 @@ func testSetup(t *testing.T) (*dynamodb.Client, string, *UserRepository, *OrderR
 	return client, tableName, userRepo, orderRepo, productRepo, cleanup
 }
 
-// createTestData creates test data for use in tests
-func createTestData() (models.User, []models.Order, []models.Product) {
-	testProducts := []models.Product{
-		{
-			ProductID: "PROD1",
-			Name:      "Product 1",
-			Category:  "Electronics",
-			Price:     100.00,
-			Stock:     100,
-			CreatedAt: time.Now(),
-		},
-		{
-			ProductID: "PROD2",
-			Name:      "Product 2",
-			Category:  "Electronics",
-			Price:     200.00,
-			Stock:     100,
-			CreatedAt: time.Now(),
-		},
-	}
-
-	testUser := models.User{
-		Email:     "test@example.com",
-		Name:      "Test User",
-		CreatedAt: time.Now(),
-	}
-
-	testOrders := []models.Order{
-		{
-			OrderID:   "ORD1",
-			UserEmail: testUser.Email,
-			Status:    models.OrderStatusPending,
-			Total:     99.99,
-			CreatedAt: time.Now(),
-			Products:  []string{"PROD1"},
-		},
-		{
-			OrderID:   "ORD2",
-			UserEmail: testUser.Email,
-			Status:    models.OrderStatusCompleted,
-			Total:     199.99,
-			CreatedAt: time.Now(),
-			Products:  []string{"PROD2", "PROD3"},
-		},
-		{
-			OrderID:   "ORD3",
-			UserEmail: testUser.Email,
-			Status:    models.OrderStatusPending,
-			Total:     299.99,
-			CreatedAt: time.Now(),
-			Products:  []string{"PROD4"},
-		},
-	}
-
-	return testUser, testOrders, testProducts
-}
-
 func TestUserRepository_Put(t *testing.T) {
 	_, _, userRepo, _, _, cleanup := testSetup(t)
 	defer cleanup()
 
 	// Test putting a valid user
-	user := models.User{
-		Email:     "test@example.com",
-		Name:      "Test User",
-		CreatedAt: time.Now(),
-	}
+	user := fixtures.NewUser().Build()
 
 	err := userRepo.Put(context.Background(), user)
 	if err != nil {
@@ -118,10 +78,7 @@ func TestUserRepository_Put(t *testing.T) {
 	}
 
 	// Test putting an invalid user (missing email)
-	invalidUser := models.User{
-		Name:      "Test User",
-		CreatedAt: time.Now(),
-	}
+	invalidUser := fixtures.NewUser().WithEmail("").Build()
 
 	err = userRepo.Put(context.Background(), invalidUser)
 	if err == nil {
@@ -129,10 +86,7 @@ func TestUserRepository_Put(t *testing.T) {
 	}
 
 	// Test putting an invalid user (missing name)
-	invalidUser = models.User{
-		Email:     "test@example.com",
-		CreatedAt: time.Now(),
-	}
+	invalidUser = fixtures.NewUser().WithName("").Build()
 
 	err = userRepo.Put(context.Background(), invalidUser)
 	if err == nil {
@@ -145,11 +99,7 @@ func TestUserRepository_Get(t *testing.T) {
 	defer cleanup()
 
 	// Create and store a test user
-	user := models.User{
-		Email:     "test@example.com",
-		Name:      "Test User",
-		CreatedAt: time.Now(),
-	}
+	user := fixtures.NewUser().Build()
 
 	err := userRepo.Put(context.Background(), user)
 	if err != nil {
@@ -184,14 +134,7 @@ func TestProductRepository_Put(t *testing.T) {
 	defer cleanup()
 
 	// Create and store a test product
-	product := models.Product{
-		ProductID: "PROD1",
-		Name:      "Test Product",
-		Category:  "Electronics",
-		Price:     100.00,
-		Stock:     100,
-		CreatedAt: time.Now(),
-	}
+	product := fixtures.NewProduct().WithName("Test Product").Build()
 
 	err := productRepo.Put(context.Background(), product)
 	if err != nil {
@@ -229,14 +172,7 @@ func TestOrderRepository_Put(t *testing.T) {
 	defer cleanup()
 
 	// Test putting a valid order
-	order := models.Order{
-		OrderID:   "ORD1",
-		UserEmail: "test@example.com",
-		Status:    models.OrderStatusPending,
-		Total:     99.99,
-		CreatedAt: time.Now(),
-		Products:  []string{"PROD1"},
-	}
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).Build()
 
 	err := orderRepo.Put(context.Background(), order)
 	if err != nil {
@@ -244,13 +180,7 @@ func TestOrderRepository_Put(t *testing.T) {
 	}
 
 	// Test putting an invalid order (missing order ID)
-	invalidOrder := models.Order{
-		UserEmail: "test@example.com",
-		Status:    models.OrderStatusPending,
-		Total:     99.99,
-		Products:  []string{"PROD1"},
-		CreatedAt: time.Now(),
-	}
+	invalidOrder := fixtures.NewOrderFor(fixtures.NewUser().Build()).WithID("").Build()
 
 	err = orderRepo.Put(context.Background(), invalidOrder)
 	if err == nil {
@@ -258,61 +188,43 @@ func TestOrderRepository_Put(t *testing.T) {
 	}
 
 	// Test putting an invalid order (invalid status)
-	invalidOrder = models.Order{
-		OrderID:   "ORD2",
-		UserEmail: "test@example.com",
-		Status:    "INVALID_STATUS",
-		Total:     99.99,
-		Products:  []string{"PROD1"},
-		CreatedAt: time.Now(),
-	}
+	invalidOrder = fixtures.NewOrderFor(fixtures.NewUser().Build()).WithID("ORD2").WithStatus("INVALID_STATUS").Build()
 
 	err = orderRepo.Put(context.Background(), invalidOrder)
 	if err == nil {
 		t.Error("Expected error when putting order with invalid status, got nil")
 	}
+
+	// Test the total comes from the line items, not the caller
+	tampered := fixtures.NewOrderFor(fixtures.NewUser().Build()).WithID("ORD3").Build()
+	tampered.Items = []models.LineItem{{ProductID: "PROD1", Name: "Mug", UnitPrice: 12.5, Quantity: 2}}
+	tampered.Total = 0.01
+	if err := orderRepo.Put(context.Background(), tampered); err != nil {
+		t.Fatalf("Failed to put order: %v", err)
+	}
+	stored, err := orderRepo.Get(context.Background(), tampered.UserEmail, tampered.OrderID)
+	if err != nil {
+		t.Fatalf("Failed to get order: %v", err)
+	}
+	if stored.Total != 25 {
+		t.Errorf("Total = %.2f, want 25.00 from the line items", stored.Total)
+	}
 }
 
 func TestOrderRepository_GetUserOrders(t *testing.T) {
 	_, _, _, orderRepo, _, cleanup := testSetup(t)
 	defer cleanup()
 
-	userEmail := "test@example.com"
+	user := fixtures.NewUser().Build()
+	userEmail := user.Email
 
 	// Create and store some test orders
-	orders := []models.Order{
-		{
-			OrderID:   "ORD1",
-			UserEmail: userEmail,
-			Status:    models.OrderStatusPending,
-			Total:     99.99,
-			CreatedAt: time.Now(),
-			Products:  []string{"PROD1"},
-		},
-		{
-			OrderID:   "ORD2",
-			UserEmail: userEmail,
-			Status:    models.OrderStatusCompleted,
-			Total:     199.99,
-			CreatedAt: time.Now(),
-			Products:  []string{"PROD2", "PROD3"},
-		},
-		{
-			OrderID:   "ORD3",
-			UserEmail: userEmail,
-			Status:    models.OrderStatusPending,
-			Total:     299.99,
-			CreatedAt: time.Now(),
-			Products:  []string{"PROD4"},
-		},
-	}
-
-	for _, order := range orders {
-		err := orderRepo.Put(context.Background(), order)
-		if err != nil {
-			t.Fatalf("Failed to put test order: %v", err)
-		}
+	orders := []fixtures.Fixture{
+		fixtures.NewOrderFor(user).WithID("ORD1"),
+		fixtures.NewOrderFor(user).WithID("ORD2").WithStatus(models.OrderStatusCompleted).WithTotal(199.99).WithProducts("PROD2", "PROD3"),
+		fixtures.NewOrderFor(user).WithID("ORD3").WithTotal(299.99).WithProducts("PROD4"),
 	}
+	fixtures.Seed(t, fixtures.Repos{Orders: orderRepo}, orders...)
 
 	// Test getting all orders
 	result, err := orderRepo.GetUserOrders(context.Background(), userEmail, nil)
@@ -337,6 +249,9 @@ func TestOrderRepository_GetUserOrders(t *testing.T) {
 	if result.NextPageToken == nil {
 		t.Error("Expected next page token for paginated results, got nil")
 	}
+	if !result.HasMore || result.Count != 2 {
+		t.Errorf("PageInfo = %+v, want HasMore with Count 2", result.PageInfo)
+	}
 
 	// Test getting orders for non-existent user
 	result, err = orderRepo.GetUserOrders(context.Background(), "nonexistent@example.com", nil)
@@ -348,3 +263,3206 @@ func TestOrderRepository_GetUserOrders(t *testing.T) {
 		t.Errorf("Got %d orders for non-existent user, want 0", len(result.Orders))
 	}
 }
+
+func TestStore_EnforceKeyConsistency(t *testing.T) {
+	// The checks run before any DynamoDB call, so no table is needed
+	store := NewStore(nil, "unused", EnforceKeyConsistency())
+	ctx := context.Background()
+
+	// Test an order stored under a product key
+	item := GenericItem[models.Order]{
+		PK:         Key.ProductPK(),
+		SK:         Key.ProductSK("PROD1"),
+		EntityType: EntityOrder,
+	}
+	err := PutItem(ctx, store, item)
+	if !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch for order under product key, got %v", err)
+	}
+
+	// Test an unregistered entity type
+	item.EntityType = "UNKNOWN"
+	err = PutItem(ctx, store, item)
+	if !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch for unknown entity type, got %v", err)
+	}
+
+	// Test matching keys pass the check
+	if err := store.checkKeys(ctx, EntityOrder, Key.UserPK("test@example.com"), Key.OrderSK("ORD1")); err != nil {
+		t.Errorf("Expected matching order keys to pass, got %v", err)
+	}
+
+	// Test the migration escape hatch
+	if err := store.checkKeys(SkipKeyCheck(ctx), EntityOrder, Key.ProductPK(), Key.ProductSK("PROD1")); err != nil {
+		t.Errorf("Expected skipped key check to pass, got %v", err)
+	}
+}
+
+func TestDetectDuplicateWrites(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	op := WriteOp{PK: Key.UserPK("test@example.com"), SK: Key.UserSK("test@example.com"), EntityType: EntityUser}
+
+	// Test writes outside a tracked request are ignored
+	duplicateWriteHook(context.Background(), op)
+	duplicateWriteHook(context.Background(), op)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no warning without write tracking, got %q", buf.String())
+	}
+
+	// Test the second unconditional write in a request is reported
+	ctx := TrackWrites(context.Background())
+	duplicateWriteHook(ctx, op)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no warning after first write, got %q", buf.String())
+	}
+	duplicateWriteHook(ctx, op)
+	if !strings.Contains(buf.String(), "duplicate unconditional write") {
+		t.Errorf("Expected duplicate write warning, got %q", buf.String())
+	}
+
+	// Test conditional writes are not reported
+	buf.Reset()
+	op.Conditional = true
+	duplicateWriteHook(ctx, op)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no warning for conditional write, got %q", buf.String())
+	}
+}
+
+func TestBatchPutItems(t *testing.T) {
+	client, tableName, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+
+	// Write more items than fit in a single batch
+	items := benchOrderItems(60, "ORD")
+	batch, err := BatchPutItems(context.Background(), NewStore(client, tableName), items)
+	if err != nil {
+		t.Fatalf("Failed to batch put items: %v", err)
+	}
+	if err := batch.Err(); err != nil {
+		t.Fatalf("Failed to batch put items: %v", err)
+	}
+	if len(batch.Succeeded) != len(items) {
+		t.Errorf("Got %d succeeded keys, want %d", len(batch.Succeeded), len(items))
+	}
+
+	result, err := orderRepo.GetUserOrders(context.Background(), items[0].Data.UserEmail, nil)
+	if err != nil {
+		t.Fatalf("Failed to get user orders: %v", err)
+	}
+
+	if len(result.Orders) != len(items) {
+		t.Errorf("Got %d orders, want %d", len(result.Orders), len(items))
+	}
+}
+
+func TestProductRepository_GetContent(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+
+	contents := []models.ProductContent{
+		{ProductID: "PROD1", Locale: "en", Name: "Product 1", Description: "A product"},
+		{ProductID: "PROD1", Locale: "fr", Name: "Produit 1", Description: "Un produit"},
+	}
+	for _, content := range contents {
+		if err := productRepo.PutContent(context.Background(), content); err != nil {
+			t.Fatalf("Failed to put product content: %v", err)
+		}
+	}
+
+	// Test the first available locale in the chain wins
+	got, err := productRepo.GetContent(context.Background(), "PROD1", []string{"fr-CA", "fr", "en"})
+	if err != nil {
+		t.Fatalf("Failed to get product content: %v", err)
+	}
+	if got.Name != "Produit 1" {
+		t.Errorf("Name = %v, want %v", got.Name, "Produit 1")
+	}
+
+	// Test falling back to the default locale
+	got, err = productRepo.GetContent(context.Background(), "PROD1", []string{"de", "en"})
+	if err != nil {
+		t.Fatalf("Failed to get fallback product content: %v", err)
+	}
+	if got.Name != "Product 1" {
+		t.Errorf("Name = %v, want %v", got.Name, "Product 1")
+	}
+
+	// Test a product without content
+	_, err = productRepo.GetContent(context.Background(), "PROD2", []string{"en"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for product without content, got %v", err)
+	}
+}
+
+func TestPageRepository_PutAndAll(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	pageRepo := NewPageRepository(client, tableName)
+
+	pages := []models.Page{
+		{Slug: "about", Title: "About", Status: models.PageStatusPublished, NavLabel: "About"},
+		{Slug: "faq", Title: "FAQ", Status: models.PageStatusDraft},
+	}
+	for _, page := range pages {
+		if err := pageRepo.Put(context.Background(), page); err != nil {
+			t.Fatalf("Failed to put page: %v", err)
+		}
+	}
+
+	// Test getting a single page
+	got, err := pageRepo.Get(context.Background(), "faq")
+	if err != nil {
+		t.Fatalf("Failed to get page: %v", err)
+	}
+	if got.Status != models.PageStatusDraft {
+		t.Errorf("Status = %v, want %v", got.Status, models.PageStatusDraft)
+	}
+
+	// Test listing includes drafts
+	all, err := pageRepo.All(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list pages: %v", err)
+	}
+	if len(all) != len(pages) {
+		t.Errorf("Got %d pages, want %d", len(all), len(pages))
+	}
+
+	// Test putting a page with an invalid slug
+	err = pageRepo.Put(context.Background(), models.Page{Slug: "Not A Slug", Title: "Bad", Status: models.PageStatusDraft})
+	if err == nil {
+		t.Error("Expected error when putting page with invalid slug, got nil")
+	}
+}
+
+func TestOrderRepository_GetUserOrdersByCreatedAt(t *testing.T) {
+	_, _, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+
+	// Order IDs sort differently from creation time
+	user := fixtures.NewUser().Build()
+	now := time.Now()
+	fixtures.Seed(t, fixtures.Repos{Orders: orderRepo},
+		fixtures.NewOrderFor(user).WithID("ORD1").WithCreatedAt(now.Add(-time.Hour)),
+		fixtures.NewOrderFor(user).WithID("ORD2").WithCreatedAt(now.Add(-3*time.Hour)),
+		fixtures.NewOrderFor(user).WithID("ORD3").WithCreatedAt(now.Add(-2*time.Hour)),
+		// Undated orders stay out of the index instead of sorting first
+		fixtures.NewOrderFor(user).WithID("ORD4").WithCreatedAt(time.Time{}),
+	)
+
+	// Test oldest first
+	result, err := orderRepo.GetUserOrdersByCreatedAt(context.Background(), user.Email, nil)
+	if err != nil {
+		t.Fatalf("Failed to get user orders by creation time: %v", err)
+	}
+	var got []string
+	for _, order := range result.Orders {
+		got = append(got, order.OrderID)
+	}
+	if want := []string{"ORD2", "ORD3", "ORD1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Order IDs = %v, want %v", got, want)
+	}
+
+	// Test newest first with pagination across the index
+	result, err = orderRepo.GetUserOrdersByCreatedAt(context.Background(), user.Email, &QueryOptions{Limit: 2, Descending: true})
+	if err != nil {
+		t.Fatalf("Failed to get first page: %v", err)
+	}
+	if len(result.Orders) != 2 || result.Orders[0].OrderID != "ORD1" {
+		t.Fatalf("Unexpected first page %+v", result.Orders)
+	}
+	result, err = orderRepo.GetUserOrdersByCreatedAt(context.Background(), user.Email, &QueryOptions{Limit: 2, Descending: true, PageToken: result.NextPageToken})
+	if err != nil {
+		t.Fatalf("Failed to get second page: %v", err)
+	}
+	if len(result.Orders) != 1 || result.Orders[0].OrderID != "ORD2" {
+		t.Errorf("Unexpected second page %+v", result.Orders)
+	}
+}
+
+func TestOrderRepository_GetUserOrdersByStatus(t *testing.T) {
+	_, _, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	user := fixtures.NewUser().Build()
+	now := time.Now()
+	fixtures.Seed(t, fixtures.Repos{Orders: orderRepo},
+		fixtures.NewOrderFor(user).WithID("ORD1").WithStatus(models.OrderStatusPending).WithCreatedAt(now.Add(-3*time.Hour)),
+		fixtures.NewOrderFor(user).WithID("ORD2").WithStatus(models.OrderStatusProcessing).WithCreatedAt(now.Add(-2*time.Hour)),
+		fixtures.NewOrderFor(user).WithID("ORD3").WithStatus(models.OrderStatusPending).WithCreatedAt(now.Add(-time.Hour)),
+		fixtures.NewOrderFor(fixtures.NewUser().WithEmail("other@example.com").Build()).WithID("ORD4").WithStatus(models.OrderStatusPending),
+	)
+
+	// Test only the user's orders in the status are listed, newest first
+	result, err := orderRepo.GetUserOrdersByStatus(ctx, user.Email, models.OrderStatusPending, &QueryOptions{Descending: true})
+	if err != nil {
+		t.Fatalf("Failed to get orders by status: %v", err)
+	}
+	var got []string
+	for _, order := range result.Orders {
+		got = append(got, order.OrderID)
+	}
+	if want := []string{"ORD3", "ORD1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pending order IDs = %v, want %v", got, want)
+	}
+
+	// Test a transition moves the order to its new status's partition
+	if _, err := orderRepo.Transition(ctx, user.Email, "ORD1", models.OrderStatusPending, models.OrderStatusProcessing); err != nil {
+		t.Fatalf("Failed to transition order: %v", err)
+	}
+	result, err = orderRepo.GetUserOrdersByStatus(ctx, user.Email, models.OrderStatusProcessing, &QueryOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("Failed to get first page: %v", err)
+	}
+	if len(result.Orders) != 1 || result.Orders[0].OrderID != "ORD1" || result.NextPageToken == nil {
+		t.Fatalf("Unexpected first page %+v", result.Orders)
+	}
+	result, err = orderRepo.GetUserOrdersByStatus(ctx, user.Email, models.OrderStatusProcessing, &QueryOptions{Limit: 1, PageToken: result.NextPageToken})
+	if err != nil {
+		t.Fatalf("Failed to get second page: %v", err)
+	}
+	if len(result.Orders) != 1 || result.Orders[0].OrderID != "ORD2" {
+		t.Errorf("Unexpected second page %+v", result.Orders)
+	}
+	result, err = orderRepo.GetUserOrdersByStatus(ctx, user.Email, models.OrderStatusPending, nil)
+	if err != nil {
+		t.Fatalf("Failed to get pending orders: %v", err)
+	}
+	if len(result.Orders) != 1 || result.Orders[0].OrderID != "ORD3" {
+		t.Errorf("Pending orders after the transition = %+v, want only ORD3", result.Orders)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	tracker := NewRecentWrites(time.Minute)
+	store := NewStore(nil, "unused", ReadYourWrites(tracker))
+	pk := Key.UserPK("test@example.com")
+	op := WriteOp{PK: pk, SK: Key.UserSK("test@example.com"), EntityType: EntityUser}
+
+	alice := WithSession(context.Background(), "alice")
+	bob := WithSession(context.Background(), "bob")
+
+	// Test reads are eventually consistent before any write
+	if store.consistentRead(alice, pk) != nil {
+		t.Error("Expected eventually consistent read before writing")
+	}
+
+	store.runWriteHooks(alice, op)
+
+	// Test the writing session reads its partition consistently
+	if got := store.consistentRead(alice, pk); got == nil || !*got {
+		t.Error("Expected consistent read right after the session's write")
+	}
+	// Test other sessions and partitions are unaffected
+	if store.consistentRead(bob, pk) != nil {
+		t.Error("Expected eventually consistent read for another session")
+	}
+	if store.consistentRead(alice, Key.ProductPK()) != nil {
+		t.Error("Expected eventually consistent read for another partition")
+	}
+	if store.consistentRead(context.Background(), pk) != nil {
+		t.Error("Expected eventually consistent read without a session")
+	}
+
+	// Test the window expires
+	expired := NewRecentWrites(-time.Second)
+	expired.record(alice, op)
+	if expired.shouldReadConsistently(alice, pk) {
+		t.Error("Expected consistent reads to stop after the window")
+	}
+}
+
+func TestUserRepository_GetUserWithOrders(t *testing.T) {
+	client, tableName, userRepo, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	addressRepo := NewAddressRepository(client, tableName)
+
+	user := fixtures.NewUser().Build()
+	fixtures.Seed(t, fixtures.Repos{Users: userRepo, Orders: orderRepo},
+		fixtures.NewUser().WithEmail(user.Email).WithName(user.Name),
+		fixtures.NewOrderFor(user).WithID("ORD1"),
+		fixtures.NewOrderFor(user).WithID("ORD2"),
+	)
+	address := models.Address{
+		AddressID:  "ADDR1",
+		UserEmail:  user.Email,
+		Line1:      "1 Main St",
+		City:       "Springfield",
+		PostalCode: "12345",
+		Country:    "US",
+		CreatedAt:  time.Now(),
+	}
+	if err := addressRepo.Put(context.Background(), address); err != nil {
+		t.Fatalf("Failed to put address: %v", err)
+	}
+
+	// Test one query returns every entity in the collection
+	got, err := userRepo.GetUserWithOrders(context.Background(), user.Email)
+	if err != nil {
+		t.Fatalf("Failed to get user with orders: %v", err)
+	}
+	if got.User.Email != user.Email {
+		t.Errorf("Email = %v, want %v", got.User.Email, user.Email)
+	}
+	if len(got.Orders) != 2 {
+		t.Errorf("Got %d orders, want 2", len(got.Orders))
+	}
+	if len(got.Addresses) != 1 || got.Addresses[0].City != address.City {
+		t.Errorf("Addresses = %+v, want [%+v]", got.Addresses, address)
+	}
+
+	// Test a user without a profile
+	_, err = userRepo.GetUserWithOrders(context.Background(), "nonexistent@example.com")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing user, got %v", err)
+	}
+}
+
+func TestBatchPutItems_PartialFailure(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	store := NewStore(client, tableName, EnforceKeyConsistency())
+
+	// One item has keys that don't match its entity type
+	items := benchOrderItems(3, "ORD")
+	items[1].PK = Key.ProductPK()
+
+	result, err := BatchPutItems(context.Background(), store, items)
+	if err != nil {
+		t.Fatalf("Failed to batch put items: %v", err)
+	}
+	if len(result.Succeeded) != 2 || len(result.Failed) != 1 {
+		t.Fatalf("Got %d succeeded and %d failed, want 2 and 1", len(result.Succeeded), len(result.Failed))
+	}
+	if result.Failed[0].Key.PK != items[1].PK || result.Failed[0].Retryable {
+		t.Errorf("Unexpected failure %+v", result.Failed[0])
+	}
+	if !errors.Is(result.Err(), ErrPartialBatch) {
+		t.Errorf("Expected ErrPartialBatch, got %v", result.Err())
+	}
+
+	// Test reading back the written items and a missing one
+	keys := []ItemKey{
+		{PK: items[0].PK, SK: items[0].SK},
+		{PK: items[2].PK, SK: items[2].SK},
+		{PK: items[1].PK, SK: items[1].SK},
+	}
+	got, result, err := BatchGetItems[models.Order](context.Background(), store, keys)
+	if err != nil {
+		t.Fatalf("Failed to batch get items: %v", err)
+	}
+	if len(got) != 2 || len(result.Succeeded) != 2 {
+		t.Errorf("Got %d items and %d succeeded keys, want 2", len(got), len(result.Succeeded))
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Key != keys[2] {
+		t.Errorf("Failed = %+v, want only %+v", result.Failed, keys[2])
+	}
+}
+
+func TestBatchResult(t *testing.T) {
+	result := &BatchResult{Succeeded: []ItemKey{{PK: "USER#a", SK: "PROFILE#a"}}}
+	if !result.OK() || result.Err() != nil || result.Retryable() {
+		t.Errorf("Expected a clean result, got %+v", result)
+	}
+
+	throttled := ItemKey{PK: "USER#b", SK: "PROFILE#b"}
+	result.fail([]ItemKey{throttled}, errors.New("still unprocessed"), true)
+	if !result.Retryable() {
+		t.Error("Expected throttled-only failures to be retryable")
+	}
+	if keys := result.RetryKeys(); len(keys) != 1 || keys[0] != throttled {
+		t.Errorf("RetryKeys = %v, want [%v]", keys, throttled)
+	}
+
+	result.fail([]ItemKey{{PK: "USER#c", SK: "PROFILE#c"}}, errors.New("bad keys"), false)
+	if result.Retryable() {
+		t.Error("Expected a rejected item to make the result non-retryable")
+	}
+	if !errors.Is(result.Err(), ErrPartialBatch) {
+		t.Errorf("Expected ErrPartialBatch, got %v", result.Err())
+	}
+}
+
+func TestPageToken_RoundTrip(t *testing.T) {
+	// An LSI1 LastEvaluatedKey carries the index sort key as well
+	key := map[string]types.AttributeValue{
+		"PK":  &types.AttributeValueMemberS{Value: string(Key.UserPK("test@example.com"))},
+		"SK":  &types.AttributeValueMemberS{Value: string(Key.OrderSK("ORD1"))},
+		"SK2": &types.AttributeValueMemberN{Value: "1700000000000"},
+	}
+
+	token, err := ParsePageToken(NewPageToken(key).String())
+	if err != nil {
+		t.Fatalf("Failed to parse page token: %v", err)
+	}
+	if !reflect.DeepEqual(token.Raw(), key) {
+		t.Errorf("Raw = %#v, want %#v", token.Raw(), key)
+	}
+
+	// Test the first page has no token
+	if token, err := ParsePageToken(""); token != nil || err != nil {
+		t.Errorf("ParsePageToken(\"\") = %v, %v, want nil, nil", token, err)
+	}
+	if NewPageToken(nil) != nil {
+		t.Error("Expected no token without a LastEvaluatedKey")
+	}
+
+	// Test tampered tokens are rejected
+	for _, bad := range []string{"not base64!", "bnVsbA", "e30"} {
+		if _, err := ParsePageToken(bad); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("ParsePageToken(%q) error = %v, want ErrInvalidPageToken", bad, err)
+		}
+	}
+}
+
+func TestKeyFactory_HMACIDs(t *testing.T) {
+	plain := NewKeyFactory(NewIDHasher(""))
+	hashed := NewKeyFactory(NewIDHasher("secret"))
+	email := "test@example.com"
+
+	if plain.UserPK(email) != Key.UserPK(email) {
+		t.Errorf("UserPK = %v, want %v", plain.UserPK(email), Key.UserPK(email))
+	}
+	if pk := string(hashed.UserPK(email)); strings.Contains(pk, email) || !strings.HasPrefix(pk, "USER#") {
+		t.Errorf("Expected hashed UserPK without the email, got %v", pk)
+	}
+	if hashed.UserPK(email) != NewKeyFactory(NewIDHasher("secret")).UserPK(email) {
+		t.Error("Expected hashing to be deterministic")
+	}
+	if hashed.UserPK(email) == NewKeyFactory(NewIDHasher("other")).UserPK(email) {
+		t.Error("Expected a different secret to give different keys")
+	}
+
+	// Test moving a profile and an order from plain to hashed keys
+	profile, err := attributevalue.MarshalMap(GenericItem[models.User]{
+		PK: plain.UserPK(email), SK: plain.UserSK(email), EntityType: EntityUser,
+		Data: models.User{Email: email, Name: "Test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := attributevalue.MarshalMap(GenericItem[models.Order]{
+		PK: plain.UserPK(email), SK: plain.OrderSK("ORD1"), EntityType: EntityOrder,
+		GSI2PK: plain.OrderStatusPK(email, models.OrderStatusPending),
+		Data:   models.Order{OrderID: "ORD1", UserEmail: email, Status: models.OrderStatusPending},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		item   map[string]types.AttributeValue
+		wantSK SortKey
+	}{
+		{profile, hashed.UserSK(email)},
+		{order, plain.OrderSK("ORD1")},
+	} {
+		rekeyed, changed, err := hashed.RekeyUserItem(tc.item, "")
+		if err != nil || !changed {
+			t.Fatalf("RekeyUserItem = %v, %v, want changed", changed, err)
+		}
+		var got GenericItem[map[string]any]
+		if err := attributevalue.UnmarshalMap(rekeyed, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.PK != hashed.UserPK(email) || got.SK != tc.wantSK {
+			t.Errorf("Keys = %v/%v, want %v/%v", got.PK, got.SK, hashed.UserPK(email), tc.wantSK)
+		}
+		if got.EntityType == EntityOrder && got.GSI2PK != hashed.OrderStatusPK(email, models.OrderStatusPending) {
+			t.Errorf("GSI2PK = %v, want the hashed status partition", got.GSI2PK)
+		}
+
+		// Test rekeying is idempotent
+		if _, changed, _ := hashed.RekeyUserItem(rekeyed, ""); changed {
+			t.Error("Expected already rekeyed item to be unchanged")
+		}
+	}
+
+	// Test a cart, which doesn't store the email, moves to its owner's keys
+	cart, err := attributevalue.MarshalMap(GenericItem[models.Cart]{
+		PK: plain.UserPK(email), SK: Key.CartSK(), EntityType: EntityCart,
+		Data: models.Cart{Items: []models.CartItem{{ProductID: "P1", Quantity: 1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := hashed.RekeyUserItem(cart, ""); err == nil {
+		t.Error("Expected a cart without its owner to fail")
+	}
+	rekeyed, changed, err := hashed.RekeyUserItem(cart, email)
+	if err != nil || !changed || rekeyed["PK"].(*types.AttributeValueMemberS).Value != string(hashed.UserPK(email)) {
+		t.Errorf("Rekeyed cart = %v, %v, %v, want it under the hashed key", rekeyed["PK"], changed, err)
+	}
+
+	// Test compressed data is read through the codec and stays compressed
+	compressed := maps.Clone(order)
+	packed, err := compressAttribute(order["data"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed["data"] = &types.AttributeValueMemberB{Value: packed}
+	compressed[contentEncodingAttribute] = &types.AttributeValueMemberS{Value: encodingGzip}
+	rekeyed, changed, err = hashed.RekeyUserItem(compressed, "")
+	if err != nil || !changed {
+		t.Fatalf("RekeyUserItem of compressed data = %v, %v, want changed", changed, err)
+	}
+	if _, ok := rekeyed["data"].(*types.AttributeValueMemberB); !ok {
+		t.Error("Expected the rekeyed data to stay compressed")
+	}
+	if got := rekeyed["GSI2PK"].(*types.AttributeValueMemberS).Value; got != string(hashed.OrderStatusPK(email, models.OrderStatusPending)) {
+		t.Errorf("GSI2PK = %v, want the hashed status partition", got)
+	}
+
+	// Test an order without data is an error rather than a panic
+	bare := maps.Clone(order)
+	delete(bare, "data")
+	if _, _, err := hashed.RekeyUserItem(bare, email); err == nil {
+		t.Error("Expected an order without data to fail")
+	}
+
+	// Test a coupon redemption moves within its coupon
+	redemption, err := attributevalue.MarshalMap(GenericItem[models.CouponRedemption]{
+		PK: Key.CouponPK("SAVE10"), SK: plain.CouponRedemptionSK(email), EntityType: EntityCouponRedemption,
+		Data: models.CouponRedemption{Code: "SAVE10", UserEmail: email, OrderID: "ORD1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, changed, err = hashed.RekeyUserItem(redemption, "")
+	if err != nil || !changed {
+		t.Fatalf("RekeyUserItem of a redemption = %v, %v, want changed", changed, err)
+	}
+	if pk, sk := rekeyed["PK"].(*types.AttributeValueMemberS).Value, rekeyed["SK"].(*types.AttributeValueMemberS).Value; pk != string(Key.CouponPK("SAVE10")) || sk != string(hashed.CouponRedemptionSK(email)) {
+		t.Errorf("Redemption keys = %v/%v, want the hashed redemption in the same coupon", pk, sk)
+	}
+
+	// Test the collection's audit log moves with it
+	entry, err := attributevalue.MarshalMap(auditItem(models.AuditEntry{
+		AuditID: "A1", PK: string(plain.UserPK(email)), SK: string(plain.UserSK(email)), At: time.Now(),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, changed, err = RekeyAuditEntry(entry, hashed.UserPK(email))
+	if err != nil || !changed {
+		t.Fatalf("RekeyAuditEntry = %v, %v, want changed", changed, err)
+	}
+	var moved GenericItem[models.AuditEntry]
+	if err := attributevalue.UnmarshalMap(rekeyed, &moved); err != nil {
+		t.Fatal(err)
+	}
+	if moved.PK != Key.AuditPK(hashed.UserPK(email)) || moved.Data.PK != string(hashed.UserPK(email)) || moved.Data.SK != string(hashed.UserSK(email)) {
+		t.Errorf("Moved entry = %v %s/%s, want the hashed profile in the hashed log", moved.PK, moved.Data.PK, moved.Data.SK)
+	}
+}
+
+func TestStore_MaxPageSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		max   int32
+		opts  *QueryOptions
+		limit int32
+	}{
+		{"no cap and no limit", 0, nil, 0},
+		{"no cap keeps limit", 0, &QueryOptions{Limit: 500}, 500},
+		{"cap applies without options", 100, nil, 100},
+		{"cap applies to unset limit", 100, &QueryOptions{}, 100},
+		{"cap clamps large limit", 100, &QueryOptions{Limit: 500}, 100},
+		{"smaller limit is kept", 100, &QueryOptions{Limit: 10}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(nil, "unused", MaxPageSize(tt.max))
+			if got := store.pageLimit(tt.opts); got != tt.limit {
+				t.Errorf("pageLimit = %d, want %d", got, tt.limit)
+			}
+		})
+	}
+}
+
+func TestProductRepository_SearchByNamePrefix(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+
+	fixtures.Seed(t, fixtures.Repos{Products: productRepo},
+		fixtures.NewProduct().WithID("PROD1").WithName("Desk Lamp"),
+		fixtures.NewProduct().WithID("PROD2").WithName("desk chair"),
+		fixtures.NewProduct().WithID("PROD3").WithName("Bookshelf"),
+	)
+
+	// Test matching ignores case and returns name order
+	result, err := productRepo.SearchByNamePrefix(context.Background(), "DESK", nil)
+	if err != nil {
+		t.Fatalf("Failed to search products: %v", err)
+	}
+	var got []string
+	for _, product := range result.Products {
+		got = append(got, product.ProductID)
+	}
+	if want := []string{"PROD2", "PROD1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Product IDs = %v, want %v", got, want)
+	}
+
+	// Test paginating the index resumes after the first match
+	result, err = productRepo.SearchByNamePrefix(context.Background(), "desk", &QueryOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("Failed to get first page: %v", err)
+	}
+	result, err = productRepo.SearchByNamePrefix(context.Background(), "desk", &QueryOptions{Limit: 1, PageToken: result.NextPageToken})
+	if err != nil {
+		t.Fatalf("Failed to get second page: %v", err)
+	}
+	if len(result.Products) != 1 || result.Products[0].ProductID != "PROD1" {
+		t.Errorf("Unexpected second page %+v", result.Products)
+	}
+}
+
+func TestWebhookRepository(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	webhookRepo := NewWebhookRepository(client, tableName)
+	ctx := context.Background()
+
+	webhook := models.Webhook{
+		WebhookID:  "WH1",
+		URL:        "https://example.com/hooks",
+		Secret:     "s3cret",
+		EventTypes: []string{"order.completed"},
+		CreatedAt:  time.Now(),
+	}
+	if err := webhookRepo.Put(ctx, webhook); err != nil {
+		t.Fatalf("Failed to put webhook: %v", err)
+	}
+	all, err := webhookRepo.All(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list webhooks: %v", err)
+	}
+	if len(all) != 1 || all[0].Secret != webhook.Secret {
+		t.Errorf("Webhooks = %+v, want [%+v]", all, webhook)
+	}
+
+	// Test deliveries come back newest first
+	now := time.Now()
+	for attempt := 1; attempt <= 2; attempt++ {
+		err := webhookRepo.RecordDelivery(ctx, models.WebhookDelivery{
+			WebhookID:   webhook.WebhookID,
+			EventID:     "EVT1",
+			EventType:   "order.completed",
+			Attempt:     attempt,
+			AttemptedAt: now.Add(time.Duration(attempt) * time.Second),
+		})
+		if err != nil {
+			t.Fatalf("Failed to record delivery: %v", err)
+		}
+	}
+	page, err := webhookRepo.GetDeliveries(ctx, webhook.WebhookID, nil)
+	if err != nil {
+		t.Fatalf("Failed to get deliveries: %v", err)
+	}
+	if len(page.Deliveries) != 2 || page.Deliveries[0].Attempt != 2 {
+		t.Errorf("Unexpected deliveries %+v", page.Deliveries)
+	}
+
+	// Test invalid subscriptions are rejected
+	webhook.URL = "not a url"
+	if err := webhookRepo.Put(ctx, webhook); err == nil {
+		t.Error("Expected error when putting webhook with invalid URL, got nil")
+	}
+}
+
+func TestContactRepository(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	contactRepo := NewContactRepository(client, tableName)
+	ctx := context.Background()
+
+	for _, name := range []string{"Ada", "Grace", "Linus"} {
+		if _, err := contactRepo.Submit(ctx, name, name+"@Example.com", "Hello from "+name); err != nil {
+			t.Fatalf("Failed to submit message: %v", err)
+		}
+		// Keys are ordered to the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+	if _, err := contactRepo.Submit(ctx, "", "nobody@example.com", "Hi"); err == nil {
+		t.Error("Expected a message without a name to be rejected")
+	}
+
+	page, err := contactRepo.Recent(ctx, &QueryOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("Failed to list messages: %v", err)
+	}
+	if len(page.Messages) != 2 || page.Messages[0].Name != "Linus" || page.Messages[1].Name != "Grace" {
+		t.Fatalf("First page = %+v, want Linus then Grace", page.Messages)
+	}
+	if page.Messages[0].Email != "linus@example.com" {
+		t.Errorf("Email = %q, want it normalized", page.Messages[0].Email)
+	}
+	page, err = contactRepo.Recent(ctx, &QueryOptions{Limit: 2, PageToken: page.NextPageToken})
+	if err != nil {
+		t.Fatalf("Failed to list messages: %v", err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Name != "Ada" || page.NextPageToken != nil {
+		t.Errorf("Last page = %+v, want just Ada", page.Messages)
+	}
+}
+
+func TestCartRepository(t *testing.T) {
+	client, tableName, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	cartRepo := NewCartRepository(client, tableName, EnforceKeyConsistency())
+	ctx := context.Background()
+	session, user := SessionCart("sess-1"), UserCart("ann@example.com")
+	for _, id := range []string{"P1", "P2", "P3"} {
+		if err := productRepo.Put(ctx, fixtures.NewProduct().WithID(id).WithStock(10).Build()); err != nil {
+			t.Fatalf("Failed to put product: %v", err)
+		}
+	}
+	stock := func(productID string) int {
+		t.Helper()
+		product, err := productRepo.Get(ctx, productID)
+		if err != nil {
+			t.Fatalf("Failed to get product: %v", err)
+		}
+		return product.Stock
+	}
+
+	cart, err := cartRepo.Get(ctx, session)
+	if err != nil || len(cart.Items) != 0 {
+		t.Fatalf("Get of a new session = %+v, %v, want an empty cart", cart, err)
+	}
+	for _, add := range []models.CartItem{{ProductID: "P1", Quantity: 1}, {ProductID: "P2", Quantity: 2}, {ProductID: "P1", Quantity: 1}} {
+		if _, err := cartRepo.Add(ctx, session, add.ProductID, add.Quantity); err != nil {
+			t.Fatalf("Failed to add to session cart: %v", err)
+		}
+	}
+	if _, err := cartRepo.Add(ctx, session, "P3", 0); !errors.Is(err, models.ErrInvalid) {
+		t.Errorf("Add of nothing = %v, want a validation error", err)
+	}
+	if _, err := cartRepo.Add(ctx, session, "P3", 11); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("Add of more than is in stock = %v, want ErrInsufficientStock", err)
+	}
+	if _, err := cartRepo.Add(ctx, user, "P1", 5); err != nil {
+		t.Fatalf("Failed to add to user cart: %v", err)
+	}
+
+	// Test what is added is held out of stock, once per add
+	if got := stock("P1"); got != 3 {
+		t.Errorf("P1 stock = %d, want 3 with 7 in carts", got)
+	}
+	if got := stock("P3"); got != 10 {
+		t.Errorf("P3 stock = %d, want 10 after failed adds", got)
+	}
+	var stored GenericItem[models.Cart]
+	if err := GetItem(ctx, cartRepo.store, Key.SessionPK("sess-1"), Key.CartSK(), &stored); err != nil {
+		t.Fatalf("Failed to read session cart: %v", err)
+	}
+	if stored.EntityType != EntitySessionCart || stored.TTL == 0 || stored.Data.Version != 3 {
+		t.Errorf("Session cart item = %s ttl=%d version=%d, want an expiring session cart at version 3", stored.EntityType, stored.TTL, stored.Data.Version)
+	}
+	if holds := stored.Data.Items[0].HoldIDs; len(holds) != 2 {
+		t.Errorf("P1 holds = %v, want one per add", holds)
+	}
+
+	merged, err := cartRepo.Merge(ctx, "sess-1", "Ann@Example.com")
+	if err != nil {
+		t.Fatalf("Failed to merge carts: %v", err)
+	}
+	want := []models.CartItem{{ProductID: "P1", Quantity: 7}, {ProductID: "P2", Quantity: 2}}
+	if got := withoutHolds(merged.Items); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merged items = %+v, want %+v", got, want)
+	}
+	if holds := merged.Items[0].HoldIDs; len(holds) != 3 {
+		t.Errorf("Merged P1 holds = %v, want both carts' holds", holds)
+	}
+	if cart, _ := cartRepo.Get(ctx, user); !reflect.DeepEqual(withoutHolds(cart.Items), want) {
+		t.Errorf("Stored user cart = %+v, want %+v", cart.Items, want)
+	}
+	if cart, _ := cartRepo.Get(ctx, session); len(cart.Items) != 0 {
+		t.Errorf("Session cart after merge = %+v, want it gone", cart.Items)
+	}
+	if got := stock("P1"); got != 3 {
+		t.Errorf("P1 stock after merge = %d, want it still held", got)
+	}
+
+	// Merging again, with nothing left in the session, changes nothing
+	if again, err := cartRepo.Merge(ctx, "sess-1", "ann@example.com"); err != nil || again.Version != merged.Version {
+		t.Errorf("Second merge = %+v, %v, want the user's cart unchanged", again, err)
+	}
+
+	// Test removing an item releases its holds
+	cart, err = cartRepo.Remove(ctx, user, "P1")
+	if err != nil || !reflect.DeepEqual(withoutHolds(cart.Items), want[1:]) {
+		t.Errorf("Remove = %+v, %v, want just P2", cart, err)
+	}
+	if got := stock("P1"); got != 10 {
+		t.Errorf("P1 stock after remove = %d, want all 10 back", got)
+	}
+}
+
+// withoutHolds returns cart items with their hold IDs left out
+func withoutHolds(items []models.CartItem) []models.CartItem {
+	stripped := make([]models.CartItem, len(items))
+	for i, item := range items {
+		stripped[i] = models.CartItem{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+	return stripped
+}
+
+func TestOrderService_Checkout(t *testing.T) {
+	client, tableName, userRepo, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	service := NewOrderService(client, tableName, EnforceKeyConsistency())
+	cartRepo := NewCartRepository(client, tableName, EnforceKeyConsistency())
+
+	user := fixtures.NewUser().Build()
+	if err := userRepo.Put(ctx, user); err != nil {
+		t.Fatalf("Failed to put user: %v", err)
+	}
+	product := fixtures.NewProduct().WithPrice(5).WithStock(4).Build()
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	if _, err := service.Checkout(ctx, user.Email); !errors.Is(err, ErrEmptyCart) {
+		t.Errorf("Checkout of no cart = %v, want ErrEmptyCart", err)
+	}
+
+	owner := UserCart(user.Email)
+	if _, err := cartRepo.Add(ctx, owner, product.ProductID, 2); err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+	cart, err := cartRepo.Add(ctx, owner, product.ProductID, 1)
+	if err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+	// One hold lapsing puts its unit back on sale, so checkout takes it
+	// from stock again
+	lapsed := cart.Items[0].HoldIDs[1]
+	if err := productRepo.ReleaseHold(ctx, product.ProductID, lapsed); err != nil {
+		t.Fatalf("Failed to release hold: %v", err)
+	}
+
+	order, err := service.Checkout(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to check out: %v", err)
+	}
+	if order.Total != 15 || len(order.Items) != 1 || order.Items[0].Quantity != 3 {
+		t.Errorf("Order = %.2f %+v, want 3 units for 15.00", order.Total, order.Items)
+	}
+	stored, err := productRepo.Get(ctx, product.ProductID)
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if stored.Stock != 1 {
+		t.Errorf("Stock = %d, want 1 with 3 sold", stored.Stock)
+	}
+	hold, err := productRepo.GetHold(ctx, product.ProductID, cart.Items[0].HoldIDs[0])
+	if err != nil || hold.Status != models.HoldStatusConsumed {
+		t.Errorf("Hold = %+v, %v, want it consumed", hold, err)
+	}
+	if cart, _ := cartRepo.Get(ctx, owner); len(cart.Items) != 0 {
+		t.Errorf("Cart after checkout = %+v, want it empty", cart.Items)
+	}
+}
+
+func TestImpersonationRepository(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	impRepo := NewImpersonationRepository(client, tableName, EnforceKeyConsistency(), AuditWrites())
+	auditRepo := NewAuditRepository(client, tableName)
+	ctx := WithSession(context.Background(), "sess-1")
+
+	if _, err := impRepo.Get(ctx, "sess-1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Start = %v, want ErrNotFound", err)
+	}
+	imp, err := impRepo.Start(ctx, "sess-1", "Ann@Example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to start impersonation: %v", err)
+	}
+	if imp.Email != "ann@example.com" || imp.Admin != "session:sess-1" {
+		t.Errorf("Started %+v, want ann@example.com viewed by session:sess-1", imp)
+	}
+	if got, err := impRepo.Get(ctx, "sess-1"); err != nil || got.Email != imp.Email {
+		t.Errorf("Get = %+v, %v, want the started impersonation", got, err)
+	}
+	if _, err := impRepo.Start(ctx, "sess-1", "not an email", time.Hour); !errors.Is(err, models.ErrInvalid) {
+		t.Errorf("Start with a bad email = %v, want a validation error", err)
+	}
+
+	// Audit entries sort by millisecond, so keep Stop's after Start's
+	time.Sleep(2 * time.Millisecond)
+	if err := impRepo.Stop(ctx, "sess-1"); err != nil {
+		t.Fatalf("Failed to stop impersonation: %v", err)
+	}
+	if _, err := impRepo.Get(ctx, "sess-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Stop = %v, want ErrNotFound", err)
+	}
+
+	// An expired impersonation is gone even before TTL deletes it
+	if _, err := impRepo.Start(ctx, "sess-2", "ann@example.com", time.Nanosecond); err != nil {
+		t.Fatalf("Failed to start impersonation: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := impRepo.Get(ctx, "sess-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of an expired impersonation = %v, want ErrNotFound", err)
+	}
+
+	page, err := auditRepo.ForPartition(ctx, Key.SessionPK("sess-1"), nil)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	var actions []models.AuditAction
+	for _, entry := range page.Entries {
+		if entry.EntityType != EntityImpersonation {
+			t.Errorf("Audited %s, want impersonations", entry.EntityType)
+		}
+		actions = append(actions, entry.Action)
+	}
+	if want := []models.AuditAction{models.AuditActionDelete, models.AuditActionPut}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("Audited %v, want %v", actions, want)
+	}
+}
+
+func TestNotificationPrefsRepository(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	prefsRepo := NewNotificationPrefsRepository(client, tableName, EnforceKeyConsistency())
+	ctx := context.Background()
+
+	prefs, err := prefsRepo.Get(ctx, "Ann@Example.com")
+	if err != nil {
+		t.Fatalf("Failed to get prefs: %v", err)
+	}
+	if prefs.UserEmail != "ann@example.com" || !prefs.Emails(models.NotificationOrderCompleted) {
+		t.Errorf("Prefs of a new user = %+v, want everything emailed", prefs)
+	}
+
+	prefs.Email = map[models.NotificationEvent]bool{models.NotificationOrderCompleted: false, models.NotificationOrderConfirmed: true}
+	if err := prefsRepo.Put(ctx, *prefs); err != nil {
+		t.Fatalf("Failed to put prefs: %v", err)
+	}
+	stored, err := prefsRepo.Get(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatalf("Failed to get prefs: %v", err)
+	}
+	if stored.Emails(models.NotificationOrderCompleted) || !stored.Emails(models.NotificationOrderConfirmed) || !stored.Emails(models.NotificationOrderCancelled) {
+		t.Errorf("Stored prefs = %+v, want only completed orders left out", stored.Email)
+	}
+
+	prefs.Email = map[models.NotificationEvent]bool{"order.shipped": false}
+	if err := prefsRepo.Put(ctx, *prefs); !errors.Is(err, models.ErrInvalid) {
+		t.Errorf("Put with an unknown event = %v, want a validation error", err)
+	}
+}
+
+func TestProductRepository_GetMany(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+
+	fixtures.Seed(t, fixtures.Repos{Products: productRepo},
+		fixtures.NewProduct().WithID("PROD1"),
+		fixtures.NewProduct().WithID("PROD2"),
+	)
+
+	got, err := productRepo.GetMany(context.Background(), []string{"PROD1", "PROD2", "PROD1", "MISSING"})
+	if err != nil {
+		t.Fatalf("Failed to get products: %v", err)
+	}
+	if len(got) != 2 || got["PROD1"].ProductID != "PROD1" || got["PROD2"].ProductID != "PROD2" {
+		t.Errorf("Unexpected products %+v", got)
+	}
+}
+
+func TestJobRepository(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	jobRepo := NewJobRepository(client, tableName)
+	ctx := context.Background()
+
+	job, err := jobRepo.Enqueue(ctx, "email.send", `{}`, time.Now().Add(-time.Second), 2)
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+	if _, err := jobRepo.Enqueue(ctx, "email.send", `{}`, time.Now().Add(time.Hour), 2); err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	// Test only the visible job is claimed, and only once
+	claimed, err := jobRepo.Claim(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if claimed == nil || claimed.JobID != job.JobID || claimed.Attempts != 1 {
+		t.Fatalf("Claimed %+v, want job %s on attempt 1", claimed, job.JobID)
+	}
+	if again, err := jobRepo.Claim(ctx, time.Minute); err != nil || again != nil {
+		t.Errorf("Claim() = %+v, %v, want nothing while leased", again, err)
+	}
+
+	// Test a stale copy can't finish the job after it's been reclaimed
+	if err := jobRepo.Fail(ctx, *claimed, errors.New("boom"), -time.Second); err != nil {
+		t.Fatalf("Failed to fail job: %v", err)
+	}
+	retried, err := jobRepo.Claim(ctx, time.Minute)
+	if err != nil || retried == nil || retried.Attempts != 2 {
+		t.Fatalf("Claim() = %+v, %v, want the job on attempt 2", retried, err)
+	}
+	if err := jobRepo.Complete(ctx, *claimed); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Complete() with a stale job = %v, want ErrLeaseLost", err)
+	}
+
+	if err := jobRepo.Complete(ctx, *retried); err != nil {
+		t.Fatalf("Failed to complete job: %v", err)
+	}
+	done, err := jobRepo.Get(ctx, job.JobID)
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if done.Status != models.JobStatusDone || done.LastError != "" {
+		t.Errorf("Job = %+v, want done", done)
+	}
+}
+
+func TestOrderRepository_Transition(t *testing.T) {
+	_, _, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	order := models.Order{
+		OrderID:   "ORD1",
+		UserEmail: "test@example.com",
+		Status:    models.OrderStatusPending,
+		Total:     10,
+		Items:     pricedItems(10, "PROD1"),
+		CreatedAt: time.Now(),
+	}
+	if err := orderRepo.Put(ctx, order); err != nil {
+		t.Fatalf("Failed to put order: %v", err)
+	}
+
+	updated, err := orderRepo.Transition(ctx, order.UserEmail, order.OrderID, models.OrderStatusPending, models.OrderStatusProcessing)
+	if err != nil {
+		t.Fatalf("Failed to transition order: %v", err)
+	}
+	if updated.Status != models.OrderStatusProcessing {
+		t.Errorf("Status = %s, want %s", updated.Status, models.OrderStatusProcessing)
+	}
+
+	// Test the same transition can't be applied twice
+	_, err = orderRepo.Transition(ctx, order.UserEmail, order.OrderID, models.OrderStatusPending, models.OrderStatusProcessing)
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Repeated transition error = %v, want ErrConditionFailed", err)
+	}
+
+	// Test moves the status machine doesn't allow are rejected
+	_, err = orderRepo.Transition(ctx, order.UserEmail, order.OrderID, models.OrderStatusCompleted, models.OrderStatusPending)
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Backwards transition error = %v, want ErrInvalidTransition", err)
+	}
+}
+
+func TestUserRepository_GetStats(t *testing.T) {
+	_, _, userRepo, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	email := "stats@example.com"
+
+	stats, err := userRepo.GetStats(ctx, email)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.OrderCount != 0 || len(stats.LifetimeSpend) != 0 {
+		t.Errorf("Stats before any order = %+v, want zero", stats)
+	}
+
+	last := time.Now().Truncate(time.Second)
+	orders := []models.Order{
+		{OrderID: "ORD3", UserEmail: email, Status: models.OrderStatusPending, Total: 8, Currency: "EUR", Items: pricedItems(8, "P3"), CreatedAt: last.Add(-2 * time.Hour)},
+		{OrderID: "ORD1", UserEmail: email, Status: models.OrderStatusPending, Total: 10.5, Items: pricedItems(10.5, "P1"), CreatedAt: last.Add(-time.Hour)},
+		{OrderID: "ORD2", UserEmail: email, Status: models.OrderStatusPending, Total: 4.5, Items: pricedItems(4.5, "P2"), CreatedAt: last},
+	}
+	for _, order := range orders {
+		if err := orderRepo.Put(ctx, order); err != nil {
+			t.Fatalf("Failed to put order: %v", err)
+		}
+	}
+	// Test saving an existing order again isn't counted twice
+	orders[2].Status = models.OrderStatusProcessing
+	if err := orderRepo.Put(ctx, orders[2]); err != nil {
+		t.Fatalf("Failed to update order: %v", err)
+	}
+
+	stats, err = userRepo.GetStats(ctx, email)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	// Test spend in different currencies is kept apart
+	wantSpend := map[string]float64{"USD": 15, "EUR": 8}
+	if stats.OrderCount != 3 || !reflect.DeepEqual(stats.LifetimeSpend, wantSpend) || !stats.LastOrderAt.Equal(last) {
+		t.Errorf("Stats = %+v, want 3 orders, %v spent, last at %v", stats, wantSpend, last)
+	}
+	updated, err := orderRepo.Get(ctx, email, "ORD2")
+	if err != nil || updated.Status != models.OrderStatusProcessing {
+		t.Errorf("Order after update = %+v, %v", updated, err)
+	}
+}
+
+func TestReportRepository_GetSalesRange(t *testing.T) {
+	client, tableName, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	reportRepo := NewReportRepository(client, tableName)
+	ctx := context.Background()
+
+	for _, order := range []models.Order{
+		{OrderID: "ORD1", UserEmail: "sales@example.com", Status: models.OrderStatusPending, Total: 20, Items: pricedItems(20, "P1"), CreatedAt: time.Now()},
+		{OrderID: "ORD2", UserEmail: "sales@example.com", Status: models.OrderStatusPending, Total: 1500, Currency: "JPY", Items: pricedItems(1500, "P2"), CreatedAt: time.Now()},
+	} {
+		if err := orderRepo.Put(ctx, order); err != nil {
+			t.Fatalf("Failed to put order: %v", err)
+		}
+		for _, step := range [][2]models.OrderStatus{
+			{models.OrderStatusPending, models.OrderStatusProcessing},
+			{models.OrderStatusProcessing, models.OrderStatusCompleted},
+		} {
+			if _, err := orderRepo.Transition(ctx, order.UserEmail, order.OrderID, step[0], step[1]); err != nil {
+				t.Fatalf("Failed to transition order: %v", err)
+			}
+		}
+	}
+
+	// The range spans a month boundary, so it needs more than one partition
+	today := time.Now().UTC()
+	sales, err := reportRepo.GetSalesRange(ctx, today.AddDate(0, 0, -40), today)
+	if err != nil {
+		t.Fatalf("Failed to get sales: %v", err)
+	}
+	if len(sales) != 41 {
+		t.Fatalf("Got %d days, want 41", len(sales))
+	}
+	last := sales[len(sales)-1]
+	wantRevenue := map[string]float64{"USD": 20, "JPY": 1500}
+	if last.Date != today.Format(time.DateOnly) || last.OrderCount != 2 || !reflect.DeepEqual(last.Revenue, wantRevenue) {
+		t.Errorf("Today's sales = %+v, want 2 orders worth %v", last, wantRevenue)
+	}
+	if sales[0].OrderCount != 0 {
+		t.Errorf("Expected no sales on %s, got %+v", sales[0].Date, sales[0])
+	}
+}
+
+func TestCurrencyTotals(t *testing.T) {
+	raw := map[string]types.AttributeValue{
+		"revenue":     &types.AttributeValueMemberN{Value: "5"},
+		"revenue_USD": &types.AttributeValueMemberN{Value: "10"},
+		"revenue_EUR": &types.AttributeValueMemberN{Value: "2.5"},
+		"order_count": &types.AttributeValueMemberN{Value: "3"},
+	}
+	totals, err := currencyTotals(raw, revenueAttribute)
+	if err != nil {
+		t.Fatalf("currencyTotals: %v", err)
+	}
+	// Test the counter from before currencies counts as the default one
+	want := map[string]float64{"USD": 15, "EUR": 2.5}
+	if !reflect.DeepEqual(totals, want) {
+		t.Errorf("Totals = %v, want %v", totals, want)
+	}
+}
+
+func TestDashboardQueries(t *testing.T) {
+	client, tableName, _, orderRepo, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	tableRepo := NewTableRepository(client, tableName)
+	ctx := context.Background()
+
+	now := time.Now()
+	for i, id := range []string{"ORD1", "ORD2", "ORD3"} {
+		order := models.Order{
+			OrderID:   id,
+			UserEmail: "recent@example.com",
+			Status:    models.OrderStatusPending,
+			Total:     5,
+			Items:     pricedItems(5, "P1"),
+			CreatedAt: now.Add(time.Duration(i-48) * time.Hour),
+		}
+		if err := orderRepo.Put(ctx, order); err != nil {
+			t.Fatalf("Failed to put order: %v", err)
+		}
+	}
+	for _, stock := range []int{1, 50} {
+		product := models.Product{ProductID: fmt.Sprintf("P%d", stock), Category: "Toys", Name: "Top", Price: 1, Stock: stock, LowStockThreshold: 5}
+		if err := productRepo.Put(ctx, product); err != nil {
+			t.Fatalf("Failed to put product: %v", err)
+		}
+	}
+
+	// Test recent orders come back newest first, across days
+	recent, err := orderRepo.Recent(ctx, 2)
+	if err != nil {
+		t.Fatalf("Failed to get recent orders: %v", err)
+	}
+	if len(recent) != 2 || recent[0].OrderID != "ORD3" || recent[1].OrderID != "ORD2" {
+		t.Errorf("Recent orders = %+v, want ORD3, ORD2", recent)
+	}
+
+	low, err := productRepo.LowStock(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to get low stock products: %v", err)
+	}
+	if len(low.Products) != 1 || low.Products[0].ProductID != "P1" {
+		t.Errorf("Low stock = %+v, want P1", low.Products)
+	}
+
+	counts, err := tableRepo.CountByEntityType(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	if counts[EntityOrder] != 3 || counts[EntityProduct] != 2 || counts[EntityUserStats] != 1 {
+		t.Errorf("Counts = %v", counts)
+	}
+}
+
+func TestProductRepository_ReserveStock(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	var alerted []models.Product
+	productRepo.OnLowStock(func(ctx context.Context, product models.Product) {
+		alerted = append(alerted, product)
+	})
+
+	product := fixtures.NewProduct().WithStock(12).Build()
+	product.LowStockThreshold = 10
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	// Test the alert fires once, when the threshold is crossed
+	for _, quantity := range []int{2, 1, 1} {
+		if _, err := productRepo.ReserveStock(ctx, product.ProductID, quantity); err != nil {
+			t.Fatalf("Failed to reserve stock: %v", err)
+		}
+	}
+	if len(alerted) != 1 || alerted[0].Stock != 9 {
+		t.Errorf("Alerts = %+v, want one at stock 9", alerted)
+	}
+
+	if _, err := productRepo.ReserveStock(ctx, product.ProductID, 100); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("Over-reserving error = %v, want ErrInsufficientStock", err)
+	}
+
+	// Test the product shows up in the low stock index
+	var low *ProductsPage
+	for i := 0; i < 10; i++ {
+		var err error
+		if low, err = productRepo.LowStock(ctx, nil); err != nil {
+			t.Fatalf("Failed to get low stock products: %v", err)
+		}
+		if len(low.Products) > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(low.Products) != 1 || low.Products[0].Stock != 8 {
+		t.Errorf("Low stock = %+v, want the product at stock 8", low.Products)
+	}
+}
+
+func TestProductRepository_SetImageURL(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	product := fixtures.NewProduct().WithStock(5).Build()
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	// Test stock taken while the image was uploading is kept
+	if _, err := productRepo.ReserveStock(ctx, product.ProductID, 2); err != nil {
+		t.Fatalf("Failed to reserve stock: %v", err)
+	}
+	if err := productRepo.SetImageURL(ctx, product.ProductID, "/images/mug.png"); err != nil {
+		t.Fatalf("Failed to set image: %v", err)
+	}
+	got, err := productRepo.Get(ctx, product.ProductID)
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.ImageURL != "/images/mug.png" || got.Stock != 3 {
+		t.Errorf("Product = %q with stock %d, want the image and stock 3", got.ImageURL, got.Stock)
+	}
+
+	if err := productRepo.SetImageURL(ctx, "MISSING", "/images/x.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Setting a missing product's image = %v, want ErrNotFound", err)
+	}
+}
+
+func TestProductRepository_Featured(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	for _, name := range []string{"Yoyo", "Kite", "Ball"} {
+		product := models.Product{ProductID: "P" + name, Category: "Toys", Name: name, Price: 1, Stock: 10, Featured: name != "Kite"}
+		if err := productRepo.Put(ctx, product); err != nil {
+			t.Fatalf("Failed to put product: %v", err)
+		}
+	}
+
+	// Test only featured products are returned, in name order
+	featured, err := productRepo.Featured(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to get featured products: %v", err)
+	}
+	if len(featured.Products) != 2 || featured.Products[0].Name != "Ball" || featured.Products[1].Name != "Yoyo" {
+		t.Errorf("Featured = %+v, want Ball, Yoyo", featured.Products)
+	}
+
+	// Test unfeaturing a product drops it from the sparse index
+	unfeatured := featured.Products[0]
+	unfeatured.Featured = false
+	if err := productRepo.Put(ctx, unfeatured); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	if featured, err = productRepo.Featured(ctx, nil); err != nil {
+		t.Fatalf("Failed to get featured products: %v", err)
+	}
+	if len(featured.Products) != 1 || featured.Products[0].Name != "Yoyo" {
+		t.Errorf("Featured = %+v, want Yoyo", featured.Products)
+	}
+}
+
+func TestProductRepository_Holds(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	product := fixtures.NewProduct().WithStock(10).Build()
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	// Test holds count against stock
+	kept, err := productRepo.Hold(ctx, product.ProductID, "cart-1", 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to hold stock: %v", err)
+	}
+	expired, err := productRepo.Hold(ctx, product.ProductID, "cart-2", 4, -time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to hold stock: %v", err)
+	}
+	if _, err := productRepo.Hold(ctx, product.ProductID, "cart-3", 4, time.Hour); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("Over-holding error = %v, want ErrInsufficientStock", err)
+	}
+	got, err := productRepo.Get(ctx, product.ProductID)
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.Stock != 3 {
+		t.Errorf("Stock = %d, want 3", got.Stock)
+	}
+
+	// Test the reconciliation returns only the expired hold's stock
+	released, err := productRepo.ReleaseExpiredHolds(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("Failed to release expired holds: %v", err)
+	}
+	if released != 1 {
+		t.Errorf("Released = %d, want 1", released)
+	}
+	if got, err = productRepo.Get(ctx, product.ProductID); err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.Stock != 7 {
+		t.Errorf("Stock = %d, want 7", got.Stock)
+	}
+	if _, err := productRepo.ConsumeHold(ctx, product.ProductID, expired.HoldID); !errors.Is(err, ErrHoldNotActive) {
+		t.Errorf("Consuming a released hold error = %v, want ErrHoldNotActive", err)
+	}
+
+	// Test a consumed hold keeps its stock, and releasing it is a no-op
+	if _, err := productRepo.ConsumeHold(ctx, product.ProductID, kept.HoldID); err != nil {
+		t.Fatalf("Failed to consume hold: %v", err)
+	}
+	if err := productRepo.ReleaseHold(ctx, product.ProductID, kept.HoldID); err != nil {
+		t.Fatalf("Failed to release hold: %v", err)
+	}
+	if got, err = productRepo.Get(ctx, product.ProductID); err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.Stock != 7 {
+		t.Errorf("Stock = %d, want 7", got.Stock)
+	}
+}
+
+func TestPaymentRepository_ForOrder(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	paymentRepo := NewPaymentRepository(client, tableName, EnforceKeyConsistency())
+
+	now := time.Now()
+	payments := []models.Payment{
+		{PaymentID: "PAY1", OrderID: "ORD1", Kind: models.PaymentKindCharge, Amount: 50, Provider: "fake", ProviderRef: "ch_1", Status: models.PaymentStatusSucceeded, CreatedAt: now, UpdatedAt: now},
+		{PaymentID: "PAY2", OrderID: "ORD1", Kind: models.PaymentKindRefund, Amount: 20, Provider: "fake", Status: models.PaymentStatusPending, RefundOf: "PAY1", CreatedAt: now, UpdatedAt: now},
+		{PaymentID: "PAY3", OrderID: "ORD2", Kind: models.PaymentKindCharge, Amount: 5, Provider: "fake", Status: models.PaymentStatusFailed, CreatedAt: now, UpdatedAt: now},
+	}
+	for _, payment := range payments {
+		if err := paymentRepo.Put(ctx, payment); err != nil {
+			t.Fatalf("Failed to put payment: %v", err)
+		}
+	}
+
+	// Test a refund must say which charge it pays back
+	refund := payments[1]
+	refund.RefundOf = ""
+	if err := paymentRepo.Put(ctx, refund); err == nil {
+		t.Error("expected a refund without RefundOf to fail validation")
+	}
+
+	got, err := paymentRepo.ForOrder(ctx, "ORD1")
+	if err != nil {
+		t.Fatalf("Failed to get payments: %v", err)
+	}
+	if len(got) != 2 || got[0].PaymentID != "PAY1" || got[1].RefundOf != "PAY1" {
+		t.Errorf("Payments = %+v, want PAY1 and its refund", got)
+	}
+}
+
+func TestOrderRepository_Activity(t *testing.T) {
+	client, tableName, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	service := NewOrderService(client, tableName, EnforceKeyConsistency())
+	paymentRepo := NewPaymentRepository(client, tableName)
+
+	now := time.Now()
+	order := models.Order{OrderID: "ORD1", UserEmail: "log@example.com", Status: models.OrderStatusPending, Total: 20, Items: pricedItems(20, "P1"), CreatedAt: now}
+	if err := orderRepo.Put(ctx, order); err != nil {
+		t.Fatalf("Failed to put order: %v", err)
+	}
+	charge := models.Payment{PaymentID: "CH1", OrderID: "ORD1", Kind: models.PaymentKindCharge, Amount: 20, Provider: "fake", Status: models.PaymentStatusSucceeded, CreatedAt: now, UpdatedAt: now}
+	if err := paymentRepo.Put(ctx, charge); err != nil {
+		t.Fatalf("Failed to put payment: %v", err)
+	}
+
+	activity, err := orderRepo.Activity(ctx, "ORD1")
+	if err != nil || len(activity.Payments) != 1 || len(activity.Log) != 0 {
+		t.Fatalf("Activity before any change = %+v, %v, want just the charge", activity, err)
+	}
+
+	if _, err := orderRepo.Transition(ctx, "log@example.com", "ORD1", models.OrderStatusPending, models.OrderStatusProcessing); err != nil {
+		t.Fatalf("Failed to transition order: %v", err)
+	}
+	// Keys are ordered to the millisecond
+	time.Sleep(2 * time.Millisecond)
+	if _, err := service.Cancel(ctx, "log@example.com", "ORD1", "out of stock"); err != nil {
+		t.Fatalf("Failed to cancel order: %v", err)
+	}
+
+	activity, err = orderRepo.Activity(ctx, "ORD1")
+	if err != nil {
+		t.Fatalf("Failed to get activity: %v", err)
+	}
+	if len(activity.Payments) != 2 {
+		t.Errorf("Payments = %+v, want the charge and its refund", activity.Payments)
+	}
+	var got []string
+	for _, entry := range activity.Log {
+		got = append(got, entry.Event+" "+string(entry.Status)+" "+entry.Note)
+	}
+	want := []string{
+		EventOrderStatusChanged + " processing ",
+		EventOrderCancelled + " cancelled out of stock",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Log = %q, want %q", got, want)
+	}
+
+	// A failed transition logs nothing
+	if _, err := orderRepo.Transition(ctx, "log@example.com", "ORD1", models.OrderStatusProcessing, models.OrderStatusCompleted); !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Transition from a stale status = %v, want ErrConditionFailed", err)
+	}
+	if activity, _ := orderRepo.Activity(ctx, "ORD1"); len(activity.Log) != 2 {
+		t.Errorf("Log after a failed transition has %d entries, want 2", len(activity.Log))
+	}
+}
+
+func TestOrderService_CancelAndRefund(t *testing.T) {
+	client, tableName, _, orderRepo, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	service := NewOrderService(client, tableName, EnforceKeyConsistency())
+	paymentRepo := NewPaymentRepository(client, tableName)
+	outboxRepo := NewOutboxRepository(client, tableName)
+
+	product := fixtures.NewProduct().WithStock(5).Build()
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	now := time.Now()
+	orders := []models.Order{
+		{OrderID: "ORD1", UserEmail: "cancel@example.com", Status: models.OrderStatusProcessing, Total: 40, Items: pricedItems(40, product.ProductID, product.ProductID), CreatedAt: now},
+		{OrderID: "ORD2", UserEmail: "cancel@example.com", Status: models.OrderStatusCompleted, Total: 30, Items: pricedItems(30, product.ProductID), CreatedAt: now},
+	}
+	for _, order := range orders {
+		if err := orderRepo.Put(ctx, order); err != nil {
+			t.Fatalf("Failed to put order: %v", err)
+		}
+		charge := models.Payment{PaymentID: "CH-" + order.OrderID, OrderID: order.OrderID, Kind: models.PaymentKindCharge, Amount: order.Total, Provider: "fake", ProviderRef: "ch_1", Status: models.PaymentStatusSucceeded, CreatedAt: now, UpdatedAt: now}
+		if err := paymentRepo.Put(ctx, charge); err != nil {
+			t.Fatalf("Failed to put payment: %v", err)
+		}
+	}
+
+	// Test cancelling restocks, refunds the charge and writes an event
+	cancelled, err := service.Cancel(ctx, "cancel@example.com", "ORD1", "changed my mind")
+	if err != nil {
+		t.Fatalf("Failed to cancel order: %v", err)
+	}
+	if cancelled.Status != models.OrderStatusCancelled {
+		t.Errorf("Status = %s, want cancelled", cancelled.Status)
+	}
+	got, err := productRepo.Get(ctx, product.ProductID)
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.Stock != 7 {
+		t.Errorf("Stock = %d, want 7", got.Stock)
+	}
+	payments, err := paymentRepo.ForOrder(ctx, "ORD1")
+	if err != nil {
+		t.Fatalf("Failed to get payments: %v", err)
+	}
+	var refunded float64
+	for _, payment := range payments {
+		if payment.Kind == models.PaymentKindRefund && payment.Status == models.PaymentStatusPending {
+			refunded += payment.Amount
+		}
+	}
+	if refunded != 40 {
+		t.Errorf("Refunded = %.2f, want 40", refunded)
+	}
+	events, _, err := outboxRepo.Pending(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to get outbox events: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != EventOrderCancelled || events[0].AggregateID != "ORD1" {
+		t.Errorf("Events = %+v, want one order.cancelled", events)
+	}
+
+	// Test completed orders can't be cancelled, only refunded
+	if _, err := service.Cancel(ctx, "cancel@example.com", "ORD2", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Cancelling a completed order error = %v, want ErrInvalidTransition", err)
+	}
+	if _, err := service.Refund(ctx, "cancel@example.com", "ORD2", 10, "damaged"); err != nil {
+		t.Fatalf("Failed to refund order: %v", err)
+	}
+	if _, err := service.Refund(ctx, "cancel@example.com", "ORD2", 25, "damaged"); !errors.Is(err, ErrRefundTooLarge) {
+		t.Errorf("Over-refunding error = %v, want ErrRefundTooLarge", err)
+	}
+
+	// Test published events leave the pending index
+	if err := outboxRepo.MarkPublished(ctx, events[0]); err != nil {
+		t.Fatalf("Failed to mark event published: %v", err)
+	}
+	if events, _, err = outboxRepo.Pending(ctx, nil); err != nil {
+		t.Fatalf("Failed to get outbox events: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != EventOrderRefunded {
+		t.Errorf("Events = %+v, want one order.refunded", events)
+	}
+}
+
+func TestCouponRepository_Redeem(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	couponRepo := NewCouponRepository(client, tableName, EnforceKeyConsistency())
+
+	coupon := models.Coupon{Code: "save10", PercentOff: 10, MinTotal: 20, MaxRedemptions: 2, CreatedAt: time.Now()}
+	if err := couponRepo.Create(ctx, coupon); err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+	if err := couponRepo.Create(ctx, coupon); !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Creating a duplicate code error = %v, want ErrConditionFailed", err)
+	}
+
+	// Test checking quotes the discount without redeeming
+	_, discount, err := couponRepo.Check(ctx, "SAVE10", "a@example.com", 50)
+	if err != nil {
+		t.Fatalf("Failed to check coupon: %v", err)
+	}
+	if discount != 5 {
+		t.Errorf("Discount = %.2f, want 5", discount)
+	}
+	if _, _, err := couponRepo.Check(ctx, "SAVE10", "a@example.com", 10); !errors.Is(err, ErrCouponBelowMinimum) {
+		t.Errorf("Below minimum error = %v, want ErrCouponBelowMinimum", err)
+	}
+
+	// Test a user can only redeem once, and the cap holds across users
+	if _, err := couponRepo.Redeem(ctx, "save10", "a@example.com", "ORD1", 50); err != nil {
+		t.Fatalf("Failed to redeem coupon: %v", err)
+	}
+	if _, err := couponRepo.Redeem(ctx, "save10", "a@example.com", "ORD2", 50); !errors.Is(err, ErrCouponAlreadyRedeemed) {
+		t.Errorf("Second redemption error = %v, want ErrCouponAlreadyRedeemed", err)
+	}
+	if _, err := couponRepo.Redeem(ctx, "save10", "b@example.com", "ORD3", 50); err != nil {
+		t.Fatalf("Failed to redeem coupon: %v", err)
+	}
+	if _, err := couponRepo.Redeem(ctx, "save10", "c@example.com", "ORD4", 50); !errors.Is(err, ErrCouponExhausted) {
+		t.Errorf("Redemption over the cap error = %v, want ErrCouponExhausted", err)
+	}
+
+	got, err := couponRepo.Get(ctx, "save10")
+	if err != nil {
+		t.Fatalf("Failed to get coupon: %v", err)
+	}
+	if got.Redemptions != 2 {
+		t.Errorf("Redemptions = %d, want 2", got.Redemptions)
+	}
+}
+
+func TestDiffItems(t *testing.T) {
+	before, err := attributevalue.MarshalMap(GenericItem[models.Product]{
+		PK: "PRODUCT", SK: "PRODUCT#P1", EntityType: EntityProduct,
+		Data: models.Product{ProductID: "P1", Name: "Old", Stock: 5},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal before: %v", err)
+	}
+	after, err := attributevalue.MarshalMap(GenericItem[models.Product]{
+		PK: "PRODUCT", SK: "PRODUCT#P1", EntityType: EntityProduct,
+		Data: models.Product{ProductID: "P1", Name: "New", Stock: 5},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal after: %v", err)
+	}
+
+	changes, err := diffItems(before, after)
+	if err != nil {
+		t.Fatalf("Failed to diff items: %v", err)
+	}
+	want := []models.AuditChange{{Field: "data.name", Before: `"Old"`, After: `"New"`}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Changes = %+v, want %+v", changes, want)
+	}
+
+	// Test a new item lists every attribute as added
+	changes, err = diffItems(nil, after)
+	if err != nil {
+		t.Fatalf("Failed to diff items: %v", err)
+	}
+	for _, change := range changes {
+		if change.Before != "" || change.After == "" {
+			t.Errorf("Change to new item = %+v, want only an after value", change)
+		}
+	}
+}
+
+func TestAuditClock(t *testing.T) {
+	clock := &monotonicClock{}
+	now := time.UnixMilli(1700000000123)
+	first, second := clock.next(now), clock.next(now)
+	if !first.Equal(now) || !second.After(first) {
+		t.Errorf("Times in one tick = %v, %v, want %v and then later", first, second, now)
+	}
+	// Test entries in the same millisecond sort by time, not by their IDs
+	if Key.AuditSK(first, "Z") >= Key.AuditSK(second, "A") {
+		t.Errorf("AuditSK %s sorts after %s", Key.AuditSK(first, "Z"), Key.AuditSK(second, "A"))
+	}
+}
+
+func TestAuditWrites(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := WithActor(context.Background(), "admin@example.com")
+	productRepo := NewProductRepository(client, tableName, AuditWrites())
+	auditRepo := NewAuditRepository(client, tableName)
+
+	product := fixtures.NewProduct().WithName("Audited").Build()
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	product.Stock--
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	page, err := auditRepo.ForPartition(ctx, Key.ProductPK(), nil)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if len(page.Entries) != 2 {
+		t.Fatalf("Got %d audit entries, want 2", len(page.Entries))
+	}
+	latest := page.Entries[0]
+	if latest.Actor != "admin@example.com" || latest.EntityType != EntityProduct {
+		t.Errorf("Latest entry = %+v, want a product put by admin@example.com", latest)
+	}
+	want := []models.AuditChange{{Field: "data.stock", Before: "100", After: "99"}}
+	if !reflect.DeepEqual(latest.Changes, want) {
+		t.Errorf("Changes = %+v, want %+v", latest.Changes, want)
+	}
+
+	byDay, err := auditRepo.ByDay(ctx, time.Now(), nil)
+	if err != nil {
+		t.Fatalf("Failed to read audit log by day: %v", err)
+	}
+	if len(byDay.Entries) != 2 {
+		t.Errorf("Got %d audit entries for today, want 2", len(byDay.Entries))
+	}
+}
+
+func TestAuditWrites_ConcurrentChange(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	plain := NewProductRepository(client, tableName)
+
+	product := fixtures.NewProduct().WithID("RACE").Build()
+	if err := plain.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	// Test a write landing between the before image read and the audited
+	// transaction makes the transaction retry against the new image
+	interleaved := false
+	audited := NewProductRepository(testutil.CreateTestClient(t, dynamoclient.WithMiddleware(func(stack *smithymiddleware.Stack) error {
+		return stack.Initialize.Add(smithymiddleware.InitializeMiddlewareFunc("Interleave", func(
+			ctx context.Context, in smithymiddleware.InitializeInput, next smithymiddleware.InitializeHandler,
+		) (smithymiddleware.InitializeOutput, smithymiddleware.Metadata, error) {
+			if _, ok := in.Parameters.(*dynamodb.TransactWriteItemsInput); ok && !interleaved {
+				interleaved = true
+				other := product
+				other.Stock = 50
+				if err := plain.Put(ctx, other); err != nil {
+					t.Errorf("Failed to interleave a write: %v", err)
+				}
+			}
+			return next.HandleInitialize(ctx, in)
+		}), smithymiddleware.Before)
+	})), tableName, AuditWrites())
+	product.Stock = 99
+	if err := audited.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	page, err := NewAuditRepository(client, tableName).ForPartition(ctx, Key.ProductPK(), nil)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if len(page.Entries) != 1 {
+		t.Fatalf("Got %d audit entries, want 1", len(page.Entries))
+	}
+	want := []models.AuditChange{{Field: "data.stock", Before: "50", After: "99"}}
+	if !reflect.DeepEqual(page.Entries[0].Changes, want) {
+		t.Errorf("Changes = %+v, want %+v", page.Entries[0].Changes, want)
+	}
+}
+
+func TestChangeHooks_Typed(t *testing.T) {
+	var got []string
+	s := NewStore(nil, "test", OnPut(Typed(EntityProduct, func(ctx context.Context, change Change, item GenericItem[models.Product]) {
+		got = append(got, fmt.Sprintf("%d %s", change.Phase, item.Data.Name))
+	})))
+
+	product, err := attributevalue.MarshalMap(productItem(fixtures.NewProduct().WithName("Widget").Build()))
+	if err != nil {
+		t.Fatalf("Failed to marshal product: %v", err)
+	}
+	user, err := attributevalue.MarshalMap(GenericItem[models.User]{PK: "USER#a", SK: "USER#a", EntityType: EntityUser})
+	if err != nil {
+		t.Fatalf("Failed to marshal user: %v", err)
+	}
+	changes := s.changesOf([]types.TransactWriteItem{{Put: &types.Put{Item: product}}, {Put: &types.Put{Item: user}}}, true)
+	s.runChangeHooks(context.Background(), BeforeWrite, changes)
+	s.runChangeHooks(context.Background(), AfterWrite, changes)
+
+	want := []string{"0 Widget", "1 Widget"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Hook calls = %v, want %v", got, want)
+	}
+	if !changes[0].Transactional || changes[0].PK != Key.ProductPK() {
+		t.Errorf("Change = %+v, want a transactional product put", changes[0])
+	}
+}
+
+func TestStore_ChangeHooks(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	var changes []Change
+	record := func(ctx context.Context, change Change) { changes = append(changes, change) }
+	store := NewStore(client, tableName, OnPut(record), OnDelete(record))
+
+	item := productItem(fixtures.NewProduct().Build())
+	if err := PutItem(ctx, store, item); err != nil {
+		t.Fatalf("Failed to put item: %v", err)
+	}
+	if err := DeleteItem(ctx, store, item.PK, item.SK); err != nil {
+		t.Fatalf("Failed to delete item: %v", err)
+	}
+	// Deleting it again removes nothing, so only the before hook runs
+	if err := DeleteItem(ctx, store, item.PK, item.SK); err != nil {
+		t.Fatalf("Failed to delete missing item: %v", err)
+	}
+
+	var got []string
+	for _, change := range changes {
+		got = append(got, fmt.Sprintf("%s %d %s", change.Operation, change.Phase, change.EntityType))
+	}
+	want := []string{
+		"put 0 PRODUCT", "put 1 PRODUCT",
+		"delete 0 ", "delete 1 PRODUCT",
+		"delete 0 ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes = %v, want %v", got, want)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.record(true)
+	if !breaker.allow() {
+		t.Fatal("Breaker refused a call before reaching the threshold")
+	}
+	breaker.record(true)
+	if breaker.allow() || !breaker.Open() {
+		t.Fatal("Breaker allowed a call after reaching the threshold")
+	}
+
+	// Test one trial call is let through after the cooldown
+	now = now.Add(time.Minute)
+	if !breaker.allow() {
+		t.Fatal("Breaker refused the trial call after the cooldown")
+	}
+	if breaker.allow() {
+		t.Error("Breaker allowed a second call during the trial")
+	}
+	breaker.record(false)
+	if !breaker.allow() || breaker.Open() {
+		t.Error("Breaker stayed open after a successful trial")
+	}
+}
+
+func TestStore_Guards(t *testing.T) {
+	// A server that never answers, standing in for a hung DynamoDB
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(hung.URL),
+		Credentials:      credentials.NewStaticCredentialsProvider("dummy", "dummy", ""),
+		RetryMaxAttempts: 1,
+	})
+
+	breaker := NewCircuitBreaker(1, time.Minute)
+	store := NewStore(client, "test", OperationTimeouts(Timeouts{Default: 50 * time.Millisecond}), WithCircuitBreaker(breaker))
+
+	start := time.Now()
+	_, err := getRawItem(context.Background(), store, "PK", "SK")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Error = %v, want a deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Call took %v, want it cut off by the timeout", elapsed)
+	}
+
+	// Test the failure opened the breaker, so the next call isn't sent
+	if _, err := getRawItem(context.Background(), store, "PK", "SK"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Error = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestStore_ReadOnly(t *testing.T) {
+	// Nothing listens here, so any write that got sent would fail differently
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String("http://127.0.0.1:1"),
+		Credentials:      credentials.NewStaticCredentialsProvider("dummy", "dummy", ""),
+		RetryMaxAttempts: 1,
+	})
+	sw := NewReadOnlySwitch(true)
+	breaker := NewCircuitBreaker(1, time.Minute)
+	store := NewStore(client, "test", WithReadOnlySwitch(sw), WithCircuitBreaker(breaker))
+	ctx := context.Background()
+
+	item := productItem(fixtures.NewProduct().Build())
+	writes := map[string]error{
+		"put":    PutItem(ctx, store, item),
+		"update": UpdateItem(ctx, store, item.PK, item.SK, map[string]any{"stock": 1}),
+		"delete": DeleteItem(ctx, store, item.PK, item.SK),
+	}
+	_, writes["batch"] = BatchPutItems(ctx, store, []GenericItem[models.Product]{item})
+	for name, err := range writes {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s while read-only = %v, want ErrReadOnly", name, err)
+		}
+	}
+	if breaker.Open() {
+		t.Error("Refused writes opened the circuit breaker")
+	}
+
+	// Test turning the switch off lets writes through to DynamoDB again
+	sw.Set(false)
+	if err := PutItem(ctx, store, item); err == nil || errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put after switching off = %v, want it sent and failing to connect", err)
+	}
+}
+
+func TestPageIterator_Prefetch(t *testing.T) {
+	client, tableName, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Test prefetching reads the next page before the caller asks for it
+	fetched := make(chan int, 3)
+	pages := newPageIterator(ctx, nil, func(ctx context.Context, token *PageToken) ([]int, *PageToken, error) {
+		n := 0
+		if token != nil {
+			n, _ = strconv.Atoi(token.Raw()["n"].(*types.AttributeValueMemberS).Value)
+		}
+		fetched <- n
+		if n == 2 {
+			return []int{n}, nil, nil
+		}
+		return []int{n}, NewPageToken(map[string]types.AttributeValue{"n": &types.AttributeValueMemberS{Value: strconv.Itoa(n + 1)}}), nil
+	}, []IteratorOption{Prefetch()})
+	defer pages.Close()
+	var got []int
+	for pages.Next() {
+		page := pages.Items()[0]
+		got = append(got, page)
+		for n := -1; page < 2 && n != page+1; {
+			select {
+			case n = <-fetched:
+			case <-time.After(time.Second):
+				t.Fatalf("Page %d wasn't prefetched while page %d was handled", page+1, page)
+			}
+		}
+	}
+	if err := pages.Err(); err != nil || fmt.Sprint(got) != "[0 1 2]" {
+		t.Errorf("Read %v, %v, want [0 1 2]", got, err)
+	}
+
+	// Test iterating over a query's pages through the store
+	user := fixtures.NewUser().Build()
+	for i := range 5 {
+		if err := orderRepo.Put(ctx, fixtures.NewOrderFor(user).WithID(fmt.Sprintf("ORD%d", i)).Build()); err != nil {
+			t.Fatalf("Failed to put order: %v", err)
+		}
+	}
+	orders := IterateData[models.Order](ctx, NewStore(client, tableName), Key.UserPK(user.Email), "ORDER#", &QueryOptions{Limit: 2}, Prefetch())
+	defer orders.Close()
+	count := 0
+	for orders.Next() {
+		count += len(orders.Items())
+	}
+	if err := orders.Err(); err != nil || count != 5 {
+		t.Errorf("Read %d orders, %v, want 5", count, err)
+	}
+}
+
+func TestUserRepository_Export(t *testing.T) {
+	client, tableName, userRepo, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	addressRepo := NewAddressRepository(client, tableName)
+
+	user := fixtures.NewUser().Build()
+	fixtures.Seed(t, fixtures.Repos{Users: userRepo, Orders: orderRepo},
+		fixtures.NewUser().WithEmail(user.Email).WithName(user.Name),
+		fixtures.NewOrderFor(user).WithID("ORD1"),
+	)
+	address := models.Address{
+		AddressID: "ADDR1", UserEmail: user.Email, Line1: "1 Main St",
+		City: "Springfield", PostalCode: "12345", Country: "US", CreatedAt: time.Now(),
+	}
+	if err := addressRepo.Put(ctx, address); err != nil {
+		t.Fatalf("Failed to put address: %v", err)
+	}
+
+	exported := map[string]ExportedItem{}
+	err := userRepo.Export(ctx, strings.ToUpper(user.Email), func(item ExportedItem) error {
+		exported[item.EntityType] = item
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to export user: %v", err)
+	}
+	for _, entityType := range []string{EntityUser, EntityOrder, EntityAddress} {
+		if _, ok := exported[entityType]; !ok {
+			t.Errorf("Export is missing the %s item, got %v", entityType, exported)
+		}
+	}
+	if data, ok := exported[EntityAddress].Data.(map[string]any); !ok || data["city"] != "Springfield" || exported[EntityAddress].CreatedAt == "" {
+		t.Errorf("Address = %+v, want its data and timestamps", exported[EntityAddress])
+	}
+
+	err = userRepo.Export(ctx, "nobody@example.com", func(ExportedItem) error { return nil })
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUserRepository_Forget(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	userRepo := NewUserRepository(client, tableName, AuditWrites())
+	orderRepo := NewOrderRepository(client, tableName, AuditWrites())
+	addressRepo := NewAddressRepository(client, tableName, AuditWrites())
+
+	user := fixtures.NewUser().Build()
+	fixtures.Seed(t, fixtures.Repos{Users: userRepo, Orders: orderRepo},
+		fixtures.NewUser().WithEmail(user.Email).WithName(user.Name),
+		fixtures.NewOrderFor(user).WithID("ORD1"),
+	)
+	address := models.Address{
+		AddressID: "ADDR1", UserEmail: user.Email, Line1: "1 Main St",
+		City: "Springfield", PostalCode: "12345", Country: "US", CreatedAt: time.Now(),
+	}
+	if err := addressRepo.Put(ctx, address); err != nil {
+		t.Fatalf("Failed to put address: %v", err)
+	}
+
+	report, err := userRepo.Forget(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to forget user: %v", err)
+	}
+	// The profile, the address and the stats written with the order
+	if len(report.Deleted) != 3 {
+		t.Errorf("Deleted %v, want the profile, address and stats", report.Deleted)
+	}
+	if len(report.Anonymized) != 1 || report.AuditEntriesPurged == 0 {
+		t.Errorf("Report = %+v, want one anonymized order and a purged audit log", report)
+	}
+
+	// Test nothing is left under the user, and the order lives on anonymously
+	page, err := QueryCollection(ctx, userRepo.store, Key.UserPK(user.Email), nil)
+	if err != nil {
+		t.Fatalf("Failed to query user collection: %v", err)
+	}
+	if len(page.Items) != 0 {
+		t.Errorf("Got %d items left under the user, want 0", len(page.Items))
+	}
+	order, err := orderRepo.Get(ctx, report.AnonymousEmail, "ORD1")
+	if err != nil {
+		t.Fatalf("Failed to get anonymized order: %v", err)
+	}
+	if order.UserEmail != report.AnonymousEmail {
+		t.Errorf("Order email = %v, want %v", order.UserEmail, report.AnonymousEmail)
+	}
+
+	if _, err := userRepo.Forget(ctx, user.Email); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Forgetting twice error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestKeyspace_Parse(t *testing.T) {
+	orderID, err := ParseOrderSK(Key.OrderSK("ORD1"))
+	if err != nil || orderID != "ORD1" {
+		t.Errorf("ParseOrderSK = %q, %v, want ORD1", orderID, err)
+	}
+	userID, err := ParseUserPK(Key.UserPK("a@example.com"))
+	if err != nil || userID != "a@example.com" {
+		t.Errorf("ParseUserPK = %q, %v, want a@example.com", userID, err)
+	}
+	if _, err := ParseOrderSK(Key.PaymentSK("PAY1")); !errors.Is(err, ErrMalformedKey) {
+		t.Errorf("ParseOrderSK of a payment key error = %v, want ErrMalformedKey", err)
+	}
+
+	// Test time-ordered keys come apart into their time and ID
+	at := time.UnixMilli(1700000000123)
+	got, id, err := ParseTimeKey(PrefixCreated, string(Key.OrderDateSK(at, "ORD1")))
+	if err != nil || !got.Equal(at) || id != "ORD1" {
+		t.Errorf("ParseTimeKey = %v, %q, %v, want %v, ORD1", got, id, err, at)
+	}
+	got, id, err = ParseTimeKey(PrefixAt, string(Key.AuditSK(at.Add(456), "A1")))
+	if err != nil || !got.Equal(at.Add(456)) || id != "A1" {
+		t.Errorf("ParseTimeKey of an audit key = %v, %q, %v, want %v, A1", got, id, err, at.Add(456))
+	}
+	if _, _, err := ParseTimeKey(PrefixCreated, "CREATED#soon#ORD1"); !errors.Is(err, ErrMalformedKey) {
+		t.Errorf("ParseTimeKey of a bad time error = %v, want ErrMalformedKey", err)
+	}
+
+	prefix, rest := ParseKey(string(Key.HoldExpirySK(at, "H1")))
+	if prefix != PrefixExpires || rest != "1700000000123#H1" {
+		t.Errorf("ParseKey = %q, %q, want EXPIRES# and the rest", prefix, rest)
+	}
+	if prefix, rest := ParseKey(string(Key.UserStatsSK())); prefix != "" || rest != "STATS" {
+		t.Errorf("ParseKey of STATS = %q, %q, want no prefix", prefix, rest)
+	}
+}
+
+func TestTimes_StoredInUTC(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	at := time.Date(2024, 1, 2, 9, 4, 5, 123456789, tokyo)
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).Build()
+	order.CreatedAt = at
+
+	// Test times are stored as RFC3339Nano in UTC whatever their zone
+	av, err := marshalMap(orderItem(order))
+	if err != nil {
+		t.Fatalf("Failed to marshal order: %v", err)
+	}
+	data := av["data"].(*types.AttributeValueMemberM).Value
+	stored := data["created_at"].(*types.AttributeValueMemberS).Value
+	if stored != "2024-01-02T00:04:05.123456789Z" {
+		t.Errorf("created_at = %q, want RFC3339Nano UTC", stored)
+	}
+
+	// Test they come back in UTC, as do times stored with an offset
+	var item GenericItem[models.Order]
+	if err := unmarshalMap(av, &item); err != nil {
+		t.Fatalf("Failed to unmarshal order: %v", err)
+	}
+	if !item.Data.CreatedAt.Equal(at) || item.Data.CreatedAt.Location() != time.UTC {
+		t.Errorf("CreatedAt = %v, want %v in UTC", item.Data.CreatedAt, at)
+	}
+	var legacy time.Time
+	if err := unmarshal(&types.AttributeValueMemberS{Value: at.Format(time.RFC3339Nano)}, &legacy); err != nil {
+		t.Fatalf("Failed to unmarshal time: %v", err)
+	}
+	if !legacy.Equal(at) || legacy.Location() != time.UTC {
+		t.Errorf("Offset time = %v, want %v in UTC", legacy, at)
+	}
+
+	// Test time keys don't depend on the zone and sort as strings
+	if Key.OrderDateSK(at, "ORD1") != Key.OrderDateSK(at.UTC(), "ORD1") {
+		t.Error("OrderDateSK differs between zones of the same instant")
+	}
+	earlier := Key.OrderDateSK(time.UnixMilli(999), "ORD1")
+	later := Key.OrderDateSK(time.UnixMilli(1000), "ORD1")
+	if earlier != "CREATED#0000000000999#ORD1" || earlier >= later {
+		t.Errorf("OrderDateSK = %q, %q, want zero padded keys in time order", earlier, later)
+	}
+	if before := Key.OrderDateSK(time.UnixMilli(-1), "ORD1"); before != "CREATED#0000000000000#ORD1" {
+		t.Errorf("OrderDateSK before the epoch = %q, want it clamped to the epoch", before)
+	}
+	got, _, err := ParseTimeKey(PrefixCreated, string(Key.OrderDateSK(at, "ORD1")))
+	if err != nil || got.Location() != time.UTC {
+		t.Errorf("ParseTimeKey = %v, %v, want a UTC time", got, err)
+	}
+}
+
+func TestKeyFactory_DelimiterInjection(t *testing.T) {
+	// Test a delimiter in a value can't forge extra key segments
+	cases := []struct {
+		name  string
+		key   string
+		parse func(string) (string, error)
+		id    string
+	}{
+		{"email", string(Key.UserPK("a#b@example.com")), func(k string) (string, error) { return ParseUserPK(PrimaryKey(k)) }, "a#b@example.com"},
+		{"order", string(Key.OrderSK("X#ORDER#Y")), func(k string) (string, error) { return ParseOrderSK(SortKey(k)) }, "X#ORDER#Y"},
+		{"escape", string(Key.ProductSK("50%23")), func(k string) (string, error) { return ParseProductSK(SortKey(k)) }, "50%23"},
+	}
+	for _, tc := range cases {
+		_, rest := ParseKey(tc.key)
+		if strings.Contains(rest, KeyDelimiter) {
+			t.Errorf("%s key %q has a delimiter after its prefix", tc.name, tc.key)
+		}
+		if id, err := tc.parse(tc.key); err != nil || id != tc.id {
+			t.Errorf("%s key parsed to %q, %v, want %q", tc.name, id, err, tc.id)
+		}
+	}
+
+	if got := Key.OrderSK("ORD1#PAYMENT#P1"); strings.HasPrefix(string(got), string(Key.OrderSK("ORD1"))+KeyDelimiter) {
+		t.Errorf("OrderSK %q sorts under another order's key", got)
+	}
+	at := time.UnixMilli(1700000000123)
+	if _, id, err := ParseTimeKey(PrefixCreated, string(Key.OrderDateSK(at, "O#1"))); err != nil || id != "O#1" {
+		t.Errorf("ParseTimeKey = %q, %v, want O#1", id, err)
+	}
+	if sk := Key.ProductNameSK("C# Book", "P1"); sk != "NAME#c%23 book#P1" {
+		t.Errorf("ProductNameSK = %q, want the name escaped", sk)
+	}
+
+	// Test models refuse the delimiter before it reaches a key
+	if err := (models.User{Email: "a#b@example.com", Name: "A"}).Validate(); err == nil {
+		t.Error("expected a user with # in the email to fail validation")
+	}
+	order := models.Order{OrderID: "X#ORDER#Y", UserEmail: "a@example.com", Status: models.OrderStatusPending, Total: 1, Items: lineItems("P1")}
+	if err := order.Validate(); err == nil {
+		t.Error("expected an order ID with # to fail validation")
+	}
+	order.OrderID = "ORD1"
+	if err := order.Validate(); err != nil {
+		t.Errorf("valid order failed validation: %v", err)
+	}
+}
+
+func TestKeyFactory_NormalizesEmails(t *testing.T) {
+	if Key.UserPK(" John@X.com") != Key.UserPK("john@x.com") {
+		t.Errorf("UserPK = %v, want the same key as john@x.com", Key.UserPK(" John@X.com"))
+	}
+	hashed := NewKeyFactory(NewIDHasher("secret"))
+	if hashed.UserPK("John@X.com") != hashed.UserPK("john@x.com") {
+		t.Error("Expected hashed keys to ignore case")
+	}
+	if err := (models.User{Email: "John@X.com", Name: "John"}).Validate(); err == nil {
+		t.Error("Expected a mixed-case email to fail validation")
+	}
+
+	// Test migrating a profile written before emails were normalized
+	legacy, err := attributevalue.MarshalMap(GenericItem[models.User]{
+		PK: "USER#John@X.com", SK: "PROFILE#John@X.com", EntityType: EntityUser,
+		Data: models.User{Email: "John@X.com", Name: "John"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekeyed, changed, err := Key.RekeyUserItem(legacy, "")
+	if err != nil || !changed {
+		t.Fatalf("RekeyUserItem = %v, %v, want changed", changed, err)
+	}
+	var got GenericItem[models.User]
+	if err := attributevalue.UnmarshalMap(rekeyed, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.PK != Key.UserPK("john@x.com") || got.SK != Key.UserSK("john@x.com") || got.Data.Email != "john@x.com" {
+		t.Errorf("Rekeyed profile = %v/%v %q, want it under john@x.com", got.PK, got.SK, got.Data.Email)
+	}
+	if _, changed, _ := Key.RekeyUserItem(rekeyed, ""); changed {
+		t.Error("Expected a normalized profile to be unchanged")
+	}
+}
+
+func TestStampWrites(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := created.Add(time.Hour)
+	item, err := attributevalue.MarshalMap(productItem(fixtures.NewProduct().Build()))
+	if err != nil {
+		t.Fatalf("Failed to marshal product: %v", err)
+	}
+	put := &types.Put{Item: item}
+	update := &types.Update{UpdateExpression: aws.String("ADD order_count :one")}
+	stampWrites([]types.TransactWriteItem{{Put: put}, {Update: update}}, now)
+
+	var got GenericItem[models.Product]
+	if err := attributevalue.UnmarshalMap(put.Item, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(now) || !got.UpdatedAt.Equal(now) {
+		t.Errorf("Timestamps = %v, %v, want both %v", got.CreatedAt, got.UpdatedAt, now)
+	}
+	if expr := aws.ToString(update.UpdateExpression); !strings.Contains(expr, "SET "+updatedAtName) {
+		t.Errorf("Update = %q, want updated_at set", expr)
+	}
+
+	// Test a put that may overwrite is sent as an update keeping the stored
+	// created_at and removing the optional attributes the item leaves out
+	overwrite := overwriteUpdate(put)
+	if overwrite == nil {
+		t.Fatal("Expected an unconditional put to be sent as an update")
+	}
+	expr := aws.ToString(overwrite.UpdateExpression)
+	if !strings.Contains(expr, createdAtName+" = if_not_exists("+createdAtName+", "+createdAtValue+")") || !strings.Contains(expr, " REMOVE ") {
+		t.Errorf("Overwrite = %q, want created_at kept and absent attributes removed", expr)
+	}
+	if !reflect.DeepEqual(overwrite.Key, map[string]types.AttributeValue{"PK": item["PK"], "SK": item["SK"]}) {
+		t.Errorf("Overwrite key = %v, want the item's keys", overwrite.Key)
+	}
+	if overwriteUpdate(&types.Put{Item: item, ConditionExpression: aws.String(createOnly)}) != nil {
+		t.Error("Expected a create-only put to be sent as a put")
+	}
+}
+
+func TestStore_ItemSizeAndCompression(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Test items over the limit are refused before they're sent
+	store := NewStore(client, tableName, ItemSizeLimit(1000))
+	product := fixtures.NewProduct().WithCategory(strings.Repeat("x", 2000)).Build()
+	if err := PutItem(ctx, store, productItem(product)); !errors.Is(err, ErrItemTooLarge) {
+		t.Errorf("Expected ErrItemTooLarge, got %v", err)
+	}
+
+	// Test compressed data is stored as binary and read back transparently,
+	// which also brings the item under the limit
+	store = NewStore(client, tableName, ItemSizeLimit(1000), CompressData(500, EntityProduct))
+	if err := PutItem(ctx, store, productItem(product)); err != nil {
+		t.Fatalf("Failed to put compressed product: %v", err)
+	}
+	raw, err := getRawItem(ctx, store, Key.ProductPK(), Key.ProductSK(product.ProductID))
+	if err != nil {
+		t.Fatalf("Failed to get raw item: %v", err)
+	}
+	if _, ok := raw[dataAttribute].(*types.AttributeValueMemberB); !ok || raw.EntityType() != EntityProduct {
+		t.Errorf("Stored data = %T, want compressed binary", raw[dataAttribute])
+	}
+	var got GenericItem[models.Product]
+	if err := GetItem(ctx, store, Key.ProductPK(), Key.ProductSK(product.ProductID), &got); err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.Data.Category != product.Category || got.Data.Price != product.Price || got.CreatedAt.IsZero() {
+		t.Errorf("Product = %+v, want it decompressed", got)
+	}
+	page, err := QueryData[models.Product](ctx, store, Key.ProductPK(), string(Key.ProductSK(product.ProductID)), nil)
+	if err != nil || len(page.Items) != 1 || page.Items[0].Category != product.Category {
+		t.Errorf("Query = %+v, %v, want the decompressed product", page, err)
+	}
+
+	// Test small data is left uncompressed
+	small := fixtures.NewProduct().Build()
+	if err := PutItem(ctx, store, productItem(small)); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	raw, err = getRawItem(ctx, store, Key.ProductPK(), Key.ProductSK(small.ProductID))
+	if err != nil {
+		t.Fatalf("Failed to get raw item: %v", err)
+	}
+	if _, ok := raw[dataAttribute].(*types.AttributeValueMemberM); !ok {
+		t.Errorf("Stored data = %T, want a map", raw[dataAttribute])
+	}
+}
+
+func TestStore_Encoding(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	type entity struct {
+		Name    string    `dynamodbav:"name"`
+		Note    string    `dynamodbav:"note"`
+		Tags    []string  `dynamodbav:"tags"`
+		Deleted time.Time `dynamodbav:"deleted"`
+		Seen    time.Time `dynamodbav:"seen"`
+		Events  []string  `dynamodbav:"events"`
+	}
+	store := NewStore(client, tableName, WithEncoding(EncodingOptions{
+		OmitEmptyStrings: true,
+		OmitNulls:        true,
+		OmitZeroTimes:    true,
+		TimeFormat:       TimeUnix,
+	}))
+	seen := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	item := GenericItem[entity]{
+		PK: "TEST#encoding", SK: "TEST#encoding", EntityType: "TEST",
+		Data: entity{Name: "a", Seen: seen, Events: []string{"", "b"}},
+	}
+	if err := PutItem(ctx, store, item); err != nil {
+		t.Fatalf("Failed to put item: %v", err)
+	}
+
+	raw, err := getRawItem(ctx, store, item.PK, item.SK)
+	if err != nil {
+		t.Fatalf("Failed to get raw item: %v", err)
+	}
+	data := raw[dataAttribute].(*types.AttributeValueMemberM).Value
+	for _, name := range []string{"note", "tags", "deleted"} {
+		if _, ok := data[name]; ok {
+			t.Errorf("Stored %s = %v, want it left out", name, data[name])
+		}
+	}
+	if n, ok := data["seen"].(*types.AttributeValueMemberN); !ok || n.Value != "1709632800" {
+		t.Errorf("Stored seen = %v, want epoch seconds", data["seen"])
+	}
+	if _, ok := raw[createdAtAttribute].(*types.AttributeValueMemberS); !ok {
+		t.Errorf("Stored created_at = %v, want the usual timestamp", raw[createdAtAttribute])
+	}
+
+	var got GenericItem[entity]
+	if err := GetItem(ctx, store, item.PK, item.SK, &got); err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if !reflect.DeepEqual(got.Data, item.Data) {
+		t.Errorf("Read back %+v, want %+v", got.Data, item.Data)
+	}
+}
+
+func TestStore_Timestamps(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	store := NewStore(client, tableName)
+
+	product := fixtures.NewProduct().Build()
+	if err := PutItem(ctx, store, productItem(product)); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	var first GenericItem[models.Product]
+	if err := GetItem(ctx, store, Key.ProductPK(), Key.ProductSK(product.ProductID), &first); err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+
+	// Rewriting the product from scratch keeps when it was created
+	time.Sleep(5 * time.Millisecond)
+	product.Stock++
+	if err := PutItem(ctx, store, productItem(product)); err != nil {
+		t.Fatalf("Failed to put product again: %v", err)
+	}
+	var second GenericItem[models.Product]
+	if err := GetItem(ctx, store, Key.ProductPK(), Key.ProductSK(product.ProductID), &second); err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want it kept at %v", second.CreatedAt, first.CreatedAt)
+	}
+	if !second.UpdatedAt.After(first.UpdatedAt) {
+		t.Errorf("UpdatedAt = %v, want it after %v", second.UpdatedAt, first.UpdatedAt)
+	}
+
+	// Test an overwrite is a single request, which drops the index keys
+	// the new item leaves out
+	var mu sync.Mutex
+	var operations []string
+	counted := NewStore(testutil.CreateTestClient(t, dynamoclient.WithMiddleware(func(stack *smithymiddleware.Stack) error {
+		return stack.Initialize.Add(smithymiddleware.InitializeMiddlewareFunc("CountOperations", func(
+			ctx context.Context, in smithymiddleware.InitializeInput, next smithymiddleware.InitializeHandler,
+		) (smithymiddleware.InitializeOutput, smithymiddleware.Metadata, error) {
+			mu.Lock()
+			operations = append(operations, awsmiddleware.GetOperationName(ctx))
+			mu.Unlock()
+			return next.HandleInitialize(ctx, in)
+		}), smithymiddleware.Before)
+	})), tableName)
+	product.Featured = true
+	if err := PutItem(ctx, counted, productItem(product)); err != nil {
+		t.Fatalf("Failed to feature product: %v", err)
+	}
+	product.Featured = false
+	if err := PutItem(ctx, counted, productItem(product)); err != nil {
+		t.Fatalf("Failed to unfeature product: %v", err)
+	}
+	if len(operations) != 2 {
+		t.Errorf("Overwrites sent %v, want one request each", operations)
+	}
+	raw, err := getRawItem(ctx, store, Key.ProductPK(), Key.ProductSK(product.ProductID))
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if _, ok := raw["GSI3PK"]; ok {
+		t.Error("Expected the unfeatured product to leave the featured index")
+	}
+	var third GenericItem[models.Product]
+	if err := GetItem(ctx, store, Key.ProductPK(), Key.ProductSK(product.ProductID), &third); err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if !third.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want it kept at %v", third.CreatedAt, first.CreatedAt)
+	}
+}
+
+func TestStore_Exists(t *testing.T) {
+	_, _, userRepo, orderRepo, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	user := fixtures.NewUser().Build()
+	product := fixtures.NewProduct().Build()
+	if err := userRepo.Put(ctx, user); err != nil {
+		t.Fatalf("Failed to put user: %v", err)
+	}
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	if ok, err := userRepo.Exists(ctx, user.Email); err != nil || !ok {
+		t.Errorf("UserRepository.Exists = %v, %v, want true", ok, err)
+	}
+	if ok, err := productRepo.Exists(ctx, "MISSING"); err != nil || ok {
+		t.Errorf("ProductRepository.Exists of a missing product = %v, %v, want false", ok, err)
+	}
+
+	order := fixtures.NewOrderFor(user).WithProducts(product.ProductID, product.ProductID).Build()
+	if err := orderRepo.CheckReferences(ctx, order); err != nil {
+		t.Errorf("CheckReferences = %v, want nil", err)
+	}
+	order.Items = append(order.Items, lineItems("MISSING")...)
+	if err := orderRepo.CheckReferences(ctx, order); !errors.Is(err, ErrUnknownReference) {
+		t.Errorf("CheckReferences with a missing product = %v, want ErrUnknownReference", err)
+	}
+	order.UserEmail = "nobody@example.com"
+	if err := orderRepo.CheckReferences(ctx, order); !errors.Is(err, ErrUnknownReference) {
+		t.Errorf("CheckReferences with a missing user = %v, want ErrUnknownReference", err)
+	}
+}
+
+func TestStore_Chaos(t *testing.T) {
+	client, tableName, userRepo, orderRepo, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Test batch puts get through throttling and unprocessed items by
+	// retrying with backoff
+	faults := chaos.New(chaos.Config{ThrottleRate: 0.2, UnprocessedRate: 0.2, Seed: 3})
+	chaotic := testutil.CreateTestClient(t, faults.Option(), chaos.FastRetries(10))
+	products := make([]models.Product, 30)
+	for i := range products {
+		products[i] = fixtures.NewProduct().WithID(fmt.Sprintf("CHAOS%02d", i)).WithStock(5).Build()
+	}
+	result, err := NewProductRepository(chaotic, tableName).PutMany(ctx, products)
+	if err != nil || !result.OK() || len(result.Succeeded) != len(products) {
+		t.Fatalf("PutMany = %+v, %v, want every product written", result, err)
+	}
+	if stats := faults.Stats(); stats.Throttles == 0 || stats.Unprocessed == 0 {
+		t.Errorf("Stats = %+v, want throttling and unprocessed items", stats)
+	}
+	for _, product := range products {
+		if _, err := productRepo.Get(ctx, product.ProductID); err != nil {
+			t.Errorf("Failed to get product %s: %v", product.ProductID, err)
+		}
+	}
+
+	// Test a transaction cancelled by a conflict changes nothing
+	user := fixtures.NewUser().Build()
+	if err := userRepo.Put(ctx, user); err != nil {
+		t.Fatalf("Failed to put user: %v", err)
+	}
+	conflicts := chaos.New(chaos.Config{ConflictRate: 1, Operations: []string{"TransactWriteItems"}})
+	service := NewOrderService(testutil.CreateTestClient(t, conflicts.Option()), tableName, EnforceKeyConsistency())
+	_, err = service.Place(ctx, user.Email, []string{products[0].ProductID})
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("Expected the transaction to be cancelled, got %v", err)
+	}
+	stored, err := productRepo.Get(ctx, products[0].ProductID)
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if stored.Stock != 5 {
+		t.Errorf("Stock = %d after a cancelled order, want 5", stored.Stock)
+	}
+	orders, err := orderRepo.GetUserOrders(ctx, user.Email, nil)
+	if err != nil {
+		t.Fatalf("Failed to get orders: %v", err)
+	}
+	if len(orders.Orders) != 0 {
+		t.Errorf("Got %d orders after a cancelled order, want none", len(orders.Orders))
+	}
+	stats, err := userRepo.GetStats(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.OrderCount != 0 {
+		t.Errorf("OrderCount = %d after a cancelled order, want 0", stats.OrderCount)
+	}
+	events, _, err := NewOutboxRepository(client, tableName).Pending(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to get outbox events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Got %d outbox events after a cancelled order, want none", len(events))
+	}
+
+	// Test the operation timeout ends a call that hangs
+	hangs := chaos.New(chaos.Config{TimeoutRate: 1})
+	slow := NewProductRepository(testutil.CreateTestClient(t, hangs.Option()), tableName,
+		OperationTimeouts(Timeouts{Default: 50 * time.Millisecond}))
+	start := time.Now()
+	if _, err := slow.Get(ctx, products[0].ProductID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get = %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Get took %v despite its timeout", elapsed)
+	}
+}
+
+func TestOrderService_Place(t *testing.T) {
+	client, tableName, userRepo, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+	service := NewOrderService(client, tableName, EnforceKeyConsistency())
+
+	user := fixtures.NewUser().Build()
+	if err := userRepo.Put(ctx, user); err != nil {
+		t.Fatalf("Failed to put user: %v", err)
+	}
+	product := fixtures.NewProduct().WithPrice(12.5).WithStock(3).Build()
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+
+	// Test the total comes from the stored price and stock is taken
+	order, err := service.Place(ctx, user.Email, []string{product.ProductID, product.ProductID})
+	if err != nil {
+		t.Fatalf("Failed to place order: %v", err)
+	}
+	if order.Total != 25 || order.Currency != "USD" || order.Status != models.OrderStatusPending {
+		t.Errorf("Order = %.2f %s %s, want 25.00 USD pending", order.Total, order.Currency, order.Status)
+	}
+	wantItems := []models.LineItem{{ProductID: product.ProductID, Name: product.Name, UnitPrice: 12.5, Quantity: 2}}
+	if !reflect.DeepEqual(order.Items, wantItems) {
+		t.Errorf("Items = %+v, want %+v", order.Items, wantItems)
+	}
+	stored, err := productRepo.Get(ctx, product.ProductID)
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if stored.Stock != 1 {
+		t.Errorf("Stock = %d, want 1", stored.Stock)
+	}
+	stats, err := userRepo.GetStats(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.OrderCount != 1 || stats.LifetimeSpend["USD"] != 25 {
+		t.Errorf("Stats = %+v, want one order of 25.00", stats)
+	}
+
+	if _, err := service.Place(ctx, user.Email, []string{product.ProductID, product.ProductID}); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("Placing more than is in stock = %v, want ErrInsufficientStock", err)
+	}
+	if _, err := service.Place(ctx, user.Email, []string{"MISSING"}); !errors.Is(err, ErrUnknownReference) {
+		t.Errorf("Placing a missing product = %v, want ErrUnknownReference", err)
+	}
+	if _, err := service.Place(ctx, "nobody@example.com", []string{product.ProductID}); !errors.Is(err, ErrUnknownReference) {
+		t.Errorf("Placing for a missing user = %v, want ErrUnknownReference", err)
+	}
+
+	// Test products in another currency can't share an order
+	euros := fixtures.NewProduct().WithID("PROD_EUR").WithCurrency("EUR").Build()
+	if err := productRepo.Put(ctx, euros); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	if _, err := service.Place(ctx, user.Email, []string{product.ProductID, euros.ProductID}); !errors.Is(err, ErrMixedCurrencies) {
+		t.Errorf("Placing products in two currencies = %v, want ErrMixedCurrencies", err)
+	}
+	order, err = service.Place(ctx, user.Email, []string{euros.ProductID})
+	if err != nil {
+		t.Fatalf("Failed to place order: %v", err)
+	}
+	if order.Currency != "EUR" {
+		t.Errorf("Currency = %q, want EUR", order.Currency)
+	}
+
+	// Test an order with more products than a transaction holds is refused
+	// before anything is read or sent, at half the size when audited
+	many := make([]string, 97)
+	for i := range many {
+		many[i] = fmt.Sprintf("MANY%03d", i)
+	}
+	if _, err := service.Place(ctx, user.Email, many[:96]); !errors.Is(err, ErrUnknownReference) {
+		t.Errorf("Placing 96 products = %v, want them read", err)
+	}
+	if _, err := service.Place(ctx, user.Email, many); !errors.Is(err, ErrTransactionTooLarge) {
+		t.Errorf("Placing 97 products = %v, want ErrTransactionTooLarge", err)
+	}
+	audited := NewOrderService(client, tableName, AuditWrites())
+	if _, err := audited.Place(ctx, user.Email, many[:47]); !errors.Is(err, ErrTransactionTooLarge) {
+		t.Errorf("Placing 47 audited products = %v, want ErrTransactionTooLarge", err)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		name  string
+		cond  Condition
+		expr  string
+		names map[string]string
+		value string
+	}{
+		{"not exists", AttributeNotExists("PK"), "attribute_not_exists (#0)", map[string]string{"#0": "PK"}, ""},
+		{"equals", AttributeEquals("data.status", "pending"), "#0.#1 = :0", map[string]string{"#0": "data", "#1": "status"}, "pending"},
+		{"version", VersionEquals("data.version", 3), "#0.#1 = :0", map[string]string{"#0": "data", "#1": "version"}, ""},
+		{"first version", VersionEquals("data.version", 0), "(attribute_not_exists (#0.#1)) OR (#0.#1 = :0)", map[string]string{"#0": "data", "#1": "version"}, ""},
+		{"and", And(AttributeExists("PK"), AttributeEquals("data.status", "pending")), "(attribute_exists (#0)) AND (#1.#2 = :0)", map[string]string{"#0": "PK", "#1": "data", "#2": "status"}, "pending"},
+		{"where", Where(expression.Name("data.total").GreaterThan(expression.Value(10))), "#0.#1 > :0", map[string]string{"#0": "data", "#1": "total"}, ""},
+	}
+	for _, tt := range tests {
+		cond, err := tt.cond.build()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if cond.expr != tt.expr || !reflect.DeepEqual(cond.names, tt.names) {
+			t.Errorf("%s = %q %v, want %q %v", tt.name, cond.expr, cond.names, tt.expr, tt.names)
+		}
+		if tt.value != "" {
+			value, _ := cond.values[":0"].(*types.AttributeValueMemberS)
+			if value == nil || value.Value != tt.value {
+				t.Errorf("%s values = %v, want %q", tt.name, cond.values, tt.value)
+			}
+		}
+	}
+
+	if _, err := AttributeEquals("data.#status", "x").build(); err == nil {
+		t.Error("A path with a placeholder character built a condition, want an error")
+	}
+	// Test each comparison gets its own value placeholder
+	cond, err := And(AttributeEquals("data.status", "a"), AttributeEquals("data.status", "b")).build()
+	if err != nil || len(cond.values) != 2 {
+		t.Errorf("Comparing one path to two values = %+v, %v, want two values", cond, err)
+	}
+}
+
+func TestStore_ConditionalWrites(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	for _, audited := range []bool{false, true} {
+		var opts []StoreOption
+		if audited {
+			opts = append(opts, AuditWrites())
+		}
+		store := NewStore(client, tableName, opts...)
+		product := fixtures.NewProduct().WithID(fmt.Sprintf("COND%v", audited)).Build()
+		item := productItem(product)
+
+		// Test puts only create when told the item mustn't exist
+		if err := PutItem(ctx, store, item, AttributeNotExists("PK")); err != nil {
+			t.Fatalf("audited=%v: Failed to create product: %v", audited, err)
+		}
+		if err := PutItem(ctx, store, item, AttributeNotExists("PK")); !errors.Is(err, ErrConditionFailed) {
+			t.Errorf("audited=%v: Creating twice = %v, want ErrConditionFailed", audited, err)
+		}
+
+		// Test updates check the stored values
+		err := UpdateItem(ctx, store, item.PK, item.SK, map[string]any{"stock": 5}, AttributeEquals("data.stock", 1))
+		if !errors.Is(err, ErrConditionFailed) {
+			t.Errorf("audited=%v: Update from the wrong stock = %v, want ErrConditionFailed", audited, err)
+		}
+		err = UpdateItem(ctx, store, item.PK, item.SK, map[string]any{"stock": 5}, AttributeEquals("data.stock", product.Stock))
+		if err != nil {
+			t.Fatalf("audited=%v: Failed to update product: %v", audited, err)
+		}
+		var stored GenericItem[models.Product]
+		if err := GetItem(ctx, store, item.PK, item.SK, &stored); err != nil {
+			t.Fatalf("audited=%v: Failed to get product: %v", audited, err)
+		}
+		if stored.Data.Stock != 5 || stored.Data.Name != product.Name {
+			t.Errorf("audited=%v: Product = %+v, want stock 5 and the rest unchanged", audited, stored.Data)
+		}
+		if err := UpdateItem(ctx, store, item.PK, "PRODUCT#MISSING", map[string]any{"stock": 1}); !errors.Is(err, ErrConditionFailed) {
+			t.Errorf("audited=%v: Updating a missing item = %v, want ErrConditionFailed", audited, err)
+		}
+
+		// Test deletes check the stored values
+		if err := DeleteItem(ctx, store, item.PK, item.SK, AttributeEquals("data.stock", 1)); !errors.Is(err, ErrConditionFailed) {
+			t.Errorf("audited=%v: Delete with the wrong stock = %v, want ErrConditionFailed", audited, err)
+		}
+		if err := DeleteItem(ctx, store, item.PK, item.SK, AttributeEquals("data.stock", 5)); err != nil {
+			t.Errorf("audited=%v: Failed to delete product: %v", audited, err)
+		}
+		if ok, err := store.Exists(ctx, item.PK, item.SK); err != nil || ok {
+			t.Errorf("audited=%v: Exists after delete = %v, %v, want false", audited, ok, err)
+		}
+	}
+}
+
+func TestQueryOptions_Filter(t *testing.T) {
+	_, _, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	user := fixtures.NewUser().Build()
+	fixtures.Seed(t, fixtures.Repos{Orders: orderRepo},
+		fixtures.NewOrderFor(user).WithID("ORD1"),
+		fixtures.NewOrderFor(user).WithID("ORD2").WithStatus(models.OrderStatusCompleted),
+		fixtures.NewOrderFor(user).WithID("ORD3"),
+	)
+
+	result, err := orderRepo.GetUserOrders(ctx, user.Email, &QueryOptions{
+		Filter: AttributeEquals("data.status", models.OrderStatusCompleted),
+	})
+	if err != nil {
+		t.Fatalf("Failed to get filtered orders: %v", err)
+	}
+	if len(result.Orders) != 1 || result.Orders[0].OrderID != "ORD2" {
+		t.Errorf("Filtered orders = %+v, want only ORD2", result.Orders)
+	}
+
+	// Test a filter's placeholders don't clash with the key condition's
+	result, err = orderRepo.GetUserOrders(ctx, user.Email, &QueryOptions{
+		Filter: And(
+			AttributeEquals("entity_type", EntityOrder),
+			Where(expression.Name("data.order_id").NotEqual(expression.Value("ORD2"))),
+		),
+	})
+	if err != nil {
+		t.Fatalf("Failed to get orders with two filters: %v", err)
+	}
+	if len(result.Orders) != 2 {
+		t.Errorf("Orders with two filters = %+v, want ORD1 and ORD3", result.Orders)
+	}
+}
+
+func TestWriteLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewWriteLimiter(10)
+	limiter.now = func() time.Time { return now }
+	limiter.last = now
+
+	// Test a full bucket covers a second's worth, then writes wait their turn
+	if wait := limiter.reserve(10); wait != 0 {
+		t.Errorf("First reserve waited %v, want 0", wait)
+	}
+	if wait := limiter.reserve(5); wait != 500*time.Millisecond {
+		t.Errorf("Second reserve waited %v, want 500ms", wait)
+	}
+	now = now.Add(time.Second)
+	if wait := limiter.reserve(5); wait != 0 {
+		t.Errorf("Reserve after refilling waited %v, want 0", wait)
+	}
+
+	// Test an idle limiter holds no more than a second's worth
+	now = now.Add(time.Minute)
+	if wait := limiter.reserve(20); wait != time.Second {
+		t.Errorf("Reserve after idling waited %v, want 1s", wait)
+	}
+
+	// Test a cancelled wait gives its units back
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if wait := limiter.reserve(0); wait != time.Second {
+		t.Errorf("Debt after the cancelled wait is %v, want 1s", wait)
+	}
+
+	// Test concurrent writers each take their own units
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.reserve(1)
+		}()
+	}
+	wg.Wait()
+	if wait := limiter.reserve(0); wait != 2*time.Second {
+		t.Errorf("Debt after concurrent writes is %v, want 2s", wait)
+	}
+
+	if err := (*WriteLimiter)(nil).Wait(ctx, 10); err != nil {
+		t.Errorf("Nil limiter returned %v, want nil", err)
+	}
+}
+
+func TestWriteUnits(t *testing.T) {
+	small := map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: "A"}}
+	large := map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: strings.Repeat("x", 1500)}}
+	tests := []struct {
+		name  string
+		input any
+		want  int
+	}{
+		{"put", &dynamodb.PutItemInput{Item: large}, 2},
+		{"update", &dynamodb.UpdateItemInput{}, 1},
+		{"large update", &dynamodb.UpdateItemInput{ExpressionAttributeValues: large}, 2},
+		{"batch", &dynamodb.BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{"T": {
+			{PutRequest: &types.PutRequest{Item: small}},
+			{PutRequest: &types.PutRequest{Item: large}},
+			{DeleteRequest: &types.DeleteRequest{Key: small}},
+		}}}, 4},
+		{"transaction", &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{Item: small}},
+			{Delete: &types.Delete{Key: small}},
+		}}, 4},
+		{"read", &dynamodb.GetItemInput{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writeUnits(tt.input); got != tt.want {
+				t.Errorf("writeUnits() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_LimitWrites(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+
+	// 60 one-unit items against 40 units a second: the first 40 go at
+	// once, the rest wait half a second
+	store := NewStore(client, tableName, LimitWrites(NewWriteLimiter(40)))
+	start := time.Now()
+	batch, err := BatchPutItems(context.Background(), store, benchOrderItems(60, "ORD"))
+	if err != nil {
+		t.Fatalf("Failed to batch put items: %v", err)
+	}
+	if err := batch.Err(); err != nil {
+		t.Fatalf("Failed to batch put items: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Batch took %v, want at least 400ms at the write budget", elapsed)
+	}
+}
+
+func TestOrderRepository_ExpireCompletedOrders(t *testing.T) {
+	_, _, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	user := fixtures.NewUser().Build()
+	now := time.Now()
+	fixtures.Seed(t, fixtures.Repos{Orders: orderRepo},
+		fixtures.NewOrderFor(user).WithID("OLD").WithStatus(models.OrderStatusCompleted).WithCreatedAt(now.AddDate(0, 0, -3)),
+		fixtures.NewOrderFor(user).WithID("PENDING").WithCreatedAt(now.AddDate(0, 0, -3)),
+		fixtures.NewOrderFor(user).WithID("NEW").WithStatus(models.OrderStatusCompleted).WithCreatedAt(now.Add(-time.Hour)),
+		fixtures.NewOrderFor(user).WithID("ANCIENT").WithStatus(models.OrderStatusCompleted).WithCreatedAt(now.AddDate(0, 0, -30)),
+	)
+
+	// Only completed orders before the cutoff and within the lookback expire
+	expired, err := orderRepo.ExpireCompletedOrders(ctx, now.AddDate(0, 0, -2), 5, now)
+	if err != nil {
+		t.Fatalf("Failed to expire orders: %v", err)
+	}
+	if expired != 1 {
+		t.Errorf("Expired %d orders, want 1", expired)
+	}
+	for id, want := range map[string]bool{"OLD": true, "PENDING": false, "NEW": false, "ANCIENT": false} {
+		var item GenericItem[models.Order]
+		if err := GetItem(ctx, orderRepo.store, Key.UserPK(user.Email), Key.OrderSK(id), &item); err != nil {
+			t.Fatalf("Failed to get order %s: %v", id, err)
+		}
+		if got := item.TTL != 0; got != want {
+			t.Errorf("Order %s has TTL %d, want set %v", id, item.TTL, want)
+		}
+	}
+
+	// Test orders already expiring are left alone
+	expired, err = orderRepo.ExpireCompletedOrders(ctx, now.AddDate(0, 0, -2), 5, now)
+	if err != nil {
+		t.Fatalf("Failed to expire orders: %v", err)
+	}
+	if expired != 0 {
+		t.Errorf("Second pass expired %d orders, want 0", expired)
+	}
+}
+
+// pricedItems is lineItems with the first unit priced at total, so the
+// items add up to it
+func pricedItems(total float64, productIDs ...string) []models.LineItem {
+	items := lineItems(productIDs...)
+	items[0].UnitPrice = total
+	return items
+}
+
+// lineItems returns a free line item for one unit of each product ID
+func lineItems(productIDs ...string) []models.LineItem {
+	items := make([]models.LineItem, len(productIDs))
+	for i, id := range productIDs {
+		items[i] = models.LineItem{ProductID: id, Name: id, Quantity: 1}
+	}
+	return items
+}
+
+func TestLegacyLineItems(t *testing.T) {
+	catalog := map[string]models.Product{
+		"P1": {ProductID: "P1", Name: "Ball", Price: 4},
+		"P2": {ProductID: "P2", Name: "Yoyo", Price: 3},
+	}
+
+	// Test a single product is priced from what was paid, not today's price
+	single := models.Order{Total: 9, LegacyProducts: []string{"P1", "P1"}}
+	want := []models.LineItem{{ProductID: "P1", Name: "Ball", UnitPrice: 4.5, Quantity: 2}}
+	if got := legacyLineItems(single, catalog); !reflect.DeepEqual(got, want) {
+		t.Errorf("legacyLineItems = %+v, want %+v", got, want)
+	}
+
+	mixed := models.Order{Total: 10, LegacyProducts: []string{"P2", "GONE", "P2"}}
+	want = []models.LineItem{
+		{ProductID: "P2", Name: "Yoyo", UnitPrice: 3, Quantity: 2},
+		{ProductID: "GONE", Name: "GONE", Quantity: 1},
+	}
+	if got := legacyLineItems(mixed, catalog); !reflect.DeepEqual(got, want) {
+		t.Errorf("legacyLineItems = %+v, want %+v", got, want)
+	}
+}
+
+func TestBufferedWriter(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	w := productRepo.BufferedWriter(ctx, 3, 50*time.Millisecond)
+	if err := w.Put(ctx, fixtures.NewProduct().WithID("BAD").WithPrice(0).Build()); err == nil {
+		t.Error("Expected an invalid product to be rejected, got nil")
+	}
+	for i := range 4 {
+		if err := w.Put(ctx, fixtures.NewProduct().WithID(fmt.Sprintf("PROD%d", i)).Build()); err != nil {
+			t.Fatalf("Failed to put product: %v", err)
+		}
+	}
+
+	// Test the first three were flushed as a full batch, and the timer
+	// flushes the fourth
+	if got := len(w.Result().Succeeded); got < 3 {
+		t.Errorf("%d products flushed after a full batch, want at least 3", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(w.Result().Succeeded) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := len(w.Result().Succeeded); got != 4 {
+		t.Errorf("%d products flushed by the timer, want 4", got)
+	}
+
+	if err := w.Put(ctx, fixtures.NewProduct().WithID("PROD4").Build()); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	if err := w.Close(ctx); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	if _, err := productRepo.Get(ctx, "PROD4"); err != nil {
+		t.Errorf("Close didn't flush PROD4: %v", err)
+	}
+	if err := w.Put(ctx, fixtures.NewProduct().Build()); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("Expected ErrWriterClosed, got %v", err)
+	}
+}
+
+func TestProductRepository_PutMany(t *testing.T) {
+	_, _, _, _, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	products := []models.Product{
+		fixtures.NewProduct().WithID("PROD1").Build(),
+		fixtures.NewProduct().WithID("PROD2").WithPrice(0).Build(),
+		fixtures.NewProduct().WithID("PROD3").Build(),
+	}
+	result, err := productRepo.PutMany(ctx, products)
+	if err != nil {
+		t.Fatalf("Failed to put products: %v", err)
+	}
+	if len(result.Succeeded) != 2 || len(result.Failed) != 1 || result.Failed[0].Key.SK != Key.ProductSK("PROD2") {
+		t.Errorf("Result = %+v, want PROD2 rejected and the rest written", result)
+	}
+	if _, err := productRepo.Get(ctx, "PROD3"); err != nil {
+		t.Errorf("Failed to get PROD3: %v", err)
+	}
+}
+
+func TestOrderRepository_SetInvoiceKey(t *testing.T) {
+	_, _, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	user := fixtures.NewUser().Build()
+	fixtures.Seed(t, fixtures.Repos{Orders: orderRepo}, fixtures.NewOrderFor(user).WithID("ORD1").WithStatus(models.OrderStatusCompleted))
+
+	if err := orderRepo.SetInvoiceKey(ctx, user.Email, "ORD1", "invoices/ORD1.html"); err != nil {
+		t.Fatalf("Failed to set invoice key: %v", err)
+	}
+	order, err := orderRepo.Get(ctx, user.Email, "ORD1")
+	if err != nil {
+		t.Fatalf("Failed to get order: %v", err)
+	}
+	if order.InvoiceKey != "invoices/ORD1.html" || order.Status != models.OrderStatusCompleted {
+		t.Errorf("Order = %+v, want the invoice key set and the rest unchanged", order)
+	}
+
+	// Test a missing order isn't created
+	if err := orderRepo.SetInvoiceKey(ctx, user.Email, "MISSING", "invoices/MISSING.html"); !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Expected ErrConditionFailed, got %v", err)
+	}
+}
+
+func TestOrderRepository_CheckTotals(t *testing.T) {
+	_, _, _, orderRepo, _, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	user := fixtures.NewUser().Build()
+	order := fixtures.NewOrderFor(user).WithID("ORD1").Build()
+	order.Items = []models.LineItem{{ProductID: "PROD1", Name: "Mug", UnitPrice: 12.5, Quantity: 2}}
+	if err := orderRepo.Put(ctx, order); err != nil {
+		t.Fatalf("Failed to put order: %v", err)
+	}
+	fixtures.Seed(t, fixtures.Repos{Orders: orderRepo}, fixtures.NewOrderFor(user).WithID("ORD2"))
+	// Put always saves the items' total, so drift it the way a direct
+	// write would
+	if err := UpdateItem(ctx, orderRepo.store, Key.UserPK(user.Email), Key.OrderSK("ORD1"), map[string]any{"total": 30.0}); err != nil {
+		t.Fatalf("Failed to update total: %v", err)
+	}
+	var alerts []TotalMismatch
+	orderRepo.OnTotalMismatch(func(ctx context.Context, mismatch TotalMismatch) {
+		alerts = append(alerts, mismatch)
+	})
+
+	want := TotalMismatch{UserEmail: user.Email, OrderID: "ORD1", Currency: models.DefaultCurrency, Stored: 30, Computed: 25}
+	mismatches, err := orderRepo.CheckUserTotals(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to check totals: %v", err)
+	}
+	if !reflect.DeepEqual(mismatches, []TotalMismatch{want}) || len(alerts) != 1 {
+		t.Errorf("Mismatches = %+v with %d alerts, want only ORD1 flagged", mismatches, len(alerts))
+	}
+
+	// Test reconciliation finds it in the day's orders
+	mismatches, err = orderRepo.CheckDayTotals(ctx, order.CreatedAt)
+	if err != nil {
+		t.Fatalf("Failed to check the day's totals: %v", err)
+	}
+	if !reflect.DeepEqual(mismatches, []TotalMismatch{want}) {
+		t.Errorf("Day mismatches = %+v, want only ORD1", mismatches)
+	}
+
+	// Test reads check it in dev mode
+	alerts = nil
+	orderRepo.CheckTotalsOnRead()
+	if _, err := orderRepo.Get(ctx, user.Email, "ORD1"); err != nil {
+		t.Fatalf("Failed to get order: %v", err)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("Got %d alerts reading ORD1, want 1", len(alerts))
+	}
+
+	// Test the repair sets the items' total
+	repaired, err := orderRepo.RepairTotal(ctx, user.Email, "ORD1")
+	if err != nil {
+		t.Fatalf("Failed to repair total: %v", err)
+	}
+	if repaired.Total != 25 {
+		t.Errorf("Repaired total = %.2f, want 25.00", repaired.Total)
+	}
+	if mismatches, err := orderRepo.CheckUserTotals(ctx, user.Email); err != nil || len(mismatches) != 0 {
+		t.Errorf("Mismatches after repair = %+v, %v, want none", mismatches, err)
+	}
+}
+
+func TestOrderRepository_MigrateLineItems(t *testing.T) {
+	_, _, _, orderRepo, productRepo, cleanup := testSetup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	product := fixtures.NewProduct().WithID("P1").WithName("Ball").Build()
+	if err := productRepo.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	legacy := models.Order{OrderID: "OLD1", UserEmail: "legacy@example.com", Status: models.OrderStatusCompleted, Total: 8, LegacyProducts: []string{"P1", "P1"}, CreatedAt: time.Now()}
+	if err := orderRepo.Put(ctx, legacy); err != nil {
+		t.Fatalf("Failed to put legacy order: %v", err)
+	}
+
+	scanned, migrated, err := orderRepo.MigrateLineItems(ctx, productRepo, false)
+	if err != nil || scanned != 1 || migrated != 1 {
+		t.Fatalf("MigrateLineItems = %d, %d, %v, want 1 migrated", scanned, migrated, err)
+	}
+	got, err := orderRepo.Get(ctx, legacy.UserEmail, legacy.OrderID)
+	if err != nil {
+		t.Fatalf("Failed to get order: %v", err)
+	}
+	want := []models.LineItem{{ProductID: "P1", Name: "Ball", UnitPrice: 4, Quantity: 2}}
+	if !reflect.DeepEqual(got.Items, want) || got.LegacyProducts != nil {
+		t.Errorf("Migrated order = %+v / %v, want %+v", got.Items, got.LegacyProducts, want)
+	}
+
+	// Test running it again finds nothing left to do
+	if scanned, _, err := orderRepo.MigrateLineItems(ctx, productRepo, false); err != nil || scanned != 0 {
+		t.Errorf("Second MigrateLineItems scanned %d, %v, want 0", scanned, err)
+	}
+}
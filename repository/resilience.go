@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// ErrCircuitOpen means DynamoDB calls are being refused without being sent
+// because the last ones kept failing
+var ErrCircuitOpen = errors.New("dynamodb circuit breaker is open")
+
+// Timeouts bounds how long each DynamoDB operation may take, retries
+// included
+type Timeouts struct {
+	// Default applies to operations not in PerOperation; 0 means no timeout
+	Default time.Duration
+	// PerOperation overrides Default by operation name, e.g. "Scan"
+	PerOperation map[string]time.Duration
+}
+
+// For returns the timeout for an operation
+func (t Timeouts) For(operation string) time.Duration {
+	if d, ok := t.PerOperation[operation]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// OperationTimeouts gives every DynamoDB call the Store makes a deadline,
+// so a DynamoDB that stops answering fails requests instead of hanging them
+func OperationTimeouts(timeouts Timeouts) StoreOption {
+	return func(s *Store) {
+		s.timeouts = &timeouts
+	}
+}
+
+// WithCircuitBreaker routes the Store's DynamoDB calls through breaker.
+// Share one breaker between stores so they all stop calling a dead table.
+func WithCircuitBreaker(breaker *CircuitBreaker) StoreOption {
+	return func(s *Store) {
+		s.breaker = breaker
+	}
+}
+
+// CircuitBreaker opens after a run of consecutive failed calls and refuses
+// calls with ErrCircuitOpen until cooldown has passed. It then lets one call
+// through as a trial: success closes it, failure opens it again.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+// NewCircuitBreaker creates a breaker that opens after threshold consecutive
+// failures and stays open for cooldown
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Open reports whether calls are currently being refused
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && b.now().Sub(b.openedAt) < b.cooldown
+}
+
+// allow reports whether a call may be sent, claiming the trial call once
+// the cooldown is over
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trialing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.trialing = true
+	return true
+}
+
+// record counts a call's outcome
+func (b *CircuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// abandon ends a call without counting it, freeing the trial if it was one
+func (b *CircuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialing = false
+}
+
+// isOutage reports whether a call's error means DynamoDB is unreachable or
+// unwell. Errors DynamoDB answered with on purpose, like a failed
+// condition, show it is up.
+func isOutage(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() == smithy.FaultServer
+	}
+	return err != nil
+}
+
+// guardClient rebuilds the Store's client with the timeout, circuit
+// breaker, read-only and write limit middleware, so every call the Store
+// makes goes through them
+func (s *Store) guardClient() {
+	if s.client == nil || (s.timeouts == nil && s.breaker == nil && s.readOnly == nil && s.writeLimiter == nil) {
+		return
+	}
+	s.client = dynamodb.New(s.client.Options(), func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, s.addGuards)
+	})
+}
+
+// addGuards adds the guard middleware at the start of the stack, ahead of
+// the SDK's retries, so the deadline and breaker cover every attempt.
+// Writes refused for read-only mode never reach the breaker, and writes
+// wait for the write limiter before the deadline starts.
+func (s *Store) addGuards(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("StoreGuards",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			operation := middleware.GetOperationName(ctx)
+			if s.readOnly.On() && writeOperations[operation] {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("%s: %w", operation, ErrReadOnly)
+			}
+			if err := s.writeLimiter.Wait(ctx, writeUnits(in.Parameters)); err != nil {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, err
+			}
+			if s.breaker != nil && !s.breaker.allow() {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("%s: %w", operation, ErrCircuitOpen)
+			}
+
+			callCtx := ctx
+			if s.timeouts != nil {
+				if d := s.timeouts.For(operation); d > 0 {
+					var cancel context.CancelFunc
+					callCtx, cancel = context.WithTimeout(ctx, d)
+					defer cancel()
+				}
+			}
+
+			out, md, err := next.HandleInitialize(callCtx, in)
+			// A caller giving up says nothing about DynamoDB's health
+			if s.breaker != nil && ctx.Err() != nil {
+				s.breaker.abandon()
+			} else if s.breaker != nil {
+				s.breaker.record(isOutage(err))
+			}
+			return out, md, err
+		}), middleware.Before)
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"pgregory.net/rapid"
+
+	"LearnSingleTableDesign/models"
+)
+
+// timeGen generates timestamps with nanosecond precision in a few time zones
+var timeGen = rapid.Custom(func(t *rapid.T) time.Time {
+	zones := []*time.Location{time.UTC, time.Local, time.FixedZone("UTC+5:30", 5*3600+1800)}
+	seconds := rapid.Int64Range(0, 4102444800).Draw(t, "seconds") // up to 2100-01-01
+	nanos := rapid.Int64Range(0, 999999999).Draw(t, "nanos")
+	zone := rapid.SampledFrom(zones).Draw(t, "zone")
+	return time.Unix(seconds, nanos).In(zone)
+})
+
+// priceGen generates finite non-negative prices
+var priceGen = rapid.Float64Range(0, 1e9)
+
+var userGen = rapid.Custom(func(t *rapid.T) models.User {
+	return models.User{
+		Email:     rapid.StringMatching(`[a-z0-9.]{1,20}@[a-z]{1,10}\.[a-z]{2,3}`).Draw(t, "email"),
+		Name:      rapid.String().Draw(t, "name"),
+		CreatedAt: timeGen.Draw(t, "created_at"),
+	}
+})
+
+var lineItemGen = rapid.Custom(func(t *rapid.T) models.LineItem {
+	return models.LineItem{
+		ProductID: rapid.StringN(1, 10, -1).Draw(t, "product_id"),
+		Name:      rapid.String().Draw(t, "name"),
+		UnitPrice: priceGen.Draw(t, "unit_price"),
+		Quantity:  rapid.IntRange(1, 100).Draw(t, "quantity"),
+	}
+})
+
+var orderGen = rapid.Custom(func(t *rapid.T) models.Order {
+	return models.Order{
+		OrderID:   rapid.StringN(1, 20, -1).Draw(t, "order_id"),
+		UserEmail: rapid.String().Draw(t, "user_email"),
+		Status:    rapid.SampledFrom([]models.OrderStatus{models.OrderStatusPending, models.OrderStatusProcessing, models.OrderStatusCompleted, models.OrderStatusCancelled}).Draw(t, "status"),
+		Total:     priceGen.Draw(t, "total"),
+		Items:     rapid.SliceOfN(lineItemGen, 1, 10).Draw(t, "items"),
+		CreatedAt: timeGen.Draw(t, "created_at"),
+	}
+})
+
+var productGen = rapid.Custom(func(t *rapid.T) models.Product {
+	return models.Product{
+		ProductID: rapid.StringN(1, 20, -1).Draw(t, "product_id"),
+		Category:  rapid.String().Draw(t, "category"),
+		Name:      rapid.String().Draw(t, "name"),
+		Price:     priceGen.Draw(t, "price"),
+		Stock:     rapid.IntRange(0, 1<<31).Draw(t, "stock"),
+		CreatedAt: timeGen.Draw(t, "created_at"),
+	}
+})
+
+// roundTrip marshals the item the way the Store does and unmarshals it back
+func roundTrip[T any](t *rapid.T, item GenericItem[T]) GenericItem[T] {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		t.Fatalf("Failed to marshal item: %v", err)
+	}
+	var got GenericItem[T]
+	if err := attributevalue.UnmarshalMap(av, &got); err != nil {
+		t.Fatalf("Failed to unmarshal item: %v", err)
+	}
+	return got
+}
+
+// assertSameTime checks the instants match, then copies want into got so the
+// remaining fields can be compared with DeepEqual (locations don't round trip)
+func assertSameTime(t *rapid.T, field string, got *time.Time, want time.Time) {
+	if !got.Equal(want) {
+		t.Fatalf("%s = %v, want %v", field, *got, want)
+	}
+	*got = want
+}
+
+func TestRoundTrip_User(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		user := userGen.Draw(t, "user")
+		item := GenericItem[models.User]{PK: Key.UserPK(user.Email), SK: Key.UserSK(user.Email), EntityType: EntityUser, Data: user}
+
+		got := roundTrip(t, item)
+		assertSameTime(t, "CreatedAt", &got.Data.CreatedAt, user.CreatedAt)
+		if !reflect.DeepEqual(got, item) {
+			t.Fatalf("round trip = %+v, want %+v", got, item)
+		}
+	})
+}
+
+func TestRoundTrip_Order(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		order := orderGen.Draw(t, "order")
+		item := GenericItem[models.Order]{PK: Key.UserPK(order.UserEmail), SK: Key.OrderSK(order.OrderID), EntityType: EntityOrder, Data: order}
+
+		got := roundTrip(t, item)
+		assertSameTime(t, "CreatedAt", &got.Data.CreatedAt, order.CreatedAt)
+		if !reflect.DeepEqual(got, item) {
+			t.Fatalf("round trip = %+v, want %+v", got, item)
+		}
+	})
+}
+
+func TestRoundTrip_Product(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		product := productGen.Draw(t, "product")
+		item := GenericItem[models.Product]{PK: Key.ProductPK(), SK: Key.ProductSK(product.ProductID), EntityType: EntityProduct, Data: product}
+
+		got := roundTrip(t, item)
+		assertSameTime(t, "CreatedAt", &got.Data.CreatedAt, product.CreatedAt)
+		if !reflect.DeepEqual(got, item) {
+			t.Fatalf("round trip = %+v, want %+v", got, item)
+		}
+	})
+}
+
+func TestRoundTrip_ProductThroughStore(t *testing.T) {
+	client, tableName, _, _, _, cleanup := testSetup(t)
+	defer cleanup()
+	store := NewStore(client, tableName)
+
+	rapid.Check(t, func(rt *rapid.T) {
+		product := productGen.Draw(rt, "product")
+		item := GenericItem[models.Product]{PK: Key.ProductPK(), SK: Key.ProductSK(product.ProductID), EntityType: EntityProduct, Data: product}
+		if err := PutItem(context.Background(), store, item); err != nil {
+			rt.Fatalf("Failed to put item: %v", err)
+		}
+
+		var got GenericItem[models.Product]
+		if err := GetItem(context.Background(), store, item.PK, item.SK, &got); err != nil {
+			rt.Fatalf("Failed to get item: %v", err)
+		}
+		assertSameTime(rt, "CreatedAt", &got.Data.CreatedAt, product.CreatedAt)
+		// The Store stamps the envelope times itself
+		if got.CreatedAt.IsZero() || got.UpdatedAt.Before(got.CreatedAt) {
+			rt.Fatalf("Timestamps = %v, %v, want them set by the Store", got.CreatedAt, got.UpdatedAt)
+		}
+		got.CreatedAt, got.UpdatedAt = item.CreatedAt, item.UpdatedAt
+		if !reflect.DeepEqual(got, item) {
+			rt.Fatalf("round trip = %+v, want %+v", got, item)
+		}
+	})
+}
+
+// assertCompiledEncoding checks marshalMap's compiled encoder gives what
+// attributevalue does with the same options
+func assertCompiledEncoding(t interface {
+	Helper()
+	Fatalf(format string, args ...any)
+}, in any) {
+	t.Helper()
+	want, err := attributevalue.MarshalMapWithOptions(in, encodeOptions)
+	if err != nil {
+		t.Fatalf("Failed to marshal %T with attributevalue: %v", in, err)
+	}
+	got, err := marshalMap(in)
+	if err != nil {
+		t.Fatalf("Failed to marshal %T: %v", in, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("marshalMap(%T) = %v, want %v", in, got, want)
+	}
+}
+
+func TestMarshalMap_Compiled(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		order := orderGen.Draw(rt, "order")
+		assertCompiledEncoding(rt, orderItem(order))
+		assertCompiledEncoding(rt, productItem(productGen.Draw(rt, "product")))
+		assertCompiledEncoding(rt, GenericItem[models.User]{Data: userGen.Draw(rt, "user")})
+	})
+
+	expires := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	for _, in := range []any{
+		GenericItem[models.Order]{},
+		&GenericItem[models.Order]{SK2: 1, TTL: 2},
+		(*GenericItem[models.Order])(nil),
+		GenericItem[models.Order]{Data: models.Order{Items: []models.LineItem{}}},
+		GenericItem[models.Coupon]{Data: models.Coupon{Code: "SPRING", ExpiresAt: &expires}},
+		GenericItem[models.Coupon]{},
+		GenericItem[models.Webhook]{Data: models.Webhook{EventTypes: []string{"order.placed", ""}}},
+		GenericItem[models.AuditEntry]{Data: models.AuditEntry{Changes: []models.AuditChange{{Field: "price"}}}},
+		GenericItem[map[string]int]{Data: map[string]int{"a": 1}},
+	} {
+		assertCompiledEncoding(t, in)
+	}
+
+	// Test types attributevalue has to handle aren't compiled
+	if encoderFor(reflect.TypeOf(GenericItem[map[string]int]{})) != nil {
+		t.Error("Compiled an encoder for an item holding a map")
+	}
+	if encoderFor(reflect.TypeOf(GenericItem[models.Order]{})) == nil {
+		t.Error("Didn't compile an encoder for order items")
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/schema"
 )
 
 // Entity types for our single table design
@@ -16,6 +20,44 @@ const (
 	EntityUser    = "USER"
 	EntityOrder   = "ORDER"
 	EntityProduct = "PRODUCT"
+	// EntityProductContent is a product's localized text, one item per locale
+	EntityProductContent = "PRODUCT_CONTENT"
+	EntityPage           = "PAGE"
+	EntityAddress        = "ADDRESS"
+	EntityWebhook        = "WEBHOOK"
+	// EntityWebhookDelivery is one delivery attempt, stored under its webhook
+	EntityWebhookDelivery = "WEBHOOK_DELIVERY"
+	EntityJob             = "JOB"
+	// EntityUserStats is the pre-aggregated order summary in a user's collection
+	EntityUserStats = "USER_STATS"
+	// EntityDailySales is a rollup of one day's completed orders
+	EntityDailySales = "DAILY_SALES"
+	// EntityInventoryHold is stock set aside for a cart, stored under its product
+	EntityInventoryHold = "INVENTORY_HOLD"
+	// EntityPayment is a charge or refund, stored under its order
+	EntityPayment = "PAYMENT"
+	// EntityOutboxEvent is a domain event waiting to be published
+	EntityOutboxEvent = "OUTBOX_EVENT"
+	EntityCoupon      = "COUPON"
+	// EntityAudit records one audited write, stored under the written partition
+	EntityAudit = "AUDIT"
+	// EntityCouponRedemption is one user's use of a coupon, stored under it
+	EntityCouponRedemption = "COUPON_REDEMPTION"
+	// EntityContactMessage is a message sent through the contact form
+	EntityContactMessage = "CONTACT_MESSAGE"
+	// EntityOrderLog is an event in an order's history, stored with its
+	// payments
+	EntityOrderLog = "ORDER_LOG"
+	// EntityCart is a signed-in user's cart, stored in their collection
+	EntityCart = "CART"
+	// EntitySessionCart is an anonymous visitor's cart, stored under their
+	// session until they sign in
+	EntitySessionCart = "SESSION_CART"
+	// EntityImpersonation is an admin session viewing the store as a user
+	EntityImpersonation = "IMPERSONATION"
+	// EntityNotificationPrefs is which emails a user wants, stored in their
+	// collection
+	EntityNotificationPrefs = "NOTIFICATION_PREFS"
 )
 
 // Custom key types for type safety
@@ -26,19 +68,98 @@ type SortKey string
 type Store struct {
 	client    *dynamodb.Client
 	tableName string
+	// enforceKeys rejects writes whose keys don't match their entity type
+	enforceKeys bool
+	// writeHooks are called before each write is sent to DynamoDB
+	writeHooks []WriteHook
+	// recentWrites forces consistent reads of partitions a session just wrote
+	recentWrites *RecentWrites
+	// maxPageSize caps the items returned per query page; 0 means no cap
+	maxPageSize int32
+	// audit writes an audit entry in the same transaction as each write
+	audit bool
+	// changeHooks are called around each write, by operation
+	changeHooks map[Operation][]ChangeHook
+	// timeouts bound each DynamoDB call; nil means no deadline
+	timeouts *Timeouts
+	// breaker fails calls fast while DynamoDB is down
+	breaker *CircuitBreaker
+	// readOnly refuses writes while it is on
+	readOnly *ReadOnlySwitch
+	// writeLimiter paces writes to a write capacity budget; nil means no limit
+	writeLimiter *WriteLimiter
+	// itemSizeLimit refuses puts of larger items; 0 means MaxItemSize
+	itemSizeLimit int
+	// compress is the minimum data size to compress at, by entity type
+	compress map[string]int
+	// encoding and dataEncoder marshal entity data; a nil dataEncoder
+	// means the defaults
+	encoding    EncodingOptions
+	dataEncoder *attributevalue.Encoder
+}
+
+// StoreOption configures optional Store behaviour
+type StoreOption func(*Store)
+
+// EnforceKeyConsistency makes the Store reject writes whose PK/SK don't match
+// the key pattern registered for the item's entity type
+func EnforceKeyConsistency() StoreOption {
+	return func(s *Store) {
+		s.enforceKeys = true
+	}
+}
+
+// MaxPageSize caps how many items a single query page may return.
+// Larger or unset QueryOptions.Limit values are clamped to n, so callers
+// passing a page size from a request can't ask for unbounded pages.
+func MaxPageSize(n int32) StoreOption {
+	return func(s *Store) {
+		s.maxPageSize = n
+	}
+}
+
+// WriteOp describes a write that is about to be sent to DynamoDB
+type WriteOp struct {
+	PK         PrimaryKey
+	SK         SortKey
+	EntityType string
+	// Conditional is true when the write is guarded by a condition expression
+	Conditional bool
+	// Item is the marshalled item being put, for hooks that mirror writes
+	Item map[string]types.AttributeValue
+}
+
+// WriteHook is called with the request context before each write. Unlike a
+// ChangeHook it sees only puts, as each item is marshalled.
+type WriteHook func(ctx context.Context, op WriteOp)
+
+// WithWriteHook registers a hook that is called before each write
+func WithWriteHook(hook WriteHook) StoreOption {
+	return func(s *Store) {
+		s.writeHooks = append(s.writeHooks, hook)
+	}
 }
 
 // NewStore creates a new Store instance
-func NewStore(client *dynamodb.Client, tableName string) *Store {
-	return &Store{
+func NewStore(client *dynamodb.Client, tableName string, opts ...StoreOption) *Store {
+	s := &Store{
 		client:    client,
 		tableName: tableName,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.guardClient()
+	return s
 }
 
 // Common errors
 var (
-	ErrNotFound = errors.New("item not found")
+	ErrNotFound    = errors.New("item not found")
+	ErrKeyMismatch = errors.New("item keys do not match entity type")
+	// ErrConditionFailed means a conditional write was rejected because
+	// the stored item didn't match the condition
+	ErrConditionFailed = errors.New("condition check failed")
 )
 
 // GenericItem makes the Data field type-safe
@@ -47,12 +168,26 @@ type GenericItem[T any] struct {
 	SK         SortKey    `dynamodbav:"SK"`
 	EntityType string     `dynamodbav:"entity_type"`
 	Data       T          `dynamodbav:"data"`
-}
-
-// PageToken represents an opaque token for pagination
-type PageToken struct {
-	PK PrimaryKey `dynamodbav:"PK"`
-	SK SortKey    `dynamodbav:"SK"`
+	// SK2 is the LSI1 sort key (creation time in epoch milliseconds).
+	// Items without it are left out of the index.
+	SK2 int64 `dynamodbav:"SK2,omitempty"`
+	// GSI1PK and GSI1SK key the overloaded GSI1. Items without them are
+	// left out of the index.
+	GSI1PK PrimaryKey `dynamodbav:"GSI1PK,omitempty"`
+	GSI1SK SortKey    `dynamodbav:"GSI1SK,omitempty"`
+	// GSI2PK and GSI2SK key GSI2, for items already using GSI1
+	GSI2PK PrimaryKey `dynamodbav:"GSI2PK,omitempty"`
+	GSI2SK SortKey    `dynamodbav:"GSI2SK,omitempty"`
+	// GSI3PK and GSI3SK key GSI3, for items already using GSI1 and GSI2
+	GSI3PK PrimaryKey `dynamodbav:"GSI3PK,omitempty"`
+	GSI3SK SortKey    `dynamodbav:"GSI3SK,omitempty"`
+	// TTL is when DynamoDB may delete the item, in epoch seconds. Items
+	// without it are kept.
+	TTL int64 `dynamodbav:"ttl,omitempty"`
+	// CreatedAt and UpdatedAt are set by the Store on every write; callers
+	// leave them zero. A stored item's CreatedAt never changes.
+	CreatedAt time.Time `dynamodbav:"created_at,omitempty"`
+	UpdatedAt time.Time `dynamodbav:"updated_at,omitempty"`
 }
 
 // QueryOptions contains options for querying items
@@ -61,6 +196,13 @@ type QueryOptions struct {
 	Limit int32
 	// PageToken is the token for getting the next page
 	PageToken *PageToken
+	// Descending returns items in reverse sort key order
+	Descending bool
+	// Filter drops items that don't match it after they are read, e.g.
+	// AttributeEquals("data.status", "pending"). Filtered items still count
+	// towards Limit and the read capacity used, so a page may come back
+	// short or empty while HasMore is true.
+	Filter Condition
 }
 
 // QueryResult contains the query results and pagination info
@@ -70,68 +212,591 @@ type QueryResult[T any] struct {
 	// NextPageToken is the token for getting the next page
 	// If nil, there are no more pages
 	NextPageToken *PageToken
+	PageInfo
+}
+
+// DataPage is a page of query results decoded to just their data
+type DataPage[T any] struct {
+	Items         []T
+	NextPageToken *PageToken
+	PageInfo
 }
 
-// PutItem is a generic function to put any item into DynamoDB
-func PutItem[T any](ctx context.Context, s *Store, item GenericItem[T]) error {
-	av, err := attributevalue.MarshalMap(item)
+// PageInfo describes a page of query results for rendering pagination controls
+type PageInfo struct {
+	// Count is the number of items returned
+	Count int32
+	// ScannedCount is the number of items read, before any filter
+	ScannedCount int32
+	// HasMore is true when there is a NextPageToken. DynamoDB may still
+	// return an empty last page when the limit lands on the final item.
+	HasMore bool
+}
+
+// PutItem is a generic function to put any item into DynamoDB. With
+// conds, the put only happens if they all hold for the stored item, and
+// returns ErrConditionFailed otherwise.
+func PutItem[T any](ctx context.Context, s *Store, item GenericItem[T], conds ...Condition) error {
+	cond, ok, err := conditionOf(conds)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return putItemIf(ctx, s, item, cond)
+	}
+	if err := s.checkKeys(ctx, item.EntityType, item.PK, item.SK); err != nil {
+		return err
+	}
+
+	av, err := marshalItem(s, item)
 	if err != nil {
 		return fmt.Errorf("failed to marshal item: %w", err)
 	}
+	stored, err := s.storedItem(item.EntityType, item.PK, item.SK, av)
+	if err != nil {
+		return err
+	}
+
+	s.runWriteHooks(ctx, WriteOp{PK: item.PK, SK: item.SK, EntityType: item.EntityType, Item: av})
 
-	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+	return s.put(ctx, &types.Put{
 		TableName: aws.String(s.tableName),
-		Item:      av,
+		Item:      stored,
+	})
+}
+
+// put sends a single put, in a transaction with its audit entry when
+// auditing is on
+func (s *Store) put(ctx context.Context, put *types.Put) error {
+	if s.audit {
+		return s.transactPut(ctx, put, nil)
+	}
+	stampPut(put, time.Now())
+	changes := s.changesOf([]types.TransactWriteItem{{Put: put}}, false)
+	s.runChangeHooks(ctx, BeforeWrite, changes)
+	err := s.putItem(ctx, put)
+	if err == nil {
+		s.runChangeHooks(ctx, AfterWrite, changes)
+	}
+	return err
+}
+
+// putItem sends a stamped put on its own, as an update if it may overwrite
+// an item
+func (s *Store) putItem(ctx context.Context, put *types.Put) error {
+	if update := overwriteUpdate(put); update != nil {
+		_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                           update.TableName,
+			Key:                                 update.Key,
+			UpdateExpression:                    update.UpdateExpression,
+			ConditionExpression:                 update.ConditionExpression,
+			ExpressionAttributeNames:            update.ExpressionAttributeNames,
+			ExpressionAttributeValues:           update.ExpressionAttributeValues,
+			ReturnValuesOnConditionCheckFailure: update.ReturnValuesOnConditionCheckFailure,
+		})
+		return err
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                           put.TableName,
+		Item:                                put.Item,
+		ConditionExpression:                 put.ConditionExpression,
+		ExpressionAttributeNames:            put.ExpressionAttributeNames,
+		ExpressionAttributeValues:           put.ExpressionAttributeValues,
+		ReturnValuesOnConditionCheckFailure: put.ReturnValuesOnConditionCheckFailure,
 	})
 	return err
 }
 
+// condition guards a write with a DynamoDB condition expression
+type condition struct {
+	expr   string
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+// putItemIf puts an item only if cond holds for the stored item,
+// returning ErrConditionFailed otherwise. Any updates are applied in the
+// same transaction, so they happen only when the put does.
+func putItemIf[T any](ctx context.Context, s *Store, item GenericItem[T], cond condition, updates ...*types.Update) error {
+	put, err := conditionalPut(ctx, s, item, cond)
+	if err != nil {
+		return err
+	}
+	if len(updates) == 0 {
+		err = s.put(ctx, put)
+	} else {
+		err = s.transactPut(ctx, put, updates)
+	}
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) || putConditionFailed(err) {
+		return ErrConditionFailed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+	return nil
+}
+
+// conditionalPut checks and marshals item into a put guarded by cond, and
+// runs the write hooks, for writes that go out as part of a transaction
+func conditionalPut[T any](ctx context.Context, s *Store, item GenericItem[T], cond condition) (*types.Put, error) {
+	if err := s.checkKeys(ctx, item.EntityType, item.PK, item.SK); err != nil {
+		return nil, err
+	}
+
+	av, err := marshalItem(s, item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal item: %w", err)
+	}
+	stored, err := s.storedItem(item.EntityType, item.PK, item.SK, av)
+	if err != nil {
+		return nil, err
+	}
+
+	s.runWriteHooks(ctx, WriteOp{PK: item.PK, SK: item.SK, EntityType: item.EntityType, Conditional: true, Item: av})
+
+	return &types.Put{
+		TableName:                 aws.String(s.tableName),
+		Item:                      stored,
+		ConditionExpression:       aws.String(cond.expr),
+		ExpressionAttributeNames:  cond.names,
+		ExpressionAttributeValues: cond.values,
+	}, nil
+}
+
+// transactPuts writes the puts in one transaction, returning
+// ErrConditionFailed if any of their conditions didn't hold
+func (s *Store) transactPuts(ctx context.Context, puts ...*types.Put) error {
+	return s.transactPutsWith(ctx, puts, nil)
+}
+
+// transactPutsWith is transactPuts with updates written in the same
+// transaction
+func (s *Store) transactPutsWith(ctx context.Context, puts []*types.Put, updates []*types.Update) error {
+	items := make([]types.TransactWriteItem, 0, len(puts)+len(updates))
+	for _, put := range puts {
+		items = append(items, types.TransactWriteItem{Put: put})
+	}
+	for _, update := range updates {
+		update.TableName = aws.String(s.tableName)
+		items = append(items, types.TransactWriteItem{Update: update})
+	}
+	_, err := s.transactWrite(ctx, items)
+	if conditionCancelled(err) {
+		return ErrConditionFailed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write transaction: %w", err)
+	}
+	return nil
+}
+
+// transactPut writes put and updates in one transaction
+func (s *Store) transactPut(ctx context.Context, put *types.Put, updates []*types.Update) error {
+	items := []types.TransactWriteItem{{Put: put}}
+	for _, update := range updates {
+		update.TableName = aws.String(s.tableName)
+		items = append(items, types.TransactWriteItem{Update: update})
+	}
+	_, err := s.transactWrite(ctx, items)
+	return err
+}
+
+// maxTransactItems is the most items DynamoDB accepts in one
+// TransactWriteItems call
+const maxTransactItems = 100
+
+// ErrTransactionTooLarge means a transaction had more items than DynamoDB
+// accepts, so it was refused before it was sent
+var ErrTransactionTooLarge = errors.New("transaction too large")
+
+// transactionSize is how many items a transaction with writes puts, updates
+// and deletes and checks condition checks sends, counting the audit entry
+// of each write when auditing is on
+func (s *Store) transactionSize(writes, checks int) int {
+	if s.audit {
+		writes *= 2
+	}
+	return writes + checks
+}
+
+// transactWrite sends a transaction, running the change hooks around it and
+// adding an audit entry for each of its writes when auditing is on. The
+// entries go after the writes, so cancellation reasons still line up with
+// the caller's items.
+func (s *Store) transactWrite(ctx context.Context, items []types.TransactWriteItem) (*dynamodb.TransactWriteItemsOutput, error) {
+	checks := 0
+	for _, item := range items {
+		if item.ConditionCheck != nil {
+			checks++
+		}
+	}
+	if size := s.transactionSize(len(items)-checks, checks); size > maxTransactItems {
+		return nil, fmt.Errorf("%w: %d items, over DynamoDB's limit of %d", ErrTransactionTooLarge, size, maxTransactItems)
+	}
+	stampWrites(items, time.Now())
+	changes := s.changesOf(items, true)
+	s.runChangeHooks(ctx, BeforeWrite, changes)
+	out, err := s.sendTransaction(ctx, items)
+	if err == nil {
+		s.runChangeHooks(ctx, AfterWrite, changes)
+	}
+	return out, err
+}
+
+// sendTransaction sends items, with their audit entries when auditing is
+// on, the puts that may overwrite an item as updates
+func (s *Store) sendTransaction(ctx context.Context, items []types.TransactWriteItem) (*dynamodb.TransactWriteItemsOutput, error) {
+	if s.audit {
+		return s.sendAudited(ctx, items)
+	}
+	return s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: overwritesAsUpdates(items),
+	})
+}
+
+// putConditionFailed reports whether a transactPut was cancelled because
+// the put's condition didn't hold
+func putConditionFailed(err error) bool {
+	var cancelled *types.TransactionCanceledException
+	return errors.As(err, &cancelled) && len(cancelled.CancellationReasons) > 0 &&
+		aws.ToString(cancelled.CancellationReasons[0].Code) == "ConditionalCheckFailed"
+}
+
+// putNewItemWithUpdate creates item and applies update in one transaction,
+// so the update happens exactly once per item created. If the item already
+// exists it is overwritten on its own and update is skipped. Write hooks
+// see a single write either way.
+func putNewItemWithUpdate[T any](ctx context.Context, s *Store, item GenericItem[T], update *types.Update) error {
+	if err := s.checkKeys(ctx, item.EntityType, item.PK, item.SK); err != nil {
+		return err
+	}
+
+	av, err := marshalItem(s, item)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item: %w", err)
+	}
+	stored, err := s.storedItem(item.EntityType, item.PK, item.SK, av)
+	if err != nil {
+		return err
+	}
+
+	s.runWriteHooks(ctx, WriteOp{PK: item.PK, SK: item.SK, EntityType: item.EntityType, Item: av})
+
+	err = s.transactPut(ctx, &types.Put{
+		TableName:           aws.String(s.tableName),
+		Item:                stored,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	}, []*types.Update{update})
+	if putConditionFailed(err) {
+		err = s.put(ctx, &types.Put{
+			TableName: aws.String(s.tableName),
+			Item:      stored,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+	return nil
+}
+
 // GetItem is a generic function to get any item from DynamoDB
 func GetItem[T any](ctx context.Context, s *Store, pk PrimaryKey, sk SortKey, out *GenericItem[T]) error {
+	item, err := getRawItem(ctx, s, pk, sk)
+	if err != nil {
+		return err
+	}
+
+	if err := unmarshalMap(item, out); err != nil {
+		return fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+
+	return nil
+}
+
+// Exists reports whether an item is stored, reading only its partition key
+// so nothing is unmarshalled
+func (s *Store) Exists(ctx context.Context, pk PrimaryKey, sk SortKey) (bool, error) {
 	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(s.tableName),
 		Key: map[string]types.AttributeValue{
 			"PK": &types.AttributeValueMemberS{Value: string(pk)},
 			"SK": &types.AttributeValueMemberS{Value: string(sk)},
 		},
+		ProjectionExpression: aws.String("PK"),
+		ConsistentRead:       s.consistentRead(ctx, pk),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to get item: %w", err)
+		return false, fmt.Errorf("failed to check item: %w", err)
 	}
+	return result.Item != nil, nil
+}
 
-	if result.Item == nil {
-		return ErrNotFound
+// getRawItem reads an item without decoding it, for items that don't fit
+// the GenericItem envelope
+func getRawItem(ctx context.Context, s *Store, pk PrimaryKey, sk SortKey) (RawItem, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: string(pk)},
+			"SK": &types.AttributeValueMemberS{Value: string(sk)},
+		},
+		ConsistentRead: s.consistentRead(ctx, pk),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
 	}
 
-	if err := attributevalue.UnmarshalMap(result.Item, out); err != nil {
-		return fmt.Errorf("failed to unmarshal item: %w", err)
+	if result.Item == nil {
+		return nil, ErrNotFound
 	}
-
-	return nil
+	return result.Item, nil
 }
 
 // Query is a generic function to query items from DynamoDB with pagination support
 func Query[T any](ctx context.Context, s *Store, pk PrimaryKey, skPrefix string, opts *QueryOptions) (*QueryResult[T], error) {
-	queryInput := &dynamodb.QueryInput{
-		TableName:              aws.String(s.tableName),
-		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :sk)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: string(pk)},
-			":sk": &types.AttributeValueMemberS{Value: skPrefix},
+	return runQuery[T](ctx, s, prefixQuery(ctx, s, pk, skPrefix), opts)
+}
+
+// QueryData is Query for callers that only want the items' data. Each
+// item's data is decoded straight into the returned slice, skipping the
+// GenericItem and the copy out of it.
+func QueryData[T any](ctx context.Context, s *Store, pk PrimaryKey, skPrefix string, opts *QueryOptions) (*DataPage[T], error) {
+	return runQueryData[T](ctx, s, prefixQuery(ctx, s, pk, skPrefix), opts)
+}
+
+// prefixQuery is the input of a base table query for items whose SK begins
+// with skPrefix
+func prefixQuery(ctx context.Context, s *Store, pk PrimaryKey, skPrefix string) query {
+	return query{
+		input: &dynamodb.QueryInput{
+			TableName:      aws.String(s.tableName),
+			ConsistentRead: s.consistentRead(ctx, pk),
+		},
+		key: partitionKey("PK", pk).And(expression.Key("SK").BeginsWith(skPrefix)),
+	}
+}
+
+// QueryByLSI is a generic function to query a partition through LSI1, which
+// orders the items that have an SK2 by creation time
+func QueryByLSI[T any](ctx context.Context, s *Store, pk PrimaryKey, opts *QueryOptions) (*QueryResult[T], error) {
+	return runQuery[T](ctx, s, lsiQuery(ctx, s, pk), opts)
+}
+
+// QueryDataByLSI is QueryByLSI decoding only the items' data, like QueryData
+func QueryDataByLSI[T any](ctx context.Context, s *Store, pk PrimaryKey, opts *QueryOptions) (*DataPage[T], error) {
+	return runQueryData[T](ctx, s, lsiQuery(ctx, s, pk), opts)
+}
+
+func lsiQuery(ctx context.Context, s *Store, pk PrimaryKey) query {
+	return query{
+		input: &dynamodb.QueryInput{
+			TableName: aws.String(s.tableName),
+			IndexName: aws.String(schema.LSI1),
+			// Local indexes support consistent reads, unlike global ones
+			ConsistentRead: s.consistentRead(ctx, pk),
+		},
+		key: partitionKey("PK", pk),
+	}
+}
+
+// QueryByGSI queries GSI1 for items whose GSI1SK begins with skPrefix.
+// Global indexes are eventually consistent, so a write may not show up in
+// the results straight away.
+func QueryByGSI[T any](ctx context.Context, s *Store, pk PrimaryKey, skPrefix string, opts *QueryOptions) (*QueryResult[T], error) {
+	return runQuery[T](ctx, s, indexQuery(s, schema.GSI1, pk, skPrefix), opts)
+}
+
+// QueryByGSI2 queries GSI2 for items whose GSI2SK begins with skPrefix
+func QueryByGSI2[T any](ctx context.Context, s *Store, pk PrimaryKey, skPrefix string, opts *QueryOptions) (*QueryResult[T], error) {
+	return runQuery[T](ctx, s, indexQuery(s, schema.GSI2, pk, skPrefix), opts)
+}
+
+// QueryByGSI3 queries GSI3 for items whose GSI3SK begins with skPrefix
+func QueryByGSI3[T any](ctx context.Context, s *Store, pk PrimaryKey, skPrefix string, opts *QueryOptions) (*QueryResult[T], error) {
+	return runQuery[T](ctx, s, indexQuery(s, schema.GSI3, pk, skPrefix), opts)
+}
+
+// QueryDataByIndex queries one of the overloaded GSIs, schema.GSI1 to
+// GSI3, decoding only the items' data like QueryData
+func QueryDataByIndex[T any](ctx context.Context, s *Store, index string, pk PrimaryKey, skPrefix string, opts *QueryOptions) (*DataPage[T], error) {
+	return runQueryData[T](ctx, s, indexQuery(s, index, pk, skPrefix), opts)
+}
+
+// indexQuery is the input of a query on an overloaded GSI whose keys are
+// <index>PK and <index>SK
+func indexQuery(s *Store, index string, pk PrimaryKey, skPrefix string) query {
+	return query{
+		input: &dynamodb.QueryInput{
+			TableName: aws.String(s.tableName),
+			IndexName: aws.String(index),
 		},
+		key: partitionKey(index+"PK", pk).And(expression.Key(index + "SK").BeginsWith(skPrefix)),
+	}
+}
+
+// query is the input of a query and its key condition, which are built
+// into the input together with any filter so their placeholders can't
+// clash
+type query struct {
+	input *dynamodb.QueryInput
+	key   expression.KeyConditionBuilder
+}
+
+// partitionKey is the key condition selecting one partition, by the name
+// of its partition key attribute
+func partitionKey(name string, pk PrimaryKey) expression.KeyConditionBuilder {
+	return expression.Key(name).Equal(expression.Value(string(pk)))
+}
+
+// build sets the query's key condition and filter expressions, with their
+// placeholders, on its input
+func (q query) build(filter Condition) (*dynamodb.QueryInput, error) {
+	if filter.err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", filter.err)
+	}
+	builder := expression.NewBuilder().WithKeyCondition(q.key)
+	if filter.builder.IsSet() {
+		builder = builder.WithFilter(filter.builder)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	q.input.KeyConditionExpression = expr.KeyCondition()
+	q.input.FilterExpression = expr.Filter()
+	q.input.ExpressionAttributeNames = expr.Names()
+	q.input.ExpressionAttributeValues = expr.Values()
+	return q.input, nil
+}
+
+// runQuery applies the query options, runs the query and decodes the page
+func runQuery[T any](ctx context.Context, s *Store, q query, opts *QueryOptions) (*QueryResult[T], error) {
+	page, err := s.queryPage(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	items, err := decodeItems[T](page.Items)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryResult[T]{
+		Items:         items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
+	}, nil
+}
+
+// runQueryData is runQuery decoding only the items' data
+func runQueryData[T any](ctx context.Context, s *Store, q query, opts *QueryOptions) (*DataPage[T], error) {
+	page, err := s.queryPage(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	items, err := decodeData[T](page.Items)
+	if err != nil {
+		return nil, err
+	}
+	return &DataPage[T]{
+		Items:         items,
+		NextPageToken: page.NextPageToken,
+		PageInfo:      page.PageInfo,
+	}, nil
+}
+
+// decodeItems decodes raw items into GenericItems
+func decodeItems[T any](raw []RawItem) ([]GenericItem[T], error) {
+	var items []GenericItem[T]
+	for _, item := range raw {
+		genericItem, err := Decode[T](item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, genericItem)
+	}
+	return items, nil
+}
+
+// decodeData decodes the data attribute of raw items in place into one
+// slice, allocated once at its final size
+func decodeData[T any](raw []RawItem) ([]T, error) {
+	items := make([]T, len(raw))
+	for i, item := range raw {
+		item, err := inflate(item)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := item[dataAttribute]
+		if !ok {
+			continue
+		}
+		if err := unmarshal(data, &items[i]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal item: %w", err)
+		}
+	}
+	return items, nil
+}
+
+// RawItem is an item read before its entity type is known
+type RawItem map[string]types.AttributeValue
+
+// EntityType returns the item's entity_type attribute
+func (r RawItem) EntityType() string {
+	if v, ok := r["entity_type"].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// Decode unmarshals a raw item into a typed GenericItem
+func Decode[T any](raw RawItem) (GenericItem[T], error) {
+	var item GenericItem[T]
+	if err := unmarshalMap(raw, &item); err != nil {
+		return item, fmt.Errorf("failed to unmarshal item: %w", err)
+	}
+	return item, nil
+}
+
+// CollectionPage is a page of heterogeneous items from one partition
+type CollectionPage struct {
+	Items         []RawItem
+	NextPageToken *PageToken
+	PageInfo
+}
+
+// QueryCollection reads a page of a partition's whole item collection,
+// whatever the entity types, so related entities come back in one query
+func QueryCollection(ctx context.Context, s *Store, pk PrimaryKey, opts *QueryOptions) (*CollectionPage, error) {
+	return s.queryPage(ctx, query{
+		input: &dynamodb.QueryInput{
+			TableName:      aws.String(s.tableName),
+			ConsistentRead: s.consistentRead(ctx, pk),
+		},
+		key: partitionKey("PK", pk),
+	}, opts)
+}
+
+// queryPage applies the query options and runs the query without decoding items
+func (s *Store) queryPage(ctx context.Context, q query, opts *QueryOptions) (*CollectionPage, error) {
+	var filter Condition
+	if opts != nil {
+		filter = opts.Filter
+	}
+	queryInput, err := q.build(filter)
+	if err != nil {
+		return nil, err
 	}
 
 	// Apply pagination options if provided
+	if limit := s.pageLimit(opts); limit > 0 {
+		queryInput.Limit = aws.Int32(limit)
+	}
 	if opts != nil {
-		if opts.Limit > 0 {
-			queryInput.Limit = aws.Int32(opts.Limit)
+		if opts.Descending {
+			queryInput.ScanIndexForward = aws.Bool(false)
 		}
 		if opts.PageToken != nil {
-			exclusiveStartKey, err := attributevalue.MarshalMap(opts.PageToken)
-			if err != nil {
-				return nil, fmt.Errorf("failed to marshal page token: %w", err)
-			}
-			queryInput.ExclusiveStartKey = exclusiveStartKey
+			queryInput.ExclusiveStartKey = opts.PageToken.Raw()
 		}
 	}
 
@@ -140,26 +805,75 @@ func Query[T any](ctx context.Context, s *Store, pk PrimaryKey, skPrefix string,
 		return nil, fmt.Errorf("failed to query items: %w", err)
 	}
 
-	var items []GenericItem[T]
-	for _, item := range result.Items {
-		var genericItem GenericItem[T]
-		if err := attributevalue.UnmarshalMap(item, &genericItem); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal item: %w", err)
-		}
-		items = append(items, genericItem)
-	}
-
-	// Handle pagination result
-	var nextPageToken *PageToken
-	if result.LastEvaluatedKey != nil {
-		nextPageToken = &PageToken{}
-		if err := attributevalue.UnmarshalMap(result.LastEvaluatedKey, nextPageToken); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal last evaluated key: %w", err)
-		}
+	items := make([]RawItem, len(result.Items))
+	for i, item := range result.Items {
+		items[i] = item
 	}
 
-	return &QueryResult[T]{
+	return &CollectionPage{
 		Items:         items,
-		NextPageToken: nextPageToken,
+		NextPageToken: NewPageToken(result.LastEvaluatedKey),
+		PageInfo: PageInfo{
+			Count:        result.Count,
+			ScannedCount: result.ScannedCount,
+			HasMore:      len(result.LastEvaluatedKey) > 0,
+		},
 	}, nil
 }
+
+// pageLimit is the requested page size clamped to the store's maximum
+func (s *Store) pageLimit(opts *QueryOptions) int32 {
+	var limit int32
+	if opts != nil {
+		limit = opts.Limit
+	}
+	if s.maxPageSize > 0 && (limit <= 0 || limit > s.maxPageSize) {
+		return s.maxPageSize
+	}
+	return limit
+}
+
+// checkKeys verifies the keys against the registered pattern for the entity type
+// when key enforcement is enabled and hasn't been skipped for this context
+func (s *Store) checkKeys(ctx context.Context, entityType string, pk PrimaryKey, sk SortKey) error {
+	if !s.enforceKeys || keyCheckSkipped(ctx) {
+		return nil
+	}
+	pattern, ok := EntityKeyPattern(entityType)
+	if !ok {
+		return fmt.Errorf("%w: unknown entity type %q", ErrKeyMismatch, entityType)
+	}
+	if !pattern.Matches(pk, sk) {
+		return fmt.Errorf("%w: %s item cannot be stored under %s/%s", ErrKeyMismatch, entityType, pk, sk)
+	}
+	return nil
+}
+
+// runWriteHooks calls each registered write hook in order
+func (s *Store) runWriteHooks(ctx context.Context, op WriteOp) {
+	for _, hook := range s.writeHooks {
+		hook(ctx, op)
+	}
+}
+
+// consistentRead returns whether a read of the partition should be strongly
+// consistent, which is only when the session wrote to it moments ago
+func (s *Store) consistentRead(ctx context.Context, pk PrimaryKey) *bool {
+	if s.recentWrites != nil && s.recentWrites.shouldReadConsistently(ctx, pk) {
+		return aws.Bool(true)
+	}
+	return nil
+}
+
+type skipKeyCheckKey struct{}
+
+// SkipKeyCheck returns a context under which writes bypass key enforcement.
+// It is meant for migrations that need to move items between key layouts.
+func SkipKeyCheck(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipKeyCheckKey{}, true)
+}
+
+func keyCheckSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipKeyCheckKey{}).(bool)
+	return skip
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/testutil"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+var benchItems = flag.Int("bench.items", 100, "number of items written or queried per benchmark iteration")
+
+// benchStore creates a Store on a fresh test table for a benchmark
+func benchStore(b *testing.B) (*Store, func()) {
+	b.Helper()
+	client := testutil.CreateTestClient(b)
+	tableName := testutil.SetupTestTable(b, client)
+	return NewStore(client, tableName), func() {
+		testutil.CleanupTestTable(b, client, tableName)
+	}
+}
+
+// benchOrderItems builds n order items for one user
+func benchOrderItems(n int, prefix string) []GenericItem[models.Order] {
+	user := fixtures.NewUser().Build()
+	items := make([]GenericItem[models.Order], n)
+	for i := range items {
+		order := fixtures.NewOrderFor(user).WithID(fmt.Sprintf("%s%06d", prefix, i)).Build()
+		items[i] = GenericItem[models.Order]{
+			PK:         Key.UserPK(order.UserEmail),
+			SK:         Key.OrderSK(order.OrderID),
+			EntityType: EntityOrder,
+			Data:       order,
+		}
+	}
+	return items
+}
+
+func Benchmark_Put(b *testing.B) {
+	store, cleanup := benchStore(b)
+	defer cleanup()
+
+	items := benchOrderItems(*benchItems, "ORD")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := PutItem(context.Background(), store, items[i%len(items)]); err != nil {
+			b.Fatalf("Failed to put item: %v", err)
+		}
+	}
+}
+
+func Benchmark_QueryPage(b *testing.B) {
+	store, cleanup := benchStore(b)
+	defer cleanup()
+
+	items := benchOrderItems(*benchItems, "ORD")
+	if _, err := BatchPutItems(context.Background(), store, items); err != nil {
+		b.Fatalf("Failed to seed items: %v", err)
+	}
+
+	pk := Key.UserPK(fixtures.NewUser().Build().Email)
+	for _, pageSize := range []int32{10, 25, 100} {
+		b.Run(fmt.Sprintf("limit=%d", pageSize), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Query[models.Order](context.Background(), store, pk, "ORDER#", &QueryOptions{Limit: pageSize}); err != nil {
+					b.Fatalf("Failed to query page: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_BatchWrite(b *testing.B) {
+	store, cleanup := benchStore(b)
+	defer cleanup()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		items := benchOrderItems(*benchItems, fmt.Sprintf("ORD%d-", i))
+		b.StartTimer()
+		result, err := BatchPutItems(context.Background(), store, items)
+		if err != nil {
+			b.Fatalf("Failed to batch write items: %v", err)
+		}
+		if err := result.Err(); err != nil {
+			b.Fatalf("Failed to batch write items: %v", err)
+		}
+	}
+}
+
+// benchRawOrders marshals n order items, as a query page returns them
+func benchRawOrders(b *testing.B, n int) []RawItem {
+	b.Helper()
+	raw := make([]RawItem, n)
+	for i, item := range benchOrderItems(n, "ORD") {
+		av, err := marshalMap(item)
+		if err != nil {
+			b.Fatal(err)
+		}
+		raw[i] = av
+	}
+	return raw
+}
+
+// Benchmark_DecodePage compares decoding a 1000 item page into
+// GenericItems and copying their data out, as Query callers did, with
+// decoding the data straight into the result as QueryData does
+func Benchmark_DecodePage(b *testing.B) {
+	raw := benchRawOrders(b, 1000)
+	b.Run("items", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			items, err := decodeItems[models.Order](raw)
+			if err != nil {
+				b.Fatal(err)
+			}
+			orders := make([]models.Order, len(items))
+			for j, item := range items {
+				orders[j] = item.Data
+			}
+		}
+	})
+	b.Run("data", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeData[models.Order](raw); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Benchmark_MarshalItems compares attributevalue's reflection with the
+// compiled encoders marshalMap caches, on the items of a 1000 order write
+func Benchmark_MarshalItems(b *testing.B) {
+	items := benchOrderItems(1000, "ORD")
+	b.Run("attributevalue", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, item := range items {
+				if _, err := attributevalue.MarshalMapWithOptions(item, encodeOptions); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("compiled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, item := range items {
+				if _, err := marshalMap(item); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
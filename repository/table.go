@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// TableRepository reports on the table as a whole, for the admin dashboard
+type TableRepository struct {
+	store *Store
+}
+
+func NewTableRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *TableRepository {
+	return &TableRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// TableInfo is DynamoDB's description of the table. ItemCount and SizeBytes
+// are refreshed by DynamoDB roughly every six hours.
+type TableInfo struct {
+	Name      string
+	Status    string
+	ItemCount int64
+	SizeBytes int64
+}
+
+// Describe returns the table's status and approximate size
+func (r *TableRepository) Describe(ctx context.Context) (*TableInfo, error) {
+	out, err := r.store.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(r.store.tableName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe table: %w", err)
+	}
+	return &TableInfo{
+		Name:      r.store.tableName,
+		Status:    string(out.Table.TableStatus),
+		ItemCount: aws.ToInt64(out.Table.ItemCount),
+		SizeBytes: aws.ToInt64(out.Table.TableSizeBytes),
+	}, nil
+}
+
+// CountByEntityType counts the items of each entity type. There's no index
+// for this, so it scans the whole table, projecting only entity_type to
+// keep the reads small; callers should cache the result.
+func (r *TableRepository) CountByEntityType(ctx context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+	paginator := dynamodb.NewScanPaginator(r.store.client, &dynamodb.ScanInput{
+		TableName:            aws.String(r.store.tableName),
+		ProjectionExpression: aws.String("entity_type"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		for _, item := range page.Items {
+			entityType := RawItem(item).EntityType()
+			if entityType == "" {
+				entityType = "unknown"
+			}
+			counts[entityType]++
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The Store stamps every item it writes with when it was created and last
+// written. The placeholders are prefixed so they can't clash with the
+// caller's own expression names and values.
+const (
+	createdAtAttribute = "created_at"
+	updatedAtAttribute = "updated_at"
+
+	createdAtName  = "#store_created_at"
+	updatedAtName  = "#store_updated_at"
+	createdAtValue = ":store_created_at"
+	updatedAtValue = ":store_updated_at"
+
+	// createOnly is the condition of puts that must not overwrite an item
+	createOnly = "attribute_not_exists(PK)"
+)
+
+// stampItem sets an item's updated_at to now, and its created_at to now
+// unless it already has one
+func stampItem(item map[string]types.AttributeValue, now time.Time) {
+	at := timestampValue(now)
+	item[updatedAtAttribute] = at
+	if !hasTimestamp(item, createdAtAttribute) {
+		item[createdAtAttribute] = at
+	}
+}
+
+// stampPut stamps a put's item. Puts that may overwrite an item are sent
+// as updates by overwriteUpdate, which keep the stored item's created_at.
+func stampPut(put *types.Put, now time.Time) {
+	stampItem(put.Item, now)
+}
+
+// optionalAttributes are the attributes a GenericItem may leave out, which
+// an overwrite removes from the stored item when the put's item has none
+var optionalAttributes = []string{
+	"SK2", "GSI1PK", "GSI1SK", "GSI2PK", "GSI2SK", "GSI3PK", "GSI3SK", "ttl", contentEncodingAttribute,
+}
+
+// overwriteUpdate turns a stamped put that may replace a stored item into
+// an update writing the same item, so the stored created_at is kept in the
+// same request with if_not_exists. It returns nil for puts that only create
+// items, which are sent as they are.
+func overwriteUpdate(put *types.Put) *types.Update {
+	if aws.ToString(put.ConditionExpression) == createOnly {
+		return nil
+	}
+	names := withName(put.ExpressionAttributeNames, createdAtName, createdAtAttribute)
+	values := withValue(put.ExpressionAttributeValues, createdAtValue, put.Item[createdAtAttribute])
+
+	attributes := make([]string, 0, len(put.Item))
+	for name := range put.Item {
+		if name != "PK" && name != "SK" && name != createdAtAttribute {
+			attributes = append(attributes, name)
+		}
+	}
+	sort.Strings(attributes)
+	sets := make([]string, 0, len(attributes)+1)
+	for i, name := range attributes {
+		placeholder := "store_put_" + strconv.Itoa(i)
+		names["#"+placeholder] = name
+		values[":"+placeholder] = put.Item[name]
+		sets = append(sets, "#"+placeholder+" = :"+placeholder)
+	}
+	sets = append(sets, createdAtName+" = if_not_exists("+createdAtName+", "+createdAtValue+")")
+	expr := "SET " + strings.Join(sets, ", ")
+
+	var removes []string
+	for i, name := range optionalAttributes {
+		if _, ok := put.Item[name]; ok {
+			continue
+		}
+		placeholder := "#store_remove_" + strconv.Itoa(i)
+		names[placeholder] = name
+		removes = append(removes, placeholder)
+	}
+	if len(removes) > 0 {
+		expr += " REMOVE " + strings.Join(removes, ", ")
+	}
+
+	return &types.Update{
+		TableName:                           put.TableName,
+		Key:                                 map[string]types.AttributeValue{"PK": put.Item["PK"], "SK": put.Item["SK"]},
+		UpdateExpression:                    aws.String(expr),
+		ConditionExpression:                 put.ConditionExpression,
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: put.ReturnValuesOnConditionCheckFailure,
+	}
+}
+
+// stampUpdate sets updated_at on an update, and created_at when the update
+// creates the item
+func stampUpdate(update *types.Update, now time.Time) {
+	if _, ok := update.ExpressionAttributeNames[updatedAtName]; ok {
+		return
+	}
+	set := updatedAtName + " = " + updatedAtValue + ", " +
+		createdAtName + " = if_not_exists(" + createdAtName + ", " + updatedAtValue + ")"
+	expr := aws.ToString(update.UpdateExpression)
+	if strings.Contains(expr, "SET ") {
+		expr = strings.Replace(expr, "SET ", "SET "+set+", ", 1)
+	} else {
+		expr += " SET " + set
+	}
+	update.UpdateExpression = aws.String(expr)
+	update.ExpressionAttributeNames = withName(update.ExpressionAttributeNames, updatedAtName, updatedAtAttribute)
+	update.ExpressionAttributeNames[createdAtName] = createdAtAttribute
+	update.ExpressionAttributeValues = withValue(update.ExpressionAttributeValues, updatedAtValue, timestampValue(now))
+}
+
+// stampWrites stamps the puts and updates of a transaction
+func stampWrites(items []types.TransactWriteItem, now time.Time) {
+	for _, item := range items {
+		switch {
+		case item.Put != nil:
+			stampPut(item.Put, now)
+		case item.Update != nil:
+			stampUpdate(item.Update, now)
+		}
+	}
+}
+
+// overwritesAsUpdates returns items with the puts that may overwrite an
+// item replaced by their overwriteUpdate, leaving items itself alone
+func overwritesAsUpdates(items []types.TransactWriteItem) []types.TransactWriteItem {
+	sent := make([]types.TransactWriteItem, len(items))
+	for i, item := range items {
+		sent[i] = item
+		if item.Put == nil {
+			continue
+		}
+		if update := overwriteUpdate(item.Put); update != nil {
+			sent[i] = types.TransactWriteItem{Update: update}
+		}
+	}
+	return sent
+}
+
+// timestampValue is how every time is stored: RFC3339Nano in UTC, so that
+// stored times compare and sort as strings whatever zone they were made in
+func timestampValue(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: t.UTC().Format(time.RFC3339Nano)}
+}
+
+// The repository marshals and unmarshals through these rather than
+// attributevalue's defaults, which keep a time's own zone. marshalMap uses
+// the encoders compiled in codec.go, and unmarshalMap decompresses data
+// stored with CompressData.
+func marshal(in any) (types.AttributeValue, error) {
+	return sharedEncoder.Encode(in)
+}
+
+func marshalMap(in any) (map[string]types.AttributeValue, error) {
+	return encodeMap(in)
+}
+
+func unmarshal(av types.AttributeValue, out any) error {
+	return sharedDecoder.Decode(av, out)
+}
+
+func unmarshalMap(m map[string]types.AttributeValue, out any) error {
+	m, err := inflate(m)
+	if err != nil {
+		return err
+	}
+	return sharedDecoder.Decode(&types.AttributeValueMemberM{Value: m}, out)
+}
+
+func encodeOptions(o *attributevalue.EncoderOptions) {
+	o.EncodeTime = func(t time.Time) (types.AttributeValue, error) {
+		return timestampValue(t), nil
+	}
+}
+
+// decodeOptions reads times back in UTC, including those stored with
+// another offset before times were normalized, or as epoch seconds
+func decodeOptions(o *attributevalue.DecoderOptions) {
+	o.DecodeTime.S = func(s string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t.UTC(), err
+	}
+	o.DecodeTime.N = func(n string) (time.Time, error) {
+		seconds, err := strconv.ParseInt(n, 10, 64)
+		return time.Unix(seconds, 0).UTC(), err
+	}
+}
+
+// hasTimestamp reports whether an item has a non-zero time in attribute
+func hasTimestamp(item map[string]types.AttributeValue, attribute string) bool {
+	av, ok := item[attribute]
+	if !ok {
+		return false
+	}
+	var t time.Time
+	return unmarshal(av, &t) == nil && !t.IsZero()
+}
+
+// withName returns a copy of names with name added, leaving the caller's
+// map alone
+func withName(names map[string]string, name, attribute string) map[string]string {
+	names = maps.Clone(names)
+	if names == nil {
+		names = make(map[string]string)
+	}
+	names[name] = attribute
+	return names
+}
+
+// withValue returns a copy of values with name added
+func withValue(values map[string]types.AttributeValue, name string, value types.AttributeValue) map[string]types.AttributeValue {
+	values = maps.Clone(values)
+	if values == nil {
+		values = make(map[string]types.AttributeValue)
+	}
+	values[name] = value
+	return values
+}
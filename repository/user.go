@@ -2,8 +2,13 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
 	"LearnSingleTableDesign/models"
 )
@@ -14,14 +19,15 @@ type UserRepository struct {
 }
 
 // NewUserRepository creates a new UserRepository
-func NewUserRepository(client *dynamodb.Client, tableName string) *UserRepository {
+func NewUserRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *UserRepository {
 	return &UserRepository{
-		store: NewStore(client, tableName),
+		store: NewStore(client, tableName, opts...),
 	}
 }
 
 // Put stores a user in DynamoDB
 func (r *UserRepository) Put(ctx context.Context, user models.User) error {
+	user.Email = models.NormalizeEmail(user.Email)
 	if err := user.Validate(); err != nil {
 		return err
 	}
@@ -43,3 +49,156 @@ func (r *UserRepository) Get(ctx context.Context, email string) (*models.User, e
 	}
 	return &item.Data, nil
 }
+
+// Exists reports whether a user is registered, without reading their profile
+func (r *UserRepository) Exists(ctx context.Context, email string) (bool, error) {
+	return r.store.Exists(ctx, Key.UserPK(email), Key.UserSK(email))
+}
+
+// userStatsItem is how UserStats is stored. The counters sit beside data
+// rather than inside it because ADD can't create a nested attribute on an
+// item that doesn't exist yet, and the user's first order creates this item.
+// What the user spent is kept per currency, in lifetime_spend_<currency>
+// counters.
+type userStatsItem struct {
+	PK         PrimaryKey `dynamodbav:"PK"`
+	SK         SortKey    `dynamodbav:"SK"`
+	EntityType string     `dynamodbav:"entity_type"`
+	Data       struct {
+		Email string `dynamodbav:"email"`
+	} `dynamodbav:"data"`
+	OrderCount  int       `dynamodbav:"order_count"`
+	LastOrderAt time.Time `dynamodbav:"last_order_at"`
+}
+
+// userStats decodes a stored stats item
+func userStats(raw map[string]types.AttributeValue) (models.UserStats, error) {
+	var item userStatsItem
+	if err := unmarshalMap(raw, &item); err != nil {
+		return models.UserStats{}, fmt.Errorf("failed to unmarshal user stats: %w", err)
+	}
+	spend, err := currencyTotals(raw, lifetimeSpendAttribute)
+	if err != nil {
+		return models.UserStats{}, fmt.Errorf("failed to unmarshal user stats: %w", err)
+	}
+	return models.UserStats{
+		Email:         item.Data.Email,
+		OrderCount:    item.OrderCount,
+		LifetimeSpend: spend,
+		LastOrderAt:   item.LastOrderAt,
+	}, nil
+}
+
+// userStatsUpdate counts a new order in its user's stats with atomic ADDs,
+// creating the stats item on the user's first order
+func userStatsUpdate(order models.Order) (*types.Update, error) {
+	data, err := marshal(map[string]string{"email": order.UserEmail})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal stats data: %w", err)
+	}
+	lastOrderAt, err := marshal(order.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal order time: %w", err)
+	}
+	return &types.Update{
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: string(Key.UserPK(order.UserEmail))},
+			"SK": &types.AttributeValueMemberS{Value: string(Key.UserStatsSK())},
+		},
+		UpdateExpression: aws.String("ADD order_count :one, #spend :total " +
+			"SET entity_type = :type, #data = if_not_exists(#data, :data), last_order_at = :last"),
+		ExpressionAttributeNames: map[string]string{
+			"#data":  "data",
+			"#spend": currencyAttribute(lifetimeSpendAttribute, order.PriceCurrency()),
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":   &types.AttributeValueMemberN{Value: "1"},
+			":total": &types.AttributeValueMemberN{Value: fmt.Sprint(order.Total)},
+			":type":  &types.AttributeValueMemberS{Value: EntityUserStats},
+			":data":  data,
+			":last":  lastOrderAt,
+		},
+	}, nil
+}
+
+// GetStats returns the user's order summary. Users who haven't ordered
+// anything get zero stats.
+func (r *UserRepository) GetStats(ctx context.Context, email string) (*models.UserStats, error) {
+	raw, err := getRawItem(ctx, r.store, Key.UserPK(email), Key.UserStatsSK())
+	if errors.Is(err, ErrNotFound) {
+		return &models.UserStats{Email: email}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats, err := userStats(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// UserAggregate is a user together with the related items in their collection
+type UserAggregate struct {
+	User      models.User
+	Orders    []models.Order
+	Addresses []models.Address
+	Stats     models.UserStats
+}
+
+// GetUserWithOrders reads the user's whole item collection (PK=USER#<email>)
+// with a single query per page and sorts the items out by entity type,
+// the canonical single-table way to load related entities together
+func (r *UserRepository) GetUserWithOrders(ctx context.Context, email string) (*UserAggregate, error) {
+	var aggregate UserAggregate
+	foundUser := false
+
+	opts := &QueryOptions{}
+	for {
+		page, err := QueryCollection(ctx, r.store, Key.UserPK(email), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, raw := range page.Items {
+			switch raw.EntityType() {
+			case EntityUser:
+				item, err := Decode[models.User](raw)
+				if err != nil {
+					return nil, err
+				}
+				aggregate.User = item.Data
+				foundUser = true
+			case EntityOrder:
+				item, err := Decode[models.Order](raw)
+				if err != nil {
+					return nil, err
+				}
+				aggregate.Orders = append(aggregate.Orders, item.Data)
+			case EntityUserStats:
+				stats, err := userStats(raw)
+				if err != nil {
+					return nil, err
+				}
+				aggregate.Stats = stats
+			case EntityAddress:
+				item, err := Decode[models.Address](raw)
+				if err != nil {
+					return nil, err
+				}
+				aggregate.Addresses = append(aggregate.Addresses, item.Data)
+			}
+		}
+
+		if page.NextPageToken == nil {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+
+	if !foundUser {
+		return nil, ErrNotFound
+	}
+	aggregate.Stats.Email = aggregate.User.Email
+	return &aggregate, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"LearnSingleTableDesign/models"
+)
+
+// WebhookRepository handles Webhook subscriptions and their delivery attempts
+type WebhookRepository struct {
+	store *Store
+}
+
+// NewWebhookRepository creates a new WebhookRepository
+func NewWebhookRepository(client *dynamodb.Client, tableName string, opts ...StoreOption) *WebhookRepository {
+	return &WebhookRepository{
+		store: NewStore(client, tableName, opts...),
+	}
+}
+
+// Put stores a webhook subscription
+func (r *WebhookRepository) Put(ctx context.Context, webhook models.Webhook) error {
+	if err := webhook.Validate(); err != nil {
+		return err
+	}
+	item := GenericItem[models.Webhook]{
+		PK:         Key.WebhookPK(),
+		SK:         Key.WebhookSK(webhook.WebhookID),
+		EntityType: EntityWebhook,
+		Data:       webhook,
+	}
+	return PutItem(ctx, r.store, item)
+}
+
+// All retrieves every webhook subscription
+func (r *WebhookRepository) All(ctx context.Context) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	opts := &QueryOptions{}
+	for {
+		result, err := Query[models.Webhook](ctx, r.store, Key.WebhookPK(), string(PrefixWebhook), opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			webhooks = append(webhooks, item.Data)
+		}
+		if result.NextPageToken == nil {
+			return webhooks, nil
+		}
+		opts.PageToken = result.NextPageToken
+	}
+}
+
+// RecordDelivery stores a delivery attempt under its webhook
+func (r *WebhookRepository) RecordDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	if err := delivery.Validate(); err != nil {
+		return err
+	}
+	item := GenericItem[models.WebhookDelivery]{
+		PK:         Key.WebhookDeliveryPK(delivery.WebhookID),
+		SK:         Key.WebhookDeliverySK(delivery.EventID, delivery.Attempt),
+		EntityType: EntityWebhookDelivery,
+		Data:       delivery,
+		SK2:        lsiMillis(delivery.AttemptedAt),
+	}
+	return PutItem(ctx, r.store, item)
+}
+
+// DeliveriesPage represents a page of webhook delivery attempts
+type DeliveriesPage struct {
+	Deliveries    []models.WebhookDelivery
+	NextPageToken *PageToken
+	PageInfo
+}
+
+// GetDeliveries retrieves a webhook's delivery attempts, newest first
+func (r *WebhookRepository) GetDeliveries(ctx context.Context, webhookID string, opts *QueryOptions) (*DeliveriesPage, error) {
+	newestFirst := QueryOptions{}
+	if opts != nil {
+		newestFirst = *opts
+	}
+	newestFirst.Descending = true
+
+	result, err := QueryByLSI[models.WebhookDelivery](ctx, r.store, Key.WebhookDeliveryPK(webhookID), &newestFirst)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(result.Items))
+	for i, item := range result.Items {
+		deliveries[i] = item.Data
+	}
+
+	return &DeliveriesPage{
+		Deliveries:    deliveries,
+		NextPageToken: result.NextPageToken,
+		PageInfo:      result.PageInfo,
+	}, nil
+}
@@ -0,0 +1,221 @@
+// Package s3 is a minimal client for the Amazon S3 REST API, covering just
+// the object calls the app makes. It works against S3, LocalStack and
+// MinIO.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+)
+
+// ErrNotFound means the object or its bucket doesn't exist
+var ErrNotFound = errors.New("s3 object not found")
+
+// Client makes signed S3 requests
+type Client struct {
+	// Endpoint is the scheme and host requests go to, without a bucket
+	Endpoint    string
+	Region      string
+	Credentials aws.CredentialsProvider
+	// PathStyle puts the bucket in the path instead of the host name
+	PathStyle bool
+	Client    *http.Client
+}
+
+// New creates a client for cfg. S3Endpoint, e.g. a local MinIO, takes its
+// credentials from the default AWS config chain such as
+// AWS_ACCESS_KEY_ID. Without one, local mode uses LocalStack's S3 with its
+// dummy credentials, and otherwise AWS's regional endpoint.
+func New(ctx context.Context, cfg config.Config) (*Client, error) {
+	endpoint := cfg.S3Endpoint
+	var awsCfg aws.Config
+	var err error
+	switch {
+	case endpoint != "":
+		awsCfg, err = awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+	case cfg.Local && cfg.LocalEmulator() != config.EmulatorLocalStack:
+		return nil, fmt.Errorf("%s has no S3, set S3_ENDPOINT", cfg.LocalEmulator())
+	case cfg.Local:
+		endpoint = cfg.Endpoint
+		awsCfg, err = dynamoclient.AWSConfig(ctx, cfg)
+	default:
+		endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+		awsCfg, err = dynamoclient.AWSConfig(ctx, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		Region:      cfg.Region,
+		Credentials: awsCfg.Credentials,
+		PathStyle:   cfg.PathStyle(),
+		Client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Put stores body under key, replacing any object already there
+func (c *Client) Put(ctx context.Context, bucket, key, contentType string, body []byte) error {
+	req, err := c.newRequest(ctx, http.MethodPut, bucket, key, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.do(req, body)
+	if err != nil {
+		return fmt.Errorf("failed to put s3://%s/%s: %w", bucket, key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get returns the object stored under key, or ErrNotFound
+func (c *Client) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get s3://%s/%s: %w", bucket, key, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", bucket, key, err)
+	}
+	return data, nil
+}
+
+// PresignGet returns a URL that downloads the object without credentials
+// until expires has passed
+func (c *Client) PresignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return "", err
+	}
+	query := req.URL.Query()
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	req.URL.RawQuery = query.Encode()
+
+	creds, err := c.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	signed, _, err := signer.PresignHTTP(ctx, creds, req, "UNSIGNED-PAYLOAD", "s3", c.Region, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("failed to presign s3://%s/%s: %w", bucket, key, err)
+	}
+	return signed, nil
+}
+
+// CreateBucket creates a bucket, doing nothing if this account already has
+// it. It is for emulators; real buckets are made with the infrastructure.
+func (c *Client) CreateBucket(ctx context.Context, bucket string) error {
+	req, err := c.newRequest(ctx, http.MethodPut, bucket, "", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, nil)
+	if err != nil && strings.Contains(err.Error(), "BucketAlreadyOwnedByYou") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ObjectURL is the unsigned URL of an object
+func (c *Client) ObjectURL(bucket, key string) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %w", c.Endpoint, err)
+	}
+	path := "/" + key
+	if c.PathStyle {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = path
+	u.RawPath = escapePath(path)
+	return u, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, bucket, key string, body []byte) (*http.Request, error) {
+	u, err := c.ObjectURL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+}
+
+// do signs and sends req, turning error responses into errors
+func (c *Client) do(req *http.Request, body []byte) (*http.Response, error) {
+	creds, err := c.Credentials.Retrieve(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(hash[:])
+	// S3 wants the payload hash as a header too
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if err := signer.SignHTTP(req.Context(), creds, req, payloadHash, "s3", c.Region, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to sign S3 request: %w", err)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 returned %s: %s", resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// signer signs S3 requests, whose paths are escaped once rather than twice
+var signer = v4.NewSigner(func(o *v4.SignerOptions) {
+	o.DisableURIPathEscaping = true
+})
+
+// escapePath percent-encodes everything in an object path but unreserved
+// characters and slashes, as S3's signatures expect
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestClient(t *testing.T) {
+	objects := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.EscapedPath()] = string(body)
+		case http.MethodGet:
+			body, ok := objects[r.URL.EscapedPath()]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, body)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{
+		Endpoint:    server.URL,
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+		PathStyle:   true,
+		Client:      server.Client(),
+	}
+	ctx := context.Background()
+
+	// Test the bucket goes in the path and keys are escaped once
+	if err := client.Put(ctx, "bucket", "orders/a b+c.json", "application/json", []byte(`{}`)); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if _, ok := objects["/bucket/orders/a%20b%2Bc.json"]; !ok {
+		t.Errorf("Objects = %v, want /bucket/orders/a%%20b%%2Bc.json", objects)
+	}
+	body, err := client.Get(ctx, "bucket", "orders/a b+c.json")
+	if err != nil {
+		t.Fatalf("Failed to get object: %v", err)
+	}
+	if string(body) != `{}` {
+		t.Errorf("Get() = %s, want {}", body)
+	}
+
+	if _, err := client.Get(ctx, "bucket", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_ObjectURL(t *testing.T) {
+	client := &Client{Endpoint: "https://s3.us-east-1.amazonaws.com"}
+	u, err := client.ObjectURL("bucket", "orders/1.json")
+	if err != nil {
+		t.Fatalf("Failed to build URL: %v", err)
+	}
+	if got := u.String(); got != "https://bucket.s3.us-east-1.amazonaws.com/orders/1.json" {
+		t.Errorf("ObjectURL() = %s, want the bucket in the host", got)
+	}
+}
+
+func TestClient_PresignGet(t *testing.T) {
+	client := &Client{
+		Endpoint:    "http://localhost:9000",
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+		PathStyle:   true,
+	}
+	signed, err := client.PresignGet(context.Background(), "bucket", "invoices/ORD1.html", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to presign URL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("Presigned URL %q doesn't parse: %v", signed, err)
+	}
+	if u.Path != "/bucket/invoices/ORD1.html" {
+		t.Errorf("Path = %s, want /bucket/invoices/ORD1.html", u.Path)
+	}
+	query := u.Query()
+	if query.Get("X-Amz-Expires") != "900" || query.Get("X-Amz-Signature") == "" || !strings.HasPrefix(query.Get("X-Amz-Credential"), "test/") {
+		t.Errorf("Query = %v, want a signature valid for 900 seconds", query)
+	}
+}
@@ -0,0 +1,231 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxCopyBatch is the most items DynamoDB accepts in one BatchWriteItem call
+const maxCopyBatch = 25
+
+// maxCopyAttempts bounds how often unprocessed items are retried
+const maxCopyAttempts = 8
+
+// CopyOptions tunes CopyTable
+type CopyOptions struct {
+	// Segments is how many workers scan the source in parallel, 4 by default
+	Segments int
+	// ItemsPerSecond caps how fast items are written to the destination,
+	// 0 meaning as fast as DynamoDB takes them
+	ItemsPerSecond int
+}
+
+// CopyTable creates dst with src's keys, indexes, billing and time to live,
+// then copies every item across with a parallel scan and batch writes.
+// Items are copied as stored, so timestamps and versions are kept. dst
+// must not exist yet.
+func CopyTable(ctx context.Context, client *dynamodb.Client, src, dst string, opts CopyOptions) (int64, error) {
+	desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(src)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe table: %w", err)
+	}
+	if _, err := client.CreateTable(ctx, createInputLike(desc.Table, dst)); err != nil {
+		return 0, fmt.Errorf("failed to create table: %w", err)
+	}
+	if err := waitForTable(ctx, client, dst); err != nil {
+		return 0, err
+	}
+	if err := copyTTL(ctx, client, src, dst); err != nil {
+		return 0, err
+	}
+
+	segments := opts.Segments
+	if segments <= 0 {
+		segments = 4
+	}
+	throttle := newThrottle(opts.ItemsPerSecond)
+
+	var copied atomic.Int64
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var wg sync.WaitGroup
+	for segment := range segments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := copySegment(ctx, client, src, dst, segment, segments, throttle, &copied); err != nil {
+				cancel(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return copied.Load(), err
+	}
+	slog.Info("copied table", "from", src, "to", dst, "items", copied.Load())
+	return copied.Load(), nil
+}
+
+// copySegment scans one segment of src and writes its items to dst
+func copySegment(ctx context.Context, client *dynamodb.Client, src, dst string, segment, segments int, throttle *throttle, copied *atomic.Int64) error {
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:     aws.String(src),
+		Segment:       aws.Int32(int32(segment)),
+		TotalSegments: aws.Int32(int32(segments)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan segment %d: %w", segment, err)
+		}
+		for start := 0; start < len(page.Items); start += maxCopyBatch {
+			batch := page.Items[start:min(start+maxCopyBatch, len(page.Items))]
+			if err := throttle.wait(ctx, len(batch)); err != nil {
+				return err
+			}
+			if err := writeCopyBatch(ctx, client, dst, batch); err != nil {
+				return err
+			}
+			copied.Add(int64(len(batch)))
+		}
+	}
+	return nil
+}
+
+// writeCopyBatch writes one batch, retrying unprocessed items with backoff
+func writeCopyBatch(ctx context.Context, client *dynamodb.Client, dst string, items []map[string]types.AttributeValue) error {
+	pending := make([]types.WriteRequest, len(items))
+	for i, item := range items {
+		pending[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+	}
+	for attempt := 0; ; attempt++ {
+		out, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{dst: pending},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to batch write items: %w", err)
+		}
+		pending = out.UnprocessedItems[dst]
+		if len(pending) == 0 {
+			return nil
+		}
+		if attempt+1 == maxCopyAttempts {
+			return fmt.Errorf("%d items still unprocessed after %d attempts", len(pending), maxCopyAttempts)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(1<<attempt) * 50 * time.Millisecond):
+		}
+	}
+}
+
+// createInputLike builds the CreateTable input for a table with the same
+// keys, indexes, billing and stream as table
+func createInputLike(table *types.TableDescription, name string) *dynamodb.CreateTableInput {
+	billing := currentBillingMode(table)
+	throughput := func(t *types.ProvisionedThroughputDescription) *types.ProvisionedThroughput {
+		if billing != types.BillingModeProvisioned || t == nil {
+			return nil
+		}
+		return &types.ProvisionedThroughput{ReadCapacityUnits: t.ReadCapacityUnits, WriteCapacityUnits: t.WriteCapacityUnits}
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:             aws.String(name),
+		AttributeDefinitions:  table.AttributeDefinitions,
+		KeySchema:             table.KeySchema,
+		BillingMode:           billing,
+		ProvisionedThroughput: throughput(table.ProvisionedThroughput),
+	}
+	if billing == types.BillingModePayPerRequest {
+		input.OnDemandThroughput = table.OnDemandThroughput
+	}
+	if s := table.StreamSpecification; s != nil && aws.ToBool(s.StreamEnabled) {
+		input.StreamSpecification = s
+	}
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, types.GlobalSecondaryIndex{
+			IndexName:             gsi.IndexName,
+			KeySchema:             gsi.KeySchema,
+			Projection:            gsi.Projection,
+			ProvisionedThroughput: throughput(gsi.ProvisionedThroughput),
+		})
+	}
+	for _, lsi := range table.LocalSecondaryIndexes {
+		input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, types.LocalSecondaryIndex{
+			IndexName:  lsi.IndexName,
+			KeySchema:  lsi.KeySchema,
+			Projection: lsi.Projection,
+		})
+	}
+	return input
+}
+
+// copyTTL turns on time to live for dst when it is on for src
+func copyTTL(ctx context.Context, client *dynamodb.Client, src, dst string) error {
+	desc, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(src)})
+	if err != nil {
+		return fmt.Errorf("failed to describe time to live: %w", err)
+	}
+	ttl := desc.TimeToLiveDescription
+	if ttl == nil || (ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabled && ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabling) {
+		return nil
+	}
+	return enableTTL(ctx, client, dst, aws.ToString(ttl.AttributeName))
+}
+
+// throttle spaces out writes shared by all of a copy's workers, so they
+// add up to at most a rate of items per second
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	now      func() time.Time
+}
+
+func newThrottle(perSecond int) *throttle {
+	t := &throttle{now: time.Now}
+	if perSecond > 0 {
+		t.interval = time.Second / time.Duration(perSecond)
+	}
+	return t
+}
+
+// reserve returns how long to wait before writing n items
+func (t *throttle) reserve(n int) time.Duration {
+	if t.interval == 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(n) * t.interval)
+	return wait
+}
+
+func (t *throttle) wait(ctx context.Context, n int) error {
+	wait := t.reserve(n)
+	if wait == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
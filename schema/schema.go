@@ -0,0 +1,324 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// GSI1 is the general purpose overloaded index. Entities that need a second
+// access pattern write GSI1PK/GSI1SK attributes with their own key prefixes.
+const GSI1 = "GSI1"
+
+// GSI2 is a second overloaded index, for items that already use GSI1 and
+// need another access pattern
+const GSI2 = "GSI2"
+
+// GSI3 is a third overloaded index, for items already using GSI1 and GSI2
+const GSI3 = "GSI3"
+
+// LSI1 sorts a partition's items by creation time (SK2, epoch milliseconds)
+// instead of SK. Unlike a GSI it shares the base table's partition key and
+// supports consistent reads, but it can only be created with the table and
+// caps each partition at 10GB.
+const LSI1 = "LSI1"
+
+// TTLAttribute is the item attribute DynamoDB's time to live reads, in
+// epoch seconds. Items without it never expire.
+const TTLAttribute = "ttl"
+
+// IndexSpec declares a secondary index the access patterns need
+type IndexSpec struct {
+	Name         string
+	PartitionKey string
+	// SortKey is optional for global indexes
+	SortKey string
+	// SortKeyType defaults to string
+	SortKeyType types.ScalarAttributeType
+}
+
+// Indexes are the GSIs the table should have. Add new access patterns here
+// and EnsureTable creates them on the next start.
+var Indexes = []IndexSpec{
+	{Name: GSI1, PartitionKey: "GSI1PK", SortKey: "GSI1SK"},
+	{Name: GSI2, PartitionKey: "GSI2PK", SortKey: "GSI2SK"},
+	{Name: GSI3, PartitionKey: "GSI3PK", SortKey: "GSI3SK"},
+}
+
+// LocalIndexes are the LSIs the table should have. They use the base table's
+// PK, so PartitionKey is ignored. LSIs can only be created with the table,
+// so EnsureTable just warns when an existing table is missing one.
+var LocalIndexes = []IndexSpec{
+	{Name: LSI1, SortKey: "SK2", SortKeyType: types.ScalarAttributeTypeN},
+}
+
+// pollInterval is how often DescribeTable is called while waiting for an index
+var pollInterval = 2 * time.Second
+
+// EnsureTable creates the table with all declared indexes if it doesn't exist,
+// otherwise creates any declared indexes the existing table is missing
+func EnsureTable(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	return EnsureTableSpec(ctx, client, DefaultSpec(tableName))
+}
+
+// EnsureTTL turns on time to live for TTLAttribute unless it already is
+func EnsureTTL(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	enabled, err := ttlEnabled(ctx, client, tableName, TTLAttribute)
+	if err != nil || enabled {
+		return err
+	}
+	return enableTTL(ctx, client, tableName, TTLAttribute)
+}
+
+// ttlEnabled reports whether time to live is on for the table. It warns
+// when it is on for a different attribute, since switching attributes
+// means turning it off for an hour first.
+func ttlEnabled(ctx context.Context, client *dynamodb.Client, tableName, attribute string) (bool, error) {
+	desc, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe time to live: %w", err)
+	}
+	ttl := desc.TimeToLiveDescription
+	if ttl == nil || (ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabled && ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabling) {
+		return false, nil
+	}
+	if name := aws.ToString(ttl.AttributeName); name != attribute {
+		slog.Warn("time to live is on for a different attribute, turn it off to switch",
+			"table", tableName, "attribute", name, "want", attribute)
+	}
+	return true, nil
+}
+
+func enableTTL(ctx context.Context, client *dynamodb.Client, tableName, attribute string) error {
+	slog.Info("enabling time to live", "table", tableName, "attribute", attribute)
+	_, err := client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(attribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable time to live: %w", err)
+	}
+	return nil
+}
+
+// CreateTable creates the table with the base PK/SK keys and all declared indexes
+func CreateTable(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	return CreateTableSpec(ctx, client, DefaultSpec(tableName))
+}
+
+// CreateTableSpec creates the table spec describes. It doesn't wait for the
+// table to become active, so time to live isn't turned on; EnsureTableSpec
+// does both.
+func CreateTableSpec(ctx context.Context, client *dynamodb.Client, spec TableSpec) error {
+	throughput := spec.provisionedThroughput()
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(spec.Name),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("PK"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("SK"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("PK"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("SK"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		BillingMode:           spec.billingMode(),
+		ProvisionedThroughput: throughput,
+		OnDemandThroughput:    spec.onDemandThroughput(false),
+		StreamSpecification:   spec.streamSpecification(),
+		Tags:                  tagList(spec.Tags),
+	}
+	for _, index := range spec.Indexes {
+		input.AttributeDefinitions = appendAttributeDefinitions(input.AttributeDefinitions, index)
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, index.create(throughput))
+	}
+	for _, index := range spec.LocalIndexes {
+		input.AttributeDefinitions = appendAttributeDefinitions(input.AttributeDefinitions, index)
+		input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, index.createLocal())
+	}
+
+	if _, err := client.CreateTable(ctx, input); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+	return nil
+}
+
+// MissingIndexes returns the declared indexes the table doesn't have yet
+func MissingIndexes(table *types.TableDescription, indexes []IndexSpec) []IndexSpec {
+	existing := make(map[string]bool)
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		existing[aws.ToString(gsi.IndexName)] = true
+	}
+
+	var missing []IndexSpec
+	for _, index := range indexes {
+		if !existing[index.Name] {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// missingLocalIndexes returns the declared LSIs the table doesn't have
+func missingLocalIndexes(table *types.TableDescription, indexes []IndexSpec) []IndexSpec {
+	existing := make(map[string]bool)
+	for _, lsi := range table.LocalSecondaryIndexes {
+		existing[aws.ToString(lsi.IndexName)] = true
+	}
+
+	var missing []IndexSpec
+	for _, index := range indexes {
+		if !existing[index.Name] {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// EnsureIndexes creates the indexes on an on-demand table one at a time,
+// since DynamoDB only allows one index creation per UpdateTable call,
+// waiting for each to become ACTIVE
+func EnsureIndexes(ctx context.Context, client *dynamodb.Client, tableName string, indexes []IndexSpec) error {
+	return createIndexes(ctx, client, tableName, indexes, nil)
+}
+
+// createIndexes is EnsureIndexes with the throughput each index is
+// provisioned with, nil on demand
+func createIndexes(ctx context.Context, client *dynamodb.Client, tableName string, indexes []IndexSpec, throughput *types.ProvisionedThroughput) error {
+	for _, index := range indexes {
+		slog.Info("creating global secondary index", "table", tableName, "index", index.Name)
+
+		_, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:            aws.String(tableName),
+			AttributeDefinitions: appendAttributeDefinitions(nil, index),
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+				{Create: index.createAction(throughput)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create index %s: %w", index.Name, err)
+		}
+
+		if err := waitForIndex(ctx, client, tableName, index.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForIndex polls DescribeTable until the index is ACTIVE
+func waitForIndex(ctx context.Context, client *dynamodb.Client, tableName, indexName string) error {
+	for {
+		desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe table: %w", err)
+		}
+		for _, gsi := range desc.Table.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) == indexName && gsi.IndexStatus == types.IndexStatusActive {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for index %s: %w", indexName, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// keySchema returns the index's key schema elements
+func (i IndexSpec) keySchema() []types.KeySchemaElement {
+	schema := []types.KeySchemaElement{
+		{AttributeName: aws.String(i.PartitionKey), KeyType: types.KeyTypeHash},
+	}
+	if i.SortKey != "" {
+		schema = append(schema, types.KeySchemaElement{AttributeName: aws.String(i.SortKey), KeyType: types.KeyTypeRange})
+	}
+	return schema
+}
+
+// create returns the index definition for CreateTable
+func (i IndexSpec) create(throughput *types.ProvisionedThroughput) types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName:             aws.String(i.Name),
+		KeySchema:             i.keySchema(),
+		Projection:            &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		ProvisionedThroughput: throughput,
+	}
+}
+
+// createLocal returns the local index definition for CreateTable
+func (i IndexSpec) createLocal() types.LocalSecondaryIndex {
+	return types.LocalSecondaryIndex{
+		IndexName: aws.String(i.Name),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(i.SortKey), KeyType: types.KeyTypeRange},
+		},
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+	}
+}
+
+// createAction returns the index definition for UpdateTable
+func (i IndexSpec) createAction(throughput *types.ProvisionedThroughput) *types.CreateGlobalSecondaryIndexAction {
+	return &types.CreateGlobalSecondaryIndexAction{
+		IndexName:             aws.String(i.Name),
+		KeySchema:             i.keySchema(),
+		Projection:            &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		ProvisionedThroughput: throughput,
+	}
+}
+
+// appendAttributeDefinitions adds the index's key attributes unless already defined
+func appendAttributeDefinitions(defs []types.AttributeDefinition, index IndexSpec) []types.AttributeDefinition {
+	sortKeyType := index.SortKeyType
+	if sortKeyType == "" {
+		sortKeyType = types.ScalarAttributeTypeS
+	}
+	attributes := []types.AttributeDefinition{
+		{AttributeName: aws.String(index.PartitionKey), AttributeType: types.ScalarAttributeTypeS},
+		{AttributeName: aws.String(index.SortKey), AttributeType: sortKeyType},
+	}
+
+	for _, attribute := range attributes {
+		name := aws.ToString(attribute.AttributeName)
+		if name == "" {
+			continue
+		}
+		defined := false
+		for _, def := range defs {
+			if aws.ToString(def.AttributeName) == name {
+				defined = true
+				break
+			}
+		}
+		if !defined {
+			defs = append(defs, attribute)
+		}
+	}
+	return defs
+}
@@ -0,0 +1,200 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestMissingIndexes(t *testing.T) {
+	declared := []IndexSpec{
+		{Name: "GSI1", PartitionKey: "GSI1PK", SortKey: "GSI1SK"},
+		{Name: "GSI2", PartitionKey: "GSI2PK"},
+	}
+	table := &types.TableDescription{
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String("GSI1")},
+			{IndexName: aws.String("Legacy")},
+		},
+	}
+
+	got := MissingIndexes(table, declared)
+	want := []IndexSpec{declared[1]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingIndexes() = %+v, want %+v", got, want)
+	}
+
+	// Test a table without indexes is missing all of them
+	if got := MissingIndexes(&types.TableDescription{}, declared); len(got) != len(declared) {
+		t.Errorf("Got %d missing indexes, want %d", len(got), len(declared))
+	}
+}
+
+func TestAppendAttributeDefinitions(t *testing.T) {
+	defs := []types.AttributeDefinition{
+		{AttributeName: aws.String("GSI1PK"), AttributeType: types.ScalarAttributeTypeS},
+	}
+
+	// Test shared attributes are only defined once
+	defs = appendAttributeDefinitions(defs, IndexSpec{Name: "GSI1", PartitionKey: "GSI1PK", SortKey: "GSI1SK"})
+	defs = appendAttributeDefinitions(defs, IndexSpec{Name: "GSI2", PartitionKey: "GSI1SK"})
+
+	var names []string
+	for _, def := range defs {
+		names = append(names, aws.ToString(def.AttributeName))
+	}
+	if want := []string{"GSI1PK", "GSI1SK"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Attribute definitions = %v, want %v", names, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	onDemand := &types.TableDescription{
+		BillingModeSummary: &types.BillingModeSummary{BillingMode: types.BillingModePayPerRequest},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String(GSI1)},
+		},
+		LocalSecondaryIndexes: []types.LocalSecondaryIndexDescription{
+			{IndexName: aws.String(LSI1)},
+		},
+	}
+
+	// Test a table matching the spec needs nothing but its missing indexes
+	spec := DefaultSpec("AppTable")
+	spec.Indexes = spec.Indexes[:1]
+	if diff := Diff(onDemand, spec); !diff.Empty() {
+		t.Errorf("Diff() = %v, want no changes", diff.Changes())
+	}
+	spec.Indexes = Indexes
+	if diff := Diff(onDemand, spec); len(diff.MissingIndexes) != 2 || len(diff.Changes()) != 2 {
+		t.Errorf("Diff() = %v, want GSI2 and GSI3 created", diff.Changes())
+	}
+
+	// Test switching to provisioned sets billing and capacity
+	spec = DefaultSpec("AppTable")
+	spec.Indexes = spec.Indexes[:1]
+	spec.BillingMode = types.BillingModeProvisioned
+	spec.Capacity = Capacity{Read: 5, Write: 2}
+	spec.StreamView = types.StreamViewTypeNewAndOldImages
+	diff := Diff(onDemand, spec)
+	want := []string{
+		"switch billing to PROVISIONED",
+		"set capacity to 5 read, 2 write",
+		"turn on stream with NEW_AND_OLD_IMAGES",
+	}
+	if !reflect.DeepEqual(diff.Changes(), want) {
+		t.Errorf("Diff() = %v, want %v", diff.Changes(), want)
+	}
+
+	// Test a provisioned table only changes capacity where it differs,
+	// GSIs included
+	throughput := &types.ProvisionedThroughputDescription{ReadCapacityUnits: aws.Int64(5), WriteCapacityUnits: aws.Int64(2)}
+	provisioned := &types.TableDescription{
+		ProvisionedThroughput: throughput,
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String(GSI1), ProvisionedThroughput: throughput},
+		},
+		LocalSecondaryIndexes: onDemand.LocalSecondaryIndexes,
+		StreamSpecification:   &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: types.StreamViewTypeKeysOnly},
+	}
+	diff = Diff(provisioned, spec)
+	if diff.BillingMode != "" || diff.Capacity != nil {
+		t.Errorf("Diff() = %v, want capacity unchanged", diff.Changes())
+	}
+	if diff.Stream == nil || *diff.Stream != types.StreamViewTypeNewAndOldImages || diff.StreamWas != types.StreamViewTypeKeysOnly {
+		t.Errorf("Diff() = %v, want the stream view switched", diff.Changes())
+	}
+	provisioned.GlobalSecondaryIndexes[0].ProvisionedThroughput = &types.ProvisionedThroughputDescription{ReadCapacityUnits: aws.Int64(1), WriteCapacityUnits: aws.Int64(1)}
+	if diff := Diff(provisioned, spec); diff.Capacity == nil {
+		t.Error("Expected a capacity change for a GSI provisioned differently")
+	}
+
+	// Test an on-demand cap is added and removed
+	spec = DefaultSpec("AppTable")
+	spec.Indexes = spec.Indexes[:1]
+	spec.Capacity = Capacity{Read: 100}
+	if diff := Diff(onDemand, spec); diff.Capacity == nil || diff.BillingMode != "" {
+		t.Errorf("Diff() = %v, want only the on-demand cap set", diff.Changes())
+	}
+	capped := *onDemand
+	capped.OnDemandThroughput = &types.OnDemandThroughput{MaxReadRequestUnits: aws.Int64(100), MaxWriteRequestUnits: aws.Int64(-1)}
+	if diff := Diff(&capped, spec); !diff.Empty() {
+		t.Errorf("Diff() = %v, want no changes", diff.Changes())
+	}
+}
+
+func TestTableSpec_Validate(t *testing.T) {
+	spec := DefaultSpec("AppTable")
+	if err := spec.Validate(); err != nil {
+		t.Errorf("Validate() on the default spec: %v", err)
+	}
+	spec.BillingMode = types.BillingModeProvisioned
+	if err := spec.Validate(); err == nil {
+		t.Error("Expected error for provisioned billing without capacity")
+	}
+	spec.Capacity = Capacity{Read: 1, Write: 1}
+	spec.StreamView = "EVERYTHING"
+	if err := spec.Validate(); err == nil {
+		t.Error("Expected error for an unknown stream view")
+	}
+}
+
+func TestCreateInputLike(t *testing.T) {
+	throughput := &types.ProvisionedThroughputDescription{ReadCapacityUnits: aws.Int64(0), WriteCapacityUnits: aws.Int64(0)}
+	table := &types.TableDescription{
+		BillingModeSummary:    &types.BillingModeSummary{BillingMode: types.BillingModePayPerRequest},
+		KeySchema:             []types.KeySchemaElement{{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash}},
+		ProvisionedThroughput: throughput,
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String(GSI1), ProvisionedThroughput: throughput},
+		},
+		StreamSpecification: &types.StreamSpecification{StreamEnabled: aws.Bool(false)},
+	}
+
+	// Test an on-demand table's zero throughput isn't copied, nor a stream
+	// that is off
+	input := createInputLike(table, "Copy")
+	if aws.ToString(input.TableName) != "Copy" || input.BillingMode != types.BillingModePayPerRequest {
+		t.Errorf("Got table %s billed %s, want Copy on demand", aws.ToString(input.TableName), input.BillingMode)
+	}
+	if input.ProvisionedThroughput != nil || input.GlobalSecondaryIndexes[0].ProvisionedThroughput != nil {
+		t.Error("Expected no provisioned throughput for an on-demand copy")
+	}
+	if input.StreamSpecification != nil {
+		t.Error("Expected no stream for a table without one")
+	}
+
+	// Test a provisioned table's capacity is copied to its indexes too
+	table.BillingModeSummary = nil
+	throughput.ReadCapacityUnits = aws.Int64(5)
+	throughput.WriteCapacityUnits = aws.Int64(2)
+	input = createInputLike(table, "Copy")
+	if input.BillingMode != types.BillingModeProvisioned || aws.ToInt64(input.GlobalSecondaryIndexes[0].ProvisionedThroughput.ReadCapacityUnits) != 5 {
+		t.Errorf("Got %s with GSI throughput %+v, want provisioned with 5 read", input.BillingMode, input.GlobalSecondaryIndexes[0].ProvisionedThroughput)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	now := time.Unix(0, 0)
+	throttle := newThrottle(10)
+	throttle.now = func() time.Time { return now }
+
+	// Test each reservation waits for the ones before it at 10 items a second
+	if wait := throttle.reserve(5); wait != 0 {
+		t.Errorf("First wait = %v, want 0", wait)
+	}
+	if wait := throttle.reserve(5); wait != 500*time.Millisecond {
+		t.Errorf("Second wait = %v, want 500ms", wait)
+	}
+	now = now.Add(2 * time.Second)
+	if wait := throttle.reserve(5); wait != 0 {
+		t.Errorf("Wait after idling = %v, want 0", wait)
+	}
+
+	if wait := newThrottle(0).reserve(1000); wait != 0 {
+		t.Errorf("Unlimited wait = %v, want 0", wait)
+	}
+}
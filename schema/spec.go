@@ -0,0 +1,509 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/config"
+)
+
+// Capacity is a table's read and write capacity. With provisioned billing
+// it is what the table and each of its GSIs are provisioned with. On
+// demand it is an optional cap on request units per second, 0 meaning
+// uncapped.
+type Capacity struct {
+	Read  int64
+	Write int64
+}
+
+// TableSpec describes the table EnsureTableSpec creates or brings an
+// existing table in line with
+type TableSpec struct {
+	Name string
+	// BillingMode defaults to PAY_PER_REQUEST
+	BillingMode  types.BillingMode
+	Capacity     Capacity
+	Indexes      []IndexSpec
+	LocalIndexes []IndexSpec
+	// TTLAttribute is turned on for time to live; empty leaves it off
+	TTLAttribute string
+	// StreamView turns on a stream with that view; empty means no stream
+	StreamView types.StreamViewType
+	Tags       map[string]string
+}
+
+// DefaultSpec is the on-demand table with every declared index and time
+// to live, as the app has always created it
+func DefaultSpec(tableName string) TableSpec {
+	return TableSpec{
+		Name:         tableName,
+		BillingMode:  types.BillingModePayPerRequest,
+		Indexes:      Indexes,
+		LocalIndexes: LocalIndexes,
+		TTLAttribute: TTLAttribute,
+	}
+}
+
+// FromConfig is DefaultSpec for the configured table, with its billing,
+// stream and tags settings
+func FromConfig(cfg config.Config) TableSpec {
+	spec := DefaultSpec(cfg.TableName)
+	if cfg.BillingMode != "" {
+		spec.BillingMode = types.BillingMode(cfg.BillingMode)
+	}
+	spec.Capacity = Capacity{Read: cfg.ReadCapacity, Write: cfg.WriteCapacity}
+	spec.StreamView = types.StreamViewType(cfg.StreamView)
+	spec.Tags = cfg.TableTags
+	return spec
+}
+
+// Validate reports settings DynamoDB would reject
+func (s TableSpec) Validate() error {
+	switch s.billingMode() {
+	case types.BillingModePayPerRequest:
+	case types.BillingModeProvisioned:
+		if s.Capacity.Read < 1 || s.Capacity.Write < 1 {
+			return errors.New("provisioned billing needs read and write capacity of at least 1")
+		}
+	default:
+		return fmt.Errorf("unknown billing mode %s", s.BillingMode)
+	}
+	if s.Capacity.Read < 0 || s.Capacity.Write < 0 {
+		return errors.New("capacity can't be negative")
+	}
+	if s.StreamView != "" && !isStreamView(s.StreamView) {
+		return fmt.Errorf("unknown stream view %s", s.StreamView)
+	}
+	return nil
+}
+
+func isStreamView(view types.StreamViewType) bool {
+	for _, known := range view.Values() {
+		if view == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (s TableSpec) billingMode() types.BillingMode {
+	if s.BillingMode == "" {
+		return types.BillingModePayPerRequest
+	}
+	return s.BillingMode
+}
+
+// provisionedThroughput is the throughput of the table and each GSI, nil
+// on demand
+func (s TableSpec) provisionedThroughput() *types.ProvisionedThroughput {
+	if s.billingMode() != types.BillingModeProvisioned {
+		return nil
+	}
+	return &types.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(s.Capacity.Read),
+		WriteCapacityUnits: aws.Int64(s.Capacity.Write),
+	}
+}
+
+// onDemandThroughput is the on-demand cap, nil when provisioned. Creating
+// a table leaves an uncapped side unset, while updating one needs -1 to
+// remove a cap.
+func (s TableSpec) onDemandThroughput(update bool) *types.OnDemandThroughput {
+	if s.billingMode() != types.BillingModePayPerRequest {
+		return nil
+	}
+	capOf := func(units int64) *int64 {
+		if units > 0 {
+			return aws.Int64(units)
+		}
+		if update {
+			return aws.Int64(-1)
+		}
+		return nil
+	}
+	if !update && s.Capacity == (Capacity{}) {
+		return nil
+	}
+	return &types.OnDemandThroughput{
+		MaxReadRequestUnits:  capOf(s.Capacity.Read),
+		MaxWriteRequestUnits: capOf(s.Capacity.Write),
+	}
+}
+
+func (s TableSpec) streamSpecification() *types.StreamSpecification {
+	if s.StreamView == "" {
+		return nil
+	}
+	return &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: s.StreamView}
+}
+
+// TableDiff is what it takes to bring a table in line with a spec
+type TableDiff struct {
+	// Create means the table doesn't exist yet, and nothing else is set
+	Create bool
+	// BillingMode is set when the billing mode changes
+	BillingMode types.BillingMode
+	// Capacity is set when the provisioned capacity or on-demand cap of
+	// the table or an existing GSI changes
+	Capacity *Capacity
+	// Stream is set when the stream changes, to the view wanted or to ""
+	// to turn it off. StreamWas is the view it replaces.
+	Stream    *types.StreamViewType
+	StreamWas types.StreamViewType
+	// MissingIndexes are created, but MissingLocalIndexes can only be
+	// added by recreating the table
+	MissingIndexes      []IndexSpec
+	MissingLocalIndexes []IndexSpec
+	// EnableTTL turns on time to live for the spec's TTLAttribute
+	EnableTTL bool
+	// Tags are the tags to add or change. Tags not in the spec are left.
+	Tags map[string]string
+}
+
+// Empty reports whether the table already matches the spec
+func (d TableDiff) Empty() bool {
+	return len(d.Changes()) == 0
+}
+
+// Changes describes the diff, one line per change
+func (d TableDiff) Changes() []string {
+	if d.Create {
+		return []string{"create table"}
+	}
+	var changes []string
+	if d.BillingMode != "" {
+		changes = append(changes, "switch billing to "+string(d.BillingMode))
+	}
+	if d.Capacity != nil {
+		changes = append(changes, fmt.Sprintf("set capacity to %d read, %d write", d.Capacity.Read, d.Capacity.Write))
+	}
+	if d.Stream != nil {
+		if *d.Stream == "" {
+			changes = append(changes, "turn off stream")
+		} else {
+			changes = append(changes, "turn on stream with "+string(*d.Stream))
+		}
+	}
+	for _, index := range d.MissingIndexes {
+		changes = append(changes, "create index "+index.Name)
+	}
+	for _, index := range d.MissingLocalIndexes {
+		changes = append(changes, "recreate table to add local index "+index.Name)
+	}
+	if d.EnableTTL {
+		changes = append(changes, "turn on time to live")
+	}
+	keys := make([]string, 0, len(d.Tags))
+	for key := range d.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		changes = append(changes, fmt.Sprintf("tag %s=%s", key, d.Tags[key]))
+	}
+	return changes
+}
+
+// Diff compares a table's description with a spec. Time to live and tags
+// aren't in the description, so Plan adds them.
+func Diff(table *types.TableDescription, spec TableSpec) TableDiff {
+	var diff TableDiff
+
+	current := currentBillingMode(table)
+	want := spec.billingMode()
+	if current != want {
+		diff.BillingMode = want
+	}
+	if capacityChanged(table, spec) {
+		capacity := spec.Capacity
+		diff.Capacity = &capacity
+	}
+
+	var stream types.StreamViewType
+	if s := table.StreamSpecification; s != nil && aws.ToBool(s.StreamEnabled) {
+		stream = s.StreamViewType
+	}
+	if stream != spec.StreamView {
+		view := spec.StreamView
+		diff.Stream = &view
+		diff.StreamWas = stream
+	}
+
+	diff.MissingIndexes = MissingIndexes(table, spec.Indexes)
+	diff.MissingLocalIndexes = missingLocalIndexes(table, spec.LocalIndexes)
+	return diff
+}
+
+// currentBillingMode reads a table's billing mode. Tables created before
+// on demand existed, and some DynamoDB Local versions, leave out the
+// summary, so provisioned capacity decides.
+func currentBillingMode(table *types.TableDescription) types.BillingMode {
+	if summary := table.BillingModeSummary; summary != nil && summary.BillingMode != "" {
+		return summary.BillingMode
+	}
+	if table.ProvisionedThroughput != nil && aws.ToInt64(table.ProvisionedThroughput.ReadCapacityUnits) > 0 {
+		return types.BillingModeProvisioned
+	}
+	return types.BillingModePayPerRequest
+}
+
+func capacityChanged(table *types.TableDescription, spec TableSpec) bool {
+	if spec.billingMode() == types.BillingModeProvisioned {
+		if !sameThroughput(table.ProvisionedThroughput, spec.Capacity) {
+			return true
+		}
+		for _, gsi := range table.GlobalSecondaryIndexes {
+			if !sameThroughput(gsi.ProvisionedThroughput, spec.Capacity) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var current Capacity
+	if t := table.OnDemandThroughput; t != nil {
+		current = Capacity{Read: max(aws.ToInt64(t.MaxReadRequestUnits), 0), Write: max(aws.ToInt64(t.MaxWriteRequestUnits), 0)}
+	}
+	return current != spec.Capacity
+}
+
+func sameThroughput(t *types.ProvisionedThroughputDescription, capacity Capacity) bool {
+	return t != nil &&
+		aws.ToInt64(t.ReadCapacityUnits) == capacity.Read &&
+		aws.ToInt64(t.WriteCapacityUnits) == capacity.Write
+}
+
+// Plan works out the TableDiff for a spec against the table as it is now
+func Plan(ctx context.Context, client *dynamodb.Client, spec TableSpec) (TableDiff, error) {
+	if err := spec.Validate(); err != nil {
+		return TableDiff{}, fmt.Errorf("invalid table spec: %w", err)
+	}
+	desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(spec.Name),
+	})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return TableDiff{Create: true}, nil
+	}
+	if err != nil {
+		return TableDiff{}, fmt.Errorf("failed to describe table: %w", err)
+	}
+
+	diff := Diff(desc.Table, spec)
+	if spec.TTLAttribute != "" {
+		enabled, err := ttlEnabled(ctx, client, spec.Name, spec.TTLAttribute)
+		if err != nil {
+			return TableDiff{}, err
+		}
+		diff.EnableTTL = !enabled
+	}
+	if len(spec.Tags) > 0 {
+		if diff.Tags, err = missingTags(ctx, client, aws.ToString(desc.Table.TableArn), spec.Tags); err != nil {
+			return TableDiff{}, err
+		}
+	}
+	return diff, nil
+}
+
+// missingTags returns the tags in want the table doesn't have with the
+// same value
+func missingTags(ctx context.Context, client *dynamodb.Client, arn string, want map[string]string) (map[string]string, error) {
+	have := make(map[string]string)
+	input := &dynamodb.ListTagsOfResourceInput{ResourceArn: aws.String(arn)}
+	for {
+		page, err := client.ListTagsOfResource(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags: %w", err)
+		}
+		for _, tag := range page.Tags {
+			have[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		if page.NextToken == nil {
+			break
+		}
+		input.NextToken = page.NextToken
+	}
+
+	var missing map[string]string
+	for key, value := range want {
+		if current, ok := have[key]; ok && current == value {
+			continue
+		}
+		if missing == nil {
+			missing = make(map[string]string)
+		}
+		missing[key] = value
+	}
+	return missing, nil
+}
+
+// EnsureTableSpec creates the table if it doesn't exist, otherwise updates
+// it to match the spec
+func EnsureTableSpec(ctx context.Context, client *dynamodb.Client, spec TableSpec) error {
+	diff, err := Plan(ctx, client, spec)
+	if err != nil {
+		return err
+	}
+	return Apply(ctx, client, spec, diff)
+}
+
+// Apply makes the changes in a diff from Plan. DynamoDB takes one kind of
+// change per UpdateTable call and the table must be active in between, so
+// they are made one at a time. AWS allows switching billing mode once a
+// day per table.
+func Apply(ctx context.Context, client *dynamodb.Client, spec TableSpec, diff TableDiff) error {
+	if diff.Create {
+		if err := CreateTableSpec(ctx, client, spec); err != nil {
+			return err
+		}
+		// Time to live can only be turned on once the table is active
+		if err := waitForTable(ctx, client, spec.Name); err != nil {
+			return err
+		}
+		if spec.TTLAttribute == "" {
+			return nil
+		}
+		return enableTTL(ctx, client, spec.Name, spec.TTLAttribute)
+	}
+
+	if diff.BillingMode != "" || diff.Capacity != nil {
+		if err := updateCapacity(ctx, client, spec); err != nil {
+			return err
+		}
+	}
+	if diff.Stream != nil {
+		if err := updateStream(ctx, client, spec.Name, diff.StreamWas, *diff.Stream); err != nil {
+			return err
+		}
+	}
+
+	for _, index := range diff.MissingLocalIndexes {
+		slog.Warn("table is missing a local secondary index, recreate it to add the index",
+			"table", spec.Name, "index", index.Name)
+	}
+	if err := createIndexes(ctx, client, spec.Name, diff.MissingIndexes, spec.provisionedThroughput()); err != nil {
+		return err
+	}
+
+	if diff.EnableTTL {
+		if err := enableTTL(ctx, client, spec.Name, spec.TTLAttribute); err != nil {
+			return err
+		}
+	}
+	if len(diff.Tags) > 0 {
+		if err := tagTable(ctx, client, spec.Name, diff.Tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateCapacity sets the table's billing mode and capacity, and the
+// capacity of its existing GSIs when provisioned
+func updateCapacity(ctx context.Context, client *dynamodb.Client, spec TableSpec) error {
+	slog.Info("updating table capacity", "table", spec.Name, "billing_mode", spec.billingMode(),
+		"read", spec.Capacity.Read, "write", spec.Capacity.Write)
+
+	input := &dynamodb.UpdateTableInput{
+		TableName:             aws.String(spec.Name),
+		BillingMode:           spec.billingMode(),
+		ProvisionedThroughput: spec.provisionedThroughput(),
+		OnDemandThroughput:    spec.onDemandThroughput(true),
+	}
+	if throughput := spec.provisionedThroughput(); throughput != nil {
+		desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(spec.Name)})
+		if err != nil {
+			return fmt.Errorf("failed to describe table: %w", err)
+		}
+		for _, gsi := range desc.Table.GlobalSecondaryIndexes {
+			input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, types.GlobalSecondaryIndexUpdate{
+				Update: &types.UpdateGlobalSecondaryIndexAction{
+					IndexName:             gsi.IndexName,
+					ProvisionedThroughput: throughput,
+				},
+			})
+		}
+	}
+
+	if _, err := client.UpdateTable(ctx, input); err != nil {
+		return fmt.Errorf("failed to update table capacity: %w", err)
+	}
+	return waitForTable(ctx, client, spec.Name)
+}
+
+// updateStream turns the stream on, off or to another view. Changing the
+// view means turning the stream off first.
+func updateStream(ctx context.Context, client *dynamodb.Client, tableName string, from, to types.StreamViewType) error {
+	set := func(spec *types.StreamSpecification) error {
+		_, err := client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:           aws.String(tableName),
+			StreamSpecification: spec,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update stream: %w", err)
+		}
+		return waitForTable(ctx, client, tableName)
+	}
+
+	if from != "" {
+		slog.Info("turning off table stream", "table", tableName, "view", from)
+		if err := set(&types.StreamSpecification{StreamEnabled: aws.Bool(false)}); err != nil {
+			return err
+		}
+	}
+	if to == "" {
+		return nil
+	}
+	slog.Info("turning on table stream", "table", tableName, "view", to)
+	return set(&types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: to})
+}
+
+func tagTable(ctx context.Context, client *dynamodb.Client, tableName string, tags map[string]string) error {
+	desc, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+	slog.Info("tagging table", "table", tableName, "tags", len(tags))
+	_, err = client.TagResource(ctx, &dynamodb.TagResourceInput{
+		ResourceArn: desc.Table.TableArn,
+		Tags:        tagList(tags),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag table: %w", err)
+	}
+	return nil
+}
+
+// tagList is tags as DynamoDB takes them, sorted by key
+func tagList(tags map[string]string) []types.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]types.Tag, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, types.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return list
+}
+
+// waitForTable waits for the table to be ACTIVE
+func waitForTable(ctx context.Context, client *dynamodb.Client, tableName string) error {
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)}, 5*time.Minute); err != nil {
+		return fmt.Errorf("failed waiting for table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package search
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// ProductIndex is where the Indexer mirrors products to
+type ProductIndex interface {
+	IndexProduct(ctx context.Context, product models.Product) error
+}
+
+// Indexer mirrors product writes into a search index in the background.
+// Register Hook on the product store and start Run in a goroutine.
+//
+// Hooks run before the write reaches DynamoDB, so a write that then fails
+// can still be indexed; the next successful write of the product fixes it.
+type Indexer struct {
+	index   ProductIndex
+	pending chan models.Product
+}
+
+// NewIndexer creates an Indexer that queues up to buffer products
+func NewIndexer(index ProductIndex, buffer int) *Indexer {
+	return &Indexer{
+		index:   index,
+		pending: make(chan models.Product, buffer),
+	}
+}
+
+// Hook queues every product put through the store for indexing. It never
+// blocks a write: when the queue is full the product is dropped with a warning.
+func (i *Indexer) Hook() repository.WriteHook {
+	return func(ctx context.Context, op repository.WriteOp) {
+		if op.EntityType != repository.EntityProduct || op.Item == nil {
+			return
+		}
+		var item repository.GenericItem[models.Product]
+		if err := attributevalue.UnmarshalMap(op.Item, &item); err != nil {
+			slog.Warn("failed to decode product for search index", "pk", op.PK, "sk", op.SK, "error", err)
+			return
+		}
+		select {
+		case i.pending <- item.Data:
+		default:
+			slog.Warn("search index queue full, dropping product", "product_id", item.Data.ProductID)
+		}
+	}
+}
+
+// Run indexes queued products until ctx is done
+func (i *Indexer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case product := <-i.pending:
+			if err := i.index.IndexProduct(ctx, product); err != nil {
+				slog.Error("failed to index product", "product_id", product.ProductID, "error", err)
+			}
+		}
+	}
+}
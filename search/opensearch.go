@@ -0,0 +1,167 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// defaultPageSize is used when a search doesn't set QueryOptions.Limit
+const defaultPageSize = 20
+
+// OpenSearch mirrors products into an OpenSearch (or Elasticsearch) index and
+// runs full-text queries against it over the REST API
+type OpenSearch struct {
+	endpoint string
+	index    string
+	client   *http.Client
+}
+
+// NewOpenSearch creates a client for the index at endpoint
+func NewOpenSearch(endpoint, index string, client *http.Client) *OpenSearch {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OpenSearch{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		index:    index,
+		client:   client,
+	}
+}
+
+// IndexProduct creates or replaces the product's document
+func (o *OpenSearch) IndexProduct(ctx context.Context, product models.Product) error {
+	body, err := json.Marshal(product)
+	if err != nil {
+		return fmt.Errorf("failed to marshal product: %w", err)
+	}
+	path := fmt.Sprintf("/%s/_doc/%s", url.PathEscape(o.index), url.PathEscape(product.ProductID))
+	if err := o.do(ctx, http.MethodPut, path, body, nil); err != nil {
+		return fmt.Errorf("failed to index product %s: %w", product.ProductID, err)
+	}
+	return nil
+}
+
+// searchRequest is the body of a _search call
+type searchRequest struct {
+	From  int            `json:"from"`
+	Size  int            `json:"size"`
+	Query map[string]any `json:"query"`
+}
+
+// searchResponse is the part of a _search response we read
+type searchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Source models.Product `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// SearchProducts runs a fuzzy full-text query over product names and
+// categories. The page token carries the offset of the next page.
+func (o *OpenSearch) SearchProducts(ctx context.Context, query string, opts *repository.QueryOptions) (*repository.ProductsPage, error) {
+	size := defaultPageSize
+	from := 0
+	if opts != nil {
+		if opts.Limit > 0 {
+			size = int(opts.Limit)
+		}
+		if opts.PageToken != nil {
+			offset, err := tokenOffset(opts.PageToken)
+			if err != nil {
+				return nil, err
+			}
+			from = offset
+		}
+	}
+
+	body, err := json.Marshal(searchRequest{
+		From: from,
+		Size: size,
+		Query: map[string]any{
+			"multi_match": map[string]any{
+				"query":     query,
+				"fields":    []string{"name^2", "category"},
+				"fuzziness": "AUTO",
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal search: %w", err)
+	}
+
+	var resp searchResponse
+	if err := o.do(ctx, http.MethodPost, fmt.Sprintf("/%s/_search", url.PathEscape(o.index)), body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to search products: %w", err)
+	}
+
+	products := make([]models.Product, len(resp.Hits.Hits))
+	for i, hit := range resp.Hits.Hits {
+		products[i] = hit.Source
+	}
+
+	page := &repository.ProductsPage{Products: products}
+	page.Count = int32(len(products))
+	page.ScannedCount = page.Count
+	if next := from + len(products); len(products) > 0 && next < resp.Hits.Total.Value {
+		page.NextPageToken = offsetToken(next)
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// do sends a JSON request and decodes the response into out, if set
+func (o *OpenSearch) do(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, o.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("opensearch returned %s: %s", resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// offsetToken wraps a result offset in an opaque page token
+func offsetToken(offset int) *repository.PageToken {
+	return repository.NewPageToken(map[string]types.AttributeValue{
+		"from": &types.AttributeValueMemberN{Value: strconv.Itoa(offset)},
+	})
+}
+
+// tokenOffset reads the offset back out of a token made by offsetToken
+func tokenOffset(token *repository.PageToken) (int, error) {
+	if n, ok := token.Raw()["from"].(*types.AttributeValueMemberN); ok {
+		if offset, err := strconv.Atoi(n.Value); err == nil && offset >= 0 {
+			return offset, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: not a search page token", repository.ErrInvalidPageToken)
+}
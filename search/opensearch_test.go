@@ -0,0 +1,143 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+// fakeOpenSearch stores indexed documents and answers every search with
+// the requested slice of them
+type fakeOpenSearch struct {
+	docs []models.Product
+}
+
+func (f *fakeOpenSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/products/_doc/"+r.PathValue("id"):
+		var product models.Product
+		if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.docs = append(f.docs, product)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost && r.URL.Path == "/products/_search":
+		var req searchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var resp searchResponse
+		resp.Hits.Total.Value = len(f.docs)
+		for _, doc := range f.docs[min(req.From, len(f.docs)):min(req.From+req.Size, len(f.docs))] {
+			resp.Hits.Hits = append(resp.Hits.Hits, struct {
+				Source models.Product `json:"_source"`
+			}{doc})
+		}
+		json.NewEncoder(w).Encode(resp)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeOpenSearch(t *testing.T) (*OpenSearch, *fakeOpenSearch) {
+	t.Helper()
+	fake := &fakeOpenSearch{}
+	mux := http.NewServeMux()
+	mux.Handle("/products/_doc/{id}", fake)
+	mux.Handle("/products/_search", fake)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return NewOpenSearch(server.URL+"/", "products", server.Client()), fake
+}
+
+func TestOpenSearch_IndexAndSearch(t *testing.T) {
+	client, _ := newFakeOpenSearch(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"PROD1", "PROD2", "PROD3"} {
+		if err := client.IndexProduct(ctx, fixtures.NewProduct().WithID(id).Build()); err != nil {
+			t.Fatalf("Failed to index product: %v", err)
+		}
+	}
+
+	// Test the token carries the offset of the next page
+	page, err := client.SearchProducts(ctx, "product", &repository.QueryOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("Failed to search products: %v", err)
+	}
+	if len(page.Products) != 2 || !page.HasMore || page.NextPageToken == nil {
+		t.Fatalf("Unexpected first page %+v", page)
+	}
+	page, err = client.SearchProducts(ctx, "product", &repository.QueryOptions{Limit: 2, PageToken: page.NextPageToken})
+	if err != nil {
+		t.Fatalf("Failed to get second page: %v", err)
+	}
+	if len(page.Products) != 1 || page.Products[0].ProductID != "PROD3" || page.HasMore {
+		t.Errorf("Unexpected second page %+v", page)
+	}
+
+	// Test table page tokens are rejected
+	tableToken := repository.NewPageToken(map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "PRODUCT#ALL"},
+	})
+	_, err = client.SearchProducts(ctx, "product", &repository.QueryOptions{PageToken: tableToken})
+	if !errors.Is(err, repository.ErrInvalidPageToken) {
+		t.Errorf("Expected ErrInvalidPageToken, got %v", err)
+	}
+}
+
+// recordingIndex sends each indexed product on a channel
+type recordingIndex chan models.Product
+
+func (r recordingIndex) IndexProduct(ctx context.Context, product models.Product) error {
+	r <- product
+	return nil
+}
+
+func TestIndexer(t *testing.T) {
+	indexed := make(recordingIndex, 10)
+	indexer := NewIndexer(indexed, 10)
+	hook := indexer.Hook()
+
+	product := fixtures.NewProduct().Build()
+	av, err := attributevalue.MarshalMap(repository.GenericItem[models.Product]{
+		PK: repository.Key.ProductPK(), SK: repository.Key.ProductSK(product.ProductID),
+		EntityType: repository.EntityProduct, Data: product,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only product writes are queued
+	hook(context.Background(), repository.WriteOp{EntityType: repository.EntityPage, Item: av})
+	hook(context.Background(), repository.WriteOp{EntityType: repository.EntityProduct, Item: av})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go indexer.Run(ctx)
+
+	select {
+	case got := <-indexed:
+		if got.ProductID != product.ProductID {
+			t.Errorf("Indexed %s, want %s", got.ProductID, product.ProductID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the product to be indexed")
+	}
+	if len(indexer.pending) != 0 {
+		t.Errorf("Expected the queue to be drained, %d left", len(indexer.pending))
+	}
+}
@@ -0,0 +1,24 @@
+// Package search finds products for the web search box, either with a
+// prefix query on the table or, when configured, through OpenSearch.
+package search
+
+import (
+	"context"
+
+	"LearnSingleTableDesign/repository"
+)
+
+// Service finds products matching a user's query
+type Service interface {
+	SearchProducts(ctx context.Context, query string, opts *repository.QueryOptions) (*repository.ProductsPage, error)
+}
+
+// PrefixSearch matches product names by prefix using the table's GSI1.
+// It's the fallback when no search cluster is configured.
+type PrefixSearch struct {
+	Products repository.Catalog
+}
+
+func (p PrefixSearch) SearchProducts(ctx context.Context, query string, opts *repository.QueryOptions) (*repository.ProductsPage, error) {
+	return p.Products.SearchByNamePrefix(ctx, query, opts)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// batchOrders is implemented by order repositories that can write many
+// orders in one batch
+type batchOrders interface {
+	PutMany(ctx context.Context, orders []models.Order) (*repository.BatchResult, error)
+}
+
+// orderPlacer places orders the way the shop does, pricing them from the
+// products and taking the units out of stock
+type orderPlacer interface {
+	Place(ctx context.Context, userEmail string, productIDs []string) (*models.Order, error)
+}
+
+// seedDemoData inserts sample products, pages, a user and their orders,
+// then walks the orders page by page to demonstrate pagination. Orders go
+// through placer when there is one, and are written directly otherwise.
+func seedDemoData(
+	userRepo repository.Users,
+	orderRepo repository.Orders,
+	productRepo repository.Catalog,
+	pageRepo repository.Pages,
+	placer orderPlacer,
+) {
+	// Insert some misc products
+	products := []models.Product{
+		{
+			ProductID: "PROD1",
+			Name:      "Product 1",
+			Price:     10.99,
+			Category:  "Electronics",
+			Stock:     23,
+			// Already below its threshold, so it shows up as low stock
+			LowStockThreshold: 25,
+		},
+		{
+			ProductID: "PROD2",
+			Name:      "Product 2",
+			Price:     20.99,
+			Category:  "Electronics",
+			Stock:     100,
+
+			LowStockThreshold: 10,
+			Featured:          true,
+		},
+	}
+	for _, product := range products {
+		err := productRepo.Put(context.Background(), product)
+		if err != nil {
+			log.Fatalf("failed to put product: %v", err)
+		}
+		fmt.Printf("Created product: %s\n", product.ProductID)
+	}
+
+	// Add localized content for the first product
+	contents := []models.ProductContent{
+		{ProductID: "PROD1", Locale: "en", Name: "Product 1", Description: "Our best selling gadget."},
+		{ProductID: "PROD1", Locale: "fr", Name: "Produit 1", Description: "Notre gadget le plus vendu."},
+	}
+	for _, content := range contents {
+		if err := productRepo.PutContent(context.Background(), content); err != nil {
+			log.Fatalf("failed to put product content: %v", err)
+		}
+	}
+
+	// Create the pages linked from the navbar
+	pages := []models.Page{
+		{
+			Slug:      "contact",
+			Title:     "Contact",
+			Markdown:  "Reach us at **hello@example.com**.",
+			Status:    models.PageStatusPublished,
+			NavLabel:  "Contact",
+			NavOrder:  1,
+			UpdatedAt: time.Now(),
+		},
+		{
+			Slug:      "about",
+			Title:     "About",
+			Markdown:  "A demo of *single table design* with DynamoDB.",
+			Status:    models.PageStatusPublished,
+			NavLabel:  "About",
+			NavOrder:  2,
+			UpdatedAt: time.Now(),
+		},
+	}
+	for _, page := range pages {
+		if err := pageRepo.Put(context.Background(), page); err != nil {
+			log.Fatalf("failed to put page: %v", err)
+		}
+	}
+
+	// Example: Create a new user
+	user := models.User{
+		Email:     "john@example.com",
+		Name:      "John Doe",
+		CreatedAt: time.Now(),
+	}
+
+	// Put user in DynamoDB
+	if err := userRepo.Put(context.TODO(), user); err != nil {
+		log.Fatalf("failed to put user: %v", err)
+	}
+	fmt.Println("Successfully created user:", user.Email)
+
+	if placer != nil {
+		placeDemoOrders(orderRepo, placer, user.Email)
+	} else {
+		putDemoOrders(orderRepo, user.Email)
+	}
+
+	// Demonstrate pagination
+	fmt.Println("\nFetching orders with pagination (2 items per page):")
+	var pageToken *repository.PageToken
+	pageNum := 1
+
+	for {
+		// Get a page of orders
+		page, err := orderRepo.GetUserOrders(context.TODO(), user.Email, &repository.QueryOptions{
+			Limit:     2,
+			PageToken: pageToken,
+		})
+		if err != nil {
+			log.Fatalf("failed to get user orders: %v", err)
+		}
+
+		fmt.Printf("\nPage %d:\n", pageNum)
+		for _, order := range page.Orders {
+			fmt.Printf("Order: %s, Total: $%.2f\n", order.OrderID, order.Total)
+		}
+
+		// If there's no next page token, we've reached the end
+		if page.NextPageToken == nil {
+			break
+		}
+
+		// Set up for next page
+		pageToken = page.NextPageToken
+		pageNum++
+	}
+}
+
+// placeDemoOrders places five orders for the user, alternating between the
+// demo products. Placed orders get new IDs, so a user who already has
+// orders is left alone rather than given five more on every start.
+func placeDemoOrders(orderRepo repository.Orders, placer orderPlacer, email string) {
+	existing, err := orderRepo.GetUserOrders(context.TODO(), email, &repository.QueryOptions{Limit: 1})
+	if err != nil {
+		log.Fatalf("failed to get user orders: %v", err)
+	}
+	if len(existing.Orders) > 0 {
+		return
+	}
+	for i := 1; i <= 5; i++ {
+		var productIDs []string
+		for range i {
+			productIDs = append(productIDs, fmt.Sprintf("PROD%d", (i-1)%2+1))
+		}
+		order, err := placer.Place(context.TODO(), email, productIDs)
+		if err != nil {
+			log.Fatalf("failed to place order: %v", err)
+		}
+		fmt.Printf("Created order: %s\n", order.OrderID)
+	}
+}
+
+// putDemoOrders writes five orders for the user, in one batch where
+// supported
+func putDemoOrders(orderRepo repository.Orders, email string) {
+	var orders []models.Order
+	for i := 1; i <= 5; i++ {
+		orders = append(orders, models.Order{
+			OrderID:   fmt.Sprintf("ORD%d", i),
+			UserEmail: email,
+			Status:    models.OrderStatusPending,
+			CreatedAt: time.Now(),
+			Items: []models.LineItem{{
+				ProductID: fmt.Sprintf("PROD%d", i),
+				Name:      fmt.Sprintf("Product %d", i),
+				UnitPrice: float64(i) * 10.99,
+				Quantity:  1,
+			}},
+		})
+	}
+	if batch, ok := orderRepo.(batchOrders); ok {
+		result, err := batch.PutMany(context.TODO(), orders)
+		if err != nil {
+			log.Fatalf("failed to put orders: %v", err)
+		}
+		if !result.OK() {
+			slog.Warn("some orders were not created", "result", result)
+		}
+		for _, key := range result.Succeeded {
+			fmt.Printf("Created order: %s\n", key.SK)
+		}
+		return
+	}
+	for _, order := range orders {
+		if err := orderRepo.Put(context.TODO(), order); err != nil {
+			log.Fatalf("failed to put order: %v", err)
+		}
+		fmt.Printf("Created order: %s\n", order.OrderID)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/search"
+	"LearnSingleTableDesign/sqlstore"
+	"LearnSingleTableDesign/web"
+)
+
+// runSQLite serves the shop from a SQLite database instead of DynamoDB,
+// for offline demos. The admin dashboard, reports, audit log and the
+// DynamoDB-backed background work aren't available.
+func runSQLite(appCfg config.Config) {
+	stores, err := sqlstore.Open(appCfg.SQLitePath)
+	if err != nil {
+		log.Fatalf("failed to open sqlite database: %v", err)
+	}
+	defer stores.Close()
+	slog.Info("using sqlite backend", "path", appCfg.SQLitePath)
+
+	// Seed a fresh database so there is something to look at
+	page, err := stores.Products.All(context.TODO(), &repository.QueryOptions{Limit: 1})
+	if err != nil {
+		log.Fatalf("failed to read products: %v", err)
+	}
+	if page.Count == 0 {
+		// SQLite has no order service, so the orders are written directly
+		seedDemoData(stores.Users, stores.Orders, stores.Products, stores.Pages, nil)
+	}
+
+	imageStore, err := newImageStore(context.TODO(), appCfg)
+	if err != nil {
+		log.Fatalf("unable to create image store, %v", err)
+	}
+
+	web.Start(
+		appCfg,
+		stores.Users, nil, stores.Products, stores.Pages, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+		search.PrefixSearch{Products: stores.Products}, newConverter(appCfg), nil, nil, imageStore,
+	)
+}
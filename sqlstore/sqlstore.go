@@ -0,0 +1,533 @@
+// Package sqlstore keeps users, products, orders and pages in SQLite,
+// behind the same repository interfaces as the DynamoDB table, so the web
+// app can run offline and the relational design can be compared with the
+// single table. Entities are stored as JSON next to the columns queries
+// filter and sort on.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	// Registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// defaultPageSize is the page size when QueryOptions has no limit
+const defaultPageSize = 100
+
+// schema creates the tables, and is safe to run on every start
+const schema = `
+CREATE TABLE IF NOT EXISTS users (
+	email TEXT PRIMARY KEY,
+	data  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS products (
+	product_id          TEXT PRIMARY KEY,
+	name                TEXT NOT NULL,
+	stock               INTEGER NOT NULL,
+	low_stock_threshold INTEGER NOT NULL,
+	featured            INTEGER NOT NULL,
+	data                TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS products_name ON products (lower(name), product_id);
+CREATE TABLE IF NOT EXISTS product_content (
+	product_id TEXT NOT NULL,
+	locale     TEXT NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (product_id, locale)
+);
+CREATE TABLE IF NOT EXISTS orders (
+	user_email TEXT NOT NULL,
+	order_id   TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (user_email, order_id)
+);
+CREATE TABLE IF NOT EXISTS pages (
+	slug TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+`
+
+// Stores are the repositories of one SQLite database
+type Stores struct {
+	DB       *sql.DB
+	Users    *Users
+	Products *Products
+	Orders   *Orders
+	Pages    *Pages
+}
+
+// Open opens the SQLite database at path, creating it and its tables if
+// needed. ":memory:" gives a database that lives as long as the Stores.
+func Open(path string) (*Stores, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// SQLite takes one writer at a time, and each connection to :memory:
+	// would get its own database
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+	return &Stores{
+		DB:       db,
+		Users:    &Users{db: db},
+		Products: &Products{db: db},
+		Orders:   &Orders{db: db},
+		Pages:    &Pages{db: db},
+	}, nil
+}
+
+// Close closes the database
+func (s *Stores) Close() error {
+	return s.DB.Close()
+}
+
+var (
+	_ repository.Users   = (*Users)(nil)
+	_ repository.Catalog = (*Products)(nil)
+	_ repository.Orders  = (*Orders)(nil)
+	_ repository.Pages   = (*Pages)(nil)
+)
+
+// getJSON scans the single JSON column of a row into out
+func getJSON(row *sql.Row, out any) error {
+	var data string
+	if err := row.Scan(&data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrNotFound
+		}
+		return fmt.Errorf("failed to read row: %w", err)
+	}
+	if err := json.Unmarshal([]byte(data), out); err != nil {
+		return fmt.Errorf("failed to unmarshal row: %w", err)
+	}
+	return nil
+}
+
+// scanJSON collects the JSON column of each row
+func scanJSON[T any](rows *sql.Rows) ([]T, error) {
+	defer rows.Close()
+	var all []T
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		var v T
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal row: %w", err)
+		}
+		all = append(all, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return all, nil
+}
+
+func marshal(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal row: %w", err)
+	}
+	return string(data), nil
+}
+
+// Users stores users by email
+type Users struct {
+	db *sql.DB
+}
+
+func (r *Users) Put(ctx context.Context, user models.User) error {
+	user.Email = models.NormalizeEmail(user.Email)
+	if err := user.Validate(); err != nil {
+		return err
+	}
+	data, err := marshal(user)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT OR REPLACE INTO users (email, data) VALUES (?, ?)`, user.Email, data)
+	if err != nil {
+		return fmt.Errorf("failed to put user: %w", err)
+	}
+	return nil
+}
+
+func (r *Users) Get(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	row := r.db.QueryRowContext(ctx, `SELECT data FROM users WHERE email = ?`, models.NormalizeEmail(email))
+	if err := getJSON(row, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserWithOrders reads the user and their orders with one join. The
+// aggregate has no addresses or stats, which this backend doesn't store.
+func (r *Users) GetUserWithOrders(ctx context.Context, email string) (*repository.UserAggregate, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.data, o.data FROM users u
+		LEFT JOIN orders o ON o.user_email = u.email
+		WHERE u.email = ?
+		ORDER BY o.order_id`, models.NormalizeEmail(email))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user with orders: %w", err)
+	}
+	defer rows.Close()
+
+	var aggregate *repository.UserAggregate
+	for rows.Next() {
+		var userData string
+		var orderData sql.NullString
+		if err := rows.Scan(&userData, &orderData); err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		if aggregate == nil {
+			aggregate = &repository.UserAggregate{}
+			if err := json.Unmarshal([]byte(userData), &aggregate.User); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+			}
+		}
+		if !orderData.Valid {
+			continue
+		}
+		var order models.Order
+		if err := json.Unmarshal([]byte(orderData.String), &order); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal order: %w", err)
+		}
+		aggregate.Orders = append(aggregate.Orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	if aggregate == nil {
+		return nil, repository.ErrNotFound
+	}
+	return aggregate, nil
+}
+
+// Products stores products and their localized content
+type Products struct {
+	db *sql.DB
+}
+
+func (r *Products) Put(ctx context.Context, product models.Product) error {
+	if err := product.Validate(); err != nil {
+		return err
+	}
+	data, err := marshal(product)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO products (product_id, name, stock, low_stock_threshold, featured, data)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		product.ProductID, product.Name, product.Stock, product.LowStockThreshold, product.Featured, data)
+	if err != nil {
+		return fmt.Errorf("failed to put product: %w", err)
+	}
+	return nil
+}
+
+// SetImageURL sets a product's image URL in place, leaving its stock alone
+func (r *Products) SetImageURL(ctx context.Context, productID, imageURL string) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE products SET data = json_set(data, '$.image_url', ?) WHERE product_id = ?`,
+		imageURL, productID)
+	if err != nil {
+		return fmt.Errorf("failed to set product image: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *Products) Get(ctx context.Context, productID string) (*models.Product, error) {
+	var product models.Product
+	row := r.db.QueryRowContext(ctx, `SELECT data FROM products WHERE product_id = ?`, productID)
+	if err := getJSON(row, &product); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// GetMany reads products by ID, leaving out the ones that don't exist
+func (r *Products) GetMany(ctx context.Context, productIDs []string) (map[string]models.Product, error) {
+	products := make(map[string]models.Product, len(productIDs))
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+	args := make([]any, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+	rows, err := r.db.QueryContext(ctx, `SELECT data FROM products WHERE product_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get products: %w", err)
+	}
+	found, err := scanJSON[models.Product](rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range found {
+		products[product.ProductID] = product
+	}
+	return products, nil
+}
+
+// All returns the products in ID order
+func (r *Products) All(ctx context.Context, opts *repository.QueryOptions) (*repository.ProductsPage, error) {
+	return r.page(ctx, "1 = 1", nil, "product_id", opts)
+}
+
+// SearchByNamePrefix returns products whose name starts with prefix,
+// ignoring case, in name order
+func (r *Products) SearchByNamePrefix(ctx context.Context, prefix string, opts *repository.QueryOptions) (*repository.ProductsPage, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(prefix))
+	return r.page(ctx, `lower(name) LIKE ? ESCAPE '\'`, []any{escaped + "%"}, "lower(name)", opts)
+}
+
+// Featured returns the featured products in name order
+func (r *Products) Featured(ctx context.Context, opts *repository.QueryOptions) (*repository.ProductsPage, error) {
+	return r.page(ctx, "featured", nil, "lower(name)", opts)
+}
+
+// LowStock returns the products below their stock threshold, lowest stock
+// first
+func (r *Products) LowStock(ctx context.Context, opts *repository.QueryOptions) (*repository.ProductsPage, error) {
+	return r.page(ctx, "stock < low_stock_threshold", nil, "stock", opts)
+}
+
+// page reads a page of the products matching where, sorted by column then
+// ID. The page token holds the ID of the last product, and the next page
+// starts after that product's position.
+func (r *Products) page(ctx context.Context, where string, args []any, column string, opts *repository.QueryOptions) (*repository.ProductsPage, error) {
+	if opts == nil {
+		opts = &repository.QueryOptions{}
+	}
+	limit := int(opts.Limit)
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	order, after := "ASC", ">"
+	if opts.Descending {
+		order, after = "DESC", "<"
+	}
+
+	query := `SELECT data FROM products WHERE ` + where
+	if last, ok := lastID(opts.PageToken); ok {
+		query += fmt.Sprintf(` AND (%s, product_id) %s ((SELECT %s FROM products WHERE product_id = ?), ?)`, column, after, column)
+		args = append(args, last, last)
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, product_id %s LIMIT ?`, column, order, order)
+	// One more than the page tells whether there is another page
+	args = append(args, limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query products: %w", err)
+	}
+	products, err := scanJSON[models.Product](rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &repository.ProductsPage{}
+	if len(products) > limit {
+		products = products[:limit]
+		page.NextPageToken = idToken(products[limit-1].ProductID)
+		page.HasMore = true
+	}
+	page.Products = products
+	page.Count = int32(len(products))
+	page.ScannedCount = page.Count
+	return page, nil
+}
+
+// PutContent stores a product's localized content
+func (r *Products) PutContent(ctx context.Context, content models.ProductContent) error {
+	if err := content.Validate(); err != nil {
+		return err
+	}
+	data, err := marshal(content)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT OR REPLACE INTO product_content (product_id, locale, data) VALUES (?, ?, ?)`,
+		content.ProductID, strings.ToLower(content.Locale), data)
+	if err != nil {
+		return fmt.Errorf("failed to put product content: %w", err)
+	}
+	return nil
+}
+
+// GetContent returns the product content for the first locale in the
+// fallback chain that has a translation, or ErrNotFound if none of them do
+func (r *Products) GetContent(ctx context.Context, productID string, locales []string) (*models.ProductContent, error) {
+	for _, locale := range locales {
+		var content models.ProductContent
+		row := r.db.QueryRowContext(ctx, `SELECT data FROM product_content WHERE product_id = ? AND locale = ?`,
+			productID, strings.ToLower(locale))
+		err := getJSON(row, &content)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &content, nil
+	}
+	return nil, repository.ErrNotFound
+}
+
+// Orders stores orders by user and order ID
+type Orders struct {
+	db *sql.DB
+}
+
+// Put stores an order, with its total worked out from its line items like
+// the DynamoDB repository's
+func (r *Orders) Put(ctx context.Context, order models.Order) error {
+	order.UserEmail = models.NormalizeEmail(order.UserEmail)
+	if len(order.Items) > 0 {
+		order.Total = order.ItemsTotal()
+	}
+	if err := order.Validate(); err != nil {
+		return err
+	}
+	data, err := marshal(order)
+	if err != nil {
+		return err
+	}
+	// Status goes through OrderStatus's driver.Valuer
+	_, err = r.db.ExecContext(ctx, `INSERT OR REPLACE INTO orders (user_email, order_id, status, data) VALUES (?, ?, ?, ?)`,
+		order.UserEmail, order.OrderID, order.Status, data)
+	if err != nil {
+		return fmt.Errorf("failed to put order: %w", err)
+	}
+	return nil
+}
+
+func (r *Orders) Get(ctx context.Context, userEmail, orderID string) (*models.Order, error) {
+	var order models.Order
+	row := r.db.QueryRowContext(ctx, `SELECT data FROM orders WHERE user_email = ? AND order_id = ?`,
+		models.NormalizeEmail(userEmail), orderID)
+	if err := getJSON(row, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetUserOrders pages through a user's orders by order ID. opts.Filter
+// isn't supported and is ignored.
+func (r *Orders) GetUserOrders(ctx context.Context, userEmail string, opts *repository.QueryOptions) (*repository.OrdersPage, error) {
+	if opts == nil {
+		opts = &repository.QueryOptions{}
+	}
+	limit := int(opts.Limit)
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	order, after := "ASC", ">"
+	if opts.Descending {
+		order, after = "DESC", "<"
+	}
+
+	query := `SELECT data FROM orders WHERE user_email = ?`
+	args := []any{models.NormalizeEmail(userEmail)}
+	if last, ok := lastID(opts.PageToken); ok {
+		query += ` AND order_id ` + after + ` ?`
+		args = append(args, last)
+	}
+	query += ` ORDER BY order_id ` + order + ` LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders: %w", err)
+	}
+	orders, err := scanJSON[models.Order](rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &repository.OrdersPage{}
+	if len(orders) > limit {
+		orders = orders[:limit]
+		page.NextPageToken = idToken(orders[limit-1].OrderID)
+		page.HasMore = true
+	}
+	page.Orders = orders
+	page.Count = int32(len(orders))
+	page.ScannedCount = page.Count
+	return page, nil
+}
+
+// Pages stores the CMS pages by slug
+type Pages struct {
+	db *sql.DB
+}
+
+func (r *Pages) Put(ctx context.Context, page models.Page) error {
+	if err := page.Validate(); err != nil {
+		return err
+	}
+	data, err := marshal(page)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, `INSERT OR REPLACE INTO pages (slug, data) VALUES (?, ?)`, page.Slug, data); err != nil {
+		return fmt.Errorf("failed to put page: %w", err)
+	}
+	return nil
+}
+
+func (r *Pages) Get(ctx context.Context, slug string) (*models.Page, error) {
+	var page models.Page
+	if err := getJSON(r.db.QueryRowContext(ctx, `SELECT data FROM pages WHERE slug = ?`, slug), &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// All returns every page in slug order
+func (r *Pages) All(ctx context.Context) ([]models.Page, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT data FROM pages ORDER BY slug`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pages: %w", err)
+	}
+	return scanJSON[models.Page](rows)
+}
+
+// tokenAttribute is the attribute page tokens keep the last ID in. Tokens
+// are repository.PageTokens so callers page through either backend alike.
+const tokenAttribute = "id"
+
+func idToken(id string) *repository.PageToken {
+	return repository.NewPageToken(map[string]types.AttributeValue{
+		tokenAttribute: &types.AttributeValueMemberS{Value: id},
+	})
+}
+
+func lastID(token *repository.PageToken) (string, bool) {
+	id, ok := token.Raw()[tokenAttribute].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", false
+	}
+	return id.Value, true
+}
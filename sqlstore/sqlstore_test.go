@@ -0,0 +1,159 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+func openTest(t *testing.T) *Stores {
+	t.Helper()
+	stores, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { stores.Close() })
+	return stores
+}
+
+func TestUsers_GetUserWithOrders(t *testing.T) {
+	stores := openTest(t)
+	ctx := context.Background()
+
+	user := fixtures.NewUser().Build()
+	if err := stores.Users.Put(ctx, user); err != nil {
+		t.Fatalf("Failed to put user: %v", err)
+	}
+
+	// Test a user without orders still comes back from the join
+	aggregate, err := stores.Users.GetUserWithOrders(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to get user with orders: %v", err)
+	}
+	if aggregate.User.Email != user.Email || len(aggregate.Orders) != 0 {
+		t.Errorf("GetUserWithOrders() = %+v, want the user and no orders", aggregate)
+	}
+
+	for i := range 3 {
+		order := fixtures.NewOrderFor(user).WithID(fmt.Sprintf("order-%d", i)).WithStatus(models.OrderStatusCompleted).Build()
+		if err := stores.Orders.Put(ctx, order); err != nil {
+			t.Fatalf("Failed to put order: %v", err)
+		}
+	}
+	aggregate, err = stores.Users.GetUserWithOrders(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("Failed to get user with orders: %v", err)
+	}
+	if len(aggregate.Orders) != 3 || aggregate.Orders[0].Status != models.OrderStatusCompleted {
+		t.Errorf("Orders = %+v, want 3 completed orders", aggregate.Orders)
+	}
+
+	if _, err := stores.Users.GetUserWithOrders(ctx, "nobody@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing user, got %v", err)
+	}
+}
+
+func TestProducts_Pagination(t *testing.T) {
+	stores := openTest(t)
+	ctx := context.Background()
+
+	// Names sort differently from IDs, and ties fall back to the ID
+	names := []string{"delta", "Alpha", "charlie", "bravo", "alpha"}
+	for i, name := range names {
+		product := fixtures.NewProduct().WithID(fmt.Sprintf("p%d", i)).WithName(name).Build()
+		product.Featured = true
+		if err := stores.Products.Put(ctx, product); err != nil {
+			t.Fatalf("Failed to put product: %v", err)
+		}
+	}
+
+	for _, descending := range []bool{false, true} {
+		var got []string
+		opts := &repository.QueryOptions{Limit: 2, Descending: descending}
+		for {
+			page, err := stores.Products.Featured(ctx, opts)
+			if err != nil {
+				t.Fatalf("Failed to get featured products: %v", err)
+			}
+			for _, product := range page.Products {
+				got = append(got, product.ProductID)
+			}
+			if !page.HasMore {
+				break
+			}
+			opts.PageToken = page.NextPageToken
+		}
+		want := "[p1 p4 p3 p2 p0]"
+		if descending {
+			want = "[p0 p2 p3 p4 p1]"
+		}
+		if fmt.Sprint(got) != want {
+			t.Errorf("Featured(descending=%v) = %v, want %v", descending, got, want)
+		}
+	}
+
+	// Test LIKE wildcards in the prefix are matched literally
+	page, err := stores.Products.SearchByNamePrefix(ctx, "AL", nil)
+	if err != nil {
+		t.Fatalf("Failed to search products: %v", err)
+	}
+	if page.Count != 2 {
+		t.Errorf("SearchByNamePrefix(AL) found %d products, want 2", page.Count)
+	}
+	page, err = stores.Products.SearchByNamePrefix(ctx, "_%", nil)
+	if err != nil {
+		t.Fatalf("Failed to search products: %v", err)
+	}
+	if page.Count != 0 {
+		t.Errorf("SearchByNamePrefix(_%%) found %d products, want 0", page.Count)
+	}
+}
+
+func TestProducts_GetContent(t *testing.T) {
+	stores := openTest(t)
+	ctx := context.Background()
+
+	content := models.ProductContent{ProductID: "p1", Locale: "de", Name: "Kaffee"}
+	if err := stores.Products.PutContent(ctx, content); err != nil {
+		t.Fatalf("Failed to put content: %v", err)
+	}
+
+	got, err := stores.Products.GetContent(ctx, "p1", []string{"fr", "DE", "en"})
+	if err != nil {
+		t.Fatalf("Failed to get content: %v", err)
+	}
+	if got.Name != "Kaffee" {
+		t.Errorf("GetContent() = %+v, want the German content", got)
+	}
+	if _, err := stores.Products.GetContent(ctx, "p1", []string{"fr"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without a matching locale, got %v", err)
+	}
+}
+
+func TestProducts_SetImageURL(t *testing.T) {
+	stores := openTest(t)
+	ctx := context.Background()
+
+	product := fixtures.NewProduct().WithStock(4).Build()
+	if err := stores.Products.Put(ctx, product); err != nil {
+		t.Fatalf("Failed to put product: %v", err)
+	}
+	if err := stores.Products.SetImageURL(ctx, product.ProductID, "/images/mug.png"); err != nil {
+		t.Fatalf("Failed to set image: %v", err)
+	}
+	got, err := stores.Products.Get(ctx, product.ProductID)
+	if err != nil {
+		t.Fatalf("Failed to get product: %v", err)
+	}
+	if got.ImageURL != "/images/mug.png" || got.Stock != 4 {
+		t.Errorf("Product = %+v, want the image and the stock kept", got)
+	}
+	if err := stores.Products.SetImageURL(ctx, "missing", "/images/x.png"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing product, got %v", err)
+	}
+}
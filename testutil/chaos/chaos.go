@@ -0,0 +1,296 @@
+// Package chaos injects DynamoDB faults into a client for tests: throttled
+// and timed out requests, batch items left unprocessed and cancelled
+// transactions, at random with configurable rates. It works as client
+// middleware, so the code under test keeps its *dynamodb.Client and the
+// faults reach it the way real ones would, through the SDK's retries and
+// error types, against the fake or an emulator alike.
+//
+//	c := chaos.New(chaos.Config{ThrottleRate: 0.3, Seed: 1})
+//	client := testutil.CreateTestClient(t, c.Option(), chaos.FastRetries(5))
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"LearnSingleTableDesign/dynamoclient"
+)
+
+// Config says which faults to inject and how often. Rates are
+// probabilities from 0 to 1; zero injects nothing.
+type Config struct {
+	// ThrottleRate is the chance a request attempt fails with
+	// ProvisionedThroughputExceededException, which the SDK retries
+	ThrottleRate float64
+	// TimeoutRate is the chance a request attempt hangs for Hang and then
+	// fails as a timed out connection, which the SDK retries. A context
+	// done sooner ends it with the context's error.
+	TimeoutRate float64
+	// Hang is how long timed out attempts hang; zero means 5 seconds
+	Hang time.Duration
+	// UnprocessedRate is the chance each item of a BatchWriteItem or
+	// BatchGetItem is held back and returned unprocessed
+	UnprocessedRate float64
+	// ConflictRate is the chance a TransactWriteItems call is cancelled
+	// for a transaction conflict, writing nothing
+	ConflictRate float64
+	// Operations limits the faults to these operations, e.g.
+	// "TransactWriteItems"; empty means every operation
+	Operations []string
+	// Seed seeds the random faults, so a failing test can be replayed
+	Seed int64
+}
+
+// Stats counts the faults injected so far
+type Stats struct {
+	Throttles   int
+	Timeouts    int
+	Unprocessed int
+	Conflicts   int
+}
+
+// Chaos injects the faults of a Config into the clients it is added to
+type Chaos struct {
+	cfg        Config
+	operations map[string]bool
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	stats Stats
+}
+
+// New creates a Chaos injecting the faults cfg describes
+func New(cfg Config) *Chaos {
+	if cfg.Hang <= 0 {
+		cfg.Hang = 5 * time.Second
+	}
+	operations := make(map[string]bool, len(cfg.Operations))
+	for _, operation := range cfg.Operations {
+		operations[operation] = true
+	}
+	return &Chaos{cfg: cfg, operations: operations, rng: rand.New(rand.NewSource(cfg.Seed))}
+}
+
+// Option adds the faults to a client built by dynamoclient.New or
+// testutil.CreateTestClient
+func (c *Chaos) Option() dynamoclient.Option {
+	return dynamoclient.WithMiddleware(c.Middleware)
+}
+
+// FastRetries gives a client the SDK's standard retries, up to maxAttempts
+// attempts per call, with millisecond backoff and no retry quota, so tests
+// injecting faults don't wait seconds between attempts or run out of
+// retries
+func FastRetries(maxAttempts int) dynamoclient.Option {
+	return dynamoclient.WithRetryer(func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = maxAttempts
+			o.MaxBackoff = 5 * time.Millisecond
+			o.RateLimiter = ratelimit.None
+		})
+	})
+}
+
+// Middleware registers the fault middleware on a request's stack.
+// Throttling and timeouts are injected per attempt, inside the SDK's
+// retries; unprocessed items and conflicts once per call, after any
+// middleware the caller adds at the start of the stack.
+func (c *Chaos) Middleware(stack *middleware.Stack) error {
+	if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ChaosCall", c.handleCall), middleware.After); err != nil {
+		return err
+	}
+	attempt := middleware.FinalizeMiddlewareFunc("ChaosAttempt", c.handleAttempt)
+	if _, ok := stack.Finalize.Get((&retry.Attempt{}).ID()); ok {
+		return stack.Finalize.Insert(attempt, (&retry.Attempt{}).ID(), middleware.After)
+	}
+	return stack.Finalize.Add(attempt, middleware.After)
+}
+
+// Stats reports the faults injected so far
+func (c *Chaos) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// roll reports whether a fault with the given rate happens, counting it in
+// stat if so
+func (c *Chaos) roll(rate float64, stat *int) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rng.Float64() >= rate {
+		return false
+	}
+	*stat++
+	return true
+}
+
+func (c *Chaos) affects(ctx context.Context) bool {
+	return len(c.operations) == 0 || c.operations[middleware.GetOperationName(ctx)]
+}
+
+func (c *Chaos) handleAttempt(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	if !c.affects(ctx) {
+		return next.HandleFinalize(ctx, in)
+	}
+	if c.roll(c.cfg.ThrottleRate, &c.stats.Throttles) {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, &types.ProvisionedThroughputExceededException{
+			Message: aws.String("throttled by chaos"),
+		}
+	}
+	if c.roll(c.cfg.TimeoutRate, &c.stats.Timeouts) {
+		select {
+		case <-ctx.Done():
+			return middleware.FinalizeOutput{}, middleware.Metadata{}, ctx.Err()
+		case <-time.After(c.cfg.Hang):
+			return middleware.FinalizeOutput{}, middleware.Metadata{}, &smithyhttp.RequestSendError{Err: timeoutError{}}
+		}
+	}
+	return next.HandleFinalize(ctx, in)
+}
+
+func (c *Chaos) handleCall(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	if !c.affects(ctx) {
+		return next.HandleInitialize(ctx, in)
+	}
+	switch params := in.Parameters.(type) {
+	case *dynamodb.BatchWriteItemInput:
+		return c.batchWrite(ctx, in, params, next)
+	case *dynamodb.BatchGetItemInput:
+		return c.batchGet(ctx, in, params, next)
+	case *dynamodb.TransactWriteItemsInput:
+		if c.roll(c.cfg.ConflictRate, &c.stats.Conflicts) {
+			return middleware.InitializeOutput{}, middleware.Metadata{}, conflict(len(params.TransactItems))
+		}
+	}
+	return next.HandleInitialize(ctx, in)
+}
+
+// batchWrite sends the requests chaos doesn't hold back, and returns the
+// rest as unprocessed
+func (c *Chaos) batchWrite(ctx context.Context, in middleware.InitializeInput, params *dynamodb.BatchWriteItemInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	sent := make(map[string][]types.WriteRequest, len(params.RequestItems))
+	held := make(map[string][]types.WriteRequest)
+	for table, requests := range params.RequestItems {
+		for _, request := range requests {
+			if c.roll(c.cfg.UnprocessedRate, &c.stats.Unprocessed) {
+				held[table] = append(held[table], request)
+			} else {
+				sent[table] = append(sent[table], request)
+			}
+		}
+	}
+	if len(held) == 0 {
+		return next.HandleInitialize(ctx, in)
+	}
+
+	out := middleware.InitializeOutput{Result: &dynamodb.BatchWriteItemOutput{}}
+	var md middleware.Metadata
+	if len(sent) > 0 {
+		copied := *params
+		copied.RequestItems = sent
+		in.Parameters = &copied
+		var err error
+		if out, md, err = next.HandleInitialize(ctx, in); err != nil {
+			return out, md, err
+		}
+	}
+	result := out.Result.(*dynamodb.BatchWriteItemOutput)
+	if result.UnprocessedItems == nil {
+		result.UnprocessedItems = map[string][]types.WriteRequest{}
+	}
+	for table, requests := range held {
+		result.UnprocessedItems[table] = append(result.UnprocessedItems[table], requests...)
+	}
+	return out, md, nil
+}
+
+// batchGet reads the keys chaos doesn't hold back, and returns the rest as
+// unprocessed
+func (c *Chaos) batchGet(ctx context.Context, in middleware.InitializeInput, params *dynamodb.BatchGetItemInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	sent := make(map[string]types.KeysAndAttributes, len(params.RequestItems))
+	held := make(map[string]types.KeysAndAttributes)
+	for table, request := range params.RequestItems {
+		for _, key := range request.Keys {
+			bucket := sent
+			if c.roll(c.cfg.UnprocessedRate, &c.stats.Unprocessed) {
+				bucket = held
+			}
+			keys := bucket[table]
+			if keys.Keys == nil {
+				keys = request
+				keys.Keys = nil
+			}
+			keys.Keys = append(keys.Keys, key)
+			bucket[table] = keys
+		}
+	}
+	if len(held) == 0 {
+		return next.HandleInitialize(ctx, in)
+	}
+
+	out := middleware.InitializeOutput{Result: &dynamodb.BatchGetItemOutput{}}
+	var md middleware.Metadata
+	if len(sent) > 0 {
+		copied := *params
+		copied.RequestItems = sent
+		in.Parameters = &copied
+		var err error
+		if out, md, err = next.HandleInitialize(ctx, in); err != nil {
+			return out, md, err
+		}
+	}
+	result := out.Result.(*dynamodb.BatchGetItemOutput)
+	if result.UnprocessedKeys == nil {
+		result.UnprocessedKeys = map[string]types.KeysAndAttributes{}
+	}
+	for table, request := range held {
+		keys := result.UnprocessedKeys[table]
+		if keys.Keys == nil {
+			keys = request
+			keys.Keys = nil
+		}
+		keys.Keys = append(keys.Keys, request.Keys...)
+		result.UnprocessedKeys[table] = keys
+	}
+	return out, md, nil
+}
+
+// conflict is the error DynamoDB cancels a transaction of n items with when
+// another request touched one of them
+func conflict(n int) error {
+	reasons := make([]types.CancellationReason, n)
+	for i := range reasons {
+		reasons[i].Code = aws.String("None")
+	}
+	if n > 0 {
+		reasons[0] = types.CancellationReason{
+			Code:    aws.String("TransactionConflict"),
+			Message: aws.String("Transaction is ongoing for the item"),
+		}
+	}
+	return &types.TransactionCanceledException{
+		Message:             aws.String("Transaction cancelled by chaos [TransactionConflict]"),
+		CancellationReasons: reasons,
+	}
+}
+
+// timeoutError is a net.Error for a connection that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout (injected by chaos)" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
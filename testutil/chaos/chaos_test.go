@@ -0,0 +1,166 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/schema"
+	"LearnSingleTableDesign/testutil/fakedynamo"
+)
+
+func newClient(t *testing.T, c *Chaos, maxAttempts int) (*dynamodb.Client, string) {
+	t.Helper()
+	fake := fakedynamo.New()
+	if err := schema.CreateTable(context.Background(), fake.Client(), "T"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	return fake.Client(c.Option(), FastRetries(maxAttempts)), "T"
+}
+
+func key(i int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "P"},
+		"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("S#%02d", i)},
+	}
+}
+
+func TestChaos_Throttling(t *testing.T) {
+	ctx := context.Background()
+
+	// Every attempt throttled: the SDK retries, then gives up with the error
+	c := New(Config{ThrottleRate: 1})
+	client, table := newClient(t, c, 3)
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: key(1)})
+	var throttled *types.ProvisionedThroughputExceededException
+	if !errors.As(err, &throttled) {
+		t.Fatalf("Expected a throttling error, got %v", err)
+	}
+	if got := c.Stats().Throttles; got != 3 {
+		t.Errorf("Throttles = %d, want one per attempt, 3", got)
+	}
+
+	// Some attempts throttled: the retries get every call through
+	c = New(Config{ThrottleRate: 0.3, Seed: 1})
+	client, table = newClient(t, c, 10)
+	for i := range 20 {
+		if _, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: key(i)}); err != nil {
+			t.Fatalf("Put %d failed despite retries: %v", i, err)
+		}
+	}
+	if c.Stats().Throttles == 0 {
+		t.Error("Expected some throttling")
+	}
+}
+
+func TestChaos_Timeouts(t *testing.T) {
+	c := New(Config{TimeoutRate: 1, Hang: time.Millisecond})
+	client, table := newClient(t, c, 2)
+	_, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{TableName: aws.String(table), Key: key(1)})
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if got := c.Stats().Timeouts; got != 2 {
+		t.Errorf("Timeouts = %d, want one per attempt, 2", got)
+	}
+
+	// A deadline ends the hang early
+	c = New(Config{TimeoutRate: 1})
+	client, table = newClient(t, c, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(table), Key: key(1)})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to end the call, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Call took %v despite its deadline", elapsed)
+	}
+}
+
+func TestChaos_Unprocessed(t *testing.T) {
+	ctx := context.Background()
+	c := New(Config{UnprocessedRate: 0.5, Seed: 2})
+	client, table := newClient(t, c, 1)
+
+	requests := make([]types.WriteRequest, 20)
+	for i := range requests {
+		requests[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: key(i)}}
+	}
+	out, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]types.WriteRequest{table: requests},
+	})
+	if err != nil {
+		t.Fatalf("Failed to batch write: %v", err)
+	}
+	unprocessed := len(out.UnprocessedItems[table])
+	if unprocessed == 0 || unprocessed == len(requests) || unprocessed != c.Stats().Unprocessed {
+		t.Fatalf("Unprocessed %d of %d, chaos held back %d", unprocessed, len(requests), c.Stats().Unprocessed)
+	}
+
+	// Only the processed items were written
+	keys := make([]map[string]types.AttributeValue, len(requests))
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	scan, err := client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(table)})
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if int(scan.Count) != len(requests)-unprocessed {
+		t.Errorf("Stored %d items, want %d", scan.Count, len(requests)-unprocessed)
+	}
+
+	got, err := client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]types.KeysAndAttributes{table: {Keys: keys, ConsistentRead: aws.Bool(true)}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to batch get: %v", err)
+	}
+	held := got.UnprocessedKeys[table]
+	if len(held.Keys) == 0 || !aws.ToBool(held.ConsistentRead) {
+		t.Errorf("Unprocessed keys %+v, want some, keeping their read options", held)
+	}
+	if len(got.Responses[table])+len(held.Keys) > len(requests) {
+		t.Errorf("Read %d and held back %d of %d keys", len(got.Responses[table]), len(held.Keys), len(requests))
+	}
+}
+
+func TestChaos_Conflicts(t *testing.T) {
+	ctx := context.Background()
+	c := New(Config{ConflictRate: 1, Operations: []string{"TransactWriteItems"}})
+	client, table := newClient(t, c, 1)
+
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{TableName: aws.String(table), Item: key(1)}},
+			{Put: &types.Put{TableName: aws.String(table), Item: key(2)}},
+		},
+	})
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) || len(cancelled.CancellationReasons) != 2 ||
+		aws.ToString(cancelled.CancellationReasons[0].Code) != "TransactionConflict" {
+		t.Fatalf("Expected a transaction conflict, got %v", err)
+	}
+
+	// Other operations are left alone
+	if _, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: key(3)}); err != nil {
+		t.Fatalf("Failed to put outside the chaos: %v", err)
+	}
+	scan, err := client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(table)})
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if scan.Count != 1 {
+		t.Errorf("Stored %d items, want only the put outside the transaction", scan.Count)
+	}
+}
@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/schema"
+)
+
+// ContractTargetEnv names the variable choosing where contract tests run:
+// ContractTargetAWS for a temporary table in real DynamoDB, anything else
+// for the emulator the other tests use
+const (
+	ContractTargetEnv = "CONTRACT_TARGET"
+	ContractTargetAWS = "aws"
+)
+
+// contractTableTimeout bounds creating a real table and its indexes
+const contractTableTimeout = 5 * time.Minute
+
+// OnAWS reports whether contract tests run against real DynamoDB
+func OnAWS() bool {
+	return os.Getenv(ContractTargetEnv) == ContractTargetAWS
+}
+
+// SetupContractTable returns a client and a new table for contract tests,
+// and deletes the table when t ends. Against AWS the client comes from
+// the default config chain and the region in the config, and the table,
+// named contract_test_<uuid>, is billed per request; a run that is killed
+// before cleanup leaves it behind.
+func SetupContractTable(t testing.TB) (*dynamodb.Client, string) {
+	t.Helper()
+	if !OnAWS() {
+		client := CreateTestClient(t)
+		tableName := SetupTestTable(t, client)
+		t.Cleanup(func() { CleanupTestTable(t, client, tableName) })
+		return client, tableName
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("unable to load config: %v", err)
+	}
+	cfg.Local = false
+	client, err := dynamoclient.New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("unable to load SDK config: %v", err)
+	}
+
+	tableName := fmt.Sprintf("contract_test_%s", uuid.New().String())
+	// Registered first, so a table that never became active is deleted too
+	t.Cleanup(func() {
+		_, err := client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{
+			TableName: aws.String(tableName),
+		})
+		var notFound *types.ResourceNotFoundException
+		if err != nil && !errors.As(err, &notFound) {
+			t.Errorf("unable to delete contract table %s, delete it by hand: %v", tableName, err)
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), contractTableTimeout)
+	defer cancel()
+	// Unlike CreateTable, EnsureTable waits for the table to be active
+	if err := schema.EnsureTable(ctx, client, tableName); err != nil {
+		t.Fatalf("unable to create contract table %s: %v", tableName, err)
+	}
+	return client, tableName
+}
@@ -0,0 +1,129 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"LearnSingleTableDesign/config"
+)
+
+// emulatorStartTimeout bounds how long a freshly started emulator gets to
+// start listening
+const emulatorStartTimeout = 60 * time.Second
+
+// composeServices are the docker-compose.yml services of each emulator, and
+// the profile that enables them
+var composeServices = map[string]struct{ service, profile string }{
+	config.EmulatorDynamoDBLocal: {service: "dynamodb-local"},
+	config.EmulatorLocalStack:    {service: "localstack", profile: "localstack"},
+}
+
+var (
+	emulatorOnce sync.Once
+	emulatorErr  error
+)
+
+// StartEmulator makes sure the emulator cfg points at is listening. When
+// nothing answers on the endpoint, it starts the emulator's service from
+// the repository's docker-compose.yml and waits for it. It only does the
+// work once per test binary.
+func StartEmulator(cfg config.Config) error {
+	emulatorOnce.Do(func() {
+		emulatorErr = startEmulator(cfg)
+	})
+	return emulatorErr
+}
+
+func startEmulator(cfg config.Config) error {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %w", cfg.Endpoint, err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "80")
+	}
+	if listening(addr) {
+		return nil
+	}
+
+	emulator := cfg.LocalEmulator()
+	compose, ok := composeServices[emulator]
+	if !ok {
+		return fmt.Errorf("unknown emulator %q", emulator)
+	}
+	file, err := findComposeFile()
+	if err != nil {
+		return err
+	}
+	args := []string{"-f", file}
+	if compose.profile != "" {
+		args = append(args, "--profile", compose.profile)
+	}
+	args = append(args, "up", "-d", compose.service)
+
+	cmd, err := composeCommand(args)
+	if err != nil {
+		return fmt.Errorf("nothing is listening on %s: %w", addr, err)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to start %s: %w\n%s", compose.service, err, out)
+	}
+
+	deadline := time.Now().Add(emulatorStartTimeout)
+	for !listening(addr) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s isn't listening on %s after %v", compose.service, addr, emulatorStartTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return nil
+}
+
+func listening(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// composeCommand prefers the docker compose plugin over the standalone
+// docker-compose the Makefile uses
+func composeCommand(args []string) (*exec.Cmd, error) {
+	if _, err := exec.LookPath("docker"); err == nil {
+		return exec.Command("docker", append([]string{"compose"}, args...)...), nil
+	}
+	if _, err := exec.LookPath("docker-compose"); err == nil {
+		return exec.Command("docker-compose", args...), nil
+	}
+	return nil, errors.New("docker isn't installed to start an emulator")
+}
+
+// findComposeFile looks for docker-compose.yml from the working directory
+// up, as tests run from their package's directory
+func findComposeFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+	for {
+		path := filepath.Join(dir, "docker-compose.yml")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("no docker-compose.yml found")
+		}
+		dir = parent
+	}
+}
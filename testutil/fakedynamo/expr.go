@@ -0,0 +1,1001 @@
+package fakedynamo
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// token kinds of the expression language
+const (
+	tokEOF = iota
+	tokIdent
+	tokName  // #name placeholder
+	tokValue // :value placeholder
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '#' || c == ':' || isIdentRune(c):
+			start := i
+			i++
+			for i < len(expr) && isIdentRune(rune(expr[i])) {
+				i++
+			}
+			kind := tokIdent
+			if c == '#' {
+				kind = tokName
+			} else if c == ':' {
+				kind = tokValue
+			}
+			text := expr[start:i]
+			if kind != tokIdent && len(text) == 1 {
+				return nil, fmt.Errorf("invalid token %q at %d", text, start)
+			}
+			if kind == tokIdent && unicode.IsDigit(c) {
+				kind = tokNumber
+			}
+			tokens = append(tokens, token{kind: kind, text: text})
+		default:
+			for _, op := range []string{"<>", "<=", ">=", "=", "<", ">", "(", ")", ",", ".", "[", "]", "+", "-"} {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, token{kind: tokPunct, text: op})
+					i += len(op)
+					goto next
+				}
+			}
+			return nil, fmt.Errorf("invalid character %q at %d", c, i)
+		next:
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c))
+}
+
+// env resolves the placeholders of a request's expressions and records
+// which were used, since DynamoDB rejects unused ones
+type env struct {
+	names      map[string]string
+	values     map[string]*attr
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newEnv(names map[string]string, values map[string]*attr) *env {
+	return &env{names: names, values: values, usedNames: map[string]bool{}, usedValues: map[string]bool{}}
+}
+
+// checkUnused fails like DynamoDB when a placeholder went unused
+func (e *env) checkUnused() error {
+	var unused []string
+	for name := range e.names {
+		if !e.usedNames[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return validationf("Value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	for value := range e.values {
+		if !e.usedValues[value] {
+			unused = append(unused, value)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return validationf("Value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+// pathElem is a map key, or a list index when key is empty
+type pathElem struct {
+	key   string
+	index int
+}
+
+type path []pathElem
+
+func (p path) String() string {
+	var b strings.Builder
+	for i, elem := range p {
+		if elem.key == "" {
+			fmt.Fprintf(&b, "[%d]", elem.index)
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(elem.key)
+	}
+	return b.String()
+}
+
+// operand is something that evaluates to a value, or nil when it refers
+// to a missing attribute
+type operand interface {
+	eval(it item) (*attr, error)
+}
+
+type pathOperand struct{ path path }
+
+type valueOperand struct{ value *attr }
+
+type sizeOperand struct{ path path }
+
+type ifNotExistsOperand struct {
+	path     path
+	fallback operand
+}
+
+type listAppendOperand struct{ a, b operand }
+
+type arithOperand struct {
+	op   string
+	a, b operand
+}
+
+func (o pathOperand) eval(it item) (*attr, error) { return resolve(it, o.path), nil }
+
+func (o valueOperand) eval(it item) (*attr, error) { return o.value, nil }
+
+func (o sizeOperand) eval(it item) (*attr, error) {
+	v := resolve(it, o.path)
+	if v == nil {
+		return nil, nil
+	}
+	var n int
+	switch v.kind {
+	case "S":
+		n = len(v.s)
+	case "B":
+		n = len(v.b)
+	case "M":
+		n = len(v.m)
+	case "L":
+		n = len(v.l)
+	case "SS", "NS":
+		n = len(v.ss)
+	case "BS":
+		n = len(v.bs)
+	default:
+		return nil, nil
+	}
+	return numberAttr(big.NewRat(int64(n), 1)), nil
+}
+
+func (o ifNotExistsOperand) eval(it item) (*attr, error) {
+	if v := resolve(it, o.path); v != nil {
+		return v, nil
+	}
+	return o.fallback.eval(it)
+}
+
+func (o listAppendOperand) eval(it item) (*attr, error) {
+	a, err := o.a.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	b, err := o.b.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || b == nil {
+		return nil, validationf("The provided expression refers to an attribute that does not exist in the item")
+	}
+	if a.kind != "L" || b.kind != "L" {
+		return nil, validationf("An operand in the update expression has an incorrect data type")
+	}
+	return &attr{kind: "L", l: append(append([]*attr{}, a.l...), b.l...)}, nil
+}
+
+func (o arithOperand) eval(it item) (*attr, error) {
+	a, err := o.a.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	b, err := o.b.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || b == nil {
+		return nil, validationf("The provided expression refers to an attribute that does not exist in the item")
+	}
+	if a.kind != "N" || b.kind != "N" {
+		return nil, validationf("An operand in the update expression has an incorrect data type")
+	}
+	x, _ := parseNumber(a.s)
+	y, _ := parseNumber(b.s)
+	if o.op == "-" {
+		return numberAttr(new(big.Rat).Sub(x, y)), nil
+	}
+	return numberAttr(new(big.Rat).Add(x, y)), nil
+}
+
+// cond is a parsed condition, filter or key condition
+type cond interface {
+	eval(it item) bool
+}
+
+type andCond struct{ a, b cond }
+
+type orCond struct{ a, b cond }
+
+type notCond struct{ c cond }
+
+type compareCond struct {
+	op   string
+	a, b operand
+}
+
+type betweenCond struct{ x, lo, hi operand }
+
+type inCond struct {
+	x    operand
+	list []operand
+}
+
+type funcCond struct {
+	name string
+	path path
+	arg  operand
+}
+
+func (c andCond) eval(it item) bool { return c.a.eval(it) && c.b.eval(it) }
+
+func (c orCond) eval(it item) bool { return c.a.eval(it) || c.b.eval(it) }
+
+func (c notCond) eval(it item) bool { return !c.c.eval(it) }
+
+func (c compareCond) eval(it item) bool {
+	a, _ := c.a.eval(it)
+	b, _ := c.b.eval(it)
+	switch c.op {
+	case "=":
+		return equal(a, b)
+	case "<>":
+		return !equal(a, b)
+	}
+	if !comparable(a, b) {
+		return false
+	}
+	n := compare(a, b)
+	switch c.op {
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	default:
+		return n >= 0
+	}
+}
+
+func (c betweenCond) eval(it item) bool {
+	x, _ := c.x.eval(it)
+	lo, _ := c.lo.eval(it)
+	hi, _ := c.hi.eval(it)
+	return comparable(x, lo) && comparable(x, hi) && compare(lo, x) <= 0 && compare(x, hi) <= 0
+}
+
+func (c inCond) eval(it item) bool {
+	x, _ := c.x.eval(it)
+	for _, o := range c.list {
+		if v, _ := o.eval(it); equal(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c funcCond) eval(it item) bool {
+	v := resolve(it, c.path)
+	switch c.name {
+	case "attribute_exists":
+		return v != nil
+	case "attribute_not_exists":
+		return v == nil
+	}
+	arg, _ := c.arg.eval(it)
+	if v == nil || arg == nil {
+		return false
+	}
+	switch c.name {
+	case "attribute_type":
+		return arg.kind == "S" && v.kind == arg.s
+	case "begins_with":
+		if v.kind == "S" && arg.kind == "S" {
+			return strings.HasPrefix(v.s, arg.s)
+		}
+		return v.kind == "B" && arg.kind == "B" && strings.HasPrefix(string(v.b), string(arg.b))
+	case "contains":
+		switch v.kind {
+		case "S":
+			return arg.kind == "S" && strings.Contains(v.s, arg.s)
+		case "SS", "NS", "BS":
+			if arg.kind != v.kind[1:] {
+				return false
+			}
+			return subset(&attr{kind: v.kind, ss: []string{arg.s}, bs: [][]byte{arg.b}}, v)
+		case "L":
+			for _, elem := range v.l {
+				if equal(elem, arg) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// resolve follows a document path through an item, returning nil when
+// any step is missing
+func resolve(it item, p path) *attr {
+	if len(p) == 0 || p[0].key == "" {
+		return nil
+	}
+	v := it[p[0].key]
+	for _, elem := range p[1:] {
+		if v == nil {
+			return nil
+		}
+		if elem.key != "" {
+			if v.kind != "M" {
+				return nil
+			}
+			v = v.m[elem.key]
+			continue
+		}
+		if v.kind != "L" || elem.index >= len(v.l) {
+			return nil
+		}
+		v = v.l[elem.index]
+	}
+	return v
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	env    *env
+}
+
+func newParser(expr string, e *env) (*parser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, validationf("Invalid expression: %v", err)
+	}
+	return &parser{tokens: tokens, env: e}, nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is the keyword, ignoring case
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, word)
+}
+
+func (p *parser) punct(text string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.punct(text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	near := p.peek().text
+	if p.peek().kind == tokEOF {
+		near = "<EOF>"
+	}
+	return validationf("Invalid expression: %s near %q", fmt.Sprintf(format, args...), near)
+}
+
+func (p *parser) end() error {
+	if p.peek().kind != tokEOF {
+		return p.errorf("unexpected token")
+	}
+	return nil
+}
+
+func (p *parser) path() (path, error) {
+	var out path
+	name, err := p.pathName()
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, pathElem{key: name})
+	for {
+		switch {
+		case p.punct("."):
+			p.next()
+			name, err := p.pathName()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, pathElem{key: name})
+		case p.punct("["):
+			p.next()
+			t := p.next()
+			index, err := strconv.Atoi(t.text)
+			if t.kind != tokNumber || err != nil {
+				return nil, p.errorf("expected a list index")
+			}
+			out = append(out, pathElem{index: index})
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return out, nil
+		}
+	}
+}
+
+func (p *parser) pathName() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent:
+		return t.text, nil
+	case tokName:
+		name, ok := p.env.names[t.text]
+		if !ok {
+			return "", validationf("An expression attribute name used in the document path is not defined; attribute name: %s", t.text)
+		}
+		p.env.usedNames[t.text] = true
+		return name, nil
+	}
+	p.pos--
+	return "", p.errorf("expected an attribute name")
+}
+
+func (p *parser) value() (operand, error) {
+	t := p.next()
+	v, ok := p.env.values[t.text]
+	if !ok {
+		return nil, validationf("An expression attribute value used in expression is not defined; attribute value: %s", t.text)
+	}
+	p.env.usedValues[t.text] = true
+	return valueOperand{value: v}, nil
+}
+
+// operand parses a condition operand: a path, a value or size(path)
+func (p *parser) operand() (operand, error) {
+	switch t := p.peek(); {
+	case t.kind == tokValue:
+		return p.value()
+	case p.keyword("size") && p.tokens[p.pos+1].text == "(":
+		p.next()
+		p.next()
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		return sizeOperand{path: path}, p.expect(")")
+	default:
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		return pathOperand{path: path}, nil
+	}
+}
+
+// condition parses OR, AND and NOT with their usual precedence
+func (p *parser) condition() (cond, error) {
+	left, err := p.andCondition()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		p.next()
+		right, err := p.andCondition()
+		if err != nil {
+			return nil, err
+		}
+		left = orCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) andCondition() (cond, error) {
+	left, err := p.notCondition()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		p.next()
+		right, err := p.notCondition()
+		if err != nil {
+			return nil, err
+		}
+		left = andCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) notCondition() (cond, error) {
+	if p.keyword("NOT") {
+		p.next()
+		c, err := p.notCondition()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{c}, nil
+	}
+	return p.primaryCondition()
+}
+
+var conditionFuncs = map[string]bool{
+	"attribute_exists":     true,
+	"attribute_not_exists": true,
+	"attribute_type":       true,
+	"begins_with":          true,
+	"contains":             true,
+}
+
+func (p *parser) primaryCondition() (cond, error) {
+	if p.punct("(") {
+		p.next()
+		c, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expect(")")
+	}
+	if t := p.peek(); t.kind == tokIdent && conditionFuncs[t.text] && p.tokens[p.pos+1].text == "(" {
+		p.next()
+		p.next()
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		c := funcCond{name: t.text, path: path}
+		if t.text != "attribute_exists" && t.text != "attribute_not_exists" {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			if c.arg, err = p.operand(); err != nil {
+				return nil, err
+			}
+		}
+		return c, p.expect(")")
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	switch t := p.peek(); {
+	case t.kind == tokPunct && (t.text == "=" || t.text == "<>" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return compareCond{op: t.text, a: left, b: right}, nil
+	case p.keyword("BETWEEN"):
+		p.next()
+		lo, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, p.errorf("expected AND")
+		}
+		p.next()
+		hi, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return betweenCond{x: left, lo: lo, hi: hi}, nil
+	case p.keyword("IN"):
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		c := inCond{x: left}
+		for {
+			o, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			c.list = append(c.list, o)
+			if !p.punct(",") {
+				break
+			}
+			p.next()
+		}
+		return c, p.expect(")")
+	}
+	return nil, p.errorf("expected a comparison")
+}
+
+// parseCondition parses a condition or filter expression
+func parseCondition(expr string, e *env) (cond, error) {
+	p, err := newParser(expr, e)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.condition()
+	if err != nil {
+		return nil, err
+	}
+	return c, p.end()
+}
+
+// parseProjection parses a projection expression into its paths
+func parseProjection(expr string, e *env) ([]path, error) {
+	p, err := newParser(expr, e)
+	if err != nil {
+		return nil, err
+	}
+	var paths []path
+	for {
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+		if !p.punct(",") {
+			break
+		}
+		p.next()
+	}
+	return paths, p.end()
+}
+
+// update is a parsed update expression
+type update struct {
+	sets    []setAction
+	removes []path
+	adds    []setAction
+	deletes []setAction
+}
+
+type setAction struct {
+	path  path
+	value operand
+}
+
+// paths returns every path the update writes
+func (u *update) paths() []path {
+	var paths []path
+	for _, actions := range [][]setAction{u.sets, u.adds, u.deletes} {
+		for _, a := range actions {
+			paths = append(paths, a.path)
+		}
+	}
+	return append(paths, u.removes...)
+}
+
+func parseUpdate(expr string, e *env) (*update, error) {
+	p, err := newParser(expr, e)
+	if err != nil {
+		return nil, err
+	}
+	u := &update{}
+	seen := map[string]bool{}
+	for p.peek().kind != tokEOF {
+		clause := strings.ToUpper(p.next().text)
+		if clause != "SET" && clause != "REMOVE" && clause != "ADD" && clause != "DELETE" {
+			p.pos--
+			return nil, p.errorf("expected SET, REMOVE, ADD or DELETE")
+		}
+		if seen[clause] {
+			return nil, validationf("Invalid UpdateExpression: The \"%s\" section can only be used once in an update expression", clause)
+		}
+		seen[clause] = true
+		for {
+			target, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			switch clause {
+			case "SET":
+				if err := p.expect("="); err != nil {
+					return nil, err
+				}
+				value, err := p.setValue()
+				if err != nil {
+					return nil, err
+				}
+				u.sets = append(u.sets, setAction{target, value})
+			case "REMOVE":
+				u.removes = append(u.removes, target)
+			case "ADD", "DELETE":
+				value, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				if clause == "ADD" {
+					u.adds = append(u.adds, setAction{target, value})
+				} else {
+					u.deletes = append(u.deletes, setAction{target, value})
+				}
+			}
+			if !p.punct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if len(seen) == 0 {
+		return nil, validationf("Invalid UpdateExpression: The expression can not be empty")
+	}
+
+	// DynamoDB refuses two actions on overlapping paths
+	paths := u.paths()
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			if overlaps(paths[i], paths[j]) {
+				return nil, validationf("Invalid UpdateExpression: Two document paths overlap with each other; must remove or rewrite one of these paths; path one: [%s], path two: [%s]", paths[i], paths[j])
+			}
+		}
+	}
+	return u, nil
+}
+
+func overlaps(a, b path) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setValue parses the right-hand side of a SET action
+func (p *parser) setValue() (operand, error) {
+	left, err := p.setTerm()
+	if err != nil {
+		return nil, err
+	}
+	if p.punct("+") || p.punct("-") {
+		op := p.next().text
+		right, err := p.setTerm()
+		if err != nil {
+			return nil, err
+		}
+		return arithOperand{op: op, a: left, b: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) setTerm() (operand, error) {
+	t := p.peek()
+	if t.kind == tokValue {
+		return p.value()
+	}
+	if t.kind == tokIdent && p.tokens[p.pos+1].text == "(" {
+		switch t.text {
+		case "if_not_exists":
+			p.next()
+			p.next()
+			path, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			fallback, err := p.setTerm()
+			if err != nil {
+				return nil, err
+			}
+			return ifNotExistsOperand{path: path, fallback: fallback}, p.expect(")")
+		case "list_append":
+			p.next()
+			p.next()
+			a, err := p.setTerm()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			b, err := p.setTerm()
+			if err != nil {
+				return nil, err
+			}
+			return listAppendOperand{a: a, b: b}, p.expect(")")
+		}
+		return nil, p.errorf("unknown function %s", t.text)
+	}
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	return pathOperand{path: path}, nil
+}
+
+// apply runs the update against a copy of it. Values are read from the item
+// as it was before the update, as DynamoDB does.
+func (u *update) apply(it item) (item, error) {
+	values := make([]*attr, len(u.sets))
+	for i, set := range u.sets {
+		v, err := set.value.eval(it)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, validationf("The provided expression refers to an attribute that does not exist in the item")
+		}
+		values[i] = v
+	}
+
+	out := it.clone()
+	if out == nil {
+		out = item{}
+	}
+	for i, set := range u.sets {
+		if err := setPath(out, set.path, values[i].clone()); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range u.removes {
+		removePath(out, p)
+	}
+	for _, add := range u.adds {
+		v, _ := add.value.eval(out)
+		current := resolve(out, add.path)
+		var next *attr
+		switch {
+		case v.kind != "N" && v.kind != "SS" && v.kind != "NS" && v.kind != "BS":
+			return nil, validationf("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: ADD, operand type: %s", v.kind)
+		case current == nil:
+			next = v.clone()
+		case current.kind != v.kind:
+			return nil, validationf("An operand in the update expression has an incorrect data type")
+		case v.kind == "N":
+			x, _ := parseNumber(current.s)
+			y, _ := parseNumber(v.s)
+			next = numberAttr(new(big.Rat).Add(x, y))
+		default:
+			members := current.setKeys()
+			for k := range v.setKeys() {
+				members[k] = true
+			}
+			next = setOf(v.kind, members)
+		}
+		if err := setPath(out, add.path, next); err != nil {
+			return nil, err
+		}
+	}
+	for _, del := range u.deletes {
+		v, _ := del.value.eval(out)
+		current := resolve(out, del.path)
+		if current == nil {
+			continue
+		}
+		if current.kind != v.kind || (v.kind != "SS" && v.kind != "NS" && v.kind != "BS") {
+			return nil, validationf("An operand in the update expression has an incorrect data type")
+		}
+		members := current.setKeys()
+		for k := range v.setKeys() {
+			delete(members, k)
+		}
+		if len(members) == 0 {
+			removePath(out, del.path)
+			continue
+		}
+		if err := setPath(out, del.path, setOf(v.kind, members)); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// setPath writes v at p, whose parent must already exist
+func setPath(it item, p path, v *attr) error {
+	if len(p) == 1 {
+		it[p[0].key] = v
+		return nil
+	}
+	parent := resolve(it, p[:len(p)-1])
+	last := p[len(p)-1]
+	switch {
+	case parent != nil && last.key != "" && parent.kind == "M":
+		parent.m[last.key] = v
+		return nil
+	case parent != nil && last.key == "" && parent.kind == "L":
+		if last.index < len(parent.l) {
+			parent.l[last.index] = v
+		} else {
+			parent.l = append(parent.l, v)
+		}
+		return nil
+	}
+	return validationf("The document path provided in the update expression is invalid for update")
+}
+
+func removePath(it item, p path) {
+	if len(p) == 1 {
+		delete(it, p[0].key)
+		return
+	}
+	parent := resolve(it, p[:len(p)-1])
+	last := p[len(p)-1]
+	switch {
+	case parent == nil:
+	case last.key != "" && parent.kind == "M":
+		delete(parent.m, last.key)
+	case last.key == "" && parent.kind == "L" && last.index < len(parent.l):
+		parent.l = append(parent.l[:last.index], parent.l[last.index+1:]...)
+	}
+}
+
+// project keeps only the paths of it, as a projection expression does
+func project(it item, paths []path) item {
+	out := item{}
+	for _, p := range paths {
+		v := resolve(it, p)
+		if v == nil {
+			continue
+		}
+		// Build the path in out, collapsing list indexes into appends
+		var parent *attr
+		for i, elem := range p {
+			last := i == len(p)-1
+			var child *attr
+			if last {
+				child = v.clone()
+			} else if p[i+1].key == "" {
+				child = &attr{kind: "L"}
+			} else {
+				child = &attr{kind: "M", m: map[string]*attr{}}
+			}
+			switch {
+			case parent == nil:
+				if existing, ok := out[elem.key]; ok && !last {
+					child = existing
+				} else {
+					out[elem.key] = child
+				}
+			case elem.key != "":
+				if existing, ok := parent.m[elem.key]; ok && !last {
+					child = existing
+				} else {
+					parent.m[elem.key] = child
+				}
+			default:
+				parent.l = append(parent.l, child)
+			}
+			parent = child
+		}
+	}
+	return out
+}
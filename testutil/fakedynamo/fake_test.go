@@ -0,0 +1,288 @@
+package fakedynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func s(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+
+func n(v string) types.AttributeValue { return &types.AttributeValueMemberN{Value: v} }
+
+// newTable creates a table keyed by PK and SK with a GSI on GSI1PK/GSI1SK
+func newTable(t *testing.T) (*dynamodb.Client, string) {
+	t.Helper()
+	client := New().Client()
+	_, err := client.CreateTable(context.Background(), &dynamodb.CreateTableInput{
+		TableName:   aws.String("T"),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("PK"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("SK"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("GSI1PK"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("GSI1SK"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("SK"), KeyType: types.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String("GSI1"),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String("GSI1PK"), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String("GSI1SK"), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	return client, "T"
+}
+
+func TestFake_QueryPaging(t *testing.T) {
+	client, table := newTable(t)
+	ctx := context.Background()
+	for i := range 5 {
+		_, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: map[string]types.AttributeValue{
+			"PK": s("USER#1"), "SK": s(fmt.Sprintf("ORDER#%d", i)),
+			"GSI1PK": s("ORDERS"), "GSI1SK": n(fmt.Sprint(10 - i)), "total": n(fmt.Sprint(i)),
+		}})
+		if err != nil {
+			t.Fatalf("Failed to put item: %v", err)
+		}
+	}
+
+	// Test begins_with, descending order and a filter, two items at a time
+	var got []string
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(table),
+		KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :sk)"),
+		FilterExpression:          aws.String("#total <> :one"),
+		ExpressionAttributeNames:  map[string]string{"#total": "total"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": s("USER#1"), ":sk": s("ORDER#"), ":one": n("1")},
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(2),
+	}
+	for {
+		out, err := client.Query(ctx, input)
+		if err != nil {
+			t.Fatalf("Failed to query: %v", err)
+		}
+		for _, it := range out.Items {
+			got = append(got, it["SK"].(*types.AttributeValueMemberS).Value)
+		}
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	if fmt.Sprint(got) != "[ORDER#4 ORDER#3 ORDER#2 ORDER#0]" {
+		t.Errorf("Query() = %v, want every order but ORDER#1, newest first", got)
+	}
+
+	// Test the keys-only index sorts numbers by value and leaves out the rest
+	out, err := client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(table),
+		IndexName:                 aws.String("GSI1"),
+		KeyConditionExpression:    aws.String("GSI1PK = :pk AND GSI1SK BETWEEN :lo AND :hi"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": s("ORDERS"), ":lo": n("7"), ":hi": n("10")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to query index: %v", err)
+	}
+	if out.Count != 4 || out.Items[0]["SK"].(*types.AttributeValueMemberS).Value != "ORDER#3" {
+		t.Errorf("Index query = %d items starting %v, want 4 starting ORDER#3", out.Count, out.Items[0]["SK"])
+	}
+	if _, ok := out.Items[0]["total"]; ok {
+		t.Error("Keys-only index returned a non-key attribute")
+	}
+}
+
+func TestFake_Conditions(t *testing.T) {
+	client, table := newTable(t)
+	ctx := context.Background()
+	item := map[string]types.AttributeValue{"PK": s("A"), "SK": s("A"), "version": n("1")}
+	put := &dynamodb.PutItemInput{
+		TableName:                           aws.String(table),
+		Item:                                item,
+		ConditionExpression:                 aws.String("attribute_not_exists(PK)"),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if _, err := client.PutItem(ctx, put); err != nil {
+		t.Fatalf("Failed to put item: %v", err)
+	}
+
+	// Test a failed condition returns the item it failed against
+	_, err := client.PutItem(ctx, put)
+	var failed *types.ConditionalCheckFailedException
+	if !errors.As(err, &failed) {
+		t.Fatalf("Expected ConditionalCheckFailedException, got %v", err)
+	}
+	if failed.Item["version"].(*types.AttributeValueMemberN).Value != "1" {
+		t.Errorf("Failure item = %v, want the stored item", failed.Item)
+	}
+
+	// Test an unused placeholder is rejected, as DynamoDB does
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(table),
+		Item:                      item,
+		ExpressionAttributeValues: map[string]types.AttributeValue{":unused": n("1")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unused in expressions") {
+		t.Errorf("Expected an unused value error, got %v", err)
+	}
+
+	// Test a transaction is cancelled as a whole, with a reason per item
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: []types.TransactWriteItem{
+		{Put: &types.Put{TableName: aws.String(table), Item: map[string]types.AttributeValue{"PK": s("B"), "SK": s("B")}}},
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:                 aws.String(table),
+			Key:                       map[string]types.AttributeValue{"PK": s("A"), "SK": s("A")},
+			ConditionExpression:       aws.String("version = :v"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":v": n("2")},
+		}},
+	}})
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("Expected TransactionCanceledException, got %v", err)
+	}
+	if len(cancelled.CancellationReasons) != 2 || aws.ToString(cancelled.CancellationReasons[1].Code) != "ConditionalCheckFailed" {
+		t.Errorf("CancellationReasons = %+v, want None then ConditionalCheckFailed", cancelled.CancellationReasons)
+	}
+	got, err := client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(table), Key: map[string]types.AttributeValue{"PK": s("B"), "SK": s("B")}})
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if got.Item != nil {
+		t.Error("Cancelled transaction wrote its put")
+	}
+}
+
+func TestFake_UpdateItem(t *testing.T) {
+	client, table := newTable(t)
+	ctx := context.Background()
+	key := map[string]types.AttributeValue{"PK": s("A"), "SK": s("A")}
+
+	update := func(expr string, values map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+		out, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(table),
+			Key:                       key,
+			UpdateExpression:          aws.String(expr),
+			ExpressionAttributeNames:  map[string]string{"#data": "data"},
+			ExpressionAttributeValues: values,
+			ReturnValues:              types.ReturnValueAllNew,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.Attributes, nil
+	}
+
+	// Test an update creates the item, and values are read before it
+	attrs, err := update("ADD hits :one SET #data = if_not_exists(#data, :data), tags = list_append(:tags, :tags)",
+		map[string]types.AttributeValue{
+			":one":  n("1"),
+			":data": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"count": n("1.5")}},
+			":tags": &types.AttributeValueMemberL{Value: []types.AttributeValue{s("a")}},
+		})
+	if err != nil {
+		t.Fatalf("Failed to update item: %v", err)
+	}
+	if attrs["hits"].(*types.AttributeValueMemberN).Value != "1" || len(attrs["tags"].(*types.AttributeValueMemberL).Value) != 2 {
+		t.Errorf("Attributes = %v, want hits 1 and two tags", attrs)
+	}
+
+	// Test undefined names are rejected
+	if _, err := update("SET #data.#count = :one", map[string]types.AttributeValue{":one": n("1")}); err == nil {
+		t.Error("Expected error for the undefined #count name, got nil")
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(table),
+		Key:                       key,
+		UpdateExpression:          aws.String("SET #data.#count = #data.#count + :half REMOVE tags"),
+		ExpressionAttributeNames:  map[string]string{"#data": "data", "#count": "count"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":half": n("0.5")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update item: %v", err)
+	}
+	got, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String(table),
+		Key:                      key,
+		ProjectionExpression:     aws.String("#data.#count, tags"),
+		ExpressionAttributeNames: map[string]string{"#data": "data", "#count": "count"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	data := got.Item["data"].(*types.AttributeValueMemberM).Value
+	if data["count"].(*types.AttributeValueMemberN).Value != "2" || got.Item["tags"] != nil {
+		t.Errorf("Item = %v, want data.count 2 and no tags", got.Item)
+	}
+
+	// Test key attributes can't be updated
+	if _, err := update("SET PK = :one", map[string]types.AttributeValue{":one": n("1")}); err == nil {
+		t.Error("Expected error updating a key attribute, got nil")
+	}
+}
+
+func TestFake_Limits(t *testing.T) {
+	client, table := newTable(t)
+	ctx := context.Background()
+
+	// Test the 400 KB item limit
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: map[string]types.AttributeValue{
+		"PK": s("A"), "SK": s("A"), "blob": s(strings.Repeat("x", 400*1024)),
+	}})
+	if err == nil || !strings.Contains(err.Error(), "maximum allowed size") {
+		t.Errorf("Expected an item size error, got %v", err)
+	}
+
+	// Test a parallel scan's segments cover every item exactly once
+	var writes []types.WriteRequest
+	for i := range 20 {
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: map[string]types.AttributeValue{
+			"PK": s(fmt.Sprint(i)), "SK": s("A"),
+		}}})
+	}
+	for start := 0; start < len(writes); start += 10 {
+		if _, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{table: writes[start : start+10]},
+		}); err != nil {
+			t.Fatalf("Failed to batch write: %v", err)
+		}
+	}
+	seen := map[string]int{}
+	for segment := range 3 {
+		paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+			TableName: aws.String(table), Segment: aws.Int32(int32(segment)), TotalSegments: aws.Int32(3), Limit: aws.Int32(4),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				t.Fatalf("Failed to scan: %v", err)
+			}
+			for _, it := range page.Items {
+				seen[it["PK"].(*types.AttributeValueMemberS).Value]++
+			}
+		}
+	}
+	if len(seen) != 20 {
+		t.Errorf("Scan saw %d items, want 20", len(seen))
+	}
+	for pk, count := range seen {
+		if count != 1 {
+			t.Errorf("Scan saw %s %d times", pk, count)
+		}
+	}
+}
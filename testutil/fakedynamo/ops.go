@@ -0,0 +1,1103 @@
+package fakedynamo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// accountID is the account every fake table lives in
+const accountID = "000000000000"
+
+// maxBatchGet and maxBatchWrite are the batch sizes DynamoDB accepts
+const (
+	maxBatchGet    = 100
+	maxBatchWrite  = 25
+	maxTransaction = 100
+)
+
+type indexInput struct {
+	IndexName             string
+	KeySchema             []keySchemaElement
+	Projection            projection
+	ProvisionedThroughput *throughput
+}
+
+type createTableInput struct {
+	TableName              string
+	AttributeDefinitions   []attributeDefinition
+	KeySchema              []keySchemaElement
+	GlobalSecondaryIndexes []indexInput
+	LocalSecondaryIndexes  []indexInput
+	BillingMode            string
+	ProvisionedThroughput  *throughput
+	OnDemandThroughput     *onDemandThroughput
+	StreamSpecification    *streamSpecification
+	Tags                   []tag
+}
+
+func (f *Fake) table(name string) (*table, error) {
+	t, ok := f.tables[name]
+	if !ok {
+		return nil, notFoundf("Requested resource not found: Table: %s not found", name)
+	}
+	return t, nil
+}
+
+func (f *Fake) createTable(in *createTableInput) (map[string]any, error) {
+	if in.TableName == "" {
+		return nil, validationf("TableName must not be empty")
+	}
+	if _, ok := f.tables[in.TableName]; ok {
+		return nil, &apiError{code: "ResourceInUseException", message: "Table already exists: " + in.TableName}
+	}
+	t := &table{
+		name:      in.TableName,
+		arn:       fmt.Sprintf("arn:aws:dynamodb:us-east-1:%s:table/%s", accountID, in.TableName),
+		created:   time.Now(),
+		attrTypes: map[string]string{},
+		attrDefs:  in.AttributeDefinitions,
+		keySchema: in.KeySchema,
+		indexes:   map[string]*index{},
+		billing:   in.BillingMode,
+		onDemand:  in.OnDemandThroughput,
+		stream:    in.StreamSpecification,
+		tags:      map[string]string{},
+		items:     map[string]item{},
+	}
+	for _, def := range in.AttributeDefinitions {
+		t.attrTypes[def.AttributeName] = def.AttributeType
+	}
+	t.hashKey, t.rangeKey = keysOf(in.KeySchema)
+	if t.hashKey == "" {
+		return nil, validationf("One or more parameter values were invalid: Invalid KeySchema: Some index key attribute have no definition")
+	}
+	if t.billing == "" {
+		t.billing = "PROVISIONED"
+	}
+	if err := t.setThroughput(in.ProvisionedThroughput); err != nil {
+		return nil, err
+	}
+	for _, idx := range in.GlobalSecondaryIndexes {
+		if err := t.addIndex(idx, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, idx := range in.LocalSecondaryIndexes {
+		if err := t.addIndex(idx, true); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.checkAttributeDefinitions(); err != nil {
+		return nil, err
+	}
+	for _, tag := range in.Tags {
+		t.tags[tag.Key] = tag.Value
+	}
+	f.tables[t.name] = t
+	return map[string]any{"TableDescription": t.description("CREATING")}, nil
+}
+
+// setThroughput applies provisioned capacity, which only provisioned
+// tables take
+func (t *table) setThroughput(capacity *throughput) error {
+	switch {
+	case t.billing == "PAY_PER_REQUEST" && capacity != nil:
+		return validationf("One or more parameter values were invalid: Neither ReadCapacityUnits nor WriteCapacityUnits can be specified when BillingMode is PAY_PER_REQUEST")
+	case t.billing == "PROVISIONED" && capacity == nil && t.throughput == nil:
+		return validationf("One or more parameter values were invalid: ReadCapacityUnits and WriteCapacityUnits must both be specified when BillingMode is PROVISIONED")
+	case capacity != nil:
+		t.throughput = capacity
+	}
+	return nil
+}
+
+func (t *table) addIndex(in indexInput, local bool) error {
+	if _, ok := t.indexes[in.IndexName]; ok || in.IndexName == "" {
+		return validationf("One or more parameter values were invalid: Duplicate index name: %s", in.IndexName)
+	}
+	idx := &index{
+		name:       in.IndexName,
+		keySchema:  in.KeySchema,
+		projection: in.Projection,
+		throughput: in.ProvisionedThroughput,
+		local:      local,
+	}
+	idx.hashKey, idx.rangeKey = keysOf(in.KeySchema)
+	if local && idx.hashKey != t.hashKey {
+		return validationf("One or more parameter values were invalid: Index KeySchema does not have the same leading hash key as table KeySchema for index: %s", in.IndexName)
+	}
+	if idx.projection.ProjectionType == "" {
+		idx.projection.ProjectionType = "ALL"
+	}
+	if !local && t.billing == "PROVISIONED" && idx.throughput == nil {
+		return validationf("One or more parameter values were invalid: ProvisionedThroughput should not be null for index: %s", in.IndexName)
+	}
+	t.indexes[idx.name] = idx
+	return nil
+}
+
+// checkAttributeDefinitions makes sure every key has a definition and
+// every definition is a key, as DynamoDB does
+func (t *table) checkAttributeDefinitions() error {
+	keys := map[string]bool{}
+	schemas := [][]keySchemaElement{t.keySchema}
+	for _, idx := range t.indexes {
+		schemas = append(schemas, idx.keySchema)
+	}
+	for _, schema := range schemas {
+		for _, k := range schema {
+			if _, ok := t.attrTypes[k.AttributeName]; !ok {
+				return validationf("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions. Keys: [%s]", k.AttributeName)
+			}
+			keys[k.AttributeName] = true
+		}
+	}
+	for name := range t.attrTypes {
+		if !keys[name] {
+			return validationf("One or more parameter values were invalid: Some AttributeDefinitions are not used. AttributeDefinitions: [%s]", name)
+		}
+	}
+	return nil
+}
+
+type tableNameInput struct {
+	TableName string
+}
+
+func (f *Fake) describeTable(in *tableNameInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"Table": t.description("ACTIVE")}, nil
+}
+
+func (f *Fake) deleteTable(in *tableNameInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(f.tables, t.name)
+	return map[string]any{"TableDescription": t.description("DELETING")}, nil
+}
+
+type updateTableInput struct {
+	TableName                   string
+	AttributeDefinitions        []attributeDefinition
+	BillingMode                 string
+	ProvisionedThroughput       *throughput
+	OnDemandThroughput          *onDemandThroughput
+	StreamSpecification         *streamSpecification
+	GlobalSecondaryIndexUpdates []struct {
+		Create *indexInput
+		Update *struct {
+			IndexName             string
+			ProvisionedThroughput *throughput
+		}
+		Delete *struct {
+			IndexName string
+		}
+	}
+}
+
+func (f *Fake) updateTable(in *updateTableInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range in.AttributeDefinitions {
+		if _, ok := t.attrTypes[def.AttributeName]; !ok {
+			t.attrDefs = append(t.attrDefs, def)
+		}
+		t.attrTypes[def.AttributeName] = def.AttributeType
+	}
+	if in.BillingMode != "" {
+		t.billing = in.BillingMode
+		if t.billing == "PAY_PER_REQUEST" {
+			t.throughput = nil
+		}
+	}
+	if err := t.setThroughput(in.ProvisionedThroughput); err != nil {
+		return nil, err
+	}
+	if in.OnDemandThroughput != nil {
+		t.onDemand = in.OnDemandThroughput
+	}
+	if in.StreamSpecification != nil {
+		t.stream = in.StreamSpecification
+	}
+	for _, u := range in.GlobalSecondaryIndexUpdates {
+		switch {
+		case u.Create != nil:
+			if err := t.addIndex(*u.Create, false); err != nil {
+				return nil, err
+			}
+		case u.Update != nil:
+			idx, ok := t.indexes[u.Update.IndexName]
+			if !ok {
+				return nil, notFoundf("Requested resource not found: Index: %s not found", u.Update.IndexName)
+			}
+			idx.throughput = u.Update.ProvisionedThroughput
+		case u.Delete != nil:
+			if _, ok := t.indexes[u.Delete.IndexName]; !ok {
+				return nil, notFoundf("Requested resource not found: Index: %s not found", u.Delete.IndexName)
+			}
+			delete(t.indexes, u.Delete.IndexName)
+		}
+	}
+	return map[string]any{"TableDescription": t.description("UPDATING")}, nil
+}
+
+type listTablesInput struct {
+	ExclusiveStartTableName string
+	Limit                   int
+}
+
+func (f *Fake) listTables(in *listTablesInput) (map[string]any, error) {
+	names := []string{}
+	for name := range f.tables {
+		if name > in.ExclusiveStartTableName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	out := map[string]any{}
+	if in.Limit > 0 && len(names) > in.Limit {
+		names = names[:in.Limit]
+		out["LastEvaluatedTableName"] = names[len(names)-1]
+	}
+	out["TableNames"] = names
+	return out, nil
+}
+
+type updateTimeToLiveInput struct {
+	TableName               string
+	TimeToLiveSpecification struct {
+		AttributeName string
+		Enabled       bool
+	}
+}
+
+func (f *Fake) updateTimeToLive(in *updateTimeToLiveInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	spec := in.TimeToLiveSpecification
+	if spec.Enabled == (t.ttlName != "") {
+		return nil, validationf("TimeToLive is already %s", map[bool]string{true: "enabled", false: "disabled"}[spec.Enabled])
+	}
+	t.ttlName = ""
+	if spec.Enabled {
+		t.ttlName = spec.AttributeName
+	}
+	return map[string]any{"TimeToLiveSpecification": spec}, nil
+}
+
+func (f *Fake) describeTimeToLive(in *tableNameInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	desc := map[string]any{"TimeToLiveStatus": "DISABLED"}
+	if t.ttlName != "" {
+		desc = map[string]any{"TimeToLiveStatus": "ENABLED", "AttributeName": t.ttlName}
+	}
+	return map[string]any{"TimeToLiveDescription": desc}, nil
+}
+
+func (f *Fake) tableByARN(arn string) (*table, error) {
+	for _, t := range f.tables {
+		if t.arn == arn {
+			return t, nil
+		}
+	}
+	return nil, notFoundf("Requested resource not found: ResourceArn: %s not found", arn)
+}
+
+type tagResourceInput struct {
+	ResourceArn string
+	Tags        []tag
+	TagKeys     []string
+	NextToken   string
+}
+
+func (f *Fake) tagResource(in *tagResourceInput) (map[string]any, error) {
+	t, err := f.tableByARN(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range in.Tags {
+		t.tags[tag.Key] = tag.Value
+	}
+	return map[string]any{}, nil
+}
+
+func (f *Fake) untagResource(in *tagResourceInput) (map[string]any, error) {
+	t, err := f.tableByARN(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range in.TagKeys {
+		delete(t.tags, key)
+	}
+	return map[string]any{}, nil
+}
+
+func (f *Fake) listTagsOfResource(in *tagResourceInput) (map[string]any, error) {
+	t, err := f.tableByARN(in.ResourceArn)
+	if err != nil {
+		return nil, err
+	}
+	tags := []tag{}
+	for k, v := range t.tags {
+		tags = append(tags, tag{Key: k, Value: v})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+	return map[string]any{"Tags": tags}, nil
+}
+
+// expressionInput holds the expression fields most item operations share
+type expressionInput struct {
+	ConditionExpression                 string
+	ExpressionAttributeNames            map[string]string
+	ExpressionAttributeValues           map[string]*attr
+	ReturnValuesOnConditionCheckFailure string
+}
+
+// condition parses the condition, nil when there is none
+func (in *expressionInput) condition(e *env) (cond, error) {
+	if in.ConditionExpression == "" {
+		return nil, nil
+	}
+	return parseCondition(in.ConditionExpression, e)
+}
+
+// check evaluates a write's condition against the item as it stands
+func check(c cond, old item, returnOld bool) error {
+	if c == nil {
+		return nil
+	}
+	current := old
+	if current == nil {
+		current = item{}
+	}
+	if !c.eval(current) {
+		return conditionFailed(old, returnOld)
+	}
+	return nil
+}
+
+type putItemInput struct {
+	TableName string
+	Item      item
+	expressionInput
+	ReturnValues string
+}
+
+func (f *Fake) putItem(in *putItemInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkItem(in.Item); err != nil {
+		return nil, err
+	}
+	e := newEnv(in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	c, err := in.condition(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkUnused(); err != nil {
+		return nil, err
+	}
+	key := t.keyOf(in.Item)
+	old := t.items[key]
+	if err := check(c, old, in.ReturnValuesOnConditionCheckFailure == "ALL_OLD"); err != nil {
+		return nil, err
+	}
+	t.items[key] = in.Item.clone()
+	return returnValues(in.ReturnValues, old, nil, nil), nil
+}
+
+// returnValues builds an item write's response for its ReturnValues
+func returnValues(mode string, old, new item, updated map[string]bool) map[string]any {
+	var attrs item
+	switch mode {
+	case "ALL_OLD":
+		attrs = old
+	case "ALL_NEW":
+		attrs = new
+	case "UPDATED_OLD", "UPDATED_NEW":
+		source := old
+		if mode == "UPDATED_NEW" {
+			source = new
+		}
+		attrs = item{}
+		for name := range updated {
+			if v, ok := source[name]; ok {
+				attrs[name] = v
+			}
+		}
+	}
+	if len(attrs) == 0 {
+		return map[string]any{}
+	}
+	return map[string]any{"Attributes": attrs}
+}
+
+type getItemInput struct {
+	TableName                string
+	Key                      item
+	ProjectionExpression     string
+	ExpressionAttributeNames map[string]string
+	ConsistentRead           bool
+}
+
+func (f *Fake) getItem(in *getItemInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	it, err := t.get(in.Key, in.ProjectionExpression, in.ExpressionAttributeNames)
+	if err != nil || it == nil {
+		return map[string]any{}, err
+	}
+	return map[string]any{"Item": it}, nil
+}
+
+// get reads one item by key with an optional projection
+func (t *table) get(key item, projection string, names map[string]string) (item, error) {
+	if err := t.checkKey(key); err != nil {
+		return nil, err
+	}
+	e := newEnv(names, nil)
+	var paths []path
+	if projection != "" {
+		var err error
+		if paths, err = parseProjection(projection, e); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.checkUnused(); err != nil {
+		return nil, err
+	}
+	it, ok := t.items[t.keyOf(key)]
+	if !ok {
+		return nil, nil
+	}
+	if paths != nil {
+		return project(it, paths), nil
+	}
+	return it.clone(), nil
+}
+
+type deleteItemInput struct {
+	TableName string
+	Key       item
+	expressionInput
+	ReturnValues string
+}
+
+func (f *Fake) deleteItem(in *deleteItemInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkKey(in.Key); err != nil {
+		return nil, err
+	}
+	e := newEnv(in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	c, err := in.condition(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkUnused(); err != nil {
+		return nil, err
+	}
+	key := t.keyOf(in.Key)
+	old := t.items[key]
+	if err := check(c, old, in.ReturnValuesOnConditionCheckFailure == "ALL_OLD"); err != nil {
+		return nil, err
+	}
+	delete(t.items, key)
+	return returnValues(in.ReturnValues, old, nil, nil), nil
+}
+
+type updateItemInput struct {
+	TableName        string
+	Key              item
+	UpdateExpression string
+	expressionInput
+	ReturnValues string
+}
+
+func (f *Fake) updateItem(in *updateItemInput) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	w, err := t.prepareUpdate(in.Key, in.UpdateExpression, &in.expressionInput)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.run(); err != nil {
+		return nil, err
+	}
+	return returnValues(in.ReturnValues, w.old, w.new, w.updated), nil
+}
+
+// write is an item write whose expressions have been parsed, ready to be
+// checked and applied. Transactions check every write before applying any.
+type write struct {
+	table     *table
+	key       string
+	old       item
+	cond      cond
+	returnOld bool
+	// new is the item after the write, nil for a delete or a check
+	new     item
+	delete  bool
+	check   bool
+	update  *update
+	updated map[string]bool
+}
+
+// prepareUpdate parses an UpdateItem, or the Update of a transaction
+func (t *table) prepareUpdate(key item, expr string, in *expressionInput) (*write, error) {
+	if err := t.checkKey(key); err != nil {
+		return nil, err
+	}
+	e := newEnv(in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	w := &write{table: t, key: t.keyOf(key), returnOld: in.ReturnValuesOnConditionCheckFailure == "ALL_OLD", updated: map[string]bool{}}
+	if expr != "" {
+		var err error
+		if w.update, err = parseUpdate(expr, e); err != nil {
+			return nil, err
+		}
+		for _, p := range w.update.paths() {
+			for _, name := range t.keyNames() {
+				if p[0].key == name {
+					return nil, validationf("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", name)
+				}
+			}
+			w.updated[p[0].key] = true
+		}
+	}
+	var err error
+	if w.cond, err = in.condition(e); err != nil {
+		return nil, err
+	}
+	if err := e.checkUnused(); err != nil {
+		return nil, err
+	}
+	w.old = t.items[w.key]
+
+	base := w.old
+	if base == nil {
+		base = key.clone()
+	}
+	w.new = base.clone()
+	return w, nil
+}
+
+// prepare checks the write's condition and works out the new item
+func (w *write) prepare() error {
+	if err := check(w.cond, w.old, w.returnOld); err != nil {
+		return err
+	}
+	if w.update != nil {
+		base := w.old
+		if base == nil {
+			base = w.new
+		}
+		next, err := w.update.apply(base)
+		if err != nil {
+			return err
+		}
+		w.new = next
+	}
+	if w.new != nil && !w.delete && !w.check {
+		return w.table.checkItem(w.new)
+	}
+	return nil
+}
+
+func (w *write) apply() {
+	switch {
+	case w.check:
+	case w.delete:
+		delete(w.table.items, w.key)
+	default:
+		w.table.items[w.key] = w.new
+	}
+}
+
+func (w *write) run() error {
+	if err := w.prepare(); err != nil {
+		return err
+	}
+	w.apply()
+	return nil
+}
+
+type queryInput struct {
+	TableName                 string
+	IndexName                 string
+	KeyConditionExpression    string
+	FilterExpression          string
+	ProjectionExpression      string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]*attr
+	Limit                     int
+	ExclusiveStartKey         item
+	ScanIndexForward          *bool
+	Select                    string
+	ConsistentRead            bool
+	Segment                   *int
+	TotalSegments             *int
+}
+
+func (f *Fake) query(in *queryInput) (map[string]any, error) {
+	return f.read(in, true)
+}
+
+func (f *Fake) scan(in *queryInput) (map[string]any, error) {
+	return f.read(in, false)
+}
+
+// read runs a Query or a Scan
+func (f *Fake) read(in *queryInput, isQuery bool) (map[string]any, error) {
+	t, err := f.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	v, err := t.view(in.IndexName)
+	if err != nil {
+		return nil, err
+	}
+	if in.ConsistentRead && v.index != nil && !v.index.local {
+		return nil, validationf("Consistent reads are not supported on global secondary indexes")
+	}
+
+	e := newEnv(in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	var keyCond cond
+	if isQuery {
+		if in.KeyConditionExpression == "" {
+			return nil, validationf("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+		}
+		c, err := parseCondition(in.KeyConditionExpression, e)
+		if err != nil {
+			return nil, err
+		}
+		if keyCond, err = v.keyCondition(c); err != nil {
+			return nil, err
+		}
+	}
+	var filter cond
+	if in.FilterExpression != "" {
+		if filter, err = parseCondition(in.FilterExpression, e); err != nil {
+			return nil, err
+		}
+	}
+	var paths []path
+	if in.ProjectionExpression != "" {
+		if paths, err = parseProjection(in.ProjectionExpression, e); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.checkUnused(); err != nil {
+		return nil, err
+	}
+	if in.Limit < 0 {
+		return nil, validationf("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1", in.Limit)
+	}
+
+	var candidates []item
+	for _, it := range v.items() {
+		if keyCond != nil && !keyCond.eval(it) {
+			continue
+		}
+		if in.TotalSegments != nil && in.Segment != nil && v.segmentOf(it, *in.TotalSegments) != *in.Segment {
+			continue
+		}
+		candidates = append(candidates, it)
+	}
+	forward := in.ScanIndexForward == nil || *in.ScanIndexForward
+	if !forward {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+	}
+	if in.ExclusiveStartKey != nil {
+		for _, name := range v.table.keyNames() {
+			if in.ExclusiveStartKey[name] == nil {
+				return nil, validationf("The provided starting key is invalid: The provided key element does not match the schema")
+			}
+		}
+		start := 0
+		for start < len(candidates) {
+			it := candidates[start]
+			after := v.less(in.ExclusiveStartKey, it)
+			if !forward {
+				after = v.less(it, in.ExclusiveStartKey)
+			}
+			if after {
+				break
+			}
+			start++
+		}
+		candidates = candidates[start:]
+	}
+
+	items := []item{}
+	scanned, bytes := 0, 0
+	var last item
+	for _, it := range candidates {
+		if in.Limit > 0 && scanned == in.Limit || bytes >= maxPageBytes {
+			break
+		}
+		scanned++
+		bytes += it.size()
+		last = it
+		if filter != nil && !filter.eval(it) {
+			continue
+		}
+		projected := v.project(it)
+		if paths != nil {
+			projected = project(projected, paths)
+		}
+		items = append(items, projected)
+	}
+
+	out := map[string]any{"Count": len(items), "ScannedCount": scanned}
+	if in.Select != "COUNT" {
+		out["Items"] = items
+	}
+	// Like DynamoDB, a page that stops at the limit has a LastEvaluatedKey
+	// even when nothing follows it
+	if last != nil && (in.Limit > 0 && scanned == in.Limit || bytes >= maxPageBytes) {
+		out["LastEvaluatedKey"] = v.lastKey(last)
+	}
+	return out, nil
+}
+
+// keyCondition checks a parsed key condition has an equality on the hash
+// key and at most one condition on the range key
+func (v *view) keyCondition(c cond) (cond, error) {
+	var conjuncts []cond
+	var flatten func(c cond)
+	flatten = func(c cond) {
+		if and, ok := c.(andCond); ok {
+			flatten(and.a)
+			flatten(and.b)
+			return
+		}
+		conjuncts = append(conjuncts, c)
+	}
+	flatten(c)
+
+	var hash, rng bool
+	for _, conj := range conjuncts {
+		var name string
+		switch k := conj.(type) {
+		case compareCond:
+			name = keyAttribute(k.a)
+			if _, isValue := k.b.(valueOperand); !isValue || k.op == "<>" {
+				name = ""
+			}
+			if name == v.hashKey && k.op != "=" {
+				return nil, validationf("Query key condition not supported")
+			}
+		case betweenCond:
+			name = keyAttribute(k.x)
+		case funcCond:
+			if k.name == "begins_with" && len(k.path) == 1 {
+				name = k.path[0].key
+			}
+		}
+		switch {
+		case name == v.hashKey && !hash:
+			hash = true
+		case name != "" && name == v.rangeKey && !rng:
+			rng = true
+		default:
+			return nil, validationf("Query key condition not supported")
+		}
+	}
+	if !hash {
+		return nil, validationf("Query condition missed key schema element: %s", v.hashKey)
+	}
+	return c, nil
+}
+
+func keyAttribute(o operand) string {
+	if p, ok := o.(pathOperand); ok && len(p.path) == 1 {
+		return p.path[0].key
+	}
+	return ""
+}
+
+type keysAndAttributes struct {
+	Keys                     []item
+	ProjectionExpression     string
+	ExpressionAttributeNames map[string]string
+	ConsistentRead           bool
+}
+
+type batchGetItemInput struct {
+	RequestItems map[string]keysAndAttributes
+}
+
+func (f *Fake) batchGetItem(in *batchGetItemInput) (map[string]any, error) {
+	total := 0
+	for _, req := range in.RequestItems {
+		total += len(req.Keys)
+	}
+	if total == 0 || total > maxBatchGet {
+		return nil, validationf("Too many items requested for the BatchGetItem call")
+	}
+	responses := map[string][]item{}
+	for name, req := range in.RequestItems {
+		t, err := f.table(name)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		responses[name] = []item{}
+		for _, key := range req.Keys {
+			if err := t.checkKey(key); err != nil {
+				return nil, err
+			}
+			if seen[t.keyOf(key)] {
+				return nil, validationf("Provided list of item keys contains duplicates")
+			}
+			seen[t.keyOf(key)] = true
+			it, err := t.get(key, req.ProjectionExpression, req.ExpressionAttributeNames)
+			if err != nil {
+				return nil, err
+			}
+			if it != nil {
+				responses[name] = append(responses[name], it)
+			}
+		}
+	}
+	return map[string]any{"Responses": responses, "UnprocessedKeys": map[string]any{}}, nil
+}
+
+type batchWriteItemInput struct {
+	RequestItems map[string][]struct {
+		PutRequest *struct {
+			Item item
+		}
+		DeleteRequest *struct {
+			Key item
+		}
+	}
+}
+
+func (f *Fake) batchWriteItem(in *batchWriteItemInput) (map[string]any, error) {
+	total := 0
+	for _, reqs := range in.RequestItems {
+		total += len(reqs)
+	}
+	if total == 0 || total > maxBatchWrite {
+		return nil, validationf("1 validation error detected: Value at 'requestItems' failed to satisfy constraint: Map value must satisfy constraint: [Member must have length less than or equal to 25, Member must have length greater than or equal to 1]")
+	}
+	var writes []*write
+	for name, reqs := range in.RequestItems {
+		t, err := f.table(name)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, req := range reqs {
+			w := &write{table: t}
+			switch {
+			case req.PutRequest != nil:
+				if err := t.checkItem(req.PutRequest.Item); err != nil {
+					return nil, err
+				}
+				w.key, w.new = t.keyOf(req.PutRequest.Item), req.PutRequest.Item.clone()
+			case req.DeleteRequest != nil:
+				if err := t.checkKey(req.DeleteRequest.Key); err != nil {
+					return nil, err
+				}
+				w.key, w.delete = t.keyOf(req.DeleteRequest.Key), true
+			default:
+				return nil, validationf("A write request must have a PutRequest or a DeleteRequest")
+			}
+			if seen[w.key] {
+				return nil, validationf("Provided list of item keys contains duplicates")
+			}
+			seen[w.key] = true
+			writes = append(writes, w)
+		}
+	}
+	for _, w := range writes {
+		w.apply()
+	}
+	return map[string]any{"UnprocessedItems": map[string]any{}}, nil
+}
+
+type transactGetItemsInput struct {
+	TransactItems []struct {
+		Get struct {
+			TableName                string
+			Key                      item
+			ProjectionExpression     string
+			ExpressionAttributeNames map[string]string
+		}
+	}
+}
+
+func (f *Fake) transactGetItems(in *transactGetItemsInput) (map[string]any, error) {
+	if len(in.TransactItems) == 0 || len(in.TransactItems) > maxTransaction {
+		return nil, validationf("Member must have length less than or equal to %d", maxTransaction)
+	}
+	responses := make([]map[string]any, len(in.TransactItems))
+	for i, ti := range in.TransactItems {
+		t, err := f.table(ti.Get.TableName)
+		if err != nil {
+			return nil, err
+		}
+		it, err := t.get(ti.Get.Key, ti.Get.ProjectionExpression, ti.Get.ExpressionAttributeNames)
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = map[string]any{}
+		if it != nil {
+			responses[i]["Item"] = it
+		}
+	}
+	return map[string]any{"Responses": responses}, nil
+}
+
+type transactWriteItem struct {
+	TableName        string
+	Key              item
+	Item             item
+	UpdateExpression string
+	expressionInput
+}
+
+type transactWriteItemsInput struct {
+	TransactItems []struct {
+		ConditionCheck *transactWriteItem
+		Put            *transactWriteItem
+		Delete         *transactWriteItem
+		Update         *transactWriteItem
+	}
+	ClientRequestToken string
+}
+
+func (f *Fake) transactWriteItems(in *transactWriteItemsInput) (map[string]any, error) {
+	if len(in.TransactItems) == 0 || len(in.TransactItems) > maxTransaction {
+		return nil, validationf("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d", maxTransaction)
+	}
+	writes := make([]*write, len(in.TransactItems))
+	seen := map[string]bool{}
+	for i, ti := range in.TransactItems {
+		var w *write
+		var err error
+		switch {
+		case ti.Put != nil:
+			w, err = f.prepareTransactWrite(ti.Put, ti.Put.Item)
+			if w != nil {
+				w.new = ti.Put.Item.clone()
+			}
+		case ti.Delete != nil:
+			w, err = f.prepareTransactWrite(ti.Delete, ti.Delete.Key)
+			if w != nil {
+				w.delete = true
+			}
+		case ti.ConditionCheck != nil:
+			if ti.ConditionCheck.ConditionExpression == "" {
+				return nil, validationf("The ConditionCheck must have a ConditionExpression")
+			}
+			w, err = f.prepareTransactWrite(ti.ConditionCheck, ti.ConditionCheck.Key)
+			if w != nil {
+				w.check = true
+			}
+		case ti.Update != nil:
+			var t *table
+			if t, err = f.table(ti.Update.TableName); err == nil {
+				w, err = t.prepareUpdate(ti.Update.Key, ti.Update.UpdateExpression, &ti.Update.expressionInput)
+			}
+		default:
+			return nil, validationf("A transact write item must have one of ConditionCheck, Put, Delete or Update")
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := w.table.name + "\x00" + w.key
+		if seen[id] {
+			return nil, validationf("Transaction request cannot include multiple operations on one item")
+		}
+		seen[id] = true
+		writes[i] = w
+	}
+
+	// Check every write against the current items before applying any
+	reasons := make([]map[string]any, len(writes))
+	var codes []string
+	cancelled := false
+	for i, w := range writes {
+		reason := map[string]any{"Code": "None"}
+		if err := w.prepare(); err != nil {
+			cancelled = true
+			apiErr := err.(*apiError)
+			reason = map[string]any{"Code": "ValidationError", "Message": apiErr.message}
+			if apiErr.code == "ConditionalCheckFailedException" {
+				reason = map[string]any{"Code": "ConditionalCheckFailed", "Message": apiErr.message}
+				if old, ok := apiErr.fields["Item"]; ok {
+					reason["Item"] = old
+				}
+			}
+		}
+		reasons[i] = reason
+		codes = append(codes, reason["Code"].(string))
+	}
+	if cancelled {
+		return nil, &apiError{
+			code:    "TransactionCanceledException",
+			message: fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(codes, ", ")),
+			fields:  map[string]any{"CancellationReasons": reasons},
+		}
+	}
+	for _, w := range writes {
+		w.apply()
+	}
+	return map[string]any{}, nil
+}
+
+// prepareTransactWrite parses the condition of a transaction's put,
+// delete or condition check on the item with key
+func (f *Fake) prepareTransactWrite(ti *transactWriteItem, key item) (*write, error) {
+	t, err := f.table(ti.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if ti.Item != nil {
+		if err := t.checkItem(key); err != nil {
+			return nil, err
+		}
+		key = t.primaryKey(key)
+	}
+	if err := t.checkKey(key); err != nil {
+		return nil, err
+	}
+	e := newEnv(ti.ExpressionAttributeNames, ti.ExpressionAttributeValues)
+	c, err := ti.condition(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkUnused(); err != nil {
+		return nil, err
+	}
+	w := &write{table: t, key: t.keyOf(key), cond: c, returnOld: ti.ReturnValuesOnConditionCheckFailure == "ALL_OLD"}
+	w.old = t.items[w.key]
+	return w, nil
+}
@@ -0,0 +1,383 @@
+package fakedynamo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxItemSize is DynamoDB's item size limit
+const maxItemSize = 400 * 1024
+
+// maxPageBytes is how much data one Query or Scan reads before stopping
+const maxPageBytes = 1024 * 1024
+
+type keySchemaElement struct {
+	AttributeName string
+	KeyType       string
+}
+
+type attributeDefinition struct {
+	AttributeName string
+	AttributeType string
+}
+
+type projection struct {
+	ProjectionType   string   `json:",omitempty"`
+	NonKeyAttributes []string `json:",omitempty"`
+}
+
+type throughput struct {
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+}
+
+type onDemandThroughput struct {
+	MaxReadRequestUnits  int64 `json:",omitempty"`
+	MaxWriteRequestUnits int64 `json:",omitempty"`
+}
+
+type streamSpecification struct {
+	StreamEnabled  bool
+	StreamViewType string `json:",omitempty"`
+}
+
+type tag struct {
+	Key   string
+	Value string
+}
+
+// index is a secondary index. Items aren't copied into it; queries read the
+// table's items that have the index's keys.
+type index struct {
+	name       string
+	hashKey    string
+	rangeKey   string
+	keySchema  []keySchemaElement
+	projection projection
+	throughput *throughput
+	local      bool
+}
+
+type table struct {
+	name       string
+	arn        string
+	created    time.Time
+	attrTypes  map[string]string
+	attrDefs   []attributeDefinition
+	keySchema  []keySchemaElement
+	hashKey    string
+	rangeKey   string
+	indexes    map[string]*index
+	billing    string
+	throughput *throughput
+	onDemand   *onDemandThroughput
+	stream     *streamSpecification
+	ttlName    string
+	tags       map[string]string
+	items      map[string]item
+}
+
+func keysOf(schema []keySchemaElement) (hash, rng string) {
+	for _, k := range schema {
+		if k.KeyType == "HASH" {
+			hash = k.AttributeName
+		} else {
+			rng = k.AttributeName
+		}
+	}
+	return hash, rng
+}
+
+// keyNames returns the table's key attribute names
+func (t *table) keyNames() []string {
+	if t.rangeKey == "" {
+		return []string{t.hashKey}
+	}
+	return []string{t.hashKey, t.rangeKey}
+}
+
+// keyOf encodes an item's primary key for the item map. Numbers are
+// normalized so 1 and 1.0 are the same key.
+func (t *table) keyOf(it item) string {
+	var b strings.Builder
+	for _, name := range t.keyNames() {
+		b.WriteString(encodeKeyValue(it[name]))
+	}
+	return b.String()
+}
+
+func encodeKeyValue(v *attr) string {
+	value := v.s
+	switch v.kind {
+	case "N":
+		n, _ := parseNumber(v.s)
+		value = formatNumber(n)
+	case "B":
+		value = string(v.b)
+	}
+	return v.kind + "\x00" + value + "\x00"
+}
+
+// primaryKey returns only the key attributes of an item
+func (t *table) primaryKey(it item) item {
+	key := item{}
+	for _, name := range t.keyNames() {
+		key[name] = it[name]
+	}
+	return key
+}
+
+// checkKey validates a key given to GetItem, DeleteItem and friends
+func (t *table) checkKey(key item) error {
+	if len(key) != len(t.keyNames()) {
+		return validationf("The provided key element does not match the schema")
+	}
+	for _, name := range t.keyNames() {
+		v, ok := key[name]
+		if !ok || v.kind != t.attrTypes[name] {
+			return validationf("The provided key element does not match the schema")
+		}
+		if err := checkKeyValue(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkItem validates an item about to be written: its keys, the types of
+// any index keys it has, and its size
+func (t *table) checkItem(it item) error {
+	for _, name := range t.keyNames() {
+		v, ok := it[name]
+		if !ok {
+			return validationf("One or more parameter values were invalid: Missing the key %s in the item", name)
+		}
+		if v.kind != t.attrTypes[name] {
+			return validationf("One or more parameter values were invalid: Type mismatch for key %s expected: %s actual: %s", name, t.attrTypes[name], v.kind)
+		}
+		if err := checkKeyValue(name, v); err != nil {
+			return err
+		}
+	}
+	for _, idx := range t.indexes {
+		for _, name := range []string{idx.hashKey, idx.rangeKey} {
+			v, ok := it[name]
+			if name == "" || !ok {
+				continue
+			}
+			if v.kind != t.attrTypes[name] {
+				return validationf("One or more parameter values were invalid: Type mismatch for Index Key %s Expected: %s Actual: %s IndexName: %s", name, t.attrTypes[name], v.kind, idx.name)
+			}
+			if v.kind == "S" && v.s == "" || v.kind == "B" && len(v.b) == 0 {
+				return validationf("One or more parameter values are not valid. A value specified for a secondary index key is not supported. The AttributeValue for a key attribute cannot contain an empty string value. IndexName: %s, IndexKey: %s", idx.name, name)
+			}
+		}
+	}
+	if it.size() > maxItemSize {
+		return validationf("Item size has exceeded the maximum allowed size")
+	}
+	return nil
+}
+
+func checkKeyValue(name string, v *attr) error {
+	if v.kind == "S" && v.s == "" || v.kind == "B" && len(v.b) == 0 {
+		return validationf("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty string value. Key: %s", name)
+	}
+	return nil
+}
+
+// view is how a query or scan sees the table: the table itself or one of
+// its indexes
+type view struct {
+	table    *table
+	index    *index
+	hashKey  string
+	rangeKey string
+}
+
+func (t *table) view(indexName string) (*view, error) {
+	if indexName == "" {
+		return &view{table: t, hashKey: t.hashKey, rangeKey: t.rangeKey}, nil
+	}
+	idx, ok := t.indexes[indexName]
+	if !ok {
+		return nil, validationf("The table does not have the specified index: %s", indexName)
+	}
+	return &view{table: t, index: idx, hashKey: idx.hashKey, rangeKey: idx.rangeKey}, nil
+}
+
+// items returns the items in the view, in its key order. Ties on an
+// index's keys fall back to the table's key so paging is stable.
+func (v *view) items() []item {
+	var items []item
+	for _, it := range v.table.items {
+		if v.index != nil && (it[v.hashKey] == nil || v.rangeKey != "" && it[v.rangeKey] == nil) {
+			continue
+		}
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool { return v.less(items[i], items[j]) })
+	return items
+}
+
+// less orders items by hash key, then range key, then table key
+func (v *view) less(a, b item) bool {
+	if n := compareKey(a[v.hashKey], b[v.hashKey]); n != 0 {
+		return n < 0
+	}
+	if v.rangeKey != "" {
+		if n := compareKey(a[v.rangeKey], b[v.rangeKey]); n != 0 {
+			return n < 0
+		}
+	}
+	return v.table.keyOf(a) < v.table.keyOf(b)
+}
+
+// compareKey orders key values, tolerating the missing attributes of an
+// incomplete ExclusiveStartKey
+func compareKey(a, b *attr) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case a.kind != b.kind:
+		return strings.Compare(a.kind, b.kind)
+	}
+	return compare(a, b)
+}
+
+// lastKey is the LastEvaluatedKey of a page ending at it: the table's key
+// plus the index's
+func (v *view) lastKey(it item) item {
+	key := v.table.primaryKey(it)
+	if v.index != nil {
+		key[v.hashKey] = it[v.hashKey]
+		if v.rangeKey != "" {
+			key[v.rangeKey] = it[v.rangeKey]
+		}
+	}
+	return key
+}
+
+// project returns what the view stores of an item, by the index's
+// projection
+func (v *view) project(it item) item {
+	if v.index == nil || v.index.projection.ProjectionType == "ALL" {
+		return it.clone()
+	}
+	out := v.lastKey(it).clone()
+	if v.index.projection.ProjectionType == "INCLUDE" {
+		for _, name := range v.index.projection.NonKeyAttributes {
+			if a, ok := it[name]; ok {
+				out[name] = a.clone()
+			}
+		}
+	}
+	return out
+}
+
+// segmentOf assigns an item to a parallel scan segment by its hash key
+func (v *view) segmentOf(it item, total int) int {
+	h := fnv.New32a()
+	h.Write([]byte(encodeKeyValue(it[v.table.hashKey])))
+	return int(h.Sum32() % uint32(total))
+}
+
+// description is the table as DescribeTable reports it
+func (t *table) description(status string) map[string]any {
+	desc := map[string]any{
+		"TableName":            t.name,
+		"TableArn":             t.arn,
+		"TableId":              t.id(),
+		"TableStatus":          status,
+		"CreationDateTime":     float64(t.created.UnixMilli()) / 1000,
+		"AttributeDefinitions": t.attrDefs,
+		"KeySchema":            t.keySchema,
+		"ItemCount":            len(t.items),
+		"TableSizeBytes":       t.sizeBytes(),
+		"BillingModeSummary":   map[string]any{"BillingMode": t.billing},
+		"ProvisionedThroughput": map[string]any{
+			"ReadCapacityUnits":      t.capacity().ReadCapacityUnits,
+			"WriteCapacityUnits":     t.capacity().WriteCapacityUnits,
+			"NumberOfDecreasesToday": 0,
+		},
+		"DeletionProtectionEnabled": false,
+	}
+	if t.onDemand != nil {
+		desc["OnDemandThroughput"] = t.onDemand
+	}
+	if t.stream != nil && t.stream.StreamEnabled {
+		desc["StreamSpecification"] = t.stream
+		desc["LatestStreamArn"] = t.arn + "/stream/" + t.created.Format("2006-01-02T15:04:05.000")
+		desc["LatestStreamLabel"] = t.created.Format("2006-01-02T15:04:05.000")
+	}
+
+	var global, local []map[string]any
+	names := make([]string, 0, len(t.indexes))
+	for name := range t.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		idx := t.indexes[name]
+		v, _ := t.view(name)
+		d := map[string]any{
+			"IndexName":      idx.name,
+			"IndexArn":       t.arn + "/index/" + idx.name,
+			"KeySchema":      idx.keySchema,
+			"Projection":     idx.projection,
+			"ItemCount":      len(v.items()),
+			"IndexSizeBytes": 0,
+		}
+		if idx.local {
+			local = append(local, d)
+			continue
+		}
+		d["IndexStatus"] = "ACTIVE"
+		capacity := throughput{}
+		if idx.throughput != nil {
+			capacity = *idx.throughput
+		}
+		d["ProvisionedThroughput"] = map[string]any{
+			"ReadCapacityUnits":      capacity.ReadCapacityUnits,
+			"WriteCapacityUnits":     capacity.WriteCapacityUnits,
+			"NumberOfDecreasesToday": 0,
+		}
+		global = append(global, d)
+	}
+	if len(global) > 0 {
+		desc["GlobalSecondaryIndexes"] = global
+	}
+	if len(local) > 0 {
+		desc["LocalSecondaryIndexes"] = local
+	}
+	return desc
+}
+
+// id is a stable stand-in for the table's UUID
+func (t *table) id() string {
+	h := fnv.New64a()
+	h.Write([]byte(t.arn))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func (t *table) capacity() throughput {
+	if t.billing == "PROVISIONED" && t.throughput != nil {
+		return *t.throughput
+	}
+	return throughput{}
+}
+
+func (t *table) sizeBytes() int {
+	n := 0
+	for _, it := range t.items {
+		n += it.size()
+	}
+	return n
+}
@@ -0,0 +1,336 @@
+package fakedynamo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// attr is an attribute value in DynamoDB's JSON wire format, e.g.
+// {"S": "x"}. kind is the format's type descriptor.
+type attr struct {
+	kind string
+	s    string
+	b    []byte
+	bool bool
+	m    map[string]*attr
+	l    []*attr
+	ss   []string
+	bs   [][]byte
+}
+
+// item is an item or key, by attribute name
+type item map[string]*attr
+
+func (a *attr) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 1 {
+		return fmt.Errorf("attribute value must have exactly one type, got %d", len(raw))
+	}
+	for kind, value := range raw {
+		a.kind = kind
+		var err error
+		switch kind {
+		case "S", "N":
+			err = json.Unmarshal(value, &a.s)
+		case "B":
+			err = json.Unmarshal(value, &a.b)
+		case "BOOL", "NULL":
+			err = json.Unmarshal(value, &a.bool)
+		case "M":
+			err = json.Unmarshal(value, &a.m)
+			if a.m == nil {
+				a.m = map[string]*attr{}
+			}
+		case "L":
+			err = json.Unmarshal(value, &a.l)
+		case "SS", "NS":
+			err = json.Unmarshal(value, &a.ss)
+			if err == nil && len(a.ss) == 0 {
+				err = fmt.Errorf("an empty set is not allowed")
+			}
+		case "BS":
+			err = json.Unmarshal(value, &a.bs)
+			if err == nil && len(a.bs) == 0 {
+				err = fmt.Errorf("an empty set is not allowed")
+			}
+		default:
+			return fmt.Errorf("unknown attribute value type %q", kind)
+		}
+		if err != nil {
+			return err
+		}
+		if kind == "N" {
+			if _, ok := parseNumber(a.s); !ok {
+				return fmt.Errorf("invalid number %q", a.s)
+			}
+		}
+		if kind == "NS" {
+			for _, n := range a.ss {
+				if _, ok := parseNumber(n); !ok {
+					return fmt.Errorf("invalid number %q", n)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (a *attr) MarshalJSON() ([]byte, error) {
+	var value any
+	switch a.kind {
+	case "S", "N":
+		value = a.s
+	case "B":
+		value = a.b
+	case "BOOL", "NULL":
+		value = a.bool
+	case "M":
+		value = a.m
+	case "L":
+		if a.l == nil {
+			value = []*attr{}
+		} else {
+			value = a.l
+		}
+	case "SS", "NS":
+		value = a.ss
+	case "BS":
+		value = a.bs
+	default:
+		return nil, fmt.Errorf("unknown attribute value type %q", a.kind)
+	}
+	return json.Marshal(map[string]any{a.kind: value})
+}
+
+func stringAttr(s string) *attr {
+	return &attr{kind: "S", s: s}
+}
+
+func numberAttr(n *big.Rat) *attr {
+	return &attr{kind: "N", s: formatNumber(n)}
+}
+
+// clone deep copies the value, so stored items never share state with
+// requests or responses
+func (a *attr) clone() *attr {
+	if a == nil {
+		return nil
+	}
+	c := *a
+	c.b = bytes.Clone(a.b)
+	if a.m != nil {
+		c.m = make(map[string]*attr, len(a.m))
+		for k, v := range a.m {
+			c.m[k] = v.clone()
+		}
+	}
+	if a.l != nil {
+		c.l = make([]*attr, len(a.l))
+		for i, v := range a.l {
+			c.l[i] = v.clone()
+		}
+	}
+	c.ss = append([]string(nil), a.ss...)
+	if a.bs != nil {
+		c.bs = make([][]byte, len(a.bs))
+		for i, v := range a.bs {
+			c.bs[i] = bytes.Clone(v)
+		}
+	}
+	return &c
+}
+
+func (it item) clone() item {
+	if it == nil {
+		return nil
+	}
+	c := make(item, len(it))
+	for k, v := range it {
+		c[k] = v.clone()
+	}
+	return c
+}
+
+// parseNumber parses a DynamoDB number, which has up to 38 digits of
+// precision, exactly
+func parseNumber(s string) (*big.Rat, bool) {
+	return new(big.Rat).SetString(strings.TrimSpace(s))
+}
+
+// formatNumber writes a number the way DynamoDB returns it, without
+// trailing zeros
+func formatNumber(n *big.Rat) string {
+	if n.IsInt() {
+		return n.Num().String()
+	}
+	s := n.FloatString(38)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// equal reports whether two values are equal, comparing numbers by value
+// and sets regardless of order
+func equal(a, b *attr) bool {
+	if a == nil || b == nil || a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case "S":
+		return a.s == b.s
+	case "N":
+		return compare(a, b) == 0
+	case "B":
+		return bytes.Equal(a.b, b.b)
+	case "BOOL", "NULL":
+		return a.bool == b.bool
+	case "M":
+		if len(a.m) != len(b.m) {
+			return false
+		}
+		for k, v := range a.m {
+			if !equal(v, b.m[k]) {
+				return false
+			}
+		}
+		return true
+	case "L":
+		if len(a.l) != len(b.l) {
+			return false
+		}
+		for i := range a.l {
+			if !equal(a.l[i], b.l[i]) {
+				return false
+			}
+		}
+		return true
+	case "SS", "NS", "BS":
+		return len(a.setKeys()) == len(b.setKeys()) && subset(a, b)
+	}
+	return false
+}
+
+// comparable reports whether two values can be ordered, which is only
+// strings, numbers and binaries of the same type
+func comparable(a, b *attr) bool {
+	return a != nil && b != nil && a.kind == b.kind && (a.kind == "S" || a.kind == "N" || a.kind == "B")
+}
+
+// compare orders two values of the same scalar type: strings and binaries
+// by their bytes, numbers by value
+func compare(a, b *attr) int {
+	switch a.kind {
+	case "N":
+		x, _ := parseNumber(a.s)
+		y, _ := parseNumber(b.s)
+		return x.Cmp(y)
+	case "B":
+		return bytes.Compare(a.b, b.b)
+	}
+	return strings.Compare(a.s, b.s)
+}
+
+// setKeys returns the canonical members of a set, numbers normalized
+func (a *attr) setKeys() map[string]bool {
+	keys := map[string]bool{}
+	switch a.kind {
+	case "SS":
+		for _, s := range a.ss {
+			keys[s] = true
+		}
+	case "NS":
+		for _, s := range a.ss {
+			n, _ := parseNumber(s)
+			keys[formatNumber(n)] = true
+		}
+	case "BS":
+		for _, b := range a.bs {
+			keys[string(b)] = true
+		}
+	}
+	return keys
+}
+
+// subset reports whether every member of set a is in set b
+func subset(a, b *attr) bool {
+	members := b.setKeys()
+	for k := range a.setKeys() {
+		if !members[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOf builds a set of kind from canonical member keys
+func setOf(kind string, keys map[string]bool) *attr {
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	set := &attr{kind: kind}
+	if kind == "BS" {
+		for _, k := range sorted {
+			set.bs = append(set.bs, []byte(k))
+		}
+		return set
+	}
+	set.ss = sorted
+	return set
+}
+
+// size approximates the bytes DynamoDB bills a value at
+func (a *attr) size() int {
+	switch a.kind {
+	case "S":
+		return len(a.s)
+	case "N":
+		digits := strings.TrimLeft(strings.NewReplacer("-", "", ".", "").Replace(a.s), "0")
+		return (len(digits)+1)/2 + 1
+	case "B":
+		return len(a.b)
+	case "BOOL", "NULL":
+		return 1
+	case "M":
+		n := 3
+		for k, v := range a.m {
+			n += len(k) + v.size() + 1
+		}
+		return n
+	case "L":
+		n := 3
+		for _, v := range a.l {
+			n += v.size() + 1
+		}
+		return n
+	case "SS", "NS":
+		n := 0
+		for _, s := range a.ss {
+			n += (&attr{kind: a.kind[1:], s: s}).size()
+		}
+		return n
+	case "BS":
+		n := 0
+		for _, b := range a.bs {
+			n += len(b)
+		}
+		return n
+	}
+	return 0
+}
+
+// size is the item's size as counted against the 400 KB limit
+func (it item) size() int {
+	n := 0
+	for k, v := range it {
+		n += len(k) + v.size()
+	}
+	return n
+}
@@ -0,0 +1,212 @@
+package fixtures
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"LearnSingleTableDesign/models"
+)
+
+// UserBuilder builds test users with sensible defaults
+type UserBuilder struct {
+	user models.User
+}
+
+// NewUser starts a valid test user
+func NewUser() *UserBuilder {
+	return &UserBuilder{
+		user: models.User{
+			Email:     "test@example.com",
+			Name:      "Test User",
+			CreatedAt: time.Now(),
+		},
+	}
+}
+
+// WithEmail sets the user's email
+func (b *UserBuilder) WithEmail(email string) *UserBuilder {
+	b.user.Email = email
+	return b
+}
+
+// WithName sets the user's name
+func (b *UserBuilder) WithName(name string) *UserBuilder {
+	b.user.Name = name
+	return b
+}
+
+// Build returns the built user
+func (b *UserBuilder) Build() models.User {
+	return b.user
+}
+
+func (b *UserBuilder) seed(ctx context.Context, repos Repos) error {
+	return repos.Users.Put(ctx, b.user)
+}
+
+// OrderBuilder builds test orders with sensible defaults
+type OrderBuilder struct {
+	order models.Order
+}
+
+// NewOrderFor starts a valid pending order belonging to the user
+func NewOrderFor(user models.User) *OrderBuilder {
+	return &OrderBuilder{
+		order: models.Order{
+			OrderID:   "ORD1",
+			UserEmail: user.Email,
+			Status:    models.OrderStatusPending,
+			Total:     99.99,
+			CreatedAt: time.Now(),
+			Items:     []models.LineItem{{ProductID: "PROD1", Name: "Test Product", UnitPrice: 99.99, Quantity: 1}},
+		},
+	}
+}
+
+// WithID sets the order ID
+func (b *OrderBuilder) WithID(orderID string) *OrderBuilder {
+	b.order.OrderID = orderID
+	return b
+}
+
+// WithStatus sets the order status
+func (b *OrderBuilder) WithStatus(status models.OrderStatus) *OrderBuilder {
+	b.order.Status = status
+	return b
+}
+
+// WithTotal sets the order total. Stores work totals out from the line
+// items, so Build prices the first item to match.
+func (b *OrderBuilder) WithTotal(total float64) *OrderBuilder {
+	b.order.Total = total
+	return b
+}
+
+// WithProducts sets the order's line items to one unit of each product ID,
+// named after the ID and free
+func (b *OrderBuilder) WithProducts(productIDs ...string) *OrderBuilder {
+	b.order.Items = nil
+	for _, id := range productIDs {
+		b.order.Items = append(b.order.Items, models.LineItem{ProductID: id, Name: id, Quantity: 1})
+	}
+	return b
+}
+
+// WithCreatedAt sets when the order was created
+func (b *OrderBuilder) WithCreatedAt(createdAt time.Time) *OrderBuilder {
+	b.order.CreatedAt = createdAt
+	return b
+}
+
+// Build returns the built order, its first line item priced so the items
+// add up to its total
+func (b *OrderBuilder) Build() models.Order {
+	order := b.order
+	order.Items = slices.Clone(order.Items)
+	if len(order.Items) > 0 {
+		first := &order.Items[0]
+		others := order.ItemsTotal() - first.Subtotal()
+		first.UnitPrice = (order.Total - others) / float64(first.Quantity)
+	}
+	return order
+}
+
+func (b *OrderBuilder) seed(ctx context.Context, repos Repos) error {
+	return repos.Orders.Put(ctx, b.Build())
+}
+
+// ProductBuilder builds test products with sensible defaults
+type ProductBuilder struct {
+	product models.Product
+}
+
+// NewProduct starts a valid test product
+func NewProduct() *ProductBuilder {
+	return &ProductBuilder{
+		product: models.Product{
+			ProductID: "PROD1",
+			Name:      "Product 1",
+			Category:  "Electronics",
+			Price:     100.00,
+			Stock:     100,
+			CreatedAt: time.Now(),
+		},
+	}
+}
+
+// WithID sets the product ID
+func (b *ProductBuilder) WithID(productID string) *ProductBuilder {
+	b.product.ProductID = productID
+	return b
+}
+
+// WithName sets the product name
+func (b *ProductBuilder) WithName(name string) *ProductBuilder {
+	b.product.Name = name
+	return b
+}
+
+// WithCategory sets the product category
+func (b *ProductBuilder) WithCategory(category string) *ProductBuilder {
+	b.product.Category = category
+	return b
+}
+
+// WithPrice sets the product price
+func (b *ProductBuilder) WithPrice(price float64) *ProductBuilder {
+	b.product.Price = price
+	return b
+}
+
+// WithCurrency sets the currency of the product's price
+func (b *ProductBuilder) WithCurrency(currency string) *ProductBuilder {
+	b.product.Currency = currency
+	return b
+}
+
+// WithStock sets the product stock
+func (b *ProductBuilder) WithStock(stock int) *ProductBuilder {
+	b.product.Stock = stock
+	return b
+}
+
+// Build returns the built product
+func (b *ProductBuilder) Build() models.Product {
+	return b.product
+}
+
+func (b *ProductBuilder) seed(ctx context.Context, repos Repos) error {
+	return repos.Products.Put(ctx, b.product)
+}
+
+// Repos are the repositories Seed writes fixtures through.
+// They are interfaces so this package doesn't import repository,
+// which would create an import cycle for the repository tests.
+type Repos struct {
+	Users interface {
+		Put(ctx context.Context, user models.User) error
+	}
+	Orders interface {
+		Put(ctx context.Context, order models.Order) error
+	}
+	Products interface {
+		Put(ctx context.Context, product models.Product) error
+	}
+}
+
+// Fixture is anything Seed can store, i.e. one of the builders
+type Fixture interface {
+	seed(ctx context.Context, repos Repos) error
+}
+
+// Seed stores the fixtures in order, failing the test on the first error
+func Seed(t *testing.T, repos Repos, fixtures ...Fixture) {
+	t.Helper()
+	for _, fixture := range fixtures {
+		if err := fixture.seed(context.Background(), repos); err != nil {
+			t.Fatalf("failed to seed fixture: %v", err)
+		}
+	}
+}
@@ -3,62 +3,58 @@ package testutil
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/dynamoclient"
+	"LearnSingleTableDesign/schema"
+	"LearnSingleTableDesign/testutil/fakedynamo"
 )
 
-// CreateTestClient creates a DynamoDB client for testing
-func CreateTestClient(t *testing.T) *dynamodb.Client {
-	cfg, err := config.LoadDefaultConfig(context.Background(),
-		config.WithRegion("us-east-1"),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "test")),
-		config.WithEndpointResolverWithOptions(aws.EndpointResolverWithOptionsFunc(
-			func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: "http://localhost:8000"}, nil
-			})),
-	)
+// fake is shared by every test of a binary that uses the fake; the tests'
+// tables have unique names
+var fake = sync.OnceValue(fakedynamo.New)
+
+// CreateTestClient creates a DynamoDB client for testing.
+// Tests run against an in-process fake unless EMULATOR names an emulator,
+// dynamodb-local or localstack, in which case they use it at the
+// configured endpoint, starting it with docker compose if needed. opts
+// customise the client, e.g. to inject faults with testutil/chaos.
+func CreateTestClient(t testing.TB, opts ...dynamoclient.Option) *dynamodb.Client {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("unable to load config: %v", err)
+	}
+	cfg.Local = true
+	if cfg.Emulator == "" || cfg.Emulator == config.EmulatorFake {
+		optFns := make([]func(*dynamodb.Options), len(opts))
+		for i, opt := range opts {
+			optFns[i] = opt
+		}
+		return fake().Client(optFns...)
+	}
+	if err := StartEmulator(cfg); err != nil {
+		t.Fatalf("unable to reach the emulator: %v", err)
+	}
+
+	client, err := dynamoclient.New(context.Background(), cfg, opts...)
 	if err != nil {
 		t.Fatalf("unable to load SDK config: %v", err)
 	}
 
-	return dynamodb.NewFromConfig(cfg)
+	return client
 }
 
 // SetupTestTable creates a test table and returns its name
-func SetupTestTable(t *testing.T, client *dynamodb.Client) string {
+func SetupTestTable(t testing.TB, client *dynamodb.Client) string {
 	tableName := fmt.Sprintf("test_table_%s", uuid.New().String())
 
-	_, err := client.CreateTable(context.Background(), &dynamodb.CreateTableInput{
-		TableName: aws.String(tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("PK"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-			{
-				AttributeName: aws.String("SK"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("PK"),
-				KeyType:       types.KeyTypeHash,
-			},
-			{
-				AttributeName: aws.String("SK"),
-				KeyType:       types.KeyTypeRange,
-			},
-		},
-		BillingMode: types.BillingModePayPerRequest,
-	})
-	if err != nil {
+	if err := schema.CreateTable(context.Background(), client, tableName); err != nil {
 		t.Fatalf("unable to create test table: %v", err)
 	}
 
@@ -66,7 +62,7 @@ func SetupTestTable(t *testing.T, client *dynamodb.Client) string {
 }
 
 // CleanupTestTable deletes the test table
-func CleanupTestTable(t *testing.T, client *dynamodb.Client, tableName string) {
+func CleanupTestTable(t testing.TB, client *dynamodb.Client, tableName string) {
 	_, err := client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{
 		TableName: aws.String(tableName),
 	})
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"LearnSingleTableDesign/repository"
+)
+
+// adminPath is the prefix of every admin page
+const adminPath = "/admin"
+
+type adminKey struct{}
+
+// AdminAuth guards the admin pages with HTTP basic auth as user and
+// password. Requests anywhere that carry the credentials are the admin's,
+// and their writes are audited as made by "admin:<user>". With no password
+// the admin pages are off: no credentials are accepted.
+func AdminAuth(user, password string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if password != "" {
+				u, p, ok := r.BasicAuth()
+				if ok && subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1 && subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1 {
+					ctx := context.WithValue(r.Context(), adminKey{}, user)
+					next.ServeHTTP(w, r.WithContext(repository.WithActor(ctx, "admin:"+user)))
+					return
+				}
+			}
+			if r.URL.Path == adminPath || strings.HasPrefix(r.URL.Path, adminPath+"/") {
+				refuseAdmin(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsAdmin reports whether the request carried the admin's credentials
+func IsAdmin(ctx context.Context) bool {
+	_, ok := ctx.Value(adminKey{}).(string)
+	return ok
+}
+
+// RequireAdmin refuses requests without the admin's credentials, for admin
+// routes outside /admin such as the API's
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r.Context()) {
+			refuseAdmin(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refuseAdmin asks the browser for the admin's credentials
+func refuseAdmin(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+	http.Error(w, "admin credentials required", http.StatusUnauthorized)
+}
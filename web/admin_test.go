@@ -0,0 +1,67 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"LearnSingleTableDesign/repository"
+)
+
+func TestAdminAuth(t *testing.T) {
+	var admin bool
+	var actor string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin = IsAdmin(r.Context())
+		actor = repository.ActorFrom(r.Context())
+	})
+	handler := AdminAuth("admin", "secret")(next)
+
+	tests := []struct {
+		name       string
+		path       string
+		user, pass string
+		wantStatus int
+		wantAdmin  bool
+	}{
+		{"admin page without credentials", "/admin/pages", "", "", http.StatusUnauthorized, false},
+		{"admin root without credentials", "/admin", "", "", http.StatusUnauthorized, false},
+		{"admin page with wrong password", "/admin/pages", "admin", "guess", http.StatusUnauthorized, false},
+		{"admin page with credentials", "/admin/pages", "admin", "secret", http.StatusOK, true},
+		{"public page without credentials", "/", "", "", http.StatusOK, false},
+		{"public page with credentials", "/", "admin", "secret", http.StatusOK, true},
+		{"page that only starts with admin", "/administrivia", "", "", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin, actor = false, ""
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a basic auth challenge")
+			}
+			if admin != tt.wantAdmin {
+				t.Errorf("IsAdmin = %v, want %v", admin, tt.wantAdmin)
+			}
+			if tt.wantAdmin && actor != "admin:admin" {
+				t.Errorf("Actor = %q, want admin:admin", actor)
+			}
+		})
+	}
+
+	// Test no password turns the admin pages off
+	r := httptest.NewRequest("GET", "/admin/pages", nil)
+	r.SetBasicAuth("admin", "")
+	w := httptest.NewRecorder()
+	AdminAuth("admin", "")(next).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Status without a password = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// apiError is the body of a JSON API error. Validation failures list each
+// field, the same messages the web forms show inline:
+//
+//	{"error": "invalid request", "fields": [{"field": "email", "message": "must be an email address"}]}
+type apiError struct {
+	Error  string                  `json:"error"`
+	Fields models.ValidationErrors `json:"fields,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write JSON response: %v", err)
+	}
+}
+
+// writeAPIError answers a JSON API request that failed with err: 422 with
+// the fields for validation failures, 503 during maintenance, else 500
+func writeAPIError(w http.ResponseWriter, err error) {
+	var fields models.ValidationErrors
+	switch {
+	case errors.As(err, &fields):
+		writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: "invalid request", Fields: fields})
+	case errors.Is(err, repository.ErrReadOnly):
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "the store is read-only for maintenance"})
+	default:
+		log.Printf("API request failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: "internal error"})
+	}
+}
+
+// writeAPIBodyError answers a JSON API request whose body couldn't be
+// decoded with a 400, listing the fields that don't match the schema
+func writeAPIBodyError(w http.ResponseWriter, err error) {
+	var fields models.ValidationErrors
+	if errors.As(err, &fields) {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "the body doesn't match the API schema", Fields: fields})
+		return
+	}
+	writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+}
+
+// apiContactHandler stores a contact message sent as JSON, for scripts on
+// the site's own pages:
+//
+//	POST /api/v1/contact {"name": "...", "email": "...", "message": "..."}
+//
+// A body that doesn't match contactOperation's schema gets a 400 listing
+// what's wrong; one that does but fails validation gets a 422.
+func (a *App) apiContactHandler(w http.ResponseWriter, r *http.Request) {
+	var form contactForm
+	if err := decodeAPIBody(r, contactOperation, &form); err != nil {
+		writeAPIBodyError(w, err)
+		return
+	}
+	msg, err := a.contacts.Submit(r.Context(), form.Name, form.Email, form.Message)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil"
+)
+
+func TestWriteAPIError(t *testing.T) {
+	invalid := (&models.ContactMessage{MessageID: "m1", Name: "Ann", Email: "not an email"}).Validate()
+	if invalid == nil {
+		t.Fatal("expected the contact message to be invalid")
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantFields models.ValidationErrors
+	}{
+		{
+			name:       "validation",
+			err:        fmt.Errorf("failed to save contact message: %w", invalid),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: models.ValidationErrors{
+				{Field: "email", Message: "must be an email address"},
+				{Field: "message", Message: "is required"},
+			},
+		},
+		{name: "read-only", err: repository.ErrReadOnly, wantStatus: http.StatusServiceUnavailable},
+		{name: "other", err: errors.New("boom"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeAPIError(w, tt.err)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var body apiError
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body isn't JSON: %v", err)
+			}
+			if body.Error == "" {
+				t.Error("expected an error message")
+			}
+			if fmt.Sprint(body.Fields) != fmt.Sprint(tt.wantFields) {
+				t.Errorf("fields = %v, want %v", body.Fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+// assertMatchesSchema fails the test if body isn't what op documents for
+// status
+func assertMatchesSchema(t *testing.T, op apiOperation, status int, body []byte) {
+	t.Helper()
+	for _, resp := range op.Responses {
+		if resp.Status != status {
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(body, &value); err != nil {
+			t.Fatalf("Body isn't JSON: %v\n%s", err, body)
+		}
+		if errs := validateSchema(schemaOf(reflect.TypeOf(resp.Body)), value, ""); len(errs) > 0 {
+			t.Errorf("%d body doesn't match the spec: %v\n%s", status, errs, body)
+		}
+		return
+	}
+	t.Errorf("%s %s doesn't document a %d response", op.Method, op.Path, status)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&App{}).openAPIHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters  []struct{ Name string } `json:"parameters"`
+			Security    []map[string]any        `json:"security"`
+			RequestBody struct {
+				Content map[string]struct {
+					Schema struct {
+						Required   []string       `json:"required"`
+						Properties map[string]any `json:"properties"`
+					} `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]any `json:"responses"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Document isn't JSON: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q", doc.OpenAPI)
+	}
+
+	contact := doc.Paths["/api/v1/contact"]["post"]
+	schema := contact.RequestBody.Content["application/json"].Schema
+	if !reflect.DeepEqual(schema.Required, []string{"email", "message", "name"}) {
+		t.Errorf("Contact requires %v, want email, message and name", schema.Required)
+	}
+	for _, status := range []string{"201", "400", "422", "503"} {
+		if contact.Responses[status] == nil {
+			t.Errorf("Contact doesn't document a %s", status)
+		}
+	}
+	export := doc.Paths["/api/v1/users/{email}/export"]["get"]
+	if len(export.Parameters) != 1 || export.Parameters[0].Name != "email" || len(export.Security) != 1 {
+		t.Errorf("Export = %+v, want the email parameter and admin security", export)
+	}
+}
+
+func TestAPIContactHandler(t *testing.T) {
+	client := testutil.CreateTestClient(t)
+	tableName := testutil.SetupTestTable(t, client)
+	defer testutil.CleanupTestTable(t, client, tableName)
+	app := &App{contacts: repository.NewContactRepository(client, tableName)}
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/contact", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		app.apiContactHandler(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantFields  models.ValidationErrors
+	}{
+		{
+			name:        "valid",
+			contentType: "application/json",
+			body:        `{"name": "Ann", "email": "ann@example.com", "message": "Hello"}`,
+			wantStatus:  http.StatusCreated,
+		},
+		{
+			name:        "not the schema",
+			contentType: "application/json",
+			body:        `{"name": 7, "email": "ann@example.com", "subject": "Hi"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantFields: models.ValidationErrors{
+				{Field: "message", Message: "is required"},
+				{Field: "name", Message: "must be a string"},
+				{Field: "subject", Message: "isn't a known field"},
+			},
+		},
+		{
+			name:        "not JSON",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=Ann",
+			wantStatus:  http.StatusBadRequest,
+			wantFields:  models.ValidationErrors{{Message: "the body must be application/json"}},
+		},
+		{
+			name:        "invalid",
+			contentType: "application/json",
+			body:        `{"name": "Ann", "email": "nope", "message": ""}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantFields: models.ValidationErrors{
+				{Field: "email", Message: "must be an email address"},
+				{Field: "message", Message: "is required"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(tt.contentType, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			assertMatchesSchema(t, contactOperation, w.Code, w.Body.Bytes())
+			if tt.wantFields == nil {
+				return
+			}
+			var body apiError
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if fmt.Sprint(body.Fields) != fmt.Sprint(tt.wantFields) {
+				t.Errorf("fields = %v, want %v", body.Fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Package assets serves the app's scripts from the binary instead of a CDN,
+// so pages work offline and under a Content-Security-Policy of 'self'.
+// Each file is served under a name with its content hash, e.g.
+// /assets/app.3f2a9c1e.js, and cached for a year since the name changes
+// whenever the file does.
+//
+// Third-party scripts are pinned in Vendor and downloaded into
+// static/vendor by cmd/fetchassets. Until they are, URL points at the CDN
+// they came from.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Prefix is the URL path assets are served under
+const Prefix = "/assets/"
+
+//go:embed static
+var static embed.FS
+
+// VendorFile is a third-party file pinned to one version
+type VendorFile struct {
+	// Name is the file's name under static/vendor
+	Name string
+	// URL is the pinned download, also used while the file isn't vendored
+	URL string
+}
+
+// Vendor lists the third-party scripts BaseHTML loads. Bump a version
+// here and rerun cmd/fetchassets to upgrade.
+var Vendor = []VendorFile{
+	{Name: "tailwind.js", URL: "https://cdn.tailwindcss.com/3.4.16"},
+	{Name: "htmx.min.js", URL: "https://unpkg.com/htmx.org@1.9.10/dist/htmx.min.js"},
+}
+
+// asset is one embedded file
+type asset struct {
+	name   string
+	hashed string
+	body   []byte
+}
+
+var (
+	// byName and byHashed index the embedded files by their name under
+	// static, e.g. "vendor/htmx.min.js", and by their hashed name
+	byName   = map[string]*asset{}
+	byHashed = map[string]*asset{}
+)
+
+func init() {
+	err := fs.WalkDir(static, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		body, err := static.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		a := &asset{name: strings.TrimPrefix(p, "static/"), body: body}
+		a.hashed = hashedName(a.name, body)
+		byName[a.name] = a
+		byHashed[a.hashed] = a
+		return nil
+	})
+	if err != nil {
+		panic("assets: failed to index embedded files: " + err.Error())
+	}
+}
+
+// hashedName puts the start of the content's SHA-256 before the extension:
+// app.js becomes app.3f2a9c1e.js
+func hashedName(name string, body []byte) string {
+	sum := sha256.Sum256(body)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+}
+
+// URL returns where a page should load the named file from: its hashed
+// path when embedded, else a vendored file's pinned CDN URL, else ""
+func URL(name string) string {
+	if a, ok := byName[name]; ok {
+		return Prefix + a.hashed
+	}
+	for _, v := range Vendor {
+		if "vendor/"+v.Name == name {
+			return v.URL
+		}
+	}
+	return ""
+}
+
+// Handler serves the embedded files under Prefix. Hashed names are cached
+// as immutable; plain names also work, for tools that can't know the hash,
+// but must be revalidated.
+func Handler() http.Handler {
+	return http.StripPrefix(Prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a, ok := byHashed[r.URL.Path]
+		if ok {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else if a, ok = byName[r.URL.Path]; ok {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"`+a.hashed+`"`)
+		} else {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// ServeContent picks the Content-Type from the extension and
+		// answers If-None-Match against the ETag
+		http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(a.body))
+	}))
+}
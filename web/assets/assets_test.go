@@ -0,0 +1,64 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestURL(t *testing.T) {
+	url := URL("app.js")
+	if !strings.HasPrefix(url, Prefix+"app.") || !strings.HasSuffix(url, ".js") || url == Prefix+"app.js" {
+		t.Errorf("URL(app.js) = %q, want a hashed path", url)
+	}
+	if URL("missing.js") != "" {
+		t.Error("Expected no URL for a file that doesn't exist")
+	}
+	for _, v := range Vendor {
+		if _, vendored := byName["vendor/"+v.Name]; !vendored && URL("vendor/"+v.Name) != v.URL {
+			t.Errorf("URL(%s) = %q, want the pinned CDN URL until it is fetched", v.Name, URL("vendor/"+v.Name))
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := get(t, URL("app.js"), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("Cache-Control = %q, want immutable for a hashed name", cc)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, "javascript") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "htmx.config") {
+		t.Errorf("body = %q", w.Body.String())
+	}
+
+	w = get(t, Prefix+"app.js", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("plain name: status = %d, Cache-Control = %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	etag := w.Header().Get("ETag")
+	w = get(t, Prefix+"app.js", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status = %d, want 304", w.Code)
+	}
+
+	if w := get(t, Prefix+"nope.js", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing file: status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,21 @@
+// Runs after htmx loads, before the page's own htmx attributes are processed
+htmx.config.defaultSwapStyle = 'innerHTML';
+
+// The Content-Security-Policy doesn't allow eval, which hx-on handlers and
+// trigger filters need, so clicks are handled here by data attributes
+htmx.config.allowEval = false;
+
+document.addEventListener('click', function (event) {
+  // data-toggle shows or hides the element its aria-controls names
+  var toggle = event.target.closest('[data-toggle]');
+  if (toggle) {
+    var target = document.getElementById(toggle.getAttribute('aria-controls'));
+    target.classList.toggle('hidden');
+    toggle.setAttribute('aria-expanded', String(!target.classList.contains('hidden')));
+  }
+  // data-dismiss removes the element the button sits in, e.g. a toast
+  var dismiss = event.target.closest('[data-dismiss]');
+  if (dismiss) {
+    dismiss.parentElement.remove();
+  }
+});
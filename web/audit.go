@@ -0,0 +1,139 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// auditPageSize is how many audit entries each page shows
+const auditPageSize = 50
+
+// adminAuditHandler browses the audit log one UTC day at a time, newest
+// first. ?day= picks the day (today by default) and ?cursor= the page.
+func (a *App) adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	day := time.Now().UTC()
+	if v := r.URL.Query().Get("day"); v != "" {
+		parsed, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			http.Error(w, "day must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+	token, err := repository.ParsePageToken(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := a.audit.ByDay(r.Context(), day, &repository.QueryOptions{Limit: auditPageSize, PageToken: token})
+	if err != nil {
+		log.Printf("failed to load audit log: %v", err)
+		http.Error(w, "failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	var nextURL string
+	if page.NextPageToken != nil {
+		nextURL = auditPageURL(day, page.NextPageToken)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			auditLogComponent(day, page.Entries, nextURL),
+		),
+	).Render(w)
+}
+
+// auditPageURL links to the audit log for day, at page next if set
+func auditPageURL(day time.Time, next *repository.PageToken) string {
+	query := url.Values{"day": {day.Format(time.DateOnly)}}
+	if next != nil {
+		query.Set("cursor", next.String())
+	}
+	return "/admin/audit?" + query.Encode()
+}
+
+// auditLogComponent renders a day of audit entries with links to the
+// neighbouring days and, when nextURL is set, the next page
+func auditLogComponent(day time.Time, entries []models.AuditEntry, nextURL string) Node {
+	dayLink := func(label string, d time.Time) Node {
+		return A(Href(auditPageURL(d, nil)), Class("text-blue-600 hover:underline"), Text(label))
+	}
+
+	var rows []Node
+	for _, entry := range entries {
+		_, itemID := repository.ParseKey(entry.SK)
+		var changes []Node
+		for _, change := range entry.Changes {
+			changes = append(changes, Li(
+				Span(Class("font-mono text-gray-700"), Text(change.Field)),
+				Text(": "),
+				Span(Class("text-red-600 line-through"), Text(change.Before)),
+				Text(" "),
+				Span(Class("text-green-700"), Text(change.After)),
+			))
+		}
+		if entry.Expression != "" {
+			changes = append(changes, Li(Class("font-mono text-gray-700"), Text(entry.Expression)))
+		}
+		rows = append(rows, Tr(
+			Class("align-top border-t border-gray-100"),
+			Td(Class("py-2 pr-4 text-gray-500 whitespace-nowrap"), Text(entry.At.UTC().Format(time.TimeOnly))),
+			Td(Class("py-2 pr-4 text-gray-700"), Text(entry.Actor)),
+			Td(Class("py-2 pr-4"),
+				Div(Class("font-medium text-gray-900"), Text(fmt.Sprintf("%s %s %s", entry.Action, entry.EntityType, itemID))),
+				Div(Class("font-mono text-xs text-gray-500"), Text(entry.PK+" / "+entry.SK)),
+			),
+			Td(Class("py-2"), Ul(append([]Node{Class("space-y-1 text-xs")}, changes...)...)),
+		))
+	}
+
+	var body Node = P(Class("text-sm text-gray-500"), Text("Nothing changed on this day."))
+	if len(rows) > 0 {
+		body = Table(
+			Class("w-full text-sm"),
+			THead(Tr(
+				Class("text-left text-gray-500"),
+				Th(Class("pb-2"), Text("Time (UTC)")),
+				Th(Class("pb-2"), Text("Actor")),
+				Th(Class("pb-2"), Text("Item")),
+				Th(Class("pb-2"), Text("Changes")),
+			)),
+			TBody(rows...),
+		)
+	}
+
+	return Div(
+		Class("space-y-6"),
+		Div(
+			Class("flex justify-between items-center"),
+			H1(Class("text-2xl font-bold text-gray-900"), Text("Audit log for "+day.Format(time.DateOnly))),
+			Div(
+				Class("space-x-4 text-sm"),
+				dayLink("Previous day", day.AddDate(0, 0, -1)),
+				dayLink("Next day", day.AddDate(0, 0, 1)),
+			),
+		),
+		Div(Class("bg-white rounded-lg shadow-sm p-6"), body),
+		If(nextURL != "",
+			A(Href(nextURL), Class("text-sm text-blue-600 hover:underline"), Text("Older entries")),
+		),
+	)
+}
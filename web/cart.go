@@ -0,0 +1,306 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/web/forms"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// cartForm is a product being added to the cart
+type cartForm struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// cartLine is a product in the cart with how many of it
+type cartLine struct {
+	Product  models.Product
+	Quantity int
+}
+
+// cartOwner is the cart of the request's session, or of the user an admin
+// is viewing as. There is no sign-in yet, so every other visitor is
+// anonymous; signing in should call CartRepository.Merge to move this cart
+// into the user's.
+func cartOwner(ctx context.Context) (repository.CartOwner, bool) {
+	if imp, ok := ImpersonationFrom(ctx); ok {
+		return repository.UserCart(imp.Email), true
+	}
+	sessionID, ok := repository.SessionFrom(ctx)
+	return repository.SessionCart(sessionID), ok
+}
+
+// cartHandler shows what is in the visitor's cart, priced in their currency
+func (a *App) cartHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := cartOwner(r.Context())
+	if !ok {
+		http.Error(w, "no session", http.StatusBadRequest)
+		return
+	}
+	cart, err := a.carts.Get(r.Context(), owner)
+	if err != nil {
+		log.Printf("failed to load cart: %v", err)
+		http.Error(w, "failed to load cart", http.StatusInternalServerError)
+		return
+	}
+	lines, err := a.cartLines(r.Context(), *cart, requestCurrency(w, r))
+	if err != nil {
+		log.Printf("failed to load cart products: %v", err)
+		http.Error(w, "failed to load cart", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			cartComponent(lines, CSRFToken(r.Context()), a.canCheckout(r.Context())),
+		),
+	).Render(w)
+}
+
+// cartLines looks up the products in the cart, leaving out any that have
+// since been removed from the catalogue
+func (a *App) cartLines(ctx context.Context, cart models.Cart, currency string) ([]cartLine, error) {
+	ids := make([]string, len(cart.Items))
+	for i, item := range cart.Items {
+		ids[i] = item.ProductID
+	}
+	products, err := a.products.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []cartLine
+	for _, item := range cart.Items {
+		if product, ok := products[item.ProductID]; ok {
+			lines = append(lines, cartLine{Product: product, Quantity: item.Quantity})
+		}
+	}
+	priced := make([]models.Product, len(lines))
+	for i, line := range lines {
+		priced[i] = line.Product
+	}
+	a.priceIn(ctx, priced, currency)
+	for i := range lines {
+		lines[i].Product = priced[i]
+	}
+	return lines, nil
+}
+
+// cartAddHandler adds a product to the visitor's cart. The product cards
+// post here with htmx and get a toast back; a plain form post is
+// redirected to the cart.
+func (a *App) cartAddHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := cartOwner(r.Context())
+	if !ok {
+		http.Error(w, "no session", http.StatusBadRequest)
+		return
+	}
+	var form cartForm
+	if err := forms.Decode(r, &form); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if form.Quantity == 0 {
+		form.Quantity = 1
+	}
+
+	product, err := a.products.Get(r.Context(), form.ProductID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		_, err = a.carts.Add(r.Context(), owner, form.ProductID, form.Quantity)
+	}
+
+	kind, message := FlashSuccess, fmt.Sprintf("Added %s to your cart.", productName(product))
+	switch {
+	case errors.Is(err, repository.ErrReadOnly):
+		kind, message = FlashError, "The cart can't be changed during maintenance. Try again later."
+	case errors.Is(err, models.ErrInvalid):
+		kind, message = FlashError, "Your cart can't hold any more of that."
+	case errors.Is(err, repository.ErrInsufficientStock):
+		kind, message = FlashError, fmt.Sprintf("Sorry, there isn't enough %s left in stock.", productName(product))
+	case err != nil:
+		log.Printf("failed to add to cart: %v", err)
+		kind, message = FlashError, "Something went wrong adding that to your cart."
+	}
+
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		toastOOB(kind, message).Render(w)
+		return
+	}
+	SetFlash(w, kind, message)
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// productName is a product's name for messages, "" for no product
+func productName(product *models.Product) string {
+	if product == nil {
+		return ""
+	}
+	return product.Name
+}
+
+// cartRemoveHandler takes a product out of the visitor's cart
+func (a *App) cartRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := cartOwner(r.Context())
+	if !ok {
+		http.Error(w, "no session", http.StatusBadRequest)
+		return
+	}
+	_, err := a.carts.Remove(r.Context(), owner, r.PathValue("id"))
+	switch {
+	case errors.Is(err, repository.ErrReadOnly):
+		SetFlash(w, FlashError, "The cart can't be changed during maintenance. Try again later.")
+	case err != nil:
+		log.Printf("failed to remove from cart: %v", err)
+		SetFlash(w, FlashError, "Something went wrong updating your cart.")
+	}
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// canCheckout reports whether the visitor's cart can be turned into an
+// order. Orders belong to users, and with no sign-in yet the only carts
+// with a user are those of users an admin is viewing as.
+func (a *App) canCheckout(ctx context.Context) bool {
+	_, ok := ImpersonationFrom(ctx)
+	return ok && a.orderService != nil
+}
+
+// cartCheckoutHandler places an order for everything in the cart through
+// OrderService.Checkout and shows it. Units whose holds lapsed are taken
+// from stock again, which fails if they have since sold out.
+func (a *App) cartCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	imp, ok := ImpersonationFrom(r.Context())
+	if !ok {
+		SetFlash(w, FlashError, "Checking out needs an account, and there's no sign-in yet.")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+	order, err := a.orderService.Checkout(r.Context(), imp.Email)
+	if err == nil {
+		SetFlash(w, FlashSuccess, "Order placed. Thank you!")
+		http.Redirect(w, r, orderDetailURL(*order), http.StatusSeeOther)
+		return
+	}
+
+	switch {
+	case errors.Is(err, repository.ErrEmptyCart):
+		SetFlash(w, FlashError, "Your cart is empty.")
+	case errors.Is(err, repository.ErrInsufficientStock):
+		SetFlash(w, FlashError, "Your hold on some items ran out and they've sold out since. Remove them and try again.")
+	case errors.Is(err, repository.ErrUnknownReference):
+		SetFlash(w, FlashError, "Something in your cart is no longer sold. Remove it and try again.")
+	case errors.Is(err, repository.ErrMixedCurrencies):
+		SetFlash(w, FlashError, "Items priced in different currencies have to be ordered separately.")
+	case errors.Is(err, repository.ErrTransactionTooLarge):
+		SetFlash(w, FlashError, "Your cart has too many different items for one order.")
+	case errors.Is(err, repository.ErrConditionFailed):
+		SetFlash(w, FlashError, "Your cart or our stock kept changing while we placed the order. Try again.")
+	case errors.Is(err, repository.ErrReadOnly):
+		SetFlash(w, FlashError, "Orders can't be placed during maintenance. Try again later.")
+	default:
+		log.Printf("failed to check out: %v", err)
+		SetFlash(w, FlashError, "Something went wrong placing your order.")
+	}
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// addToCartButton adds one of a product to the cart without leaving the
+// page, showing a toast when done
+func addToCartButton(productID string) Node {
+	return Button(
+		Type("button"),
+		Class("rounded bg-blue-600 px-3 py-1.5 text-sm text-white hover:bg-blue-700"),
+		Attr("hx-post", "/cart/items"),
+		Attr("hx-vals", fmt.Sprintf(`{"product_id": %q}`, productID)),
+		Attr("hx-swap", "none"),
+		Text("Add to cart"),
+	)
+}
+
+// cartComponent lists the lines of the cart with their subtotal, and a
+// checkout button if checkout is set. The total is left out if the
+// products couldn't all be priced in one currency.
+func cartComponent(lines []cartLine, csrfToken string, checkout bool) Node {
+	if len(lines) == 0 {
+		return Div(
+			Class("space-y-4"),
+			H1(Class("text-2xl font-bold text-gray-900"), Text("Your cart")),
+			P(Class("text-gray-500"), Text("Your cart is empty. "), A(Href("/"), Class("text-blue-600 hover:underline"), Text("Browse products"))),
+		)
+	}
+
+	currency := lines[0].Product.PriceCurrency()
+	total := 0.0
+	for _, line := range lines {
+		if line.Product.PriceCurrency() != currency {
+			currency = ""
+		}
+		total += line.Product.Price * float64(line.Quantity)
+	}
+
+	return Div(
+		Class("space-y-4"),
+		H1(Class("text-2xl font-bold text-gray-900"), Text("Your cart")),
+		Table(
+			Class("min-w-full divide-y divide-gray-200 bg-white rounded-lg shadow-sm"),
+			THead(Tr(
+				Th(Class("px-4 py-2 text-left text-sm font-medium text-gray-700"), Text("Product")),
+				Th(Class("px-4 py-2 text-right text-sm font-medium text-gray-700"), Text("Quantity")),
+				Th(Class("px-4 py-2 text-right text-sm font-medium text-gray-700"), Text("Price")),
+				Th(Class("px-4 py-2")),
+			)),
+			TBody(
+				Class("divide-y divide-gray-200"),
+				Map(lines, func(line cartLine) Node {
+					return Tr(
+						Td(Class("px-4 py-2 text-sm text-gray-900"), Text(line.Product.Name)),
+						Td(Class("px-4 py-2 text-right text-sm text-gray-700"), Text(fmt.Sprint(line.Quantity))),
+						Td(Class("px-4 py-2 text-right text-sm text-gray-700"), Text(money.Format(line.Product.Price*float64(line.Quantity), line.Product.PriceCurrency()))),
+						Td(
+							Class("px-4 py-2 text-right"),
+							Form(
+								Method("post"),
+								Action("/cart/items/"+line.Product.ProductID+"/remove"),
+								csrfInput(csrfToken),
+								Button(Type("submit"), Class("text-sm text-red-600 hover:underline"), Text("Remove")),
+							),
+						),
+					)
+				}),
+			),
+		),
+		If(currency != "",
+			P(Class("text-right text-lg font-medium text-gray-900"), Text("Total: "+money.Format(total, currency))),
+		),
+		If(checkout,
+			Form(
+				Method("post"),
+				Action("/cart/checkout"),
+				Class("text-right"),
+				csrfInput(csrfToken),
+				Button(Type("submit"), Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"), Text("Check out")),
+			),
+		),
+	)
+}
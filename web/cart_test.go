@@ -0,0 +1,22 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCartCheckoutHandler_Anonymous(t *testing.T) {
+	// No order service: an anonymous visitor never reaches it
+	app := &App{}
+	r := httptest.NewRequest("POST", "/cart/checkout", nil)
+	w := httptest.NewRecorder()
+	app.cartCheckoutHandler(w, r)
+
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/cart" {
+		t.Errorf("Got %d to %q, want a redirect back to the cart", w.Code, w.Header().Get("Location"))
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != flashCookie {
+		t.Errorf("Expected a flash saying why, got %v", cookies)
+	}
+}
@@ -0,0 +1,452 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil/fixtures"
+	"LearnSingleTableDesign/web/forms"
+
+	. "maragu.dev/gomponents"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// assertGolden renders the node and compares it to testdata/<name>.golden
+func assertGolden(t *testing.T, name string, node Node) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := node.Render(&buf); err != nil {
+		t.Fatalf("Failed to render %s: %v", name, err)
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("%s does not match %s\ngot:\n%s\nwant:\n%s", name, path, buf.String(), want)
+	}
+}
+
+func TestBaseHTML_Golden(t *testing.T) {
+	assertGolden(t, "base_html", BaseHTML(context.Background(), Text("content")))
+}
+
+func TestNavbar_Golden(t *testing.T) {
+	links := navLinks([]models.Page{
+		{Slug: "about", Title: "About", Status: models.PageStatusPublished, NavLabel: "About", NavOrder: 2},
+		{Slug: "contact", Title: "Contact", Status: models.PageStatusPublished, NavLabel: "Contact", NavOrder: 1},
+		{Slug: "faq", Title: "FAQ", Status: models.PageStatusDraft, NavLabel: "FAQ"},
+		{Slug: "terms", Title: "Terms", Status: models.PageStatusPublished},
+	})
+	assertGolden(t, "navbar", Navbar(markActive(links, "/contact")))
+}
+
+func TestMarkActive(t *testing.T) {
+	links := []NavLink{{Label: "Home", Href: "/"}, {Label: "About", Href: "/about"}}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "Home"},
+		{"/about", "About"},
+		{"/about/team", "About"},
+		{"/aboutface", ""},
+		{"/products", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		for _, link := range markActive(links, tt.path) {
+			if link.Active {
+				got += link.Label
+			}
+		}
+		if got != tt.want {
+			t.Errorf("markActive(%q) marked %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if links[0].Active || links[1].Active {
+		t.Error("markActive changed the links passed in")
+	}
+}
+
+func TestMaintenanceBanner_Golden(t *testing.T) {
+	app := &App{readOnly: repository.NewReadOnlySwitch(true)}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assertGolden(t, "navbar_maintenance", app.navbarWith(r, []NavLink{{Label: "Home", Href: "/"}}))
+	assertGolden(t, "maintenance_toggle", maintenanceToggleComponent(true, "token"))
+}
+
+func TestPage_Golden(t *testing.T) {
+	page := models.Page{
+		Slug:     "about",
+		Title:    "About",
+		Markdown: "# Hello\n\nSome *markdown* with <script>alert(1)</script> raw HTML.",
+		Status:   models.PageStatusPublished,
+	}
+	assertGolden(t, "page", pageComponent(page))
+}
+
+func TestProductList_Golden(t *testing.T) {
+	products := []models.Product{
+		fixtures.NewProduct().Build(),
+		fixtures.NewProduct().WithID("PROD2").WithName("Product 2").WithCategory("Books").WithPrice(12.5).WithStock(3).Build(),
+	}
+	assertGolden(t, "product_list", productListComponent(products, nil, "", false))
+}
+
+func TestProductList_Localized_Golden(t *testing.T) {
+	products := []models.Product{fixtures.NewProduct().Build()}
+	content := map[string]models.ProductContent{
+		"PROD1": {ProductID: "PROD1", Locale: "fr", Name: "Produit 1", Description: "Un produit"},
+	}
+	assertGolden(t, "product_list_localized", productListComponent(products, content, "", false))
+}
+
+func TestProductList_Converted_Golden(t *testing.T) {
+	app := &App{converter: money.StaticRates{"USD": 1, "EUR": 0.5}}
+	products := []models.Product{
+		fixtures.NewProduct().Build(),
+		fixtures.NewProduct().WithID("PROD2").WithName("Product 2").WithPrice(12.5).WithCurrency("GBP").Build(),
+	}
+	app.priceIn(context.Background(), products, "EUR")
+	assertGolden(t, "product_list_converted", productListComponent(products, nil, "", false))
+}
+
+func TestProductList_Image_Golden(t *testing.T) {
+	product := fixtures.NewProduct().Build()
+	product.ImageURL = "/images/products/PROD1/0123456789abcdef.png"
+	assertGolden(t, "product_list_image", productListComponent([]models.Product{product}, nil, "", false))
+}
+
+func TestProductList_Empty_Golden(t *testing.T) {
+	assertGolden(t, "product_list_empty", productListComponent(nil, nil, "", false))
+}
+
+func TestProductList_NextPage_Golden(t *testing.T) {
+	products := []models.Product{fixtures.NewProduct().Build()}
+	next := repository.NewPageToken(map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: string(repository.Key.ProductPK())},
+		"SK": &types.AttributeValueMemberS{Value: string(repository.Key.ProductSK("PROD1"))},
+	})
+	assertGolden(t, "product_list_next_page", productListComponent(products, nil, productsPageURL(next), false))
+}
+
+func TestProductList_AddToCart_Golden(t *testing.T) {
+	products := []models.Product{fixtures.NewProduct().Build()}
+	assertGolden(t, "product_list_add_to_cart", productListComponent(products, nil, "", true))
+}
+
+func TestCart_Golden(t *testing.T) {
+	lines := []cartLine{
+		{Product: fixtures.NewProduct().Build(), Quantity: 2},
+		{Product: fixtures.NewProduct().WithID("PROD2").WithName("Product 2").WithPrice(12.5).Build(), Quantity: 1},
+	}
+	assertGolden(t, "cart", cartComponent(lines, "token", true))
+	assertGolden(t, "cart_empty", cartComponent(nil, "token", true))
+}
+
+func TestFeaturedCarousel_Golden(t *testing.T) {
+	products := []models.Product{
+		{ProductID: "PROD1", Name: "Laptop", Price: 999.99, Stock: 50, Category: "Electronics", Featured: true},
+		{ProductID: "PROD2", Name: "Mouse", Price: 29.99, Stock: 100, Category: "Electronics", Featured: true},
+	}
+	assertGolden(t, "featured_carousel", featuredCarouselComponent(products, nil, false))
+}
+
+func TestFeaturedCarousel_Empty(t *testing.T) {
+	if featuredCarouselComponent(nil, nil, false) != nil {
+		t.Error("expected no carousel without featured products")
+	}
+}
+
+func TestProductsSearchURL(t *testing.T) {
+	next := repository.NewPageToken(map[string]types.AttributeValue{
+		"GSI1PK": &types.AttributeValueMemberS{Value: string(repository.Key.ProductNamePK())},
+		"GSI1SK": &types.AttributeValueMemberS{Value: string(repository.Key.ProductNameSK("Desk & Chair", "PROD1"))},
+	})
+
+	got, err := url.Parse(productsSearchURL("desk & c", next))
+	if err != nil {
+		t.Fatalf("Failed to parse search URL: %v", err)
+	}
+	if q := got.Query().Get("q"); q != "desk & c" {
+		t.Errorf("q = %q, want %q", q, "desk & c")
+	}
+	token, err := repository.ParsePageToken(got.Query().Get("cursor"))
+	if err != nil {
+		t.Fatalf("Failed to parse cursor: %v", err)
+	}
+	if !reflect.DeepEqual(token.Raw(), next.Raw()) {
+		t.Errorf("cursor = %v, want %v", token.Raw(), next.Raw())
+	}
+
+	if productsSearchURL("desk", nil) != "" {
+		t.Error("Expected no URL on the last page")
+	}
+}
+
+func TestSalesReport_Golden(t *testing.T) {
+	sales := []models.DailySales{
+		{Date: "2024-03-01", OrderCount: 2, Revenue: map[string]float64{"USD": 50}},
+		{Date: "2024-03-02"},
+		{Date: "2024-03-03", OrderCount: 2, Revenue: map[string]float64{"USD": 25, "EUR": 23}},
+	}
+	app := &App{converter: money.StaticRates{"USD": 1, "EUR": 0.92}}
+	chart := app.chartRevenue(context.Background(), sales)
+	if chart[2] != 50 {
+		t.Errorf("Chart revenue = %v, want the euros converted to dollars", chart[2])
+	}
+	assertGolden(t, "sales_report", salesReportComponent(sales, chart, 30))
+}
+
+func TestDashboard_Golden(t *testing.T) {
+	d := dashboard{
+		Table:    repository.TableInfo{Name: "LearnSingleTableDesign", Status: "ACTIVE", ItemCount: 42, SizeBytes: 5 << 20},
+		Counts:   map[string]int{repository.EntityUser: 2, repository.EntityOrder: 5},
+		CountsAt: time.Date(2024, 3, 1, 15, 4, 0, 0, time.UTC),
+		Recent: []models.Order{
+			fixtures.NewOrderFor(fixtures.NewUser().Build()).Build(),
+		},
+		LowStock: []models.Product{
+			{ProductID: "PROD1", Name: "Product 1", Stock: 2, LowStockThreshold: 5},
+		},
+	}
+	assertGolden(t, "dashboard", dashboardComponent(d))
+}
+
+func TestAuditLog_Golden(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	entries := []models.AuditEntry{
+		{
+			AuditID:    "A2",
+			PK:         "PRODUCT",
+			SK:         "PRODUCT#PROD1",
+			EntityType: repository.EntityProduct,
+			Action:     models.AuditActionPut,
+			Actor:      "admin@example.com",
+			Changes:    []models.AuditChange{{Field: "data.stock", Before: "5", After: "4"}},
+			At:         day.Add(15 * time.Hour),
+		},
+		{
+			AuditID:    "A1",
+			PK:         "COUPON#SAVE10",
+			SK:         "COUPON#SAVE10",
+			EntityType: repository.EntityCoupon,
+			Action:     models.AuditActionUpdate,
+			Actor:      "system",
+			Expression: "SET #data.#redemptions = #data.#redemptions + :one",
+			At:         day.Add(9 * time.Hour),
+		},
+	}
+	assertGolden(t, "audit_log", auditLogComponent(day, entries, "/admin/audit?cursor=next&day=2024-03-01"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:       "0 B",
+		1023:    "1023 B",
+		1536:    "1.5 KiB",
+		5 << 20: "5.0 MiB",
+	}
+	for n, want := range tests {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestOrderHistory_Golden(t *testing.T) {
+	user := fixtures.NewUser().Build()
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := orderHistory{
+		Email:  user.Email,
+		Status: models.OrderStatusPending,
+		Orders: []models.Order{
+			fixtures.NewOrderFor(user).WithID("ORD2").WithTotal(25).WithCreatedAt(day.AddDate(0, 0, 1)).Build(),
+			fixtures.NewOrderFor(user).WithID("ORD1").WithTotal(9.5).WithCreatedAt(day).Build(),
+		},
+		PrevURL: orderHistoryURL(user.Email, models.OrderStatusPending, "", nil),
+		NextURL: orderHistoryURL(user.Email, models.OrderStatusPending, "next", []string{""}),
+	}
+	assertGolden(t, "order_history", orderHistoryComponent(h))
+}
+
+func TestOrderHistory_Empty_Golden(t *testing.T) {
+	h := orderHistory{Email: "a@example.com", Status: models.OrderStatusCancelled}
+	assertGolden(t, "order_history_empty", orderHistoryComponent(h))
+}
+
+func TestOrderDetail_Golden(t *testing.T) {
+	user := fixtures.NewUser().Build()
+	placed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	order := fixtures.NewOrderFor(user).WithID("ORD1").WithStatus(models.OrderStatusProcessing).WithCreatedAt(placed).Build()
+	order.Items = []models.LineItem{
+		{ProductID: "PROD1", Name: "Laptop", UnitPrice: 999.99, Quantity: 1},
+		{ProductID: "PROD2", Name: "Mouse", UnitPrice: 29.99, Quantity: 2},
+	}
+	order.Total = 1059.97
+	d := orderDetail{
+		Order: order,
+		Activity: repository.OrderActivity{
+			Payments: []models.Payment{
+				{PaymentID: "PAY1", OrderID: "ORD1", Kind: models.PaymentKindCharge, Amount: 1059.97, Status: models.PaymentStatusSucceeded, CreatedAt: placed},
+			},
+			Log: []models.OrderLogEntry{
+				{EntryID: "LOG1", OrderID: "ORD1", Event: repository.EventOrderPlaced, Status: models.OrderStatusPending, At: placed},
+				{EntryID: "LOG2", OrderID: "ORD1", Event: repository.EventOrderStatusChanged, Status: models.OrderStatusProcessing, At: placed.Add(time.Hour)},
+			},
+		},
+	}
+	assertGolden(t, "order_detail", orderDetailComponent(d))
+
+	d.Admin = true
+	d.InvoiceURL = adminOrderURL(order) + "/invoice"
+	d.Actions = true
+	d.CSRFToken = "token"
+	d.Mismatch = &repository.TotalMismatch{UserEmail: order.UserEmail, OrderID: order.OrderID, Currency: "USD", Stored: 1000, Computed: 1059.97}
+	assertGolden(t, "order_detail_admin", orderDetailComponent(d))
+}
+
+func TestOrderTimeline_WithoutLog(t *testing.T) {
+	placed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	order := models.Order{OrderID: "ORD1", Status: models.OrderStatusPending, CreatedAt: placed}
+	var buf bytes.Buffer
+	if err := orderTimeline(order, nil).Render(&buf); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("Placed")) || !bytes.Contains(buf.Bytes(), []byte("2024-03-01 12:00 UTC")) {
+		t.Errorf("expected the timeline to start when the order was created, got %s", buf.String())
+	}
+}
+
+func TestOrderHistoryURL(t *testing.T) {
+	got, err := url.Parse(orderHistoryURL("a+b@example.com", models.OrderStatusCompleted, "c3", []string{"", "c2"}))
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+	if got.Path != "/users/a+b@example.com/orders" {
+		t.Errorf("Path = %q", got.Path)
+	}
+	q := got.Query()
+	if q.Get("status") != "completed" || q.Get("cursor") != "c3" || !reflect.DeepEqual(q["back"], []string{"", "c2"}) {
+		t.Errorf("Query = %v, want the status, cursor and back trail", q)
+	}
+
+	if u := orderHistoryURL("a@example.com", "", "", nil); u != "/users/a@example.com/orders" {
+		t.Errorf("First page URL = %q, want no query", u)
+	}
+
+	// Test the back trail keeps only the latest cursors
+	long := make([]string, maxBackCursors+5)
+	for i := range long {
+		long[i] = fmt.Sprintf("c%d", i)
+	}
+	got, err = url.Parse(orderHistoryURL("a@example.com", "", "next", long))
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+	if back := got.Query()["back"]; !reflect.DeepEqual(back, long[5:]) {
+		t.Errorf("Back = %v, want the last %d cursors", back, maxBackCursors)
+	}
+}
+
+func TestContactForm_Golden(t *testing.T) {
+	form := contactForm{Name: "Ada", Email: "not-an-email", Message: "Hello"}
+	assertGolden(t, "contact_form", contactFormComponent(form, forms.Errors{"email": "must be an email address"}, "token"))
+}
+
+func TestContactMessages_Golden(t *testing.T) {
+	messages := []models.ContactMessage{
+		{MessageID: "M2", Name: "Grace", Email: "grace@example.com", Message: "Do you ship\nto Europe?", CreatedAt: time.Date(2024, 3, 2, 9, 30, 0, 0, time.UTC)},
+		{MessageID: "M1", Name: "Ada", Email: "ada@example.com", Message: "Hello", CreatedAt: time.Date(2024, 3, 1, 17, 5, 0, 0, time.UTC)},
+	}
+	assertGolden(t, "contact_messages", contactMessagesComponent(messages, "/admin/contact?cursor=next"))
+}
+
+func TestWithDefaultPages(t *testing.T) {
+	about := models.Page{Slug: "about", Title: "About us", Status: models.PageStatusPublished}
+	draft := models.Page{Slug: "contact", Title: "Contact (draft)", Status: models.PageStatusDraft}
+
+	page, ok := publishedPage([]models.Page{about, draft}, "about")
+	if !ok || page.Title != "About us" {
+		t.Errorf("about = %+v, want the published page", page)
+	}
+	page, ok = publishedPage([]models.Page{about, draft}, "contact")
+	if !ok || page.Title != "Contact" {
+		t.Errorf("contact = %+v, want the default page in place of the draft", page)
+	}
+	if _, ok := publishedPage(nil, "faq"); ok {
+		t.Error("Expected no page without a default")
+	}
+}
+
+func TestToasts_Golden(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx := context.WithValue(r.Context(), flashContextKey{}, Flash{Kind: FlashSuccess, Message: "Saved About."})
+	assertGolden(t, "toasts", toastsComponent(ctx))
+	assertGolden(t, "toast_oob", toastOOB(FlashError, "2 of 5 rows failed to import."))
+}
+
+func TestImpersonation_Golden(t *testing.T) {
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	imp := models.Impersonation{SessionID: "sess-1", Email: "ann@example.com", Admin: "session:sess-1", StartedAt: started, ExpiresAt: started.Add(impersonationTTL)}
+	assertGolden(t, "impersonation_banner", impersonationBannerComponent(imp, "token"))
+	assertGolden(t, "impersonate_form", impersonateFormComponent(impersonateForm{Email: "nobody@example.com"}, forms.Errors{"email": "No user has this email."}, "token"))
+
+	user := fixtures.NewUser().WithEmail("ann@example.com").WithName("Ann").Build()
+	user.CreatedAt = started
+	aggregate := repository.UserAggregate{
+		User: user,
+		Orders: []models.Order{
+			fixtures.NewOrderFor(user).WithID("ORD1").WithCreatedAt(started).Build(),
+			fixtures.NewOrderFor(user).WithID("ORD2").WithStatus(models.OrderStatusCompleted).WithCreatedAt(started.AddDate(0, 0, 1)).Build(),
+		},
+		Addresses: []models.Address{
+			{AddressID: "ADDR1", UserEmail: user.Email, Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"},
+		},
+	}
+	assertGolden(t, "account", accountComponent(aggregate))
+}
+
+func TestCartOwner_Impersonating(t *testing.T) {
+	ctx := repository.WithSession(context.Background(), "sess-1")
+	if owner, ok := cartOwner(ctx); !ok || owner != repository.SessionCart("sess-1") {
+		t.Errorf("cartOwner = %+v, %v, want the session's cart", owner, ok)
+	}
+	ctx = context.WithValue(ctx, impersonationKey{}, models.Impersonation{Email: "ann@example.com"})
+	if owner, ok := cartOwner(ctx); !ok || owner != repository.UserCart("ann@example.com") {
+		t.Errorf("cartOwner while impersonating = %+v, %v, want the user's cart", owner, ok)
+	}
+}
+
+func TestNotifications_Golden(t *testing.T) {
+	prefs := models.NotificationPrefs{
+		UserEmail: "ann@example.com",
+		Email:     map[models.NotificationEvent]bool{models.NotificationOrderProcessing: false},
+	}
+	assertGolden(t, "notifications", notificationsComponent(prefs, adminNotificationsURL(prefs.UserEmail), "token"))
+}
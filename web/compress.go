@@ -0,0 +1,146 @@
+package web
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// Compress gzips or deflates responses for clients that accept it. Only
+// text-like content types are compressed, and responses that can't have a
+// body (HEAD, 204, 304) or are already encoded pass through untouched.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip when both have the same weight
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name != "gzip" && name != "deflate") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a content type is worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/javascript" ||
+		mediaType == "image/svg+xml"
+}
+
+// compressWriter decides whether to compress when the status is written,
+// since that's the last moment the headers can change
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	bodyAllowed := status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+	if bodyAllowed && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		// The compressed length isn't known up front
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if cw.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.writer = gz
+		} else {
+			// flate.NewWriter only fails for invalid levels
+			cw.writer, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.writer.Write(b)
+}
+
+// Flush sends any buffered compressed data to the client
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the compressed stream
+func (cw *compressWriter) Close() error {
+	if cw.writer == nil {
+		return nil
+	}
+	err := cw.writer.Close()
+	if gz, ok := cw.writer.(*gzip.Writer); ok {
+		gzipWriters.Put(gz)
+	}
+	cw.writer = nil
+	return err
+}
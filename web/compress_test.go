@@ -0,0 +1,89 @@
+package web
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"br", ""},
+		{"*", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat("<p>hello</p>", 100)
+	handler := func(contentType string, status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Length", "1200")
+			w.WriteHeader(status)
+			if status != http.StatusNoContent {
+				io.WriteString(w, body)
+			}
+		})
+	}
+	serve := func(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		Compress(h).ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Test gzip through the pretty printer, which changes the body length
+	rec := serve(PrettyPrintHTML(handler("text/html; charset=utf-8", http.StatusNotFound)), "gzip")
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Got status %d with encoding %q, want 404 gzip", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Errorf("Expected Content-Length to be dropped, got %q", rec.Header().Get("Content-Length"))
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Failed to read gzip body: %v", err)
+	}
+	got, _ := io.ReadAll(gz)
+	if !strings.Contains(string(got), "hello") {
+		t.Errorf("Unexpected body %q", got)
+	}
+
+	// Test deflate
+	rec = serve(handler("application/json", http.StatusOK), "deflate")
+	got, _ = io.ReadAll(flate.NewReader(rec.Body))
+	if rec.Header().Get("Content-Encoding") != "deflate" || string(got) != body {
+		t.Errorf("Expected deflated body, got encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	// Test responses that shouldn't be compressed
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"not accepted":     serve(handler("text/html", http.StatusOK), ""),
+		"binary":           serve(handler("image/png", http.StatusOK), "gzip"),
+		"no content":       serve(handler("text/html", http.StatusNoContent), "gzip"),
+		"pretty no status": serve(PrettyPrintHTML(handler("text/html", http.StatusNoContent)), "gzip"),
+	} {
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s: Content-Encoding = %q, want none", name, enc)
+		}
+	}
+}
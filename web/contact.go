@@ -0,0 +1,166 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/web/forms"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// contactPageSize is how many contact messages each admin page shows
+const contactPageSize = 25
+
+// contactForm is what a visitor typed into the contact form
+type contactForm struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+// contactHandler shows the contact page with the contact form below it
+func (a *App) contactHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderContact(w, r, contactForm{}, nil, http.StatusOK)
+}
+
+// contactSubmitHandler stores a message from the contact form, then
+// redirects back so reloading the page doesn't send it twice
+func (a *App) contactSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	var form contactForm
+	if err := forms.Decode(r, &form); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	_, err := a.contacts.Submit(r.Context(), form.Name, form.Email, form.Message)
+	if errors.Is(err, repository.ErrReadOnly) {
+		a.renderContact(w, r, form, forms.Errors{"": "Messages can't be sent during maintenance. Try again later."}, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		a.renderContact(w, r, form, forms.FromError(models.ContactMessage{}, err), http.StatusUnprocessableEntity)
+		return
+	}
+	SetFlash(w, FlashSuccess, "Thanks, we got your message and will be in touch.")
+	http.Redirect(w, r, "/contact", http.StatusSeeOther)
+}
+
+func (a *App) renderContact(w http.ResponseWriter, r *http.Request, form contactForm, errs forms.Errors, status int) {
+	pages, err := a.pageCache.all(r.Context(), a.pages)
+	if err != nil {
+		log.Printf("failed to load pages: %v", err)
+	}
+	page, _ := publishedPage(pages, "contact")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbarWith(r, navLinks(pages)),
+			Div(
+				Class("space-y-8"),
+				pageComponent(page),
+				contactFormComponent(form, errs, CSRFToken(r.Context())),
+			),
+		),
+	).Render(w)
+}
+
+// contactFormComponent renders the contact form, with each field's error
+// below it
+func contactFormComponent(form contactForm, errs forms.Errors, csrfToken string) Node {
+	return Form(
+		Method("post"),
+		Action("/contact"),
+		Class("space-y-4 bg-white p-6 rounded-lg shadow-sm"),
+		csrfInput(csrfToken),
+		H2(Class("text-lg font-semibold text-gray-900"), Text("Send us a message")),
+		forms.FormError(errs),
+		forms.Field("Name", "name", forms.TextInput("name", form.Name, errs, Required()), errs),
+		forms.Field("Email", "email", Input(Type("email"), forms.InputAttrs("email", errs), Value(form.Email), Required()), errs),
+		forms.Field("Message", "message", forms.TextArea("message", form.Message, errs, Rows("6"), Required()), errs),
+		Button(
+			Type("submit"),
+			Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"),
+			Text("Send"),
+		),
+	)
+}
+
+// adminContactHandler lists contact messages newest first, ?cursor= picking
+// the page
+func (a *App) adminContactHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := repository.ParsePageToken(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := a.contacts.Recent(r.Context(), &repository.QueryOptions{Limit: contactPageSize, PageToken: token})
+	if err != nil {
+		log.Printf("failed to load contact messages: %v", err)
+		http.Error(w, "failed to load contact messages", http.StatusInternalServerError)
+		return
+	}
+
+	var nextURL string
+	if page.NextPageToken != nil {
+		nextURL = "/admin/contact?" + url.Values{"cursor": {page.NextPageToken.String()}}.Encode()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			contactMessagesComponent(page.Messages, nextURL),
+		),
+	).Render(w)
+}
+
+// contactMessagesComponent renders contact messages and, when nextURL is
+// set, a link to older ones
+func contactMessagesComponent(messages []models.ContactMessage, nextURL string) Node {
+	var body Node = P(Class("text-sm text-gray-500"), Text("No messages yet."))
+	if len(messages) > 0 {
+		body = Ul(
+			Class("divide-y divide-gray-200"),
+			Map(messages, func(msg models.ContactMessage) Node {
+				return Li(
+					Class("py-4 space-y-1"),
+					Div(
+						Class("flex justify-between text-sm"),
+						Span(
+							Span(Class("font-medium text-gray-900"), Text(msg.Name)),
+							Text(" "),
+							A(Href("mailto:"+msg.Email), Class("text-blue-600 hover:underline"), Text(msg.Email)),
+						),
+						Span(Class("text-gray-500"), Text(msg.CreatedAt.UTC().Format("2006-01-02 15:04"))),
+					),
+					P(Class("text-sm text-gray-700 whitespace-pre-line"), Text(msg.Message)),
+				)
+			}),
+		)
+	}
+
+	return Div(
+		Class("space-y-6"),
+		H1(Class("text-2xl font-bold text-gray-900"), Text("Contact messages")),
+		Div(Class("bg-white rounded-lg shadow-sm px-6"), body),
+		If(nextURL != "",
+			A(Href(nextURL), Class("text-sm text-blue-600 hover:underline"), Text("Older messages")),
+		),
+	)
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+
+	"LearnSingleTableDesign/images"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+const (
+	// csrfCookie holds the token the submitted form must echo back
+	csrfCookie = "csrf"
+	// csrfField is the hidden form field carrying the token
+	csrfField = "csrf_token"
+	// csrfHeader carries the token for requests made from scripts
+	csrfHeader = "X-CSRF-Token"
+)
+
+const (
+	// maxFormSize caps the urlencoded form bodies CSRF reads for the token
+	maxFormSize = 1 << 20
+	// maxMultipartSize caps the multipart form bodies CSRF reads for the
+	// token. It has to take the largest upload any route accepts.
+	maxMultipartSize = max(images.MaxSize, maxImportSize) + multipartOverhead
+)
+
+type csrfContextKey struct{}
+
+// CSRF protects form submissions with a double-submit token. Each visitor
+// gets a random token cookie; POST, PUT, PATCH and DELETE requests must send
+// the same token in the X-CSRF-Token header or the csrf_token form field.
+// Another site can make the browser send the cookie but cannot read it.
+// Only form bodies are read for the field, and at most maxFormSize or
+// maxMultipartSize of them; requests with other bodies must send the
+// header.
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) == 43 {
+			token = cookie.Value
+		}
+		if token == "" {
+			token = newCSRFToken()
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			sent := r.Header.Get(csrfHeader)
+			if sent == "" {
+				var err error
+				sent, err = formToken(w, r)
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				http.Error(w, "invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token)))
+	})
+}
+
+// formToken reads the csrf_token field of a form body, capping how much of
+// the body is read. Bodies that aren't forms have no field.
+func formToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+		err = r.ParseForm()
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, maxMultipartSize)
+		err = r.ParseMultipartForm(maxFormSize)
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return r.PostForm.Get(csrfField), nil
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CSRFToken returns the request's CSRF token, or "" outside the CSRF middleware
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey{}).(string)
+	return token
+}
+
+// csrfInput embeds the CSRF token in a form. htmx requests made from inside
+// the form include it too.
+func csrfInput(token string) Node {
+	return Input(Type("hidden"), Name(csrfField), Value(token))
+}
@@ -0,0 +1,99 @@
+package web
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	var got string
+	handler := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = CSRFToken(r.Context())
+	}))
+
+	// Test a GET issues a token
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/admin/pages/new", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie {
+		t.Fatalf("Expected a CSRF cookie, got %v", cookies)
+	}
+	token := cookies[0].Value
+	if got != token {
+		t.Errorf("CSRFToken() = %q, want %q", got, token)
+	}
+
+	post := func(form url.Values, header string) int {
+		r := httptest.NewRequest("POST", "/admin/pages", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookies[0])
+		if header != "" {
+			r.Header.Set(csrfHeader, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	tests := map[string]struct {
+		form   url.Values
+		header string
+		want   int
+	}{
+		"form field":    {form: url.Values{csrfField: {token}}, want: http.StatusOK},
+		"header":        {header: token, want: http.StatusOK},
+		"missing token": {want: http.StatusForbidden},
+		"wrong token":   {form: url.Values{csrfField: {"nope"}}, want: http.StatusForbidden},
+	}
+	for name, tt := range tests {
+		if code := post(tt.form, tt.header); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", name, code, tt.want)
+		}
+	}
+
+	// Test only form bodies are read for the field, and only up to a cap
+	send := func(contentType string, body string) int {
+		r := httptest.NewRequest("POST", "/admin/pages", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.AddCookie(cookies[0])
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	oversized := url.Values{csrfField: {token}, "body": {strings.Repeat("x", maxFormSize)}}.Encode()
+	if code := send("application/x-www-form-urlencoded", oversized); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized form: status = %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	mw.WriteField(csrfField, token)
+	mw.Close()
+	if code := send(mw.FormDataContentType(), multipartBody.String()); code != http.StatusOK {
+		t.Errorf("Multipart form: status = %d, want %d", code, http.StatusOK)
+	}
+	multipartBody.Reset()
+	mw = multipart.NewWriter(&multipartBody)
+	mw.WriteField("body", strings.Repeat("x", maxMultipartSize))
+	mw.WriteField(csrfField, token)
+	mw.Close()
+	if code := send(mw.FormDataContentType(), multipartBody.String()); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized multipart form: status = %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+	if code := send("application/json", `{"csrf_token": "`+token+`"}`); code != http.StatusForbidden {
+		t.Errorf("JSON without the header: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Test a POST without the cookie is rejected even with a token
+	r := httptest.NewRequest("POST", "/admin/pages", nil)
+	r.Header.Set(csrfHeader, token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("POST without cookie: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+
+	"LearnSingleTableDesign/models"
+)
+
+// currencyCookie remembers the currency a visitor picked with ?currency=
+const currencyCookie = "currency"
+
+// requestCurrency picks the currency to show a request's prices in: a
+// currency query parameter, which is remembered in a cookie, then that
+// cookie, then the currency of the region in Accept-Language. It returns ""
+// when none of them names one, leaving prices in their own currency.
+func requestCurrency(w http.ResponseWriter, r *http.Request) string {
+	if code, ok := parseCurrency(r.URL.Query().Get("currency")); ok {
+		http.SetCookie(w, &http.Cookie{
+			Name:     currencyCookie,
+			Value:    code,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return code
+	}
+	if cookie, err := r.Cookie(currencyCookie); err == nil {
+		if code, ok := parseCurrency(cookie.Value); ok {
+			return code
+		}
+	}
+
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		return ""
+	}
+	for _, tag := range tags {
+		// Only an explicit region says where the visitor is
+		if _, confidence := tag.Region(); confidence != language.Exact {
+			continue
+		}
+		if unit, confidence := currency.FromTag(tag); confidence != language.No {
+			return unit.String()
+		}
+	}
+	return ""
+}
+
+// parseCurrency returns the ISO 4217 code in s, if it is one
+func parseCurrency(s string) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+	unit, err := currency.ParseISO(s)
+	if err != nil {
+		return "", false
+	}
+	return unit.String(), true
+}
+
+// priceIn converts the products' prices to code in place. Products the
+// converter can't convert keep their own currency, so a visitor from an
+// unsupported region still sees a price.
+func (a *App) priceIn(ctx context.Context, products []models.Product, code string) {
+	if code == "" {
+		return
+	}
+	for i, product := range products {
+		price, err := a.converter.Convert(ctx, product.Price, product.PriceCurrency(), code)
+		if err != nil {
+			slog.Debug("showing product in its own currency", "product_id", product.ProductID, "error", err)
+			continue
+		}
+		products[i].Price = price
+		products[i].Currency = code
+	}
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestCurrency(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		cookie         string
+		acceptLanguage string
+		want           string
+	}{
+		{"nothing", "", "", "", ""},
+		{"language without region", "", "", "fr", ""},
+		{"region", "", "", "fr-FR,en;q=0.5", "EUR"},
+		{"later region", "", "", "en,ja-JP;q=0.5", "JPY"},
+		{"cookie beats region", "", "GBP", "fr-FR", "GBP"},
+		{"query beats cookie", "cad", "GBP", "fr-FR", "CAD"},
+		{"bad query", "dollars", "", "en-US", "USD"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/?currency="+tt.query, nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: currencyCookie, Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		if got := requestCurrency(w, r); got != tt.want {
+			t.Errorf("%s: requestCurrency = %q, want %q", tt.name, got, tt.want)
+		}
+		remembered := len(w.Result().Cookies()) > 0
+		if wantRemembered := tt.name == "query beats cookie"; remembered != wantRemembered {
+			t.Errorf("%s: cookie set = %v, want %v", tt.name, remembered, wantRemembered)
+		}
+	}
+}
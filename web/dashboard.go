@@ -0,0 +1,211 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/repository"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+const (
+	// entityCountTTL is how long the entity counts are reused; counting
+	// scans the whole table, so it mustn't run on every poll
+	entityCountTTL = 5 * time.Minute
+	// recentOrderCount is how many orders the dashboard lists
+	recentOrderCount = 10
+	// lowStockCount is how many low stock products the dashboard lists
+	lowStockCount = 10
+)
+
+// entityCountCache holds the last count of items per entity type
+type entityCountCache struct {
+	mu       sync.Mutex
+	counts   map[string]int
+	loadedAt time.Time
+}
+
+// get returns the cached counts, recounting once they've expired
+func (c *entityCountCache) get(ctx context.Context, repo *repository.TableRepository) (map[string]int, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts != nil && time.Since(c.loadedAt) < entityCountTTL {
+		return c.counts, c.loadedAt, nil
+	}
+	counts, err := repo.CountByEntityType(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	c.counts = counts
+	c.loadedAt = time.Now()
+	return counts, c.loadedAt, nil
+}
+
+// dashboard is everything the admin dashboard shows
+type dashboard struct {
+	Table    repository.TableInfo
+	Counts   map[string]int
+	CountsAt time.Time
+	Recent   []models.Order
+	LowStock []models.Product
+}
+
+func (a *App) loadDashboard(ctx context.Context) (*dashboard, error) {
+	var d dashboard
+	table, err := a.tables.Describe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.Table = *table
+	if d.Counts, d.CountsAt, err = a.entityCounts.get(ctx, a.tables); err != nil {
+		return nil, err
+	}
+	if d.Recent, err = a.orders.Recent(ctx, recentOrderCount); err != nil {
+		return nil, err
+	}
+	lowStock, err := a.products.LowStock(ctx, &repository.QueryOptions{Limit: lowStockCount})
+	if err != nil {
+		return nil, err
+	}
+	d.LowStock = lowStock.Products
+	return &d, nil
+}
+
+// adminDashboardHandler renders the dashboard page
+func (a *App) adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	d, err := a.loadDashboard(r.Context())
+	if err != nil {
+		log.Printf("failed to load dashboard: %v", err)
+		http.Error(w, "failed to load dashboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			Div(
+				Class("space-y-6"),
+				H1(Class("text-2xl font-bold text-gray-900"), Text("Dashboard")),
+				maintenanceToggleComponent(a.readOnly.On(), CSRFToken(r.Context())),
+				dashboardComponent(*d),
+			),
+		),
+	).Render(w)
+}
+
+// adminDashboardStatsHandler renders just the dashboard body for HTMX polling
+func (a *App) adminDashboardStatsHandler(w http.ResponseWriter, r *http.Request) {
+	d, err := a.loadDashboard(r.Context())
+	if err != nil {
+		log.Printf("failed to load dashboard: %v", err)
+		http.Error(w, "failed to load dashboard", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardComponent(*d).Render(w)
+}
+
+// dashboardComponent renders the dashboard body, which replaces itself
+// with a fresh copy every 10 seconds
+func dashboardComponent(d dashboard) Node {
+	entityTypes := make([]string, 0, len(d.Counts))
+	for entityType := range d.Counts {
+		entityTypes = append(entityTypes, entityType)
+	}
+	sort.Strings(entityTypes)
+
+	var countRows []Node
+	for _, entityType := range entityTypes {
+		countRows = append(countRows, Tr(
+			Td(Class("py-1 text-gray-700"), Text(entityType)),
+			Td(Class("py-1 text-right font-medium text-gray-900"), Text(strconv.Itoa(d.Counts[entityType]))),
+		))
+	}
+
+	var orderRows []Node
+	for _, order := range d.Recent {
+		orderRows = append(orderRows, Tr(
+			Td(Class("py-1 text-gray-700"), Text(order.OrderID)),
+			Td(Class("py-1 text-gray-500"), Text(order.UserEmail)),
+			Td(Class("py-1 text-gray-500"), Text(string(order.Status))),
+			Td(Class("py-1 text-right font-medium text-gray-900"), Text(money.Format(order.Total, order.PriceCurrency()))),
+			If(order.InvoiceKey != "", Td(Class("py-1 text-right"),
+				A(Href(invoicePath(order)), Class("text-blue-600 hover:underline"), Text("Invoice")),
+			)),
+		))
+	}
+
+	var stockRows []Node
+	for _, product := range d.LowStock {
+		stockRows = append(stockRows, Tr(
+			Td(Class("py-1 text-gray-700"), Text(product.Name)),
+			Td(Class("py-1 text-right font-medium text-red-600"), Text(fmt.Sprintf("%d of %d", product.Stock, product.LowStockThreshold))),
+		))
+	}
+
+	card := func(title string, body ...Node) Node {
+		return Div(
+			append([]Node{
+				Class("bg-white rounded-lg shadow-sm p-6 space-y-3"),
+				H2(Class("text-lg font-semibold text-gray-900"), Text(title)),
+			}, body...)...,
+		)
+	}
+	table := func(empty string, rows []Node) Node {
+		if len(rows) == 0 {
+			return P(Class("text-sm text-gray-500"), Text(empty))
+		}
+		return Table(Class("w-full text-sm"), TBody(rows...))
+	}
+
+	return Div(
+		ID("dashboard"),
+		Class("space-y-6"),
+		Attr("hx-get", "/admin/dashboard/stats"),
+		Attr("hx-trigger", "every 10s"),
+		Attr("hx-swap", "outerHTML"),
+		Div(
+			Class("grid grid-cols-3 gap-4"),
+			statComponent("Table status", d.Table.Status),
+			statComponent("Items", strconv.FormatInt(d.Table.ItemCount, 10)),
+			statComponent("Size", formatBytes(d.Table.SizeBytes)),
+		),
+		P(Class("text-xs text-gray-500"), Text("DynamoDB updates the item count and size about every six hours.")),
+		card("Items by entity type",
+			table("No items yet.", countRows),
+			P(Class("text-xs text-gray-500"), Text("Counted at "+d.CountsAt.Format(time.Kitchen))),
+		),
+		card("Recent orders", table("No orders in the last week.", orderRows)),
+		card("Low stock", table("Everything is in stock.", stockRows)),
+	)
+}
+
+// formatBytes renders a byte count in the largest whole unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
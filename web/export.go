@@ -0,0 +1,69 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// userExporter is implemented by user repositories that can export a
+// user's whole item collection
+type userExporter interface {
+	Export(ctx context.Context, email string, fn func(repository.ExportedItem) error) error
+}
+
+// userExportHandler streams a user's data as a JSON download for a data
+// portability request:
+//
+//	{"email": "...", "exported_at": "...", "items": [{"entity_type": "USER", "data": {...}}, ...]}
+//
+// The items are written as they are read, so a failure part way leaves the
+// document unterminated rather than looking complete. The route is wrapped
+// in RequireAdmin.
+func (a *App) userExportHandler(w http.ResponseWriter, r *http.Request) {
+	exporter, ok := a.users.(userExporter)
+	if !ok {
+		http.Error(w, "exports aren't supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	email := models.NormalizeEmail(r.PathValue("email"))
+
+	// The header goes out with the first item, so a user without items
+	// can still get a 404
+	started := false
+	err := exporter.Export(r.Context(), email, func(item repository.ExportedItem) error {
+		body, err := json.Marshal(item)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s item: %w", item.EntityType, err)
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", email+"-export.json"))
+			quoted, _ := json.Marshal(email)
+			fmt.Fprintf(w, `{"email":%s,"exported_at":"%s","items":[`, quoted, time.Now().UTC().Format(time.RFC3339))
+			started = true
+		} else {
+			w.Write([]byte(","))
+		}
+		_, err = w.Write(body)
+		return err
+	})
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		http.NotFound(w, r)
+	case err != nil && !started:
+		log.Printf("failed to export user: %v", err)
+		http.Error(w, "failed to export user", http.StatusInternalServerError)
+	case err != nil:
+		log.Printf("failed to export user part way: %v", err)
+	default:
+		w.Write([]byte("]}\n"))
+	}
+}
@@ -0,0 +1,72 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"LearnSingleTableDesign/repository"
+)
+
+// exportingUsers exports the same items for every user but missing
+type exportingUsers struct {
+	repository.Users
+	items []repository.ExportedItem
+}
+
+func (u exportingUsers) Export(ctx context.Context, email string, fn func(repository.ExportedItem) error) error {
+	if email == "missing@example.com" {
+		return repository.ErrNotFound
+	}
+	for _, item := range u.items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUserExportHandler(t *testing.T) {
+	app := &App{users: exportingUsers{items: []repository.ExportedItem{
+		{EntityType: repository.EntityUser, Data: map[string]any{"email": "a@example.com"}},
+		{EntityType: repository.EntityOrder, Data: map[string]any{"order_id": "ORD1"}},
+	}}}
+	handler := AdminAuth("admin", "secret")(RequireAdmin(http.HandlerFunc(app.userExportHandler)))
+	export := func(email string, admin bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/users/"+email+"/export", nil)
+		r.SetPathValue("email", email)
+		if admin {
+			r.SetBasicAuth("admin", "secret")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Test nobody but the admin can export a user's data
+	if w := export("a@example.com", false); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Anonymous export got %d, want 401", w.Code)
+	}
+
+	w := export("A@example.com", true)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Disposition") != `attachment; filename="a@example.com-export.json"` {
+		t.Fatalf("Got %d with headers %v", w.Code, w.Header())
+	}
+	var archive struct {
+		Email string                    `json:"email"`
+		Items []repository.ExportedItem `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &archive); err != nil {
+		t.Fatalf("Export isn't valid JSON: %v\n%s", err, w.Body)
+	}
+	assertMatchesSchema(t, exportOperation, http.StatusOK, w.Body.Bytes())
+	if archive.Email != "a@example.com" || len(archive.Items) != 2 || archive.Items[1].EntityType != repository.EntityOrder {
+		t.Errorf("Export = %+v, want both items for a@example.com", archive)
+	}
+
+	if w := export("missing@example.com", true); w.Code != http.StatusNotFound {
+		t.Errorf("Missing user got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// flashCookie carries a message to the next page the visitor loads
+const flashCookie = "flash"
+
+// FlashKind says whether a flash reports a success or an error
+type FlashKind string
+
+const (
+	FlashSuccess FlashKind = "success"
+	FlashError   FlashKind = "error"
+)
+
+// Flash is a one-off message shown as a toast
+type Flash struct {
+	Kind    FlashKind `json:"kind"`
+	Message string    `json:"message"`
+}
+
+type flashContextKey struct{}
+
+// SetFlash shows a toast on the next page the visitor loads, for handlers
+// that redirect after a write. The cookie isn't signed; a visitor can only
+// forge a message to themselves, and it is rendered as text.
+func SetFlash(w http.ResponseWriter, kind FlashKind, message string) {
+	value, err := json.Marshal(Flash{Kind: kind, Message: message})
+	if err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     "/",
+		MaxAge:   60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Flashes takes the flash set by an earlier response off the request,
+// clearing its cookie so it is shown once, and attaches it to the context
+// for FlashFrom. htmx requests leave it for the next full page, so a
+// polling fragment can't swallow it.
+func Flashes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("HX-Request") == "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cookie, err := r.Cookie(flashCookie)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: "/", MaxAge: -1})
+
+		var flash Flash
+		value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+		if err != nil || json.Unmarshal(value, &flash) != nil || flash.Message == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), flashContextKey{}, flash)))
+	})
+}
+
+// FlashFrom returns the flash to show on this page, if any
+func FlashFrom(ctx context.Context) (Flash, bool) {
+	flash, ok := ctx.Value(flashContextKey{}).(Flash)
+	return flash, ok
+}
+
+// toastsComponent is the corner of the page toasts appear in, holding the
+// request's flash if it has one. htmx responses add to it with toastOOB.
+func toastsComponent(ctx context.Context) Node {
+	var toasts []Node
+	if flash, ok := FlashFrom(ctx); ok {
+		toasts = append(toasts, toastComponent(flash))
+	}
+	return Div(
+		ID("toasts"),
+		Class("fixed top-20 right-4 z-50 space-y-2"),
+		Attr("aria-live", "polite"),
+		Group(toasts),
+	)
+}
+
+// toastComponent renders a flash with a button to dismiss it
+func toastComponent(flash Flash) Node {
+	classes, role := "bg-green-50 text-green-800 border-green-200", "status"
+	if flash.Kind == FlashError {
+		classes, role = "bg-red-50 text-red-800 border-red-200", "alert"
+	}
+	return Div(
+		Class("flex items-start gap-3 rounded-lg border px-4 py-3 text-sm shadow-sm "+classes),
+		Attr("role", role),
+		Span(Text(flash.Message)),
+		Button(
+			Type("button"),
+			Class("ml-auto opacity-60 hover:opacity-100"),
+			Attr("aria-label", "Dismiss"),
+			Data("dismiss", ""),
+			Text("×"),
+		),
+	)
+}
+
+// toastOOB adds a toast to the page from an htmx response, swapped in out
+// of band next to the fragment the response is for
+func toastOOB(kind FlashKind, message string) Node {
+	return Div(
+		Attr("hx-swap-oob", "beforeend:#toasts"),
+		toastComponent(Flash{Kind: kind, Message: message}),
+	)
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFlashes(t *testing.T) {
+	// A handler redirecting after a write sets the flash
+	w := httptest.NewRecorder()
+	SetFlash(w, FlashSuccess, "Saved <About>.")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != flashCookie {
+		t.Fatalf("Expected a flash cookie, got %v", cookies)
+	}
+
+	var got Flash
+	var ok bool
+	handler := Flashes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok = FlashFrom(r.Context())
+	}))
+	request := func(method string, htmx bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/admin/pages", nil)
+		r.AddCookie(cookies[0])
+		if htmx {
+			r.Header.Set("HX-Request", "true")
+		}
+		w := httptest.NewRecorder()
+		got, ok = Flash{}, false
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Test htmx requests and form posts leave it for the next page
+	for _, htmx := range []bool{true, false} {
+		method := http.MethodGet
+		if !htmx {
+			method = http.MethodPost
+		}
+		if w := request(method, htmx); ok || len(w.Result().Cookies()) != 0 {
+			t.Errorf("%s (htmx %v) took the flash", method, htmx)
+		}
+	}
+
+	// Test the next page shows it once and clears the cookie
+	w = request(http.MethodGet, false)
+	if !ok || got != (Flash{Kind: FlashSuccess, Message: "Saved <About>."}) {
+		t.Errorf("Flash = %+v, %v", got, ok)
+	}
+	cleared := w.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != flashCookie || cleared[0].MaxAge >= 0 {
+		t.Errorf("Expected the flash cookie to be cleared, got %v", cleared)
+	}
+
+	// Test a garbled cookie is dropped
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: flashCookie, Value: "not base64!"})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if ok {
+		t.Errorf("Garbled cookie gave flash %+v", got)
+	}
+}
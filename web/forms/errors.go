@@ -0,0 +1,115 @@
+package forms
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"LearnSingleTableDesign/models"
+)
+
+// Errors maps form field names to what is wrong with them. The "" entry
+// holds an error that isn't about one field.
+type Errors map[string]string
+
+// Error lists the fields and their messages, for logs and API responses
+func (e Errors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		if name == "" {
+			parts[i] = e[name]
+		} else {
+			parts[i] = name + " " + e[name]
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Get returns the message for a field, "" if it is valid
+func (e Errors) Get(field string) string {
+	return e[field]
+}
+
+// AsErrors returns the field errors in err, and whether it has any
+func AsErrors(err error) (Errors, bool) {
+	var errs Errors
+	if errors.As(err, &errs) {
+		return errs, true
+	}
+	return nil, false
+}
+
+// Validate runs v's Validate method, if it has one, and returns its
+// failures as Errors, or nil if v is valid
+func Validate(v any) Errors {
+	model, ok := v.(validatable)
+	if !ok {
+		return nil
+	}
+	return FromError(v, model.Validate())
+}
+
+// FromError turns an error from validating v, for example one a repository
+// returned, into Errors keyed by v's form field names. Failures of nested
+// fields, like "items[0].quantity", are reported on the top-level field.
+// An error that isn't a validation failure is kept under "".
+func FromError(v any, err error) Errors {
+	if err == nil {
+		return nil
+	}
+	var fieldErrs models.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return Errors{"": err.Error()}
+	}
+
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	errs := Errors{}
+	for _, fe := range fieldErrs {
+		name := topLevelField(fe.Field)
+		if t != nil && t.Kind() == reflect.Struct {
+			name = formName(t, name)
+		}
+		// The first failure of a field is the one worth fixing first
+		if _, seen := errs[name]; !seen {
+			errs[name] = fe.Message
+		}
+	}
+	return errs
+}
+
+// topLevelField returns the field a path like "items[0].quantity" starts
+// with, here "items"
+func topLevelField(path string) string {
+	end := strings.IndexAny(path, ".[")
+	if end < 0 {
+		return path
+	}
+	return path[:end]
+}
+
+// formName returns the form name of the field of t whose JSON name is
+// jsonName, which is how models name fields in their errors
+func formName(t reflect.Type, jsonName string) string {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			name = sf.Name
+		}
+		if name == jsonName {
+			if form := fieldName(sf); form != "" {
+				return form
+			}
+		}
+	}
+	return jsonName
+}
@@ -0,0 +1,210 @@
+// Package forms binds request bodies to models and turns validation
+// failures into per-field messages that form components render inline:
+//
+//	var page models.Page
+//	if err := forms.Bind(r, &page); err != nil {
+//		errs, ok := forms.AsErrors(err)
+//		...
+//	}
+//	forms.Field("Title", "title", forms.TextInput("title", page.Title, errs), errs)
+//
+// Form values are matched to struct fields by their form tag, else their
+// json tag, else their name lower cased; a tag of "-" skips the field.
+package forms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodyBytes bounds the bodies Bind reads
+const maxBodyBytes = 1 << 20
+
+// ErrBadBody means a body couldn't be read at all, as opposed to one whose
+// fields failed validation
+var ErrBadBody = errors.New("malformed request body")
+
+// validatable is a model that checks its own fields, like the models
+// package's types
+type validatable interface {
+	Validate() error
+}
+
+// Bind decodes the request body into dst, a pointer to a struct, and
+// validates it when it has a Validate method. Fields that can't be parsed
+// or fail validation are reported as Errors; a body that can't be read
+// wraps ErrBadBody.
+func Bind(r *http.Request, dst any) error {
+	err := Decode(r, dst)
+	errs, ok := AsErrors(err)
+	if err != nil && !ok {
+		return err
+	}
+	// Fields that couldn't be parsed keep that error over their validation
+	for name, msg := range Validate(dst) {
+		if errs == nil {
+			errs = Errors{}
+		}
+		if _, seen := errs[name]; !seen {
+			errs[name] = msg
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Decode decodes a JSON body, or else form values, into dst without
+// validating it
+func Decode(r *http.Request, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("forms: cannot decode into %T", dst)
+	}
+	r.Body = http.MaxBytesReader(nil, r.Body, maxBodyBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) && typeErr.Field != "" {
+				return Errors{typeErr.Field: "has the wrong type"}
+			}
+			return fmt.Errorf("%w: %v", ErrBadBody, err)
+		}
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBody, err)
+	}
+	return decodeValues(r.Form, rv.Elem())
+}
+
+// decodeValues sets the fields of v from form values. Fields without a
+// value are left as they are, except booleans, since an unticked checkbox
+// sends nothing.
+func decodeValues(values map[string][]string, v reflect.Value) error {
+	errs := Errors{}
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		name := fieldName(sf)
+		if !sf.IsExported() || name == "" {
+			continue
+		}
+		field := v.Field(i)
+		vals, ok := values[name]
+		if !ok && field.Kind() != reflect.Bool {
+			continue
+		}
+		if err := setField(field, vals); err != nil {
+			errs[name] = err.Error()
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// timeLayouts are the layouts time fields accept, most precise first:
+// RFC 3339, then what datetime-local and date inputs send
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04", time.DateOnly}
+
+// setField parses form values into field
+func setField(field reflect.Value, vals []string) error {
+	value := ""
+	if len(vals) > 0 {
+		value = strings.TrimSpace(vals[0])
+	}
+
+	if field.Type() == timeType {
+		if value == "" {
+			field.Set(reflect.Zero(timeType))
+			return nil
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				field.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return errors.New("must be a date")
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		field.SetBool(value == "on" || value == "true" || value == "1")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value == "" {
+			field.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value == "" {
+			field.SetUint(0)
+			return nil
+		}
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if value == "" {
+			field.SetFloat(0)
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		s := reflect.MakeSlice(field.Type(), 0, len(vals))
+		for _, v := range vals {
+			if v = strings.TrimSpace(v); v != "" {
+				s = reflect.Append(s, reflect.ValueOf(v).Convert(field.Type().Elem()))
+			}
+		}
+		field.Set(s)
+	}
+	return nil
+}
+
+// fieldName is the form name of a struct field, "" if it is skipped
+func fieldName(sf reflect.StructField) string {
+	for _, key := range []string{"form", "json"} {
+		if tag, ok := sf.Tag.Lookup(key); ok {
+			name, _, _ := strings.Cut(tag, ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return strings.ToLower(sf.Name)
+}
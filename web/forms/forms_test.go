@@ -0,0 +1,131 @@
+package forms
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"LearnSingleTableDesign/models"
+)
+
+func postForm(values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestBind_Form(t *testing.T) {
+	var page models.Page
+	err := Bind(postForm(url.Values{
+		"slug":      {"shipping-faq"},
+		"title":     {"  Shipping  "},
+		"status":    {"published"},
+		"nav_order": {"3"},
+	}), &page)
+	if err != nil {
+		t.Fatalf("Failed to bind page: %v", err)
+	}
+	want := models.Page{Slug: "shipping-faq", Title: "Shipping", Status: models.PageStatusPublished, NavOrder: 3}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("Page = %+v, want %+v", page, want)
+	}
+
+	// Test parse and validation errors are reported together, by form name
+	page = models.Page{}
+	err = Bind(postForm(url.Values{"slug": {"Not A Slug"}, "status": {"published"}, "nav_order": {"first"}}), &page)
+	errs, ok := AsErrors(err)
+	if !ok {
+		t.Fatalf("Expected field errors, got %v", err)
+	}
+	want2 := Errors{"slug": "must be lower case letters, digits and dashes", "title": "is required", "nav_order": "must be a whole number"}
+	if !reflect.DeepEqual(errs, want2) {
+		t.Errorf("Errors = %v, want %v", errs, want2)
+	}
+}
+
+func TestDecode_Types(t *testing.T) {
+	var got struct {
+		Count   int       `form:"count"`
+		Price   float64   `form:"price"`
+		Public  bool      `form:"public"`
+		Tags    []string  `form:"tag"`
+		Due     time.Time `form:"due"`
+		Skipped string    `form:"-"`
+		Kept    string
+	}
+	got.Public = true
+	got.Kept = "kept"
+	err := Decode(postForm(url.Values{
+		"count":   {"2"},
+		"price":   {"9.5"},
+		"tag":     {"a", " ", "b"},
+		"due":     {"2024-03-01"},
+		"Skipped": {"x"},
+	}), &got)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if got.Count != 2 || got.Price != 9.5 || got.Public || !reflect.DeepEqual(got.Tags, []string{"a", "b"}) ||
+		!got.Due.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || got.Skipped != "" || got.Kept != "kept" {
+		t.Errorf("Decoded %+v", got)
+	}
+
+	if err := Decode(postForm(nil), got); err == nil {
+		t.Error("Expected decoding into a non-pointer to fail")
+	}
+}
+
+func TestBind_JSON(t *testing.T) {
+	bind := func(body string) (models.Page, error) {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json; charset=utf-8")
+		var page models.Page
+		return page, Bind(r, &page)
+	}
+
+	page, err := bind(`{"slug":"about","title":"About","status":"draft"}`)
+	if err != nil || page.Title != "About" {
+		t.Errorf("Bound %+v, %v", page, err)
+	}
+	_, err = bind(`{"slug":"about","title":"About","status":"draft","nav_order":"first"}`)
+	if errs, ok := AsErrors(err); !ok || errs.Get("nav_order") == "" {
+		t.Errorf("Expected a nav_order error, got %v", err)
+	}
+	_, err = bind(`{"slug":`)
+	if !errors.Is(err, ErrBadBody) {
+		t.Errorf("Expected ErrBadBody, got %v", err)
+	}
+}
+
+func TestFromError(t *testing.T) {
+	order := models.Order{
+		OrderID:   "ORD1",
+		UserEmail: "a@example.com",
+		Status:    models.OrderStatusPending,
+		Total:     10,
+		Items:     []models.LineItem{{ProductID: "P1", Name: "Widget", Quantity: 0}},
+	}
+	err := order.Validate()
+	var fieldErrs models.ValidationErrors
+	if !errors.As(err, &fieldErrs) || !errors.Is(err, models.ErrInvalid) || fieldErrs.Get("items[0].quantity") == "" {
+		t.Fatalf("Validate() = %v, want the quantity reported by its JSON path", err)
+	}
+	errs := FromError(order, err)
+	if errs.Get("items") != "must be at least 1" {
+		t.Errorf("Errors = %v, want the nested quantity reported on items", errs)
+	}
+
+	errs = FromError(order, errors.New("boom"))
+	if errs.Get("") != "boom" || errs.Error() != "boom" {
+		t.Errorf("Errors = %v, want the error kept whole", errs)
+	}
+	if FromError(order, nil) != nil {
+		t.Error("Expected no errors without an error")
+	}
+}
@@ -0,0 +1,69 @@
+package forms
+
+import (
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// inputClass is shared by every input, with a red border when invalid
+const (
+	inputClass   = "block w-full rounded border px-3 py-2 "
+	validClass   = "border-gray-300"
+	invalidClass = "border-red-500"
+)
+
+// Field renders a labelled input with its error, if any, below it. The
+// input should be built with errs too, so it is marked invalid.
+func Field(label, name string, input Node, errs Errors) Node {
+	return Label(
+		Class("block space-y-1"),
+		Span(Class("text-sm font-medium text-gray-700"), Text(label)),
+		input,
+		FieldError(name, errs),
+	)
+}
+
+// FieldError renders the message for a field, or nothing if it is valid
+func FieldError(name string, errs Errors) Node {
+	msg := errs.Get(name)
+	if msg == "" {
+		return nil
+	}
+	return P(ID(name+"-error"), Class("text-sm text-red-600"), Text(msg))
+}
+
+// FormError renders the error that isn't about one field, if any
+func FormError(errs Errors) Node {
+	msg := errs.Get("")
+	if msg == "" {
+		return nil
+	}
+	return P(Class("text-sm text-red-600"), Attr("role", "alert"), Text(msg))
+}
+
+// InputAttrs are the attributes of an input named name: its name, class
+// and, when it is invalid, aria-invalid pointing at its error
+func InputAttrs(name string, errs Errors) Node {
+	if errs.Get(name) == "" {
+		return Group{Name(name), Class(inputClass + validClass)}
+	}
+	return Group{
+		Name(name),
+		Class(inputClass + invalidClass),
+		Aria("invalid", "true"),
+		Aria("describedby", name+"-error"),
+	}
+}
+
+// TextInput renders a text input named name holding value
+func TextInput(name, value string, errs Errors, attrs ...Node) Node {
+	return Input(Type("text"), InputAttrs(name, errs), Value(value), Group(attrs))
+}
+
+// TextArea renders a textarea named name holding value
+func TextArea(name, value string, errs Errors, attrs ...Node) Node {
+	return Textarea(InputAttrs(name, errs), Group(attrs), Text(value))
+}
@@ -0,0 +1,155 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"LearnSingleTableDesign/images"
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// multipartOverhead is room for a form's other fields and part headers on
+// top of the size limit of the file it uploads
+const multipartOverhead = 64 << 10
+
+// imageHandler serves a stored image. Image keys change with their
+// content, so browsers may cache them for good.
+func (a *App) imageHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	body, err := a.images.Get(r.Context(), key)
+	if errors.Is(err, images.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get image: %v", err)
+		http.Error(w, "failed to get image", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", images.ContentType(key))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(body)
+}
+
+// adminProductImageHandler renders the image upload form for a product
+func (a *App) adminProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	product, err := a.products.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to load product: %v", err)
+		http.Error(w, "failed to load product", http.StatusInternalServerError)
+		return
+	}
+	a.renderProductImageForm(w, r, *product, "", http.StatusOK)
+}
+
+// adminUploadProductImageHandler stores the uploaded image and points the
+// product at it
+func (a *App) adminUploadProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	product, err := a.products.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to load product: %v", err)
+		http.Error(w, "failed to load product", http.StatusInternalServerError)
+		return
+	}
+	// Refuse oversized uploads before reading them all
+	r.Body = http.MaxBytesReader(w, r.Body, images.MaxSize+multipartOverhead)
+	file, _, err := r.FormFile("image")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		a.renderProductImageForm(w, r, *product, images.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		a.renderProductImageForm(w, r, *product, "Choose an image to upload.", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	imageURL, err := images.Upload(r.Context(), a.images, product.ProductID, file)
+	switch {
+	case errors.Is(err, images.ErrTooLarge):
+		a.renderProductImageForm(w, r, *product, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, images.ErrUnsupportedType):
+		a.renderProductImageForm(w, r, *product, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		log.Printf("failed to upload image: %v", err)
+		http.Error(w, "failed to upload image", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the URL is written, so stock taken during the upload is kept
+	product.ImageURL = imageURL
+	err = a.products.SetImageURL(r.Context(), product.ProductID, imageURL)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, repository.ErrReadOnly) {
+		a.renderProductImageForm(w, r, *product, "Products can't be saved during maintenance. Try again later.", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to save product: %v", err)
+		http.Error(w, "failed to save product", http.StatusInternalServerError)
+		return
+	}
+	SetFlash(w, FlashSuccess, "Image uploaded.")
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+func (a *App) renderProductImageForm(w http.ResponseWriter, r *http.Request, product models.Product, formError string, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			productImageFormComponent(product, formError, CSRFToken(r.Context())),
+		),
+	).Render(w)
+}
+
+// productImageFormComponent shows a product's image with a form to replace it
+func productImageFormComponent(product models.Product, formError, csrfToken string) Node {
+	return Form(
+		Method("post"),
+		EncType("multipart/form-data"),
+		Class("space-y-4 bg-white p-6 rounded-lg shadow-sm"),
+		csrfInput(csrfToken),
+		H1(Class("text-2xl font-bold text-gray-900"), Text(product.Name+" image")),
+		If(formError != "",
+			P(Class("text-sm text-red-600"), Text(formError)),
+		),
+		If(product.ImageURL != "",
+			Img(Src(product.ImageURL), Alt(product.Name), Class("h-48 rounded object-cover")),
+		),
+		If(product.ImageURL == "",
+			P(Class("text-sm text-gray-500"), Text("No image yet.")),
+		),
+		Input(Type("file"), Name("image"), Accept("image/jpeg,image/png,image/gif,image/webp"), Required()),
+		Button(
+			Type("submit"),
+			Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"),
+			Text("Upload"),
+		),
+	)
+}
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"LearnSingleTableDesign/images"
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// oneProductCatalog holds a single product
+type oneProductCatalog struct {
+	repository.Catalog
+	product models.Product
+}
+
+func (c oneProductCatalog) Get(ctx context.Context, productID string) (*models.Product, error) {
+	if productID != c.product.ProductID {
+		return nil, repository.ErrNotFound
+	}
+	return &c.product, nil
+}
+
+func TestAdminUploadProductImageHandler_TooLarge(t *testing.T) {
+	dir := t.TempDir()
+	app := &App{
+		products: oneProductCatalog{product: models.Product{ProductID: "PROD1", Name: "Mug"}},
+		images:   images.Dir(dir),
+		readOnly: repository.NewReadOnlySwitch(false),
+		// Loaded and empty, so the navbar doesn't read pages
+		pageCache: &pageCache{pages: []models.Page{}, loadedAt: time.Now()},
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("image", "huge.png")
+	part.Write(make([]byte, images.MaxSize+multipartOverhead))
+	form.Close()
+	r := httptest.NewRequest("POST", "/admin/products/PROD1/image", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.SetPathValue("id", "PROD1")
+	w := httptest.NewRecorder()
+	app.adminUploadProductImageHandler(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status = %d, want 413", w.Code)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Stored %d files, want none", len(entries))
+	}
+}
@@ -0,0 +1,282 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/web/forms"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// impersonationTTL is how long an admin views the store as a user before
+// it ends on its own
+const impersonationTTL = time.Hour
+
+// accountOrderCount is how many recent orders the account page lists
+const accountOrderCount = 10
+
+// impersonatePath is where admins start and stop viewing as a user. Its
+// posts are the only writes allowed while viewing as one.
+const impersonatePath = "/admin/impersonate"
+
+type impersonationKey struct{}
+
+// ImpersonationFrom returns who the request's session is viewing the store
+// as, if anyone
+func ImpersonationFrom(ctx context.Context) (models.Impersonation, bool) {
+	imp, ok := ctx.Value(impersonationKey{}).(models.Impersonation)
+	return imp, ok
+}
+
+// Impersonation attaches the session's impersonation, if it has one, to
+// the request context. Viewing as a user is for looking only, so every
+// other write is refused until the admin stops.
+func (a *App) Impersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID, ok := repository.SessionFrom(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		imp, err := a.impersonations.Get(r.Context(), sessionID)
+		if err != nil {
+			if !errors.Is(err, repository.ErrNotFound) {
+				log.Printf("failed to load impersonation: %v", err)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !strings.HasPrefix(r.URL.Path, impersonatePath) {
+			const message = "You're viewing the store as someone else, so changes are blocked. Stop viewing as them first."
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				toastOOB(FlashError, message).Render(w)
+				return
+			}
+			SetFlash(w, FlashError, message)
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), impersonationKey{}, *imp)))
+	})
+}
+
+// impersonateForm is the user an admin wants to view the store as
+type impersonateForm struct {
+	Email string `json:"email"`
+}
+
+// adminImpersonateHandler shows the form for viewing the store as a user
+func (a *App) adminImpersonateHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderImpersonate(w, r, impersonateForm{}, nil, http.StatusOK)
+}
+
+// adminImpersonateStartHandler starts viewing the store as a user and
+// shows their account
+func (a *App) adminImpersonateStartHandler(w http.ResponseWriter, r *http.Request) {
+	var form impersonateForm
+	if err := forms.Decode(r, &form); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	sessionID, ok := repository.SessionFrom(r.Context())
+	if !ok {
+		http.Error(w, "no session", http.StatusBadRequest)
+		return
+	}
+
+	_, err := a.users.Get(r.Context(), models.NormalizeEmail(form.Email))
+	if err == nil {
+		_, err = a.impersonations.Start(r.Context(), sessionID, form.Email, impersonationTTL)
+	}
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		a.renderImpersonate(w, r, form, forms.Errors{"email": "No user has this email."}, http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, repository.ErrReadOnly):
+		a.renderImpersonate(w, r, form, forms.Errors{"": "Viewing as a user can't be started during maintenance, since it has to be logged."}, http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, models.ErrInvalid):
+		a.renderImpersonate(w, r, form, forms.FromError(form, err), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		log.Printf("failed to start impersonation: %v", err)
+		http.Error(w, "failed to start impersonation", http.StatusInternalServerError)
+		return
+	}
+	SetFlash(w, FlashSuccess, "You're now viewing the store as "+models.NormalizeEmail(form.Email)+".")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// adminImpersonateStopHandler goes back to browsing as yourself
+func (a *App) adminImpersonateStopHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := repository.SessionFrom(r.Context())
+	if !ok {
+		http.Error(w, "no session", http.StatusBadRequest)
+		return
+	}
+	if err := a.impersonations.Stop(r.Context(), sessionID); err != nil {
+		log.Printf("failed to stop impersonation: %v", err)
+		http.Error(w, "failed to stop impersonation", http.StatusInternalServerError)
+		return
+	}
+	SetFlash(w, FlashSuccess, "You're browsing as yourself again.")
+	http.Redirect(w, r, impersonatePath, http.StatusSeeOther)
+}
+
+func (a *App) renderImpersonate(w http.ResponseWriter, r *http.Request, form impersonateForm, errs forms.Errors, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			impersonateFormComponent(form, errs, CSRFToken(r.Context())),
+		),
+	).Render(w)
+}
+
+// impersonateFormComponent asks which user to view the store as
+func impersonateFormComponent(form impersonateForm, errs forms.Errors, csrfToken string) Node {
+	return Form(
+		Method("post"),
+		Action(impersonatePath),
+		Class("space-y-4 bg-white p-6 rounded-lg shadow-sm"),
+		csrfInput(csrfToken),
+		H1(Class("text-2xl font-bold text-gray-900"), Text("View as user")),
+		P(
+			Class("text-sm text-gray-500"),
+			Text("See the store, including their orders, cart and addresses, the way a user does. Changes are blocked while you do, and starting and stopping are recorded in the audit log."),
+		),
+		forms.FormError(errs),
+		forms.Field("Email", "email", Input(Type("email"), forms.InputAttrs("email", errs), Value(form.Email), Required()), errs),
+		Button(
+			Type("submit"),
+			Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"),
+			Text("View as user"),
+		),
+	)
+}
+
+// accountHandler shows the profile, addresses and recent orders of the
+// user being viewed as; the navbar's cart link shows their cart. There is
+// no sign-in yet, so there is no account to show otherwise.
+func (a *App) accountHandler(w http.ResponseWriter, r *http.Request) {
+	imp, ok := ImpersonationFrom(r.Context())
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	aggregate, err := a.users.GetUserWithOrders(r.Context(), imp.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to load account: %v", err)
+		http.Error(w, "failed to load account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			accountComponent(*aggregate),
+		),
+	).Render(w)
+}
+
+// accountComponent renders a user's profile, addresses and most recent
+// orders
+func accountComponent(aggregate repository.UserAggregate) Node {
+	orders := append([]models.Order(nil), aggregate.Orders...)
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.After(orders[j].CreatedAt) })
+	if len(orders) > accountOrderCount {
+		orders = orders[:accountOrderCount]
+	}
+
+	var addresses Node = P(Class("text-sm text-gray-500"), Text("No addresses saved."))
+	if len(aggregate.Addresses) > 0 {
+		addresses = Ul(
+			Class("space-y-2 text-sm text-gray-700"),
+			Map(aggregate.Addresses, func(addr models.Address) Node {
+				var lines []string
+				for _, line := range []string{addr.Line1, addr.Line2, addr.City + " " + addr.PostalCode, addr.Country} {
+					if line != "" {
+						lines = append(lines, line)
+					}
+				}
+				return Li(Text(strings.Join(lines, ", ")))
+			}),
+		)
+	}
+
+	var recent Node = P(Class("text-sm text-gray-500"), Text("No orders yet."))
+	if len(orders) > 0 {
+		recent = Ul(
+			Class("divide-y divide-gray-100 text-sm"),
+			Map(orders, func(order models.Order) Node {
+				return Li(
+					Class("flex justify-between items-center py-2"),
+					A(Href(orderDetailURL(order)), Class("font-mono text-blue-600 hover:underline"), Text(order.OrderID)),
+					Span(Class("text-gray-500"), Text(order.CreatedAt.UTC().Format(time.DateOnly))),
+					orderStatusBadge(order.Status),
+				)
+			}),
+		)
+	}
+
+	user := aggregate.User
+	return Div(
+		Class("space-y-6"),
+		H1(Class("text-2xl font-bold text-gray-900"), Text(user.Name)),
+		P(Class("text-sm text-gray-500"), Text(user.Email+" · customer since "+user.CreatedAt.UTC().Format(time.DateOnly))),
+		Div(
+			Class("bg-white rounded-lg shadow-sm p-6 space-y-2"),
+			H2(Class("text-lg font-semibold text-gray-900"), Text("Addresses")),
+			addresses,
+		),
+		Div(
+			Class("bg-white rounded-lg shadow-sm p-6 space-y-2"),
+			Div(
+				Class("flex justify-between items-center"),
+				H2(Class("text-lg font-semibold text-gray-900"), Text("Recent orders")),
+				A(Href(orderHistoryURL(user.Email, "", "", nil)), Class("text-sm text-blue-600 hover:underline"), Text("All orders")),
+			),
+			recent,
+		),
+		A(Href(adminNotificationsURL(user.Email)), Class("text-sm text-blue-600 hover:underline"), Text("Notification settings")),
+	)
+}
+
+// impersonationBannerComponent reminds an admin whose store they're
+// looking at, with a button to stop
+func impersonationBannerComponent(imp models.Impersonation, csrfToken string) Node {
+	return Form(
+		ID("impersonation-banner"),
+		Method("post"),
+		Action(impersonatePath+"/stop"),
+		Class("flex items-center justify-center gap-3 bg-purple-100 border-b border-purple-300 px-4 py-2 text-sm text-purple-900"),
+		Attr("role", "status"),
+		csrfInput(csrfToken),
+		Span(Text("You're viewing the store as "+imp.Email+" until "+imp.ExpiresAt.UTC().Format("15:04")+" UTC. Changes are blocked.")),
+		Button(Type("submit"), Class("font-medium underline"), Text("Stop viewing")),
+	)
+}
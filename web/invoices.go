@@ -0,0 +1,42 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// invoicePath is the app URL that redirects to an order's invoice
+func invoicePath(order models.Order) string {
+	return "/admin/orders/" + url.PathEscape(order.UserEmail) + "/" + url.PathEscape(order.OrderID) + "/invoice"
+}
+
+// adminInvoiceHandler redirects to a short-lived download URL for an
+// order's invoice
+func (a *App) adminInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	order, err := a.orders.Get(r.Context(), r.PathValue("email"), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get order: %v", err)
+		http.Error(w, "failed to get order", http.StatusInternalServerError)
+		return
+	}
+	link, err := a.invoices.URL(r.Context(), *order)
+	if err != nil {
+		log.Printf("failed to sign invoice URL: %v", err)
+		http.Error(w, "failed to get invoice", http.StatusInternalServerError)
+		return
+	}
+	if link == "" {
+		http.Error(w, "order has no invoice yet", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+}
@@ -0,0 +1,38 @@
+package web
+
+import (
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// defaultLocale is the last resort when no requested locale has content
+const defaultLocale = "en"
+
+// requestLocales builds the locale fallback chain for a request from its
+// Accept-Language header: each tag in preference order followed by its base
+// language, ending with the default locale. e.g. "fr-CA,de" gives
+// [fr-CA fr de en].
+func requestLocales(r *http.Request) []string {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		tags = nil
+	}
+
+	var locales []string
+	seen := make(map[string]bool)
+	add := func(locale string) {
+		if !seen[locale] {
+			seen[locale] = true
+			locales = append(locales, locale)
+		}
+	}
+	for _, tag := range tags {
+		add(tag.String())
+		if base, confidence := tag.Base(); confidence != language.No {
+			add(base.String())
+		}
+	}
+	add(defaultLocale)
+	return locales
+}
@@ -0,0 +1,27 @@
+package web
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRequestLocales(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           []string
+	}{
+		{"", []string{"en"}},
+		{"fr-CA,fr;q=0.9,de;q=0.5", []string{"fr-CA", "fr", "de", "en"}},
+		{"de;q=0.5,es", []string{"es", "de", "en"}},
+		{"not a locale!", []string{"en"}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+		if got := requestLocales(r); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("requestLocales(%q) = %v, want %v", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
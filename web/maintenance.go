@@ -0,0 +1,86 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// navbar renders the navbar, under a maintenance banner while the store is
+// read-only, and the request's toasts
+func (a *App) navbar(r *http.Request) Node {
+	return a.navbarWith(r, a.navLinks(r.Context()))
+}
+
+// navbarWith renders the navbar with links, marking the one for r active.
+// The cart is linked last when carts are on, after the account of the
+// user an admin is viewing as.
+func (a *App) navbarWith(r *http.Request, links []NavLink) Node {
+	imp, viewingAs := ImpersonationFrom(r.Context())
+	if viewingAs {
+		links = append(links, NavLink{Label: "Account", Href: "/account"})
+	}
+	if a.carts != nil {
+		links = append(links, NavLink{Label: "Cart", Href: "/cart"})
+	}
+	return Group{
+		If(a.readOnly.On(), maintenanceBannerComponent()),
+		If(viewingAs, impersonationBannerComponent(imp, CSRFToken(r.Context()))),
+		Navbar(markActive(links, r.URL.Path)),
+		toastsComponent(r.Context()),
+	}
+}
+
+// maintenanceBannerComponent tells visitors that changes are paused
+func maintenanceBannerComponent() Node {
+	return Div(
+		ID("maintenance-banner"),
+		Class("bg-amber-100 border-b border-amber-300 px-4 py-2 text-center text-sm text-amber-900"),
+		Attr("role", "status"),
+		Text("We're doing some maintenance. You can browse as usual, but changes are paused for now."),
+	)
+}
+
+// maintenanceToggleComponent lets admins turn read-only mode on and off
+func maintenanceToggleComponent(on bool, csrfToken string) Node {
+	label, next, status := "Start maintenance", "true", "Writes are allowed."
+	if on {
+		label, next, status = "End maintenance", "false", "The store is read-only: every write is refused."
+	}
+	return Form(
+		Method("post"),
+		Action("/admin/maintenance"),
+		Class("flex items-center justify-between bg-white rounded-lg shadow-sm p-6"),
+		csrfInput(csrfToken),
+		Input(Type("hidden"), Name("read_only"), Value(next)),
+		P(Class("text-sm text-gray-700"), Text(status)),
+		Button(
+			Type("submit"),
+			Class("rounded bg-amber-600 px-4 py-2 text-white hover:bg-amber-700"),
+			Text(label),
+		),
+	)
+}
+
+// adminMaintenanceHandler turns read-only mode on or off
+func (a *App) adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	on, err := strconv.ParseBool(r.PostFormValue("read_only"))
+	if err != nil {
+		http.Error(w, "invalid read_only value", http.StatusBadRequest)
+		return
+	}
+	a.readOnly.Set(on)
+	slog.Info("maintenance mode changed", "read_only", on)
+	if on {
+		SetFlash(w, FlashSuccess, "Maintenance started: the store is read-only.")
+	} else {
+		SetFlash(w, FlashSuccess, "Maintenance ended: writes are allowed again.")
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
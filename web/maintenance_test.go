@@ -0,0 +1,33 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"LearnSingleTableDesign/repository"
+)
+
+func TestAdminMaintenanceHandler(t *testing.T) {
+	app := &App{readOnly: repository.NewReadOnlySwitch(false)}
+	post := func(value string) *httptest.ResponseRecorder {
+		body := url.Values{"read_only": {value}}.Encode()
+		r := httptest.NewRequest("POST", "/admin/maintenance", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.adminMaintenanceHandler(w, r)
+		return w
+	}
+
+	if w := post("true"); w.Code != http.StatusSeeOther || !app.readOnly.On() {
+		t.Errorf("Turning maintenance on = %d, read-only %v; want a redirect and read-only", w.Code, app.readOnly.On())
+	}
+	if w := post("false"); w.Code != http.StatusSeeOther || app.readOnly.On() {
+		t.Errorf("Turning maintenance off = %d, read-only %v; want a redirect and writable", w.Code, app.readOnly.On())
+	}
+	if w := post("maybe"); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid value = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/renderer/html"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// markdownRenderer lets raw HTML through so authors can use simple inline
+// markup; everything it produces goes through markdownPolicy before rendering
+var markdownRenderer = goldmark.New(
+	goldmark.WithRendererOptions(html.WithUnsafe()),
+)
+
+// markdownPolicy strips scripts, event handlers and other unsafe markup
+var markdownPolicy = bluemonday.UGCPolicy()
+
+// markdown renders user-authored markdown to sanitized HTML. It is used for
+// CMS pages and product descriptions.
+func markdown(source string) Node {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(source), &buf); err != nil {
+		return P(Text(source))
+	}
+	return Raw(markdownPolicy.Sanitize(buf.String()))
+}
+
+// markdownPreviewHandler renders the posted markdown as an HTML fragment
+// for the live preview in the admin editors
+func (a *App) markdownPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	markdown(r.PostForm.Get("markdown")).Render(w)
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMarkdown_Sanitizes(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    string
+		notWant string
+	}{
+		{"**bold**", "<strong>bold</strong>", ""},
+		{"<script>alert(1)</script>", "", "<script"},
+		{`<a href="javascript:alert(1)">x</a>`, "", "javascript:"},
+		{`<img src="x.png" onerror="alert(1)">`, `<img src="x.png"`, "onerror"},
+		{"<em>inline html</em>", "<em>inline html</em>", ""},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := markdown(tt.source).Render(&buf); err != nil {
+			t.Fatalf("Failed to render %q: %v", tt.source, err)
+		}
+		got := buf.String()
+		if tt.want != "" && !strings.Contains(got, tt.want) {
+			t.Errorf("markdown(%q) = %q, want it to contain %q", tt.source, got, tt.want)
+		}
+		if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+			t.Errorf("markdown(%q) = %q, want it not to contain %q", tt.source, got, tt.notWant)
+		}
+	}
+}
+
+func TestMarkdownPreviewHandler(t *testing.T) {
+	form := url.Values{"markdown": {"# Title\n\n<script>x</script>"}}
+	r := httptest.NewRequest("POST", "/admin/markdown/preview", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	(&App{}).markdownPreviewHandler(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("Status = %d, want 200", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "<h1>Title</h1>") || strings.Contains(body, "<script") {
+		t.Errorf("Unexpected preview body %q", body)
+	}
+}
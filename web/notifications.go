@@ -0,0 +1,180 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/web/forms"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// notificationsForm lists the events a user ticked to be emailed about
+type notificationsForm struct {
+	Email []models.NotificationEvent `json:"email"`
+}
+
+// notificationsHandler shows the settings of the user a signed link in an
+// order email was sent to. There is no customer sign-in, so the link is
+// what shows the visitor is that user.
+func (a *App) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	email, ok := a.linkedUser(r)
+	if !ok {
+		http.Error(w, "invalid notification settings link", http.StatusForbidden)
+		return
+	}
+	a.renderNotifications(w, r, email, a.settingsLinks.Path(email))
+}
+
+// notificationsSaveHandler saves the settings of the user a signed link in
+// an order email was sent to
+func (a *App) notificationsSaveHandler(w http.ResponseWriter, r *http.Request) {
+	email, ok := a.linkedUser(r)
+	if !ok {
+		http.Error(w, "invalid notification settings link", http.StatusForbidden)
+		return
+	}
+	a.saveNotifications(w, r, email, a.settingsLinks.Path(email))
+}
+
+// adminNotificationsHandler shows a user's settings to the admin, for
+// changing them on the user's behalf
+func (a *App) adminNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	email := models.NormalizeEmail(r.PathValue("email"))
+	a.renderNotifications(w, r, email, adminNotificationsURL(email))
+}
+
+// adminNotificationsSaveHandler saves a user's settings for the admin
+func (a *App) adminNotificationsSaveHandler(w http.ResponseWriter, r *http.Request) {
+	email := models.NormalizeEmail(r.PathValue("email"))
+	a.saveNotifications(w, r, email, adminNotificationsURL(email))
+}
+
+// linkedUser returns the user whose signed settings link the request
+// carries
+func (a *App) linkedUser(r *http.Request) (string, bool) {
+	email := models.NormalizeEmail(r.URL.Query().Get("email"))
+	if email == "" || !a.settingsLinks.Verify(email, r.URL.Query().Get("sig")) {
+		return "", false
+	}
+	return email, true
+}
+
+// renderNotifications shows which emails a user gets, with a checkbox to
+// turn each off, in a form posting to action
+func (a *App) renderNotifications(w http.ResponseWriter, r *http.Request, email, action string) {
+	if _, err := a.users.Get(r.Context(), email); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("failed to load user: %v", err)
+		http.Error(w, "failed to load user", http.StatusInternalServerError)
+		return
+	}
+	prefs, err := a.notificationPrefs.Get(r.Context(), email)
+	if err != nil {
+		log.Printf("failed to load notification prefs: %v", err)
+		http.Error(w, "failed to load notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			notificationsComponent(*prefs, action, CSRFToken(r.Context())),
+		),
+	).Render(w)
+}
+
+// saveNotifications stores the ticked events, turning the emails about
+// every other event off, and goes back to the settings at action
+func (a *App) saveNotifications(w http.ResponseWriter, r *http.Request, email, action string) {
+	var form notificationsForm
+	if err := forms.Decode(r, &form); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if _, err := a.users.Get(r.Context(), email); errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+
+	prefs := models.NotificationPrefs{UserEmail: email, Email: map[models.NotificationEvent]bool{}}
+	for _, event := range models.NotificationEvents {
+		prefs.Email[event] = slices.Contains(form.Email, event)
+	}
+	err := a.notificationPrefs.Put(r.Context(), prefs)
+	switch {
+	case err == nil:
+		SetFlash(w, FlashSuccess, "Saved your notification settings.")
+	case errors.Is(err, repository.ErrReadOnly):
+		SetFlash(w, FlashError, "Settings can't be changed during maintenance. Try again later.")
+	default:
+		log.Printf("failed to save notification prefs: %v", err)
+		SetFlash(w, FlashError, "Something went wrong saving your settings.")
+	}
+	http.Redirect(w, r, action, http.StatusSeeOther)
+}
+
+// adminNotificationsURL links to the admin page of a user's notification
+// settings
+func adminNotificationsURL(email string) string {
+	return "/admin/users/" + url.PathEscape(email) + "/notifications"
+}
+
+// notificationLabel describes an event for the settings page
+func notificationLabel(event models.NotificationEvent) string {
+	switch event {
+	case models.NotificationOrderConfirmed:
+		return "Order confirmations"
+	case models.NotificationOrderProcessing:
+		return "When an order is being processed"
+	case models.NotificationOrderCompleted:
+		return "When an order is completed"
+	case models.NotificationOrderCancelled:
+		return "When an order is cancelled"
+	}
+	return string(event)
+}
+
+// notificationsComponent renders a checkbox for each event the user can be
+// emailed about
+func notificationsComponent(prefs models.NotificationPrefs, action, csrfToken string) Node {
+	return Form(
+		Method("post"),
+		Action(action),
+		Class("space-y-4 bg-white p-6 rounded-lg shadow-sm"),
+		csrfInput(csrfToken),
+		H1(Class("text-2xl font-bold text-gray-900"), Text("Notification settings")),
+		P(Class("text-sm text-gray-500"), Text("Choose what we email "+prefs.UserEmail+" about.")),
+		FieldSet(
+			Class("space-y-2"),
+			Legend(Class("text-sm font-medium text-gray-700"), Text("Email me about")),
+			Map(models.NotificationEvents, func(event models.NotificationEvent) Node {
+				return Label(
+					Class("flex items-center gap-2 text-sm text-gray-700"),
+					Input(Type("checkbox"), Name("email"), Value(string(event)), If(prefs.Emails(event), Checked())),
+					Text(notificationLabel(event)),
+				)
+			}),
+		),
+		Button(
+			Type("submit"),
+			Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"),
+			Text("Save"),
+		),
+	)
+}
@@ -0,0 +1,39 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"LearnSingleTableDesign/notifications"
+)
+
+func TestNotificationsHandler_SignedLink(t *testing.T) {
+	links := notifications.NewSettingsLinks("secret", "")
+	app := &App{settingsLinks: links}
+	bobs, _ := url.Parse(links.Path("bob@example.com"))
+
+	// Test a link without the user's signature is refused
+	for _, path := range []string{
+		notifications.SettingsPath + "?email=ann@example.com",
+		notifications.SettingsPath + "?email=ann@example.com&sig=forged",
+		notifications.SettingsPath + "?email=ann@example.com&sig=" + bobs.Query().Get("sig"),
+	} {
+		w := httptest.NewRecorder()
+		app.notificationsHandler(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("GET %s = %d, want 403", path, w.Code)
+		}
+		w = httptest.NewRecorder()
+		app.notificationsSaveHandler(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("POST %s = %d, want 403", path, w.Code)
+		}
+	}
+
+	// Test a signed link names its user
+	if email, ok := app.linkedUser(httptest.NewRequest("GET", links.Path("Ann@Example.com"), nil)); !ok || email != "ann@example.com" {
+		t.Errorf("linkedUser = %q, %v; want ann@example.com", email, ok)
+	}
+}
@@ -0,0 +1,323 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// apiOperation describes a JSON API route for the OpenAPI document. Its
+// schemas are generated from the Go types the handler reads and writes,
+// so the document can't drift from the code.
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+	// Admin marks routes that need the admin's credentials
+	Admin bool
+	// Request is a value of the type the body decodes into, nil for none
+	Request   any
+	Responses []apiResponse
+}
+
+// apiResponse is one of the responses an operation documents
+type apiResponse struct {
+	Status      int
+	Description string
+	// Body is a value of the type written, nil for none
+	Body any
+}
+
+// userExport is the document userExportHandler streams
+type userExport struct {
+	Email      string                    `json:"email"`
+	ExportedAt time.Time                 `json:"exported_at"`
+	Items      []repository.ExportedItem `json:"items"`
+}
+
+var (
+	contactOperation = apiOperation{
+		Method:  http.MethodPost,
+		Path:    "/api/v1/contact",
+		Summary: "Send a contact message",
+		Request: contactForm{},
+		Responses: []apiResponse{
+			{http.StatusCreated, "The stored message", models.ContactMessage{}},
+			{http.StatusBadRequest, "The body doesn't match the schema", apiError{}},
+			{http.StatusUnprocessableEntity, "The message failed validation", apiError{}},
+			{http.StatusServiceUnavailable, "The store is read-only for maintenance", apiError{}},
+		},
+	}
+	exportOperation = apiOperation{
+		Method:  http.MethodGet,
+		Path:    "/api/v1/users/{email}/export",
+		Summary: "Download everything stored about a user",
+		Admin:   true,
+		Responses: []apiResponse{
+			{http.StatusOK, "The user's items", userExport{}},
+			{http.StatusUnauthorized, "Admin credentials are required", nil},
+			{http.StatusNotFound, "No such user", nil},
+		},
+	}
+	// apiOperations are the JSON API's routes, in the document's order
+	apiOperations = []apiOperation{contactOperation, exportOperation}
+)
+
+// openAPIHandler serves the OpenAPI 3 document of the JSON API
+func (a *App) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument(apiOperations))
+}
+
+// openAPIDocument builds the OpenAPI 3 document describing ops
+func openAPIDocument(ops []apiOperation) map[string]any {
+	paths := map[string]any{}
+	for _, op := range ops {
+		operation := map[string]any{"summary": op.Summary}
+		var params []any
+		for _, name := range pathParams(op.Path) {
+			params = append(params, map[string]any{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(op.Request),
+			}
+		}
+		responses := map[string]any{}
+		for _, resp := range op.Responses {
+			response := map[string]any{"description": resp.Description}
+			if resp.Body != nil {
+				response["content"] = jsonContent(resp.Body)
+			}
+			responses[strconv.Itoa(resp.Status)] = response
+		}
+		operation["responses"] = responses
+		if op.Admin {
+			operation["security"] = []any{map[string]any{"admin": []any{}}}
+		}
+
+		path, _ := paths[op.Path].(map[string]any)
+		if path == nil {
+			path = map[string]any{}
+			paths[op.Path] = path
+		}
+		path[strings.ToLower(op.Method)] = operation
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "Shop API", "version": "1"},
+		"paths":   paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"admin": map[string]any{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+}
+
+// pathParams lists the {names} in a route pattern
+func pathParams(path string) []string {
+	var names []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			names = append(names, strings.TrimSuffix(part[1:len(part)-1], "..."))
+		}
+	}
+	return names
+}
+
+func jsonContent(v any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(v))}}
+}
+
+// schemaOf generates the JSON schema of the values of t as encoding/json
+// writes them. Struct fields without omitempty are required, and extra
+// properties aren't allowed. A validate:"email" tag adds the email format.
+func schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		var required []string
+		addStructFields(t, properties, &required)
+		schema := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	}
+	// Interfaces hold anything
+	return map[string]any{}
+}
+
+// addStructFields adds the JSON properties of t's fields, and those of
+// its embedded structs, to properties
+func addStructFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			addStructFields(sf.Type, properties, required)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		schema := schemaOf(sf.Type)
+		if hasTagOption(sf.Tag.Get("validate"), "email") {
+			schema["format"] = "email"
+		}
+		properties[name] = schema
+		if !hasTagOption(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func hasTagOption(tag, option string) bool {
+	for _, opt := range strings.Split(tag, ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeAPIBody decodes the JSON body of a request into dst after checking
+// it against the operation's request schema. Bodies that don't match come
+// back as models.ValidationErrors naming each problem, for a 400.
+func decodeAPIBody(r *http.Request, op apiOperation, dst any) error {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return models.ValidationErrors{{Message: "the body must be application/json"}}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return models.ValidationErrors{{Message: "the body isn't valid JSON"}}
+	}
+	if errs := validateSchema(schemaOf(reflect.TypeOf(op.Request)), value, ""); len(errs) > 0 {
+		return errs
+	}
+	return json.Unmarshal(body, dst)
+}
+
+// validateSchema checks a decoded JSON value against a schema from
+// schemaOf, naming fields by their JSON path as ValidationErrors do
+func validateSchema(schema map[string]any, value any, path string) models.ValidationErrors {
+	var errs models.ValidationErrors
+	fail := func(message string) {
+		errs = append(errs, models.FieldError{Field: path, Message: message})
+	}
+	switch schema["type"] {
+	case "string":
+		if _, ok := value.(string); !ok {
+			fail("must be a string")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false")
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			fail("must be a whole number")
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			fail("must be a number")
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			fail("must be an array")
+			break
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, item := range items {
+			errs = append(errs, validateSchema(itemSchema, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			break
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := object[name]; !ok {
+				errs = append(errs, models.FieldError{Field: joinPath(path, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propSchema, known := properties[name].(map[string]any)
+			if !known {
+				if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+					propSchema, known = extra, true
+				}
+			}
+			if !known {
+				errs = append(errs, models.FieldError{Field: joinPath(path, name), Message: "isn't a known field"})
+				continue
+			}
+			errs = append(errs, validateSchema(propSchema, object[name], joinPath(path, name))...)
+		}
+	}
+	return errs
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,469 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/web/forms"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// orderDetail is an order with its payments and history
+type orderDetail struct {
+	Order    models.Order
+	Activity repository.OrderActivity
+	// Admin shows the buttons that move the order along
+	Admin bool
+	// InvoiceURL links to the order's invoice, "" if there is none
+	InvoiceURL string
+	// Mismatch is set, for admins, when the order's total doesn't match its
+	// line items
+	Mismatch *repository.TotalMismatch
+	// Actions shows the admin's cancel and refund forms, which need the
+	// order service
+	Actions   bool
+	CSRFToken string
+}
+
+// orderDetailHandler shows one of a user's orders. Orders are stored under
+// their user, so the URL names the user as well as the order.
+func (a *App) orderDetailHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderOrderDetail(w, r, false)
+}
+
+// adminOrderDetailHandler shows an order with buttons to move it along
+func (a *App) adminOrderDetailHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderOrderDetail(w, r, true)
+}
+
+func (a *App) renderOrderDetail(w http.ResponseWriter, r *http.Request, admin bool) {
+	detail, err := a.orderDetail(r, admin)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to load order: %v", err)
+		http.Error(w, "failed to load order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			orderDetailComponent(*detail),
+		),
+	).Render(w)
+}
+
+// orderDetail loads the order named by the request path with its activity
+func (a *App) orderDetail(r *http.Request, admin bool) (*orderDetail, error) {
+	email := models.NormalizeEmail(r.PathValue("email"))
+	order, err := a.orders.Get(r.Context(), email, r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	activity, err := a.orders.Activity(r.Context(), order.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	detail := &orderDetail{
+		Order:     *order,
+		Activity:  *activity,
+		Admin:     admin,
+		Actions:   admin && a.orderService != nil,
+		CSRFToken: CSRFToken(r.Context()),
+	}
+	if mismatch, ok := repository.CheckTotal(*order); admin && ok {
+		detail.Mismatch = &mismatch
+	}
+	if admin && a.invoices != nil && order.InvoiceKey != "" {
+		detail.InvoiceURL = adminOrderURL(*order) + "/invoice"
+	}
+	return detail, nil
+}
+
+// statusForm is the status an admin moves an order to
+type statusForm struct {
+	To models.OrderStatus `json:"to"`
+}
+
+// adminOrderTransitionHandler moves an order to the posted status and
+// answers with its status section, for the buttons on the admin order
+// page. Failures are reported as a toast beside the unchanged section,
+// since htmx doesn't swap error responses in.
+func (a *App) adminOrderTransitionHandler(w http.ResponseWriter, r *http.Request) {
+	var form statusForm
+	if err := forms.Decode(r, &form); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	order, err := a.orders.Get(r.Context(), models.NormalizeEmail(r.PathValue("email")), r.PathValue("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		_, err = a.orders.Transition(r.Context(), order.UserEmail, order.OrderID, order.Status, form.To)
+	}
+
+	var toast Node
+	switch {
+	case err == nil:
+		toast = toastOOB(FlashSuccess, "Order moved to "+statusLabel(form.To)+".")
+	case errors.Is(err, repository.ErrInvalidTransition):
+		toast = toastOOB(FlashError, fmt.Sprintf("A %s order can't be moved to %s.", order.Status, form.To))
+	case errors.Is(err, repository.ErrConditionFailed):
+		toast = toastOOB(FlashError, "The order changed while you were looking at it. Here it is now.")
+	case errors.Is(err, repository.ErrReadOnly):
+		toast = toastOOB(FlashError, "Orders can't be changed during maintenance. Try again later.")
+	default:
+		log.Printf("failed to transition order: %v", err)
+		toast = toastOOB(FlashError, "Something went wrong updating the order.")
+	}
+
+	detail, err := a.orderDetail(r, true)
+	if err != nil {
+		log.Printf("failed to reload order: %v", err)
+		http.Error(w, "failed to load order", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	Group{orderStatusSection(*detail), toast}.Render(w)
+}
+
+// cancelForm is why an admin cancels an order
+type cancelForm struct {
+	Reason string `json:"reason"`
+}
+
+// adminOrderCancelHandler cancels an order through OrderService.Cancel, so
+// its units go back in stock and whatever was charged is refunded
+func (a *App) adminOrderCancelHandler(w http.ResponseWriter, r *http.Request) {
+	var form cancelForm
+	if err := forms.Decode(r, &form); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	order := models.Order{UserEmail: models.NormalizeEmail(r.PathValue("email")), OrderID: r.PathValue("id")}
+	_, err := a.orderService.Cancel(r.Context(), order.UserEmail, order.OrderID, form.Reason)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case err == nil:
+		SetFlash(w, FlashSuccess, "Order cancelled. Its stock is back and any charge is being refunded.")
+	case errors.Is(err, repository.ErrInvalidTransition):
+		SetFlash(w, FlashError, "This order can't be cancelled any more. Refund it instead.")
+	default:
+		SetFlash(w, FlashError, orderActionError("cancel", err))
+	}
+	http.Redirect(w, r, adminOrderURL(order), http.StatusSeeOther)
+}
+
+// refundForm is how much of an order an admin refunds, and why
+type refundForm struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+// adminOrderRefundHandler refunds part of an order through
+// OrderService.Refund, e.g. for a return after it shipped
+func (a *App) adminOrderRefundHandler(w http.ResponseWriter, r *http.Request) {
+	var form refundForm
+	if err := forms.Decode(r, &form); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	order := models.Order{UserEmail: models.NormalizeEmail(r.PathValue("email")), OrderID: r.PathValue("id")}
+	if form.Amount <= 0 {
+		SetFlash(w, FlashError, "Enter an amount to refund.")
+		http.Redirect(w, r, adminOrderURL(order), http.StatusSeeOther)
+		return
+	}
+	_, err := a.orderService.Refund(r.Context(), order.UserEmail, order.OrderID, form.Amount, form.Reason)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case err == nil:
+		SetFlash(w, FlashSuccess, "Refund recorded. It will be paid out shortly.")
+	case errors.Is(err, repository.ErrRefundTooLarge):
+		SetFlash(w, FlashError, "That is more than is left to refund on any one charge.")
+	default:
+		SetFlash(w, FlashError, orderActionError("refund", err))
+	}
+	http.Redirect(w, r, adminOrderURL(order), http.StatusSeeOther)
+}
+
+// orderActionError is the flash message for a cancel or refund that
+// failed for a reason common to both
+func orderActionError(action string, err error) string {
+	switch {
+	case errors.Is(err, repository.ErrReadOnly):
+		return "Orders can't be changed during maintenance. Try again later."
+	case errors.Is(err, repository.ErrConditionFailed):
+		return "The order kept changing while we tried to " + action + " it. Try again."
+	}
+	log.Printf("failed to %s order: %v", action, err)
+	return "Something went wrong trying to " + action + " the order."
+}
+
+// adminOrderRepairTotalHandler sets the order's total to what its line
+// items add up to
+func (a *App) adminOrderRepairTotalHandler(w http.ResponseWriter, r *http.Request) {
+	order := models.Order{UserEmail: models.NormalizeEmail(r.PathValue("email")), OrderID: r.PathValue("id")}
+	_, err := a.orders.RepairTotal(r.Context(), order.UserEmail, order.OrderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		SetFlash(w, FlashSuccess, "The total now matches the line items.")
+	} else {
+		SetFlash(w, FlashError, orderActionError("repair", err))
+	}
+	http.Redirect(w, r, adminOrderURL(order), http.StatusSeeOther)
+}
+
+// orderDetailURL links to a user's order
+func orderDetailURL(order models.Order) string {
+	return "/users/" + url.PathEscape(order.UserEmail) + "/orders/" + url.PathEscape(order.OrderID)
+}
+
+// adminOrderURL links to the admin page of an order
+func adminOrderURL(order models.Order) string {
+	return "/admin/orders/" + url.PathEscape(order.UserEmail) + "/" + url.PathEscape(order.OrderID)
+}
+
+// orderDetailComponent renders an order's line items, payments and status
+func orderDetailComponent(d orderDetail) Node {
+	order := d.Order
+	return Div(
+		Class("space-y-6"),
+		Div(
+			Class("flex justify-between items-center"),
+			H1(Class("text-2xl font-bold text-gray-900"), Text("Order "), Span(Class("font-mono"), Text(order.OrderID))),
+			A(Href(orderHistoryURL(order.UserEmail, "", "", nil)), Class("text-sm text-blue-600 hover:underline"), Text("All orders")),
+		),
+		P(
+			Class("text-sm text-gray-500"),
+			Text("Placed "+order.CreatedAt.UTC().Format("2006-01-02 15:04")+" UTC by "+order.UserEmail),
+		),
+		totalMismatchComponent(d),
+		Div(Class("bg-white rounded-lg shadow-sm p-6"), lineItemsComponent(order)),
+		Div(Class("bg-white rounded-lg shadow-sm p-6"), paymentsComponent(d.Activity.Payments, order.PriceCurrency())),
+		orderStatusSection(d),
+		If(d.Actions, orderActionsSection(d)),
+		If(d.InvoiceURL != "",
+			A(Href(d.InvoiceURL), Class("text-sm text-blue-600 hover:underline"), Text("Download invoice")),
+		),
+	)
+}
+
+// totalMismatchComponent warns that the order's total doesn't match its
+// line items, with a button to repair it. It renders nothing when they
+// match.
+func totalMismatchComponent(d orderDetail) Node {
+	m := d.Mismatch
+	if m == nil {
+		return nil
+	}
+	return Div(
+		Class("flex items-center justify-between rounded-lg border border-amber-300 bg-amber-50 p-4 text-sm text-amber-900"),
+		Span(Text(fmt.Sprintf("The total is %s but the line items add up to %s.", money.Format(m.Stored, m.Currency), money.Format(m.Computed, m.Currency)))),
+		Form(
+			Method("post"),
+			Action(adminOrderURL(d.Order)+"/repair-total"),
+			csrfInput(d.CSRFToken),
+			Button(Type("submit"), Class("rounded bg-amber-600 px-3 py-1.5 text-white hover:bg-amber-700"), Text("Repair total")),
+		),
+	)
+}
+
+// lineItemsComponent lists what was ordered with the order's total. Orders
+// from before line items only list product IDs.
+func lineItemsComponent(order models.Order) Node {
+	currency := order.PriceCurrency()
+	rows := Map(order.Items, func(item models.LineItem) Node {
+		return Tr(
+			Class("border-t border-gray-100"),
+			Td(Class("py-2 pr-4 text-gray-900"), Text(item.Name)),
+			Td(Class("py-2 pr-4 text-right text-gray-700"), Text(fmt.Sprint(item.Quantity))),
+			Td(Class("py-2 pr-4 text-right text-gray-700"), Text(money.Format(item.UnitPrice, currency))),
+			Td(Class("py-2 text-right text-gray-900"), Text(money.Format(item.Subtotal(), currency))),
+		)
+	})
+	if len(order.Items) == 0 {
+		rows = Map(order.LegacyProducts, func(productID string) Node {
+			return Tr(
+				Class("border-t border-gray-100"),
+				Td(Class("py-2 pr-4 font-mono text-gray-900"), Text(productID)),
+				Td(Class("py-2 pr-4 text-right text-gray-700"), Text("1")),
+				Td(), Td(),
+			)
+		})
+	}
+	return Table(
+		Class("w-full text-sm"),
+		THead(Tr(
+			Class("text-left text-gray-500"),
+			Th(Class("pb-2"), Text("Item")),
+			Th(Class("pb-2 text-right"), Text("Quantity")),
+			Th(Class("pb-2 text-right"), Text("Price")),
+			Th(Class("pb-2 text-right"), Text("Subtotal")),
+		)),
+		TBody(rows),
+		TFoot(Tr(
+			Class("border-t border-gray-200 font-medium"),
+			Td(Class("pt-2"), ColSpan("3"), Text("Total")),
+			Td(Class("pt-2 text-right text-gray-900"), Text(money.Format(order.Total, currency))),
+		)),
+	)
+}
+
+// paymentsComponent lists an order's charges and refunds
+func paymentsComponent(payments []models.Payment, currency string) Node {
+	var body Node = P(Class("text-sm text-gray-500"), Text("No payments yet."))
+	if len(payments) > 0 {
+		body = Ul(
+			Class("divide-y divide-gray-100 text-sm"),
+			Map(payments, func(p models.Payment) Node {
+				return Li(
+					Class("flex justify-between py-2"),
+					Span(Class("text-gray-700"), Text(paymentLabel(p))),
+					Span(Class("text-gray-900"), Text(money.Format(p.Amount, currency)+" · "+string(p.Status))),
+				)
+			}),
+		)
+	}
+	return Div(
+		Class("space-y-2"),
+		H2(Class("text-lg font-semibold text-gray-900"), Text("Payments")),
+		body,
+	)
+}
+
+// paymentLabel describes a payment, e.g. "Charge on 2024-05-01"
+func paymentLabel(p models.Payment) string {
+	kind := "Charge"
+	if p.Kind == models.PaymentKindRefund {
+		kind = "Refund"
+	}
+	label := kind + " on " + p.CreatedAt.UTC().Format(time.DateOnly)
+	if p.FailureReason != "" {
+		label += " (" + p.FailureReason + ")"
+	}
+	return label
+}
+
+// orderStatusSection renders the order's status, its timeline and, for
+// admins, a button for each status it can move to. Cancelling also
+// restocks and refunds, so it has its own form in orderActionsSection.
+func orderStatusSection(d orderDetail) Node {
+	var buttons []Node
+	if d.Admin {
+		for _, next := range orderStatuses {
+			if next == models.OrderStatusCancelled || !d.Order.Status.CanTransitionTo(next) {
+				continue
+			}
+			buttons = append(buttons, Button(
+				Type("button"),
+				Class("rounded bg-blue-600 px-3 py-1.5 text-sm text-white hover:bg-blue-700"),
+				Attr("hx-post", adminOrderURL(d.Order)+"/status"),
+				Attr("hx-vals", fmt.Sprintf(`{"to": %q}`, next)),
+				Attr("hx-target", "#order-status"),
+				Attr("hx-swap", "outerHTML"),
+				Text("Mark as "+statusLabel(next)),
+			))
+		}
+	}
+
+	return Div(
+		ID("order-status"),
+		Class("bg-white rounded-lg shadow-sm p-6 space-y-4"),
+		Div(
+			Class("flex items-center gap-3"),
+			H2(Class("text-lg font-semibold text-gray-900"), Text("Status")),
+			orderStatusBadge(d.Order.Status),
+		),
+		orderTimeline(d.Order, d.Activity.Log),
+		If(len(buttons) > 0, Div(Class("flex gap-2"), Group(buttons))),
+	)
+}
+
+// orderActionsSection renders the admin's forms to cancel the order, while
+// it still can be, and to refund part of it
+func orderActionsSection(d orderDetail) Node {
+	return Div(
+		Class("bg-white rounded-lg shadow-sm p-6 space-y-4"),
+		H2(Class("text-lg font-semibold text-gray-900"), Text("Cancel or refund")),
+		If(d.Order.Status.CanTransitionTo(models.OrderStatusCancelled),
+			Form(
+				Method("post"),
+				Action(adminOrderURL(d.Order)+"/cancel"),
+				Class("flex gap-2"),
+				csrfInput(d.CSRFToken),
+				Input(Type("text"), Name("reason"), Placeholder("Reason"), Class("flex-1 rounded border border-gray-300 px-3 py-1.5 text-sm")),
+				Button(Type("submit"), Class("rounded bg-red-600 px-3 py-1.5 text-sm text-white hover:bg-red-700"), Text("Cancel order")),
+			),
+		),
+		Form(
+			Method("post"),
+			Action(adminOrderURL(d.Order)+"/refund"),
+			Class("flex gap-2"),
+			csrfInput(d.CSRFToken),
+			Input(Type("number"), Name("amount"), Step("0.01"), Min("0.01"), Required(), Placeholder("Amount"), Class("w-32 rounded border border-gray-300 px-3 py-1.5 text-sm")),
+			Input(Type("text"), Name("reason"), Placeholder("Reason"), Class("flex-1 rounded border border-gray-300 px-3 py-1.5 text-sm")),
+			Button(Type("submit"), Class("rounded bg-gray-700 px-3 py-1.5 text-sm text-white hover:bg-gray-800"), Text("Refund")),
+		),
+	)
+}
+
+// orderTimeline lists what happened to the order, oldest first. Orders
+// placed before the log existed, or written directly, start from when they
+// were created.
+func orderTimeline(order models.Order, log []models.OrderLogEntry) Node {
+	if len(log) == 0 || log[0].Event != repository.EventOrderPlaced {
+		placed := models.OrderLogEntry{Event: repository.EventOrderPlaced, Status: models.OrderStatusPending, At: order.CreatedAt}
+		log = append([]models.OrderLogEntry{placed}, log...)
+	}
+	return Ol(
+		Class("border-l border-gray-200 pl-4 space-y-3 text-sm"),
+		Map(log, func(entry models.OrderLogEntry) Node {
+			return Li(
+				Div(Class("font-medium text-gray-900"), Text(timelineLabel(entry))),
+				Div(Class("text-gray-500"), Text(entry.At.UTC().Format("2006-01-02 15:04")+" UTC")),
+				If(entry.Note != "", Div(Class("text-gray-700"), Text(entry.Note))),
+			)
+		}),
+	)
+}
+
+// timelineLabel names a log entry for the timeline, e.g. "Processing"
+func timelineLabel(entry models.OrderLogEntry) string {
+	switch entry.Event {
+	case repository.EventOrderPlaced:
+		return "Placed"
+	case repository.EventOrderRefunded:
+		return "Refunded"
+	}
+	return statusLabel(entry.Status)
+}
@@ -0,0 +1,28 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminOrderRefundHandler_NoAmount(t *testing.T) {
+	// No order service: a refund without an amount never reaches it
+	app := &App{}
+	body := url.Values{"amount": {"0"}, "reason": {"Returned"}}
+	r := httptest.NewRequest("POST", "/admin/orders/a@example.com/ORD1/refund", strings.NewReader(body.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetPathValue("email", "a@example.com")
+	r.SetPathValue("id", "ORD1")
+	w := httptest.NewRecorder()
+	app.adminOrderRefundHandler(w, r)
+
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/orders/a@example.com/ORD1" {
+		t.Errorf("Got %d to %q, want a redirect back to the order", w.Code, w.Header().Get("Location"))
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != flashCookie {
+		t.Errorf("Expected a flash saying what is wrong, got %v", cookies)
+	}
+}
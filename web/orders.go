@@ -0,0 +1,222 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/repository"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// orderHistoryPageSize is how many orders each page of a user's history shows
+const orderHistoryPageSize = 10
+
+// maxBackCursors is how many earlier pages the previous button can walk back
+// through, so the links stay short however far someone pages
+const maxBackCursors = 20
+
+// orderStatuses are the statuses the order history can be filtered by
+var orderStatuses = []models.OrderStatus{
+	models.OrderStatusPending,
+	models.OrderStatusProcessing,
+	models.OrderStatusCompleted,
+	models.OrderStatusCancelled,
+}
+
+// orderHistory is one page of a user's orders and the links around it
+type orderHistory struct {
+	Email  string
+	Status models.OrderStatus
+	Orders []models.Order
+	// PrevURL and NextURL link to the neighbouring pages, "" at either end
+	PrevURL string
+	NextURL string
+}
+
+// userOrdersHandler lists a user's orders newest first. ?status= shows only
+// orders in that status, read from their GSI2 status partition, and
+// ?cursor= picks the page. Page tokens only lead forwards, so each link
+// also carries the cursors of the pages before it in ?back=, which the
+// previous button walks back through.
+func (a *App) userOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	email := models.NormalizeEmail(r.PathValue("email"))
+	query := r.URL.Query()
+	status := models.OrderStatus(query.Get("status"))
+	if status != "" && !status.IsValid() {
+		http.Error(w, "unknown order status", http.StatusBadRequest)
+		return
+	}
+	cursor := query.Get("cursor")
+	token, err := repository.ParsePageToken(cursor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := &repository.QueryOptions{Limit: orderHistoryPageSize, PageToken: token, Descending: true}
+	var page *repository.OrdersPage
+	if status == "" {
+		page, err = a.orders.GetUserOrdersByCreatedAt(r.Context(), email, opts)
+	} else {
+		page, err = a.orders.GetUserOrdersByStatus(r.Context(), email, status, opts)
+	}
+	if err != nil {
+		log.Printf("failed to load orders: %v", err)
+		http.Error(w, "failed to load orders", http.StatusInternalServerError)
+		return
+	}
+
+	history := orderHistory{Email: email, Status: status, Orders: page.Orders}
+	back := query["back"]
+	if len(back) > 0 {
+		history.PrevURL = orderHistoryURL(email, status, back[len(back)-1], back[:len(back)-1])
+	}
+	if page.NextPageToken != nil {
+		history.NextURL = orderHistoryURL(email, status, page.NextPageToken.String(), append(back[:len(back):len(back)], cursor))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			orderHistoryComponent(history),
+		),
+	).Render(w)
+}
+
+// orderHistoryURL links to the page of a user's orders starting at cursor,
+// "" for the first, with back the cursors of the pages before it. Only the
+// last maxBackCursors of them are kept.
+func orderHistoryURL(email string, status models.OrderStatus, cursor string, back []string) string {
+	back = back[max(len(back)-maxBackCursors, 0):]
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", string(status))
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	for _, c := range back {
+		query.Add("back", c)
+	}
+	u := "/users/" + url.PathEscape(email) + "/orders"
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// orderHistoryComponent renders a page of orders under the status filter,
+// with previous and next buttons
+func orderHistoryComponent(h orderHistory) Node {
+	var body Node = P(Class("text-sm text-gray-500"), Text(emptyOrdersMessage(h.Status)))
+	if len(h.Orders) > 0 {
+		body = Table(
+			Class("w-full text-sm"),
+			THead(Tr(
+				Class("text-left text-gray-500"),
+				Th(Class("pb-2"), Text("Placed")),
+				Th(Class("pb-2"), Text("Order")),
+				Th(Class("pb-2"), Text("Status")),
+				Th(Class("pb-2 text-right"), Text("Total")),
+			)),
+			TBody(Map(h.Orders, func(order models.Order) Node {
+				return Tr(
+					Class("border-t border-gray-100"),
+					Td(Class("py-2 pr-4 text-gray-500 whitespace-nowrap"), Text(order.CreatedAt.UTC().Format(time.DateOnly))),
+					Td(Class("py-2 pr-4 font-mono"), A(Href(orderDetailURL(order)), Class("text-blue-600 hover:underline"), Text(order.OrderID))),
+					Td(Class("py-2 pr-4"), orderStatusBadge(order.Status)),
+					Td(Class("py-2 text-right text-gray-900"), Text(money.Format(order.Total, order.PriceCurrency()))),
+				)
+			})),
+		)
+	}
+
+	return Div(
+		Class("space-y-6"),
+		Div(
+			Class("flex justify-between items-center"),
+			H1(Class("text-2xl font-bold text-gray-900"), Text("Orders")),
+			orderStatusFilter(h.Email, h.Status),
+		),
+		Div(Class("bg-white rounded-lg shadow-sm p-6"), body),
+		If(h.PrevURL != "" || h.NextURL != "",
+			Nav(
+				Class("flex justify-between text-sm"),
+				Attr("aria-label", "Order pages"),
+				pageButton("Previous", h.PrevURL),
+				pageButton("Next", h.NextURL),
+			),
+		),
+	)
+}
+
+// orderStatusFilter is a dropdown reloading the history with only the
+// orders in the chosen status. Changing it starts again from the first page.
+func orderStatusFilter(email string, selected models.OrderStatus) Node {
+	options := []Node{Option(Value(""), Text("All orders"), If(selected == "", Selected()))}
+	for _, status := range orderStatuses {
+		options = append(options, Option(Value(string(status)), Text(statusLabel(status)), If(status == selected, Selected())))
+	}
+	return Form(
+		Method("get"),
+		Action(orderHistoryURL(email, "", "", nil)),
+		Class("flex items-center gap-2 text-sm"),
+		Label(For("order-status"), Class("text-gray-600"), Text("Status")),
+		Select(
+			ID("order-status"),
+			Name("status"),
+			Class("rounded-md border border-gray-300 px-2 py-1"),
+			Attr("onchange", "this.form.submit()"),
+			Group(options),
+		),
+		NoScript(Button(Type("submit"), Class("text-blue-600 hover:underline"), Text("Filter"))),
+	)
+}
+
+// pageButton links to a neighbouring page, or is disabled at the end
+func pageButton(label, href string) Node {
+	if href == "" {
+		return Span(Class("px-3 py-1.5 rounded-md border border-gray-200 text-gray-400"), Attr("aria-disabled", "true"), Text(label))
+	}
+	return A(Href(href), Class("px-3 py-1.5 rounded-md border border-gray-300 text-blue-600 hover:bg-gray-50"), Text(label))
+}
+
+func orderStatusBadge(status models.OrderStatus) Node {
+	colors := map[models.OrderStatus]string{
+		models.OrderStatusPending:    "bg-amber-100 text-amber-800",
+		models.OrderStatusProcessing: "bg-blue-100 text-blue-800",
+		models.OrderStatusCompleted:  "bg-green-100 text-green-800",
+		models.OrderStatusCancelled:  "bg-gray-100 text-gray-700",
+	}
+	return Span(Class("rounded-full px-2 py-0.5 text-xs font-medium "+colors[status]), Text(statusLabel(status)))
+}
+
+// statusLabel is a status as shown to customers, e.g. "Pending"
+func statusLabel(status models.OrderStatus) string {
+	s := string(status)
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// emptyOrdersMessage explains an empty page of the history
+func emptyOrdersMessage(status models.OrderStatus) string {
+	if status == "" {
+		return "No orders yet."
+	}
+	return "No " + string(status) + " orders."
+}
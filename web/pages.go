@@ -0,0 +1,355 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/web/forms"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// NavLink is a single navbar entry
+type NavLink struct {
+	Label string
+	Href  string
+	// Active marks the link to the page being shown
+	Active bool
+}
+
+// markActive marks the link matching path, or the section containing it, as
+// active. Home only matches itself.
+func markActive(links []NavLink, path string) []NavLink {
+	marked := make([]NavLink, len(links))
+	for i, link := range links {
+		link.Active = path == link.Href || (link.Href != "/" && strings.HasPrefix(path, link.Href+"/"))
+		marked[i] = link
+	}
+	return marked
+}
+
+// pageCacheTTL bounds how long page edits can take to show up on other instances
+const pageCacheTTL = time.Minute
+
+// pageCache keeps the list of CMS pages in memory between requests.
+// There are only a handful of pages so the whole list is cached as one entry.
+type pageCache struct {
+	mu       sync.Mutex
+	pages    []models.Page
+	loadedAt time.Time
+}
+
+// all returns the cached pages, reloading them once the cache has expired
+func (c *pageCache) all(ctx context.Context, repo repository.Pages) ([]models.Page, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pages != nil && time.Since(c.loadedAt) < pageCacheTTL {
+		return c.pages, nil
+	}
+	pages, err := repo.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pages == nil {
+		pages = []models.Page{}
+	}
+	c.pages = pages
+	c.loadedAt = time.Now()
+	return pages, nil
+}
+
+// invalidate drops the cached pages so the next request reloads them
+func (c *pageCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pages = nil
+}
+
+// navLinks returns Home followed by the published pages that have a nav label
+func navLinks(pages []models.Page) []NavLink {
+	var navPages []models.Page
+	for _, page := range withDefaultPages(pages) {
+		if page.IsPublished() && page.NavLabel != "" {
+			navPages = append(navPages, page)
+		}
+	}
+	sort.SliceStable(navPages, func(i, j int) bool {
+		return navPages[i].NavOrder < navPages[j].NavOrder
+	})
+
+	links := []NavLink{{Label: "Home", Href: "/"}}
+	for _, page := range navPages {
+		links = append(links, NavLink{Label: page.NavLabel, Href: "/" + page.Slug})
+	}
+	return links
+}
+
+// navLinks loads the navbar entries, falling back to the default pages if
+// pages can't be read
+func (a *App) navLinks(ctx context.Context) []NavLink {
+	pages, err := a.pageCache.all(ctx, a.pages)
+	if err != nil {
+		log.Printf("failed to load pages for navbar: %v", err)
+	}
+	return navLinks(pages)
+}
+
+// pageHandler renders a published CMS page by its slug
+func (a *App) pageHandler(w http.ResponseWriter, r *http.Request) {
+	pages, err := a.pageCache.all(r.Context(), a.pages)
+	if err != nil {
+		log.Printf("failed to load pages: %v", err)
+		http.Error(w, "failed to load page", http.StatusInternalServerError)
+		return
+	}
+
+	page, ok := publishedPage(pages, r.PathValue("slug"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbarWith(r, navLinks(pages)),
+			pageComponent(page),
+		),
+	).Render(w)
+}
+
+// defaultPages are shown until a published CMS page with the same slug
+// replaces them, so the navbar's About and Contact links work on a fresh
+// table
+var defaultPages = []models.Page{
+	{
+		Slug:     "contact",
+		Title:    "Contact",
+		Markdown: "Reach us at **hello@example.com**.",
+		Status:   models.PageStatusPublished,
+		NavLabel: "Contact",
+		NavOrder: 1,
+	},
+	{
+		Slug:     "about",
+		Title:    "About",
+		Markdown: "A demo of *single table design* with DynamoDB.",
+		Status:   models.PageStatusPublished,
+		NavLabel: "About",
+		NavOrder: 2,
+	},
+}
+
+// withDefaultPages adds the default pages that no published page replaces
+func withDefaultPages(pages []models.Page) []models.Page {
+	published := map[string]bool{}
+	for _, page := range pages {
+		if page.IsPublished() {
+			published[page.Slug] = true
+		}
+	}
+	all := slices.Clone(pages)
+	for _, page := range defaultPages {
+		if !published[page.Slug] {
+			all = append(all, page)
+		}
+	}
+	return all
+}
+
+// publishedPage returns the published page with slug, default pages included
+func publishedPage(pages []models.Page, slug string) (models.Page, bool) {
+	for _, page := range withDefaultPages(pages) {
+		if page.Slug == slug && page.IsPublished() {
+			return page, true
+		}
+	}
+	return models.Page{}, false
+}
+
+// pageComponent renders a CMS page's title and markdown body
+func pageComponent(page models.Page) Node {
+	return Article(
+		Class("space-y-6"),
+		H1(
+			Class("text-2xl font-bold text-gray-900"),
+			Text(page.Title),
+		),
+		Div(
+			Class("prose max-w-none"),
+			markdown(page.Markdown),
+		),
+	)
+}
+
+// adminPagesHandler lists every page, drafts included
+func (a *App) adminPagesHandler(w http.ResponseWriter, r *http.Request) {
+	pages, err := a.pages.All(r.Context())
+	if err != nil {
+		log.Printf("failed to load pages: %v", err)
+		http.Error(w, "failed to load pages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			adminPagesComponent(pages),
+		),
+	).Render(w)
+}
+
+// adminPagesComponent renders the page list with edit links
+func adminPagesComponent(pages []models.Page) Node {
+	var rows []Node
+	for _, page := range pages {
+		rows = append(rows,
+			Li(
+				Class("flex justify-between items-center py-3"),
+				Div(
+					P(Class("font-medium text-gray-900"), Text(page.Title)),
+					P(Class("text-sm text-gray-500"), Text("/"+page.Slug+" · "+string(page.Status))),
+				),
+				A(
+					Href("/admin/pages/"+page.Slug+"/edit"),
+					Class("text-blue-600 hover:underline"),
+					Text("Edit"),
+				),
+			),
+		)
+	}
+
+	return Div(
+		Class("space-y-6"),
+		Div(
+			Class("flex justify-between items-center"),
+			H1(Class("text-2xl font-bold text-gray-900"), Text("Pages")),
+			A(
+				Href("/admin/pages/new"),
+				Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"),
+				Text("New page"),
+			),
+		),
+		Ul(
+			append([]Node{Class("divide-y divide-gray-200 bg-white rounded-lg shadow-sm px-6")}, rows...)...,
+		),
+	)
+}
+
+// adminNewPageHandler renders an empty page form
+func (a *App) adminNewPageHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderPageForm(w, r, models.Page{Status: models.PageStatusDraft}, nil, http.StatusOK)
+}
+
+// adminEditPageHandler renders the form for an existing page
+func (a *App) adminEditPageHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := a.pages.Get(r.Context(), r.PathValue("slug"))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to load page: %v", err)
+		http.Error(w, "failed to load page", http.StatusInternalServerError)
+		return
+	}
+	a.renderPageForm(w, r, *page, nil, http.StatusOK)
+}
+
+// adminSavePageHandler creates or updates a page from the submitted form
+func (a *App) adminSavePageHandler(w http.ResponseWriter, r *http.Request) {
+	var page models.Page
+	err := forms.Bind(r, &page)
+	if errs, ok := forms.AsErrors(err); ok {
+		a.renderPageForm(w, r, page, errs, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	page.UpdatedAt = time.Now()
+
+	err = a.pages.Put(r.Context(), page)
+	if errors.Is(err, repository.ErrReadOnly) {
+		a.renderPageForm(w, r, page, forms.Errors{"": "Pages can't be saved during maintenance. Try again later."}, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		a.renderPageForm(w, r, page, forms.FromError(page, err), http.StatusUnprocessableEntity)
+		return
+	}
+	a.pageCache.invalidate()
+	SetFlash(w, FlashSuccess, "Saved "+page.Title+".")
+	http.Redirect(w, r, "/admin/pages", http.StatusSeeOther)
+}
+
+func (a *App) renderPageForm(w http.ResponseWriter, r *http.Request, page models.Page, errs forms.Errors, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			pageFormComponent(page, errs, CSRFToken(r.Context())),
+		),
+	).Render(w)
+}
+
+// pageFormComponent renders the create/edit form for a page, with each
+// field's error below it
+func pageFormComponent(page models.Page, errs forms.Errors, csrfToken string) Node {
+	return Form(
+		Method("post"),
+		Action("/admin/pages"),
+		Class("space-y-4 bg-white p-6 rounded-lg shadow-sm"),
+		csrfInput(csrfToken),
+		H1(Class("text-2xl font-bold text-gray-900"), Text("Edit page")),
+		forms.FormError(errs),
+		forms.Field("Slug", "slug", forms.TextInput("slug", page.Slug, errs), errs),
+		forms.Field("Title", "title", forms.TextInput("title", page.Title, errs), errs),
+		forms.Field("Content (markdown)", "markdown", forms.TextArea("markdown", page.Markdown, errs,
+			Rows("12"),
+			// Live preview of the rendered markdown
+			Attr("hx-post", "/admin/markdown/preview"),
+			Attr("hx-trigger", "keyup changed delay:500ms"),
+			Attr("hx-target", "#markdown-preview"),
+		), errs),
+		Div(
+			ID("markdown-preview"),
+			Class("prose max-w-none rounded border border-dashed border-gray-300 p-4"),
+			markdown(page.Markdown),
+		),
+		forms.Field("Status", "status", Select(
+			forms.InputAttrs("status", errs),
+			Option(Value(string(models.PageStatusDraft)), If(page.Status == models.PageStatusDraft, Selected()), Text("Draft")),
+			Option(Value(string(models.PageStatusPublished)), If(page.IsPublished(), Selected()), Text("Published")),
+		), errs),
+		forms.Field("Navbar label (leave empty to hide)", "nav_label", forms.TextInput("nav_label", page.NavLabel, errs), errs),
+		forms.Field("Navbar order", "nav_order", Input(Type("number"), forms.InputAttrs("nav_order", errs), Value(strconv.Itoa(page.NavOrder))), errs),
+		Button(
+			Type("submit"),
+			Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"),
+			Text("Save"),
+		),
+	)
+}
@@ -2,29 +2,100 @@ package web
 
 import (
 	"bytes"
+	"mime"
 	"net/http"
 
 	"github.com/Joker/hpp"
 )
 
+// maxPrettyPrintBytes is the largest body that is buffered for formatting;
+// anything bigger is streamed as written
+const maxPrettyPrintBytes = 1 << 20
+
 type prettyPrintResponseWrapper struct {
 	buf        *bytes.Buffer
 	httpWriter http.ResponseWriter
+	// status is held back until the formatted body is ready, since
+	// formatting changes the headers that describe the body
+	status int
+	// passthrough is set once the response is known not to be formatted
+	passthrough bool
+	decided     bool
 }
 
-func (wrapper prettyPrintResponseWrapper) Header() http.Header {
+func (wrapper *prettyPrintResponseWrapper) Header() http.Header {
 	return wrapper.httpWriter.Header()
 }
-func (wrapper prettyPrintResponseWrapper) Write(bytes []byte) (int, error) {
-	return wrapper.buf.Write(bytes)
+
+func (wrapper *prettyPrintResponseWrapper) Write(b []byte) (int, error) {
+	if !wrapper.decided {
+		if wrapper.Header().Get("Content-Type") == "" {
+			wrapper.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		// Like net/http, writing the body first implies a 200
+		wrapper.WriteHeader(http.StatusOK)
+	}
+	if wrapper.passthrough {
+		return wrapper.httpWriter.Write(b)
+	}
+	if wrapper.buf.Len()+len(b) > maxPrettyPrintBytes {
+		// Too big to hold in memory: send what we have unformatted and stream the rest
+		wrapper.startPassthrough()
+		if _, err := wrapper.httpWriter.Write(wrapper.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		wrapper.buf.Reset()
+		return wrapper.httpWriter.Write(b)
+	}
+	return wrapper.buf.Write(b)
 }
-func (wrapper prettyPrintResponseWrapper) WriteHeader(statusCode int) {
-	wrapper.httpWriter.WriteHeader(statusCode)
+
+func (wrapper *prettyPrintResponseWrapper) WriteHeader(statusCode int) {
+	if wrapper.decided {
+		return
+	}
+	wrapper.decided = true
+	wrapper.status = statusCode
+	if !isHTML(wrapper.Header().Get("Content-Type")) {
+		wrapper.startPassthrough()
+	}
+}
+
+// startPassthrough sends the held back status and stops buffering
+func (wrapper *prettyPrintResponseWrapper) startPassthrough() {
+	wrapper.passthrough = true
+	wrapper.httpWriter.WriteHeader(wrapper.status)
+}
+
+// finish formats and sends a buffered HTML body
+func (wrapper *prettyPrintResponseWrapper) finish() {
+	if !wrapper.decided || wrapper.passthrough {
+		return
+	}
+	// The formatted body is a different length from the original
+	wrapper.Header().Del("Content-Length")
+	wrapper.httpWriter.WriteHeader(wrapper.status)
+	if wrapper.buf.Len() > 0 {
+		hpp.Format(wrapper.buf, wrapper.httpWriter)
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (wrapper *prettyPrintResponseWrapper) Unwrap() http.ResponseWriter {
+	return wrapper.httpWriter
+}
+
+// isHTML reports whether a content type is text/html
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/html"
 }
 
+// PrettyPrintHTML indents HTML responses for readability while developing.
+// Other content types, and HTML bodies over 1 MiB, are passed through as written.
 func PrettyPrintHTML(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		wrapped := prettyPrintResponseWrapper{
+		wrapped := &prettyPrintResponseWrapper{
 			buf:        new(bytes.Buffer),
 			httpWriter: w,
 		}
@@ -33,6 +104,6 @@ func PrettyPrintHTML(next http.Handler) http.Handler {
 			toClose = nil
 		}(wrapped.buf)
 		next.ServeHTTP(wrapped, r)
-		hpp.Format(wrapped.buf, w)
+		wrapped.finish()
 	})
 }
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func servePretty(h http.HandlerFunc) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	PrettyPrintHTML(h).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func TestPrettyPrintHTML_FormatsHTML(t *testing.T) {
+	rec := servePretty(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<div><p>hi</p></div>"))
+	})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if !strings.Contains(rec.Body.String(), "\n") {
+		t.Errorf("Body was not formatted: %q", rec.Body.String())
+	}
+}
+
+func TestPrettyPrintHTML_PassesThroughNonHTML(t *testing.T) {
+	body := `{"a": "<b>"}`
+	rec := servePretty(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(body))
+	})
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Code = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if rec.Body.String() != body {
+		t.Errorf("Body = %q, want %q", rec.Body.String(), body)
+	}
+}
+
+func TestPrettyPrintHTML_StatusAfterWriteIgnored(t *testing.T) {
+	rec := servePretty(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>ok</p>"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestPrettyPrintHTML_StreamsLargeBodies(t *testing.T) {
+	chunk := strings.Repeat("<p>x</p>", 1024)
+	rec := httptest.NewRecorder()
+	var streamed bool
+	PrettyPrintHTML(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		for i := 0; i < 200; i++ {
+			w.Write([]byte(chunk))
+			streamed = streamed || rec.Body.Len() > 0
+		}
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !streamed {
+		t.Error("Large body was buffered until the handler returned")
+	}
+	if want := strings.Repeat(chunk, 200); rec.Body.String() != want {
+		t.Errorf("Body length = %d, want %d unformatted bytes", rec.Body.Len(), len(want))
+	}
+}
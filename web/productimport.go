@@ -0,0 +1,333 @@
+package web
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// maxImportRows bounds an import so it finishes within the request timeout
+const maxImportRows = 1000
+
+// maxImportSize is the largest CSV file an import accepts, in bytes; it
+// leaves plenty of room for maxImportRows rows
+const maxImportSize = 2 << 20
+
+// importColumns are the CSV columns an import understands; the ones marked
+// true are required
+var importColumns = map[string]bool{
+	"product_id":          true,
+	"name":                true,
+	"category":            true,
+	"price":               true,
+	"currency":            false,
+	"stock":               false,
+	"low_stock_threshold": false,
+	"featured":            false,
+}
+
+// importRow is one CSV row and what became of it
+type importRow struct {
+	// Line is the row's line in the file, counting the header as line 1
+	Line    int
+	Product models.Product
+	// Err is why the row wasn't imported; empty once it has been
+	Err string
+}
+
+// batchProducts is implemented by catalogs that can write many products in
+// one batch
+type batchProducts interface {
+	PutMany(ctx context.Context, products []models.Product) (*repository.BatchResult, error)
+}
+
+// adminImportProductsHandler renders the CSV import form
+func (a *App) adminImportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			productImportFormComponent(CSRFToken(r.Context())),
+		),
+	).Render(w)
+}
+
+// adminImportProductsUploadHandler imports the uploaded CSV and returns the
+// per-row report as a fragment for the form
+func (a *App) adminImportProductsUploadHandler(w http.ResponseWriter, r *http.Request) {
+	// Refuse oversized files before reading them all
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+multipartOverhead)
+	file, _, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		P(Class("text-sm text-red-600"), Text(fmt.Sprintf("The file is larger than %d MiB.", maxImportSize>>20))).Render(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, "choose a CSV file to import", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	rows, err := parseProductCSV(file, time.Now())
+	if err != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		P(Class("text-sm text-red-600"), Text(err.Error())).Render(w)
+		return
+	}
+	a.importProducts(r.Context(), rows)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	Group{importReportComponent(rows), importToast(rows)}.Render(w)
+}
+
+// parseProductCSV reads a product CSV with a header row. Rows that can't be
+// parsed or fail validation get their Err set; an error is only returned
+// when the file as a whole is unusable.
+func parseProductCSV(r io.Reader, now time.Time) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := importColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for name, required := range importColumns {
+		if _, ok := columns[name]; required && !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var rows []importRow
+	// firstLine finds rows repeating an earlier row's product, which a
+	// batch write would reject
+	firstLine := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{Line: parseErr.Line, Err: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("imports are limited to %d rows", maxImportRows)
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := importRow{Line: line}
+		row.Product, err = productFromCSV(field, now)
+		if err == nil {
+			err = row.Product.Validate()
+		}
+		if first, ok := firstLine[row.Product.ProductID]; ok && err == nil {
+			err = fmt.Errorf("product %s is already on line %d", row.Product.ProductID, first)
+		}
+		if err != nil {
+			row.Err = err.Error()
+		} else {
+			firstLine[row.Product.ProductID] = line
+		}
+		rows = append(rows, row)
+	}
+}
+
+// productFromCSV builds a product from a row's fields
+func productFromCSV(field func(name string) string, now time.Time) (models.Product, error) {
+	product := models.Product{
+		ProductID: field("product_id"),
+		Name:      field("name"),
+		Category:  field("category"),
+		Currency:  strings.ToUpper(field("currency")),
+		CreatedAt: now,
+	}
+	var err error
+	if product.Price, err = strconv.ParseFloat(field("price"), 64); err != nil {
+		return product, fmt.Errorf("price %q isn't a number", field("price"))
+	}
+	if v := field("stock"); v != "" {
+		if product.Stock, err = strconv.Atoi(v); err != nil {
+			return product, fmt.Errorf("stock %q isn't a whole number", v)
+		}
+	}
+	if v := field("low_stock_threshold"); v != "" {
+		if product.LowStockThreshold, err = strconv.Atoi(v); err != nil {
+			return product, fmt.Errorf("low_stock_threshold %q isn't a whole number", v)
+		}
+	}
+	if v := field("featured"); v != "" {
+		if product.Featured, err = strconv.ParseBool(v); err != nil {
+			return product, fmt.Errorf("featured %q isn't true or false", v)
+		}
+	}
+	return product, nil
+}
+
+// importProducts writes the rows without errors, in batches when the
+// catalog supports them, and records the rows that failed
+func (a *App) importProducts(ctx context.Context, rows []importRow) {
+	var valid []*importRow
+	for i := range rows {
+		if rows[i].Err == "" {
+			valid = append(valid, &rows[i])
+		}
+	}
+
+	batch, ok := a.products.(batchProducts)
+	if !ok {
+		for _, row := range valid {
+			if err := a.products.Put(ctx, row.Product); err != nil {
+				row.Err = err.Error()
+			}
+		}
+		return
+	}
+
+	products := make([]models.Product, len(valid))
+	for i, row := range valid {
+		products[i] = row.Product
+	}
+	result, err := batch.PutMany(ctx, products)
+	succeeded := make(map[repository.SortKey]bool)
+	failed := make(map[repository.SortKey]string)
+	if result != nil {
+		for _, key := range result.Succeeded {
+			succeeded[key.SK] = true
+		}
+		for _, failure := range result.Failed {
+			failed[failure.Key.SK] = failure.Reason
+		}
+	}
+	for _, row := range valid {
+		sk := repository.Key.ProductSK(row.Product.ProductID)
+		switch {
+		case failed[sk] != "":
+			row.Err = failed[sk]
+		case !succeeded[sk] && err != nil:
+			// The import was abandoned before reaching this row
+			row.Err = err.Error()
+		}
+	}
+}
+
+// productImportFormComponent renders the CSV upload form, which shows the
+// import report below it
+func productImportFormComponent(csrfToken string) Node {
+	return Div(
+		Class("space-y-6"),
+		Form(
+			Method("post"),
+			EncType("multipart/form-data"),
+			Class("space-y-4 bg-white p-6 rounded-lg shadow-sm"),
+			Attr("hx-post", "/admin/products/import"),
+			Attr("hx-encoding", "multipart/form-data"),
+			Attr("hx-target", "#import-report"),
+			csrfInput(csrfToken),
+			H1(Class("text-2xl font-bold text-gray-900"), Text("Import products")),
+			P(
+				Class("text-sm text-gray-500"),
+				Text(fmt.Sprintf("A CSV with a header row of product_id, name, category and price, and optionally currency, stock, low_stock_threshold and featured. Up to %d rows; existing products are replaced.", maxImportRows)),
+			),
+			Input(Type("file"), Name("file"), Accept(".csv,text/csv"), Required()),
+			Button(
+				Type("submit"),
+				Class("rounded bg-blue-600 px-4 py-2 text-white hover:bg-blue-700"),
+				Text("Import"),
+			),
+		),
+		Div(ID("import-report")),
+	)
+}
+
+// importToast sums up an import in a toast next to its report
+func importToast(rows []importRow) Node {
+	var failed int
+	for _, row := range rows {
+		if row.Err != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return toastOOB(FlashError, fmt.Sprintf("%d of %d rows failed to import.", failed, len(rows)))
+	}
+	return toastOOB(FlashSuccess, fmt.Sprintf("Imported %d products.", len(rows)))
+}
+
+// importReportComponent lists each row's outcome under a count of both
+func importReportComponent(rows []importRow) Node {
+	var imported int
+	var tableRows []Node
+	for _, row := range rows {
+		status := Td(Class("py-1 text-green-700"), Text("Imported"))
+		if row.Err != "" {
+			status = Td(Class("py-1 text-red-600"), Text(row.Err))
+		} else {
+			imported++
+		}
+		tableRows = append(tableRows, Tr(
+			Td(Class("py-1 pr-4 text-gray-500"), Text(strconv.Itoa(row.Line))),
+			Td(Class("py-1 pr-4 text-gray-700"), Text(row.Product.ProductID)),
+			status,
+		))
+	}
+
+	return Div(
+		Class("bg-white rounded-lg shadow-sm p-6 space-y-3"),
+		P(
+			Class("font-medium text-gray-900"),
+			Text(fmt.Sprintf("%d imported, %d failed", imported, len(rows)-imported)),
+		),
+		If(len(tableRows) > 0,
+			Table(
+				Class("w-full text-sm"),
+				THead(Tr(
+					Th(Class("py-1 text-left"), Text("Line")),
+					Th(Class("py-1 text-left"), Text("Product")),
+					Th(Class("py-1 text-left"), Text("Result")),
+				)),
+				TBody(tableRows...),
+			),
+		),
+	)
+}
@@ -0,0 +1,134 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+func TestParseProductCSV(t *testing.T) {
+	csv := `product_id,name,category,price,stock,featured
+PROD1,Laptop,Electronics,999.99,5,true
+PROD2,Mouse,Electronics,cheap,1,false
+PROD3,,Books,10,,
+PROD1,Laptop again,Electronics,899,1,
+`
+	rows, err := parseProductCSV(strings.NewReader(csv), time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("Parsed %d rows, want 4", len(rows))
+	}
+	laptop := rows[0].Product
+	if rows[0].Err != "" || rows[0].Line != 2 || laptop.Price != 999.99 || laptop.Stock != 5 || !laptop.Featured {
+		t.Errorf("Row 1 = %+v, want a featured laptop on line 2", rows[0])
+	}
+	for i, want := range []string{"isn't a number", "name is required", "already on line 2"} {
+		if row := rows[i+1]; !strings.Contains(row.Err, want) {
+			t.Errorf("Line %d error = %q, want it to mention %q", row.Line, row.Err, want)
+		}
+	}
+
+	// Test files the import can't make sense of are rejected whole
+	for name, csv := range map[string]string{
+		"empty":          "",
+		"missing column": "product_id,name,category\nPROD1,Laptop,Electronics\n",
+		"unknown column": "product_id,name,category,price,colour\n",
+	} {
+		if _, err := parseProductCSV(strings.NewReader(csv), time.Now()); err == nil {
+			t.Errorf("%s: expected an error, got nil", name)
+		}
+	}
+}
+
+// importCatalog records the products put, failing the ones in fail
+type importCatalog struct {
+	repository.Catalog
+	put  []string
+	fail map[string]bool
+}
+
+func (c *importCatalog) Put(ctx context.Context, product models.Product) error {
+	if c.fail[product.ProductID] {
+		return errors.New("write failed")
+	}
+	c.put = append(c.put, product.ProductID)
+	return nil
+}
+
+// batchCatalog is an importCatalog that writes in batches
+type batchCatalog struct {
+	*importCatalog
+}
+
+func (c batchCatalog) PutMany(ctx context.Context, products []models.Product) (*repository.BatchResult, error) {
+	result := &repository.BatchResult{}
+	for _, product := range products {
+		key := repository.ItemKey{PK: repository.Key.ProductPK(), SK: repository.Key.ProductSK(product.ProductID)}
+		if err := c.Put(ctx, product); err != nil {
+			result.Failed = append(result.Failed, repository.BatchFailure{Key: key, Reason: err.Error(), Err: err})
+			continue
+		}
+		result.Succeeded = append(result.Succeeded, key)
+	}
+	return result, nil
+}
+
+func TestImportProducts(t *testing.T) {
+	for name, catalog := range map[string]func(*importCatalog) repository.Catalog{
+		"one by one": func(c *importCatalog) repository.Catalog { return c },
+		"batched":    func(c *importCatalog) repository.Catalog { return batchCatalog{c} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			products := &importCatalog{fail: map[string]bool{"PROD2": true}}
+			app := &App{products: catalog(products)}
+			rows := []importRow{
+				{Line: 2, Product: models.Product{ProductID: "PROD1"}},
+				{Line: 3, Product: models.Product{ProductID: "PROD2"}},
+				{Line: 4, Product: models.Product{ProductID: "PROD3"}, Err: "invalid"},
+			}
+			app.importProducts(context.Background(), rows)
+
+			if fmt.Sprint(products.put) != "[PROD1]" {
+				t.Errorf("Put %v, want only PROD1", products.put)
+			}
+			if rows[0].Err != "" || rows[1].Err != "write failed" || rows[2].Err != "invalid" {
+				t.Errorf("Row errors = %q, %q, %q", rows[0].Err, rows[1].Err, rows[2].Err)
+			}
+		})
+	}
+}
+
+func TestAdminImportProductsUploadHandler_TooLarge(t *testing.T) {
+	catalog := &importCatalog{}
+	app := &App{products: catalog}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "products.csv")
+	part.Write([]byte("product_id,name,category,price\n"))
+	part.Write(bytes.Repeat([]byte("PROD1,Laptop,Electronics,999.99\n"), maxImportSize/30))
+	form.Close()
+	r := httptest.NewRequest("POST", "/admin/products/import", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	app.adminImportProductsUploadHandler(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status = %d, want 413", w.Code)
+	}
+	if len(catalog.put) != 0 {
+		t.Errorf("Imported %v, want nothing", catalog.put)
+	}
+}
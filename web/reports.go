@@ -0,0 +1,169 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents/html"
+)
+
+// maxReportDays bounds the sales range so a report is at most a year of rollups
+const maxReportDays = 366
+
+// adminReportsHandler shows daily sales for the last ?days= days (30 by default)
+func (a *App) adminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxReportDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxReportDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	to := time.Now()
+	sales, err := a.reports.GetSalesRange(r.Context(), to.AddDate(0, 0, 1-days), to)
+	if err != nil {
+		log.Printf("failed to load sales: %v", err)
+		http.Error(w, "failed to load sales", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	BaseHTML(
+		r.Context(),
+		Div(
+			a.navbar(r),
+			salesReportComponent(sales, a.chartRevenue(r.Context(), sales), days),
+		),
+	).Render(w)
+}
+
+// chartRevenue is each day's revenue in DefaultCurrency, so days taking
+// different currencies can share a chart. It is only for the bars' heights:
+// the converter's rates are rough, so totals are shown in their own
+// currencies. Currencies the converter can't convert are left out.
+func (a *App) chartRevenue(ctx context.Context, sales []models.DailySales) []float64 {
+	chart := make([]float64, len(sales))
+	for i, day := range sales {
+		for currency, amount := range day.Revenue {
+			converted, err := a.converter.Convert(ctx, amount, currency, models.DefaultCurrency)
+			if err != nil {
+				log.Printf("failed to chart %s revenue: %v", currency, err)
+				continue
+			}
+			chart[i] += converted
+		}
+	}
+	return chart
+}
+
+// salesReportComponent renders a bar chart of daily revenue with the totals
+// in each currency. chart is each day's revenue in one currency, for the
+// bars' heights.
+func salesReportComponent(sales []models.DailySales, chart []float64, days int) Node {
+	var orders int
+	revenue := make(map[string]float64)
+	for _, day := range sales {
+		orders += day.OrderCount
+		for currency, amount := range day.Revenue {
+			revenue[currency] += amount
+		}
+	}
+	maxRevenue := 0.0
+	for _, amount := range chart {
+		maxRevenue = max(maxRevenue, amount)
+	}
+
+	var bars []Node
+	for i, day := range sales {
+		height := 0.0
+		if maxRevenue > 0 {
+			height = chart[i] / maxRevenue * 100
+		}
+		bars = append(bars, Div(
+			Class("flex-1 bg-blue-500 hover:bg-blue-700"),
+			StyleAttr(fmt.Sprintf("height: %.1f%%", height)),
+			TitleAttr(fmt.Sprintf("%s: %s from %d orders", day.Date, formatTotals(day.Revenue), day.OrderCount)),
+		))
+	}
+
+	rangeLink := func(n int) Node {
+		class := "text-blue-600 hover:underline"
+		if n == days {
+			class = "font-semibold text-gray-900"
+		}
+		return A(
+			Href(fmt.Sprintf("/admin/reports?days=%d", n)),
+			Class(class),
+			Text(fmt.Sprintf("%d days", n)),
+		)
+	}
+
+	return Div(
+		Class("space-y-6"),
+		Div(
+			Class("flex justify-between items-center"),
+			H1(Class("text-2xl font-bold text-gray-900"), Text("Sales")),
+			Div(Class("space-x-4 text-sm"), rangeLink(7), rangeLink(30), rangeLink(90)),
+		),
+		Div(
+			Class("grid grid-cols-2 gap-4"),
+			statComponent("Revenue", formatTotals(revenue)),
+			statComponent("Orders completed", strconv.Itoa(orders)),
+		),
+		Div(
+			Class("bg-white rounded-lg shadow-sm p-6"),
+			Div(append([]Node{Class("flex items-end gap-px h-48"), Aria("label", "Daily revenue")}, bars...)...),
+			If(len(sales) > 0,
+				Div(
+					Class("flex justify-between mt-2 text-xs text-gray-500"),
+					Span(Text(sales[0].Date)),
+					Span(Text(sales[len(sales)-1].Date)),
+				),
+			),
+		),
+	)
+}
+
+// formatTotals formats an amount in each currency, by currency code, such
+// as "€30.00 + $120.00"
+func formatTotals(totals map[string]float64) string {
+	if len(totals) == 0 {
+		return money.Format(0, models.DefaultCurrency)
+	}
+	currencies := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	parts := make([]string, len(currencies))
+	for i, currency := range currencies {
+		parts[i] = money.Format(totals[currency], currency)
+	}
+	return strings.Join(parts, " + ")
+}
+
+// statComponent renders a labelled headline number
+func statComponent(label, value string) Node {
+	return Div(
+		Class("bg-white rounded-lg shadow-sm p-6"),
+		P(Class("text-sm text-gray-500"), Text(label)),
+		P(Class("text-2xl font-bold text-gray-900"), Text(value)),
+	)
+}
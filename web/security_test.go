@@ -0,0 +1,52 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	// NEVER undo this dot import
+	. "maragu.dev/gomponents"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	var page strings.Builder
+	handler := SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page.Reset()
+		BaseHTML(r.Context(), Text("content")).Render(&page)
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	w := serve()
+	for header, want := range map[string]string{
+		"X-Frame-Options":        "DENY",
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	csp := w.Header().Get("Content-Security-Policy")
+	_, rest, ok := strings.Cut(csp, "'nonce-")
+	if !ok {
+		t.Fatalf("Content-Security-Policy = %q, want a script nonce", csp)
+	}
+	nonce, _, _ := strings.Cut(rest, "'")
+	if strings.Contains(csp, "unsafe-eval") || !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("Content-Security-Policy = %q", csp)
+	}
+	if n := strings.Count(page.String(), `nonce="`+nonce+`"`); n != strings.Count(page.String(), "<script") {
+		t.Errorf("%d scripts carry the nonce in %s", n, page.String())
+	}
+
+	if strings.Contains(serve().Header().Get("Content-Security-Policy"), nonce) {
+		t.Error("Expected a new nonce for each request")
+	}
+}
@@ -2,12 +2,25 @@ package web
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"LearnSingleTableDesign/config"
+	"LearnSingleTableDesign/graph"
+	"LearnSingleTableDesign/images"
+	"LearnSingleTableDesign/invoices"
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/money"
+	"LearnSingleTableDesign/notifications"
 	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/search"
+	"LearnSingleTableDesign/web/assets"
 
 	// NEVER undo this dot import
 	. "maragu.dev/gomponents"
@@ -16,26 +29,26 @@ import (
 	. "maragu.dev/gomponents/html"
 )
 
-func BaseHTML(content Node) Node {
+// BaseHTML is the page layout. Its scripts carry the request's CSP nonce.
+func BaseHTML(ctx context.Context, content Node) Node {
+	nonce := If(CSPNonce(ctx) != "", Attr("nonce", CSPNonce(ctx)))
 	return HTML(
 		Lang("en"),
 		Head(
 			Meta(Charset("utf-8")),
 			Meta(Name("viewport"), Content("width=device-width, initial-scale=1.0")),
 			Title("Your App"),
-			// Tailwind CSS CDN
-			Script(Src("https://cdn.tailwindcss.com")),
-			// HTMX CDN
-			Script(Src("https://unpkg.com/htmx.org@1.9.10")),
-			// Add HTMX attributes to body
-			Script(Raw(`
-				htmx.config = {
-					defaultSwapStyle: 'innerHTML'
-				}
-			`)),
+			// Served from the binary, see the assets package
+			Script(Src(assets.URL("vendor/tailwind.js")), nonce),
+			Script(Src(assets.URL("vendor/htmx.min.js")), nonce),
+			// Configures htmx and handles data-toggle and data-dismiss
+			Script(Src(assets.URL("app.js")), nonce),
 		),
 		Body(
 			Class("min-h-screen bg-gray-50"),
+			// htmx requests send the CSRF token from here, so buttons
+			// outside a form can post too
+			If(CSRFToken(ctx) != "", Attr("hx-headers", `{"`+csrfHeader+`": "`+CSRFToken(ctx)+`"}`)),
 			Div(
 				Class("mx-auto max-w-3xl px-4 sm:px-6 lg:px-8"), // Container with responsive padding
 				content,
@@ -44,7 +57,13 @@ func BaseHTML(content Node) Node {
 	)
 }
 
-func Navbar() Node {
+func Navbar(links []NavLink) Node {
+	var desktopItems, mobileItems []Node
+	for _, link := range links {
+		desktopItems = append(desktopItems, Li(navLinkAnchor(link, "transition-colors")))
+		mobileItems = append(mobileItems, Li(navLinkAnchor(link, "block transition-colors")))
+	}
+
 	return Nav(
 		Class("sticky top-0 bg-white shadow-sm mb-8"),
 		Div(
@@ -61,10 +80,7 @@ func Navbar() Node {
 				Div(
 					Class("hidden sm:block"), // Hide on mobile
 					Ol(
-						Class("flex space-x-8"),
-						Li(A(Href("/"), Class("text-gray-700 hover:text-blue-600 transition-colors"), Text("Home"))),
-						Li(A(Href("/contact"), Class("text-gray-700 hover:text-blue-600 transition-colors"), Text("Contact"))),
-						Li(A(Href("/about"), Class("text-gray-700 hover:text-blue-600 transition-colors"), Text("About"))),
+						append([]Node{Class("flex space-x-8")}, desktopItems...)...,
 					),
 				),
 				// Mobile menu button
@@ -72,71 +88,220 @@ func Navbar() Node {
 					Type("button"),
 					Class("sm:hidden p-2 text-gray-700 hover:text-blue-600"),
 					Attr("aria-label", "Toggle menu"),
+					Attr("aria-controls", "mobile-menu"),
+					Attr("aria-expanded", "false"),
+					Data("toggle", ""),
 					Text("☰"),
 				),
 			),
 		),
 		// Mobile menu (hidden by default)
 		Div(
-			Class("sm:hidden hidden"), // Initially hidden, toggle with HTMX
+			Class("sm:hidden hidden"), // Initially hidden, toggled by the menu button
 			Attr("id", "mobile-menu"),
 			Ol(
-				Class("flex flex-col space-y-4 px-4 py-6"),
-				Li(A(Href("/"), Class("text-gray-700 hover:text-blue-600 block transition-colors"), Text("Home"))),
-				Li(A(Href("/contact"), Class("text-gray-700 hover:text-blue-600 block transition-colors"), Text("Contact"))),
-				Li(A(Href("/about"), Class("text-gray-700 hover:text-blue-600 block transition-colors"), Text("About"))),
+				append([]Node{Class("flex flex-col space-y-4 px-4 py-6")}, mobileItems...)...,
 			),
 		),
 	)
 }
 
+// navLinkAnchor renders a navbar link, highlighted when it is active
+func navLinkAnchor(link NavLink, classes string) Node {
+	if link.Active {
+		return A(Href(link.Href), Class("text-blue-600 font-medium "+classes), Attr("aria-current", "page"), Text(link.Label))
+	}
+	return A(Href(link.Href), Class("text-gray-700 hover:text-blue-600 "+classes), Text(link.Label))
+}
+
 func (a *App) indexHandler(w http.ResponseWriter, r *http.Request) {
+	products, err := a.listProductsComponent(r.Context(), requestLocales(r), requestCurrency(w, r))
+	if err != nil {
+		slog.Error("failed to load products", "error", err)
+		http.Error(w, "failed to load products", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte("<!DOCTYPE html>\n"))
 	BaseHTML(
+		r.Context(),
 		Div(
-			Navbar(),
-			a.listProductsComponent(),
+			a.navbar(r),
+			products,
 		),
 	).Render(w)
 }
 
-func (a *App) listProductsComponent() Node {
-	products, err := a.products.All(context.Background(), nil)
+// productPageSize is how many products each page of the listing loads
+const productPageSize = 12
+
+// featuredLimit is how many products the homepage carousel shows
+const featuredLimit = 10
+
+func (a *App) listProductsComponent(ctx context.Context, locales []string, currency string) (Node, error) {
+	products, content, err := a.productPage(ctx, locales, currency, nil)
+	if err != nil {
+		return nil, err
+	}
+	featured, err := a.products.Featured(ctx, &repository.QueryOptions{Limit: featuredLimit})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	a.priceIn(ctx, featured.Products, currency)
+	featuredContent, err := a.productContent(ctx, featured.Products, locales)
+	if err != nil {
+		return nil, err
+	}
+	return Div(
+		Class("space-y-8"),
+		featuredCarouselComponent(featured.Products, featuredContent, a.carts != nil),
+		productListComponent(products.Products, content, productsPageURL(products.NextPageToken), a.carts != nil),
+	), nil
+}
 
-	productsLoaded := len(products.Products)
+// featuredCarouselComponent renders the featured products as a row that
+// scrolls sideways, snapping to each card. It renders nothing when no
+// products are featured.
+func featuredCarouselComponent(products []models.Product, content map[string]models.ProductContent, addToCart bool) Node {
+	if len(products) == 0 {
+		return nil
+	}
+	return Section(
+		Class("space-y-4"),
+		H2(
+			Class("text-xl font-bold text-gray-900"),
+			Text("Featured"),
+		),
+		Div(
+			ID("featured-carousel"),
+			Class("flex gap-6 overflow-x-auto snap-x snap-mandatory pb-2"),
+			Map(products, func(product models.Product) Node {
+				return Div(
+					Class("snap-start shrink-0 w-72"),
+					productCard(product, content, addToCart),
+				)
+			}),
+		),
+	)
+}
 
-	var productNodes []Node
-	for _, product := range products.Products {
-		productNodes = append(productNodes,
-			Div(
-				Class("bg-white p-6 rounded-lg shadow-sm border border-gray-200"),
-				Div(
-					Class("space-y-3"),
-					H3(
-						Class("text-lg font-semibold text-gray-900"),
-						Text(product.Name),
-					),
-					P(
-						Class("text-sm text-gray-500"),
-						Text(fmt.Sprintf("Category: %s", product.Category)),
-					),
-					P(
-						Class("text-lg font-medium text-gray-900"),
-						Text(fmt.Sprintf("$%.2f", product.Price)),
-					),
-					P(
-						Class("text-sm text-gray-600"),
-						Text(fmt.Sprintf("Stock: %d", product.Stock)),
-					),
-				),
-			),
-		)
+// productsPageHandler returns the next page of product cards as an HTML
+// fragment for the listing's infinite scroll
+func (a *App) productsPageHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := repository.ParsePageToken(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	products, content, err := a.productPage(r.Context(), requestLocales(r), requestCurrency(w, r), token)
+	if err != nil {
+		slog.Error("failed to load products page", "error", err)
+		http.Error(w, "failed to load products", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	productCardsFragment(products.Products, content, productsPageURL(products.NextPageToken), a.carts != nil).Render(w)
+}
+
+// productsSearchHandler returns the product cards matching the q parameter
+// as an HTML fragment for the live search box. An empty query returns the
+// first page of all products.
+func (a *App) productsSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	token, err := repository.ParsePageToken(r.URL.Query().Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var products *repository.ProductsPage
+	var nextURL string
+	if query == "" {
+		products, err = a.products.All(r.Context(), &repository.QueryOptions{Limit: productPageSize, PageToken: token})
+		if err == nil {
+			nextURL = productsPageURL(products.NextPageToken)
+		}
+	} else {
+		products, err = a.search.SearchProducts(r.Context(), query, &repository.QueryOptions{Limit: productPageSize, PageToken: token})
+		if err == nil {
+			nextURL = productsSearchURL(query, products.NextPageToken)
+		}
+	}
+	if err != nil {
+		slog.Error("failed to search products", "error", err)
+		http.Error(w, "failed to search products", http.StatusInternalServerError)
+		return
+	}
+	content, err := a.productContent(r.Context(), products.Products, requestLocales(r))
+	if err != nil {
+		slog.Error("failed to load product content", "error", err)
+		http.Error(w, "failed to search products", http.StatusInternalServerError)
+		return
+	}
+	a.priceIn(r.Context(), products.Products, requestCurrency(w, r))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if len(products.Products) == 0 && token == nil {
+		noProductsComponent(query).Render(w)
+		return
+	}
+	productCardsFragment(products.Products, content, nextURL, a.carts != nil).Render(w)
+}
+
+// productPage loads a page of products and their content in the request's
+// preferred locale, priced in its currency
+func (a *App) productPage(ctx context.Context, locales []string, currency string, token *repository.PageToken) (*repository.ProductsPage, map[string]models.ProductContent, error) {
+	products, err := a.products.All(ctx, &repository.QueryOptions{Limit: productPageSize, PageToken: token})
+	if err != nil {
+		return nil, nil, err
+	}
+	a.priceIn(ctx, products.Products, currency)
+	content, err := a.productContent(ctx, products.Products, locales)
+	if err != nil {
+		return nil, nil, err
+	}
+	return products, content, nil
+}
+
+// productContent looks up each product's content in the preferred locale
+func (a *App) productContent(ctx context.Context, products []models.Product, locales []string) (map[string]models.ProductContent, error) {
+	content := make(map[string]models.ProductContent)
+	for _, product := range products {
+		c, err := a.products.GetContent(ctx, product.ProductID, locales)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		content[product.ProductID] = *c
+	}
+	return content, nil
+}
+
+// productsPageURL is the fragment URL for the page after next, or "" on the last page
+func productsPageURL(next *repository.PageToken) string {
+	if next == nil {
+		return ""
+	}
+	return "/products/page?cursor=" + url.QueryEscape(next.String())
+}
+
+// productsSearchURL is the fragment URL for the next page of search results
+func productsSearchURL(query string, next *repository.PageToken) string {
+	if next == nil {
+		return ""
 	}
+	return "/products/search?" + url.Values{"q": {query}, "cursor": {next.String()}}.Encode()
+}
 
+// productListComponent renders the products header and grid, using the
+// localized content for a product's name and description when there is one.
+// When nextURL is set the grid ends with a trigger that loads the next page.
+// With addToCart each card has a button adding it to the cart.
+func productListComponent(products []models.Product, content map[string]models.ProductContent, nextURL string, addToCart bool) Node {
 	return Div(
 		Class("space-y-6"),
 		// Header section
@@ -146,47 +311,313 @@ func (a *App) listProductsComponent() Node {
 				Class("text-2xl font-bold text-gray-900"),
 				Text("Products"),
 			),
-			Div(
-				Class("text-sm text-gray-500"),
-				Text(fmt.Sprintf("Total products: %d", productsLoaded)),
-			),
+			searchBoxComponent(),
 		),
 		// Products grid
 		Div(
-			append(
-				[]Node{Class("grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 gap-6")},
-				productNodes...,
-			)...,
+			ID("product-grid"),
+			Class("grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 gap-6"),
+			productCardsFragment(products, content, nextURL, addToCart),
+		),
+	)
+}
+
+// productCardsFragment renders product cards followed by the load more
+// trigger for the next page, if any
+func productCardsFragment(products []models.Product, content map[string]models.ProductContent, nextURL string, addToCart bool) Node {
+	return Group{
+		Map(products, func(product models.Product) Node {
+			return productCard(product, content, addToCart)
+		}),
+		If(nextURL != "", loadMoreComponent(nextURL)),
+	}
+}
+
+func productCard(product models.Product, content map[string]models.ProductContent, addToCart bool) Node {
+	name := product.Name
+	var description string
+	if c, ok := content[product.ProductID]; ok {
+		name = c.Name
+		description = c.Description
+	}
+
+	return Div(
+		Class("bg-white p-6 rounded-lg shadow-sm border border-gray-200"),
+		Div(
+			Class("space-y-3"),
+			If(product.ImageURL != "",
+				Img(
+					Src(product.ImageURL),
+					Alt(name),
+					Loading("lazy"),
+					Class("h-40 w-full rounded object-cover"),
+				),
+			),
+			H3(
+				Class("text-lg font-semibold text-gray-900"),
+				Text(name),
+			),
+			If(description != "",
+				Div(
+					Class("prose prose-sm text-gray-700"),
+					markdown(description),
+				),
+			),
+			P(
+				Class("text-sm text-gray-500"),
+				Text(fmt.Sprintf("Category: %s", product.Category)),
+			),
+			P(
+				Class("text-lg font-medium text-gray-900"),
+				Text(money.Format(product.Price, product.PriceCurrency())),
+			),
+			P(
+				Class("text-sm text-gray-600"),
+				Text(fmt.Sprintf("Stock: %d", product.Stock)),
+			),
+			If(addToCart, addToCartButton(product.ProductID)),
+		),
+	)
+}
+
+// loadMoreComponent fetches the next page when scrolled into view or
+// clicked, and replaces itself with the returned cards
+func loadMoreComponent(nextURL string) Node {
+	return Div(
+		Class("col-span-full flex justify-center"),
+		Button(
+			Type("button"),
+			Class("px-4 py-2 text-sm text-blue-600 hover:text-blue-800"),
+			Attr("hx-get", nextURL),
+			Attr("hx-trigger", "revealed, click"),
+			Attr("hx-target", "closest div"),
+			Attr("hx-swap", "outerHTML"),
+			Text("Load more"),
 		),
 	)
 }
 
+// searchBoxComponent filters the product grid by name prefix as the user types
+func searchBoxComponent() Node {
+	return Input(
+		Type("search"),
+		Name("q"),
+		Placeholder("Search products"),
+		Attr("aria-label", "Search products"),
+		Class("w-48 rounded-md border border-gray-300 px-3 py-1.5 text-sm focus:border-blue-500 focus:outline-none"),
+		Attr("hx-get", "/products/search"),
+		Attr("hx-trigger", "keyup changed delay:300ms, search"),
+		Attr("hx-target", "#product-grid"),
+	)
+}
+
+// noProductsComponent fills the grid when a search matches nothing
+func noProductsComponent(query string) Node {
+	return P(
+		Class("col-span-full text-center text-gray-500"),
+		Text(fmt.Sprintf("No products match %q.", query)),
+	)
+}
+
 type App struct {
-	users    *repository.UserRepository
+	users    repository.Users
 	orders   *repository.OrderRepository
-	products *repository.ProductRepository
+	products repository.Catalog
+	pages    repository.Pages
+	// reports, tables and audit are nil on the SQLite backend, which has
+	// no admin dashboard
+	reports *repository.ReportRepository
+	tables  *repository.TableRepository
+	audit   *repository.AuditRepository
+	// contacts stores contact form messages; nil on the SQLite backend
+	contacts *repository.ContactRepository
+	// carts stores visitors' carts; nil on the SQLite backend
+	carts *repository.CartRepository
+	// orderService places, cancels and refunds orders; nil on the SQLite
+	// backend
+	orderService *repository.OrderService
+	// impersonations lets admins view the store as a user; nil on the
+	// SQLite backend
+	impersonations *repository.ImpersonationRepository
+	// notificationPrefs stores which emails users want; nil on the SQLite
+	// backend
+	notificationPrefs *repository.NotificationPrefsRepository
+	// settingsLinks checks the links to notification settings in order
+	// emails; nil when customers can't change their own
+	settingsLinks *notifications.SettingsLinks
+	search        search.Service
+	// converter prices products in the visitor's currency
+	converter money.Converter
+	// readOnly is the maintenance switch shared by every repository
+	readOnly  *repository.ReadOnlySwitch
+	pageCache *pageCache
+	// entityCounts caches the dashboard's per-entity item counts
+	entityCounts *entityCountCache
+	// invoices signs invoice download links; nil when invoices are off
+	invoices *invoices.Links
+	// images stores uploaded product images; nil when uploads are off
+	images images.Store
 }
 
 func Start(
-	userRepo *repository.UserRepository,
+	cfg config.Config,
+	userRepo repository.Users,
 	orderRepo *repository.OrderRepository,
-	productRepo *repository.ProductRepository,
+	productRepo repository.Catalog,
+	pageRepo repository.Pages,
+	reportRepo *repository.ReportRepository,
+	tableRepo *repository.TableRepository,
+	auditRepo *repository.AuditRepository,
+	contactRepo *repository.ContactRepository,
+	cartRepo *repository.CartRepository,
+	orderService *repository.OrderService,
+	impersonationRepo *repository.ImpersonationRepository,
+	notificationPrefsRepo *repository.NotificationPrefsRepository,
+	settingsLinks *notifications.SettingsLinks,
+	searcher search.Service,
+	converter money.Converter,
+	readOnly *repository.ReadOnlySwitch,
+	invoiceLinks *invoices.Links,
+	imageStore images.Store,
 ) {
 	app := &App{
 		users:    userRepo,
 		orders:   orderRepo,
 		products: productRepo,
+		pages:    pageRepo,
+		reports:  reportRepo,
+		tables:   tableRepo,
+		audit:    auditRepo,
+		contacts: contactRepo,
+		carts:    cartRepo,
+
+		orderService:      orderService,
+		impersonations:    impersonationRepo,
+		notificationPrefs: notificationPrefsRepo,
+		settingsLinks:     settingsLinks,
+		search:            searcher,
+		converter:         converter,
+		readOnly:          readOnly,
+		pageCache:         &pageCache{},
+
+		entityCounts: &entityCountCache{},
+		invoices:     invoiceLinks,
+		images:       imageStore,
 	}
 
 	// Create a new ServeMux to use our middleware
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", app.indexHandler)
+	mux.HandleFunc("GET /{$}", app.indexHandler)
+	mux.Handle("GET "+assets.Prefix, assets.Handler())
+	mux.HandleFunc("GET /products/page", app.productsPageHandler)
+	mux.HandleFunc("GET /products/search", app.productsSearchHandler)
+	mux.HandleFunc("GET /{slug}", app.pageHandler)
+	mux.HandleFunc("GET /admin/pages", app.adminPagesHandler)
+	mux.HandleFunc("GET /admin/pages/new", app.adminNewPageHandler)
+	mux.HandleFunc("GET /admin/pages/{slug}/edit", app.adminEditPageHandler)
+	mux.HandleFunc("POST /admin/pages", app.adminSavePageHandler)
+	mux.HandleFunc("POST /admin/markdown/preview", app.markdownPreviewHandler)
+	// Exports hold a user's personal data, and there is no customer sign-in
+	// to prove someone is that user, so only the admin can take them
+	mux.Handle("GET /api/v1/users/{email}/export", RequireAdmin(http.HandlerFunc(app.userExportHandler)))
+	mux.HandleFunc("GET /api/v1/openapi.json", app.openAPIHandler)
+	mux.HandleFunc("GET /admin/products/import", app.adminImportProductsHandler)
+	mux.HandleFunc("POST /admin/products/import", app.adminImportProductsUploadHandler)
+	if tableRepo != nil {
+		mux.HandleFunc("GET /admin/reports", app.adminReportsHandler)
+		mux.HandleFunc("GET /admin", app.adminDashboardHandler)
+		mux.HandleFunc("GET /admin/dashboard/stats", app.adminDashboardStatsHandler)
+		mux.HandleFunc("GET /admin/audit", app.adminAuditHandler)
+		mux.HandleFunc("POST /admin/maintenance", app.adminMaintenanceHandler)
+	}
+	if orderRepo != nil {
+		// Like the export they show a user's orders to anyone who knows the
+		// email, so they're the admin's only
+		mux.Handle("GET /users/{email}/orders", RequireAdmin(http.HandlerFunc(app.userOrdersHandler)))
+		mux.Handle("GET /users/{email}/orders/{id}", RequireAdmin(http.HandlerFunc(app.orderDetailHandler)))
+		mux.HandleFunc("GET /admin/orders/{email}/{id}", app.adminOrderDetailHandler)
+		mux.HandleFunc("POST /admin/orders/{email}/{id}/status", app.adminOrderTransitionHandler)
+		mux.HandleFunc("POST /admin/orders/{email}/{id}/repair-total", app.adminOrderRepairTotalHandler)
+		if orderService != nil {
+			mux.Handle("POST /admin/orders/{email}/{id}/cancel", RequireAdmin(http.HandlerFunc(app.adminOrderCancelHandler)))
+			mux.Handle("POST /admin/orders/{email}/{id}/refund", RequireAdmin(http.HandlerFunc(app.adminOrderRefundHandler)))
+		}
+		// It reads users' orders, so like the export it's the admin's only
+		mux.Handle("/graphql", RequireAdmin(graph.NewServer(userRepo, orderRepo, productRepo)))
+	}
+	if contactRepo != nil {
+		mux.HandleFunc("GET /contact", app.contactHandler)
+		mux.HandleFunc("POST /contact", app.contactSubmitHandler)
+		mux.HandleFunc("POST /api/v1/contact", app.apiContactHandler)
+		mux.HandleFunc("GET /admin/contact", app.adminContactHandler)
+	}
+	if cartRepo != nil {
+		mux.HandleFunc("GET /cart", app.cartHandler)
+		mux.HandleFunc("POST /cart/items", app.cartAddHandler)
+		mux.HandleFunc("POST /cart/items/{id}/remove", app.cartRemoveHandler)
+		if orderService != nil {
+			mux.HandleFunc("POST /cart/checkout", app.cartCheckoutHandler)
+		}
+	}
+	if impersonationRepo != nil {
+		mux.HandleFunc("GET "+impersonatePath, app.adminImpersonateHandler)
+		mux.HandleFunc("POST "+impersonatePath, app.adminImpersonateStartHandler)
+		mux.HandleFunc("POST "+impersonatePath+"/stop", app.adminImpersonateStopHandler)
+		mux.HandleFunc("GET /account", app.accountHandler)
+	}
+	if notificationPrefsRepo != nil {
+		mux.HandleFunc("GET /admin/users/{email}/notifications", app.adminNotificationsHandler)
+		mux.HandleFunc("POST /admin/users/{email}/notifications", app.adminNotificationsSaveHandler)
+		// Customers get here from the signed link in their order emails
+		if settingsLinks != nil {
+			mux.HandleFunc("GET "+notifications.SettingsPath, app.notificationsHandler)
+			mux.HandleFunc("POST "+notifications.SettingsPath, app.notificationsSaveHandler)
+		}
+	}
+	if invoiceLinks != nil {
+		mux.HandleFunc("GET /admin/orders/{email}/{id}/invoice", app.adminInvoiceHandler)
+	}
+	if imageStore != nil {
+		mux.HandleFunc("GET "+images.URLPrefix+"{key...}", app.imageHandler)
+		mux.HandleFunc("GET /admin/products/{id}/image", app.adminProductImageHandler)
+		mux.HandleFunc("POST /admin/products/{id}/image", app.adminUploadProductImageHandler)
+	}
 
-	// Wrap the mux with the pretty print middleware
-	handler := PrettyPrintHTML(mux)
+	// Outermost first. Writes are tracked per request in dev mode so
+	// duplicate writes can be reported.
+	middlewares := []Middleware{
+		RequestID,
+		Logging,
+		Recover,
+		SecurityHeaders,
+		Timeout(30 * time.Second),
+		Compress,
+	}
+	if cfg.PrettyHTML {
+		middlewares = append(middlewares, PrettyPrintHTML)
+	}
+	if cfg.AdminPassword == "" {
+		slog.Warn("ADMIN_PASSWORD is not set, so the admin pages are off")
+	}
+	middlewares = append(middlewares, Session, AdminAuth(cfg.AdminUser, cfg.AdminPassword), CSRF, Flashes)
+	if impersonationRepo != nil {
+		middlewares = append(middlewares, app.Impersonation)
+	}
+	if cfg.Dev {
+		middlewares = append(middlewares, TrackWrites)
+	}
+	handler := Chain(mux, middlewares...)
 
-	port := ":8080"
+	port := cfg.Addr()
 	slog.Info("Starting server on", "port", port)
 
 	log.Fatal(http.ListenAndServe(port, handler))
 }
+
+// TrackWrites gives each request its own write log so the store can detect
+// the same item being written twice while handling it
+func TrackWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(repository.TrackWrites(r.Context())))
+	})
+}
@@ -0,0 +1,38 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/repository"
+)
+
+// sessionCookie holds the anonymous session ID
+const sessionCookie = "sid"
+
+// Session makes sure every visitor has a session ID cookie and attaches the
+// session to the request context, so the store can give the session
+// read-your-writes consistency
+func Session(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID := ""
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if _, err := uuid.Parse(cookie.Value); err == nil {
+				sessionID = cookie.Value
+			}
+		}
+		if sessionID == "" {
+			sessionID = uuid.New().String()
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    sessionID,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		next.ServeHTTP(w, r.WithContext(repository.WithSession(r.Context(), sessionID)))
+	})
+}
@@ -0,0 +1,48 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"LearnSingleTableDesign/repository"
+)
+
+func TestSession(t *testing.T) {
+	var got string
+	handler := Session(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = repository.SessionFrom(r.Context())
+	}))
+
+	// Test a new visitor gets a session cookie
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie {
+		t.Fatalf("Expected a session cookie, got %v", cookies)
+	}
+	if got != cookies[0].Value {
+		t.Errorf("Context session = %q, want %q", got, cookies[0].Value)
+	}
+
+	// Test a returning visitor keeps their session
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Expected no new cookie for an existing session")
+	}
+	if got != cookies[0].Value {
+		t.Errorf("Context session = %q, want %q", got, cookies[0].Value)
+	}
+
+	// Test a tampered cookie is replaced
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "not-a-uuid"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if len(w.Result().Cookies()) != 1 || got == "not-a-uuid" {
+		t.Error("Expected an invalid session cookie to be replaced")
+	}
+}
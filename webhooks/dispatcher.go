@@ -0,0 +1,278 @@
+// Package webhooks delivers order lifecycle events to subscribed webhooks.
+//
+// Each payload is signed with the subscription's secret: the
+// X-Webhook-Signature header is "sha256=" followed by the hex HMAC-SHA256
+// of the request body. Every attempt is recorded as a delivery item under
+// the webhook in the table.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+)
+
+// Subscriptions lists webhooks and records delivery attempts.
+// *repository.WebhookRepository implements it.
+type Subscriptions interface {
+	All(ctx context.Context) ([]models.Webhook, error)
+	RecordDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+}
+
+// Event is the JSON payload POSTed to webhooks
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// OrderEventType is the event type for an order entering a status,
+// e.g. "order.completed"
+func OrderEventType(status models.OrderStatus) string {
+	return "order." + string(status)
+}
+
+// Sign returns the X-Webhook-Signature value for a payload
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher queues events from order writes and delivers them in the
+// background. Register Hook on the order store with repository.OnPut and
+// start Run in a goroutine. Each webhook is delivered to by its own worker
+// with its own queue, so an endpoint that is down only holds up its own
+// deliveries while they back off.
+type Dispatcher struct {
+	subscriptions Subscriptions
+	client        *http.Client
+	pending       chan Event
+	buffer        int
+	// MaxAttempts is how often a delivery is tried before giving up
+	MaxAttempts int
+	// Backoff is the delay before the second attempt; it doubles after each retry
+	Backoff time.Duration
+
+	mu sync.Mutex
+	// workers holds the queue of each webhook's worker, by webhook ID
+	workers map[string]chan job
+	running sync.WaitGroup
+}
+
+// job is one event to deliver to one webhook
+type job struct {
+	webhook models.Webhook
+	event   Event
+	body    []byte
+}
+
+// NewDispatcher creates a Dispatcher that queues up to buffer events, and
+// up to buffer deliveries for each webhook
+func NewDispatcher(subscriptions Subscriptions, client *http.Client, buffer int) *Dispatcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Dispatcher{
+		subscriptions: subscriptions,
+		client:        client,
+		pending:       make(chan Event, buffer),
+		buffer:        buffer,
+		MaxAttempts:   5,
+		Backoff:       time.Second,
+	}
+}
+
+// Hook turns every order put through the store into an order.<status>
+// event once the write has succeeded; register it with repository.OnPut.
+// Writes that fail or are cancelled send nothing. It never blocks a write:
+// when the queue is full the event is dropped with a warning.
+func (d *Dispatcher) Hook() repository.ChangeHook {
+	return repository.Typed(repository.EntityOrder, func(ctx context.Context, change repository.Change, item repository.GenericItem[models.Order]) {
+		if change.Phase != repository.AfterWrite {
+			return
+		}
+		d.Enqueue(Event{
+			ID:        uuid.New().String(),
+			Type:      OrderEventType(item.Data.Status),
+			CreatedAt: time.Now(),
+			Data:      item.Data,
+		})
+	})
+}
+
+// LowStockEventType is sent when a product falls below its stock threshold
+const LowStockEventType = "product.low_stock"
+
+// LowStockAlert turns low stock alerts into product.low_stock events
+func (d *Dispatcher) LowStockAlert() repository.LowStockAlert {
+	return func(ctx context.Context, product models.Product) {
+		d.Enqueue(Event{
+			ID:        uuid.New().String(),
+			Type:      LowStockEventType,
+			CreatedAt: time.Now(),
+			Data:      product,
+		})
+	}
+}
+
+// Enqueue queues an event for delivery without blocking
+func (d *Dispatcher) Enqueue(event Event) {
+	select {
+	case d.pending <- event:
+	default:
+		slog.Warn("webhook queue full, dropping event", "event_id", event.ID, "type", event.Type)
+	}
+}
+
+// Run delivers queued events until ctx is done, returning once the
+// webhooks' workers have stopped too
+func (d *Dispatcher) Run(ctx context.Context) {
+	defer d.running.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-d.pending:
+			d.dispatch(ctx, event)
+		}
+	}
+}
+
+// dispatch hands an event to the worker of every webhook subscribed to its
+// type
+func (d *Dispatcher) dispatch(ctx context.Context, event Event) {
+	webhooks, err := d.subscriptions.All(ctx)
+	if err != nil {
+		slog.Error("failed to list webhooks", "event_id", event.ID, "error", err)
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to marshal webhook event", "event_id", event.ID, "error", err)
+		return
+	}
+	for _, webhook := range webhooks {
+		if webhook.Subscribes(event.Type) {
+			d.queue(ctx, job{webhook: webhook, event: event, body: body})
+		}
+	}
+}
+
+// queue hands a job to its webhook's worker, starting the worker on the
+// webhook's first event. It never blocks: when the webhook's queue is full
+// the event is dropped for that webhook alone, with a warning.
+func (d *Dispatcher) queue(ctx context.Context, j job) {
+	d.mu.Lock()
+	jobs, ok := d.workers[j.webhook.WebhookID]
+	if !ok {
+		if d.workers == nil {
+			d.workers = make(map[string]chan job)
+		}
+		jobs = make(chan job, d.buffer)
+		d.workers[j.webhook.WebhookID] = jobs
+		d.running.Add(1)
+		go d.work(ctx, j.webhook.WebhookID, jobs)
+	}
+	d.mu.Unlock()
+
+	select {
+	case jobs <- j:
+	default:
+		slog.Warn("webhook queue full, dropping event", "webhook_id", j.webhook.WebhookID, "event_id", j.event.ID, "type", j.event.Type)
+	}
+}
+
+// work delivers a webhook's jobs one at a time, in order, until ctx is
+// done
+func (d *Dispatcher) work(ctx context.Context, webhookID string, jobs chan job) {
+	defer d.running.Done()
+	defer func() {
+		d.mu.Lock()
+		delete(d.workers, webhookID)
+		d.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-jobs:
+			d.deliver(ctx, j.webhook, j.event, j.body)
+		}
+	}
+}
+
+// deliver POSTs the payload, retrying with backoff until the receiver
+// answers 2xx or the attempts run out, and records every attempt
+func (d *Dispatcher) deliver(ctx context.Context, webhook models.Webhook, event Event, body []byte) {
+	backoff := d.Backoff
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		delivery := d.attempt(ctx, webhook, event, body)
+		delivery.Attempt = attempt
+		if err := d.subscriptions.RecordDelivery(ctx, delivery); err != nil {
+			slog.Error("failed to record webhook delivery", "webhook_id", webhook.WebhookID, "event_id", event.ID, "error", err)
+		}
+		if delivery.Succeeded {
+			return
+		}
+		if attempt == d.MaxAttempts {
+			slog.Warn("giving up on webhook delivery", "webhook_id", webhook.WebhookID, "event_id", event.ID, "attempts", attempt)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends the payload once
+func (d *Dispatcher) attempt(ctx context.Context, webhook models.Webhook, event Event, body []byte) models.WebhookDelivery {
+	delivery := models.WebhookDelivery{
+		WebhookID:   webhook.WebhookID,
+		EventID:     event.ID,
+		EventType:   event.Type,
+		AttemptedAt: time.Now(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = fmt.Sprintf("failed to build request: %v", err)
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-Delivery", event.ID)
+	req.Header.Set("X-Webhook-Signature", Sign(webhook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Succeeded = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Succeeded {
+		delivery.Error = resp.Status
+	}
+	return delivery
+}
@@ -0,0 +1,221 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"LearnSingleTableDesign/models"
+	"LearnSingleTableDesign/repository"
+	"LearnSingleTableDesign/testutil/fixtures"
+)
+
+// memorySubscriptions keeps webhooks and deliveries in memory
+type memorySubscriptions struct {
+	mu         sync.Mutex
+	webhooks   []models.Webhook
+	deliveries []models.WebhookDelivery
+}
+
+func (m *memorySubscriptions) All(ctx context.Context) ([]models.Webhook, error) {
+	return m.webhooks, nil
+}
+
+func (m *memorySubscriptions) RecordDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries = append(m.deliveries, delivery)
+	return nil
+}
+
+// recorded returns the deliveries recorded for webhookID, or for every
+// webhook when it is empty
+func (m *memorySubscriptions) recorded(webhookID string) []models.WebhookDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range m.deliveries {
+		if webhookID == "" || delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// runUntil runs d on events until done holds or five seconds pass, then
+// stops it and waits for its workers
+func runUntil(t *testing.T, d *Dispatcher, done func() bool, events ...Event) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(stopped)
+	}()
+	for _, event := range events {
+		d.Enqueue(event)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-stopped
+	if !done() {
+		t.Fatal("Timed out waiting for deliveries")
+	}
+}
+
+func TestDispatcher_DeliverWithRetries(t *testing.T) {
+	var calls int
+	var gotBody []byte
+	var gotSignature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// Fail the first attempt to exercise the retry
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get("X-Webhook-Signature")
+	}))
+	defer server.Close()
+
+	subs := &memorySubscriptions{webhooks: []models.Webhook{
+		{WebhookID: "WH1", URL: server.URL, Secret: "s3cret", EventTypes: []string{"order.completed"}},
+		{WebhookID: "WH2", URL: server.URL, Secret: "other", EventTypes: []string{"order.cancelled"}},
+	}}
+	d := NewDispatcher(subs, server.Client(), 10)
+	d.Backoff = 0
+
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).WithStatus(models.OrderStatusCompleted).Build()
+	runUntil(t, d, func() bool { return len(subs.recorded("")) == 2 },
+		Event{ID: "EVT1", Type: OrderEventType(order.Status), Data: order})
+
+	// Test only the subscribed webhook was called, and retried once
+	if calls != 2 {
+		t.Fatalf("Got %d calls, want 2", calls)
+	}
+	if len(subs.deliveries) != 2 || subs.deliveries[0].Succeeded || !subs.deliveries[1].Succeeded {
+		t.Fatalf("Unexpected deliveries %+v", subs.deliveries)
+	}
+	if subs.deliveries[0].StatusCode != http.StatusServiceUnavailable || subs.deliveries[1].Attempt != 2 {
+		t.Errorf("Unexpected deliveries %+v", subs.deliveries)
+	}
+
+	// Test the payload is signed with the webhook's secret
+	if want := Sign("s3cret", gotBody); gotSignature != want {
+		t.Errorf("Signature = %q, want %q", gotSignature, want)
+	}
+	var event Event
+	if err := json.Unmarshal(gotBody, &event); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	if event.ID != "EVT1" || event.Type != "order.completed" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	subs := &memorySubscriptions{webhooks: []models.Webhook{
+		{WebhookID: "WH1", URL: server.URL, Secret: "s3cret", EventTypes: []string{"*"}},
+	}}
+	d := NewDispatcher(subs, server.Client(), 10)
+	d.Backoff = 0
+	d.MaxAttempts = 3
+
+	runUntil(t, d, func() bool { return len(subs.recorded("")) == 3 }, Event{ID: "EVT1", Type: "order.pending"})
+
+	if len(subs.deliveries) != 3 {
+		t.Fatalf("Got %d delivery attempts, want 3", len(subs.deliveries))
+	}
+	for _, delivery := range subs.deliveries {
+		if delivery.Succeeded {
+			t.Errorf("Expected every attempt to fail, got %+v", delivery)
+		}
+	}
+}
+
+func TestDispatcher_FailingWebhookDoesntHoldOthers(t *testing.T) {
+	release := make(chan struct{})
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	defer close(release)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+
+	subs := &memorySubscriptions{webhooks: []models.Webhook{
+		{WebhookID: "DOWN", URL: down.URL, Secret: "s3cret", EventTypes: []string{"*"}},
+		{WebhookID: "UP", URL: up.URL, Secret: "s3cret", EventTypes: []string{"*"}},
+	}}
+	d := NewDispatcher(subs, nil, 1)
+
+	// Test every event reaches the healthy webhook while the other hangs,
+	// even once the hanging webhook's queue is full
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(stopped)
+	}()
+	for i, id := range []string{"EVT1", "EVT2", "EVT3"} {
+		d.Enqueue(Event{ID: id, Type: "order.pending"})
+		deadline := time.Now().Add(5 * time.Second)
+		for len(subs.recorded("UP")) <= i && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := subs.recorded("UP"); len(got) != i+1 || got[i].EventID != id {
+			t.Fatalf("Healthy webhook got %+v, want %s delivered", got, id)
+		}
+	}
+	if len(subs.recorded("DOWN")) != 0 {
+		t.Errorf("Hanging webhook recorded %+v, want its first attempt still open", subs.recorded("DOWN"))
+	}
+	cancel()
+	<-stopped
+}
+
+func TestDispatcher_Hook(t *testing.T) {
+	d := NewDispatcher(&memorySubscriptions{}, nil, 10)
+	hook := d.Hook()
+
+	order := fixtures.NewOrderFor(fixtures.NewUser().Build()).WithStatus(models.OrderStatusCompleted).Build()
+	av, err := attributevalue.MarshalMap(repository.GenericItem[models.Order]{
+		PK: repository.Key.UserPK(order.UserEmail), SK: repository.Key.OrderSK(order.OrderID),
+		EntityType: repository.EntityOrder, Data: order,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only order puts that went through send events
+	hook(context.Background(), repository.Change{Operation: repository.OperationPut, Phase: repository.BeforeWrite, EntityType: repository.EntityOrder, Item: av})
+	hook(context.Background(), repository.Change{Operation: repository.OperationPut, Phase: repository.AfterWrite, EntityType: repository.EntityProduct, Item: av})
+	hook(context.Background(), repository.Change{Operation: repository.OperationPut, Phase: repository.AfterWrite, EntityType: repository.EntityOrder, Item: av})
+
+	if len(d.pending) != 1 {
+		t.Fatalf("Queued %d events, want 1", len(d.pending))
+	}
+	if event := <-d.pending; event.Type != "order.completed" {
+		t.Errorf("Event type = %q, want order.completed", event.Type)
+	}
+}